@@ -0,0 +1,113 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AuditLogMaxSize is the size in bytes beyond which the audit log is rotated:
+// the log is renamed to <log>.1, replacing the previous rotated log, before
+// the next entry is written.
+const AuditLogMaxSize = 1 << 20
+
+// AuditEntry is an entry of the audit log, which records a destructive
+// operation performed by jiri.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Command is the command line of the jiri invocation that performed
+	// the operation.
+	Command string `json:"command"`
+	// Action names the operation, such as "delete project".
+	Action string `json:"action"`
+	// Details describes what the operation changed.
+	Details string `json:"details"`
+}
+
+// AuditLogFile returns the path to the audit log.
+func (x *X) AuditLogFile() string {
+	return filepath.Join(x.RootMetaDir(), "audit.log")
+}
+
+// Audit appends an entry for the given action, with details given by the
+// printf-style format and args, to the audit log.  Failures to write the log
+// never fail the operation being audited: they are only reported in verbose
+// mode.
+func (x *X) Audit(action, format string, args ...interface{}) {
+	entry := AuditEntry{
+		Time:    time.Now(),
+		Command: strings.Join(os.Args, " "),
+		Action:  action,
+		Details: fmt.Sprintf(format, args...),
+	}
+	if err := x.appendAuditEntry(entry); err != nil && x.Verbose() {
+		fmt.Fprintf(x.Stderr(), "WARNING: failed to write the audit log: %v\n", err)
+	}
+}
+
+func (x *X) appendAuditEntry(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file := x.AuditLogFile()
+	if fi, err := os.Stat(file); err == nil && fi.Size()+int64(len(data)) >= AuditLogMaxSize {
+		if err := os.Rename(file, file+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AuditLog returns the last n entries of the audit log, including the rotated
+// log, oldest first, or all of them if n is not positive.  Lines that cannot
+// be parsed are skipped.
+func (x *X) AuditLog(n int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	for _, file := range []string{x.AuditLogFile() + ".1", x.AuditLogFile()} {
+		f, err := os.Open(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			var entry AuditEntry
+			if json.Unmarshal(line, &entry) == nil {
+				entries = append(entries, entry)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		f.Close()
+	}
+	if n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"v.io/jiri/tool"
+)
+
+// TestAuditLog checks that audit entries are appended to the audit log, that
+// the log is rotated once it is too large, and that the most recent entries
+// are read back from both logs.
+func TestAuditLog(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	x := &X{Context: tool.NewDefaultContext(), Root: root}
+
+	// Failures to write the log are ignored.
+	x.Audit("delete project", "ignored")
+	if entries, err := x.AuditLog(0); err != nil || len(entries) != 0 {
+		t.Fatalf("got entries %v and error %v, want none", entries, err)
+	}
+
+	if err := os.MkdirAll(x.RootMetaDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		x.Audit("delete project", "deleted project %q", name)
+	}
+	entries, err := x.AuditLog(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(entries), 2; got != want {
+		t.Fatalf("got %d entries, want %d: %v", got, want, entries)
+	}
+	for i, want := range []string{`deleted project "b"`, `deleted project "c"`} {
+		if entries[i].Action != "delete project" || entries[i].Details != want || entries[i].Command == "" || entries[i].Time.IsZero() {
+			t.Errorf("got entry %+v, want details %q", entries[i], want)
+		}
+	}
+
+	// Fill the log up to its maximum size, so that the next entry rotates it.
+	f, err := os.OpenFile(x.AuditLogFile(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(strings.Repeat("x", AuditLogMaxSize) + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	x.Audit("move project", "moved project %q", "d")
+	if _, err := os.Stat(filepath.Join(x.RootMetaDir(), "audit.log.1")); err != nil {
+		t.Fatalf("expected the audit log to be rotated: %v", err)
+	}
+	if entries, err = x.AuditLog(0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(entries), 4; got != want {
+		t.Fatalf("got %d entries, want %d: %v", got, want, entries)
+	}
+	if got, want := entries[3].Details, `moved project "d"`; got != want {
+		t.Errorf("got details %q, want %q", got, want)
+	}
+}
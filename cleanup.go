@@ -0,0 +1,257 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"v.io/jiri/runutil"
+)
+
+// cleanups is a registry of functions that restore state which would
+// otherwise be left behind if jiri is interrupted, such as temporary
+// directories and stashed changes.  It is shared by an X and its clones.
+type cleanups struct {
+	mu      sync.Mutex
+	next    int
+	entries map[int]*cleanup
+	// signals runs the cleanups if the command is interrupted, or is nil if
+	// the command handles signals itself.
+	signals *signalHandler
+}
+
+type cleanup struct {
+	once sync.Once
+	fn   func() error
+	err  error
+}
+
+func (c *cleanup) run() error {
+	c.once.Do(func() { c.err = c.fn() })
+	return c.err
+}
+
+// registry returns the cleanup registry of x, creating it if necessary.  It is
+// safe to call concurrently, so that goroutines sharing x register their
+// cleanups in the same registry.
+func (x *X) registry() *cleanups {
+	x.cleanupsOnce.Do(func() {
+		if x.cleanups == nil {
+			x.cleanups = &cleanups{entries: map[int]*cleanup{}}
+		}
+	})
+	return x.cleanups
+}
+
+// AddCleanup registers fn to be run by RunCleanups, which is called when jiri
+// is interrupted by a signal.  It returns a function that runs fn and
+// unregisters it, which should be called, typically deferred, once the state
+// that fn restores is no longer needed.  The function fn is run at most once,
+// by whichever of the two is called first.
+func (x *X) AddCleanup(fn func() error) func() error {
+	r := x.registry()
+	c := &cleanup{fn: fn}
+	r.mu.Lock()
+	id := r.next
+	r.next++
+	r.entries[id] = c
+	signals := r.signals
+	r.mu.Unlock()
+	signals.listen(true)
+	return func() error {
+		r.mu.Lock()
+		delete(r.entries, id)
+		n := len(r.entries)
+		r.mu.Unlock()
+		err := c.run()
+		signals.listen(n > 0)
+		return err
+	}
+}
+
+// RunCleanups runs the registered cleanup functions in the reverse order of
+// their registration, and unregisters them.  It returns an error describing
+// all of the cleanup functions that failed.
+func (x *X) RunCleanups() error {
+	r := x.registry()
+	r.mu.Lock()
+	var ids []int
+	for id := range r.entries {
+		ids = append(ids, id)
+	}
+	entries := r.entries
+	r.entries = map[int]*cleanup{}
+	r.mu.Unlock()
+
+	// Later registrations are nested within earlier ones, so they are undone
+	// first, in the same way that deferred functions are run.
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	var errs []error
+	for _, id := range ids {
+		if err := entries[id].run(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cleanup failed: %v", errs)
+	}
+	return nil
+}
+
+// exit is os.Exit, replaced in tests.
+var exit = os.Exit
+
+// interruptGracePeriod is how long the signal handler waits for an
+// interrupted command to return before running the cleanups itself.
+var interruptGracePeriod = 10 * time.Second
+
+// signalHandler runs the cleanups of a command when jiri is interrupted by
+// SIGINT or SIGTERM, and exits with the conventional 128+signal status code.
+// It only listens for the signals while cleanups are registered, so that the
+// signals keep their default effect otherwise.
+//
+// The handler interrupts the commands being run by sequences and waits for the
+// command to return, so that the cleanups run on the goroutine of the command
+// rather than concurrently with it.  Only if the command does not return
+// within interruptGracePeriod, or if jiri is interrupted again, are the
+// cleanups run by the handler itself.
+type signalHandler struct {
+	x       *X
+	grace   time.Duration
+	sigchan chan os.Signal
+	// interrupted is closed once the running commands were interrupted.
+	interrupted chan struct{}
+	// returned is closed once the command returned.
+	returned chan struct{}
+
+	mu        sync.Mutex
+	listening bool
+	done      bool
+	received  os.Signal
+}
+
+// handleSignals installs a signal handler that runs the cleanups of x if jiri
+// is interrupted while the command that x was created for runs.  The finish
+// method of the handler must be called once the command returns.
+func (x *X) handleSignals() *signalHandler {
+	r := x.registry()
+	h := &signalHandler{
+		x:           x,
+		grace:       interruptGracePeriod,
+		sigchan:     make(chan os.Signal, 1),
+		interrupted: make(chan struct{}),
+		returned:    make(chan struct{}),
+	}
+	r.mu.Lock()
+	r.signals = h
+	n := len(r.entries)
+	r.mu.Unlock()
+	h.listen(n > 0)
+	go func() {
+		select {
+		case sig := <-h.sigchan:
+			h.interrupt(sig)
+		case <-h.returned:
+		}
+	}()
+	return h
+}
+
+// HandleSignalsItself declares that the command run with x handles SIGINT
+// and SIGTERM itself, e.g. to cancel the commands it runs gracefully and
+// report their outcome.  The cleanups registered with AddCleanup are then not
+// run when jiri is interrupted, and the command must make sure that it
+// returns, so that the cleanups that are done on return are run.
+func (x *X) HandleSignalsItself() {
+	r := x.registry()
+	r.mu.Lock()
+	h := r.signals
+	r.signals = nil
+	r.mu.Unlock()
+	h.stop()
+}
+
+// listen starts or stops listening for the signals, unless a signal was
+// already received or the command returned.  It is a no-op on a nil handler.
+func (h *signalHandler) listen(on bool) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done || h.received != nil || on == h.listening {
+		return
+	}
+	if on {
+		signal.Notify(h.sigchan, syscall.SIGINT, syscall.SIGTERM)
+	} else {
+		signal.Stop(h.sigchan)
+	}
+	h.listening = on
+}
+
+// stop stops listening for the signals, and returns the signal that was
+// received, if any.  It is a no-op on a nil handler.
+func (h *signalHandler) stop() os.Signal {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.done {
+		h.done = true
+		if h.listening && h.received == nil {
+			signal.Stop(h.sigchan)
+		}
+		close(h.returned)
+	}
+	return h.received
+}
+
+// interrupt handles the given signal.
+func (h *signalHandler) interrupt(sig os.Signal) {
+	h.mu.Lock()
+	h.received = sig
+	h.mu.Unlock()
+	fmt.Fprintf(h.x.Stderr(), "Received %v, cleaning up.\n", sig)
+	runutil.Interrupt(sig)
+	close(h.interrupted)
+	select {
+	case <-h.returned:
+		// The command returned, and finish runs the cleanups.
+		return
+	case <-h.sigchan:
+	case <-time.After(h.grace):
+	}
+	h.cleanupAndExit(sig)
+}
+
+// finish is called once the command returns.  If jiri was interrupted, it
+// runs the cleanups and exits.
+func (h *signalHandler) finish() {
+	if sig := h.stop(); sig != nil {
+		<-h.interrupted
+		h.cleanupAndExit(sig)
+	}
+}
+
+// cleanupAndExit runs the cleanups and exits with the status code for the
+// given signal.
+func (h *signalHandler) cleanupAndExit(sig os.Signal) {
+	if err := h.x.RunCleanups(); err != nil {
+		fmt.Fprintf(h.x.Stderr(), "%v\n", err)
+	}
+	code := 1
+	if s, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(s)
+	}
+	exit(code)
+}
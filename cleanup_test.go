@@ -0,0 +1,143 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"v.io/jiri/tool"
+)
+
+func TestCleanups(t *testing.T) {
+	x := &X{Context: tool.NewDefaultContext()}
+	var ran []int
+	add := func(x *X, i int) func() error {
+		return x.AddCleanup(func() error {
+			ran = append(ran, i)
+			if i == 2 {
+				return fmt.Errorf("cleanup %d failed", i)
+			}
+			return nil
+		})
+	}
+	// Clones share the cleanups of the original.
+	clone := x.Clone(tool.ContextOpts{})
+	done1, done2 := add(x, 1), add(clone, 2)
+	add(x, 3)
+	done4 := add(clone, 4)
+
+	// Cleanups that are done are run once, and unregistered.
+	if err := done4(); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.RunCleanups(); err == nil {
+		t.Errorf("expected an error")
+	}
+	if got, want := ran, []int{4, 3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Cleanups run by RunCleanups are not run again when they are done.
+	if err := done1(); err != nil {
+		t.Error(err)
+	}
+	if err := done2(); err == nil {
+		t.Errorf("expected the error of the first run")
+	}
+	if err := x.RunCleanups(); err != nil {
+		t.Error(err)
+	}
+	if got, want := ran, []int{4, 3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestHandleSignals(t *testing.T) {
+	codes := make(chan int, 1)
+	exit = func(code int) { codes <- code }
+	defer func() { exit = os.Exit }()
+	grace := interruptGracePeriod
+	defer func() { interruptGracePeriod = grace }()
+
+	checkExit := func(ran *bool) {
+		select {
+		case code := <-codes:
+			if got, want := code, 128+int(syscall.SIGINT); got != want {
+				t.Errorf("got exit code %v, want %v", got, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for exit")
+		}
+		if !*ran {
+			t.Errorf("cleanup was not run")
+		}
+	}
+
+	// The cleanups are run once the interrupted command returns.
+	x := &X{Context: tool.NewDefaultContext()}
+	ran := false
+	x.AddCleanup(func() error {
+		ran = true
+		return nil
+	})
+	h := x.handleSignals()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	<-h.interrupted
+	if ran {
+		t.Errorf("cleanup was run before the command returned")
+	}
+	h.finish()
+	checkExit(&ran)
+
+	// The cleanups are run by the handler if the command does not return.
+	interruptGracePeriod = 10 * time.Millisecond
+	x = &X{Context: tool.NewDefaultContext()}
+	ran = false
+	h = x.handleSignals()
+	x.AddCleanup(func() error {
+		ran = true
+		return nil
+	})
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	checkExit(&ran)
+	h.finish()
+}
+
+func TestHandleSignalsItself(t *testing.T) {
+	codes := make(chan int, 1)
+	exit = func(code int) { codes <- code }
+	defer func() { exit = os.Exit }()
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT)
+	defer signal.Stop(sigchan)
+
+	x := &X{Context: tool.NewDefaultContext()}
+	h := x.handleSignals()
+	defer h.finish()
+	x.Clone(tool.ContextOpts{}).HandleSignalsItself()
+	x.AddCleanup(func() error { return nil })
+	if h.listening {
+		t.Errorf("handler listens for signals")
+	}
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	<-sigchan
+	select {
+	case code := <-codes:
+		t.Errorf("got exit code %v, want no exit", code)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
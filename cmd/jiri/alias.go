@@ -0,0 +1,284 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/x/lib/cmdline"
+)
+
+// aliasPrefix is the prefix of the config keys that define command aliases.
+const aliasPrefix = "alias."
+
+// maxSuggestions is the largest number of names suggested for an unknown
+// command or project.
+const maxSuggestions = 3
+
+// aliases returns the command aliases defined in the given config, keyed by
+// alias name.
+func aliases(config jiri.Config) map[string]string {
+	result := map[string]string{}
+	for key, value := range config {
+		if strings.HasPrefix(key, aliasPrefix) {
+			result[strings.TrimPrefix(key, aliasPrefix)] = value
+		}
+	}
+	return result
+}
+
+// checkAlias checks that the given alias can be defined in the command tree
+// rooted at root.
+func checkAlias(root *cmdline.Command, name, expansion string) error {
+	if name == "" || strings.ContainsAny(name, " \t.=") || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid alias name %q", name)
+	}
+	if isCommand(root, name) {
+		return fmt.Errorf("alias %q would shadow the %q command", name, name)
+	}
+	if len(strings.Fields(expansion)) == 0 {
+		return fmt.Errorf("alias %q has an empty expansion", name)
+	}
+	return nil
+}
+
+// isCommand returns true if name is a child command of root, or the built-in
+// help command.
+func isCommand(root *cmdline.Command, name string) bool {
+	if name == "help" {
+		return true
+	}
+	for _, child := range root.Children {
+		if child.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// commandIndex returns the index in args of the name of the command run by
+// the command tree rooted at root, skipping the global flags that precede it,
+// or -1 if args do not name a command.
+func commandIndex(root *cmdline.Command, args []string) int {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			if i+1 < len(args) {
+				return i + 1
+			}
+			return -1
+		case !strings.HasPrefix(arg, "-") || arg == "-":
+			return i
+		case strings.Contains(arg, "="):
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		f := root.Flags.Lookup(name)
+		if f == nil {
+			f = flag.CommandLine.Lookup(name)
+		}
+		if f == nil {
+			continue
+		}
+		if b, ok := f.Value.(interface {
+			IsBoolFlag() bool
+		}); !ok || !b.IsBoolFlag() {
+			// The value of the flag is the next argument.
+			i++
+		}
+	}
+	return -1
+}
+
+// isVerbose returns true if the given arguments set the global -v flag.
+func isVerbose(args []string) bool {
+	verbose := false
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		switch strings.TrimLeft(arg, "-") {
+		case "v", "v=true", "v=1":
+			verbose = true
+		case "v=false", "v=0":
+			verbose = false
+		}
+	}
+	return verbose
+}
+
+// expandAliases replaces the command named in args, if it is an alias defined
+// in the given config, with the expansion of the alias.  An expansion that
+// starts with another alias is expanded in turn.  Aliases that would shadow
+// a command are ignored.  If the expanded arguments set the -v flag, the
+// expansion is logged to w.
+func expandAliases(root *cmdline.Command, config jiri.Config, args []string, w io.Writer) ([]string, error) {
+	defined := aliases(config)
+	seen := map[string]bool{}
+	var expanded []string
+	for {
+		i := commandIndex(root, args)
+		if i == -1 {
+			break
+		}
+		name := args[i]
+		expansion, ok := defined[name]
+		if !ok || isCommand(root, name) {
+			break
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("alias %q is recursive", name)
+		}
+		seen[name] = true
+		fields := strings.Fields(expansion)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("alias %q has an empty expansion", name)
+		}
+		newArgs := append([]string{}, args[:i]...)
+		newArgs = append(newArgs, fields...)
+		args = append(newArgs, args[i+1:]...)
+		expanded = append(expanded, fmt.Sprintf("%q to %q", name, expansion))
+	}
+	if len(expanded) > 0 && isVerbose(args) {
+		for _, e := range expanded {
+			fmt.Fprintf(w, "Expanded alias %s\n", e)
+		}
+		fmt.Fprintf(w, "Running: jiri %s\n", strings.Join(args, " "))
+	}
+	return args, nil
+}
+
+// editDistance returns the number of single character insertions, deletions,
+// substitutions and transpositions of adjacent characters needed to turn a
+// into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// suggestion is a name along with its edit distance from an unknown name.
+type suggestion struct {
+	name     string
+	distance int
+}
+
+// suggestions sorts suggestions by distance, and then by name.
+type suggestions []suggestion
+
+func (s suggestions) Len() int      { return len(s) }
+func (s suggestions) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s suggestions) Less(i, j int) bool {
+	if s[i].distance != s[j].distance {
+		return s[i].distance < s[j].distance
+	}
+	return s[i].name < s[j].name
+}
+
+// suggestCommands returns the names of the commands and aliases closest to
+// the given unknown command name, best first.
+func suggestCommands(root *cmdline.Command, config jiri.Config, name string) []string {
+	names := []string{"help"}
+	for _, child := range root.Children {
+		names = append(names, child.Name)
+	}
+	for alias := range aliases(config) {
+		names = append(names, alias)
+	}
+	return closestNames(names, name)
+}
+
+// closestNames returns up to maxSuggestions of the given names that are
+// closest to the given unknown name, best first.  Names are suggested if they
+// are within an edit distance of a third of their length, and at least 1, or
+// if they start with the unknown name.
+func closestNames(names []string, name string) []string {
+	seen := map[string]bool{}
+	var candidates suggestions
+	for _, n := range names {
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		distance := editDistance(name, n)
+		limit := len(n) / 3
+		if limit < 1 {
+			limit = 1
+		}
+		if distance <= limit || strings.HasPrefix(n, name) {
+			candidates = append(candidates, suggestion{n, distance})
+		}
+	}
+	sort.Sort(candidates)
+	result := []string{}
+	for _, c := range candidates {
+		if len(result) == maxSuggestions {
+			break
+		}
+		result = append(result, c.name)
+	}
+	return result
+}
+
+// printSuggestions prints the commands closest to the command named in args,
+// if it is not a command, alias or external subcommand, before the command
+// line is parsed and the usage error is reported.
+func printSuggestions(root *cmdline.Command, config jiri.Config, args []string, w io.Writer) {
+	i := commandIndex(root, args)
+	if i == -1 {
+		return
+	}
+	name := args[i]
+	if isCommand(root, name) {
+		return
+	}
+	if _, ok := aliases(config)[name]; ok {
+		return
+	}
+	if _, err := exec.LookPath(root.Name + "-" + name); err == nil {
+		return
+	}
+	if s := suggestCommands(root, config, name); len(s) > 0 {
+		fmt.Fprintf(w, "jiri: unknown command %q, did you mean: %s?\n", name, strings.Join(s, ", "))
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"v.io/jiri"
+)
+
+func TestExpandAliases(t *testing.T) {
+	config := jiri.Config{
+		"alias.up":     "update -gc",
+		"alias.u":      "up -attempts=3",
+		"alias.loop1":  "loop2",
+		"alias.loop2":  "loop1 -v",
+		"alias.status": "update",
+		"gc":           "true",
+	}
+	tests := []struct {
+		args, want []string
+		err        string
+	}{
+		{[]string{"up"}, []string{"update", "-gc"}, ""},
+		{[]string{"up", "-summary-only"}, []string{"update", "-gc", "-summary-only"}, ""},
+		{[]string{"-color=false", "up"}, []string{"-color=false", "update", "-gc"}, ""},
+		{[]string{"-color", "up"}, []string{"-color", "update", "-gc"}, ""},
+		{[]string{"u"}, []string{"update", "-gc", "-attempts=3"}, ""},
+		// Aliases cannot shadow commands, and only the command is expanded.
+		{[]string{"status"}, []string{"status"}, ""},
+		{[]string{"snapshot", "up"}, []string{"snapshot", "up"}, ""},
+		{[]string{"loop1"}, nil, `alias "loop1" is recursive`},
+	}
+	for _, test := range tests {
+		var log bytes.Buffer
+		got, err := expandAliases(cmdRoot, config, test.args, &log)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%v: got error %v, want %q", test.args, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.args, got, test.want)
+		}
+		if log.Len() != 0 {
+			t.Errorf("%v: got log %q, want none", test.args, log.String())
+		}
+	}
+
+	// The expansion is logged with -v.
+	var log bytes.Buffer
+	if _, err := expandAliases(cmdRoot, config, []string{"-v", "u"}, &log); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`Expanded alias "u" to "up -attempts=3"`, `Expanded alias "up" to "update -gc"`, "Running: jiri -v update -gc -attempts=3\n"} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("got log %q, want it to contain %q", log.String(), want)
+		}
+	}
+}
+
+func TestSuggestCommands(t *testing.T) {
+	config := jiri.Config{"alias.sync": "update"}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"updaet", []string{"update"}},
+		{"udpate", []string{"update"}},
+		{"snapshto", []string{"snapshot"}},
+		{"synk", []string{"sync"}},
+		{"upd", []string{"update", "update-history"}},
+		{"xyzzy", []string{}},
+	}
+	for _, test := range tests {
+		if got := suggestCommands(cmdRoot, config, test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.name, got, test.want)
+		}
+	}
+	var out bytes.Buffer
+	printSuggestions(cmdRoot, config, []string{"-v", "updaet"}, &out)
+	if got, want := out.String(), "jiri: unknown command \"updaet\", did you mean: update?\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	out.Reset()
+	printSuggestions(cmdRoot, config, []string{"update"}, &out)
+	if out.Len() != 0 {
+		t.Errorf("got %q, want no suggestions for a known command", out.String())
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"update", "update", 0},
+		{"updaet", "update", 1},
+		{"updte", "update", 1},
+		{"uppdate", "update", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"v.io/jiri"
+	"v.io/x/lib/cmdline"
+)
+
+var auditNumFlag int
+
+func init() {
+	cmdAudit.Flags.IntVar(&auditNumFlag, "n", 20, "Number of the most recent entries to print.  Zero means all entries.")
+}
+
+// cmdAudit represents the "jiri audit" command.
+var cmdAudit = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runAudit),
+	Name:   "audit",
+	Short:  "Show the destructive operations performed by jiri",
+	Long: `
+Show the most recent entries of the audit log in
+$JIRI_ROOT/.jiri_root/audit.log, oldest first.  The log records the
+destructive operations performed by jiri, with the command line that performed
+them:
+
+  - projects deleted by "jiri update -gc"
+  - projects moved by "jiri update", including the checkouts moved aside by
+    "jiri update -force-remote-change"
+  - branches deleted by "jiri project clean -branches" and "jiri cl cleanup"
+  - uncommitted changes and untracked files discarded by "jiri project clean"
+  - backups of projects created, restored and pruned by "jiri project clean"
+  - tools replaced by "jiri update" and "jiri rebuild"
+
+The log is rotated to audit.log.1 when it grows beyond 1MB.
+`,
+}
+
+func runAudit(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	if auditNumFlag < 0 {
+		return jirix.UsageErrorf("-n must not be negative")
+	}
+	entries, err := jirix.AuditLog(auditNumFlag)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(jirix.Stdout(), "%s %s: %s\n  (%s)\n", entry.Time.Format(time.RFC3339), entry.Action, entry.Details, entry.Command)
+	}
+	return nil
+}
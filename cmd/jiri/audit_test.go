@@ -0,0 +1,45 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/jiri/tool"
+)
+
+// TestAudit checks that "jiri audit" prints the most recent entries of the
+// audit log.
+func TestAudit(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	defer func() { auditNumFlag = 20 }()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+
+	if err := runAudit(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "" {
+		t.Errorf("got %q, want no output", got)
+	}
+
+	fake.X.Audit("delete branch", "deleted branch %q", "old")
+	fake.X.Audit("delete branch", "deleted branch %q", "new")
+	auditNumFlag = 1
+	if err := runAudit(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := stdout.String()
+	if want := `delete branch: deleted branch "new"`; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+	if unwanted := `"old"`; strings.Contains(got, unwanted) {
+		t.Errorf("got %q, want it not to contain %q", got, unwanted)
+	}
+}
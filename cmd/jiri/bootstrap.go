@@ -0,0 +1,173 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"v.io/jiri"
+	"v.io/jiri/project"
+	"v.io/x/lib/cmdline"
+)
+
+var (
+	bootstrapForceFlag      bool
+	bootstrapShimScriptFlag string
+)
+
+func init() {
+	cmdBootstrap.Flags.BoolVar(&bootstrapForceFlag, "force", false, "Bootstrap into the directory even if it is not empty.")
+	cmdBootstrap.Flags.StringVar(&bootstrapShimScriptFlag, "shim-script", "", `Shim script to install as .jiri_root/scripts/jiri, e.g. the "jiri" script of the jiri project, which sets $JIRI_ROOT and runs .jiri_root/bin/jiri.`)
+}
+
+// cmdBootstrap represents the "jiri bootstrap" command.
+var cmdBootstrap = &cmdline.Command{
+	Runner: jiri.RootRunnerFunc(bootstrapRoot, runBootstrap),
+	Name:   "bootstrap",
+	Short:  "Create a new jiri root",
+	Long: `
+Command "bootstrap" creates a new jiri root in the given directory, which is
+created if it doesn't exist.  A copy of the running jiri binary is installed
+into <dir>/.jiri_root/bin, a .jiri_manifest file that imports the given
+manifest is written, as by "jiri import", and the projects and tools are
+updated, as by "jiri update".  $JIRI_ROOT need not be set.
+
+The directory must be empty, unless -force is set.  If bootstrapping fails or
+is interrupted, the files and directories it created are removed, so that it
+can be run again.
+
+Example:
+  $ jiri bootstrap $HOME/src default https://vanadium.googlesource.com/manifest
+`,
+	ArgsName: "<dir> <manifest> <remote>",
+	ArgsLong: `
+<dir> is the root directory to create.
+
+<manifest> specifies the manifest file to import.
+
+<remote> specifies the remote manifest repository.
+`,
+}
+
+// bootstrapRoot returns the absolute path of the directory to bootstrap,
+// which is the root of the X passed to runBootstrap.
+func bootstrapRoot(env *cmdline.Env, args []string) (string, error) {
+	if len(args) != 3 {
+		return "", env.UsageErrorf("wrong number of arguments")
+	}
+	return filepath.Abs(args[0])
+}
+
+func runBootstrap(jirix *jiri.X, args []string) (e error) {
+	if len(args) != 3 {
+		return jirix.UsageErrorf("wrong number of arguments")
+	}
+	root := jirix.Root
+	// Record the existing entries of the directory, which are left alone if
+	// bootstrapping fails.
+	var existing map[string]bool
+	created := false
+	fileInfos, err := ioutil.ReadDir(root)
+	switch {
+	case os.IsNotExist(err):
+		created = true
+	case err != nil:
+		return err
+	case len(fileInfos) > 0 && !bootstrapForceFlag:
+		return fmt.Errorf("directory %q is not empty; use -force to bootstrap into it anyway", root)
+	default:
+		existing = map[string]bool{}
+		for _, fileInfo := range fileInfos {
+			existing[fileInfo.Name()] = true
+		}
+	}
+	success := false
+	cleanup := jirix.AddCleanup(func() error {
+		if success {
+			return nil
+		}
+		return removeBootstrapped(jirix, root, created, existing)
+	})
+	defer func() {
+		if err := cleanup(); err != nil && e == nil {
+			e = err
+		}
+	}()
+
+	if err := jirix.NewSeq().MkdirAll(jirix.BinDir(), 0755).Done(); err != nil {
+		return err
+	}
+	if err := installRunningJiri(jirix); err != nil {
+		return err
+	}
+	if bootstrapShimScriptFlag != "" {
+		if err := copyFile(jirix, bootstrapShimScriptFlag, filepath.Join(jirix.ScriptsDir(), "jiri"), 0755); err != nil {
+			return err
+		}
+	}
+	if err := addImport(jirix, project.Import{
+		Manifest:     args[1],
+		Name:         "manifest",
+		Protocol:     "git",
+		Remote:       args[2],
+		RemoteBranch: "master",
+	}, false, ""); err != nil {
+		return err
+	}
+	if err := runUpdate(jirix, nil); err != nil {
+		return err
+	}
+	success = true
+
+	binDir := jirix.BinDir()
+	if bootstrapShimScriptFlag != "" {
+		binDir = jirix.ScriptsDir()
+	}
+	fmt.Fprintf(jirix.Stdout(), "Bootstrapped %s; add %s to your PATH to use it.\n", root, binDir)
+	return nil
+}
+
+// installRunningJiri copies the running jiri binary to the bin directory of
+// the jiri root.
+func installRunningJiri(jirix *jiri.X) error {
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot find the running jiri binary: %v", err)
+	}
+	return copyFile(jirix, binary, filepath.Join(jirix.BinDir(), "jiri"), 0755)
+}
+
+// copyFile copies the file src to dst, creating the directory of dst if
+// necessary.
+func copyFile(jirix *jiri.X, src, dst string, perm os.FileMode) error {
+	data, err := jirix.NewSeq().ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return jirix.NewSeq().MkdirAll(filepath.Dir(dst), 0755).WriteFile(dst, data, perm).Done()
+}
+
+// removeBootstrapped removes what a failed bootstrap created in root: the
+// root itself if it was created, and otherwise the entries of root that are
+// not in existing.
+func removeBootstrapped(jirix *jiri.X, root string, created bool, existing map[string]bool) error {
+	s := jirix.NewSeq()
+	if created {
+		return s.RemoveAll(root).Done()
+	}
+	fileInfos, err := ioutil.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, fileInfo := range fileInfos {
+		if !existing[fileInfo.Name()] {
+			s.RemoveAll(filepath.Join(root, fileInfo.Name()))
+		}
+	}
+	return s.Done()
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/jiri/project"
+	"v.io/jiri/tool"
+)
+
+func TestBootstrap(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	if err := fake.CreateRemoteProject("p"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddProject(project.Project{
+		Name:   "p",
+		Path:   "p",
+		Remote: fake.Projects["p"],
+	}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := fake.ReadJiriManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imp := manifest.Imports[0]
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	shim := filepath.Join(tmp, "shim")
+	if err := ioutil.WriteFile(shim, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bootstrap := func(dir, remote string, force bool) error {
+		bootstrapForceFlag = force
+		defer func() { bootstrapForceFlag = false }()
+		var stdout bytes.Buffer
+		jirix := fake.X.Clone(tool.ContextOpts{Stdout: &stdout})
+		jirix.Root = dir
+		return runBootstrap(jirix, []string{dir, imp.Manifest, remote})
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	// Bootstrap a new directory.
+	bootstrapShimScriptFlag = shim
+	defer func() { bootstrapShimScriptFlag = "" }()
+	dir := filepath.Join(tmp, "root")
+	if err := bootstrap(dir, imp.Remote, false); err != nil {
+		t.Fatalf("bootstrap failed: %v", err)
+	}
+	for _, path := range []string{
+		filepath.Join(dir, ".jiri_root", "bin", "jiri"),
+		filepath.Join(dir, ".jiri_root", "scripts", "jiri"),
+		filepath.Join(dir, ".jiri_manifest"),
+		filepath.Join(dir, "manifest"),
+		filepath.Join(dir, "p", ".git"),
+	} {
+		if !exists(path) {
+			t.Errorf("%s does not exist", path)
+		}
+	}
+	bootstrapShimScriptFlag = ""
+
+	// A non-empty directory is refused without -force.
+	if err := bootstrap(dir, imp.Remote, false); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("got error %v, want a \"not empty\" error", err)
+	}
+	if !exists(filepath.Join(dir, "p")) {
+		t.Errorf("the existing root was modified")
+	}
+
+	// A failed bootstrap of a new directory removes the directory.
+	dir = filepath.Join(tmp, "failed")
+	if err := bootstrap(dir, filepath.Join(tmp, "nonexistent"), false); err == nil {
+		t.Fatalf("bootstrap with a nonexistent remote did not fail")
+	}
+	if exists(dir) {
+		t.Errorf("%s was not removed", dir)
+	}
+
+	// A failed bootstrap of an existing directory with -force removes only
+	// what it created.
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	keep := filepath.Join(dir, "keep")
+	if err := ioutil.WriteFile(keep, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bootstrap(dir, filepath.Join(tmp, "nonexistent"), true); err == nil {
+		t.Fatalf("bootstrap with a nonexistent remote did not fail")
+	}
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fileInfos) != 1 || fileInfos[0].Name() != "keep" {
+		var names []string
+		for _, fileInfo := range fileInfos {
+			names = append(names, fileInfo.Name())
+		}
+		t.Errorf("got entries %v, want [keep]", names)
+	}
+}
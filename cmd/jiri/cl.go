@@ -13,15 +13,18 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"v.io/jiri"
 	"v.io/jiri/collect"
+	"v.io/jiri/errkind"
 	"v.io/jiri/gerrit"
 	"v.io/jiri/gitutil"
 	"v.io/jiri/profiles/profilescmdline"
 	"v.io/jiri/project"
 	"v.io/jiri/runutil"
+	"v.io/jiri/tool"
 	"v.io/x/lib/cmdline"
 )
 
@@ -29,26 +32,40 @@ const (
 	commitMessageFileName     = ".gerrit_commit_message"
 	dependencyPathFileName    = ".dependency_path"
 	multiPartMetaDataFileName = "multipart_index"
+	topicFileName             = ".gerrit_topic"
 )
 
 var (
 	autosubmitFlag        bool
+	baseFlag              string
 	ccsFlag               string
 	draftFlag             bool
 	editFlag              bool
 	forceFlag             bool
+	forkFlag              string
+	hashtagsFlag          hashtagList
 	hostFlag              string
+	labelsFlag            labelVotes
 	messageFlag           string
 	commitMessageBodyFlag string
+	newTopicFlag          string
 	presubmitFlag         string
+	readyFlag             bool
 	remoteBranchFlag      string
+	repairFlag            bool
 	reviewersFlag         string
 	setTopicFlag          bool
 	topicFlag             string
 	uncommittedFlag       bool
 	verifyFlag            bool
+	wipFlag               bool
 	currentProjectFlag    bool
 	cleanupMultiPartFlag  bool
+	maxFileSizeFlag       = byteSize(5 << 20)
+	maxDiffSizeFlag       = byteSize(50 << 20)
+	forceLargeFlag        bool
+	noOwnersFlag          bool
+	yesFlag               bool
 )
 
 // Special labels stored in the commit message.
@@ -65,34 +82,46 @@ var (
 	// Presubmit test label.
 	// PresubmitTest: <type>
 	presubmitTestLabelRE *regexp.Regexp = regexp.MustCompile(`PresubmitTest:\s*(.*)`)
-
-	noChangesRE *regexp.Regexp = regexp.MustCompile(`! \[remote rejected\] HEAD -> refs/(for|drafts)/\S+ \(no new changes\)`)
 )
 
 // init carries out the package initialization.
 func init() {
 	cmdCLMail = newCmdCLMail()
 	cmdCL = newCmdCL()
+	cmdCLChain.Flags.StringVar(&remoteBranchFlag, "remote-branch", "master", `Name of the remote branch the CL pertains to, without the leading "origin/".`)
 	cmdCLCleanup.Flags.BoolVar(&forceFlag, "f", false, `Ignore unmerged changes.`)
 	cmdCLCleanup.Flags.StringVar(&remoteBranchFlag, "remote-branch", "master", `Name of the remote branch the CL pertains to, without the leading "origin/".`)
 	cmdCLMail.Flags.BoolVar(&autosubmitFlag, "autosubmit", false, `Automatically submit the changelist when feasible.`)
 	cmdCLMail.Flags.StringVar(&ccsFlag, "cc", "", `Comma-seperated list of emails or LDAPs to cc.`)
 	cmdCLMail.Flags.BoolVar(&draftFlag, "d", false, `Send a draft changelist.`)
 	cmdCLMail.Flags.BoolVar(&editFlag, "edit", true, `Open an editor to edit the CL description.`)
+	cmdCLMail.Flags.StringVar(&forkFlag, "fork", "", `Git url of the fork that the branch is pushed to when sending a pull request.  Defaults to a branch of origin named jiri/<username>/<branchname>.`)
+	cmdCLMail.Flags.Var(&hashtagsFlag, "hashtag", `Hashtag to add to the CL.  May be repeated.`)
 	cmdCLMail.Flags.StringVar(&hostFlag, "host", "", `Gerrit host to use.  Defaults to gerrit host specified in manifest.`)
+	cmdCLMail.Flags.Var(&labelsFlag, "label", `Vote to cast on the CL, such as "Commit-Queue=+1".  May be repeated.`)
 	cmdCLMail.Flags.StringVar(&messageFlag, "m", "", `CL description.`)
 	cmdCLMail.Flags.StringVar(&commitMessageBodyFlag, "commit-message-body-file", "", `file containing the body of the CL description, that is, text without a ChangeID, MultiPart etc.`)
 	cmdCLMail.Flags.StringVar(&presubmitFlag, "presubmit", string(gerrit.PresubmitTestTypeAll),
 		fmt.Sprintf("The type of presubmit tests to run. Valid values: %s.", strings.Join(gerrit.PresubmitTestTypes(), ",")))
+	cmdCLMail.Flags.BoolVar(&readyFlag, "ready", false, `Mark the CL as ready for review.`)
 	cmdCLMail.Flags.StringVar(&remoteBranchFlag, "remote-branch", "master", `Name of the remote branch the CL pertains to, without the leading "origin/".`)
 	cmdCLMail.Flags.StringVar(&reviewersFlag, "r", "", `Comma-seperated list of emails or LDAPs to request review.`)
 	cmdCLMail.Flags.BoolVar(&setTopicFlag, "set-topic", true, `Set Gerrit CL topic.`)
 	cmdCLMail.Flags.StringVar(&topicFlag, "topic", "", `CL topic, defaults to <username>-<branchname>.`)
 	cmdCLMail.Flags.BoolVar(&uncommittedFlag, "check-uncommitted", true, `Check that no uncommitted changes exist.`)
 	cmdCLMail.Flags.BoolVar(&verifyFlag, "verify", true, `Run pre-push git hooks.`)
+	cmdCLMail.Flags.BoolVar(&wipFlag, "wip", false, `Mark the CL as work in progress.`)
 	cmdCLMail.Flags.BoolVar(&currentProjectFlag, "current-project-only", false, `Run mail in the current project only.`)
+	cmdCLMail.Flags.Var(&maxFileSizeFlag, "max-file-size", `Largest size of a changed file, such as "512KB" or "5MB".`)
+	cmdCLMail.Flags.Var(&maxDiffSizeFlag, "max-diff-size", `Largest total size of the changed files.`)
+	cmdCLMail.Flags.BoolVar(&forceLargeFlag, "force-large", false, `Mail the changelist even if it has files larger than -max-file-size, new binary files, or a total size larger than -max-diff-size.`)
+	cmdCLMail.Flags.BoolVar(&noOwnersFlag, "no-owners", false, `Do not request review from the owners listed in the OWNERS files of the changed files when -r is not set.`)
+	cmdCLMail.Flags.BoolVar(&yesFlag, "yes", false, `Request review from the owners listed in the OWNERS files without asking for confirmation.`)
 	cmdCLMail.Flags.BoolVar(&cleanupMultiPartFlag, "clean-multipart-metadata", false, `Cleanup the metadata associated with multipart CLs pertaining the MultiPart: x/y message without mailing any CLs.`)
+	cmdCLNew.Flags.StringVar(&baseFlag, "base", "", `Branch or ref to create the new branch from, defaults to the current branch.  A base that is not a local branch, such as "origin/master", means the changelist does not depend on another local changelist.`)
+	cmdCLNew.Flags.StringVar(&newTopicFlag, "topic", "", `Gerrit topic to record for the changelist, used by "jiri cl mail" unless its -topic flag is set.`)
 	cmdCLSync.Flags.StringVar(&remoteBranchFlag, "remote-branch", "master", `Name of the remote branch the CL pertains to, without the leading "origin/".`)
+	cmdCLSync.Flags.BoolVar(&repairFlag, "repair", false, `Repair the dependency path of the current branch if it names branches that no longer exist, without asking for confirmation.`)
 }
 
 func getCommitMessageFileName(jirix *jiri.X, branch string) (string, error) {
@@ -111,6 +140,31 @@ func getDependencyPathFileName(jirix *jiri.X, branch string) (string, error) {
 	return filepath.Join(topLevel, jiri.ProjectMetaDir, branch, dependencyPathFileName), nil
 }
 
+func getTopicFileName(jirix *jiri.X, branch string) (string, error) {
+	topLevel, err := gitutil.New(jirix.NewSeq()).TopLevel()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(topLevel, jiri.ProjectMetaDir, branch, topicFileName), nil
+}
+
+// getTopic returns the Gerrit topic recorded for the given branch by
+// "jiri cl new -topic", or an empty string if no topic was recorded.
+func getTopic(jirix *jiri.X, branch string) (string, error) {
+	file, err := getTopicFileName(jirix, branch)
+	if err != nil {
+		return "", err
+	}
+	data, err := jirix.NewSeq().ReadFile(file)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
 func getDependentCLs(jirix *jiri.X, branch string) ([]string, error) {
 	file, err := getDependencyPathFileName(jirix, branch)
 	if err != nil {
@@ -131,6 +185,79 @@ func getDependentCLs(jirix *jiri.X, branch string) ([]string, error) {
 	return branches, nil
 }
 
+// writeDependentCLs records the given dependency path for the given branch.
+// The file is replaced atomically, so that an interrupted write never
+// leaves a partial dependency path behind.
+func writeDependentCLs(jirix *jiri.X, branch string, branches []string) error {
+	file, err := getDependencyPathFileName(jirix, branch)
+	if err != nil {
+		return err
+	}
+	tmpFile := file + ".tmp"
+	return jirix.NewSeq().
+		MkdirAll(filepath.Dir(file), os.FileMode(0755)).
+		WriteFile(tmpFile, []byte(strings.Join(branches, "\n")), os.FileMode(0644)).
+		Rename(tmpFile, file).Done()
+}
+
+// recordedParent returns the parent recorded in the dependency path of the
+// given branch, i.e. its last entry, or "" if no dependency path is
+// recorded for the branch.
+func recordedParent(jirix *jiri.X, branch string) (string, error) {
+	file, err := getDependencyPathFileName(jirix, branch)
+	if err != nil {
+		return "", err
+	}
+	data, err := jirix.NewSeq().ReadFile(file)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	branches := strings.Split(strings.TrimSpace(string(data)), "\n")
+	return branches[len(branches)-1], nil
+}
+
+// repairDependentCLs returns the given dependency path with the branches
+// that no longer exist spliced out, and the branches that were spliced out.
+// The dependents of a missing branch are re-pointed at the parent recorded
+// for the missing branch, if that parent still exists.  If no branch of the
+// path remains, the path starts from the remote branch instead.
+func repairDependentCLs(jirix *jiri.X, branches []string) ([]string, []string, error) {
+	git := gitutil.New(jirix.NewSeq())
+	var repaired, missing []string
+	add := func(branch string) {
+		for _, b := range repaired {
+			if b == branch {
+				return
+			}
+		}
+		repaired = append(repaired, branch)
+	}
+	for _, branch := range branches {
+		if git.BranchExists(branch) {
+			add(branch)
+			continue
+		}
+		missing = append(missing, branch)
+		parent, err := recordedParent(jirix, branch)
+		if err != nil {
+			return nil, nil, err
+		}
+		if parent != "" && git.BranchExists(parent) {
+			add(parent)
+		}
+	}
+	if len(missing) == 0 {
+		return branches, nil, nil
+	}
+	if len(repaired) == 0 {
+		repaired = []string{"origin/" + remoteBranchFlag}
+	}
+	return repaired, missing, nil
+}
+
 // cmdCL represents the "jiri cl" command.
 var cmdCL *cmdline.Command
 
@@ -141,7 +268,7 @@ func newCmdCL() *cmdline.Command {
 		Name:     "cl",
 		Short:    "Manage changelists for multiple projects",
 		Long:     "Manage changelists for multiple projects.",
-		Children: []*cmdline.Command{cmdCLCleanup, cmdCLMail, cmdCLNew, cmdCLSync},
+		Children: []*cmdline.Command{cmdCLChain, cmdCLCleanup, cmdCLMail, cmdCLNew, cmdCLSync},
 	}
 }
 
@@ -158,6 +285,16 @@ Command "cleanup" checks that the given branches have been merged into
 the corresponding remote branch. If a branch differs from the
 corresponding remote branch, the command reports the difference and
 stops. Otherwise, it deletes the given branches.
+
+If the project has a gerrit host, the status of the CL of each branch is
+fetched from the host first. Branches whose CLs have been merged are deleted,
+even if gerrit squashed or rebased their commits, while branches whose CLs
+have been abandoned are only deleted with -f. If the status cannot be fetched,
+a warning is printed and the branch is compared with the remote branch.
+
+Likewise, if the project has a review host, the state of the pull request of
+each branch is fetched from GitHub, and branches whose pull requests have been
+merged or closed are deleted.
 `,
 	ArgsName: "<branches>",
 	ArgsLong: "<branches> is a list of branches to cleanup.",
@@ -189,9 +326,15 @@ func cleanupCL(jirix *jiri.X, branches []string) (e error) {
 	if err := git.FetchRefspec("origin", remoteBranchFlag); err != nil {
 		return err
 	}
+	// The statuses of the CLs are fetched from the gerrit host of the
+	// current project, if there is one.
+	p, err := currentProject(jirix)
+	if err != nil {
+		p = project.Project{}
+	}
 	s := jirix.NewSeq()
 	for _, branch := range branches {
-		cleanupFn := func() error { return cleanupBranch(jirix, branch) }
+		cleanupFn := func() error { return cleanupBranch(jirix, p, branch) }
 		if err := s.Call(cleanupFn, "Cleaning up branch: %s", branch).Done(); err != nil {
 			return err
 		}
@@ -202,12 +345,60 @@ func cleanupCL(jirix *jiri.X, branches []string) (e error) {
 	return nil
 }
 
-func cleanupBranch(jirix *jiri.X, branch string) error {
+// changeMerged returns whether the gerrit host of the given project reports
+// the CL of the given branch as merged.  Abandoned CLs are reported as errors,
+// since their branches are only deleted with -f.  If the status cannot be
+// fetched, a warning is printed and false is returned, so that the branch is
+// compared with the remote branch instead.
+func changeMerged(jirix *jiri.X, p project.Project, branch string) (bool, error) {
+	if p.ReviewHost != "" {
+		return pullRequestClosed(jirix, p, branch)
+	}
+	if p.GerritHost == "" {
+		return false, nil
+	}
+	file, err := getCommitMessageFileName(jirix, branch)
+	if err != nil {
+		return false, err
+	}
+	message, err := jirix.NewSeq().ReadFile(file)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	match := changeIDRE.FindSubmatch(message)
+	if match == nil {
+		return false, nil
+	}
+	status, err := project.ChangeStatus(jirix, p, string(match[1]))
+	if err != nil {
+		fmt.Fprintf(jirix.Stderr(), "WARNING: failed to get the status of the CL of branch %q from %s, comparing it with the remote branch instead: %v\n", branch, p.GerritHost, err)
+		return false, nil
+	}
+	switch status {
+	case gerrit.ChangeStatusMerged:
+		return true, nil
+	case gerrit.ChangeStatusAbandoned:
+		return false, fmt.Errorf("the CL of branch %q has been abandoned, use -f to delete the branch", branch)
+	}
+	return false, nil
+}
+
+func cleanupBranch(jirix *jiri.X, p project.Project, branch string) error {
 	git := gitutil.New(jirix.NewSeq())
 	if err := git.CheckoutBranch(branch); err != nil {
 		return err
 	}
+	merged := false
 	if !forceFlag {
+		var err error
+		if merged, err = changeMerged(jirix, p, branch); err != nil {
+			return err
+		}
+	}
+	if !forceFlag && !merged {
 		trackingBranch := "origin/" + remoteBranchFlag
 		if err := git.Merge(trackingBranch); err != nil {
 			return err
@@ -226,11 +417,13 @@ func cleanupBranch(jirix *jiri.X, branch string) error {
 	if err := git.DeleteBranch(branch, gitutil.ForceOpt(true)); err != nil {
 		return err
 	}
+	jirix.Audit("delete branch", "deleted branch %q of project %q in %s", branch, p.Name, p.Path)
 	reviewBranch := branch + "-REVIEW"
 	if git.BranchExists(reviewBranch) {
 		if err := git.DeleteBranch(reviewBranch, gitutil.ForceOpt(true)); err != nil {
 			return err
 		}
+		jirix.Audit("delete branch", "deleted branch %q of project %q in %s", reviewBranch, p.Name, p.Path)
 	}
 	// Delete branch metadata.
 	topLevel, err := git.TopLevel()
@@ -263,8 +456,7 @@ func cleanupBranch(jirix *jiri.X, branch string) error {
 		branches := strings.Split(string(data), "\n")
 		for i, tmpBranch := range branches {
 			if branch == tmpBranch {
-				data := []byte(strings.Join(append(branches[:i], branches[i+1:]...), "\n"))
-				if err := s.WriteFile(file, data, os.FileMode(0644)).Done(); err != nil {
+				if err := writeDependentCLs(jirix, fileInfo.Name(), append(branches[:i], branches[i+1:]...)); err != nil {
 					return err
 				}
 				break
@@ -299,6 +491,38 @@ for the changelist, which is appended to the commit
 message. Consecutive invocations of the command use the same Change-Id
 by default, informing Gerrit that the incomming commit is an update of
 an existing changelist.
+
+Before mailing, the squashed changelist is checked for files that the
+Gerrit server is likely to reject: files larger than -max-file-size,
+newly added binary files, and a total size of the changed files larger
+than -max-diff-size. The changelist is not mailed if any are found,
+unless -force-large is set.
+
+If -r is not set, review is requested from the owners of the changed
+files, unless -no-owners is set. The owners of a file are listed in the
+nearest OWNERS file in its directory or a parent directory, one email
+address per line. A line "include <path>" adds the owners listed in
+another OWNERS file, given relative to the directory of the including
+file, or to the root of the repository if the path starts with "/". The
+owners are printed and added after confirmation, or right away if -yes
+is set.
+
+The -hashtag, -label, -wip and -ready flags are sent to Gerrit as options
+of the pushed reference, such as
+refs/for/master%hashtag=foo,l=Commit-Queue+1,wip, which is printed if -v
+is set. -hashtag and -label may be repeated.
+
+If the project has a "reviewhost" attribute naming a repository on GitHub,
+and -host is not set, the branch is sent as a pull request instead.  The
+branch is pushed to the fork given by -fork, or to a branch of origin named
+jiri/<username>/<branchname>, and a pull request against -remote-branch is
+created with the first line of -m, or of the last commit message, as its
+title and the rest as its body.  -r lists the GitHub logins to request review
+from, and -d creates a draft pull request.  Later invocations push the branch
+again, and update the title and body of the same pull request if -m is set.
+The GitHub token is read from the GITHUB_TOKEN environment variable, or from
+the password of the host in ~/.netrc.  Flags that only apply to Gerrit are
+ignored with a warning.
 `,
 	}
 }
@@ -317,20 +541,33 @@ func (e changeConflictError) Error() string {
 	return result
 }
 
+func (changeConflictError) ErrorKind() errkind.Kind {
+	return errkind.GitConflictError
+}
+
 type emptyChangeError struct{}
 
 func (_ emptyChangeError) Error() string {
 	return "current branch has no commits"
 }
 
-type gerritError string
+// gerritError wraps the error returned when pushing a review to Gerrit fails.
+type gerritError struct {
+	err error
+}
 
 func (e gerritError) Error() string {
 	result := "sending code review failed\n\n"
-	result += string(e)
+	result += e.err.Error()
 	return result
 }
 
+// ErrorKind returns the kind of the push failure, e.g. errkind.NetworkError if
+// Gerrit could not be reached.
+func (e gerritError) ErrorKind() errkind.Kind {
+	return errkind.Of(e.err)
+}
+
 type noChangeIDError struct{}
 
 func (_ noChangeIDError) Error() string {
@@ -338,6 +575,17 @@ func (_ noChangeIDError) Error() string {
 	return result
 }
 
+// largeChangeError is returned when a changelist has files that the Gerrit
+// server is likely to reject.
+type largeChangeError []string
+
+func (e largeChangeError) Error() string {
+	result := "changelist is too large to be mailed:\n"
+	result += "  " + strings.Join(e, "\n  ") + "\n"
+	result += "Use -force-large to mail it anyway."
+	return result
+}
+
 type uncommittedChangesError []string
 
 func (e uncommittedChangesError) Error() string {
@@ -544,8 +792,9 @@ func (mp *multiPart) commandline(excludeKey project.ProjectKey, flags []string)
 // that should be passed on to the sub invocations of cl mail when
 // operating across multiple repos.
 // These are:
-// -autosubmit, -cc, -d, -edit, -host, -m, -presubmit, remote-branch, -r,
-// -set-topic, -topic, -check-uncommitted and -verify,
+// -autosubmit, -cc, -d, -edit, -hashtag, -host, -label, -m, -presubmit,
+// -ready, remote-branch, -r, -set-topic, -topic, -check-uncommitted, -verify,
+// -wip, -max-file-size, -max-diff-size, -force-large, -no-owners and -yes.
 func clMailMultiFlags() []string {
 	flags := []string{}
 	stringFlag := func(name, value string) {
@@ -579,14 +828,28 @@ func clMailMultiFlags() []string {
 	boolFlag("autosubmit", autosubmitFlag)
 	stringFlag("cc", ccsFlag)
 	boolFlag("d", draftFlag)
+	for _, hashtag := range hashtagsFlag {
+		flags = append(flags, "--hashtag="+hashtag)
+	}
 	stringFlag("host", hostFlag)
+	for _, label := range labelsFlag {
+		flags = append(flags, fmt.Sprintf("--label=%s=%+d", label.Name, label.Value))
+	}
 	stringFlag("m", messageFlag)
 	stringFlag("presubmit", presubmitFlag)
+	boolFlag("ready", readyFlag)
 	stringFlag("remote-branch", remoteBranchFlag)
 	stringFlag("r", reviewersFlag)
 	boolFlag("set-topic", setTopicFlag)
+	stringFlag("topic", topicFlag)
 	boolFlag("check-uncommitted", uncommittedFlag)
 	boolFlag("verify", verifyFlag)
+	boolFlag("wip", wipFlag)
+	stringFlag("max-file-size", maxFileSizeFlag.String())
+	stringFlag("max-diff-size", maxDiffSizeFlag.String())
+	boolFlag("force-large", forceLargeFlag)
+	boolFlag("no-owners", noOwnersFlag)
+	boolFlag("yes", yesFlag)
 	return flags
 }
 
@@ -667,29 +930,22 @@ func runCLMailCurrent(jirix *jiri.X, _ []string) error {
 		return jirix.UsageErrorf("invalid value for the -presubmit flag. Valid values: %s.",
 			strings.Join(gerrit.PresubmitTestTypes(), ","))
 	}
+	if wipFlag && readyFlag {
+		return jirix.UsageErrorf("-wip and -ready cannot be used together")
+	}
 
 	p, err := currentProject(jirix)
 	if err != nil {
 		return err
 	}
-
-	host := hostFlag
-	if host == "" {
-		if p.GerritHost == "" {
-			return fmt.Errorf("No gerrit host found.  Please use the '--host' flag, or add a 'gerrithost' attribute for project %q.", p.Name)
-		}
-		host = p.GerritHost
-	}
-	hostUrl, err := url.Parse(host)
-	if err != nil {
-		return fmt.Errorf("invalid Gerrit host %q: %v", host, err)
+	if p.ReviewHost != "" && hostFlag == "" {
+		return mailPullRequest(jirix, p)
 	}
-	projectRemoteUrl, err := url.Parse(p.Remote)
+
+	hostUrl, remote, err := reviewTarget(p, hostFlag)
 	if err != nil {
-		return fmt.Errorf("invalid project remote: %v", p.Remote, err)
+		return err
 	}
-	gerritRemote := *hostUrl
-	gerritRemote.Path = projectRemoteUrl.Path
 
 	// Create and run the review.
 	review, err := newReview(jirix, p, gerrit.CLOpts{
@@ -697,12 +953,17 @@ func runCLMailCurrent(jirix *jiri.X, _ []string) error {
 		Ccs:          parseEmails(ccsFlag),
 		Draft:        draftFlag,
 		Edit:         editFlag,
-		Remote:       gerritRemote.String(),
+		Hashtags:     hashtagsFlag,
+		Labels:       labelsFlag,
+		Remote:       remote,
 		Host:         hostUrl,
 		Presubmit:    gerrit.PresubmitTestType(presubmitFlag),
 		RemoteBranch: remoteBranchFlag,
 		Reviewers:    parseEmails(reviewersFlag),
+		Topic:        topicFlag,
 		Verify:       verifyFlag,
+		WIP:          wipFlag,
+		Ready:        readyFlag,
 	})
 	if err != nil {
 		return err
@@ -715,12 +976,37 @@ func runCLMailCurrent(jirix *jiri.X, _ []string) error {
 	err = review.run()
 	// Ignore the error that is returned when there are no differences
 	// between the local and gerrit branches.
-	if err != nil && noChangesRE.MatchString(err.Error()) {
+	if ge, ok := err.(gerritError); ok && gitutil.IsNoNewChanges(ge.err) {
 		return nil
 	}
 	return err
 }
 
+// reviewTarget returns the gerrit host that CLs of the given project are
+// sent to and the git remote they are pushed to.  The host flag takes
+// precedence over the gerrithost and gerritremote attributes of the project.
+func reviewTarget(p project.Project, hostFlag string) (*url.URL, string, error) {
+	host := hostFlag
+	if host == "" {
+		if p.GerritHost == "" {
+			return nil, "", fmt.Errorf("No gerrit host found.  Please use the '--host' flag, or add a 'gerrithost' attribute for project %q.", p.Name)
+		}
+		host = p.GerritHost
+	}
+	pushUrl, err := p.GerritPushUrl(host)
+	if err != nil {
+		return nil, "", err
+	}
+	hostUrl, err := url.Parse(host)
+	if err != nil {
+		return nil, "", err
+	}
+	if hostFlag == "" && p.GerritRemote != "" {
+		return hostUrl, p.GerritRemote, nil
+	}
+	return hostUrl, pushUrl.String(), nil
+}
+
 // parseEmails input a list of comma separated tokens and outputs a
 // list of email addresses. The tokens can either be email addresses
 // or Google LDAPs in which case the suffix @google.com is appended to
@@ -804,6 +1090,13 @@ func newReview(jirix *jiri.X, project project.Project, opts gerrit.CLOpts) (*rev
 		return nil, err
 	}
 	opts.Branch = branch
+	if opts.Topic == "" {
+		topic, err := getTopic(jirix, branch)
+		if err != nil {
+			return nil, err
+		}
+		opts.Topic = topic
+	}
 	if opts.Topic == "" {
 		opts.Topic = fmt.Sprintf("%s-%s", os.Getenv("USER"), branch) // use <username>-<branchname> as the default
 	}
@@ -961,10 +1254,120 @@ func (review *review) createReviewBranch(message string) (e error) {
 		return err
 	}
 
+	// Check the squashed commits, which are exactly what is pushed.
+	if !forceLargeFlag {
+		if err := checkChangeSize(git, upstream, review.reviewBranch); err != nil {
+			return err
+		}
+	}
+
 	cleanup = false
 	return nil
 }
 
+// checkChangeSize returns a largeChangeError listing the files changed
+// between the <base> and <branch> branches that are larger than
+// -max-file-size or binary files that were added, or the total size of the
+// changed files if it is larger than -max-diff-size.
+func checkChangeSize(git *gitutil.Git, base, branch string) error {
+	changes, err := git.ChangedFiles(base, branch)
+	if err != nil {
+		return err
+	}
+	problems, total := []string{}, int64(0)
+	for _, change := range changes {
+		total += change.Size
+		if change.Size > int64(maxFileSizeFlag) {
+			problems = append(problems, fmt.Sprintf("%s: size %v exceeds -max-file-size=%v", change.Path, byteSize(change.Size), maxFileSizeFlag))
+		} else if change.Added && change.Binary {
+			problems = append(problems, fmt.Sprintf("%s: new binary file", change.Path))
+		}
+	}
+	if total > int64(maxDiffSizeFlag) {
+		problems = append(problems, fmt.Sprintf("total size of changed files %v exceeds -max-diff-size=%v", byteSize(total), maxDiffSizeFlag))
+	}
+	if len(problems) > 0 {
+		return largeChangeError(problems)
+	}
+	return nil
+}
+
+// byteSize is a number of bytes that implements flag.Value, accepting
+// values such as "1024", "512KB" or "5MB".
+type byteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func (b *byteSize) Set(value string) error {
+	number, unit := strings.ToUpper(strings.TrimSpace(value)), int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(number, u.suffix) {
+			number, unit = strings.TrimSpace(strings.TrimSuffix(number, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*b = byteSize(n * unit)
+	return nil
+}
+
+func (b byteSize) String() string {
+	for _, u := range byteSizeUnits {
+		if b != 0 && int64(b)%u.size == 0 {
+			return fmt.Sprintf("%d%s", int64(b)/u.size, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
+
+// hashtagList is a list of hashtags that implements flag.Value, accepting
+// one hashtag each time the flag is set.
+type hashtagList []string
+
+func (h *hashtagList) Set(value string) error {
+	if err := gerrit.ValidateHashtag(value); err != nil {
+		return err
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+func (h hashtagList) String() string {
+	return strings.Join(h, ",")
+}
+
+// labelVotes is a list of label votes that implements flag.Value, accepting
+// one vote such as "Code-Review=+1" each time the flag is set.
+type labelVotes []gerrit.LabelVote
+
+func (l *labelVotes) Set(value string) error {
+	vote, err := gerrit.ParseLabelVote(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, vote)
+	return nil
+}
+
+func (l labelVotes) String() string {
+	var votes []string
+	for _, vote := range l {
+		votes = append(votes, vote.String())
+	}
+	return strings.Join(votes, ",")
+}
+
 // squashBranches iterates over the given list of branches, creating
 // one commit per branch in the current branch by squashing all
 // commits of each individual branch.
@@ -1223,6 +1626,11 @@ func (review *review) run() (e error) {
 	if err := review.createReviewBranch(message); err != nil {
 		return err
 	}
+	if len(review.CLOpts.Reviewers) == 0 && !noOwnersFlag {
+		if err := review.addOwners(topLevel); err != nil {
+			return err
+		}
+	}
 	if err := review.updateReviewMessage(file); err != nil {
 		return err
 	}
@@ -1237,13 +1645,51 @@ func (review *review) run() (e error) {
 	return nil
 }
 
+// addOwners requests review from the owners of the files changed by the
+// last commit of the review branch, as listed in the OWNERS files of the
+// repository in the given directory.  The owners are printed, and added
+// only if the user confirms, or -yes is set.
+func (review *review) addOwners(topLevel string) error {
+	git := gitutil.New(review.jirix.NewSeq())
+	changes, err := git.ChangedFiles(review.reviewBranch+"^", review.reviewBranch)
+	if err != nil {
+		return err
+	}
+	// Renames are reported as a deletion and an addition, so that both the
+	// old and the new directory of a renamed file are attributed.
+	files := []string{}
+	for _, change := range changes {
+		files = append(files, change.Path)
+	}
+	owners, err := newOwnersReader(review.jirix, topLevel).findOwners(files)
+	if err != nil {
+		return err
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+	fmt.Fprintf(review.jirix.Stdout(), "Reviewers from the OWNERS files of the changed files:\n  %s\n", strings.Join(owners, "\n  "))
+	if !yesFlag {
+		fmt.Fprint(review.jirix.Stdout(), "Request review from them? y/N:")
+		var response string
+		if _, err := fmt.Fscanf(review.jirix.Stdin(), "%s\n", &response); err != nil || response != "y" {
+			return nil
+		}
+	}
+	review.CLOpts.Reviewers = owners
+	return nil
+}
+
 // send mails the current branch out for review.
 func (review *review) send() error {
 	if err := review.ensureChangeID(); err != nil {
 		return err
 	}
+	if review.jirix.Verbose() {
+		fmt.Fprintf(review.jirix.Stdout(), "Pushing HEAD:%s to %s\n", gerrit.Reference(review.CLOpts), review.CLOpts.Remote)
+	}
 	if err := gerrit.Push(review.jirix.NewSeq(), review.CLOpts); err != nil {
-		return gerritError(err.Error())
+		return gerritError{err}
 	}
 	return nil
 }
@@ -1331,10 +1777,16 @@ var cmdCLNew = &cmdline.Command{
 	Long: fmt.Sprintf(`
 Command "new" creates a new local branch for a changelist. In
 particular, it forks a new branch with the given name from the current
-branch and records the relationship between the current branch and the
-new branch in the %v metadata directory. The information recorded in
-the %v metadata directory tracks dependencies between CLs and is used
-by the "jiri cl sync" and "jiri cl mail" commands.
+branch, or from the branch or ref given by the -base flag, and records
+the relationship between the base and the new branch in the %v
+metadata directory. The information recorded in the %v metadata
+directory tracks dependencies between CLs and is used by the "jiri cl
+sync" and "jiri cl mail" commands.
+
+A base that is not a local branch, such as "origin/master", is recorded
+as the start of the dependency path of the new branch, which then does
+not depend on any other local CL. The -topic flag records the Gerrit
+topic that "jiri cl mail" uses for the new CL.
 `, jiri.ProjectMetaDir, jiri.ProjectMetaDir),
 	ArgsName: "<name>",
 	ArgsLong: "<name> is the changelist name.",
@@ -1353,15 +1805,29 @@ func newCL(jirix *jiri.X, args []string) error {
 	if err != nil {
 		return err
 	}
-	originalBranch, err := git.CurrentBranchName()
+	localBranches, originalBranch, err := git.GetBranches()
 	if err != nil {
 		return err
 	}
+	base := baseFlag
+	if base == "" {
+		base = originalBranch
+	}
 
-	// Create a new branch using the current branch.
+	// Create a new branch using the base.
 	newBranch := args[0]
-	if err := git.CreateAndCheckoutBranch(newBranch); err != nil {
-		return err
+	if base == originalBranch {
+		if err := git.CreateAndCheckoutBranch(newBranch); err != nil {
+			return err
+		}
+	} else {
+		if err := git.CreateBranchWithUpstream(newBranch, base); err != nil {
+			return err
+		}
+		if err := git.CheckoutBranch(newBranch); err != nil {
+			git.DeleteBranch(newBranch, gitutil.ForceOpt(true))
+			return err
+		}
 	}
 
 	// Register a cleanup handler in case of subsequent errors.
@@ -1376,28 +1842,48 @@ func newCL(jirix *jiri.X, args []string) error {
 	s := jirix.NewSeq()
 	// Record the dependent CLs for the new branch. The dependent CLs
 	// are recorded in a <dependencyPathFileName> file as a
-	// newline-separated list of branch names.
-	branches, err := getDependentCLs(jirix, originalBranch)
-	if err != nil {
-		return err
+	// newline-separated list of branch names. A base that is not a
+	// local branch, such as a remote branch, starts a new dependency
+	// path.
+	branches := []string{base}
+	if isLocalBranch(localBranches, base) {
+		if branches, err = getDependentCLs(jirix, base); err != nil {
+			return err
+		}
+		branches = append(branches, base)
 	}
-	branches = append(branches, originalBranch)
 	newMetadataDir := filepath.Join(topLevel, jiri.ProjectMetaDir, newBranch)
 	if err := s.MkdirAll(newMetadataDir, os.FileMode(0755)).Done(); err != nil {
 		return err
 	}
-	file, err := getDependencyPathFileName(jirix, newBranch)
-	if err != nil {
+	if err := writeDependentCLs(jirix, newBranch, branches); err != nil {
 		return err
 	}
-	if err := s.WriteFile(file, []byte(strings.Join(branches, "\n")), os.FileMode(0644)).Done(); err != nil {
-		return err
+	if newTopicFlag != "" {
+		file, err := getTopicFileName(jirix, newBranch)
+		if err != nil {
+			return err
+		}
+		if err := s.WriteFile(file, []byte(newTopicFlag), os.FileMode(0644)).Done(); err != nil {
+			return err
+		}
 	}
 
 	cleanup = false
 	return nil
 }
 
+// isLocalBranch checks whether the given branch is one of the given
+// local branches.
+func isLocalBranch(localBranches []string, branch string) bool {
+	for _, b := range localBranches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
 // cmdCLSync represents the "jiri cl sync" command.
 var cmdCLSync = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runCLSync),
@@ -1413,6 +1899,13 @@ bringing each of the CLs up to date with its ancestor. The end result
 of this process is that all CLs in the sequence are up to date with
 the branch that tracks the remote branch this CL pertains to.
 
+Before syncing, the command checks that the branches of the dependency
+path still exist. Branches deleted or renamed outside of jiri are
+spliced out of the path, after confirmation or right away if -repair
+is set: their dependents are re-pointed at the parent recorded for the
+missing branch, or at the remote branch if no branch of the path
+remains. Use "jiri cl chain" to inspect the dependency path.
+
 NOTE: It is possible that the command cannot automatically merge
 changes in an ancestor into its dependent. When that occurs, the
 command is aborted and prints instructions that need to be followed
@@ -1425,6 +1918,11 @@ func runCLSync(jirix *jiri.X, _ []string) error {
 }
 
 func syncCL(jirix *jiri.X) (e error) {
+	// The commands are run without stdin, which would otherwise be consumed
+	// by the first of them, so that it is left for the confirmation prompt
+	// of checkDependentCLs.
+	stdin := jirix.Stdin()
+	jirix = jirix.Clone(tool.ContextOpts{Stdin: strings.NewReader("")})
 	git := gitutil.New(jirix.NewSeq())
 	stashed, err := git.Stash()
 	if err != nil {
@@ -1464,18 +1962,32 @@ func syncCL(jirix *jiri.X) (e error) {
 
 	// Identify the dependents CLs leading to (and including) the
 	// current branch.
-	branches, err := getDependentCLs(jirix, originalBranch)
+	branches, err := checkDependentCLs(jirix, stdin, originalBranch)
 	if err != nil {
 		return err
 	}
 	branches = append(branches, originalBranch)
 
-	// Sync from upstream.
-	if err := git.CheckoutBranch(branches[0]); err != nil {
+	// Sync from upstream. A dependency path that starts with a remote
+	// branch, rather than a local one, is brought up to date by fetching
+	// from the remote.
+	localBranches, _, err := git.GetBranches()
+	if err != nil {
 		return err
 	}
-	if err := git.Pull("origin", branches[0]); err != nil {
-		return err
+	if !isLocalBranch(localBranches, branches[0]) {
+		if remoteBranch := strings.TrimPrefix(branches[0], "origin/"); remoteBranch != branches[0] {
+			if err := git.FetchRefspec("origin", remoteBranch); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := git.CheckoutBranch(branches[0]); err != nil {
+			return err
+		}
+		if err := git.Pull("origin", branches[0]); err != nil {
+			return err
+		}
 	}
 
 	// Bring all CLs in the sequence of dependent CLs leading to the
@@ -1500,3 +2012,114 @@ $ git checkout %v
 	forceOriginalBranch = false
 	return nil
 }
+
+// checkDependentCLs returns the dependency path of the given branch, after
+// checking that its branches still exist.  If not, the repaired dependency
+// path is printed and recorded after confirmation, read from stdin, or right
+// away if -repair is set.
+func checkDependentCLs(jirix *jiri.X, stdin io.Reader, branch string) ([]string, error) {
+	branches, err := getDependentCLs(jirix, branch)
+	if err != nil {
+		return nil, err
+	}
+	repaired, missing, err := repairDependentCLs(jirix, branches)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) == 0 {
+		return branches, nil
+	}
+	fmt.Fprintf(jirix.Stdout(), "The dependency path of branch %q names branches that no longer exist: %s\n", branch, strings.Join(missing, ", "))
+	fmt.Fprintf(jirix.Stdout(), "The repaired dependency path is:\n  %s\n", strings.Join(repaired, "\n  "))
+	if !repairFlag {
+		fmt.Fprint(jirix.Stdout(), "Repair the dependency path? y/N:")
+		var response string
+		if _, err := fmt.Fscanf(stdin, "%s\n", &response); err != nil || response != "y" {
+			return nil, fmt.Errorf("the dependency path of branch %q names branches that no longer exist: %s\nRun \"jiri cl sync -repair\" to repair it", branch, strings.Join(missing, ", "))
+		}
+	}
+	if err := writeDependentCLs(jirix, branch, repaired); err != nil {
+		return nil, err
+	}
+	return repaired, nil
+}
+
+// cmdCLChain represents the "jiri cl chain" command.
+var cmdCLChain = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runCLChain),
+	Name:   "chain",
+	Short:  "Show the dependency path of a changelist",
+	Long: fmt.Sprintf(`
+Command "chain" prints the sequence of dependent CLs leading to the CL
+identified by the current branch, as recorded in the %v metadata
+directory by "jiri cl new", one branch per line. Each branch is
+followed by its merge status: whether it is missing, whether it is up
+to date with its ancestor or how many commits it is behind, and
+whether it has been merged into the branch tracking the remote branch
+this CL pertains to.
+`, jiri.ProjectMetaDir),
+}
+
+func runCLChain(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	return printChain(jirix)
+}
+
+func printChain(jirix *jiri.X) error {
+	git := gitutil.New(jirix.NewSeq())
+	branch, err := git.CurrentBranchName()
+	if err != nil {
+		return err
+	}
+	branches, err := getDependentCLs(jirix, branch)
+	if err != nil {
+		return err
+	}
+	branches = append(branches, branch)
+	remote := "origin/" + remoteBranchFlag
+	for i, b := range branches {
+		status, err := chainStatus(git, branches, i, remote)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s: %s\n", b, status)
+	}
+	return nil
+}
+
+// chainStatus returns the merge status of the i-th branch of the given
+// dependency path.
+func chainStatus(git *gitutil.Git, branches []string, i int, remote string) (string, error) {
+	branch := branches[i]
+	if !git.BranchExists(branch) {
+		return "missing", nil
+	}
+	var status []string
+	if i == 0 {
+		status = append(status, "base")
+	} else if parent := branches[i-1]; !git.BranchExists(parent) {
+		status = append(status, fmt.Sprintf("ancestor %v is missing", parent))
+	} else {
+		behind, err := git.CountCommits(parent, branch)
+		if err != nil {
+			return "", err
+		}
+		if behind == 0 {
+			status = append(status, fmt.Sprintf("up to date with %v", parent))
+		} else {
+			status = append(status, fmt.Sprintf("%d commit(s) behind %v", behind, parent))
+		}
+	}
+	if i > 0 && git.BranchExists(remote) {
+		unmerged, err := git.CountCommits(branch, remote)
+		if err != nil {
+			return "", err
+		}
+		if unmerged == 0 {
+			status = append(status, fmt.Sprintf("merged into %v", remote))
+		}
+	}
+	return strings.Join(status, ", "), nil
+}
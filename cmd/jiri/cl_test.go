@@ -6,22 +6,29 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"v.io/jiri"
 	"v.io/jiri/gerrit"
+	"v.io/jiri/github"
 	"v.io/jiri/gitutil"
 	"v.io/jiri/jiritest"
 	"v.io/jiri/project"
 	"v.io/jiri/runutil"
+	"v.io/jiri/tool"
 )
 
 // assertCommitCount asserts that the commit count between two
@@ -310,6 +317,262 @@ func TestCleanupDirty(t *testing.T) {
 	assertFilesCommitted(t, fake.X, files)
 }
 
+// fakeGerritHost returns a fake gerrit host that reports the given statuses
+// of changes, keyed by Change-Id.
+func fakeGerritHost(statuses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Query().Get("q"), "change:")
+		// Gerrit prefixes JSON responses with a line that guards against
+		// cross-site script inclusion.
+		fmt.Fprintln(w, ")]}'")
+		if status, ok := statuses[id]; ok {
+			fmt.Fprintf(w, `[{"change_id": %q, "status": %q}]`, id, status)
+		} else {
+			fmt.Fprint(w, "[]")
+		}
+	}))
+}
+
+// writeChangeID records the given Change-Id in the commit message of the
+// given branch.
+func writeChangeID(t *testing.T, jirix *jiri.X, projectPath, branch, changeID string) {
+	dir := filepath.Join(projectPath, jiri.ProjectMetaDir, branch)
+	message := "Commit message\n\nChange-Id: " + changeID + "\n"
+	if err := jirix.NewSeq().MkdirAll(dir, 0755).
+		WriteFile(filepath.Join(dir, commitMessageFileName), []byte(message), 0644).Done(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCleanupChangeStatus checks that cleanup deletes the branches whose CLs
+// have been merged according to gerrit, and the branches whose CLs have been
+// abandoned only with -f.
+func TestCleanupChangeStatus(t *testing.T) {
+	fake, repoPath, originPath, _, cleanup := setupTest(t, true)
+	defer cleanup()
+	defer func() { forceFlag = false }()
+	statuses := map[string]string{}
+	host := fakeGerritHost(statuses)
+	defer host.Close()
+	p := project.Project{
+		Name:       "test",
+		Path:       repoPath,
+		Protocol:   "git",
+		Remote:     originPath,
+		GerritHost: host.URL,
+	}
+	if err := fake.X.NewSeq().MkdirAll(filepath.Join(repoPath, jiri.ProjectMetaDir), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ToFile(fake.X, filepath.Join(repoPath, jiri.ProjectMetaDir, jiri.ProjectMetaFile)); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stderr: &stderr})
+
+	git := gitutil.New(fake.X.NewSeq())
+	// createBranch creates a branch with changes that have not been merged
+	// into the remote branch, whose CL has the given status.
+	createBranch := func(branch, status string) {
+		if err := git.CreateAndCheckoutBranch(branch); err != nil {
+			t.Fatal(err)
+		}
+		commitFiles(t, fake.X, []string{branch})
+		changeID := fmt.Sprintf("I%040x", len(statuses)+1)
+		writeChangeID(t, fake.X, repoPath, branch, changeID)
+		if status != "" {
+			statuses[changeID] = status
+		}
+		if err := git.CheckoutBranch("master"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createBranch("merged", gerrit.ChangeStatusMerged)
+	createBranch("abandoned", gerrit.ChangeStatusAbandoned)
+	createBranch("new", gerrit.ChangeStatusNew)
+	createBranch("unknown", "")
+
+	// Only the branch whose CL has been merged is deleted.
+	for branch, wantDeleted := range map[string]bool{
+		"merged":    true,
+		"abandoned": false,
+		"new":       false,
+		"unknown":   false,
+	} {
+		err := cleanupCL(fake.X, []string{branch})
+		if wantDeleted && err != nil {
+			t.Errorf("%s: cleanup failed: %v", branch, err)
+		} else if !wantDeleted && err == nil {
+			t.Errorf("%s: cleanup did not fail when it should", branch)
+		}
+		if got := git.BranchExists(branch); got == wantDeleted {
+			t.Errorf("%s: got branch exists %v, want %v", branch, got, !wantDeleted)
+		}
+	}
+	if err := cleanupCL(fake.X, []string{"abandoned"}); err == nil || !strings.Contains(err.Error(), "abandoned") {
+		t.Errorf("got error %v, want an error about the abandoned CL", err)
+	}
+	forceFlag = true
+	if err := cleanupCL(fake.X, []string{"abandoned"}); err != nil {
+		t.Fatalf("cleanup -f failed: %v", err)
+	}
+	forceFlag = false
+	if strings.Contains(stderr.String(), "WARNING") {
+		t.Errorf("got warnings %q, want none", stderr.String())
+	}
+
+	// If gerrit cannot be reached, the branch is compared with the remote
+	// branch, after a warning.
+	createBranch("unreachable", gerrit.ChangeStatusMerged)
+	host.Close()
+	if err := cleanupCL(fake.X, []string{"unreachable"}); err == nil || !strings.Contains(err.Error(), "unmerged changes") {
+		t.Errorf("got error %v, want an error about unmerged changes", err)
+	}
+	if got, want := stderr.String(), `WARNING: failed to get the status of the CL of branch "unreachable"`; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+}
+
+// fakeGitHubHost returns a fake GitHub host that keeps the given pull
+// requests of the repository owner/repo, keyed by number, and records the
+// heads and reviewers of the pull requests.
+func fakeGitHubHost(pulls map[int]*github.PullRequest, heads map[int]string, reviewers map[int][]string) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path := strings.TrimPrefix(r.URL.Path, "/api/v3/repos/owner/repo/pulls")
+		if path == "" && r.Method == "POST" {
+			var opts github.PullRequestOpts
+			if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			number := len(pulls) + 1
+			pulls[number] = &github.PullRequest{Number: number, State: github.PullRequestOpen, Title: opts.Title, Body: opts.Body, HTMLUrl: fmt.Sprintf("https://github.com/owner/repo/pull/%d", number)}
+			heads[number] = opts.Head
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(pulls[number])
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+		number, err := strconv.Atoi(parts[0])
+		pr, ok := pulls[number]
+		if err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case len(parts) == 2 && parts[1] == "requested_reviewers":
+			var request struct {
+				Reviewers []string `json:"reviewers"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			reviewers[number] = append(reviewers[number], request.Reviewers...)
+		case r.Method == "PATCH":
+			if err := json.NewDecoder(r.Body).Decode(pr); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(pr)
+	}))
+}
+
+// TestCLMailPullRequest checks that "jiri cl mail" sends a pull request for
+// projects with a reviewhost, updates it on later invocations, and that
+// cleanup deletes the branches whose pull requests have been closed.
+func TestCLMailPullRequest(t *testing.T) {
+	fake, repoPath, originPath, _, cleanup := setupTest(t, true)
+	defer cleanup()
+	defer func() { messageFlag, reviewersFlag = "", "" }()
+	pulls, heads, reviewers := map[int]*github.PullRequest{}, map[int]string{}, map[int][]string{}
+	host := fakeGitHubHost(pulls, heads, reviewers)
+	defer host.Close()
+	defer os.Setenv("GITHUB_TOKEN", os.Getenv("GITHUB_TOKEN"))
+	os.Setenv("GITHUB_TOKEN", "secret")
+	defer os.Setenv("USER", os.Getenv("USER"))
+	os.Setenv("USER", "octocat")
+	p := project.Project{
+		Name:       "test",
+		Path:       repoPath,
+		Protocol:   "git",
+		Remote:     originPath,
+		ReviewHost: host.URL + "/owner/repo",
+	}
+	if err := fake.X.NewSeq().MkdirAll(filepath.Join(repoPath, jiri.ProjectMetaDir), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ToFile(fake.X, filepath.Join(repoPath, jiri.ProjectMetaDir, jiri.ProjectMetaFile)); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+
+	branch := "feature"
+	createCLWithFiles(t, fake.X, branch, "file1")
+	if err := runCLMailCurrent(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(pulls), 1; got != want {
+		t.Fatalf("got %d pull requests, want %d", got, want)
+	}
+	if got, want := heads[1], "jiri/octocat/"+branch; got != want {
+		t.Errorf("got head %q, want %q", got, want)
+	}
+	if got, want := pulls[1].Title, "Commit file1"; got != want {
+		t.Errorf("got title %q, want %q", got, want)
+	}
+	if !strings.Contains(stdout.String(), "Pull request: https://github.com/owner/repo/pull/1") {
+		t.Errorf("got stdout %q, want it to contain the url of the pull request", stdout.String())
+	}
+	origin := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(originPath))
+	if !origin.BranchExists(heads[1]) {
+		t.Errorf("branch %q was not pushed to origin", heads[1])
+	}
+
+	// The same pull request is updated later.
+	commitFiles(t, fake.X, []string{"file2"})
+	messageFlag, reviewersFlag = "New title\n\nNew body", "alice,bob"
+	if err := runCLMailCurrent(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(pulls), 1; got != want {
+		t.Fatalf("got %d pull requests, want %d", got, want)
+	}
+	if pulls[1].Title != "New title" || pulls[1].Body != "New body" {
+		t.Errorf("got title %q and body %q after update", pulls[1].Title, pulls[1].Body)
+	}
+	if got, want := reviewers[1], []string{"alice", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got reviewers %v, want %v", got, want)
+	}
+	files, err := origin.ModifiedFiles("master", heads[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := files, []string{"file1", "file2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v pushed, want %v", got, want)
+	}
+
+	// The branch is only cleaned up once the pull request is closed.
+	if err := gitutil.New(fake.X.NewSeq()).CheckoutBranch("master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanupCL(fake.X, []string{branch}); err == nil {
+		t.Errorf("cleanup of a branch with an open pull request did not fail")
+	}
+	pulls[1].State = github.PullRequestClosed
+	if err := cleanupCL(fake.X, []string{branch}); err != nil {
+		t.Fatal(err)
+	}
+	if gitutil.New(fake.X.NewSeq()).BranchExists(branch) {
+		t.Errorf("branch %q was not deleted", branch)
+	}
+}
+
 // TestCreateReviewBranch checks that the temporary review branch is
 // created correctly.
 func TestCreateReviewBranch(t *testing.T) {
@@ -367,6 +630,86 @@ func TestCreateReviewBranchWithEmptyChange(t *testing.T) {
 	}
 }
 
+// TestCreateReviewBranchWithLargeChange checks that running
+// createReviewBranch() on a branch with large or binary files results in a
+// largeChangeError, without leaving the review branch behind, unless
+// -force-large is set.
+func TestCreateReviewBranchWithLargeChange(t *testing.T) {
+	fake, _, _, _, cleanup := setupTest(t, true)
+	defer cleanup()
+	defer func(maxFileSize byteSize) { maxFileSizeFlag, forceLargeFlag = maxFileSize, false }(maxFileSizeFlag)
+	branch := "my-branch"
+	git := gitutil.New(fake.X.NewSeq())
+	if err := git.CreateAndCheckoutBranch(branch); err != nil {
+		t.Fatalf("%v", err)
+	}
+	commitFile(t, fake.X, "text", strings.Repeat("text\n", 100))
+	commitFile(t, fake.X, "binary", "\x00\x01\x02")
+	review, err := newReview(fake.X, project.Project{}, gerrit.CLOpts{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	maxFileSizeFlag = 100
+	err = review.createReviewBranch("squashed commit")
+	got, ok := err.(largeChangeError)
+	if !ok {
+		t.Fatalf("unexpected error type: %v", err)
+	}
+	want := largeChangeError{
+		"binary: new binary file",
+		"text: size 500B exceeds -max-file-size=100B",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if git.BranchExists(review.reviewBranch) {
+		t.Errorf("review branch %q was not deleted", review.reviewBranch)
+	}
+	if current, err := git.CurrentBranchName(); err != nil || current != branch {
+		t.Errorf("got current branch %q, %v, want %q", current, err, branch)
+	}
+	forceLargeFlag = true
+	if err := review.createReviewBranch("squashed commit"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !git.BranchExists(review.reviewBranch) {
+		t.Fatalf("review branch not found")
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  byteSize
+		str   string
+	}{
+		{"0", 0, "0B"},
+		{"1000", 1000, "1000B"},
+		{"512KB", 512 << 10, "512KB"},
+		{"5mb", 5 << 20, "5MB"},
+		{"2 GB", 2 << 30, "2GB"},
+		{"1536KB", 1536 << 10, "1536KB"},
+	} {
+		var got byteSize
+		if err := got.Set(test.value); err != nil {
+			t.Errorf("Set(%q) failed: %v", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Set(%q): got %d, want %d", test.value, got, test.want)
+		}
+		if got.String() != test.str {
+			t.Errorf("String(%q): got %q, want %q", test.value, got.String(), test.str)
+		}
+	}
+	for _, value := range []string{"", "MB", "-1", "1.5MB", "5TB"} {
+		var b byteSize
+		if err := b.Set(value); err == nil {
+			t.Errorf("Set(%q) did not fail", value)
+		}
+	}
+}
+
 // TestSendReview checks the various options for sending a review.
 func TestSendReview(t *testing.T) {
 	fake, repoPath, _, gerritPath, cleanup := setupTest(t, true)
@@ -436,6 +779,58 @@ func TestSendReview(t *testing.T) {
 		expectedRef := gerrit.Reference(review.CLOpts)
 		assertFilesPushedToRef(t, fake.X, repoPath, gerritPath, expectedRef, files)
 	}
+	{
+		// Test with reviewers, hashtags, labels and wip.
+		review, err := newReview(fake.X, project.Project{}, gerrit.CLOpts{
+			Hashtags:  []string{"tag1", "tag2"},
+			Labels:    []gerrit.LabelVote{{Name: "Commit-Queue", Value: 1}, {Name: "Code-Review", Value: -1}},
+			Remote:    gerritPath,
+			Reviewers: parseEmails("reviewer5"),
+			WIP:       true,
+		})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var stdout bytes.Buffer
+		verbose := true
+		review.jirix = fake.X.Clone(tool.ContextOpts{Stdout: &stdout, Verbose: &verbose})
+		if err := review.send(); err != nil {
+			t.Fatalf("failed to send a review: %v", err)
+		}
+		expectedRef := gerrit.Reference(review.CLOpts)
+		if want := "refs/for/master%r=reviewer5@google.com,hashtag=tag1,hashtag=tag2,l=Commit-Queue+1,l=Code-Review-1,wip"; expectedRef != want {
+			t.Errorf("got reference %q, want %q", expectedRef, want)
+		}
+		if want := "Pushing HEAD:" + expectedRef + " to " + gerritPath + "\n"; !strings.Contains(stdout.String(), want) {
+			t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+		}
+		assertFilesPushedToRef(t, fake.X, repoPath, gerritPath, expectedRef, files)
+	}
+}
+
+// TestCLMailLabelFlags checks that invalid -hashtag and -label values are
+// rejected when the flags are parsed.
+func TestCLMailLabelFlags(t *testing.T) {
+	var labels labelVotes
+	for _, value := range []string{"Code-Review=+2", "Commit-Queue=1", "Verified=-1"} {
+		if err := labels.Set(value); err != nil {
+			t.Errorf("Set(%q) failed: %v", value, err)
+		}
+	}
+	if got, want := labels.String(), "Code-Review+2,Commit-Queue+1,Verified-1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, value := range []string{"Code-Review", "Code-Review=", "Code-Review=yes", "=1", "Code Review=1"} {
+		if err := labels.Set(value); err == nil {
+			t.Errorf("Set(%q) did not fail", value)
+		}
+	}
+	var hashtags hashtagList
+	for _, value := range []string{"", "a,b", "a b", "50%"} {
+		if err := hashtags.Set(value); err == nil {
+			t.Errorf("Set(%q) did not fail", value)
+		}
+	}
 }
 
 // TestSendReviewNoChangeID checks that review.send() correctly errors when
@@ -484,6 +879,74 @@ func TestEndToEnd(t *testing.T) {
 	assertFilesPushedToRef(t, fake.X, repoPath, gerritPath, expectedRef, files)
 }
 
+// TestOwners checks that reviews are requested from the owners listed in the
+// OWNERS files of the directories of the files changed by a CL, including
+// the old directories of renamed files.
+func TestOwners(t *testing.T) {
+	fake, repoPath, originPath, gerritPath, cleanup := setupTest(t, true)
+	defer cleanup()
+	s := fake.X.NewSeq()
+	chdir(t, fake.X, originPath)
+	files := map[string]string{
+		"a/OWNERS":      "# Owners of a.\na@example.com\n",
+		"a/b/file":      "file",
+		"c/OWNERS":      "include ../common/OWNERS\n",
+		"common/OWNERS": "c@example.com\ninclude /d/OWNERS\n",
+		"d/OWNERS":      "d\n",
+		"e/OWNERS":      "e@example.com\n",
+	}
+	for _, name := range []string{"a/OWNERS", "a/b/file", "c/OWNERS", "common/OWNERS", "d/OWNERS", "e/OWNERS"} {
+		if err := s.MkdirAll(filepath.Dir(name), 0755).Done(); err != nil {
+			t.Fatal(err)
+		}
+		commitFile(t, fake.X, name, files[name])
+	}
+	chdir(t, fake.X, repoPath)
+	git := gitutil.New(fake.X.NewSeq())
+	if err := git.Pull("origin", "master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.CreateAndCheckoutBranch("my-branch"); err != nil {
+		t.Fatal(err)
+	}
+	// Move a/b/file to c/file.
+	if err := s.Rename(filepath.Join("a", "b", "file"), filepath.Join("c", "file")).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.Remove(path.Join("a", "b", "file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.Add(path.Join("c", "file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.CommitWithMessage("move file"); err != nil {
+		t.Fatal(err)
+	}
+
+	review, err := newReview(fake.X, project.Project{}, gerrit.CLOpts{Remote: gerritPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTopicFlag, yesFlag = false, true
+	defer func() { yesFlag = false }()
+	if err := review.run(); err != nil {
+		t.Fatalf("run() failed: %v", err)
+	}
+	want := []string{"a@example.com", "c@example.com", "d@google.com"}
+	if got := review.CLOpts.Reviewers; !reflect.DeepEqual(got, want) {
+		t.Errorf("got reviewers %v, want %v", got, want)
+	}
+
+	// Include cycles are reported.
+	if err := s.WriteFile(filepath.Join("d", "OWNERS"), []byte("include ../c/OWNERS\n"), 0644).Done(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = newOwnersReader(fake.X, repoPath).findOwners([]string{"c/file"})
+	if err == nil || !strings.Contains(err.Error(), "c/OWNERS -> common/OWNERS -> d/OWNERS -> c/OWNERS") {
+		t.Errorf("got error %v, want include cycle error", err)
+	}
+}
+
 // TestLabelsInCommitMessage checks the labels are correctly processed
 // for the commit message.
 //
@@ -850,6 +1313,77 @@ func TestCLNew(t *testing.T) {
 	}
 }
 
+// TestCLNewBaseAndTopic checks that "jiri cl new" records the dependency
+// path for the -base flag and the Gerrit topic for the -topic flag.
+func TestCLNewBaseAndTopic(t *testing.T) {
+	fake, repoPath, originPath, gerritPath, cleanup := setupTest(t, true)
+	defer cleanup()
+	defer func() { baseFlag, newTopicFlag = "", "" }()
+
+	createCLWithFiles(t, fake.X, "feature1", "A")
+	if err := gitutil.New(fake.X.NewSeq()).CheckoutBranch("master"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	baseFlag = "feature1"
+	if err := newCL(fake.X, []string{"feature2"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	assertFilesExist(t, fake.X, []string{"A"})
+	baseFlag, newTopicFlag = "origin/master", "my-topic"
+	createCLWithFiles(t, fake.X, "feature3", "B")
+	assertFilesDoNotExist(t, fake.X, []string{"A"})
+
+	testCases := []struct {
+		branch string
+		data   []byte
+	}{
+		{
+			branch: "feature2",
+			data:   []byte("master\nfeature1"),
+		},
+		{
+			branch: "feature3",
+			data:   []byte("origin/master"),
+		},
+	}
+	s := fake.X.NewSeq()
+	for _, testCase := range testCases {
+		file, err := getDependencyPathFileName(fake.X, testCase.branch)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		data, err := s.ReadFile(file)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if bytes.Compare(data, testCase.data) != 0 {
+			t.Fatalf("unexpected data:\ngot\n%v\nwant\n%v", string(data), string(testCase.data))
+		}
+	}
+
+	// Check that the recorded topic is used by default, and that syncing a
+	// CL based on a remote branch fetches the remote branch.
+	chdir(t, fake.X, originPath)
+	commitFiles(t, fake.X, []string{"C"})
+	chdir(t, fake.X, repoPath)
+	review, err := newReview(fake.X, project.Project{}, gerrit.CLOpts{Remote: gerritPath})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := review.CLOpts.Topic, "my-topic"; got != want {
+		t.Fatalf("unexpected topic: got %v, want %v", got, want)
+	}
+	assertFilesExist(t, fake.X, []string{"B", "C"})
+	assertFilesDoNotExist(t, fake.X, []string{"A"})
+	review, err = newReview(fake.X, project.Project{}, gerrit.CLOpts{Remote: gerritPath, Topic: "other-topic"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, want := review.CLOpts.Topic, "other-topic"; got != want {
+		t.Fatalf("unexpected topic: got %v, want %v", got, want)
+	}
+}
+
 // TestDependentClsWithEditDelete exercises a previously observed failure case
 // where if a CL edits a file and a dependent CL deletes it, jiri cl mail after
 // the deletion failed with unrecoverable merge errors.
@@ -1039,6 +1573,134 @@ func TestCLSync(t *testing.T) {
 	}
 }
 
+// assertDependencyPath asserts that the dependency path recorded for the
+// given branch matches the given branches.
+func assertDependencyPath(t *testing.T, jirix *jiri.X, branch string, want ...string) {
+	file, err := getDependencyPathFileName(jirix, branch)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	data, err := jirix.NewSeq().ReadFile(file)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := strings.Split(string(data), "\n"); !reflect.DeepEqual(got, want) {
+		_, file, line, _ := runtime.Caller(1)
+		t.Fatalf("%s:%d: unexpected dependency path of %v: got %v, want %v", filepath.Base(file), line, branch, got, want)
+	}
+}
+
+// TestCLSyncRepair checks that "jiri cl sync" repairs dependency paths that
+// name branches deleted outside of jiri, only after confirmation or if
+// -repair is set.
+func TestCLSyncRepair(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	fake, _, _, _, cleanup := setupTest(t, true)
+	defer cleanup()
+	defer func() { repairFlag = false }()
+
+	createCLWithFiles(t, fake.X, "feature1", "A")
+	createCLWithFiles(t, fake.X, "feature2", "B")
+	createCLWithFiles(t, fake.X, "feature3", "C")
+	git := gitutil.New(fake.X.NewSeq())
+	if err := git.CheckoutBranch("master"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	commitFiles(t, fake.X, []string{"test"})
+	if err := git.CheckoutBranch("feature3"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Delete feature2 with plain git, leaving its metadata behind.
+	if err := git.DeleteBranch("feature2", gitutil.ForceOpt(true)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	assertDependencyPath(t, fake.X, "feature3", "master", "feature1", "feature2")
+
+	// Without confirmation, the dependency path is left alone.
+	var stdout bytes.Buffer
+	jirix := fake.X.Clone(tool.ContextOpts{Stdin: strings.NewReader("n\n"), Stdout: &stdout})
+	if err := syncCL(jirix); err == nil || !strings.Contains(err.Error(), "jiri cl sync -repair") {
+		t.Fatalf("got error %v, want an error suggesting -repair", err)
+	}
+	if got, want := stdout.String(), "no longer exist: feature2\n"; !strings.Contains(got, want) {
+		t.Errorf("got output %q, want it to contain %q", got, want)
+	}
+	assertDependencyPath(t, fake.X, "feature3", "master", "feature1", "feature2")
+
+	// After confirmation, feature2 is spliced out and feature3 is synced
+	// with the parent recorded for feature2.
+	jirix = fake.X.Clone(tool.ContextOpts{Stdin: strings.NewReader("y\n"), Stdout: &stdout})
+	if err := syncCL(jirix); err != nil {
+		t.Fatalf("%v", err)
+	}
+	assertDependencyPath(t, fake.X, "feature3", "master", "feature1")
+	assertFilesExist(t, fake.X, []string{"A", "B", "C", "test"})
+
+	// A fully broken dependency path is re-pointed at the remote branch.
+	if err := git.DeleteBranch("feature1", gitutil.ForceOpt(true)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := git.DeleteBranch("master", gitutil.ForceOpt(true)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	repairFlag = true
+	if err := syncCL(fake.X); err != nil {
+		t.Fatalf("%v", err)
+	}
+	assertDependencyPath(t, fake.X, "feature3", "origin/master")
+	if got, want := git.BranchExists("master"), false; got != want {
+		t.Errorf("master exists: got %v, want %v", got, want)
+	}
+}
+
+// TestCLChain checks that "jiri cl chain" prints the dependency path of the
+// current branch with the merge status of each branch.
+func TestCLChain(t *testing.T) {
+	fake, _, _, _, cleanup := setupTest(t, true)
+	defer cleanup()
+
+	createCLWithFiles(t, fake.X, "feature1", "A")
+	createCLWithFiles(t, fake.X, "feature2", "B")
+	git := gitutil.New(fake.X.NewSeq())
+	if err := git.CheckoutBranch("master"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	commitFiles(t, fake.X, []string{"test"})
+	if err := git.CheckoutBranch("feature2"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	chain := func() string {
+		var stdout bytes.Buffer
+		if err := printChain(fake.X.Clone(tool.ContextOpts{Stdout: &stdout})); err != nil {
+			t.Fatalf("%v", err)
+		}
+		return stdout.String()
+	}
+	want := `master: base
+feature1: 1 commit(s) behind master
+feature2: up to date with feature1
+`
+	if got := chain(); got != want {
+		t.Errorf("got chain\n%s\nwant\n%s", got, want)
+	}
+	if err := git.DeleteBranch("feature1", gitutil.ForceOpt(true)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want = `master: base
+feature1: missing
+feature2: ancestor feature1 is missing
+`
+	if got := chain(); got != want {
+		t.Errorf("got chain\n%s\nwant\n%s", got, want)
+	}
+}
+
 func TestMultiPart(t *testing.T) {
 	fake, cleanup := jiritest.NewFakeJiriRoot(t)
 	defer cleanup()
@@ -1335,3 +1997,32 @@ func TestMultiPart(t *testing.T) {
 	hasNoMetaData(rc)
 	testCommitMsgs("a1", projects[2])
 }
+
+// TestReviewTarget checks the precedence of the -host flag and the gerrithost
+// and gerritremote project attributes in choosing where CLs are sent.
+func TestReviewTarget(t *testing.T) {
+	tests := []struct {
+		project              project.Project
+		hostFlag             string
+		wantHost, wantRemote string
+	}{
+		{project.Project{Name: "p", Remote: "https://r.com/p", GerritHost: "https://g.com"}, "", "https://g.com", "https://g.com/p"},
+		{project.Project{Name: "p", Remote: "https://r.com/p", GerritHost: "https://g.com"}, "https://f.com", "https://f.com", "https://f.com/p"},
+		{project.Project{Name: "p", Remote: "https://r.com/p", GerritHost: "https://g.com", GerritRemote: "review"}, "", "https://g.com", "review"},
+		{project.Project{Name: "p", Remote: "https://r.com/p", GerritHost: "https://g.com", GerritRemote: "review"}, "https://f.com", "https://f.com", "https://f.com/p"},
+		{project.Project{Name: "p", Remote: "https://r.com/p"}, "https://f.com", "https://f.com", "https://f.com/p"},
+	}
+	for _, test := range tests {
+		host, remote, err := reviewTarget(test.project, test.hostFlag)
+		if err != nil {
+			t.Errorf("%+v: %v", test, err)
+			continue
+		}
+		if got := host.String(); got != test.wantHost || remote != test.wantRemote {
+			t.Errorf("%+v: got (%v, %v), want (%v, %v)", test, got, remote, test.wantHost, test.wantRemote)
+		}
+	}
+	if _, _, err := reviewTarget(project.Project{Name: "p", Remote: "https://r.com/p"}, ""); err == nil {
+		t.Errorf("expected an error for a project without a gerrit host")
+	}
+}
@@ -8,10 +8,27 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"runtime"
 
+	"v.io/jiri"
+	"v.io/jiri/errkind"
+	"v.io/jiri/gitutil"
 	"v.io/jiri/tool"
 	"v.io/x/lib/cmdline"
+	"v.io/x/lib/timing"
+)
+
+var (
+	timeJSONFlag         bool
+	timeFileFlag         string
+	errorJSONFlag        string
+	credentialHelperFlag credentialHelper
 )
 
 func init() {
@@ -19,10 +36,131 @@ func init() {
 
 	cmdRoot = newCmdRoot()
 	tool.InitializeRunFlags(&cmdRoot.Flags)
+	tool.InitializeProjectFlags(&cmdRoot.Flags)
+	cmdRoot.Flags.BoolVar(&timeJSONFlag, "time-json", false, "With -time, dump the timing information as JSON, including the category of each interval.")
+	cmdRoot.Flags.StringVar(&timeFileFlag, "time-file", "", "Write the timing information as JSON to the given file before exiting the program.")
+	cmdRoot.Flags.StringVar(&errorJSONFlag, "error-json", "", `If the command fails, write the error, its kind and the exit code as JSON to the given file, e.g. for CI.  See "jiri help exit-codes".`)
+	cmdRoot.Flags.Var(&credentialHelperFlag, "credential-helper", "Git credential helper to use for all git commands, e.g. for bots that clone private repositories over HTTPS.  Overrides $"+gitutil.CredentialHelperEnv+".")
+}
+
+// credentialHelper is the value of the -credential-helper flag.  Setting it
+// also sets gitutil.CredentialHelperEnv, so that the helper is used by every
+// git command run by jiri and by its subcommands.
+type credentialHelper string
+
+func (h *credentialHelper) String() string {
+	return string(*h)
+}
+
+func (h *credentialHelper) Set(value string) error {
+	*h = credentialHelper(value)
+	return os.Setenv(gitutil.CredentialHelperEnv, value)
 }
 
 func main() {
-	cmdline.Main(cmdRoot)
+	if len(os.Args) > 1 && os.Args[1] == completeCommand {
+		printCompletions(os.Stdout, os.Args[2:])
+		return
+	}
+	addConfigRunners([]*cmdline.Command{cmdRoot})
+	// Errors reading the config are reported when the command is run.
+	config, _ := jiri.LoadConfig(jiri.FindRoot())
+	args, err := expandAliases(cmdRoot, config, os.Args[1:], os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	os.Args = append(os.Args[:1], args...)
+	printSuggestions(cmdRoot, config, args, os.Stderr)
+
+	// The following mirrors cmdline.Main, which only dumps the timing
+	// information as text.
+	env := cmdline.EnvFromOS()
+	if env.Timer != nil && len(env.Timer.Intervals) > 0 {
+		env.Timer.Intervals[0].Name = cmdRoot.Name
+		if prefix := env.Vars["CMDLINE_PREFIX"]; prefix != "" {
+			env.Timer.Intervals[0].Name = prefix + " " + cmdRoot.Name
+		}
+	}
+	err = cmdline.ParseAndRun(cmdRoot, env, args)
+	code := commandExitCode(err, env.Stderr)
+	if err := writeErrorJSON(err, code); err != nil {
+		fmt.Fprintf(env.Stderr, "ERROR: %v\n", err)
+	}
+	if err := writeTiming(env); err != nil {
+		if code2 := cmdline.ExitCode(err, env.Stderr); code == 0 {
+			code = code2
+		}
+	}
+	os.Exit(code)
+}
+
+// commandExitCode returns the exit code for the error returned by a command, and
+// prints the error, like cmdline.ExitCode.  Errors classified by the errkind
+// package exit with the code of their kind, and other errors with code 1; see
+// "jiri help exit-codes".  With -v, the kind of the error is printed too.
+func commandExitCode(err error, w io.Writer) int {
+	if _, ok := err.(cmdline.ErrExitCode); ok || err == nil {
+		return cmdline.ExitCode(err, w)
+	}
+	kind := errkind.Of(err)
+	fmt.Fprintf(w, "ERROR: %v\n", err)
+	if tool.VerboseFlag {
+		fmt.Fprintf(w, "ERROR KIND: %v (exit code %d)\n", kind, kind.ExitCode())
+	}
+	return kind.ExitCode()
+}
+
+// writeErrorJSON writes the error returned by a command, with its kind and
+// exit code, to the file named by the -error-json flag.  Nothing is written if
+// the command succeeded.
+func writeErrorJSON(err error, code int) error {
+	if errorJSONFlag == "" || err == nil {
+		return nil
+	}
+	data, err2 := json.MarshalIndent(struct {
+		Error    string `json:"error"`
+		Kind     string `json:"kind"`
+		ExitCode int    `json:"exitCode"`
+	}{err.Error(), errkind.Of(err).String(), code}, "", "  ")
+	if err2 != nil {
+		return err2
+	}
+	return ioutil.WriteFile(errorJSONFlag, append(data, '\n'), 0644)
+}
+
+// writeTiming dumps the timing information collected by the timer of env,
+// as requested by the -time, -time-json and -time-file flags.
+func writeTiming(env *cmdline.Env) error {
+	timeFlag := false
+	if f := flag.Lookup("time"); f != nil {
+		timeFlag = f.Value.String() == "true"
+	}
+	if env.Timer == nil || (!timeFlag && timeFileFlag == "") {
+		return nil
+	}
+	env.Timer.Finish()
+	if timeFileFlag != "" {
+		file, err := os.Create(timeFileFlag)
+		if err != nil {
+			return err
+		}
+		if err := jiri.WriteTimerJSON(file, env.Timer); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	if !timeFlag {
+		return nil
+	}
+	if timeJSONFlag {
+		return jiri.WriteTimerJSON(env.Stderr, env.Timer)
+	}
+	p := timing.IntervalPrinter{Zero: env.Timer.Zero}
+	return p.Print(env.Stderr, env.Timer.Intervals, env.Timer.Now())
 }
 
 // cmdRoot represents the root of the jiri tool.
@@ -40,22 +178,68 @@ Command jiri is a multi-purpose tool for multi-repo development.
 `,
 		LookPath: true,
 		Children: []*cmdline.Command{
+			cmdAudit,
+			cmdBootstrap,
 			cmdCL,
+			cmdCompletion,
+			cmdConfig,
 			cmdImport,
 			cmdProfile,
 			cmdProject,
 			cmdRebuild,
+			cmdRollbackTools,
+			cmdServer,
 			cmdSnapshot,
+			cmdStatus,
+			cmdTest,
+			cmdTools,
 			cmdUpdate,
+			cmdUpdateHistory,
 			cmdWhich,
 		},
 		Topics: []cmdline.Topic{
+			topicExitCodes,
 			topicFileSystem,
 			topicManifest,
 		},
 	}
 }
 
+var topicExitCodes = cmdline.Topic{
+	Name:  "exit-codes",
+	Short: "Description of the exit codes of jiri",
+	Long: `
+The exit code of a failed jiri command tells the kind of the failure, so that
+scripts can e.g. retry after network failures but report broken manifests:
+
+ 1  unknown failure
+ 2  usage error, e.g. wrong arguments or flags
+ 3  network failure, e.g. a remote that cannot be reached; retrying may help
+ 4  manifest error, e.g. an invalid manifest file, a duplicate project or an
+    import cycle
+ 5  git conflict, e.g. a changelist that conflicts with the remote branch, or
+    a project whose new remote has unrelated history
+ 6  tool build failure, e.g. a compile error or a Go toolchain that is too old
+
+"jiri update -manifest-only" exits with code 10, rather than 0, if the
+manifest changed since the latest update; see "jiri help update".
+
+Failures that are not classified exit with code 1, and commands run by jiri,
+such as external subcommands, keep their own exit codes.  With -v, the kind of
+the failure is printed along with the error, and -error-json writes the error,
+its kind and the exit code as JSON to a file:
+
+  {
+    "error": "...",
+    "kind": "network",
+    "exitCode": 3
+  }
+
+The kinds are "unknown", "usage", "network", "manifest", "git-conflict" and
+"tool-build".
+`,
+}
+
 var topicFileSystem = cmdline.Topic{
 	Name:  "filesystem",
 	Short: "Description of jiri file system layout",
@@ -67,6 +251,8 @@ looks like this:
  [root]                              # root directory (name picked by user)
  [root]/.jiri_root                   # root metadata directory
  [root]/.jiri_root/bin               # contains tool binaries (jiri, etc.)
+ [root]/.jiri_root/config            # default flag values
+ [root]/.jiri_root/scan-ignore       # directories not scanned for projects
  [root]/.jiri_root/update_history    # contains history of update snapshots
  [root]/.manifest                    # contains jiri manifests
  [root]/[project1]                   # project directory (name picked by user)
@@ -106,16 +292,19 @@ tools under that [root] directory will be updated.
 The shim script is located at [root]/release/go/src/v.io/jiri/scripts/jiri
 
 2) Direct binary.  This is the jiri binary, containing all of the actual jiri
-tool logic.  The binary requires the JIRI_ROOT environment variable to point to
-the [root] directory.
+tool logic.  The binary finds the [root] directory the same way as the shim
+script, and sets the JIRI_ROOT environment variable to its location for the
+commands it runs.  If the JIRI_ROOT environment variable is set, but the current
+working directory is inside another [root] directory, the binary uses JIRI_ROOT
+and prints a warning.  Run "jiri which" to show the [root] directory and how it
+was found.
 
 Note that if you have multiple [root] directories on your file system, you must
-remember to run the jiri binary corresponding to the setting of your JIRI_ROOT
-environment variable.  Things may fail if you mix things up, since the jiri
-binary is updated with each call to "jiri update", and you may encounter version
-mismatches between the jiri binary and the various metadata files or other
-logic.  This is the reason the shim script is recommended over running the
-binary directly.
+remember to run the jiri binary corresponding to the [root] directory you work
+in.  Things may fail if you mix things up, since the jiri binary is updated with
+each call to "jiri update", and you may encounter version mismatches between the
+jiri binary and the various metadata files or other logic.  This is the reason
+the shim script is recommended over running the binary directly.
 
 The jiri binary is located at [root]/.jiri_root/bin/jiri
 `,
@@ -156,6 +345,7 @@ Manifests have the following XML schema:
              gerrithost="https://myorg-review.googlesource.com"
              githooks="path/to/githooks-dir"
              runhook="path/to/runhook-script"
+             hookenv="clean"
     />
     ...
   </projects>
@@ -166,8 +356,21 @@ Manifests have the following XML schema:
     />
     ...
   </tools>
+  <postupdates>
+    <postupdate command="make compile_commands.json"
+                project="my-project"
+                failok="true"
+    />
+    ...
+  </postupdates>
 </manifest>
 
+The optional "version" attribute of the <manifest> tag is the version of the
+manifest format, of the form "<major>" or "<major>.<minor>".  Jiri refuses
+manifests with a newer major version than it supports, and warns about the
+tags and attributes it does not know, which it ignores.  Manifests without a
+version are always accepted.
+
 The <import> and <localimport> tags can be used to share common projects and
 tools across multiple manifests.
 
@@ -191,6 +394,18 @@ the manifest remote, then the "name" attribute of on the <import> tag should
 match the "name" attribute on the <project>.  Otherwise, jiri will clone the
 manifest repository on every update.
 
+* remotebranch (optional) - The remote branch of the manifest repository to
+track.  Defaults to "master".  The "remotebranch" attribute is ignored if
+"revision" is specified.
+
+* revision (optional) - The specific revision (usually a git SHA) of the
+manifest repository to use.  This can be used to freeze the imported manifest
+at a known-good state.  If "revision" is specified then the "remotebranch"
+attribute is ignored.
+
+* verify (optional) - If "signature", the revisions of the manifest
+repository must be signed, as for projects.
+
 The <project> tags describe the projects to sync, and what state they should
 sync to, accoring to the following attributes:
 
@@ -208,13 +423,51 @@ Currently "git" is the default and only supported protocol.
 Defaults to "master".  The "remotebranch" attribute is ignored if "revision"
 is specified.
 
+* fallbackbranch (optional) - The remote branch that the project will sync to
+while the "remotebranch" does not exist in the remote, e.g. because it was
+deleted or renamed upstream.  Without it, "jiri update" fails for such a
+project, listing the branches of the remote, but still updates the other
+projects.
+
 * revision (optional) - The specific revision (usually a git SHA) that the
 project will sync to.  If "revision" is  specified then the "remotebranch"
 attribute is ignored.
 
+* verify (optional) - If "signature", "jiri update" only advances the project
+to revisions whose commit, or tag if the revision is an annotated tag, is
+signed by one of the trusted keys, and fails otherwise.  The fingerprints of
+the trusted keys are listed one per line in the files in
+$JIRI_ROOT/.jiri_root/keys, and the keys must be in the keyring of gpg.
+
+* clonefilter (optional) - The filter, e.g. "blob:none" or "tree:0", passed to
+"git clone --filter" to create a partial clone of the project.  The objects
+omitted by the filter are fetched from the remote on demand, e.g. when an older
+revision is checked out.  Only supported with the "git" protocol.
+
+* sparsecheckout (optional) - A comma-separated list of directories, relative
+to the root of the project, that the working tree of the project is restricted
+to, along with the files at the root, using a cone-mode "git sparse-checkout".
+Useful for huge repositories of which only a few directories are needed.  "jiri
+update" applies changes to the list, and checks out the whole tree again when
+the attribute is removed.  Only supported with the "git" protocol.
+
+* lfs (optional) - If "true", the project stores files with Git LFS, and "jiri
+update" installs the LFS filters in the project and fetches the contents of the
+LFS files after cloning or updating it, so that the working tree does not
+contain LFS pointer files.  Requires git-lfs to be installed.
+
 * gerrithost (optional) - The url of the Gerrit host for the project.  If
 specified, then running "jiri cl mail" will upload a CL to this Gerrit host.
 
+* gerritremote (optional) - The name of the git remote that "jiri cl mail"
+pushes CLs to.  If specified, "jiri update" configures this remote to point at
+the project on the Gerrit host.  Requires "gerrithost" to be specified.
+
+* reviewhost (optional) - The url of the repository of the project on GitHub
+or a GitHub Enterprise host, such as "https://github.com/myorg/myproject".  If
+specified, then running "jiri cl mail" will send a pull request to this
+repository.  Cannot be specified with "gerrithost".
+
 * githooks (optional) - The path (relative to $JIRI_ROOT) of a directory
 containing git hooks that will be installed in the projects .git/hooks
 directory during each update.
@@ -222,6 +475,31 @@ directory during each update.
 * runhook (optional) - The path (relate to $JIRI_ROOT) of a script that will be
 run during each update.
 
+* hookenv (optional) - The environment the runhook script runs with: "inherit",
+the default, for the environment of jiri, or "clean" for a minimal environment
+with only the PATH and HOME variables of jiri, so that the script behaves the
+same on all machines.  In both cases, JIRI_ROOT, JIRI_PROJECT_NAME and
+JIRI_PROJECT_PATH are set.
+
+* hookprofiles (optional) - A comma-separated list of the profiles whose
+environment variables are merged into the environment of the runhook script.
+
+* requiresprofiles (optional) - A comma-separated list of the profiles that must
+be installed for the native target before the runhook script runs, e.g.
+"go,base".  The script is skipped if they are not, unless "jiri update
+-install-missing-profiles" installs them.
+
+* exclude (optional) - If "true", the project is a stub that removes the
+projects with the same name, and the same remote if "remote" is specified,
+from the manifest, e.g. to drop projects of an imported manifest that are
+replaced locally.  The projects are removed whether they are declared before
+or after the stub, in any manifest file, and the other attributes of the stub,
+including "groups", are ignored.  The names of the projects of an import with
+a "root" are prefixed with the root, so a stub outside of that import must
+name them "<root>/<name>".  Local copies of removed projects are treated like
+those of any project that is not in the manifest, and are deleted by "jiri
+update -gc".
+
 The <tool> tags describe the tools that will be compiled and installed in
 $JIRI_ROOT/.jiri_root/bin after each update.  The tools must be written in go,
 and are identified by their package name and the project that contains their
@@ -235,5 +513,33 @@ code.  They are configured via the following attributes:
 
 * project (required) - The name of the project that contains the source code
   for the tool.
+
+* goversion (optional) - The oldest Go version the tool can be built with,
+  e.g. "1.6".  If the Go toolchain the tools are built with is older, "jiri
+  update" fails before updating any project.  The toolchain is the one given
+  by the -go-root flag, or the one installed by the "go" profile, or the "go"
+  binary in PATH, in this order.
+
+* os, arch (optional) - Comma-separated lists of the operating systems and
+  architectures, as in GOOS and GOARCH, that the tool is built on, e.g.
+  os="linux" for a tool that links a Linux-only library.  On other hosts, the
+  tool is skipped, and reported as such in the summary of "jiri update".
+  Each tool is built separately, so a tool that fails to build does not
+  prevent the others from being installed; "jiri update" fails only if a tool
+  that is built on the host failed.
+
+The <postupdate> tags describe commands that are run, in the order they are
+declared, at the end of each "jiri update" and "jiri snapshot checkout", once
+the tools are installed.  They keep invariants that span projects, such as
+generated files, and are configured via the following attributes:
+
+* command (required) - The command line to run, with "sh -c".  JIRI_ROOT is
+  set in its environment.
+
+* project (optional) - The name of the project whose directory the command is
+  run in.  Defaults to the jiri root.
+
+* failok (optional) - If "true", a failure of the command is reported, but
+  does not fail the update.
 `,
 }
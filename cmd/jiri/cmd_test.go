@@ -0,0 +1,104 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/jiri/project"
+	"v.io/jiri/tool"
+	"v.io/x/lib/cmdline"
+)
+
+func TestExitCodes(t *testing.T) {
+	// Induce failures of "jiri update" in fake roots.
+	update := func(setup func(fake *jiritest.FakeJiriRoot) error) error {
+		fake, cleanup := jiritest.NewFakeJiriRoot(t)
+		defer cleanup()
+		if err := setup(fake); err != nil {
+			t.Fatal(err)
+		}
+		var stdout, stderr bytes.Buffer
+		fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+		return runUpdate(fake.X, nil)
+	}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"unclassified", errors.New("failed"), 1},
+		{"usage", cmdline.ErrUsage, 2},
+		{"exit code", cmdline.ErrExitCode(42), 42},
+		{"network", update(func(fake *jiritest.FakeJiriRoot) error {
+			return fake.AddProject(project.Project{
+				Name:   "unreachable",
+				Path:   "unreachable",
+				Remote: "http://127.0.0.1:1/unreachable.git",
+			})
+		}), 3},
+		{"manifest", update(func(fake *jiritest.FakeJiriRoot) error {
+			return ioutil.WriteFile(fake.X.JiriManifestFile(), []byte("<manifest><imports>"), 0644)
+		}), 4},
+		{"git conflict", changeConflictError{localBranch: "feature", remoteBranch: "master"}, 5},
+		{"tool build", update(func(fake *jiritest.FakeJiriRoot) error {
+			return fake.AddTool(project.Tool{
+				Name:    "tool",
+				Package: "v.io/x/nonexistent",
+				Project: "manifest",
+			})
+		}), 6},
+	}
+	for _, test := range tests {
+		var stderr bytes.Buffer
+		if got := commandExitCode(test.err, &stderr); got != test.want {
+			t.Errorf("%s: got exit code %d, want %d for error: %v", test.name, got, test.want, test.err)
+		}
+	}
+
+	// With -v, the kind of the error is printed.
+	tool.VerboseFlag = true
+	defer func() { tool.VerboseFlag = false }()
+	var stderr bytes.Buffer
+	commandExitCode(changeConflictError{}, &stderr)
+	if got, want := stderr.String(), "ERROR KIND: git-conflict (exit code 5)"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+
+	// The error is written as JSON with -error-json.
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	errorJSONFlag = filepath.Join(dir, "error.json")
+	defer func() { errorJSONFlag = "" }()
+	if err := writeErrorJSON(changeConflictError{}, 5); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(errorJSONFlag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Error    string
+		Kind     string
+		ExitCode int
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != "git-conflict" || got.ExitCode != 5 || !strings.Contains(got.Error, "conflicts with the remote") {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/profiles"
+	"v.io/jiri/project"
+	"v.io/x/lib/cmdline"
+)
+
+// completeCommand is the hidden command that the completion scripts run to
+// complete the names of projects, profiles and snapshot labels.  It is
+// handled before the command line is parsed, so that it is not listed by
+// "jiri help".
+const completeCommand = "__complete"
+
+// The kinds of candidates printed by "jiri __complete <kind>".
+const (
+	completeProjects       = "projects"
+	completeProfiles       = "profiles"
+	completeSnapshotLabels = "snapshot-labels"
+)
+
+// cmdCompletion represents the "jiri completion" command.
+var cmdCompletion = &cmdline.Command{
+	Runner: cmdline.RunnerFunc(runCompletion),
+	Name:   "completion",
+	Short:  "Print a shell completion script",
+	Long: `
+Command "completion" prints a script that completes the subcommands and flags
+of jiri in the given shell, along with the names of projects, profiles and
+snapshot labels for the commands that take them.  The names are listed by the
+jiri binary, quickly and without network access, from the jiri root of the
+current $JIRI_ROOT.  $JIRI_ROOT need not be set to generate the script.
+
+To enable completion, source the script from your shell startup file, e.g.:
+
+  # ~/.bashrc
+  source <(jiri completion bash)
+
+  # ~/.zshrc
+  source <(jiri completion zsh)
+`,
+	ArgsName: "<shell>",
+	ArgsLong: `<shell> is "bash" or "zsh".`,
+}
+
+func runCompletion(env *cmdline.Env, args []string) error {
+	if len(args) != 1 {
+		return env.UsageErrorf("wrong number of arguments")
+	}
+	switch args[0] {
+	case "bash":
+		return writeCompletionScript(env.Stdout, cmdRoot, false)
+	case "zsh":
+		return writeCompletionScript(env.Stdout, cmdRoot, true)
+	}
+	return env.UsageErrorf("unsupported shell %q", args[0])
+}
+
+// completionEntry describes the completions of a command.
+type completionEntry struct {
+	// path is the path of the command, e.g. "jiri project clean".
+	path string
+	// words are the names of the subcommands and flags of the command.
+	words []string
+	// dynamic is the kind of the candidates that "jiri __complete" prints
+	// for the arguments of the command, or "" if there are none.
+	dynamic string
+}
+
+// completionEntries walks the command tree rooted at the last command of the
+// given path, and returns the completions of the commands.
+func completionEntries(path []*cmdline.Command) []completionEntry {
+	cmd := path[len(path)-1]
+	names := []string{}
+	for _, c := range path {
+		names = append(names, c.Name)
+	}
+	entry := completionEntry{
+		path:    strings.Join(names, " "),
+		dynamic: dynamicCompletion(cmd.ArgsName),
+	}
+	for _, child := range cmd.Children {
+		entry.words = append(entry.words, child.Name)
+	}
+	if len(cmd.Children) > 0 {
+		// The cmdline package adds a "help" subcommand to commands with
+		// subcommands.
+		entry.words = append(entry.words, "help")
+	}
+	for _, name := range completionFlags(path) {
+		entry.words = append(entry.words, "-"+name)
+	}
+	entries := []completionEntry{entry}
+	for _, child := range cmd.Children {
+		entries = append(entries, completionEntries(append(path[:len(path):len(path)], child))...)
+	}
+	return entries
+}
+
+// completionFlags returns the names of the flags allowed for the last command
+// in the given path, which include the flags of its ancestors, as in the
+// cmdline package.
+func completionFlags(path []*cmdline.Command) []string {
+	set := map[string]bool{}
+	add := func(flags *flag.FlagSet) {
+		flags.VisitAll(func(f *flag.Flag) { set[f.Name] = true })
+	}
+	cmd := path[len(path)-1]
+	add(&cmd.Flags)
+	if !cmd.DontInheritFlags {
+		for p := len(path) - 2; p >= 0; p-- {
+			if path[p].DontPropagateFlags {
+				break
+			}
+			add(&path[p].Flags)
+			if path[p].DontInheritFlags {
+				break
+			}
+		}
+	}
+	names := []string{}
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dynamicCompletion returns the kind of the candidates for arguments with the
+// given name, or "" if they cannot be completed.
+func dynamicCompletion(argsName string) string {
+	switch {
+	case strings.Contains(argsName, "<project"):
+		return completeProjects
+	case strings.Contains(argsName, "<profiles>"):
+		return completeProfiles
+	case strings.Contains(argsName, "<label"), strings.Contains(argsName, "<snapshot>"):
+		return completeSnapshotLabels
+	}
+	return ""
+}
+
+// writeCompletionScript writes a bash script, or a zsh script if zsh is set,
+// that completes the commands in the tree rooted at root.  The zsh script
+// uses the bash completion function through bashcompinit.
+func writeCompletionScript(w io.Writer, root *cmdline.Command, zsh bool) error {
+	entries := completionEntries([]*cmdline.Command{root})
+	var commands []string
+	for _, entry := range entries[1:] {
+		commands = append(commands, fmt.Sprintf("%q", entry.path))
+	}
+	var buf bytes.Buffer
+	shell := "bash"
+	if zsh {
+		shell = "zsh"
+	}
+	fmt.Fprintf(&buf, "# %s completion for %s, generated by \"%s completion %s\".\n", shell, root.Name, root.Name, shell)
+	if zsh {
+		buf.WriteString("autoload -U +X bashcompinit && bashcompinit\n")
+	}
+	fn := "_" + root.Name
+	fmt.Fprintf(&buf, "%s() {\n", fn)
+	fmt.Fprintf(&buf, "  local cur=\"${COMP_WORDS[COMP_CWORD]}\" cmd=%q words=\"\" dynamic=\"\" i\n", root.Name)
+	buf.WriteString("  for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	buf.WriteString("    case \"$cmd ${COMP_WORDS[i]}\" in\n")
+	fmt.Fprintf(&buf, "      %s) cmd=\"$cmd ${COMP_WORDS[i]}\" ;;\n", strings.Join(commands, "|"))
+	buf.WriteString("    esac\n")
+	buf.WriteString("  done\n")
+	buf.WriteString("  case \"$cmd\" in\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&buf, "    %q) words=%q", entry.path, strings.Join(entry.words, " "))
+		if entry.dynamic != "" {
+			fmt.Fprintf(&buf, "; dynamic=%q", entry.dynamic)
+		}
+		buf.WriteString(" ;;\n")
+	}
+	buf.WriteString("  esac\n")
+	buf.WriteString("  if [[ -n \"$dynamic\" && \"$cur\" != -* ]]; then\n")
+	fmt.Fprintf(&buf, "    words=\"$words $(%s %s \"$dynamic\" 2>/dev/null)\"\n", root.Name, completeCommand)
+	buf.WriteString("  fi\n")
+	buf.WriteString("  COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	buf.WriteString("}\n")
+	fmt.Fprintf(&buf, "complete -F %s %s\n", fn, root.Name)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// printCompletions prints the candidates of the kind given by args, one per
+// line, for "jiri __complete <kind>".  Nothing is printed if the candidates
+// cannot be determined, e.g. outside of a jiri root, so that completion never
+// prints errors into the shell.
+func printCompletions(w io.Writer, args []string) {
+	if len(args) != 1 {
+		return
+	}
+	env := cmdline.EnvFromOS()
+	env.Stdout, env.Stderr = ioutil.Discard, ioutil.Discard
+	jirix, err := jiri.NewX(env)
+	if err != nil {
+		return
+	}
+	candidates, err := completionCandidates(jirix, args[0])
+	if err != nil {
+		return
+	}
+	for _, candidate := range candidates {
+		fmt.Fprintln(w, candidate)
+	}
+}
+
+// completionCandidates returns the sorted candidates of the given kind.  Only
+// local state is read, so that completion is fast and works offline.
+func completionCandidates(jirix *jiri.X, kind string) ([]string, error) {
+	var candidates []string
+	switch kind {
+	case completeProjects:
+		projects, err := project.LocalProjects(jirix, project.FastScan)
+		if err != nil {
+			return nil, err
+		}
+		names := map[string]bool{}
+		for _, p := range projects {
+			if !names[p.Name] {
+				names[p.Name] = true
+				candidates = append(candidates, p.Name)
+			}
+		}
+	case completeProfiles:
+		pdb := profiles.NewDB()
+		if err := pdb.Read(jirix, jirix.ProfilesDBDir()); err != nil {
+			return nil, err
+		}
+		candidates = pdb.Names()
+	case completeSnapshotLabels:
+		labels, err := snapshotLabels(filepath.Join(jirix.Root, defaultSnapshotDir))
+		if err != nil {
+			return nil, err
+		}
+		candidates = labels
+	default:
+		return nil, fmt.Errorf("unknown kind of completion %q", kind)
+	}
+	sort.Strings(candidates)
+	return candidates, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"v.io/jiri"
+	"v.io/jiri/jiritest"
+	"v.io/jiri/profiles"
+	"v.io/jiri/project"
+)
+
+func TestCompletionScript(t *testing.T) {
+	for _, zsh := range []bool{false, true} {
+		var script bytes.Buffer
+		if err := writeCompletionScript(&script, cmdRoot, zsh); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			// Subcommands and flags, including inherited ones.
+			`"jiri") words="audit bootstrap cl completion config`,
+			`"jiri project clean") words="-allow-nested-root `,
+			`"jiri project clean") words=`,
+			// Dynamic completions.
+			`dynamic="projects"`,
+			`dynamic="profiles"`,
+			`dynamic="snapshot-labels"`,
+			`$(jiri __complete "$dynamic" 2>/dev/null)`,
+			"complete -F _jiri jiri",
+		} {
+			if !strings.Contains(script.String(), want) {
+				t.Errorf("zsh=%v: script does not contain %q:\n%s", zsh, want, script.String())
+			}
+		}
+		for _, line := range strings.Split(script.String(), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), `"jiri update")`) {
+				if !strings.Contains(line, " -gc ") || !(strings.Contains(line, " -v ") || strings.Contains(line, " -v\"")) {
+					t.Errorf("zsh=%v: got %q, want the flags of update and of jiri", zsh, line)
+				}
+			}
+		}
+		if got := strings.Contains(script.String(), "bashcompinit"); got != zsh {
+			t.Errorf("zsh=%v: got bashcompinit %v", zsh, got)
+		}
+		if zsh {
+			continue
+		}
+		// Complete a few command lines with bash, if it is installed.
+		bash, err := exec.LookPath("bash")
+		if err != nil {
+			continue
+		}
+		for line, want := range map[string]string{
+			"jiri upd":               "update update-history",
+			"jiri project cl":        "clean",
+			"jiri -v snapshot che":   "checkout",
+			"jiri update -go":        "-go-root -googlesource-hosts",
+			"jiri project clean -br": "-branches",
+		} {
+			words := strings.Fields(line)
+			cmd := exec.Command(bash, "-c", script.String()+`
+COMP_WORDS=(`+line+`)
+COMP_CWORD=`+strconv.Itoa(len(words)-1)+`
+_jiri
+echo "${COMPREPLY[*]}"`)
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("%q: %v\n%s", line, err, output)
+			}
+			if got := strings.TrimSpace(string(output)); got != want {
+				t.Errorf("%q: got completions %q, want %q", line, got, want)
+			}
+		}
+	}
+}
+
+func TestCompletionCandidates(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	if err := fake.CreateRemoteProject("p1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddProject(project.Project{
+		Name:   "p1",
+		Path:   "p1",
+		Remote: fake.Projects["p1"],
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	pdb := profiles.NewDB()
+	target, _ := profiles.NewTarget("cpu-os@1")
+	pdb.InstallProfile("i", "base", "")
+	if err := pdb.AddProfileTarget("i", "base", target); err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.Write(fake.X, "i", fake.X.ProfilesDBDir()); err != nil {
+		t.Fatal(err)
+	}
+	snapshotDir := filepath.Join(fake.X.Root, defaultSnapshotDir)
+	if err := os.MkdirAll(filepath.Join(snapshotDir, "labels", "stable"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(snapshotDir, "labels", "stable", "s1"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setLabelSymlink(fake.X, snapshotDir, "stable", filepath.Join("labels", "stable", "s1")); err != nil {
+		t.Fatal(err)
+	}
+
+	for kind, want := range map[string][]string{
+		completeProjects:       {"manifest", "p1"},
+		completeProfiles:       {"i:base"},
+		completeSnapshotLabels: {"stable"},
+	} {
+		got, err := completionCandidates(fake.X, kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", kind, got, want)
+		}
+	}
+
+	// Nothing is printed outside of a jiri root.
+	root := os.Getenv(jiri.RootEnv)
+	defer os.Setenv(jiri.RootEnv, root)
+	for _, dir := range []string{"", filepath.Join(fake.X.Root, "nonexistent")} {
+		os.Setenv(jiri.RootEnv, dir)
+		for _, kind := range []string{completeProjects, completeProfiles, completeSnapshotLabels} {
+			var stdout bytes.Buffer
+			printCompletions(&stdout, []string{kind})
+			if stdout.Len() != 0 {
+				t.Errorf("%s: got %q outside of a jiri root, want no output", kind, stdout.String())
+			}
+		}
+	}
+}
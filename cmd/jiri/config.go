@@ -0,0 +1,360 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+	"v.io/jiri/profiles"
+	"v.io/jiri/profiles/profilesutil"
+	"v.io/x/lib/cmdline"
+)
+
+var userConfigFlag bool
+
+func init() {
+	cmdConfig.Flags.BoolVar(&userConfigFlag, "user", false, "Use the per-user config file rather than the config file of the jiri root.")
+}
+
+// cmdConfig represents the "jiri config" command.
+var cmdConfig = &cmdline.Command{
+	Name:  "config",
+	Short: "Manage default flag values",
+	Long: `
+Manage the default values of jiri flags.  Defaults are read from the config
+file of the jiri root, $JIRI_ROOT/.jiri_root/config, and from the per-user
+config file, $XDG_CONFIG_HOME/jiri/config or ~/.config/jiri/config, with values
+in the former taking precedence.
+
+Each line of a config file has the form <key>=<value>, where <key> is the name
+of a flag, optionally qualified by the names of the subcommands the flag
+belongs to, separated by dots.  For example, "color=false" disables color for
+all commands, while "cl.mail.remote-branch=release" only applies to "jiri cl
+mail".  Qualified keys take precedence over unqualified ones.
+
+Flags set on the command line always take precedence over config values, and
+environment variables that configure the same setting as a flag, such as
+$JIRI_PROFILE_MIRROR for the -mirror flag, take precedence over config values
+for that flag.
+
+Keys of the form alias.<name> define command aliases: "jiri <name> <args>" runs
+"jiri <value> <args>", where <value> is split into arguments at whitespace.
+For example, after "jiri config set alias.up 'update -gc'", "jiri up" runs
+"jiri update -gc".  An alias may expand to another alias, but not recursively,
+and aliases cannot shadow jiri commands.  With the -v flag, the expanded
+command line is printed before it is run.
+
+Keys of the form target-alias.<name> define profile target aliases: the
+-target flag of the profile and runp commands accepts <name> in place of the
+<arch>-<os>[@<version>] target that is the value of the key.  For example,
+after "jiri config set target-alias.rpi arm-linux@2", "jiri profile install
+-target=rpi" installs profiles for arm-linux@2.  The -target flag also accepts
+"native", for the architecture and operating system of the host.
+`,
+	Children: []*cmdline.Command{cmdConfigGet, cmdConfigList, cmdConfigSet},
+}
+
+// cmdConfigGet represents the "jiri config get" command.
+var cmdConfigGet = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runConfigGet),
+	Name:   "get",
+	Short:  "Print the value of a config key",
+	Long: `
+Print the value of the given config key.  Unless -user is set, the value is
+looked up in the merged per-user and jiri root configs.
+`,
+	ArgsName: "<key>",
+	ArgsLong: "<key> is the config key to print.",
+}
+
+// cmdConfigList represents the "jiri config list" command.
+var cmdConfigList = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runConfigList),
+	Name:   "list",
+	Short:  "List config keys and values",
+	Long: `
+List the config keys and values, one key=value pair per line.  Unless -user is
+set, the merged per-user and jiri root configs are listed.
+`,
+}
+
+// cmdConfigSet represents the "jiri config set" command.
+var cmdConfigSet = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runConfigSet),
+	Name:   "set",
+	Short:  "Set the value of a config key",
+	Long: `
+Set the value of the given config key in the config file of the jiri root, or
+in the per-user config file if -user is set.  The key must name a flag of an
+existing jiri command, and the value must be valid for that flag.  An empty
+value removes the key.
+`,
+	ArgsName: "<key> <value>",
+	ArgsLong: "<key> is the config key to set and <value> is its new value.",
+}
+
+// configEnvVars maps the names of flags to the environment variables that
+// configure the same setting, and take precedence over config values.
+var configEnvVars = map[string]string{
+	"credential-helper": gitutil.CredentialHelperEnv,
+	"mirror":            profilesutil.MirrorEnv,
+	"mirror-strict":     profilesutil.MirrorStrictEnv,
+}
+
+func runConfigGet(jirix *jiri.X, args []string) error {
+	if len(args) != 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	config, err := readConfig(jirix)
+	if err != nil {
+		return err
+	}
+	value, ok := config[args[0]]
+	if !ok {
+		return fmt.Errorf("config key %q is not set", args[0])
+	}
+	fmt.Fprintln(jirix.Stdout(), value)
+	return nil
+}
+
+func runConfigList(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	config, err := readConfig(jirix)
+	if err != nil {
+		return err
+	}
+	for _, key := range config.Keys() {
+		fmt.Fprintf(jirix.Stdout(), "%s=%s\n", key, config[key])
+	}
+	return nil
+}
+
+func runConfigSet(jirix *jiri.X, args []string) error {
+	if len(args) != 2 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	key, value := args[0], args[1]
+	path, err := configFile(jirix)
+	if err != nil {
+		return err
+	}
+	config, err := jiri.ReadConfig(path)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		delete(config, key)
+		return config.Write(path)
+	}
+	if strings.HasPrefix(key, aliasPrefix) {
+		if err := checkAlias(cmdRoot, strings.TrimPrefix(key, aliasPrefix), value); err != nil {
+			return err
+		}
+		config[key] = value
+		return config.Write(path)
+	}
+	if strings.HasPrefix(key, profiles.TargetAliasPrefix) {
+		if err := checkTargetAlias(strings.TrimPrefix(key, profiles.TargetAliasPrefix), value); err != nil {
+			return err
+		}
+		config[key] = value
+		return config.Write(path)
+	}
+	flags := configKeyFlags(cmdRoot, key)
+	if len(flags) == 0 {
+		return fmt.Errorf("config key %q does not name a jiri flag", key)
+	}
+	for _, f := range flags {
+		if err := checkFlagValue(f, value); err != nil {
+			return fmt.Errorf("invalid value %q for config key %q: %v", value, key, err)
+		}
+	}
+	config[key] = value
+	return config.Write(path)
+}
+
+// checkTargetAlias checks that the given target alias name and target can be
+// used with the -target flag.
+func checkTargetAlias(name, value string) error {
+	var alias profiles.Target
+	if err := alias.Set(name); err != nil || alias.Alias() != name {
+		return fmt.Errorf("invalid target alias name %q", name)
+	}
+	var target profiles.Target
+	if err := target.Set(value); err != nil || target.Alias() != "" {
+		return fmt.Errorf("invalid target %q for alias %q: want <arch>-<os>[@<version>]", value, name)
+	}
+	return nil
+}
+
+// configFile returns the path of the config file that "jiri config set"
+// modifies.
+func configFile(jirix *jiri.X) (string, error) {
+	if !userConfigFlag {
+		return jirix.ConfigFile(), nil
+	}
+	path := jiri.UserConfigFile()
+	if path == "" {
+		return "", fmt.Errorf("cannot determine the per-user config file, neither XDG_CONFIG_HOME nor HOME is set")
+	}
+	return path, nil
+}
+
+// readConfig returns the config that "jiri config get" and "jiri config list"
+// report.
+func readConfig(jirix *jiri.X) (jiri.Config, error) {
+	if !userConfigFlag {
+		return jiri.LoadConfig(jirix.Root)
+	}
+	path, err := configFile(jirix)
+	if err != nil {
+		return nil, err
+	}
+	return jiri.ReadConfig(path)
+}
+
+// checkFlagValue checks that the given value can be assigned to the given
+// flag, leaving the flag unchanged.
+func checkFlagValue(f *flag.Flag, value string) error {
+	old := f.Value.String()
+	err := f.Value.Set(value)
+	f.Value.Set(old)
+	return err
+}
+
+// visitFlags calls fn for each flag of each command in the command tree rooted
+// at root, along with the config key that names the flag of that command.
+// Global flags are visited with the root command.
+func visitFlags(root *cmdline.Command, fn func(key string, f *flag.Flag)) {
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fn(f.Name, f)
+	})
+	var visit func(prefix string, cmd *cmdline.Command)
+	visit = func(prefix string, cmd *cmdline.Command) {
+		cmd.Flags.VisitAll(func(f *flag.Flag) {
+			fn(prefix+f.Name, f)
+		})
+		for _, child := range cmd.Children {
+			visit(prefix+child.Name+".", child)
+		}
+	}
+	visit("", root)
+}
+
+// configKeyFlags returns the flags named by the given config key in the command
+// tree rooted at root.
+func configKeyFlags(root *cmdline.Command, key string) []*flag.Flag {
+	var flags []*flag.Flag
+	visitFlags(root, func(k string, f *flag.Flag) {
+		if k == key || f.Name == key {
+			flags = append(flags, f)
+		}
+	})
+	return flags
+}
+
+// configKey returns the qualified config key of the named flag for the last
+// command in the given path.  The flag belongs to the deepest command in the
+// path that defines it; global flags and flags of the root command are not
+// qualified.
+func configKey(path []*cmdline.Command, name string) string {
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i].Flags.Lookup(name) != nil {
+			var names []string
+			for _, cmd := range path[1 : i+1] {
+				names = append(names, cmd.Name)
+			}
+			return strings.Join(names, ".") + "." + name
+		}
+	}
+	return name
+}
+
+// applyConfig assigns config values to the flags of the last command in the
+// given path that were not set on the command line, unless an environment
+// variable in vars configures the same setting.  It must be called after the
+// command line has been parsed.
+func applyConfig(path []*cmdline.Command, config jiri.Config, vars map[string]string) error {
+	var errs []string
+	known := map[string]bool{}
+	visitFlags(path[0], func(key string, f *flag.Flag) {
+		known[key], known[f.Name] = true, true
+	})
+	for _, key := range config.Keys() {
+		if !known[key] && !strings.HasPrefix(key, aliasPrefix) && !strings.HasPrefix(key, profiles.TargetAliasPrefix) {
+			errs = append(errs, fmt.Sprintf("config key %q does not name a jiri flag", key))
+		}
+	}
+	leaf := path[len(path)-1]
+	if leaf.ParsedFlags != nil {
+		set := map[string]bool{}
+		for _, cmd := range path {
+			if cmd.ParsedFlags != nil {
+				cmd.ParsedFlags.Visit(func(f *flag.Flag) {
+					set[f.Name] = true
+				})
+			}
+		}
+		leaf.ParsedFlags.VisitAll(func(f *flag.Flag) {
+			if set[f.Name] {
+				return
+			}
+			if env := configEnvVars[f.Name]; env != "" && vars[env] != "" {
+				return
+			}
+			key := configKey(path, f.Name)
+			value, ok := config[key]
+			if !ok {
+				key = f.Name
+				if value, ok = config[key]; !ok {
+					return
+				}
+			}
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid value %q for config key %q: %v", value, key, err))
+			}
+		})
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// configRunner is a runner that applies the jiri config before running the
+// command at the end of path.
+type configRunner struct {
+	path   []*cmdline.Command
+	runner cmdline.Runner
+}
+
+func (r configRunner) Run(env *cmdline.Env, args []string) error {
+	config, err := jiri.LoadConfig(jiri.FindRoot())
+	if err == nil {
+		err = applyConfig(r.path, config, env.Vars)
+	}
+	if err != nil {
+		fmt.Fprintf(env.Stderr, "WARNING: %v\n", err)
+	}
+	return r.runner.Run(env, args)
+}
+
+// addConfigRunners wraps the runners of the commands in the command tree at
+// the end of path, so that the jiri config is applied before they are run.
+func addConfigRunners(path []*cmdline.Command) {
+	cmd := path[len(path)-1]
+	if cmd.Runner != nil {
+		cmd.Runner = configRunner{append([]*cmdline.Command(nil), path...), cmd.Runner}
+	}
+	for _, child := range cmd.Children {
+		addConfigRunners(append(path, child))
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"v.io/jiri"
+	"v.io/jiri/jiritest"
+	"v.io/jiri/profiles/profilesutil"
+	"v.io/jiri/tool"
+	"v.io/x/lib/cmdline"
+)
+
+// setUserConfigDir points the per-user config file at a directory under the
+// given root, and returns a function that restores the previous setting.
+func setUserConfigDir(t *testing.T, root string) func() {
+	old := os.Getenv("XDG_CONFIG_HOME")
+	if err := os.Setenv("XDG_CONFIG_HOME", root+"/xdg"); err != nil {
+		t.Fatal(err)
+	}
+	return func() { os.Setenv("XDG_CONFIG_HOME", old) }
+}
+
+// TestConfigPrecedence checks that flag values are taken from, in increasing
+// order of precedence, the built-in defaults, the config files, the
+// environment and the command line.
+func TestConfigPrecedence(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	defer setUserConfigDir(t, fake.X.Root)()
+
+	type values struct {
+		global, branch, mirror string
+	}
+	var got values
+	install := &cmdline.Command{
+		Name:   "install",
+		Short:  "Install",
+		Long:   "Install.",
+		Runner: cmdline.RunnerFunc(func(*cmdline.Env, []string) error { return nil }),
+	}
+	install.Flags.StringVar(&got.branch, "config-test-branch", "master", "")
+	install.Flags.StringVar(&got.mirror, "mirror", "", "")
+	profile := &cmdline.Command{
+		Name:     "profile",
+		Short:    "Profile",
+		Long:     "Profile.",
+		Children: []*cmdline.Command{install},
+	}
+	root := &cmdline.Command{
+		Name:     "jiri",
+		Short:    "Jiri",
+		Long:     "Jiri.",
+		Children: []*cmdline.Command{profile},
+	}
+	root.Flags.StringVar(&got.global, "config-test-global", "default", "")
+	addConfigRunners([]*cmdline.Command{root})
+
+	userConfig := jiri.Config{"config-test-global": "user", "config-test-branch": "user"}
+	if err := userConfig.Write(jiri.UserConfigFile()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		config jiri.Config
+		vars   map[string]string
+		args   []string
+		want   values
+	}{
+		// Per-user config only.
+		{
+			nil,
+			nil,
+			nil,
+			values{"user", "user", ""},
+		},
+		// The root config takes precedence over the per-user config, and
+		// qualified keys over unqualified ones.
+		{
+			jiri.Config{"config-test-branch": "root", "profile.install.config-test-branch": "qualified", "mirror": "http://config"},
+			nil,
+			nil,
+			values{"user", "qualified", "http://config"},
+		},
+		// The environment takes precedence over the config.
+		{
+			jiri.Config{"mirror": "http://config"},
+			map[string]string{profilesutil.MirrorEnv: "http://env"},
+			nil,
+			values{"user", "user", ""},
+		},
+		// The command line takes precedence over everything.
+		{
+			jiri.Config{"config-test-branch": "root", "mirror": "http://config"},
+			map[string]string{profilesutil.MirrorEnv: "http://env"},
+			[]string{"-config-test-global=cmdline", "-config-test-branch=cmdline", "-mirror=http://cmdline"},
+			values{"cmdline", "cmdline", "http://cmdline"},
+		},
+	}
+	for i, test := range tests {
+		got = values{"default", "master", ""}
+		if err := test.config.Write(fake.X.ConfigFile()); err != nil {
+			t.Fatal(err)
+		}
+		var stderr bytes.Buffer
+		env := &cmdline.Env{Stdout: ioutil.Discard, Stderr: &stderr, Vars: test.vars}
+		args := []string{"profile", "install"}
+		if len(test.args) > 0 {
+			args = []string{test.args[0], "profile", "install"}
+			args = append(args, test.args[1:]...)
+		}
+		if err := cmdline.ParseAndRun(root, env, args); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if got != test.want {
+			t.Errorf("%d: got %v, want %v", i, got, test.want)
+		}
+		if stderr.Len() != 0 {
+			t.Errorf("%d: unexpected output: %v", i, stderr.String())
+		}
+	}
+
+	// Check that unknown keys and invalid values are reported.
+	path := []*cmdline.Command{root, profile, install}
+	err := applyConfig(path, jiri.Config{"config-test-typo": "x"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "config-test-typo") {
+		t.Errorf("got %v, want an unknown key error", err)
+	}
+}
+
+func TestConfigCommands(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	defer setUserConfigDir(t, fake.X.Root)()
+	defer func() { userConfigFlag = false }()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+
+	if err := runConfigSet(fake.X, []string{"color", "false"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runConfigSet(fake.X, []string{"cl.mail.remote-branch", "release"}); err != nil {
+		t.Fatal(err)
+	}
+	userConfigFlag = true
+	if err := runConfigSet(fake.X, []string{"color", "true"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runConfigSet(fake.X, []string{"v", "true"}); err != nil {
+		t.Fatal(err)
+	}
+	userConfigFlag = false
+
+	// Check the contents of the config files.
+	for path, want := range map[string]jiri.Config{
+		fake.X.ConfigFile():   {"color": "false", "cl.mail.remote-branch": "release"},
+		jiri.UserConfigFile(): {"color": "true", "v": "true"},
+	} {
+		got, err := jiri.ReadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", path, got, want)
+		}
+	}
+
+	// Check get and list, which report the merged config by default.
+	tests := []struct {
+		user bool
+		run  func() error
+		want string
+	}{
+		{false, func() error { return runConfigGet(fake.X, []string{"color"}) }, "false\n"},
+		{true, func() error { return runConfigGet(fake.X, []string{"color"}) }, "true\n"},
+		{false, func() error { return runConfigList(fake.X, nil) }, "cl.mail.remote-branch=release\ncolor=false\nv=true\n"},
+		{true, func() error { return runConfigList(fake.X, nil) }, "color=true\nv=true\n"},
+	}
+	for i, test := range tests {
+		stdout.Reset()
+		userConfigFlag = test.user
+		if err := test.run(); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if got := stdout.String(); got != test.want {
+			t.Errorf("%d: got %q, want %q", i, got, test.want)
+		}
+	}
+	userConfigFlag = false
+
+	// Check that unknown keys, invalid values and unset keys are rejected.
+	if err := runConfigSet(fake.X, []string{"colour", "false"}); err == nil {
+		t.Errorf("expected an unknown key to be rejected")
+	}
+	if err := runConfigSet(fake.X, []string{"color", "maybe"}); err == nil {
+		t.Errorf("expected an invalid value to be rejected")
+	}
+	if err := runConfigSet(fake.X, []string{"alias.status", "update"}); err == nil {
+		t.Errorf("expected an alias that shadows a command to be rejected")
+	}
+	if err := runConfigSet(fake.X, []string{"alias.up", "update -gc"}); err != nil {
+		t.Errorf("expected an alias to be accepted: %v", err)
+	}
+	if err := runConfigSet(fake.X, []string{"target-alias.rpi", "arm-linux@2"}); err != nil {
+		t.Errorf("expected a target alias to be accepted: %v", err)
+	}
+	for _, args := range [][]string{{"target-alias.native", "arm-linux"}, {"target-alias.pi", "rpi"}, {"target-alias.a-b", "arm-linux"}} {
+		if err := runConfigSet(fake.X, args); err == nil {
+			t.Errorf("expected target alias %v to be rejected", args)
+		}
+	}
+	if got, want := tool.ColorFlag, true; got != want {
+		t.Errorf("got color %v, want %v", got, want)
+	}
+	if err := runConfigGet(fake.X, []string{"update.gc"}); err == nil {
+		t.Errorf("expected an unset key to fail")
+	}
+
+	// Check that an empty value removes the key.
+	if err := runConfigSet(fake.X, []string{"color", ""}); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := runConfigGet(fake.X, []string{"color"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "true\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
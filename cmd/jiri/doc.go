@@ -12,24 +12,54 @@ Usage:
    jiri [flags] <command>
 
 The jiri commands are:
-   cl          Manage changelists for multiple projects
-   import      Adds imports to .jiri_manifest file
-   profile     Display information about installed profiles
-   project     Manage the jiri projects
-   rebuild     Rebuild all jiri tools
-   snapshot    Manage project snapshots
-   update      Update all jiri tools and projects
-   which       Show path to the jiri tool
-   runp        Run a command in parallel across jiri projects
-   help        Display help for commands or topics
+   audit          Show the destructive operations performed by jiri
+   bootstrap      Create a new jiri root
+   cl             Manage changelists for multiple projects
+   completion     Print a shell completion script
+   config         Manage default flag values
+   import         Adds imports to .jiri_manifest file
+   profile        Display information about installed profiles
+   project        Manage the jiri projects
+   rebuild        Rebuild all jiri tools
+   rollback-tools Reinstall the tools that were installed before the latest
+                  update
+   server         Serve cached project states to editors and shell prompts
+   snapshot       Manage project snapshots
+   status         Summarize the state of the jiri root
+   test           Run the tests of Go packages
+   tools          Inspect the installed jiri tools
+   update         Update all jiri tools and projects
+   update-history Manage the update history
+   which          Show path to the jiri tool
+   grep           Search for a pattern across jiri projects
+   runp           Run a command in parallel across jiri projects
+   help           Display help for commands or topics
 
 The jiri additional help topics are:
+   exit-codes  Description of the exit codes of jiri
    filesystem  Description of jiri file system layout
    manifest    Description of manifest files
 
 The jiri flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -39,6 +69,104 @@ The global flags are:
  -time=false
    Dump timing information to stderr before exiting the program.
 
+Jiri audit - Show the destructive operations performed by jiri
+
+Show the most recent entries of the audit log in
+$JIRI_ROOT/.jiri_root/audit.log, oldest first.  The log records the destructive
+operations performed by jiri, with the command line that performed them:
+
+  - projects deleted by "jiri update -gc"
+  - projects moved by "jiri update", including the checkouts moved aside by
+    "jiri update -force-remote-change"
+  - branches deleted by "jiri project clean -branches" and "jiri cl cleanup"
+  - uncommitted changes and untracked files discarded by "jiri project clean"
+  - backups of projects created, restored and pruned by "jiri project clean"
+  - tools replaced by "jiri update" and "jiri rebuild"
+
+The log is rotated to audit.log.1 when it grows beyond 1MB.
+
+Usage:
+   jiri audit [flags]
+
+The jiri audit flags are:
+ -n=20
+   Number of the most recent entries to print.  Zero means all entries.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri bootstrap - Create a new jiri root
+
+Command "bootstrap" creates a new jiri root in the given directory, which is
+created if it doesn't exist.  A copy of the running jiri binary is installed
+into <dir>/.jiri_root/bin, a .jiri_manifest file that imports the given manifest
+is written, as by "jiri import", and the projects and tools are updated, as by
+"jiri update".  $JIRI_ROOT need not be set.
+
+The directory must be empty, unless -force is set.  If bootstrapping fails or is
+interrupted, the files and directories it created are removed, so that it can be
+run again.
+
+Example:
+  $ jiri bootstrap $HOME/src default https://vanadium.googlesource.com/manifest
+
+Usage:
+   jiri bootstrap [flags] <dir> <manifest> <remote>
+
+<dir> is the root directory to create.
+
+<manifest> specifies the manifest file to import.
+
+<remote> specifies the remote manifest repository.
+
+The jiri bootstrap flags are:
+ -force=false
+   Bootstrap into the directory even if it is not empty.
+ -shim-script=
+   Shim script to install as .jiri_root/scripts/jiri, e.g. the "jiri" script of
+   the jiri project, which sets $JIRI_ROOT and runs .jiri_root/bin/jiri.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
 Jiri cl - Manage changelists for multiple projects
 
 Manage changelists for multiple projects.
@@ -47,14 +175,70 @@ Usage:
    jiri cl [flags] <command>
 
 The jiri cl commands are:
+   chain       Show the dependency path of a changelist
    cleanup     Clean up changelists that have been merged
    mail        Mail a changelist for review
    new         Create a new local branch for a changelist
    sync        Bring a changelist up to date
 
 The jiri cl flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri cl chain - Show the dependency path of a changelist
+
+Command "chain" prints the sequence of dependent CLs leading to the CL
+identified by the current branch, as recorded in the .jiri metadata directory by
+"jiri cl new", one branch per line. Each branch is followed by its merge status:
+whether it is missing, whether it is up to date with its ancestor or how many
+commits it is behind, and whether it has been merged into the branch tracking
+the remote branch this CL pertains to.
+
+Usage:
+   jiri cl chain [flags]
+
+The jiri cl chain flags are:
+ -remote-branch=master
+   Name of the remote branch the CL pertains to, without the leading "origin/".
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -65,6 +249,16 @@ corresponding remote branch. If a branch differs from the corresponding remote
 branch, the command reports the difference and stops. Otherwise, it deletes the
 given branches.
 
+If the project has a gerrit host, the status of the CL of each branch is fetched
+from the host first. Branches whose CLs have been merged are deleted, even if
+gerrit squashed or rebased their commits, while branches whose CLs have been
+abandoned are only deleted with -f. If the status cannot be fetched, a warning
+is printed and the branch is compared with the remote branch.
+
+Likewise, if the project has a review host, the state of the pull request of
+each branch is fetched from GitHub, and branches whose pull requests have been
+merged or closed are deleted.
+
 Usage:
    jiri cl cleanup [flags] <branches>
 
@@ -76,8 +270,25 @@ The jiri cl cleanup flags are:
  -remote-branch=master
    Name of the remote branch the CL pertains to, without the leading "origin/".
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -90,6 +301,35 @@ the commit message. Consecutive invocations of the command use the same
 Change-Id by default, informing Gerrit that the incomming commit is an update of
 an existing changelist.
 
+Before mailing, the squashed changelist is checked for files that the Gerrit
+server is likely to reject: files larger than -max-file-size, newly added binary
+files, and a total size of the changed files larger than -max-diff-size. The
+changelist is not mailed if any are found, unless -force-large is set.
+
+If -r is not set, review is requested from the owners of the changed files,
+unless -no-owners is set. The owners of a file are listed in the nearest OWNERS
+file in its directory or a parent directory, one email address per line. A line
+"include <path>" adds the owners listed in another OWNERS file, given relative
+to the directory of the including file, or to the root of the repository if the
+path starts with "/". The owners are printed and added after confirmation, or
+right away if -yes is set.
+
+The -hashtag, -label, -wip and -ready flags are sent to Gerrit as options of the
+pushed reference, such as refs/for/master%hashtag=foo,l=Commit-Queue+1,wip,
+which is printed if -v is set. -hashtag and -label may be repeated.
+
+If the project has a "reviewhost" attribute naming a repository on GitHub, and
+-host is not set, the branch is sent as a pull request instead.  The branch is
+pushed to the fork given by -fork, or to a branch of origin named
+jiri/<username>/<branchname>, and a pull request against -remote-branch is
+created with the first line of -m, or of the last commit message, as its title
+and the rest as its body.  -r lists the GitHub logins to request review from,
+and -d creates a draft pull request.  Later invocations push the branch again,
+and update the title and body of the same pull request if -m is set. The GitHub
+token is read from the GITHUB_TOKEN environment variable, or from the password
+of the host in ~/.netrc.  Flags that only apply to Gerrit are ignored with a
+warning.
+
 Usage:
    jiri cl mail [flags]
 
@@ -112,14 +352,33 @@ The jiri cl mail flags are:
    Send a draft changelist.
  -edit=true
    Open an editor to edit the CL description.
+ -force-large=false
+   Mail the changelist even if it has files larger than -max-file-size, new
+   binary files, or a total size larger than -max-diff-size.
+ -fork=
+   Git url of the fork that the branch is pushed to when sending a pull request.
+   Defaults to a branch of origin named jiri/<username>/<branchname>.
+ -hashtag=
+   Hashtag to add to the CL.  May be repeated.
  -host=
    Gerrit host to use.  Defaults to gerrit host specified in manifest.
+ -label=
+   Vote to cast on the CL, such as "Commit-Queue=+1".  May be repeated.
  -m=
    CL description.
+ -max-diff-size=50MB
+   Largest total size of the changed files.
+ -max-file-size=5MB
+   Largest size of a changed file, such as "512KB" or "5MB".
+ -no-owners=false
+   Do not request review from the owners listed in the OWNERS files of the
+   changed files when -r is not set.
  -presubmit=all
    The type of presubmit tests to run. Valid values: none,all.
  -r=
    Comma-seperated list of emails or LDAPs to request review.
+ -ready=false
+   Mark the CL as ready for review.
  -remote-branch=master
    Name of the remote branch the CL pertains to, without the leading "origin/".
  -set-topic=true
@@ -128,20 +387,47 @@ The jiri cl mail flags are:
    CL topic, defaults to <username>-<branchname>.
  -verify=true
    Run pre-push git hooks.
-
+ -wip=false
+   Mark the CL as work in progress.
+ -yes=false
+   Request review from the owners listed in the OWNERS files without asking for
+   confirmation.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
 Jiri cl new - Create a new local branch for a changelist
 
 Command "new" creates a new local branch for a changelist. In particular, it
-forks a new branch with the given name from the current branch and records the
-relationship between the current branch and the new branch in the .jiri metadata
-directory. The information recorded in the .jiri metadata directory tracks
-dependencies between CLs and is used by the "jiri cl sync" and "jiri cl mail"
-commands.
+forks a new branch with the given name from the current branch, or from the
+branch or ref given by the -base flag, and records the relationship between the
+base and the new branch in the .jiri metadata directory. The information
+recorded in the .jiri metadata directory tracks dependencies between CLs and is
+used by the "jiri cl sync" and "jiri cl mail" commands.
+
+A base that is not a local branch, such as "origin/master", is recorded as the
+start of the dependency path of the new branch, which then does not depend on
+any other local CL. The -topic flag records the Gerrit topic that "jiri cl mail"
+uses for the new CL.
 
 Usage:
    jiri cl new [flags] <name>
@@ -149,8 +435,33 @@ Usage:
 <name> is the changelist name.
 
 The jiri cl new flags are:
+ -base=
+   Branch or ref to create the new branch from, defaults to the current branch.
+   A base that is not a local branch, such as "origin/master", means the
+   changelist does not depend on another local changelist.
+ -topic=
+   Gerrit topic to record for the changelist, used by "jiri cl mail" unless its
+   -topic flag is set.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -165,6 +476,13 @@ with its ancestor. The end result of this process is that all CLs in the
 sequence are up to date with the branch that tracks the remote branch this CL
 pertains to.
 
+Before syncing, the command checks that the branches of the dependency path
+still exist. Branches deleted or renamed outside of jiri are spliced out of the
+path, after confirmation or right away if -repair is set: their dependents are
+re-pointed at the parent recorded for the missing branch, or at the remote
+branch if no branch of the path remains. Use "jiri cl chain" to inspect the
+dependency path.
+
 NOTE: It is possible that the command cannot automatically merge changes in an
 ancestor into its dependent. When that occurs, the command is aborted and prints
 instructions that need to be followed before the command can be retried.
@@ -175,9 +493,244 @@ Usage:
 The jiri cl sync flags are:
  -remote-branch=master
    Name of the remote branch the CL pertains to, without the leading "origin/".
+ -repair=false
+   Repair the dependency path of the current branch if it names branches that no
+   longer exist, without asking for confirmation.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
 
+Jiri completion - Print a shell completion script
+
+Command "completion" prints a script that completes the subcommands and flags of
+jiri in the given shell, along with the names of projects, profiles and snapshot
+labels for the commands that take them.  The names are listed by the jiri
+binary, quickly and without network access, from the jiri root of the current
+$JIRI_ROOT.  $JIRI_ROOT need not be set to generate the script.
+
+To enable completion, source the script from your shell startup file, e.g.:
+
+  # ~/.bashrc
+  source <(jiri completion bash)
+
+  # ~/.zshrc
+  source <(jiri completion zsh)
+
+Usage:
+   jiri completion [flags] <shell>
+
+<shell> is "bash" or "zsh".
+
+The jiri completion flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri config - Manage default flag values
+
+Manage the default values of jiri flags.  Defaults are read from the config file
+of the jiri root, $JIRI_ROOT/.jiri_root/config, and from the per-user config
+file, $XDG_CONFIG_HOME/jiri/config or ~/.config/jiri/config, with values in the
+former taking precedence.
+
+Each line of a config file has the form <key>=<value>, where <key> is the name
+of a flag, optionally qualified by the names of the subcommands the flag belongs
+to, separated by dots.  For example, "color=false" disables color for all
+commands, while "cl.mail.remote-branch=release" only applies to "jiri cl mail".
+Qualified keys take precedence over unqualified ones.
+
+Flags set on the command line always take precedence over config values, and
+environment variables that configure the same setting as a flag, such as
+$JIRI_PROFILE_MIRROR for the -mirror flag, take precedence over config values
+for that flag.
+
+Keys of the form alias.<name> define command aliases: "jiri <name> <args>" runs
+"jiri <value> <args>", where <value> is split into arguments at whitespace. For
+example, after "jiri config set alias.up 'update -gc'", "jiri up" runs "jiri
+update -gc".  An alias may expand to another alias, but not recursively, and
+aliases cannot shadow jiri commands.  With the -v flag, the expanded command
+line is printed before it is run.
+
+Keys of the form target-alias.<name> define profile target aliases: the -target
+flag of the profile and runp commands accepts <name> in place of the
+<arch>-<os>[@<version>] target that is the value of the key.  For example, after
+"jiri config set target-alias.rpi arm-linux@2", "jiri profile install
+-target=rpi" installs profiles for arm-linux@2.  The -target flag also accepts
+"native", for the architecture and operating system of the host.
+
+Usage:
+   jiri config [flags] <command>
+
+The jiri config commands are:
+   get         Print the value of a config key
+   list        List config keys and values
+   set         Set the value of a config key
+
+The jiri config flags are:
+ -user=false
+   Use the per-user config file rather than the config file of the jiri root.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri config get - Print the value of a config key
+
+Print the value of the given config key.  Unless -user is set, the value is
+looked up in the merged per-user and jiri root configs.
+
+Usage:
+   jiri config get [flags] <key>
+
+<key> is the config key to print.
+
+The jiri config get flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -user=false
+   Use the per-user config file rather than the config file of the jiri root.
+ -v=false
+   Print verbose output.
+
+Jiri config list - List config keys and values
+
+List the config keys and values, one key=value pair per line.  Unless -user is
+set, the merged per-user and jiri root configs are listed.
+
+Usage:
+   jiri config list [flags]
+
+The jiri config list flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -user=false
+   Use the per-user config file rather than the config file of the jiri root.
+ -v=false
+   Print verbose output.
+
+Jiri config set - Set the value of a config key
+
+Set the value of the given config key in the config file of the jiri root, or in
+the per-user config file if -user is set.  The key must name a flag of an
+existing jiri command, and the value must be valid for that flag.  An empty
+value removes the key.
+
+Usage:
+   jiri config set [flags] <key> <value>
+
+<key> is the config key to set and <value> is its new value.
+
+The jiri config set flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -user=false
+   Use the per-user config file rather than the config file of the jiri root.
  -v=false
    Print verbose output.
 
@@ -186,7 +739,8 @@ Jiri import
 Command "import" adds imports to the $JIRI_ROOT/.jiri_manifest file, which
 specifies manifest information for the jiri tool.  The file is created if it
 doesn't already exist, otherwise additional imports are added to the existing
-file.
+file.  The -manifest-file flag adds the import to another manifest file, such as
+one of several configurations loaded with the global -manifest flag.
 
 An <import> element is added to the manifest representing a remote manifest
 import.  The manifest file path is relative to the root directory of the remote
@@ -205,6 +759,9 @@ Usage:
 <remote> specifies the remote manifest repository.
 
 The jiri import flags are:
+ -manifest-file=
+   The manifest file to add the import to, relative to $JIRI_ROOT, instead of
+   .jiri_manifest, for use with "jiri -manifest=<file> update".
  -name=manifest
    The name of the remote manifest project.
  -out=
@@ -219,11 +776,31 @@ The jiri import flags are:
  -remote-branch=master
    The branch of the remote manifest project to track, without the leading
    "origin/".
+ -revision=
+   The revision of the remote manifest project to use.  If set, the remote
+   branch is ignored.
  -root=
    Root to store the manifest project locally.
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -246,8 +823,25 @@ The jiri profile commands are:
    available   List the available profiles
 
 The jiri profile flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -290,12 +884,30 @@ The jiri profile list flags are:
  -skip-profiles=false
    if set, no profiles will be used
  -target=<runtime.GOARCH>-<runtime.GOOS>
-   specifies a profile target in the following form: <arch>-<os>[@<version>]
+   specifies a profile target in the following form: <arch>-<os>[@<version>], or
+   "native" or the name of a target alias, optionally followed by @<version>
  -v=false
    print more detailed information
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
 
 Jiri profile env - Display profile environment variables
 
@@ -307,6 +919,17 @@ printed, otherwise both name and value are printed, i.e. CFLAGS="foo" vs just
 If no environment variable names are requested then all will be printed in
 <name>=<val> format.
 
+If the -export flag is set, the requested variables, or all of them, are instead
+printed as a script that exports them when sourced by the shell selected by the
+-format flag: export NAME="value" lines for sh, bash and zsh, or set -x NAME
+'value' lines for fish. The values are quoted so that the variables are set to
+their exact values, e.g.
+  eval "$(jiri profile env -export --profiles=<profiles>)"
+
+If the -write flag is set, the script is written to the given file rather than
+printed. The file is replaced atomically, so that build systems can source it
+while it is being rewritten.
+
 Usage:
    jiri profile env [flags] [<environment variable names>]
 
@@ -316,6 +939,11 @@ display
 The jiri profile env flags are:
  -env=
    specify an environment variable in the form: <var>=[<val>],...
+ -export=false
+   print the variables as a script that exports them when sourced by a shell
+ -format=sh
+   the shell syntax of the script printed by --export or written by --write, one
+   of sh or fish
  -merge-policies=+CCFLAGS,+CGO_CFLAGS,+CGO_CXXFLAGS,+CGO_LDFLAGS,+CXXFLAGS,GOARCH,GOOS,GOPATH:,^GOROOT*,+LDFLAGS,:PATH,VDLPATH:
    specify policies for merging environment variables
  -profiles=
@@ -325,16 +953,43 @@ The jiri profile env flags are:
  -skip-profiles=false
    if set, no profiles will be used
  -target=<runtime.GOARCH>-<runtime.GOOS>
-   specifies a profile target in the following form: <arch>-<os>[@<version>]
+   specifies a profile target in the following form: <arch>-<os>[@<version>], or
+   "native" or the name of a target alias, optionally followed by @<version>
  -v=false
    print more detailed information
+ -write=
+   write the script printed by --export to the given file, atomically, instead
+   of printing it
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
 
 Jiri profile install - Install the given profiles
 
-Install the given profiles.
+Install the given profiles. With -dry-run, print the plan of the installation of
+each profile instead: the commands it would run, the URLs it would download and
+their destinations, the file system changes it would make and the environment
+variables it would set, grouped by installation step. Profiles whose
+installation depends on the output of commands, which are not run, are planned
+up to that point, which is marked as indeterminate; the profiles database is not
+changed.
 
 Usage:
    jiri profile install [flags] <profiles>
@@ -342,19 +997,47 @@ Usage:
 <profiles> is a list of profiles to install.
 
 The jiri profile install flags are:
+ -dry-run=false
+   print the commands, downloads, file system changes and environment variables
+   that installing the profiles would run, fetch, make and set, without
+   installing them
  -env=
    specify an environment variable in the form: <var>=[<val>],...
  -force=false
    force install the profile even if it is already installed
+ -mirror=
+   base URL of a mirror to fetch profile downloads from, overrides
+   $JIRI_PROFILE_MIRROR
+ -mirror-strict=false
+   fail rather than fall back to the original URL if a download is not found on
+   the mirror
  -profiles-db=$JIRI_ROOT/.jiri_root/profile_db
    the path, relative to JIRI_ROOT, that contains the profiles database.
  -profiles-dir=.jiri_root/profiles
    the directory, relative to JIRI_ROOT, that profiles are installed in
  -target=<runtime.GOARCH>-<runtime.GOOS>
-   specifies a profile target in the following form: <arch>-<os>[@<version>]
+   specifies a profile target in the following form: <arch>-<os>[@<version>], or
+   "native" or the name of a target alias, optionally followed by @<version>
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -381,10 +1064,28 @@ The jiri profile os-packages flags are:
  -profiles-dir=.jiri_root/profiles
    the directory, relative to JIRI_ROOT, that profiles are installed in
  -target=<runtime.GOARCH>-<runtime.GOOS>
-   specifies a profile target in the following form: <arch>-<os>[@<version>]
+   specifies a profile target in the following form: <arch>-<os>[@<version>], or
+   "native" or the name of a target alias, optionally followed by @<version>
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -405,12 +1106,30 @@ The jiri profile uninstall flags are:
  -profiles-dir=.jiri_root/profiles
    the directory, relative to JIRI_ROOT, that profiles are installed in
  -target=<runtime.GOARCH>-<runtime.GOOS>
-   specifies a profile target in the following form: <arch>-<os>[@<version>]
+   specifies a profile target in the following form: <arch>-<os>[@<version>], or
+   "native" or the name of a target alias, optionally followed by @<version>
  -v=false
    print more detailed information
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
 
 Jiri profile update - Install the latest default version of the given profiles
 
@@ -429,14 +1148,35 @@ The jiri profile update flags are:
  -v=false
    print more detailed information
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
 
 Jiri profile cleanup - Cleanup the locally installed profiles
 
 Cleanup the locally installed profiles. This is generally required when
 recovering from earlier bugs or when preparing for a subsequent change to the
-profiles implementation.
+profiles implementation. With -gc, the disk space reclaimed by removing the
+installation directories of the old targets is reported; installation
+directories that are shared with the targets that are kept are not removed, and
+are not counted. Use -dry-run to report the disk space that would be reclaimed
+without removing anything.
 
 Usage:
    jiri profile cleanup [flags] <profiles>
@@ -445,6 +1185,9 @@ Usage:
 cleaned.
 
 The jiri profile cleanup flags are:
+ -dry-run=false
+   with -gc, report the disk space that would be reclaimed without uninstalling
+   any targets
  -gc=false
    uninstall profile targets that are older than the current default
  -profiles-db=$JIRI_ROOT/.jiri_root/profile_db
@@ -458,8 +1201,25 @@ The jiri profile cleanup flags are:
  -v=false
    print more detailed information
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
 
 Jiri profile available - List the available profiles
 
@@ -474,8 +1234,25 @@ The jiri profile available flags are:
  -v=false
    print more detailed information
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
 
 Jiri project - Manage the jiri projects
 
@@ -485,15 +1262,82 @@ Usage:
    jiri project [flags] <command>
 
 The jiri project commands are:
-   clean        Restore jiri projects to their pristine state
-   info         Provided structured input for existing jiri projects and
-                branches
-   list         List existing jiri projects and branches
-   shell-prompt Print a succinct status of projects suitable for shell prompts
+   checkout      Clone projects of the manifest that do not exist locally
+   clean         Restore jiri projects to their pristine state
+   diff-manifest Report how the local projects differ from the manifest
+   fetch         Fetch the remotes of jiri projects
+   group         Manage the enabled project groups
+   info          Provided structured input for existing jiri projects and
+                 branches
+   lint-manifest Check manifests for problems
+   list          List existing jiri projects and branches
+   mirror        Create or refresh mirror repositories of the jiri projects
+   poll          Report the changelists that exist remotely but not locally
+   reclone       Replace broken checkouts of jiri projects by new clones
+   repair        Reconstruct the metadata of jiri projects
+   set-remote    Set the remotes of jiri projects to those of the manifest
+   shell-prompt  Print a succinct status of projects suitable for shell prompts
 
 The jiri project flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project checkout - Clone projects of the manifest that do not exist locally
+
+Clone the projects of the manifest that match the given project key regexps and
+do not exist locally, checked out at their revisions in the manifest, as "jiri
+update" creates projects, without updating any other project.  The metadata of
+the projects is written, and their githooks are installed; runhooks are not run.
+Matching projects that already exist are reported and left unchanged, and a
+regexp that matches no project is an error.
+
+Use "jiri project info -from-manifest" to list the projects of the manifest
+along with whether they exist locally.
+
+Usage:
+   jiri project checkout [flags] <project-keys>...
+
+<project-keys>... a list of project keys, as regexps, of the projects to clone
+
+The jiri project checkout flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -502,17 +1346,284 @@ Jiri project clean - Restore jiri projects to their pristine state
 Restore jiri projects back to their master branches and get rid of all the local
 branches and changes.
 
+Before a project is cleaned, its uncommitted changes and untracked files are
+backed up as a git stash entry with the message "jiri-clean-backup <id>", and
+the branches deleted by -branches are backed up as a git bundle in the
+.jiri/backups directory of the project.  The <id> of the backups is the time the
+clean started, e.g. 20161017-150405.  The -list-backups flag lists the backups
+of the projects, and the -restore-backup flag restores the backups with the
+given <id>: the stashed changes are applied to the current branch of each
+project, and the deleted branches are recreated.  Backups older than
+-backup-max-age are deleted when the projects are cleaned.
+
 Usage:
    jiri project clean [flags] <project ...>
 
 <project ...> is a list of projects to clean up.
 
 The jiri project clean flags are:
+ -backup-max-age=720h0m0s
+   Maximum age of the backups of the projects.  Older backups are deleted before
+   the projects are cleaned.  Zero means no limit.
  -branches=false
    Delete all non-master branches.
+ -list-backups=false
+   List the backups of the projects instead of cleaning them.
+ -no-backup=false
+   Do not back up the local changes and branches before discarding them.
+ -restore-backup=
+   Restore the backups of the projects with the given ID instead of cleaning
+   them.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project diff-manifest - Report how the local projects differ from the manifest
+
+Report how the local projects differ from the manifest, without changing
+anything: the projects of the manifest that are missing locally, the local
+projects that are not in the manifest, and the projects whose local path or
+master revision differs from the manifest.  Remotes are not fetched, so a
+project that tracks a remote branch is compared to the local copy of the branch,
+as of the last fetch.  Remote imports whose manifest projects have not been
+fetched yet are reported as unresolvable.
+
+The command exits with code 0 if the local projects match the manifest, and 1
+otherwise, so it can be used as a consistency check.
+
+Usage:
+   jiri project diff-manifest [flags]
+
+The jiri project diff-manifest flags are:
+ -json=false
+   Output the differences as a JSON array.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project fetch - Fetch the remotes of jiri projects
+
+Fetch the origin remote of the local projects, or of the given projects, so that
+the remote branches are up to date, e.g. before working offline.  Unlike "jiri
+update", only the remote-tracking refs are updated: no working tree or local
+branch is changed, and no tool is built, so it is safe to fetch while editing
+files.
+
+The projects are fetched concurrently.  For each project, the number of new
+commits fetched on the remote branch it tracks is reported, or the error that
+prevented fetching it; a failure does not stop the other projects.  The command
+exits with code 1 if any project failed to fetch.
+
+Usage:
+   jiri project fetch [flags] <project ...>
+
+<project ...> is a list of projects to fetch.  Defaults to all local projects.
+
+The jiri project fetch flags are:
+ -all=false
+   Fetch all remotes of the projects, not only origin.
+ -j=8
+   Number of projects to fetch concurrently.
+ -prune=false
+   Delete the remote-tracking refs that no longer exist on the remotes.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project group - Manage the enabled project groups
+
+Manage the project groups that are enabled in the jiri root.  Projects and
+imports in the manifest may list the groups they belong to in their "groups"
+attribute; those without the attribute belong to the "default" group.  Only the
+projects that belong to an enabled group are loaded from the manifest, and thus
+created and updated by "jiri update".  Only the "default" group is enabled
+unless the enabled groups are changed.
+
+The enabled groups are stored in $JIRI_ROOT/.jiri_root/groups, and can also be
+set with the -fetch-groups flag of "jiri update".
+
+Usage:
+   jiri project group [flags] <command>
+
+The jiri project group commands are:
+   add         Enable project groups
+   list        List the enabled project groups
+   remove      Disable project groups
+
+The jiri project group flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project group add - Enable project groups
+
+Enable the given project groups.  Run "jiri update" to create the projects of
+the groups.
+
+Usage:
+   jiri project group add [flags] <group ...>
+
+<group ...> is a list of project groups to enable.
+
+The jiri project group add flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project group list - List the enabled project groups
+
+List the enabled project groups, one per line.
+
+Usage:
+   jiri project group list [flags]
+
+The jiri project group list flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project group remove - Disable project groups
+
+Disable the given project groups.  Existing local projects of the groups are
+left unchanged by "jiri update", unless the groups are also passed to its
+-prune-groups flag along with -gc.
+
+Usage:
+   jiri project group remove [flags] <group ...>
+
+<group ...> is a list of project groups to disable.
 
+The jiri project group remove flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -527,9 +1638,28 @@ is specified using a go template, supplied via the -f flag, that is executed
 against the v.io/jiri/project.ProjectState structure. This structure currently
 has the following fields:
 project.ProjectState{Branches:[]project.BranchState(nil), CurrentBranch:"",
-HasUncommitted:false, HasUntracked:false, Project:project.Project{Name:"",
-Path:"", Protocol:"", Remote:"", RemoteBranch:"", Revision:"", GerritHost:"",
-GitHooks:"", RunHook:"", XMLName:struct {}{}}}
+HasUncommitted:false, HasUntracked:false, InProgressOperation:"",
+Project:project.Project{Name:"", Path:"", Protocol:"", Remote:"",
+RemoteBranch:"", FallbackBranch:"", Revision:"", Verify:"", CloneFilter:"",
+SparseCheckout:"", LFS:false, GerritHost:"", GerritRemote:"", ReviewHost:"",
+Groups:"", GitHooks:"", RunHook:"", HookEnv:"", HookProfiles:"", XMLName:struct
+{}{}}, Stashes:0, LastUpdateRevision:"", Present:false}
+
+With -from-manifest, the projects of the manifest that do not exist locally are
+matched too.  Their Present field is false, and their Project field holds the
+remote and revision they would be cloned from, e.g. by "jiri project checkout".
+A project key regexp that matches no project is an error.
+
+In addition to the builtin functions of go templates, the template can use the
+following functions:
+  basename <path>     the last element of the path
+  join <list> <sep>   the elements of the list of strings, separated by sep
+  json <value>        the value, encoded as JSON
+  relpath <path>      the path, relative to the jiri root
+  shortrev <revision> the first 8 characters of the revision
+
+For example, -f='{{relpath .Project.Path}} {{shortrev .Project.Revision}}'
+prints the path and abbreviated revision of each project.
 
 Usage:
    jiri project info [flags] <project-keys>...
@@ -540,77 +1670,558 @@ format to
 The jiri project info flags are:
  -f={{.Project.Name}}
    The go template for the fields to display.
+ -from-manifest=false
+   Also match the projects of the manifest that do not exist locally, whose
+   Present field is false and whose Project holds the remote and revision of the
+   manifest.
+ -with-history=false
+   Populate the LastUpdateRevision field from the update history.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project lint-manifest - Check manifests for problems
+
+Check the given manifest files, and the manifests they import, for problems that
+would otherwise only surface when "jiri update" runs into them:
+
+  - files that cannot be parsed, and elements or attributes that this version
+    of jiri does not know
+  - duplicate projects and tools, with the files they were found in
+  - project paths outside the jiri root, or colliding with .jiri_root,
+    .jiri_manifest or .manifest
+  - project paths that differ only in case, which collide on case-insensitive
+    filesystems such as the defaults of macOS and Windows
+  - relative githooks and runhook paths that do not exist
+  - remotes that are neither URLs nor absolute paths
+  - imports that are cyclic, or unreachable because the imported file or
+    the manifest project of a remote import does not exist locally
+
+Nothing is fetched or cloned, and no project is changed: the projects of all
+groups are checked, and the manifests of remote imports are read from the
+working trees of their local projects, so that uncommitted changes to the
+manifests are checked too.
+
+The command exits with code 0 if no problem is found, and 1 otherwise, so it can
+be used as a presubmit check.
+
+Usage:
+   jiri project lint-manifest [flags] [<manifest>...]
+
+<manifest>... is a list of manifest files to check.  Defaults to
+$JIRI_ROOT/.jiri_manifest.
 
+The jiri project lint-manifest flags are:
+ -json=false
+   Output the problems as a JSON array.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
 Jiri project list - List existing jiri projects and branches
 
 Inspect the local filesystem and list the existing projects and branches.
+Projects are specified using regular expressions that are matched against
+project keys.  If no command line arguments are provided, all projects are
+listed.
 
 Usage:
-   jiri project list [flags]
+   jiri project list [flags] <project-keys>...
+
+<project-keys>... a list of project keys, as regexps, to list
 
 The jiri project list flags are:
  -branches=false
-   Show project branches.
+   Show project branches, and the statuses of their CLs on the gerrit hosts of
+   the projects.
+ -json=false
+   Output the listing as a JSON array.
  -nopristine=false
    If true, omit pristine projects, i.e. projects with a clean master branch and
    no other branches.
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
-Jiri project shell-prompt - Print a succinct status of projects suitable for shell prompts
+Jiri project mirror - Create or refresh mirror repositories of the jiri projects
 
-Reports current branches of jiri projects (repositories) as well as an
-indication of each project's status:
-  *  indicates that a repository contains uncommitted changes
-  %  indicates that a repository contains untracked files
+Create a bare mirror repository, named <dir>/<project-name>.git, of each project
+in the manifest, or fetch the latest changes into the mirrors that already
+exist.  The mirrors can then be used by "jiri update -reference-dir" and "jiri
+snapshot checkout -reference-dir" to clone new projects, borrowing objects from
+the mirrors rather than fetching them from the remotes.
+
+A directory of mirrors is typically shared by the jiri roots on a machine and
+refreshed periodically.
 
 Usage:
-   jiri project shell-prompt [flags]
+   jiri project mirror [flags] <dir>
 
-The jiri project shell-prompt flags are:
- -check-dirty=true
-   If false, don't check for uncommitted changes or untracked files. Setting
-   this option to false is dangerous: dirty master branches will not appear in
-   the output.
- -show-name=false
-   Show the name of the current repo.
+<dir> is the directory of mirror repositories.
 
+The jiri project mirror flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
-Jiri rebuild - Rebuild all jiri tools
+Jiri project poll - Report the changelists that exist remotely but not locally
 
-Rebuilds all jiri tools and installs the resulting binaries into
-$JIRI_ROOT/.jiri_root/bin. This is similar to "jiri update", but does not update
-any projects before building the tools. The set of tools to rebuild is described
-in the manifest.
+Fetch the local projects, or the given projects, and report the changelists on
+the remote branches they track that the local master branches do not have yet,
+with their authors and descriptions.
 
-Run "jiri help manifest" for details on manifests.
+For projects with a gerrit host, the gerrit change of each changelist is looked
+up by the Change-Id in its description, and its number, owner, topic and status,
+e.g. MERGED, are reported too.  The changes are queried in batches, one host at
+a time; the changes of hosts that cannot be queried are left out, with a
+warning.  The -gerrit=false flag skips the lookups.
 
 Usage:
-   jiri rebuild [flags]
+   jiri project poll [flags] <project ...>
 
-The jiri rebuild flags are:
+<project ...> is a list of projects to poll.  Defaults to all local projects.
+
+The jiri project poll flags are:
+ -gerrit=true
+   Look up the gerrit changes of the changelists on the gerrit hosts of their
+   projects.
+ -json=false
+   Output the changelists as a JSON object, keyed by project name.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
-Jiri snapshot - Manage project snapshots
+Jiri project reclone - Replace broken checkouts of jiri projects by new clones
 
-The "jiri snapshot" command can be used to manage project snapshots. In
-particular, it can be used to create new snapshots and to list existing
-snapshots.
+Replace the checkouts of the given projects, e.g. ones whose .git directory was
+corrupted by disk problems or an interrupted clone, by new clones from the
+remotes of the projects in the manifest, checked out at their revisions in the
+manifest, as "jiri update" creates projects.  The metadata of the projects is
+written again, and their githooks are installed; runhooks are not run.
+
+The old checkouts are moved to <path>.corrupt-<time>, rather than deleted, and
+the local branches other than master that can still be read are fetched into the
+new clones.  The command reports where each old checkout was moved to, and which
+branches were saved.  Projects with uncommitted changes are not recloned unless
+-force is set, although checkouts that are too broken to tell are.
+
+Usage:
+   jiri project reclone [flags] <project ...>
+
+<project ...> is a list of projects to reclone.
+
+The jiri project reclone flags are:
+ -force=false
+   Reclone projects even if they have uncommitted changes.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project repair - Reconstruct the metadata of jiri projects
+
+Reconstruct the metadata of a local project, stored in the .jiri/metadata.v2
+file of the project, e.g. after the file was deleted or truncated.  Projects
+with missing or invalid metadata are skipped with a warning when scanning for
+local projects, and "jiri update" cannot create them again on top of the
+existing checkouts.
+
+The project is identified by matching the origin remote of the git repository
+against the remotes of the projects in the manifest.  Without arguments, the
+metadata of every project in the manifest whose directory exists but whose
+metadata is missing or invalid is reconstructed.
+
+Usage:
+   jiri project repair [flags] [<path>]
+
+<path> is the directory of the project to repair.
+
+The jiri project repair flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project set-remote - Set the remotes of jiri projects to those of the manifest
+
+Set the origin remote of the local projects, or of the given projects, to the
+remote of the project in the manifest, e.g. after the projects migrated to a new
+host, without waiting for "jiri update" to do so.  Nothing is fetched, and the
+manifest is loaded as it is.
+
+To avoid clobbering custom remotes, the origin of a project is only changed if
+its URL is the remote the project had in the manifest when it was last updated,
+or starts with the -from prefix.  Other git remotes of the projects, such as a
+remote for code reviews, are not set by the manifest; the remotes named by
+-remote-name are rewritten by replacing the -from prefix of their URLs with the
+-to prefix, and left unchanged if their URLs do not start with -from, e.g.:
+
+  jiri project set-remote -from https://old.host/ -to sso://new.host/ -remote-name review
+
+The old and new URLs of each changed remote are printed; -n only prints them.
+The command exits with code 1 if a remote was left unchanged because its URL did
+not match, or could not be changed.
+
+Usage:
+   jiri project set-remote [flags] <project ...>
+
+<project ...> is a list of projects whose remotes to set.  Defaults to all local
+projects.
+
+The jiri project set-remote flags are:
+ -from=
+   URL prefix of the host the projects migrated from, e.g. https://old.host/.
+   Origin remotes starting with it are changed even if they are not the remote
+   of the last update.
+ -n=false
+   Show the remotes that would be changed, without changing them.
+ -remote-name=
+   Comma-separated list of additional git remotes, e.g. review, whose URLs
+   starting with -from are rewritten to start with -to.
+ -to=
+   URL prefix of the host the projects migrated to, e.g. sso://new.host/, which
+   replaces -from in the URLs of the remotes named by -remote-name.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri project shell-prompt - Print a succinct status of projects suitable for shell prompts
+
+Reports current branches of jiri projects (repositories) as well as an
+indication of each project's status:
+  *  indicates that a repository contains uncommitted changes
+  %  indicates that a repository contains untracked files
+  |REBASE, |MERGE or |CHERRY-PICK indicates that the operation is in progress
+  in a repository
+
+With -cached, the states are requested from "jiri server", which caches them, if
+it is running; otherwise they are computed as usual.  This is much faster for
+prompts and editor status lines that are refreshed often, but the states may be
+out of date by up to the -ttl of the server.
+
+Usage:
+   jiri project shell-prompt [flags]
+
+The jiri project shell-prompt flags are:
+ -cached=false
+   Use the cached project states of "jiri server" if it is running.
+ -check-dirty=true
+   If false, don't check for uncommitted changes or untracked files. Setting
+   this option to false is dangerous: dirty master branches will not appear in
+   the output.
+ -show-name=false
+   Show the name of the current repo.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri rebuild - Rebuild all jiri tools
+
+Rebuilds all jiri tools and installs the resulting binaries into
+$JIRI_ROOT/.jiri_root/bin. This is similar to "jiri update", but does not update
+any projects before building the tools. The set of tools to rebuild is described
+in the manifest.  The tools that were installed before are kept, and can be
+reinstalled with "jiri rollback-tools".
+
+Run "jiri help manifest" for details on manifests.
+
+Usage:
+   jiri rebuild [flags]
+
+The jiri rebuild flags are:
+ -go-root=
+   Directory of the Go toolchain to build the tools with.  If empty, the
+   toolchain installed by the "go" profile is used if there is one, and the "go"
+   binary in PATH otherwise.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri rollback-tools - Reinstall the tools that were installed before the latest update
+
+Swap the tools installed in $JIRI_ROOT/.jiri_root/bin with those that were
+installed before the latest "jiri update" or "jiri rebuild", which are kept in
+$JIRI_ROOT/.jiri_root/bin.previous, e.g. to recover quickly when a newly built
+tool is broken.  Running the command again undoes the rollback.
+
+Tools are installed as a set: if an update fails to install any tool, the tools
+that were installed before are restored, so that the bin directory never holds a
+mix of old and new tools.
+
+Usage:
+   jiri rollback-tools [flags]
+
+The jiri rollback-tools flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri server - Serve cached project states to editors and shell prompts
+
+Start a long-lived process that caches the local projects and their states, and
+serves them as JSON over HTTP on the unix domain socket
+$JIRI_ROOT/.jiri_root/server.sock, for editor plugins and shell prompts that
+query them too often to scan the projects and run git each time.  The server
+runs until it is killed.
+
+The cached states are recomputed when they are older than -ttl, or when "jiri
+update" or "jiri snapshot checkout" changed the local projects, which they
+record in $JIRI_ROOT/.jiri_root/generation.  Changes made by git commands, such
+as switching branches, are therefore reported with a delay of up to -ttl.
+
+The server answers the following GET requests:
+
+  /states?check-dirty=<bool>
+    The states of all local projects, keyed by project key, as in "jiri
+    project info".  If check-dirty is false, uncommitted changes and
+    untracked files are not checked.
+  /project?path=<path>
+    The local project that contains the given absolute path.
+  /shell-prompt?path=<path>&check-dirty=<bool>&show-name=<bool>
+    The output of "jiri project shell-prompt" in the given directory, as
+    {"prompt": "<output>"}.
+
+Run "jiri project shell-prompt -cached" to use the server from a shell prompt;
+it computes the states itself if the server is not running.
+
+Usage:
+   jiri server [flags]
+
+The jiri server flags are:
+ -ttl=2s
+   Maximum age of the cached project states.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri snapshot - Manage project snapshots
+
+The "jiri snapshot" command can be used to manage project snapshots. In
+particular, it can be used to create new snapshots, to list existing snapshots,
+and to delete, rename and prune them.
 
 Usage:
    jiri snapshot [flags] <command>
@@ -618,14 +2229,37 @@ Usage:
 The jiri snapshot commands are:
    checkout    Checkout a project snapshot
    create      Create a new project snapshot
+   delete      Delete a snapshot or a snapshot label
+   fetch       Fetch snapshots from the snapshot remote
+   leave       Check the master branches back out after a detached checkout
    list        List existing project snapshots
+   prune       Delete the oldest snapshots of a label
+   push        Push the snapshots of a label to the snapshot remote
+   rename      Rename a snapshot label
 
 The jiri snapshot flags are:
  -dir=
    Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -634,19 +2268,82 @@ Jiri snapshot checkout - Checkout a project snapshot
 The "jiri snapshot checkout <snapshot>" command restores local project state to
 the state in the given snapshot manifest.
 
+By default, the master branch of each project is reset to the revision in the
+snapshot.  If the -detach flag is provided, the revision is checked out with a
+detached HEAD instead, or on the branch given by the -branch flag, and the
+master branches are left untouched.  Projects with uncommitted changes are
+listed and nothing is checked out, unless the -force flag is provided.  Run
+"jiri snapshot leave" to check the master branches back out.
+
+Before any project is changed, the snapshot revisions are fetched into the local
+projects as needed, and checked against the remotes of the projects that do not
+exist locally.  If some of them cannot be obtained, e.g. because they were
+garbage collected upstream, the projects are listed and nothing is checked out,
+unless the -partial flag is provided.
+
+The -reference-dir and -dissociate flags clone the projects that do not exist
+locally using mirror repositories, as for "jiri update".
+
+Unless -detach or -skip-postupdate is provided, the post-update commands
+recorded in the snapshot are run once the tools are installed, as for "jiri
+update".
+
 Usage:
    jiri snapshot checkout [flags] <snapshot>
 
 <snapshot> is the snapshot manifest file.
 
 The jiri snapshot checkout flags are:
+ -branch=
+   With -detach, check out the snapshot revisions on a branch with the given
+   name, e.g. snapshot/<label>, rather than with a detached HEAD.
+ -detach=false
+   Check out the snapshot revisions with a detached HEAD, leaving the master
+   branches untouched.
+ -dissociate=false
+   With -reference-dir, copy the borrowed objects into the new projects, so that
+   they do not depend on the mirror repositories.
+ -force=false
+   With -detach, discard uncommitted changes in the projects rather than fail.
  -gc=false
    Garbage collect obsolete repositories.
-
+ -no-hooks=false
+   Do not run the runhooks or install the githooks of projects, list them
+   instead.
+ -no-verify=false
+   Do not verify the signatures of the revisions of the projects whose "verify"
+   attribute is "signature".  For emergencies only.
+ -partial=false
+   Check out the projects whose snapshot revisions can be obtained even if the
+   revisions of others cannot, rather than check out nothing.  For emergencies
+   only.
+ -reference-dir=
+   Directory of mirror repositories, as created by "jiri project mirror", to
+   borrow objects from when cloning new projects.
+ -skip-postupdate=false
+   Do not run the post-update commands of the snapshot, list them instead.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
  -dir=
    Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -656,6 +2353,21 @@ The "jiri snapshot create <label>" command captures the current project state in
 a manifest.  If the -push-remote flag is provided, the snapshot is committed and
 pushed upstream.
 
+By default, the snapshot records the revision of the master branch of each
+project.  Projects that are not on their master branch or have uncommitted
+changes are listed in a warning and in a comment in the snapshot.  If the
+-current-state flag is provided, the revision checked out in each project is
+recorded instead.  If the -require-clean flag is provided, the snapshot is not
+created if any project is not on its master branch or has uncommitted changes.
+
+If the -tag flag is provided, an annotated tag with the given name is created at
+the recorded revision of each project, and the tag name is recorded in the "tag"
+attribute of the snapshot.  Projects where the tag already points at the
+recorded revision are left alone.  If the tag points at another revision in any
+project, no tag is created and the command fails, unless the -force-tag flag is
+provided, in which case the tag is moved.  If the -push-tags flag is provided,
+the tag is then pushed to the origin remote of each project.
+
 Internally, snapshots are organized as follows:
 
  <snapshot-dir>/
@@ -683,15 +2395,175 @@ Usage:
 <label> is the snapshot label.
 
 The jiri snapshot create flags are:
+ -current-state=false
+   Record the revision checked out in each project, rather than the revision of
+   its master branch.
+ -force-tag=false
+   With -tag, move the tag in projects where it already points at another
+   revision rather than fail.
  -push-remote=false
    Commit and push snapshot upstream.
+ -push-tags=false
+   With -tag, push the tag to the origin remote of each project.
+ -require-clean=false
+   Fail if any project is not on its master branch or has uncommitted changes.
+ -tag=
+   Create an annotated tag with the given name at the recorded revision of each
+   project, and record the tag name in the snapshot.
  -time-format=2006-01-02T15:04:05Z07:00
    Time format for snapshot file name.
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
  -dir=
    Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri snapshot delete - Delete a snapshot or a snapshot label
+
+The "jiri snapshot delete <label> [<snapshot-file>]" command deletes the given
+snapshot file of the label, as listed by "jiri snapshot list", or if no snapshot
+file is given, the label along with all its snapshots.
+
+The snapshot that the <label> symlink points to is only deleted if the -force
+flag is provided, in which case the symlink is pointed at the newest remaining
+snapshot of the label, or removed along with the label if no snapshot remains.
+If the -push-remote flag is provided, the deletion is committed and pushed
+upstream, as for "jiri snapshot create".
+
+Usage:
+   jiri snapshot delete [flags] <label> [<snapshot-file>]
+
+<label> is the snapshot label, and <snapshot-file> the name of one of its
+snapshot files.
+
+The jiri snapshot delete flags are:
+ -force=false
+   Delete the snapshot that the label symlink points to, pointing the symlink at
+   the newest remaining snapshot.
+ -push-remote=false
+   Commit and push the changes upstream.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -dir=
+   Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri snapshot fetch - Fetch snapshots from the snapshot remote
+
+The "jiri snapshot fetch [<label>]" command copies the snapshots of the given
+label, or of all labels, that were shared with "jiri snapshot push" into the
+local snapshot directory, and points the <label> symlinks at the latest shared
+snapshots.  A symlink that points at a snapshot that was not pushed yet is left
+unchanged.
+
+Usage:
+   jiri snapshot fetch [flags] [<label>]
+
+<label> is the snapshot label.
+
+The jiri snapshot fetch flags are:
+ -snapshot-remote=
+   The git repository that snapshots are shared through.  Can be configured with
+   "jiri config set snapshot-remote <url>".
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -dir=
+   Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri snapshot leave - Check the master branches back out after a detached checkout
+
+The "jiri snapshot leave" command checks the master branch back out in the
+projects that were checked out by "jiri snapshot checkout -detach", and have not
+been moved off the snapshot revision since.  Projects with uncommitted changes
+are listed and nothing is checked out, unless the -force flag is provided.  The
+next "jiri update" updates the projects as usual.
+
+Usage:
+   jiri snapshot leave [flags]
+
+The jiri snapshot leave flags are:
+ -force=false
+   Discard uncommitted changes in the projects rather than fail.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -dir=
+   Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -701,16 +2573,394 @@ The "snapshot list" command lists existing snapshots of the labels specified as
 command-line arguments. If no arguments are provided, the command lists
 snapshots for all known labels.
 
+Once snapshots have been shared with "jiri snapshot push" or "jiri snapshot
+fetch", each snapshot is listed with its origin: "remote" for the snapshots in
+the snapshot remote, and "local" for those that were not pushed.
+
 Usage:
    jiri snapshot list [flags] <label ...>
 
 <label ...> is a list of snapshot labels.
 
 The jiri snapshot list flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
  -dir=
    Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri snapshot prune - Delete the oldest snapshots of a label
+
+The "jiri snapshot prune -keep=<n> <label>" command deletes the snapshots of the
+given label, except the <n> newest ones by modification time.  The snapshot that
+the <label> symlink points to is always kept.  If the -push-remote flag is
+provided, the deletions are committed and pushed upstream, as for "jiri snapshot
+create".
+
+Usage:
+   jiri snapshot prune [flags] <label>
+
+<label> is the snapshot label.
+
+The jiri snapshot prune flags are:
+ -keep=0
+   Number of the newest snapshots of the label to keep.
+ -push-remote=false
+   Commit and push the changes upstream.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -dir=
+   Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri snapshot push - Push the snapshots of a label to the snapshot remote
+
+The "jiri snapshot push <label>" command shares the snapshots of the given label
+through the git repository given by the -snapshot-remote flag, which is
+typically set once with "jiri config set snapshot-remote <url>".  The snapshots
+that the repository does not have yet are committed under labels/<label>/, and
+the latest snapshot of the label, which the local <label> symlink points to, is
+recorded in the file latest/<label>, since symlinks are not portable.
+
+The repository is cloned into $JIRI_ROOT/.jiri_root/snapshot-remote.  If another
+push to the repository wins a race with this one, the snapshots are rebased onto
+the new state of the repository and pushed again, up to a bounded number of
+attempts.
+
+Usage:
+   jiri snapshot push [flags] <label>
+
+<label> is the snapshot label.
+
+The jiri snapshot push flags are:
+ -snapshot-remote=
+   The git repository that snapshots are shared through.  Can be configured with
+   "jiri config set snapshot-remote <url>".
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -dir=
+   Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri snapshot rename - Rename a snapshot label
+
+The "jiri snapshot rename <old> <new>" command renames the snapshot label <old>
+to <new>, moving its snapshots and pointing the <new> symlink at the snapshot
+that the <old> symlink pointed to.  The new label must not exist yet.  If the
+-push-remote flag is provided, the change is committed and pushed upstream, as
+for "jiri snapshot create".
+
+Usage:
+   jiri snapshot rename [flags] <old> <new>
+
+<old> is the snapshot label to rename, and <new> its new name.
+
+The jiri snapshot rename flags are:
+ -push-remote=false
+   Commit and push the changes upstream.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -dir=
+   Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri status - Summarize the state of the jiri root
+
+Summarize the state of the jiri root: the root directory in use, the manifest
+that "jiri update" tracks, the time of the last successful update, the number of
+local projects along with those that have uncommitted changes or are not on
+their master branch, and the remote imports of the manifest that have not been
+fetched by an update yet.
+
+The names of the dirty and non-master projects are listed if there are at most
+10 of them, or if the -v flag is set.  The mode is "manifest" if the last update
+synced the local projects to the manifest, or "snapshot" if it checked out a
+snapshot with "jiri snapshot checkout".
+
+Usage:
+   jiri status [flags]
+
+The jiri status flags are:
+ -json=false
+   Output the status as a JSON object.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri test - Run the tests of Go packages
+
+Run the tests of Go packages in the environment of profiles.
+
+Usage:
+   jiri test [flags] <command>
+
+The jiri test commands are:
+   run         Run the tests of Go packages
+
+The jiri test flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri test run - Run the tests of Go packages
+
+Run the tests of the given Go packages with "go test", in the environment of the
+profiles given by -profiles, if any, testing several packages in parallel.  The
+status of each package is printed, followed by the output of the packages that
+failed, and the command fails if any package failed.
+
+Packages, or some of their tests, can be excluded with the config file, which
+defaults to $JIRI_ROOT/.jiri_root/test_config.json.  It is a JSON file of the
+form:
+
+  {
+    "exclusions": [
+      {"package": "v.io/jiri/project", "test": "TestHooks.*", "os": "windows", "reason": "..."},
+      {"package": "v.io/jiri/profiles/.*", "reason": "..."}
+    ]
+  }
+
+The "package" and "test" regular expressions match the import paths of the
+packages and the names of their tests as a whole.  An exclusion without a "test"
+excludes the packages entirely, and one with an "os", a comma-separated list of
+GOOS values, only applies on those operating systems.  Excluding tests requires
+the -skip flag of "go test", added in Go 1.20.
+
+Usage:
+   jiri test run [flags] <packages>
+
+<packages> is a list of the packages to test, as accepted by "go list", e.g.
+"v.io/jiri/...".
+
+The jiri test run flags are:
+ -config=
+   Config file listing the packages and tests to exclude.  Defaults to
+   $JIRI_ROOT/.jiri_root/test_config.json.
+ -coverage-dir=
+   Directory to write a coverage profile of each package to.
+ -env=
+   specify an environment variable in the form: <var>=[<val>],...
+ -j=0
+   Number of packages to test concurrently.  Defaults to the number of CPUs.
+ -merge-policies=+CCFLAGS,+CGO_CFLAGS,+CGO_CXXFLAGS,+CGO_LDFLAGS,+CXXFLAGS,GOARCH,GOOS,GOPATH:,^GOROOT*,+LDFLAGS,:PATH,VDLPATH:
+   specify policies for merging environment variables
+ -profiles=
+   a comma separated list of profiles to use
+ -profiles-db=$JIRI_ROOT/.jiri_root/profile_db
+   the path, relative to JIRI_ROOT, that contains the profiles database.
+ -skip-profiles=false
+   if set, no profiles will be used
+ -target=<runtime.GOARCH>-<runtime.GOOS>
+   specifies a profile target in the following form: <arch>-<os>[@<version>], or
+   "native" or the name of a target alias, optionally followed by @<version>
+ -timeout=10m0s
+   Timeout of the tests of each package.
+ -xunit-dir=
+   Directory to write an xUnit report of each package to.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri tools - Inspect the installed jiri tools
+
+Inspect the jiri tools installed in $JIRI_ROOT/.jiri_root/bin.
+
+Usage:
+   jiri tools [flags] <command>
+
+The jiri tools commands are:
+   list        List the installed tools and the revisions they were built from
+
+The jiri tools flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri tools list - List the installed tools and the revisions they were built from
+
+List the tools installed in $JIRI_ROOT/.jiri_root/bin, with the project and
+revision each tool was built from and the time it was built at, which jiri
+embeds into the tools it builds.  The information is read by running each tool
+with the -metadata flag.
+
+Tools built from a revision other than the one the current manifest selects for
+their project, i.e. the pinned revision of the project or the revision of its
+local master branch, are listed as "stale"; run "jiri rebuild" to rebuild them.
+Tools that do not support -metadata, or were not built by jiri, are listed as
+"unknown".
+
+Usage:
+   jiri tools list [flags]
+
+The jiri tools list flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -722,6 +2972,162 @@ individual updates happen guarantees that we end up with a consistent set of
 tools and source code. The set of projects and tools to update is described in
 the manifest.
 
+At the end of the update, a summary is printed that lists the number of projects
+that were created, updated, moved, deleted and left unchanged, the revision
+changes of the projects, the tools that were installed and the time the update
+took.  The -summary-only flag suppresses the logging of the individual
+operations.
+
+While the projects are updated, their progress is reported, so that long clones
+and fetches are not mistaken for a hung update.  On a terminal, a single status
+line, e.g. "[12/80] cloning release.go.x.ref (34s)", is updated in place, even
+with -summary-only; otherwise, a timestamped line is printed when each operation
+starts.  With -v or -progress=off, each operation is logged as it runs instead.
+
+A project that is renamed in the manifest, but keeps its remote, is renamed in
+place or moved to its new path, so that its local branches and stashes are
+preserved.  This is only done if no other local or remote project has the same
+remote; otherwise the old project is treated as deleted, and the new one is
+created.
+
+Only the projects of the enabled project groups are created and updated; see
+"jiri help project group".  Local projects of disabled groups are left
+unchanged, unless -gc is set and their groups are listed by -prune-groups.
+
+The -no-hooks flag skips running the runhooks and installing the githooks
+specified by the manifest, and lists the skipped hooks instead.  The skipped
+hooks are also recorded in the update history snapshot.  To disable hooks
+persistently, set the flag in the jiri config:
+
+  jiri config set no-hooks true
+
+The runhooks run with the environment of jiri, or with a minimal environment for
+the projects whose "hookenv" attribute is "clean", with JIRI_ROOT,
+JIRI_PROJECT_NAME and JIRI_PROJECT_PATH set.  The environment variables of the
+profiles listed by -hook-profiles, and by the "hookprofiles" attribute of each
+project, are merged into the environments of the hooks.  With -v, the
+environment of each hook is logged.
+
+The runhook of a project whose "requiresprofiles" attribute lists profiles that
+are not installed for the native target is skipped, with a message listing the
+missing profiles, unless -install-missing-profiles is set, in which case they
+are installed first.  The profiles are looked up in the profiles database given
+by -profiles-db, and installed in the directory given by -profiles-dir, as by
+the "jiri profile" commands.  An update fails before changing any project if a
+required profile is neither installed nor available from a profile installer.
+
+The -reference-dir flag names a directory of mirror repositories, as created by
+"jiri project mirror", that new projects are cloned with as references, so that
+objects are borrowed from the mirrors rather than fetched from the remotes.  A
+project is cloned normally if its mirror is missing or unusable. Unless
+-dissociate is set, the new projects keep borrowing objects from the mirrors,
+which therefore must not be deleted.  To use mirrors for every update, e.g. on
+bots, set the flag in the jiri config:
+
+  jiri config set reference-dir /var/cache/jiri-mirrors
+
+Before updating, the revisions of the projects that track a remote branch are
+fetched from their googlesource hosts in one request per host, to skip the
+projects that are already up to date.  The -googlesource-hosts flag restricts
+these requests to the given hosts, and the -offline flag skips them, along with
+any other requests that only speed up the update, e.g. when working offline or
+from an untrusted network:
+
+  jiri config set googlesource-hosts vanadium.googlesource.com
+
+The responses of the googlesource hosts are cached for -remote-cache-ttl, so
+that updates run in quick succession, e.g. on the executors of a presubmit
+system, share one request per host.  Concurrent updates on the same machine wait
+for the request of the first one.  The cache is kept in
+.jiri_root/cache/googlesource, or in the directory given by -remote-cache-dir or
+$JIRI_REMOTE_CACHE_DIR, which may be shared by the jiri roots of a machine. The
+-no-remote-cache flag bypasses the cache.
+
+Only the master branches of the projects are updated.  The -rebase-tracked flag
+also rebases the current branch of each updated project that is not on master
+onto the updated master branch.  Projects with uncommitted changes or with a
+merge or rebase in progress are skipped, and rebases that run into conflicts are
+aborted, leaving the branch as it was.  The summary of the update lists the
+branches that were rebased and those that need to be rebased manually.
+
+When the remote of a project changes in the manifest, e.g. because the project
+migrated to a new host, the change is logged and the project is fetched from the
+new remote.  If the revision the project is to be advanced to shares no history
+with the local master branch, the project appears to have been replaced, and the
+update fails rather than resetting master to an unrelated revision.  The
+-force-remote-change flag clones such projects again instead, moving the old
+checkouts to <path>.old.
+
+The git commands that fetch, clone or reset a project are killed if they take
+longer than -git-timeout, e.g. because a remote host stopped responding, and the
+update fails with an error naming the project and its remote.  With -attempts,
+the update is then retried.  Git commands run with a timeout cannot prompt for
+credentials, since they are moved out of the foreground process group, so
+-git-timeout defaults to no limit when stdin is a terminal.
+
+The runhooks of the projects are killed, along with the processes they started,
+if they take longer than -hook-timeout.  The output of a runhook is printed only
+if it fails or -v is set, and the summary of the update lists the runhooks that
+were run, with their durations and outcomes.  The -xunit-out flag also writes
+them to an xUnit report, so that continuous integration systems can show which
+runhook failed.
+
+Once the tools are installed, the post-update commands declared by the
+<postupdate> tags of the manifest are run in order, with JIRI_ROOT set, to keep
+invariants that span projects, such as generated files.  A failing command fails
+the update, unless its "failok" attribute is "true".  The commands that were run
+are listed in the summary of the update, and recorded in the audit log.  The
+-skip-postupdate flag lists the commands instead of running them.
+
+Projects whose "verify" attribute is "signature" are only advanced to revisions
+signed by one of the keys listed in $JIRI_ROOT/.jiri_root/keys; see "jiri help
+manifest".  The update fails if a revision is unsigned or signed by another key.
+In an emergency, -no-verify skips the verification, with a warning for each such
+project.
+
+A snapshot of the projects is added to the update history at the end of each
+update, unless it is identical to the latest snapshot, e.g. because nothing
+changed since the last update; -force-snapshot adds it regardless.  The
+-update-history-keep and -update-history-max-age flags delete the oldest
+snapshots, except the latest two, so that the history does not grow forever;
+they are usually set in the jiri config, e.g.:
+
+  jiri config set update-history-keep 1000
+
+The Git LFS files of the projects whose "lfs" attribute is "true" are fetched
+and checked out after the projects are cloned or updated, which requires git-lfs
+to be installed; the update of such a project fails if it is not.  The
+-detect-lfs flag also fetches the LFS files of the projects whose .gitattributes
+file uses the LFS filter.
+
+The revisions of the manifest projects that the remote manifest imports were
+loaded at are recorded in $JIRI_ROOT/.jiri_root/manifest.lock at the end of each
+successful update.  The -frozen flag loads the imports at these revisions
+instead of the tips of their branches, so that the manifests are the same as for
+the latest update, e.g. to reproduce the update of another developer from their
+manifest.lock; it fails if there is no lock or if it does not record an import.
+The -update-lock flag only updates the lock to the tips of the branches.
+Snapshots embed the lock, and checking out a snapshot restores it.
+
+The -manifest-only flag only updates the manifest projects of the remote
+imports, as the update does before loading the manifest, and prints how the
+manifest changed since the latest update, without updating the projects or
+running any hook or tool build.  The manifest is compared to the latest update
+history snapshot: the projects and tools that were added or removed, the tools
+whose attributes changed, and the projects whose revision in the manifest
+changed are listed.  Projects that track a remote branch are not fetched, so
+their changes are not listed.  The command exits with code 0 if the manifest did
+not change, and 10 otherwise, so that e.g. a cron job can run a full update only
+when needed:
+
+  jiri update -manifest-only; if [ $? -eq 10 ]; then jiri update; fi
+
+The global -manifest flag loads the given manifest file, relative to $JIRI_ROOT,
+instead of $JIRI_ROOT/.jiri_manifest, e.g. to switch between several
+configurations of the same root.  The update history records the manifest file
+of each update, and an update from another manifest file than the latest update
+prints a warning.
+
 Run "jiri help manifest" for details on manifests.
 
 Usage:
@@ -730,13 +3136,199 @@ Usage:
 The jiri update flags are:
  -attempts=1
    Number of attempts before failing.
+ -detect-lfs=false
+   Fetch the Git LFS files of the projects whose .gitattributes file uses the
+   LFS filter, even if their "lfs" attribute is not set.
+ -dissociate=false
+   With -reference-dir, copy the borrowed objects into the new projects, so that
+   they do not depend on the mirror repositories.
+ -fetch-groups=
+   Comma-separated list of project groups to enable, replacing the enabled
+   groups.  See "jiri help project group".
+ -force-remote-change=false
+   Clone projects whose remote changed to a repository with unrelated history
+   again, moving the old checkouts to <path>.old.
+ -force-snapshot=false
+   Add a snapshot to the update history even if it is identical to the latest
+   one.
+ -frozen=false
+   Load the remote manifest imports at the revisions recorded in the manifest
+   lock by the latest update, rather than at the tips of their branches.
  -gc=false
    Garbage collect obsolete repositories.
+ -git-timeout=10m0s
+   Maximum time a git command that fetches, clones or resets a project may take
+   before it is killed and the update fails.  Zero means no limit, which is the
+   default when stdin is a terminal.
+ -go-root=
+   Directory of the Go toolchain to build the tools with.  If empty, the
+   toolchain installed by the "go" profile is used if there is one, and the "go"
+   binary in PATH otherwise.
+ -googlesource-hosts=
+   Comma-separated list of googlesource hosts that the revisions of projects may
+   be fetched from; other hosts are skipped.  If empty, all hosts are queried.
+ -hook-profiles=
+   Comma-separated list of profiles whose environment variables are merged into
+   the environment of the runhooks of all projects.
+ -hook-timeout=5m0s
+   Maximum time the runhook of a project may take before it is killed and the
+   update fails.  Zero means no limit.
+ -install-missing-profiles=false
+   Install the profiles required by the runhooks of projects that are not
+   installed, rather than skip the runhooks.
  -manifest=
    Name of the project manifest.
+ -manifest-only=false
+   Only update the manifest projects of the remote imports, and print how the
+   manifest changed since the latest update, without updating the projects.
+ -no-hooks=false
+   Do not run the runhooks or install the githooks of projects, list them
+   instead.
+ -no-remote-cache=false
+   Fetch the revisions of projects from googlesource hosts, rather than use the
+   responses cached by recent updates.
+ -no-verify=false
+   Do not verify the signatures of the revisions of the projects whose "verify"
+   attribute is "signature".  For emergencies only.
+ -offline=false
+   Skip the network requests that only speed up the update, such as fetching the
+   revisions of projects from googlesource hosts.
+ -profiles-db=$JIRI_ROOT/.jiri_root/profile_db
+   the path, relative to JIRI_ROOT, that contains the profiles database.
+ -profiles-dir=.jiri_root/profiles
+   the directory, relative to JIRI_ROOT, that profiles are installed in
+ -progress=auto
+   How to report the progress of the project operations: "auto" for a status
+   line on a terminal, or a line per operation otherwise, or "off" to log each
+   operation instead.
+ -prune-groups=
+   Comma-separated list of disabled project groups whose local projects are
+   deleted if -gc is set.
+ -rebase-tracked=false
+   Rebase the current branch of each updated project that is not on master onto
+   the updated master branch.
+ -reference-dir=
+   Directory of mirror repositories, as created by "jiri project mirror", to
+   borrow objects from when cloning new projects.
+ -remote-cache-dir=
+   Directory to cache the responses of googlesource hosts in, e.g. one shared by
+   the jiri roots of a machine.  Overrides $JIRI_REMOTE_CACHE_DIR.  Defaults to
+   $JIRI_ROOT/.jiri_root/cache/googlesource.
+ -remote-cache-ttl=1m0s
+   How long the cached responses of googlesource hosts are used for.  Zero
+   disables the cache.
+ -skip-postupdate=false
+   Do not run the post-update commands of the manifest, list them instead.
+ -summary-only=false
+   Only print the summary of the update, rather than logging each operation.
+ -update-history-keep=0
+   Number of the most recent update history snapshots to keep; older snapshots
+   are deleted.  Zero means no limit.
+ -update-history-max-age=0s
+   Maximum age of the update history snapshots to keep, e.g. 720h; older
+   snapshots are deleted.  Zero means no limit.
+ -update-lock=false
+   Only update the manifest lock to the tips of the branches of the remote
+   manifest imports, without updating the projects.
+ -xunit-out=
+   File to write the outcome of the runhooks to as an xUnit report, with one
+   test case per runhook.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri update-history - Manage the update history
+
+Manage the update history, the snapshots of the projects recorded at the end of
+each "jiri update" in $JIRI_ROOT/.jiri_root/update_history.
 
+Usage:
+   jiri update-history [flags] <command>
+
+The jiri update-history commands are:
+   prune       Delete old update history snapshots
+
+The jiri update-history flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri update-history prune - Delete old update history snapshots
+
+Delete the oldest update history snapshots, keeping at most -update-history-keep
+snapshots and none older than -update-history-max-age, and report how many
+snapshots were deleted.  The snapshots pointed to by the latest and
+second-latest links are never deleted.  The flags have the same names as those
+of "jiri update", so that the retention policy can be set for both commands in
+the jiri config, e.g.:
+
+  jiri config set update-history-keep 1000
+
+Usage:
+   jiri update-history prune [flags]
+
+The jiri update-history prune flags are:
+ -update-history-keep=0
+   Number of the most recent snapshots to keep.  Zero means no limit.
+ -update-history-max-age=0s
+   Maximum age of the snapshots to keep, e.g. 720h.  Zero means no limit.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -750,18 +3342,115 @@ If the binary is being run directly, the output looks like this:
 
   # binary
   /path/to/binary/jiri
+  # root from $JIRI_ROOT
+  /path/to/root
 
 If the script is being run, the output looks like this:
 
   # script
   /path/to/script/jiri
 
+The binary also shows the jiri root and how it was found: from $JIRI_ROOT if it
+is set, or otherwise from the closest .jiri_root directory above the current
+directory, as the script does.  The binary can thus be run without the script.
+
+On Windows, where the script is replaced by a jiri.bat, jiri.cmd or jiri.ps1
+shim, the binary cannot tell whether it was run via the shim, so the shim found
+first in PATH, if any, is shown before the binary:
+
+  # script
+  C:\path\to\script\jiri.bat
+  # binary
+  ...
+
 Usage:
    jiri which [flags]
 
 The jiri which flags are:
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
+ -color=true
+   Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
+ -v=false
+   Print verbose output.
+
+Jiri grep - Search for a pattern across jiri projects
+
+Run "git grep" for the given pattern in each of the selected local projects
+concurrently.  Each line of output is prefixed with the path of the project
+relative to the jiri root, so that matches from all projects can be read as a
+single listing.  Colors are preserved when the output is a terminal.
+
+The exit status is 0 if any project had a match, 1 if no project did and 2 if an
+error occurred.
+
+Usage:
+   jiri grep [flags] <pattern> [<path>...]
+
+<pattern> is the pattern to search for, as accepted by "git grep".  The optional
+<path> arguments limit the search to the given paths within each project.
+
+The jiri grep flags are:
+ -E=false
+   Use POSIX extended regular expressions for the pattern.
+ -from-manifest=false
+   If set, also match the projects of the manifest that do not exist locally.
+   They are reported, and skipped, so that they can be cloned with "jiri project
+   checkout".
+ -has-branch=
+   A regular expression specifying branch names to use in matching projects. A
+   project will match if the specified branch exists, even if it is not checked
+   out.
+ -has-gerrit-message=false
+   If specified, match branches that have, or have no, gerrit message
+ -has-uncommitted=false
+   If specified, match projects that have, or have no, uncommitted changes
+ -has-untracked=false
+   If specified, match projects that have, or have no, untracked files
+ -i=false
+   Ignore case differences between the pattern and the files.
+ -l=false
+   Show only the names of files that contain matches.
+ -projects=
+   A Regular expression specifying project keys to run commands in. By default,
+   all projects are searched.
+ -w=false
+   Match the pattern only at word boundaries.
+
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
  -v=false
    Print verbose output.
 
@@ -772,6 +3461,26 @@ profile target's environment. Commands are run using the shell specified by the
 users $SHELL environment variable, or "sh" if that's not set. Thus commands are
 run as $SHELL -c "args..."
 
+Once all the commands have completed, a table of the exit status and duration of
+the command in each project is printed to stderr if any of them failed, or if
+the -summary flag is set. The -json flag writes the same results to a file, or
+to stdout if the file is "-", as a JSON array of objects with the fields key,
+name, path, exitCode, durationMs, stdoutBytes and stderrBytes. The exit code is
+-1 for commands that could not be started or were killed, and the output sizes
+are not counted in -interactive mode.
+
+The exit code of runp is the largest exit code of the commands, or 1 if a
+command failed without an exit code.
+
+By default the commands run in all projects at once, in no particular order. The
+-ordered flag runs them one at a time, in the order of the paths of the
+projects, so that a project is processed before the projects nested in it. The
+-batch-by-depth flag groups the projects by the depth of their paths under
+$JIRI_ROOT, and runs the commands in each group in parallel, starting a group
+only once the commands in the shallower groups have completed.  With -v, the
+chosen order is printed before the commands are run.  If -exit-on-error is set
+and a command fails, the remaining projects are not processed.
+
 Usage:
    jiri runp [flags] <command line>
 
@@ -781,6 +3490,10 @@ is run must be quoted to avoid expansion before being passed to runp by the
 shell.
 
 The jiri runp flags are:
+ -batch-by-depth=false
+   If set, run the command in parallel in the projects at the same depth under
+   JIRI_ROOT, waiting for the shallower projects to complete before starting the
+   deeper ones. This flag cannot be used with -ordered.
  -collate-stdout=true
    Collate all stdout output from each parallel invocation and display it as if
    had been generated sequentially. This flag cannot be used with
@@ -790,6 +3503,10 @@ The jiri runp flags are:
  -exit-on-error=false
    If set, all commands will killed as soon as one reports an error, otherwise,
    each will run to completion.
+ -from-manifest=false
+   If set, also match the projects of the manifest that do not exist locally.
+   They are reported, and skipped, so that they can be cloned with "jiri project
+   checkout".
  -has-branch=
    A regular expression specifying branch names to use in matching projects. A
    project will match if the specified branch exists, even if it is not checked
@@ -804,8 +3521,15 @@ The jiri runp flags are:
    If set, the command to be run is interactive and should not have its
    stdout/stderr manipulated. This flag cannot be used with -show-name-prefix,
    -show-key-prefix or -collate-stdout.
+ -json=
+   If set, write the exit status, duration and output sizes of the command in
+   each project as a JSON array to the given file, or to stdout if the file is
+   "-".
  -merge-policies=+CCFLAGS,+CGO_CFLAGS,+CGO_CXXFLAGS,+CGO_LDFLAGS,+CXXFLAGS,GOARCH,GOOS,GOPATH:,^GOROOT*,+LDFLAGS,:PATH,VDLPATH:
    specify policies for merging environment variables
+ -ordered=false
+   If set, run the command in one project at a time, in the order of the project
+   paths. This flag cannot be used with -batch-by-depth.
  -profiles=
    a comma separated list of profiles to use
  -profiles-db=$JIRI_ROOT/.jiri_root/profile_db
@@ -829,13 +3553,34 @@ The jiri runp flags are:
    -collate-stdout.
  -skip-profiles=false
    if set, no profiles will be used
+ -summary=false
+   If set, print a table of the exit status and duration of the command in each
+   project once all have completed, even if none failed.
  -target=<runtime.GOARCH>-<runtime.GOOS>
-   specifies a profile target in the following form: <arch>-<os>[@<version>]
+   specifies a profile target in the following form: <arch>-<os>[@<version>], or
+   "native" or the name of a target alias, optionally followed by @<version>
  -v=false
    Print verbose logging information
 
+ -allow-nested-root=false
+   Allow $JIRI_ROOT to be nested inside another jiri root or a project.
  -color=true
    Use color to format output.
+ -credential-helper=
+   Git credential helper to use for all git commands, e.g. for bots that clone
+   private repositories over HTTPS.  Overrides $JIRI_GIT_CREDENTIAL_HELPER.
+ -error-json=
+   If the command fails, write the error, its kind and the exit code as JSON to
+   the given file, e.g. for CI.  See "jiri help exit-codes".
+ -manifest=
+   Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to
+   $JIRI_ROOT.
+ -time-file=
+   Write the timing information as JSON to the given file before exiting the
+   program.
+ -time-json=false
+   With -time, dump the timing information as JSON, including the category of
+   each interval.
 
 Jiri help - Display help for commands or topics
 
@@ -863,6 +3608,37 @@ The jiri help flags are:
    Defaults to the terminal width if available.  Override the default by setting
    the CMDLINE_WIDTH environment variable.
 
+Jiri exit-codes - Description of the exit codes of jiri
+
+The exit code of a failed jiri command tells the kind of the failure, so that
+scripts can e.g. retry after network failures but report broken manifests:
+
+ 1  unknown failure
+ 2  usage error, e.g. wrong arguments or flags
+ 3  network failure, e.g. a remote that cannot be reached; retrying may help
+ 4  manifest error, e.g. an invalid manifest file, a duplicate project or an
+    import cycle
+ 5  git conflict, e.g. a changelist that conflicts with the remote branch, or
+    a project whose new remote has unrelated history
+ 6  tool build failure, e.g. a compile error or a Go toolchain that is too old
+
+"jiri update -manifest-only" exits with code 10, rather than 0, if the manifest
+changed since the latest update; see "jiri help update".
+
+Failures that are not classified exit with code 1, and commands run by jiri,
+such as external subcommands, keep their own exit codes.  With -v, the kind of
+the failure is printed along with the error, and -error-json writes the error,
+its kind and the exit code as JSON to a file:
+
+  {
+    "error": "...",
+    "kind": "network",
+    "exitCode": 3
+  }
+
+The kinds are "unknown", "usage", "network", "manifest", "git-conflict" and
+"tool-build".
+
 Jiri filesystem - Description of jiri file system layout
 
 All data managed by the jiri tool is located in the file system under a root
@@ -872,6 +3648,8 @@ looks like this:
  [root]                              # root directory (name picked by user)
  [root]/.jiri_root                   # root metadata directory
  [root]/.jiri_root/bin               # contains tool binaries (jiri, etc.)
+ [root]/.jiri_root/config            # default flag values
+ [root]/.jiri_root/scan-ignore       # directories not scanned for projects
  [root]/.jiri_root/update_history    # contains history of update snapshots
  [root]/.manifest                    # contains jiri manifests
  [root]/[project1]                   # project directory (name picked by user)
@@ -911,16 +3689,19 @@ tools under that [root] directory will be updated.
 The shim script is located at [root]/release/go/src/v.io/jiri/scripts/jiri
 
 2) Direct binary.  This is the jiri binary, containing all of the actual jiri
-tool logic.  The binary requires the JIRI_ROOT environment variable to point to
-the [root] directory.
+tool logic.  The binary finds the [root] directory the same way as the shim
+script, and sets the JIRI_ROOT environment variable to its location for the
+commands it runs.  If the JIRI_ROOT environment variable is set, but the current
+working directory is inside another [root] directory, the binary uses JIRI_ROOT
+and prints a warning.  Run "jiri which" to show the [root] directory and how it
+was found.
 
 Note that if you have multiple [root] directories on your file system, you must
-remember to run the jiri binary corresponding to the setting of your JIRI_ROOT
-environment variable.  Things may fail if you mix things up, since the jiri
-binary is updated with each call to "jiri update", and you may encounter version
-mismatches between the jiri binary and the various metadata files or other
-logic.  This is the reason the shim script is recommended over running the
-binary directly.
+remember to run the jiri binary corresponding to the [root] directory you work
+in.  Things may fail if you mix things up, since the jiri binary is updated with
+each call to "jiri update", and you may encounter version mismatches between the
+jiri binary and the various metadata files or other logic.  This is the reason
+the shim script is recommended over running the binary directly.
 
 The jiri binary is located at [root]/.jiri_root/bin/jiri
 
@@ -957,6 +3738,7 @@ Manifests have the following XML schema:
              gerrithost="https://myorg-review.googlesource.com"
              githooks="path/to/githooks-dir"
              runhook="path/to/runhook-script"
+             hookenv="clean"
     />
     ...
   </projects>
@@ -967,8 +3749,21 @@ Manifests have the following XML schema:
     />
     ...
   </tools>
+  <postupdates>
+    <postupdate command="make compile_commands.json"
+                project="my-project"
+                failok="true"
+    />
+    ...
+  </postupdates>
 </manifest>
 
+The optional "version" attribute of the <manifest> tag is the version of the
+manifest format, of the form "<major>" or "<major>.<minor>".  Jiri refuses
+manifests with a newer major version than it supports, and warns about the tags
+and attributes it does not know, which it ignores.  Manifests without a version
+are always accepted.
+
 The <import> and <localimport> tags can be used to share common projects and
 tools across multiple manifests.
 
@@ -992,6 +3787,18 @@ manifest remote, then the "name" attribute of on the <import> tag should match
 the "name" attribute on the <project>.  Otherwise, jiri will clone the manifest
 repository on every update.
 
+* remotebranch (optional) - The remote branch of the manifest repository to
+track.  Defaults to "master".  The "remotebranch" attribute is ignored if
+"revision" is specified.
+
+* revision (optional) - The specific revision (usually a git SHA) of the
+manifest repository to use.  This can be used to freeze the imported manifest at
+a known-good state.  If "revision" is specified then the "remotebranch"
+attribute is ignored.
+
+* verify (optional) - If "signature", the revisions of the manifest repository
+must be signed, as for projects.
+
 The <project> tags describe the projects to sync, and what state they should
 sync to, accoring to the following attributes:
 
@@ -1009,13 +3816,51 @@ Currently "git" is the default and only supported protocol.
 Defaults to "master".  The "remotebranch" attribute is ignored if "revision" is
 specified.
 
+* fallbackbranch (optional) - The remote branch that the project will sync to
+while the "remotebranch" does not exist in the remote, e.g. because it was
+deleted or renamed upstream.  Without it, "jiri update" fails for such a
+project, listing the branches of the remote, but still updates the other
+projects.
+
 * revision (optional) - The specific revision (usually a git SHA) that the
 project will sync to.  If "revision" is  specified then the "remotebranch"
 attribute is ignored.
 
+* verify (optional) - If "signature", "jiri update" only advances the project to
+revisions whose commit, or tag if the revision is an annotated tag, is signed by
+one of the trusted keys, and fails otherwise.  The fingerprints of the trusted
+keys are listed one per line in the files in $JIRI_ROOT/.jiri_root/keys, and the
+keys must be in the keyring of gpg.
+
+* clonefilter (optional) - The filter, e.g. "blob:none" or "tree:0", passed to
+"git clone --filter" to create a partial clone of the project.  The objects
+omitted by the filter are fetched from the remote on demand, e.g. when an older
+revision is checked out.  Only supported with the "git" protocol.
+
+* sparsecheckout (optional) - A comma-separated list of directories, relative to
+the root of the project, that the working tree of the project is restricted to,
+along with the files at the root, using a cone-mode "git sparse-checkout".
+Useful for huge repositories of which only a few directories are needed.  "jiri
+update" applies changes to the list, and checks out the whole tree again when
+the attribute is removed.  Only supported with the "git" protocol.
+
+* lfs (optional) - If "true", the project stores files with Git LFS, and "jiri
+update" installs the LFS filters in the project and fetches the contents of the
+LFS files after cloning or updating it, so that the working tree does not
+contain LFS pointer files.  Requires git-lfs to be installed.
+
 * gerrithost (optional) - The url of the Gerrit host for the project.  If
 specified, then running "jiri cl mail" will upload a CL to this Gerrit host.
 
+* gerritremote (optional) - The name of the git remote that "jiri cl mail"
+pushes CLs to.  If specified, "jiri update" configures this remote to point at
+the project on the Gerrit host.  Requires "gerrithost" to be specified.
+
+* reviewhost (optional) - The url of the repository of the project on GitHub or
+a GitHub Enterprise host, such as "https://github.com/myorg/myproject".  If
+specified, then running "jiri cl mail" will send a pull request to this
+repository.  Cannot be specified with "gerrithost".
+
 * githooks (optional) - The path (relative to $JIRI_ROOT) of a directory
 containing git hooks that will be installed in the projects .git/hooks directory
 during each update.
@@ -1023,6 +3868,30 @@ during each update.
 * runhook (optional) - The path (relate to $JIRI_ROOT) of a script that will be
 run during each update.
 
+* hookenv (optional) - The environment the runhook script runs with: "inherit",
+the default, for the environment of jiri, or "clean" for a minimal environment
+with only the PATH and HOME variables of jiri, so that the script behaves the
+same on all machines.  In both cases, JIRI_ROOT, JIRI_PROJECT_NAME and
+JIRI_PROJECT_PATH are set.
+
+* hookprofiles (optional) - A comma-separated list of the profiles whose
+environment variables are merged into the environment of the runhook script.
+
+* requiresprofiles (optional) - A comma-separated list of the profiles that must
+be installed for the native target before the runhook script runs, e.g.
+"go,base".  The script is skipped if they are not, unless "jiri update
+-install-missing-profiles" installs them.
+
+* exclude (optional) - If "true", the project is a stub that removes the
+projects with the same name, and the same remote if "remote" is specified, from
+the manifest, e.g. to drop projects of an imported manifest that are replaced
+locally.  The projects are removed whether they are declared before or after the
+stub, in any manifest file, and the other attributes of the stub, including
+"groups", are ignored.  The names of the projects of an import with a "root" are
+prefixed with the root, so a stub outside of that import must name them
+"<root>/<name>".  Local copies of removed projects are treated like those of any
+project that is not in the manifest, and are deleted by "jiri update -gc".
+
 The <tool> tags describe the tools that will be compiled and installed in
 $JIRI_ROOT/.jiri_root/bin after each update.  The tools must be written in go,
 and are identified by their package name and the project that contains their
@@ -1036,5 +3905,33 @@ code.  They are configured via the following attributes:
 
 * project (required) - The name of the project that contains the source code
   for the tool.
+
+* goversion (optional) - The oldest Go version the tool can be built with,
+  e.g. "1.6".  If the Go toolchain the tools are built with is older, "jiri
+  update" fails before updating any project.  The toolchain is the one given
+  by the -go-root flag, or the one installed by the "go" profile, or the "go"
+  binary in PATH, in this order.
+
+* os, arch (optional) - Comma-separated lists of the operating systems and
+  architectures, as in GOOS and GOARCH, that the tool is built on, e.g.
+  os="linux" for a tool that links a Linux-only library.  On other hosts, the
+  tool is skipped, and reported as such in the summary of "jiri update".
+  Each tool is built separately, so a tool that fails to build does not
+  prevent the others from being installed; "jiri update" fails only if a tool
+  that is built on the host failed.
+
+The <postupdate> tags describe commands that are run, in the order they are
+declared, at the end of each "jiri update" and "jiri snapshot checkout", once
+the tools are installed.  They keep invariants that span projects, such as
+generated files, and are configured via the following attributes:
+
+* command (required) - The command line to run, with "sh -c".  JIRI_ROOT is
+  set in its environment.
+
+* project (optional) - The name of the project whose directory the command is
+  run in.  Defaults to the jiri root.
+
+* failok (optional) - If "true", a failure of the command is reported, but
+  does not fail the update.
 */
 package main
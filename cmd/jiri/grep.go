@@ -0,0 +1,186 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"v.io/jiri"
+	"v.io/jiri/project"
+	"v.io/jiri/runutil"
+	"v.io/jiri/tool"
+	"v.io/x/lib/cmdline"
+)
+
+var (
+	cmdGrep   *cmdline.Command
+	grepFlags grepFlagValues
+)
+
+type grepFlagValues struct {
+	projectSelectionFlagValues
+	ignoreCase     bool
+	filesWithMatch bool
+	wordRegexp     bool
+	extendedRegexp bool
+}
+
+func newGrep() *cmdline.Command {
+	return &cmdline.Command{
+		Runner: jiri.RunnerFunc(runGrep),
+		Name:   "grep",
+		Short:  "Search for a pattern across jiri projects",
+		Long: `
+Run "git grep" for the given pattern in each of the selected local projects
+concurrently.  Each line of output is prefixed with the path of the project
+relative to the jiri root, so that matches from all projects can be read as a
+single listing.  Colors are preserved when the output is a terminal.
+
+The exit status is 0 if any project had a match, 1 if no project did and 2 if
+an error occurred.
+`,
+		ArgsName: "<pattern> [<path>...]",
+		ArgsLong: `
+<pattern> is the pattern to search for, as accepted by "git grep".  The optional
+<path> arguments limit the search to the given paths within each project.
+`,
+	}
+}
+
+func init() {
+	// Avoid an initialization loop between cmdline.Command.Runner which
+	// refers to cmdGrep and runGrep referring back to cmdGrep.ParsedFlags.
+	cmdGrep = newGrep()
+	cmdRoot.Children = append(cmdRoot.Children, cmdGrep)
+	flags := &cmdGrep.Flags
+	registerProjectSelectionFlags(flags, &grepFlags.projectSelectionFlagValues, "By default, all projects are searched.")
+	flags.BoolVar(&grepFlags.ignoreCase, "i", false, "Ignore case differences between the pattern and the files.")
+	flags.BoolVar(&grepFlags.filesWithMatch, "l", false, "Show only the names of files that contain matches.")
+	flags.BoolVar(&grepFlags.wordRegexp, "w", false, "Match the pattern only at word boundaries.")
+	flags.BoolVar(&grepFlags.extendedRegexp, "E", false, "Use POSIX extended regular expressions for the pattern.")
+}
+
+// grepResult records the outcome of running git grep in a single project.
+type grepResult struct {
+	output  bytes.Buffer
+	matched bool
+	err     error
+}
+
+func runGrep(jirix *jiri.X, args []string) error {
+	if len(args) == 0 {
+		return jirix.UsageErrorf("no pattern specified")
+	}
+	states, keys, err := selectProjects(jirix, cmdGrep.ParsedFlags, &grepFlags.projectSelectionFlagValues, true)
+	if err != nil {
+		return err
+	}
+	grepArgs := gitGrepArgs(isTerminal(jirix.Stdout()) && jirix.Color(), args)
+	results := make([]*grepResult, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		results[i] = &grepResult{}
+		wg.Add(1)
+		go func(result *grepResult, state *project.ProjectState) {
+			defer wg.Done()
+			result.matched, result.err = grepProject(jirix.Clone(tool.ContextOpts{}), state.Project, grepArgs, &result.output)
+		}(results[i], states[key])
+	}
+	wg.Wait()
+
+	// Print the results in the order of the project keys, so that the
+	// output is the same from one run to the next.
+	matched, failed := false, false
+	for i, result := range results {
+		jirix.Stdout().Write(result.output.Bytes())
+		if result.err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%v: %v\n", states[keys[i]].Project.Name, result.err)
+			failed = true
+		}
+		matched = matched || result.matched
+	}
+	switch {
+	case failed:
+		return cmdline.ErrExitCode(2)
+	case !matched:
+		return cmdline.ErrExitCode(1)
+	}
+	return nil
+}
+
+// gitGrepArgs returns the arguments for running git grep with the given
+// pattern and paths.
+func gitGrepArgs(color bool, args []string) []string {
+	grepArgs := []string{"grep"}
+	if color {
+		grepArgs = append(grepArgs, "--color=always")
+	} else {
+		grepArgs = append(grepArgs, "--color=never")
+	}
+	if grepFlags.ignoreCase {
+		grepArgs = append(grepArgs, "-i")
+	}
+	if grepFlags.filesWithMatch {
+		grepArgs = append(grepArgs, "-l")
+	}
+	if grepFlags.wordRegexp {
+		grepArgs = append(grepArgs, "-w")
+	}
+	if grepFlags.extendedRegexp {
+		grepArgs = append(grepArgs, "-E")
+	}
+	grepArgs = append(grepArgs, "-e", args[0])
+	if len(args) > 1 {
+		grepArgs = append(grepArgs, "--")
+		grepArgs = append(grepArgs, args[1:]...)
+	}
+	return grepArgs
+}
+
+// grepProject runs git grep with the given arguments in the given project,
+// writing its output to w with each line prefixed by the project path
+// relative to the jiri root.  It returns whether git grep found any matches.
+func grepProject(jirix *jiri.X, project project.Project, args []string, w io.Writer) (bool, error) {
+	var stdout, stderr bytes.Buffer
+	err := jirix.NewSeq().Capture(&stdout, &stderr).Dir(project.Path).Last("git", args...)
+	if err != nil {
+		// Git grep exits with 1 if there are no matches.
+		if runutil.TranslateExitCode(err) == cmdline.ErrExitCode(1) && stderr.Len() == 0 {
+			return false, nil
+		}
+		if stderr.Len() > 0 {
+			return false, fmt.Errorf("%s", bytes.TrimSpace(stderr.Bytes()))
+		}
+		return false, err
+	}
+	prefix, err := filepath.Rel(jirix.Root, project.Path)
+	if err != nil {
+		return false, err
+	}
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		fmt.Fprintf(w, "%s%c%s\n", prefix, filepath.Separator, scanner.Text())
+	}
+	return true, scanner.Err()
+}
+
+// isTerminal returns true if the given writer is a terminal.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"v.io/jiri/gitutil"
+	"v.io/jiri/jiritest"
+	"v.io/jiri/project"
+	"v.io/jiri/tool"
+	"v.io/x/lib/cmdline"
+)
+
+func TestGrep(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	files := map[string]string{
+		"alpha": "Hello world\nhello again\n",
+		"beta":  "goodbye world\n",
+	}
+	for _, name := range []string{"alpha", "beta"} {
+		if err := fake.CreateRemoteProject(name); err != nil {
+			t.Fatal(err)
+		}
+		if err := fake.AddProject(project.Project{
+			Name:   name,
+			Path:   filepath.Join("src", name),
+			Remote: fake.Projects[name],
+		}); err != nil {
+			t.Fatal(err)
+		}
+		dir := fake.Projects[name]
+		file := filepath.Join(dir, "file")
+		if err := ioutil.WriteFile(file, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(dir))
+		if err := git.Add(file); err != nil {
+			t.Fatal(err)
+		}
+		if err := git.CommitWithMessage("add file"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdGrep.Flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	cmdGrep.ParsedFlags = &cmdGrep.Flags
+	defer func() {
+		grepFlags = grepFlagValues{}
+	}()
+
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+	tests := []struct {
+		ignoreCase, filesWithMatch bool
+		pattern                    string
+		want                       string
+		err                        error
+	}{
+		{false, false, "world", "src/alpha/file:Hello world\nsrc/beta/file:goodbye world\n", nil},
+		{false, false, "hello", "src/alpha/file:hello again\n", nil},
+		{true, false, "hello", "src/alpha/file:Hello world\nsrc/alpha/file:hello again\n", nil},
+		{false, true, "world", "src/alpha/file\nsrc/beta/file\n", nil},
+		{false, false, "nomatch", "", cmdline.ErrExitCode(1)},
+		{false, false, "[", "", cmdline.ErrExitCode(2)},
+	}
+	for _, test := range tests {
+		stdout.Reset()
+		stderr.Reset()
+		grepFlags.ignoreCase, grepFlags.filesWithMatch = test.ignoreCase, test.filesWithMatch
+		if got, want := runGrep(fake.X, []string{test.pattern}), test.err; got != want {
+			t.Errorf("%q: got error %v, want %v", test.pattern, got, want)
+		}
+		if got, want := stdout.String(), test.want; got != want {
+			t.Errorf("%q: got\n%v\nwant\n%v", test.pattern, got, want)
+		}
+	}
+	if stderr.Len() == 0 {
+		t.Errorf("expected an invalid pattern to be reported on stderr")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"v.io/jiri"
+	"v.io/jiri/project"
+	"v.io/x/lib/cmdline"
+)
+
+// cmdProjectGroup represents the "jiri project group" command.
+var cmdProjectGroup = &cmdline.Command{
+	Name:  "group",
+	Short: "Manage the enabled project groups",
+	Long: `
+Manage the project groups that are enabled in the jiri root.  Projects and
+imports in the manifest may list the groups they belong to in their "groups"
+attribute; those without the attribute belong to the "default" group.  Only
+the projects that belong to an enabled group are loaded from the manifest,
+and thus created and updated by "jiri update".  Only the "default" group is
+enabled unless the enabled groups are changed.
+
+The enabled groups are stored in $JIRI_ROOT/.jiri_root/groups, and can also be
+set with the -fetch-groups flag of "jiri update".
+`,
+	Children: []*cmdline.Command{cmdProjectGroupAdd, cmdProjectGroupList, cmdProjectGroupRemove},
+}
+
+// cmdProjectGroupAdd represents the "jiri project group add" command.
+var cmdProjectGroupAdd = &cmdline.Command{
+	Runner:   jiri.RunnerFunc(runProjectGroupAdd),
+	Name:     "add",
+	Short:    "Enable project groups",
+	Long:     "Enable the given project groups.  Run \"jiri update\" to create the projects of the groups.",
+	ArgsName: "<group ...>",
+	ArgsLong: "<group ...> is a list of project groups to enable.",
+}
+
+// cmdProjectGroupList represents the "jiri project group list" command.
+var cmdProjectGroupList = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectGroupList),
+	Name:   "list",
+	Short:  "List the enabled project groups",
+	Long:   "List the enabled project groups, one per line.",
+}
+
+// cmdProjectGroupRemove represents the "jiri project group remove" command.
+var cmdProjectGroupRemove = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectGroupRemove),
+	Name:   "remove",
+	Short:  "Disable project groups",
+	Long: `
+Disable the given project groups.  Existing local projects of the groups are
+left unchanged by "jiri update", unless the groups are also passed to its
+-prune-groups flag along with -gc.
+`,
+	ArgsName: "<group ...>",
+	ArgsLong: "<group ...> is a list of project groups to disable.",
+}
+
+func runProjectGroupAdd(jirix *jiri.X, args []string) error {
+	if len(args) == 0 {
+		return jirix.UsageErrorf("no groups specified")
+	}
+	groups, err := project.EnabledGroups(jirix)
+	if err != nil {
+		return err
+	}
+	return project.SetEnabledGroups(jirix, append(groups, args...))
+}
+
+func runProjectGroupList(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	groups, err := project.EnabledGroups(jirix)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		fmt.Fprintln(jirix.Stdout(), group)
+	}
+	return nil
+}
+
+func runProjectGroupRemove(jirix *jiri.X, args []string) error {
+	if len(args) == 0 {
+		return jirix.UsageErrorf("no groups specified")
+	}
+	remove := map[string]bool{}
+	for _, group := range args {
+		remove[group] = true
+	}
+	groups, err := project.EnabledGroups(jirix)
+	if err != nil {
+		return err
+	}
+	var result []string
+	for _, group := range groups {
+		if !remove[group] {
+			result = append(result, group)
+		}
+	}
+	if len(result) == 0 {
+		return fmt.Errorf("cannot disable all project groups")
+	}
+	return project.SetEnabledGroups(jirix, result)
+}
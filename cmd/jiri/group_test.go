@@ -0,0 +1,45 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/jiri/tool"
+)
+
+func TestProjectGroup(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+
+	tests := []struct {
+		run  func() error
+		want string
+	}{
+		{func() error { return nil }, "default\n"},
+		{func() error { return runProjectGroupAdd(fake.X, []string{"web", "android"}) }, "android\ndefault\nweb\n"},
+		{func() error { return runProjectGroupAdd(fake.X, []string{"web"}) }, "android\ndefault\nweb\n"},
+		{func() error { return runProjectGroupRemove(fake.X, []string{"default", "android"}) }, "web\n"},
+	}
+	for i, test := range tests {
+		if err := test.run(); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		stdout.Reset()
+		if err := runProjectGroupList(fake.X, nil); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if got := stdout.String(); got != test.want {
+			t.Errorf("%d: got %q, want %q", i, got, test.want)
+		}
+	}
+	if err := runProjectGroupRemove(fake.X, []string{"web"}); err == nil {
+		t.Errorf("expected disabling all groups to fail")
+	}
+}
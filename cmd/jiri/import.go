@@ -10,25 +10,29 @@ import (
 	"v.io/jiri"
 	"v.io/jiri/project"
 	"v.io/jiri/runutil"
+	"v.io/jiri/tool"
 	"v.io/x/lib/cmdline"
 )
 
 var (
 	// Flags for configuring project attributes for remote imports.
-	flagImportName, flagImportProtocol, flagImportRemoteBranch, flagImportRoot string
+	flagImportName, flagImportProtocol, flagImportRemoteBranch, flagImportRevision, flagImportRoot string
 	// Flags for controlling the behavior of the command.
-	flagImportOverwrite bool
-	flagImportOut       string
+	flagImportOverwrite    bool
+	flagImportOut          string
+	flagImportManifestFile string
 )
 
 func init() {
 	cmdImport.Flags.StringVar(&flagImportName, "name", "manifest", `The name of the remote manifest project.`)
 	cmdImport.Flags.StringVar(&flagImportProtocol, "protocol", "git", `The version control protocol used by the remote manifest project.`)
 	cmdImport.Flags.StringVar(&flagImportRemoteBranch, "remote-branch", "master", `The branch of the remote manifest project to track, without the leading "origin/".`)
+	cmdImport.Flags.StringVar(&flagImportRevision, "revision", "", `The revision of the remote manifest project to use.  If set, the remote branch is ignored.`)
 	cmdImport.Flags.StringVar(&flagImportRoot, "root", "", `Root to store the manifest project locally.`)
 
 	cmdImport.Flags.BoolVar(&flagImportOverwrite, "overwrite", false, `Write a new .jiri_manifest file with the given specification.  If it already exists, the existing content will be ignored and the file will be overwritten.`)
 	cmdImport.Flags.StringVar(&flagImportOut, "out", "", `The output file.  Uses $JIRI_ROOT/.jiri_manifest if unspecified.  Uses stdout if set to "-".`)
+	cmdImport.Flags.StringVar(&flagImportManifestFile, "manifest-file", "", `The manifest file to add the import to, relative to $JIRI_ROOT, instead of .jiri_manifest, for use with "jiri -manifest=<file> update".`)
 }
 
 var cmdImport = &cmdline.Command{
@@ -39,7 +43,8 @@ var cmdImport = &cmdline.Command{
 Command "import" adds imports to the $JIRI_ROOT/.jiri_manifest file, which
 specifies manifest information for the jiri tool.  The file is created if it
 doesn't already exist, otherwise additional imports are added to the existing
-file.
+file.  The -manifest-file flag adds the import to another manifest file, such
+as one of several configurations loaded with the global -manifest flag.
 
 An <import> element is added to the manifest representing a remote manifest
 import.  The manifest file path is relative to the root directory of the remote
@@ -62,33 +67,44 @@ func runImport(jirix *jiri.X, args []string) error {
 	if len(args) != 2 {
 		return jirix.UsageErrorf("wrong number of arguments")
 	}
-	// Initialize manifest.
-	var manifest *project.Manifest
-	if !flagImportOverwrite {
-		m, err := project.ManifestFromFile(jirix, jirix.JiriManifestFile())
-		if err != nil && !runutil.IsNotExist(err) {
-			return err
-		}
-		manifest = m
-	}
-	if manifest == nil {
-		manifest = &project.Manifest{}
+	if flagImportManifestFile != "" {
+		jirix = jirix.Clone(tool.ContextOpts{Manifest: &flagImportManifestFile})
 	}
 	// There's not much error checking when writing the .jiri_manifest file;
 	// errors will be reported when "jiri update" is run.
-	manifest.Imports = append(manifest.Imports, project.Import{
+	return addImport(jirix, project.Import{
 		Manifest:     args[0],
 		Name:         flagImportName,
 		Protocol:     flagImportProtocol,
 		Remote:       args[1],
 		RemoteBranch: flagImportRemoteBranch,
+		Revision:     flagImportRevision,
 		Root:         flagImportRoot,
-	})
-	// Write output to stdout or file.
-	outFile := flagImportOut
+	}, flagImportOverwrite, flagImportOut)
+}
+
+// addImport adds the given import to the manifest in outFile, or to a new
+// manifest if overwrite is set or the file does not exist.  The manifest is
+// written to stdout if outFile is "-", and to the .jiri_manifest file, or the
+// manifest file given by the -manifest flag, if it is empty.
+func addImport(jirix *jiri.X, imp project.Import, overwrite bool, outFile string) error {
 	if outFile == "" {
 		outFile = jirix.JiriManifestFile()
 	}
+	// Initialize manifest.
+	var manifest *project.Manifest
+	if !overwrite {
+		m, err := project.ManifestFromFile(jirix, jirix.JiriManifestFile())
+		if err != nil && !runutil.IsNotExist(err) {
+			return err
+		}
+		manifest = m
+	}
+	if manifest == nil {
+		manifest = &project.Manifest{}
+	}
+	manifest.Imports = append(manifest.Imports, imp)
+	// Write output to stdout or file.
 	if outFile == "-" {
 		bytes, err := manifest.ToBytes()
 		if err != nil {
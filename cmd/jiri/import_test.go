@@ -44,6 +44,15 @@ func TestImport(t *testing.T) {
     <import manifest="foo" name="name" remote="https://github.com/new.git" remotebranch="remotebranch" root="root"/>
   </imports>
 </manifest>
+`,
+		},
+		{
+			Args: []string{"-revision=abc123", "foo", "https://github.com/new.git"},
+			Want: `<manifest>
+  <imports>
+    <import manifest="foo" name="manifest" remote="https://github.com/new.git" revision="abc123"/>
+  </imports>
+</manifest>
 `,
 		},
 		{
@@ -0,0 +1,123 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+)
+
+// ownersFileName is the name of the files that list the reviewers of the
+// files in their directory and its subdirectories.
+const ownersFileName = "OWNERS"
+
+// ownersReader reads the OWNERS files of a repository.  Each line of an
+// OWNERS file is an email address or LDAP, or an "include <path>" directive
+// that adds the owners listed in another OWNERS file.  The path is relative
+// to the directory of the including file, or to the root of the repository
+// if it starts with "/".  Blank lines and lines starting with "#" are
+// ignored.
+type ownersReader struct {
+	jirix *jiri.X
+	// root is the root directory of the repository.
+	root string
+	// owners caches the owners of the OWNERS files read so far, including
+	// the owners of the files they include, by path relative to root.
+	owners map[string][]string
+}
+
+func newOwnersReader(jirix *jiri.X, root string) *ownersReader {
+	return &ownersReader{jirix: jirix, root: root, owners: map[string][]string{}}
+}
+
+// findOwners returns the union of the owners of the given files, given by
+// slash-separated paths relative to the root of the repository.  The owners
+// of a file are listed in the nearest OWNERS file in its directory or a
+// parent directory.  Files without an OWNERS file have no owners.
+func (r *ownersReader) findOwners(files []string) ([]string, error) {
+	owners := map[string]bool{}
+	for _, file := range files {
+		for dir := path.Dir(file); ; dir = path.Dir(dir) {
+			fileOwners, found, err := r.read(path.Join(dir, ownersFileName), nil)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				for _, owner := range fileOwners {
+					owners[owner] = true
+				}
+				break
+			}
+			if dir == "." {
+				break
+			}
+		}
+	}
+	result := []string{}
+	for owner := range owners {
+		result = append(result, owner)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// read returns the owners listed in the given OWNERS file, and whether the
+// file exists.  The stack lists the files whose includes led to the file,
+// which are used to detect include cycles.
+func (r *ownersReader) read(file string, stack []string) ([]string, bool, error) {
+	for i, included := range stack {
+		if included == file {
+			return nil, false, fmt.Errorf("include cycle in OWNERS files: %s", strings.Join(append(stack[i:], file), " -> "))
+		}
+	}
+	if owners, ok := r.owners[file]; ok {
+		return owners, true, nil
+	}
+	data, err := r.jirix.NewSeq().ReadFile(filepath.Join(r.root, filepath.FromSlash(file)))
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	stack = append(stack, file)
+	owners := []string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "include "):
+			include := strings.TrimSpace(strings.TrimPrefix(line, "include "))
+			if strings.HasPrefix(include, "/") {
+				include = path.Clean(strings.TrimPrefix(include, "/"))
+			} else {
+				include = path.Join(path.Dir(file), include)
+			}
+			if include == ".." || strings.HasPrefix(include, "../") {
+				return nil, false, fmt.Errorf("%s:%d: included file %q is outside of the repository", file, i+1, include)
+			}
+			included, found, err := r.read(include, stack)
+			if err != nil {
+				return nil, false, err
+			}
+			if !found {
+				return nil, false, fmt.Errorf("%s:%d: included file %q does not exist", file, i+1, include)
+			}
+			owners = append(owners, included...)
+		case strings.ContainsAny(line, " \t"):
+			return nil, false, fmt.Errorf("%s:%d: invalid line %q", file, i+1, line)
+		default:
+			owners = append(owners, parseEmails(line)...)
+		}
+	}
+	r.owners[file] = owners
+	return owners, true, nil
+}
@@ -6,12 +6,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	"v.io/jiri"
 	"v.io/jiri/project"
@@ -22,18 +24,58 @@ var (
 	branchesFlag        bool
 	cleanupBranchesFlag bool
 	noPristineFlag      bool
+	jsonFlag            bool
 	checkDirtyFlag      bool
 	showNameFlag        bool
 	formatFlag          string
+	withHistoryFlag     bool
+	fromManifestFlag    bool
+	diffJSONFlag        bool
+	lintJSONFlag        bool
+	setRemoteFromFlag   string
+	setRemoteToFlag     string
+	setRemoteNamesFlag  string
+	setRemoteDryRunFlag bool
+	fetchAllFlag        bool
+	fetchPruneFlag      bool
+	fetchJobsFlag       int
+	pollJSONFlag        bool
+	pollGerritFlag      bool
+	recloneForceFlag    bool
+	cachedFlag          bool
+	noBackupFlag        bool
+	listBackupsFlag     bool
+	restoreBackupFlag   string
+	backupMaxAgeFlag    time.Duration
 )
 
 func init() {
+	cmdProjectDiffManifest.Flags.BoolVar(&diffJSONFlag, "json", false, "Output the differences as a JSON array.")
+	cmdProjectLintManifest.Flags.BoolVar(&lintJSONFlag, "json", false, "Output the problems as a JSON array.")
+	cmdProjectFetch.Flags.BoolVar(&fetchAllFlag, "all", false, "Fetch all remotes of the projects, not only origin.")
+	cmdProjectFetch.Flags.BoolVar(&fetchPruneFlag, "prune", false, "Delete the remote-tracking refs that no longer exist on the remotes.")
+	cmdProjectFetch.Flags.IntVar(&fetchJobsFlag, "j", 8, "Number of projects to fetch concurrently.")
+	cmdProjectPoll.Flags.BoolVar(&pollJSONFlag, "json", false, "Output the changelists as a JSON object, keyed by project name.")
+	cmdProjectPoll.Flags.BoolVar(&pollGerritFlag, "gerrit", true, "Look up the gerrit changes of the changelists on the gerrit hosts of their projects.")
+	cmdProjectReclone.Flags.BoolVar(&recloneForceFlag, "force", false, "Reclone projects even if they have uncommitted changes.")
 	cmdProjectClean.Flags.BoolVar(&cleanupBranchesFlag, "branches", false, "Delete all non-master branches.")
-	cmdProjectList.Flags.BoolVar(&branchesFlag, "branches", false, "Show project branches.")
+	cmdProjectClean.Flags.BoolVar(&noBackupFlag, "no-backup", false, "Do not back up the local changes and branches before discarding them.")
+	cmdProjectClean.Flags.BoolVar(&listBackupsFlag, "list-backups", false, "List the backups of the projects instead of cleaning them.")
+	cmdProjectClean.Flags.StringVar(&restoreBackupFlag, "restore-backup", "", "Restore the backups of the projects with the given ID instead of cleaning them.")
+	cmdProjectClean.Flags.DurationVar(&backupMaxAgeFlag, "backup-max-age", 30*24*time.Hour, "Maximum age of the backups of the projects.  Older backups are deleted before the projects are cleaned.  Zero means no limit.")
+	cmdProjectList.Flags.BoolVar(&branchesFlag, "branches", false, "Show project branches, and the statuses of their CLs on the gerrit hosts of the projects.")
 	cmdProjectList.Flags.BoolVar(&noPristineFlag, "nopristine", false, "If true, omit pristine projects, i.e. projects with a clean master branch and no other branches.")
+	cmdProjectList.Flags.BoolVar(&jsonFlag, "json", false, "Output the listing as a JSON array.")
+	cmdProjectSetRemote.Flags.StringVar(&setRemoteFromFlag, "from", "", "URL prefix of the host the projects migrated from, e.g. https://old.host/.  Origin remotes starting with it are changed even if they are not the remote of the last update.")
+	cmdProjectSetRemote.Flags.StringVar(&setRemoteToFlag, "to", "", "URL prefix of the host the projects migrated to, e.g. sso://new.host/, which replaces -from in the URLs of the remotes named by -remote-name.")
+	cmdProjectSetRemote.Flags.StringVar(&setRemoteNamesFlag, "remote-name", "", "Comma-separated list of additional git remotes, e.g. review, whose URLs starting with -from are rewritten to start with -to.")
+	cmdProjectSetRemote.Flags.BoolVar(&setRemoteDryRunFlag, "n", false, "Show the remotes that would be changed, without changing them.")
 	cmdProjectShellPrompt.Flags.BoolVar(&checkDirtyFlag, "check-dirty", true, "If false, don't check for uncommitted changes or untracked files. Setting this option to false is dangerous: dirty master branches will not appear in the output.")
 	cmdProjectShellPrompt.Flags.BoolVar(&showNameFlag, "show-name", false, "Show the name of the current repo.")
+	cmdProjectShellPrompt.Flags.BoolVar(&cachedFlag, "cached", false, `Use the cached project states of "jiri server" if it is running.`)
 	cmdProjectInfo.Flags.StringVar(&formatFlag, "f", "{{.Project.Name}}", "The go template for the fields to display.")
+	cmdProjectInfo.Flags.BoolVar(&withHistoryFlag, "with-history", false, "Populate the LastUpdateRevision field from the update history.")
+	cmdProjectInfo.Flags.BoolVar(&fromManifestFlag, "from-manifest", false, "Also match the projects of the manifest that do not exist locally, whose Present field is false and whose Project holds the remote and revision of the manifest.")
 }
 
 // cmdProject represents the "jiri project" command.
@@ -41,30 +83,47 @@ var cmdProject = &cmdline.Command{
 	Name:     "project",
 	Short:    "Manage the jiri projects",
 	Long:     "Manage the jiri projects.",
-	Children: []*cmdline.Command{cmdProjectClean, cmdProjectInfo, cmdProjectList, cmdProjectShellPrompt},
+	Children: []*cmdline.Command{cmdProjectCheckout, cmdProjectClean, cmdProjectDiffManifest, cmdProjectFetch, cmdProjectGroup, cmdProjectInfo, cmdProjectLintManifest, cmdProjectList, cmdProjectMirror, cmdProjectPoll, cmdProjectReclone, cmdProjectRepair, cmdProjectSetRemote, cmdProjectShellPrompt},
 }
 
 // cmdProjectClean represents the "jiri project clean" command.
 var cmdProjectClean = &cmdline.Command{
-	Runner:   jiri.RunnerFunc(runProjectClean),
-	Name:     "clean",
-	Short:    "Restore jiri projects to their pristine state",
-	Long:     "Restore jiri projects back to their master branches and get rid of all the local branches and changes.",
+	Runner: jiri.RunnerFunc(runProjectClean),
+	Name:   "clean",
+	Short:  "Restore jiri projects to their pristine state",
+	Long: `
+Restore jiri projects back to their master branches and get rid of all the
+local branches and changes.
+
+Before a project is cleaned, its uncommitted changes and untracked files are
+backed up as a git stash entry with the message "jiri-clean-backup <id>", and
+the branches deleted by -branches are backed up as a git bundle in the
+.jiri/backups directory of the project.  The <id> of the backups is the time
+the clean started, e.g. 20161017-150405.  The -list-backups flag lists the
+backups of the projects, and the -restore-backup flag restores the backups
+with the given <id>: the stashed changes are applied to the current branch of
+each project, and the deleted branches are recreated.  Backups older than
+-backup-max-age are deleted when the projects are cleaned.
+`,
 	ArgsName: "<project ...>",
 	ArgsLong: "<project ...> is a list of projects to clean up.",
 }
 
 func runProjectClean(jirix *jiri.X, args []string) (e error) {
+	if listBackupsFlag && restoreBackupFlag != "" {
+		return jirix.UsageErrorf("-list-backups and -restore-backup cannot be used together")
+	}
 	localProjects, err := project.LocalProjects(jirix, project.FullScan)
 	if err != nil {
 		return err
 	}
 	var projects project.Projects
 	if len(args) > 0 {
+		projects = project.Projects{}
 		for _, arg := range args {
 			p, err := localProjects.FindUnique(arg)
 			if err != nil {
-				fmt.Fprintf(jirix.Stderr(), "Error finding local project %q: %v.\n", p.Name, err)
+				fmt.Fprintf(jirix.Stderr(), "Error finding local project %q: %v.\n", arg, err)
 			} else {
 				projects[p.Key()] = p
 			}
@@ -72,7 +131,20 @@ func runProjectClean(jirix *jiri.X, args []string) (e error) {
 	} else {
 		projects = localProjects
 	}
-	if err := project.CleanupProjects(jirix, projects, cleanupBranchesFlag); err != nil {
+	switch {
+	case listBackupsFlag:
+		backups, err := project.ListBackups(jirix, projects)
+		if err != nil {
+			return err
+		}
+		for _, backup := range backups {
+			fmt.Fprintf(jirix.Stdout(), "%s %s: %s\n", backup.ID, backup.Project.Name, backup.Description())
+		}
+		return nil
+	case restoreBackupFlag != "":
+		return project.RestoreBackup(jirix, projects, restoreBackupFlag)
+	}
+	if err := project.CleanupProjects(jirix, projects, cleanupBranchesFlag, project.NoBackupOpt(noBackupFlag), project.BackupMaxAgeOpt(backupMaxAgeFlag)); err != nil {
 		return err
 	}
 	return nil
@@ -83,21 +155,54 @@ var cmdProjectList = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runProjectList),
 	Name:   "list",
 	Short:  "List existing jiri projects and branches",
-	Long:   "Inspect the local filesystem and list the existing projects and branches.",
+	Long: `
+Inspect the local filesystem and list the existing projects and branches.
+Projects are specified using regular expressions that are matched against
+project keys.  If no command line arguments are provided, all projects are
+listed.
+`,
+	ArgsName: "<project-keys>...",
+	ArgsLong: "<project-keys>... a list of project keys, as regexps, to list",
+}
+
+// branchInfo is the JSON representation of a branch listed by "jiri project
+// list -json".
+type branchInfo struct {
+	Name             string `json:"name"`
+	Current          bool   `json:"current"`
+	HasGerritMessage bool   `json:"hasGerritMessage"`
+	ChangeStatus     string `json:"changeStatus,omitempty"`
+}
+
+// projectInfo is the JSON representation of a project listed by "jiri project
+// list -json".
+type projectInfo struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"`
+	Branches []branchInfo `json:"branches"`
+	Dirty    bool         `json:"dirty"`
 }
 
 // runProjectList generates a listing of local projects.
-func runProjectList(jirix *jiri.X, _ []string) error {
-	states, err := project.GetProjectStates(jirix, noPristineFlag)
+func runProjectList(jirix *jiri.X, args []string) error {
+	regexps, err := compileRegexps(args)
 	if err != nil {
 		return err
 	}
-	var keys project.ProjectKeys
-	for key := range states {
-		keys = append(keys, key)
+	states, err := project.GetProjectStates(jirix, noPristineFlag || jsonFlag)
+	if err != nil {
+		return err
+	}
+	keys := matchingKeys(states, regexps)
+	if branchesFlag {
+		var matching []*project.ProjectState
+		for _, key := range keys {
+			matching = append(matching, states[key])
+		}
+		project.SetChangeStatuses(jirix, matching)
 	}
-	sort.Sort(keys)
 
+	infos := []projectInfo{}
 	for _, key := range keys {
 		state := states[key]
 		if noPristineFlag {
@@ -106,6 +211,24 @@ func runProjectList(jirix *jiri.X, _ []string) error {
 				continue
 			}
 		}
+		if jsonFlag {
+			info := projectInfo{
+				Name:     state.Project.Name,
+				Path:     state.Project.Path,
+				Branches: []branchInfo{},
+				Dirty:    state.HasUncommitted || state.HasUntracked,
+			}
+			for _, branch := range state.Branches {
+				info.Branches = append(info.Branches, branchInfo{
+					Name:             branch.Name,
+					Current:          branch.Name == state.CurrentBranch,
+					HasGerritMessage: branch.HasGerritMessage,
+					ChangeStatus:     branch.ChangeStatus,
+				})
+			}
+			infos = append(infos, info)
+			continue
+		}
 		fmt.Fprintf(jirix.Stdout(), "name=%q remote=%q path=%q\n", state.Project.Name, state.Project.Remote, state.Project.Path)
 		if branchesFlag {
 			for _, branch := range state.Branches {
@@ -114,13 +237,76 @@ func runProjectList(jirix *jiri.X, _ []string) error {
 					s += "* "
 				}
 				s += branch.Name
-				if branch.HasGerritMessage {
+				switch {
+				case branch.ChangeStatus != "":
+					s += fmt.Sprintf(" (exported to gerrit, %s)", strings.ToLower(branch.ChangeStatus))
+				case branch.HasGerritMessage:
 					s += " (exported to gerrit)"
 				}
 				fmt.Fprintf(jirix.Stdout(), "%v\n", s)
 			}
 		}
 	}
+	if jsonFlag {
+		data, err := json.MarshalIndent(infos, "", "  ")
+		if err != nil {
+			return fmt.Errorf("json.MarshalIndent() failed: %v", err)
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s\n", data)
+	}
+	return nil
+}
+
+// compileRegexps compiles the given project key regexps.
+func compileRegexps(args []string) ([]*regexp.Regexp, error) {
+	regexps := []*regexp.Regexp{}
+	for _, a := range args {
+		re, err := regexp.Compile(a)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regexp %v: %v", a, err)
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+// matchingKeys returns the sorted keys of the given states that match any of
+// the given regexps, or all keys if no regexps are given.
+func matchingKeys(states map[project.ProjectKey]*project.ProjectState, regexps []*regexp.Regexp) project.ProjectKeys {
+	var keys project.ProjectKeys
+	for key := range states {
+		if len(regexps) == 0 {
+			keys = append(keys, key)
+			continue
+		}
+		for _, re := range regexps {
+			if re.MatchString(string(key)) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	sort.Sort(keys)
+	return keys
+}
+
+// checkSelections returns an error for the first of the given project key
+// regexps, compiled from args, that matches none of the given states,
+// suggesting the names of the projects closest to it.
+func checkSelections(states map[project.ProjectKey]*project.ProjectState, args []string, regexps []*regexp.Regexp) error {
+	for i, re := range regexps {
+		if len(matchingKeys(states, regexps[i:i+1])) > 0 {
+			continue
+		}
+		var names []string
+		for _, state := range states {
+			names = append(names, state.Project.Name)
+		}
+		if s := closestNames(names, args[i]); len(s) > 0 {
+			return fmt.Errorf("no project matches %q, did you mean: %s?", re, strings.Join(s, ", "))
+		}
+		return fmt.Errorf("no project matches %q", re)
+	}
 	return nil
 }
 
@@ -137,28 +323,40 @@ that the contains the current directory is used, or if run from outside
 of a given project, all projects will be used. The information to be
 displayed is specified using a go template, supplied via the -f flag, that is
 executed against the v.io/jiri/project.ProjectState structure. This structure
-currently has the following fields: ` + fmt.Sprintf("%#v", project.ProjectState{}),
+currently has the following fields: ` + fmt.Sprintf("%#v", project.ProjectState{}) + `
+
+With -from-manifest, the projects of the manifest that do not exist locally
+are matched too.  Their Present field is false, and their Project field holds
+the remote and revision they would be cloned from, e.g. by "jiri project
+checkout".  A project key regexp that matches no project is an error.
+
+In addition to the builtin functions of go templates, the template can use the
+following functions:
+  basename <path>     the last element of the path
+  join <list> <sep>   the elements of the list of strings, separated by sep
+  json <value>        the value, encoded as JSON
+  relpath <path>      the path, relative to the jiri root
+  shortrev <revision> the first 8 characters of the revision
+
+For example, -f='{{relpath .Project.Path}} {{shortrev .Project.Revision}}'
+prints the path and abbreviated revision of each project.`,
 	ArgsName: "<project-keys>...",
 	ArgsLong: "<project-keys>... a list of project keys, as regexps, to apply the specified format to",
 }
 
 // runProjectInfo provides structured info on local projects.
 func runProjectInfo(jirix *jiri.X, args []string) error {
-	tmpl, err := template.New("info").Parse(formatFlag)
+	getStates := project.GetProjectStates
+	if fromManifestFlag {
+		getStates = project.GetManifestProjectStates
+	}
+	tmpl, err := newInfoTemplate(jirix, formatFlag)
 	if err != nil {
-		return fmt.Errorf("failed to parse template %q: %v", formatFlag, err)
+		return err
 	}
-	regexps := []*regexp.Regexp{}
-
-	if len(args) > 0 {
-		regexps = make([]*regexp.Regexp, len(args), len(args))
-		for i, a := range args {
-			re, err := regexp.Compile(a)
-			if err != nil {
-				return fmt.Errorf("failed to compile regexp %v: %v", a, err)
-			}
-			regexps[i] = re
-		}
+	regexps, err := compileRegexps(args)
+	if err != nil {
+		return err
 	}
 
 	dirty := false
@@ -180,7 +378,7 @@ func runProjectInfo(jirix *jiri.X, args []string) error {
 		if err != nil {
 			// jiri was run from outside of a project so let's
 			// use all available projects.
-			states, err = project.GetProjectStates(jirix, dirty)
+			states, err = getStates(jirix, dirty)
 			if err != nil {
 				return err
 			}
@@ -195,21 +393,22 @@ func runProjectInfo(jirix *jiri.X, args []string) error {
 		}
 	} else {
 		var err error
-		states, err = project.GetProjectStates(jirix, dirty)
+		states, err = getStates(jirix, dirty)
 		if err != nil {
 			return err
 		}
-		for key := range states {
-			for _, re := range regexps {
-				if re.MatchString(string(key)) {
-					keys = append(keys, key)
-					break
-				}
-			}
+		if err := checkSelections(states, args, regexps); err != nil {
+			return err
 		}
+		keys = matchingKeys(states, regexps)
 	}
 	sort.Sort(keys)
 
+	if withHistoryFlag {
+		if err := setLastUpdateRevisions(jirix, states); err != nil {
+			return err
+		}
+	}
 	for _, key := range keys {
 		state := states[key]
 		out := &bytes.Buffer{}
@@ -221,6 +420,509 @@ func runProjectInfo(jirix *jiri.X, args []string) error {
 	return nil
 }
 
+// newInfoTemplate parses the given "jiri project info" template, which can use
+// the functions documented in the help of the command.
+func newInfoTemplate(jirix *jiri.X, format string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"basename": filepath.Base,
+		"join":     strings.Join,
+		"json": func(value interface{}) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+		"relpath": func(path string) (string, error) {
+			return filepath.Rel(jirix.Root, path)
+		},
+		"shortrev": project.FmtRevision,
+	}
+	tmpl, err := template.New("info").Funcs(funcs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %q: %v", format, err)
+	}
+	return tmpl, nil
+}
+
+// setLastUpdateRevisions sets the LastUpdateRevision of the given project
+// states from the snapshot written by the latest successful update.  Projects
+// that are not in the snapshot, e.g. because there is no update history, keep
+// an empty LastUpdateRevision.
+func setLastUpdateRevisions(jirix *jiri.X, states map[project.ProjectKey]*project.ProjectState) error {
+	snapshot, _, err := project.LatestUpdateSnapshot(jirix)
+	if err != nil || snapshot == nil {
+		return err
+	}
+	for _, p := range snapshot.Projects {
+		if state, ok := states[p.Key()]; ok {
+			state.LastUpdateRevision = p.Revision
+		}
+	}
+	return nil
+}
+
+// cmdProjectCheckout represents the "jiri project checkout" command.
+var cmdProjectCheckout = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectCheckout),
+	Name:   "checkout",
+	Short:  "Clone projects of the manifest that do not exist locally",
+	Long: `
+Clone the projects of the manifest that match the given project key regexps
+and do not exist locally, checked out at their revisions in the manifest, as
+"jiri update" creates projects, without updating any other project.  The
+metadata of the projects is written, and their githooks are installed;
+runhooks are not run.  Matching projects that already exist are reported and
+left unchanged, and a regexp that matches no project is an error.
+
+Use "jiri project info -from-manifest" to list the projects of the manifest
+along with whether they exist locally.
+`,
+	ArgsName: "<project-keys>...",
+	ArgsLong: "<project-keys>... a list of project keys, as regexps, of the projects to clone",
+}
+
+func runProjectCheckout(jirix *jiri.X, args []string) error {
+	if len(args) == 0 {
+		return jirix.UsageErrorf("no projects given")
+	}
+	regexps, err := compileRegexps(args)
+	if err != nil {
+		return err
+	}
+	states, err := project.GetManifestProjectStates(jirix, false)
+	if err != nil {
+		return err
+	}
+	if err := checkSelections(states, args, regexps); err != nil {
+		return err
+	}
+	projects := project.Projects{}
+	for _, key := range matchingKeys(states, regexps) {
+		state := states[key]
+		if state.Present {
+			fmt.Fprintf(jirix.Stdout(), "Project %q already exists in %q\n", state.Project.Name, state.Project.Path)
+			continue
+		}
+		projects[key] = state.Project
+	}
+	return project.CheckoutProjects(jirix, projects)
+}
+
+// cmdProjectDiffManifest represents the "jiri project diff-manifest" command.
+var cmdProjectDiffManifest = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectDiffManifest),
+	Name:   "diff-manifest",
+	Short:  "Report how the local projects differ from the manifest",
+	Long: `
+Report how the local projects differ from the manifest, without changing
+anything: the projects of the manifest that are missing locally, the local
+projects that are not in the manifest, and the projects whose local path or
+master revision differs from the manifest.  Remotes are not fetched, so a
+project that tracks a remote branch is compared to the local copy of the
+branch, as of the last fetch.  Remote imports whose manifest projects have not
+been fetched yet are reported as unresolvable.
+
+The command exits with code 0 if the local projects match the manifest, and
+1 otherwise, so it can be used as a consistency check.
+`,
+}
+
+func runProjectDiffManifest(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	diffs, err := project.DiffManifest(jirix)
+	if err != nil {
+		return err
+	}
+	if diffJSONFlag {
+		data, err := json.MarshalIndent(diffs, "", "  ")
+		if err != nil {
+			return fmt.Errorf("json.MarshalIndent() failed: %v", err)
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s\n", data)
+	} else {
+		for _, diff := range diffs {
+			fmt.Fprintln(jirix.Stdout(), diff)
+		}
+	}
+	if len(diffs) > 0 {
+		return cmdline.ErrExitCode(1)
+	}
+	return nil
+}
+
+// selectLocalProjects returns the local projects with the given names or keys,
+// or all local projects if none are given.
+func selectLocalProjects(jirix *jiri.X, args []string) (project.Projects, error) {
+	localProjects, err := project.LocalProjects(jirix, project.FullScan)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return localProjects, nil
+	}
+	projects := project.Projects{}
+	for _, arg := range args {
+		p, err := localProjects.FindUnique(arg)
+		if err != nil {
+			return nil, jirix.UsageErrorf("%v", err)
+		}
+		projects[p.Key()] = p
+	}
+	return projects, nil
+}
+
+// cmdProjectLintManifest represents the "jiri project lint-manifest" command.
+var cmdProjectLintManifest = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectLintManifest),
+	Name:   "lint-manifest",
+	Short:  "Check manifests for problems",
+	Long: `
+Check the given manifest files, and the manifests they import, for problems
+that would otherwise only surface when "jiri update" runs into them:
+
+  - files that cannot be parsed, and elements or attributes that this version
+    of jiri does not know
+  - duplicate projects and tools, with the files they were found in
+  - project paths outside the jiri root, or colliding with .jiri_root,
+    .jiri_manifest or .manifest
+  - project paths that differ only in case, which collide on case-insensitive
+    filesystems such as the defaults of macOS and Windows
+  - relative githooks and runhook paths that do not exist
+  - remotes that are neither URLs nor absolute paths
+  - imports that are cyclic, or unreachable because the imported file or
+    the manifest project of a remote import does not exist locally
+
+Nothing is fetched or cloned, and no project is changed: the projects of all
+groups are checked, and the manifests of remote imports are read from the
+working trees of their local projects, so that uncommitted changes to the
+manifests are checked too.
+
+The command exits with code 0 if no problem is found, and 1 otherwise, so it
+can be used as a presubmit check.
+`,
+	ArgsName: "[<manifest>...]",
+	ArgsLong: "<manifest>... is a list of manifest files to check.  Defaults to $JIRI_ROOT/.jiri_manifest.",
+}
+
+func runProjectLintManifest(jirix *jiri.X, args []string) error {
+	var files []string
+	for _, arg := range args {
+		file, err := filepath.Abs(arg)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+	problems, err := project.LintManifest(jirix, files...)
+	if err != nil {
+		return err
+	}
+	if lintJSONFlag {
+		if problems == nil {
+			problems = []project.ManifestProblem{}
+		}
+		data, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			return fmt.Errorf("json.MarshalIndent() failed: %v", err)
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s\n", data)
+	} else {
+		for _, problem := range problems {
+			fmt.Fprintln(jirix.Stdout(), problem)
+		}
+	}
+	if len(problems) > 0 {
+		return cmdline.ErrExitCode(1)
+	}
+	return nil
+}
+
+// cmdProjectFetch represents the "jiri project fetch" command.
+var cmdProjectFetch = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectFetch),
+	Name:   "fetch",
+	Short:  "Fetch the remotes of jiri projects",
+	Long: `
+Fetch the origin remote of the local projects, or of the given projects, so
+that the remote branches are up to date, e.g. before working offline.  Unlike
+"jiri update", only the remote-tracking refs are updated: no working tree or
+local branch is changed, and no tool is built, so it is safe to fetch while
+editing files.
+
+The projects are fetched concurrently.  For each project, the number of new
+commits fetched on the remote branch it tracks is reported, or the error that
+prevented fetching it; a failure does not stop the other projects.  The
+command exits with code 1 if any project failed to fetch.
+`,
+	ArgsName: "<project ...>",
+	ArgsLong: "<project ...> is a list of projects to fetch.  Defaults to all local projects.",
+}
+
+func runProjectFetch(jirix *jiri.X, args []string) error {
+	projects, err := selectLocalProjects(jirix, args)
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, result := range project.FetchProjects(jirix, projects, fetchAllFlag, fetchPruneFlag, fetchJobsFlag) {
+		fmt.Fprintln(jirix.Stdout(), result)
+		if result.Err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return cmdline.ErrExitCode(1)
+	}
+	return nil
+}
+
+// cmdProjectPoll represents the "jiri project poll" command.
+var cmdProjectPoll = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectPoll),
+	Name:   "poll",
+	Short:  "Report the changelists that exist remotely but not locally",
+	Long: `
+Fetch the local projects, or the given projects, and report the changelists
+on the remote branches they track that the local master branches do not have
+yet, with their authors and descriptions.
+
+For projects with a gerrit host, the gerrit change of each changelist is
+looked up by the Change-Id in its description, and its number, owner, topic
+and status, e.g. MERGED, are reported too.  The changes are queried in
+batches, one host at a time; the changes of hosts that cannot be queried are
+left out, with a warning.  The -gerrit=false flag skips the lookups.
+`,
+	ArgsName: "<project ...>",
+	ArgsLong: "<project ...> is a list of projects to poll.  Defaults to all local projects.",
+}
+
+func runProjectPoll(jirix *jiri.X, args []string) error {
+	projectSet := map[string]struct{}{}
+	for _, arg := range args {
+		projectSet[arg] = struct{}{}
+	}
+	update, err := project.PollProjects(jirix, projectSet, project.GerritChangesOpt(pollGerritFlag))
+	if err != nil {
+		return err
+	}
+	if pollJSONFlag {
+		data, err := json.MarshalIndent(update, "", "  ")
+		if err != nil {
+			return fmt.Errorf("json.MarshalIndent() failed: %v", err)
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s\n", data)
+		return nil
+	}
+	var names []string
+	for name := range update {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(update[name]) == 0 {
+			continue
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s:\n", name)
+		for _, cl := range update[name] {
+			fmt.Fprintf(jirix.Stdout(), "  %s (%s <%s>)\n", strings.SplitN(cl.Description, "\n", 2)[0], cl.Author, cl.Email)
+			if cl.Change == 0 {
+				continue
+			}
+			fmt.Fprintf(jirix.Stdout(), "    change %d, %s, owner %s", cl.Change, cl.Status, cl.Owner)
+			if cl.Topic != "" {
+				fmt.Fprintf(jirix.Stdout(), ", topic %s", cl.Topic)
+			}
+			fmt.Fprintln(jirix.Stdout())
+		}
+	}
+	return nil
+}
+
+// cmdProjectMirror represents the "jiri project mirror" command.
+var cmdProjectMirror = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectMirror),
+	Name:   "mirror",
+	Short:  "Create or refresh mirror repositories of the jiri projects",
+	Long: `
+Create a bare mirror repository, named <dir>/<project-name>.git, of each
+project in the manifest, or fetch the latest changes into the mirrors that
+already exist.  The mirrors can then be used by "jiri update -reference-dir"
+and "jiri snapshot checkout -reference-dir" to clone new projects, borrowing
+objects from the mirrors rather than fetching them from the remotes.
+
+A directory of mirrors is typically shared by the jiri roots on a machine and
+refreshed periodically.
+`,
+	ArgsName: "<dir>",
+	ArgsLong: "<dir> is the directory of mirror repositories.",
+}
+
+func runProjectMirror(jirix *jiri.X, args []string) error {
+	if len(args) != 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	return project.MirrorProjects(jirix, dir)
+}
+
+// cmdProjectSetRemote represents the "jiri project set-remote" command.
+var cmdProjectSetRemote = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectSetRemote),
+	Name:   "set-remote",
+	Short:  "Set the remotes of jiri projects to those of the manifest",
+	Long: `
+Set the origin remote of the local projects, or of the given projects, to the
+remote of the project in the manifest, e.g. after the projects migrated to a
+new host, without waiting for "jiri update" to do so.  Nothing is fetched, and
+the manifest is loaded as it is.
+
+To avoid clobbering custom remotes, the origin of a project is only changed if
+its URL is the remote the project had in the manifest when it was last
+updated, or starts with the -from prefix.  Other git remotes of the projects,
+such as a remote for code reviews, are not set by the manifest; the remotes
+named by -remote-name are rewritten by replacing the -from prefix of their
+URLs with the -to prefix, and left unchanged if their URLs do not start with
+-from, e.g.:
+
+  jiri project set-remote -from https://old.host/ -to sso://new.host/ -remote-name review
+
+The old and new URLs of each changed remote are printed; -n only prints them.
+The command exits with code 1 if a remote was left unchanged because its URL
+did not match, or could not be changed.
+`,
+	ArgsName: "<project ...>",
+	ArgsLong: "<project ...> is a list of projects whose remotes to set.  Defaults to all local projects.",
+}
+
+func runProjectSetRemote(jirix *jiri.X, args []string) error {
+	if (setRemoteFromFlag == "") != (setRemoteToFlag == "") {
+		return jirix.UsageErrorf("-from and -to must be set together")
+	}
+	remoteNames := splitList(setRemoteNamesFlag)
+	if len(remoteNames) > 0 && setRemoteFromFlag == "" {
+		return jirix.UsageErrorf("-remote-name requires -from and -to")
+	}
+	projects, err := selectLocalProjects(jirix, args)
+	if err != nil {
+		return err
+	}
+	changes, err := project.SetRemotes(jirix, projects, setRemoteFromFlag, setRemoteToFlag, remoteNames, setRemoteDryRunFlag)
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, change := range changes {
+		fmt.Fprintln(jirix.Stdout(), change)
+		if change.Err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return cmdline.ErrExitCode(1)
+	}
+	return nil
+}
+
+// cmdProjectRepair represents the "jiri project repair" command.
+var cmdProjectRepair = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectRepair),
+	Name:   "repair",
+	Short:  "Reconstruct the metadata of jiri projects",
+	Long: `
+Reconstruct the metadata of a local project, stored in the .jiri/metadata.v2
+file of the project, e.g. after the file was deleted or truncated.  Projects
+with missing or invalid metadata are skipped with a warning when scanning for
+local projects, and "jiri update" cannot create them again on top of the
+existing checkouts.
+
+The project is identified by matching the origin remote of the git repository
+against the remotes of the projects in the manifest.  Without arguments, the
+metadata of every project in the manifest whose directory exists but whose
+metadata is missing or invalid is reconstructed.
+`,
+	ArgsName: "[<path>]",
+	ArgsLong: "<path> is the directory of the project to repair.",
+}
+
+func runProjectRepair(jirix *jiri.X, args []string) error {
+	var repaired []project.Project
+	var err error
+	switch len(args) {
+	case 0:
+		repaired, err = project.RepairAllMetadata(jirix)
+	case 1:
+		var p project.Project
+		if p, err = project.RepairMetadata(jirix, args[0]); err == nil {
+			repaired = append(repaired, p)
+		}
+	default:
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	for _, p := range repaired {
+		fmt.Fprintf(jirix.Stdout(), "Repaired the metadata of project %q in %q\n", p.Name, p.Path)
+	}
+	return err
+}
+
+// cmdProjectReclone represents the "jiri project reclone" command.
+var cmdProjectReclone = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runProjectReclone),
+	Name:   "reclone",
+	Short:  "Replace broken checkouts of jiri projects by new clones",
+	Long: `
+Replace the checkouts of the given projects, e.g. ones whose .git directory
+was corrupted by disk problems or an interrupted clone, by new clones from the
+remotes of the projects in the manifest, checked out at their revisions in the
+manifest, as "jiri update" creates projects.  The metadata of the projects is
+written again, and their githooks are installed; runhooks are not run.
+
+The old checkouts are moved to <path>.corrupt-<time>, rather than deleted,
+and the local branches other than master that can still be read are fetched
+into the new clones.  The command reports where each old checkout was moved
+to, and which branches were saved.  Projects with uncommitted changes are
+not recloned unless -force is set, although checkouts that are too broken to
+tell are.
+`,
+	ArgsName: "<project ...>",
+	ArgsLong: "<project ...> is a list of projects to reclone.",
+}
+
+func runProjectReclone(jirix *jiri.X, args []string) error {
+	if len(args) == 0 {
+		return jirix.UsageErrorf("no projects given")
+	}
+	results, err := project.RecloneProjects(jirix, args, recloneForceFlag)
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, result := range results {
+		p := result.Project
+		if result.Err != nil {
+			fmt.Fprintf(jirix.Stderr(), "ERROR: recloning project %q failed: %v\n", p.Name, result.Err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(jirix.Stdout(), "Recloned project %q in %q; the old checkout is in %q\n", p.Name, p.Path, result.ParkedPath)
+		if len(result.SavedBranches) > 0 {
+			fmt.Fprintf(jirix.Stdout(), "  saved branches: %s\n", strings.Join(result.SavedBranches, ", "))
+		}
+		if len(result.LostBranches) > 0 {
+			fmt.Fprintf(jirix.Stdout(), "  branches that could not be saved: %s\n", strings.Join(result.LostBranches, ", "))
+		}
+		if result.BranchesErr != nil {
+			fmt.Fprintf(jirix.Stderr(), "WARNING: cannot list the branches of the old checkout of project %q: %v\n", p.Name, result.BranchesErr)
+		}
+	}
+	if failed {
+		return cmdline.ErrExitCode(1)
+	}
+	return nil
+}
+
 // cmdProjectShellPrompt represents the "jiri project shell-prompt" command.
 var cmdProjectShellPrompt = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runProjectShellPrompt),
@@ -231,30 +933,49 @@ Reports current branches of jiri projects (repositories) as well as an
 indication of each project's status:
   *  indicates that a repository contains uncommitted changes
   %  indicates that a repository contains untracked files
+  |REBASE, |MERGE or |CHERRY-PICK indicates that the operation is in progress
+  in a repository
+
+With -cached, the states are requested from "jiri server", which caches them,
+if it is running; otherwise they are computed as usual.  This is much faster
+for prompts and editor status lines that are refreshed often, but the states
+may be out of date by up to the -ttl of the server.
 `,
 }
 
 func runProjectShellPrompt(jirix *jiri.X, args []string) error {
+	if cachedFlag {
+		if prompt, err := cachedShellPrompt(jirix); err == nil {
+			fmt.Fprintln(jirix.Stdout(), prompt)
+			return nil
+		}
+	}
 	states, err := project.GetProjectStates(jirix, checkDirtyFlag)
 	if err != nil {
 		return err
 	}
-	var keys project.ProjectKeys
-	for key := range states {
-		keys = append(keys, key)
-	}
-	sort.Sort(keys)
-
 	// Get the key of the current project.
 	currentProjectKey, err := project.CurrentProjectKey(jirix)
 	if err != nil {
 		return err
 	}
+	fmt.Fprintln(jirix.Stdout(), shellPrompt(states, currentProjectKey, checkDirtyFlag, showNameFlag))
+	return nil
+}
+
+// shellPrompt returns the status of the given project states printed by
+// "jiri project shell-prompt".
+func shellPrompt(states map[project.ProjectKey]*project.ProjectState, currentProjectKey project.ProjectKey, checkDirty, showName bool) string {
+	var keys project.ProjectKeys
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
 	var statuses []string
 	for _, key := range keys {
 		state := states[key]
 		status := ""
-		if checkDirtyFlag {
+		if checkDirty {
 			if state.HasUncommitted {
 				status += "*"
 			}
@@ -262,17 +983,20 @@ func runProjectShellPrompt(jirix *jiri.X, args []string) error {
 				status += "%"
 			}
 		}
+		if state.InProgressOperation != "" {
+			status += "|" + strings.ToUpper(state.InProgressOperation)
+		}
 		short := state.CurrentBranch + status
 		long := filepath.Base(states[key].Project.Name) + ":" + short
 		if key == currentProjectKey {
-			if showNameFlag {
+			if showName {
 				statuses = append([]string{long}, statuses...)
 			} else {
 				statuses = append([]string{short}, statuses...)
 			}
 		} else {
-			pristine := state.CurrentBranch == "master"
-			if checkDirtyFlag {
+			pristine := state.CurrentBranch == "master" && state.InProgressOperation == ""
+			if checkDirty {
 				pristine = pristine && !state.HasUncommitted && !state.HasUntracked
 			}
 			if !pristine {
@@ -280,6 +1004,5 @@ func runProjectShellPrompt(jirix *jiri.X, args []string) error {
 			}
 		}
 	}
-	fmt.Println(strings.Join(statuses, ","))
-	return nil
+	return strings.Join(statuses, ",")
 }
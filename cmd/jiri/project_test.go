@@ -0,0 +1,796 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"v.io/jiri"
+	"v.io/jiri/gerrit"
+	"v.io/jiri/gitutil"
+	"v.io/jiri/jiritest"
+	"v.io/jiri/project"
+	"v.io/jiri/tool"
+	"v.io/x/lib/cmdline"
+)
+
+func TestProjectList(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	for _, name := range []string{"alpha", "beta"} {
+		if err := fake.CreateRemoteProject(name); err != nil {
+			t.Fatal(err)
+		}
+		if err := fake.AddProject(project.Project{
+			Name:   name,
+			Path:   name,
+			Remote: fake.Projects[name],
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		branchesFlag, noPristineFlag, jsonFlag = false, false, false
+	}()
+
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	line := func(name string) string {
+		return fmt.Sprintf("name=%q remote=%q path=%q\n", name, fake.Projects[name], filepath.Join(fake.X.Root, name))
+	}
+
+	// Check that all projects are listed in sorted order, and that the
+	// arguments filter the listing.
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, line("alpha") + line("beta") + line("manifest")},
+		{[]string{"^beta"}, line("beta")},
+		{[]string{"alpha", "beta"}, line("alpha") + line("beta")},
+		{[]string{"nomatch"}, ""},
+	}
+	for _, test := range tests {
+		stdout.Reset()
+		if err := runProjectList(fake.X, test.args); err != nil {
+			t.Fatal(err)
+		}
+		if got := stdout.String(); got != test.want {
+			t.Errorf("%v: got\n%v\nwant\n%v", test.args, got, test.want)
+		}
+	}
+	if err := runProjectList(fake.X, []string{"("}); err == nil {
+		t.Errorf("expected an invalid regexp to fail")
+	}
+
+	// Check the JSON output.
+	jsonFlag = true
+	stdout.Reset()
+	if err := runProjectList(fake.X, []string{"^alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []projectInfo
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal(%v) failed: %v", stdout.String(), err)
+	}
+	want := []projectInfo{{
+		Name:     "alpha",
+		Path:     filepath.Join(fake.X.Root, "alpha"),
+		Branches: []branchInfo{{Name: "master", Current: true}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	// Check that -nopristine composes with the filters.
+	noPristineFlag = true
+	stdout.Reset()
+	if err := runProjectList(fake.X, []string{"^alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "[]\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProjectListChangeStatus(t *testing.T) {
+	const mergedID = "I0000000000000000000000000000000000000001"
+	host := fakeGerritHost(map[string]string{mergedID: gerrit.ChangeStatusMerged})
+	defer host.Close()
+	fake, cleanup := jiritest.NewFakeUniverse(t, project.Project{
+		Name:       "alpha",
+		Path:       "alpha",
+		GerritHost: host.URL,
+	})
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { branchesFlag, jsonFlag = false, false }()
+	alpha := filepath.Join(fake.X.Root, "alpha")
+	git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(alpha))
+	for _, branch := range []string{"merged", "unsent"} {
+		if err := git.CreateBranch(branch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeChangeID(t, fake.X, alpha, "merged", mergedID)
+
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+	branchesFlag = true
+	if err := runProjectList(fake.X, []string{"^alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"  merged (exported to gerrit, merged)\n", "  unsent\n"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("got %q, want it to contain %q", stdout.String(), want)
+		}
+	}
+	jsonFlag = true
+	stdout.Reset()
+	if err := runProjectList(fake.X, []string{"^alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `"changeStatus": "MERGED"`; !strings.Contains(stdout.String(), want) {
+		t.Errorf("got %q, want it to contain %q", stdout.String(), want)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("got warnings %q, want none", stderr.String())
+	}
+
+	// If gerrit cannot be reached, the statuses are not listed.
+	host.Close()
+	jsonFlag = false
+	stdout.Reset()
+	if err := runProjectList(fake.X, []string{"^alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "  merged (exported to gerrit)\n"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("got %q, want it to contain %q", stdout.String(), want)
+	}
+	if want := "WARNING: failed to get the status of CLs"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("got %q, want it to contain %q", stderr.String(), want)
+	}
+}
+
+// TestProjectInfoTemplate checks the functions available to the templates of
+// "jiri project info".
+func TestProjectInfoTemplate(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	state := &project.ProjectState{
+		Branches:      []project.BranchState{{Name: "master"}},
+		CurrentBranch: "master",
+		Project: project.Project{
+			Name:     "alpha",
+			Path:     filepath.Join(jirix.Root, "src", "alpha"),
+			Revision: "0123456789abcdef0123456789abcdef01234567",
+		},
+	}
+	tests := []struct {
+		format string
+		data   interface{}
+		want   string
+	}{
+		{"{{basename .Project.Path}}", state, "alpha"},
+		{`{{join . ","}}`, []string{"alpha", "beta"}, "alpha,beta"},
+		{"{{json .Branches}}", state, `[{"ChangeID":"","ChangeStatus":"","HasGerritMessage":false,"Name":"master"}]`},
+		{"{{relpath .Project.Path}}", state, filepath.Join("src", "alpha")},
+		{"{{shortrev .Project.Revision}}", state, "01234567"},
+	}
+	for _, test := range tests {
+		tmpl, err := newInfoTemplate(jirix, test.format)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, test.data); err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if got := out.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+		}
+	}
+
+	// Check that unknown functions are still reported by name.
+	_, err := newInfoTemplate(jirix, "{{nosuchfunc .Project.Name}}")
+	if want := `function "nosuchfunc" not defined`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+}
+
+// TestProjectCheckout checks that "jiri project info -from-manifest" and the
+// -from-manifest flag of runp select the projects of the manifest that do not
+// exist locally, and that "jiri project checkout" clones them.
+func TestProjectCheckout(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "p1", Path: "p1"},
+		project.Project{Name: "docs", Path: "docs"},
+	)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	docs := filepath.Join(fake.X.Root, "docs")
+	if err := os.RemoveAll(docs); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { formatFlag, fromManifestFlag = "{{.Project.Name}}", false }()
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+	formatFlag = "{{.Project.Name}} {{.Present}} {{.Project.Remote}}"
+	args := []string{"^(p1|docs)"}
+
+	// Without -from-manifest, only the local projects are matched.
+	if err := runProjectInfo(fake.X, args); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "p1 true "+fake.Projects["p1"]+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := runProjectInfo(fake.X, []string{"^docs"}); err == nil || !strings.Contains(err.Error(), `no project matches "^docs"`) {
+		t.Errorf("got error %v, want no project to match", err)
+	}
+	fromManifestFlag = true
+	stdout.Reset()
+	if err := runProjectInfo(fake.X, args); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "docs false "+fake.Projects["docs"]+"\np1 true "+fake.Projects["p1"]+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Selections that match no project suggest the closest ones.
+	for _, run := range []func(*jiri.X, []string) error{runProjectInfo, runProjectCheckout} {
+		if err := run(fake.X, []string{"dcos"}); err == nil || !strings.Contains(err.Error(), `no project matches "dcos", did you mean: docs?`) {
+			t.Errorf("got error %v, want a suggestion", err)
+		}
+	}
+
+	// runp reports and skips the missing projects.
+	var values projectSelectionFlagValues
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerProjectSelectionFlags(flags, &values, "")
+	if err := flags.Parse([]string{"-from-manifest", "-projects=" + args[0]}); err != nil {
+		t.Fatal(err)
+	}
+	states, keys, err := selectProjects(fake.X, flags, &values, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || states[keys[0]].Project.Name != "p1" {
+		t.Errorf("got keys %v, want only the key of p1", keys)
+	}
+	if want := `WARNING: skipping project "docs", which does not exist locally`; !strings.Contains(stderr.String(), want) {
+		t.Errorf("got %q, want it to contain %q", stderr.String(), want)
+	}
+
+	stdout.Reset()
+	if err := runProjectCheckout(fake.X, args); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("Project \"p1\" already exists in %q\n", filepath.Join(fake.X.Root, "p1")); !strings.Contains(stdout.String(), want) {
+		t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+	}
+	if _, err := project.ProjectAtPath(fake.X, docs); err != nil {
+		t.Errorf("docs was not cloned with its metadata: %v", err)
+	}
+	stdout.Reset()
+	if err := runProjectInfo(fake.X, []string{"^docs"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "docs true "+fake.Projects["docs"]+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProjectDiffManifest(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "p1", Path: "p1"},
+		project.Project{Name: "p2", Path: "p2"},
+		project.Project{Name: "p3", Path: "p3"},
+	)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { diffJSONFlag = false }()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+
+	// The local projects match the manifest after an update.
+	if err := runProjectDiffManifest(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "" {
+		t.Errorf("got %q, want no output", got)
+	}
+
+	// Commit to p1 locally, and move p2 and delete p3 in the manifest, which
+	// is fetched without updating the local projects.
+	file := filepath.Join(fake.X.Root, "p1", "file")
+	if err := ioutil.WriteFile(file, []byte("local change"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(filepath.Join(fake.X.Root, "p1"))).CommitFile(file, "local change"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.MoveProject("p2", "p2-moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.DeleteProject("p3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(filepath.Join(fake.X.Root, "manifest"))).Fetch("origin"); err != nil {
+		t.Fatal(err)
+	}
+	// Add a project that does not exist locally, and an import whose manifest
+	// project does not exist locally, to the jiri manifest.
+	if err := fake.CreateRemoteProject("p4"); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := fake.ReadJiriManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Projects = append(manifest.Projects, project.Project{Name: "p4", Path: "p4", Remote: fake.Projects["p4"]})
+	manifest.Imports = append(manifest.Imports, project.Import{Name: "extra", Manifest: "extra", Remote: "https://example.com/extra"})
+	if err := fake.WriteJiriManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	if err := runProjectDiffManifest(fake.X, nil); err != cmdline.ErrExitCode(1) {
+		t.Fatalf("got error %v, want exit code 1", err)
+	}
+	for _, want := range []string{
+		"p4: missing locally, manifest path " + filepath.Join(fake.X.Root, "p4") + "\n",
+		"p3: not in the manifest, local path " + filepath.Join(fake.X.Root, "p3") + "\n",
+		"p2: local path " + filepath.Join(fake.X.Root, "p2") + ", manifest path " + filepath.Join(fake.X.Root, "p2-moved") + "\n",
+		"p1: local revision ",
+		"extra: unresolvable remote import of https://example.com/extra",
+	} {
+		if got := stdout.String(); !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(fake.X.Root, "p4")); !os.IsNotExist(err) {
+		t.Errorf("expected p4 not to be created, got %v", err)
+	}
+
+	diffJSONFlag = true
+	stdout.Reset()
+	if err := runProjectDiffManifest(fake.X, nil); err != cmdline.ErrExitCode(1) {
+		t.Fatalf("got error %v, want exit code 1", err)
+	}
+	var diffs []project.ProjectDiff
+	if err := json.Unmarshal(stdout.Bytes(), &diffs); err != nil {
+		t.Fatalf("Unmarshal(%v) failed: %v", stdout.String(), err)
+	}
+	var got []string
+	for _, diff := range diffs {
+		got = append(got, diff.Kind+" "+diff.Name)
+	}
+	want := []string{"missing p4", "not-in-manifest p3", "path p2", "revision p1", "unresolvable extra"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestProjectFetch checks that "jiri project fetch" fetches the remotes of
+// the projects without changing their local branches, and reports the new
+// commits of each project and the projects that failed to fetch.
+func TestProjectFetch(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "p1", Path: "p1"},
+		project.Project{Name: "p2", Path: "p2"},
+	)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+
+	git1 := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(filepath.Join(fake.X.Root, "p1")))
+	master, err := git1.CurrentRevisionOfBranch("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"one", "two"} {
+		if _, err := fake.AddCommit("p1", "file", content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git2 := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(filepath.Join(fake.X.Root, "p2")))
+	if err := git2.SetRemoteUrl("origin", filepath.Join(fake.X.Root, "does-not-exist")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runProjectFetch(fake.X, nil); err != cmdline.ErrExitCode(1) {
+		t.Errorf("got error %v, want exit code 1", err)
+	}
+	for _, want := range []string{"p1: 2 new commits on origin/master\n", "p2: ERROR: "} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+		}
+	}
+	if got, err := git1.CurrentRevisionOfBranch("master"); err != nil || got != master {
+		t.Errorf("got master revision %q (%v), want %q", got, err, master)
+	}
+	if n, err := git1.CountCommits("origin/master", "master"); err != nil || n != 2 {
+		t.Errorf("got %d (%v) commits on origin/master, want 2", n, err)
+	}
+}
+
+func TestProjectPoll(t *testing.T) {
+	const changeID = "I0000000000000000000000000000000000000001"
+	queries := 0
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		fmt.Fprintln(w, ")]}'")
+		if strings.Contains(r.URL.Query().Get("q"), "change:"+changeID) {
+			fmt.Fprintf(w, `[{"change_id": %q, "_number": 42, "status": "MERGED", "topic": "poll", "owner": {"email": "owner@example.com"}}]`, changeID)
+		} else {
+			fmt.Fprint(w, "[]")
+		}
+	}))
+	defer host.Close()
+	fake, cleanup := jiritest.NewFakeUniverse(t, project.Project{
+		Name:       "alpha",
+		Path:       "alpha",
+		GerritHost: host.URL,
+	})
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { pollJSONFlag, pollGerritFlag = false, true }()
+
+	// Add a commit from a gerrit change and one that has no Change-Id.
+	remote := fake.Projects["alpha"]
+	git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(remote))
+	for file, message := range map[string]string{
+		"reviewed":   "Reviewed change\n\nChange-Id: " + changeID + "\n",
+		"unreviewed": "Unreviewed change\n",
+	} {
+		path := filepath.Join(remote, file)
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := git.CommitFile(path, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+	if err := runProjectPoll(fake.X, []string{"alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"alpha:\n",
+		"  Reviewed change (",
+		"    change 42, MERGED, owner owner@example.com, topic poll\n",
+		"  Unreviewed change (",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("got %d gerrit queries, want 1", queries)
+	}
+
+	pollJSONFlag = true
+	stdout.Reset()
+	if err := runProjectPoll(fake.X, []string{"alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	var update project.Update
+	if err := json.Unmarshal(stdout.Bytes(), &update); err != nil {
+		t.Fatalf("%v: %s", err, stdout.String())
+	}
+	var got []project.CL
+	for _, cl := range update["alpha"] {
+		if cl.ChangeID != "" {
+			cl.Author, cl.Email, cl.Description = "", "", ""
+			got = append(got, cl)
+		}
+	}
+	want := []project.CL{{ChangeID: changeID, Change: 42, Owner: "owner@example.com", Topic: "poll", Status: gerrit.ChangeStatusMerged}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Without -gerrit, or if gerrit cannot be reached, the changes are left
+	// out.
+	pollGerritFlag = false
+	stdout.Reset()
+	if err := runProjectPoll(fake.X, []string{"alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stdout.String(), `"change"`) {
+		t.Errorf("got output %q, want no changes", stdout.String())
+	}
+	host.Close()
+	pollGerritFlag = true
+	stdout.Reset()
+	if err := runProjectPoll(fake.X, []string{"alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stdout.String(), `"change"`) {
+		t.Errorf("got output %q, want no changes", stdout.String())
+	}
+	if want := "WARNING: failed to get CLs from " + host.URL; !strings.Contains(stderr.String(), want) {
+		t.Errorf("got %q, want it to contain %q", stderr.String(), want)
+	}
+}
+
+func TestProjectLintManifest(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "p1", Path: "p1"},
+		project.Project{Name: "p2", Path: "p2"},
+	)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { lintJSONFlag = false }()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+
+	// The manifest of the fake universe has no problems.
+	if err := runProjectLintManifest(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "" {
+		t.Errorf("got %q, want no output", got)
+	}
+
+	// Write a manifest that imports the jiri manifest, and adds problems.
+	if err := os.MkdirAll(filepath.Join(fake.X.Root, "hooks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(fake.X.Root, "lint.xml")
+	manifest := fmt.Sprintf(`<manifest>
+  <imports>
+    <import manifest="extra" name="extra" remote="https://example.com/extra"/>
+    <localimport file=".jiri_manifest"/>
+    <localimport file="missing.xml"/>
+    <localimport file="lint.xml"/>
+  </imports>
+  <projects>
+    <project name="p1" path="p1-copy" remote="%s"/>
+    <project name="outside" path="../outside" remote="https://example.com/outside"/>
+    <project name="meta" path=".jiri_root/meta" remote="example.com/meta"/>
+    <project name="hooks" path="hooks" remote="git@example.com:hooks" githooks="hooks" runhook="hooks/missing.sh" color="blue"/>
+    <project name="P2" path="P2" remote="https://example.com/P2"/>
+  </projects>
+  <tools>
+    <tool name="tool" package="example.com/tool/a"/>
+    <tool name="tool" package="example.com/tool/b"/>
+  </tools>
+</manifest>
+`, fake.Projects["p1"])
+	if err := ioutil.WriteFile(file, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	if err := runProjectLintManifest(fake.X, []string{file}); err != cmdline.ErrExitCode(1) {
+		t.Fatalf("got error %v, want exit code 1", err)
+	}
+	for _, want := range []string{
+		`lint.xml: unknown attribute manifest>projects>project@color` + "\n",
+		`lint.xml: unreachable import of extra from https://example.com/extra: project "extra`,
+		`lint.xml: unreachable import: missing.xml does not exist` + "\n",
+		`lint.xml: import cycle detected in local manifest files`,
+		`lint.xml: duplicate project "p1`,
+		`lint.xml: path ../outside of project "outside" is outside the jiri root` + "\n",
+		`lint.xml: path .jiri_root/meta of project "meta" collides with .jiri_root` + "\n",
+		`lint.xml: remote "example.com/meta" of project "meta" has no scheme` + "\n",
+		`lint.xml: runhook hooks/missing.sh of project "hooks" does not exist` + "\n",
+		`lint.xml: duplicate tool "tool", also in lint.xml` + "\n",
+		`paths P2 of project "P2" and p2 of project "p2" differ only in case, and collide on case-insensitive filesystems` + "\n",
+	} {
+		if got := stdout.String(); !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+	for _, unwanted := range []string{"githooks", "remote \"git@example.com:hooks\""} {
+		if got := stdout.String(); strings.Contains(got, unwanted) {
+			t.Errorf("got %q, want it not to contain %q", got, unwanted)
+		}
+	}
+
+	lintJSONFlag = true
+	stdout.Reset()
+	if err := runProjectLintManifest(fake.X, []string{file}); err != cmdline.ErrExitCode(1) {
+		t.Fatalf("got error %v, want exit code 1", err)
+	}
+	var problems []project.ManifestProblem
+	if err := json.Unmarshal(stdout.Bytes(), &problems); err != nil {
+		t.Fatalf("Unmarshal(%v) failed: %v", stdout.String(), err)
+	}
+	if got, want := len(problems), 11; got != want {
+		t.Errorf("got %d problems, want %d: %v", got, want, problems)
+	}
+}
+
+func TestProjectReclone(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t, project.Project{Name: "p1", Path: "p1"})
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { recloneForceFlag = false }()
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+
+	// Add a local branch, and an uncommitted change to master.
+	path := filepath.Join(fake.X.Root, "p1")
+	git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(path))
+	if err := git.CreateAndCheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(path, "feature")
+	if err := ioutil.WriteFile(file, []byte("feature"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.CommitFile(file, "add feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.CheckoutBranch("master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "README"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.Add("README"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Projects with uncommitted changes are only recloned with -force.
+	if err := runProjectReclone(fake.X, []string{"p1"}); err != cmdline.ErrExitCode(1) {
+		t.Errorf("got error %v, want exit code 1", err)
+	}
+	if want := "has uncommitted changes"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("got %q, want it to contain %q", stderr.String(), want)
+	}
+	recloneForceFlag = true
+	if err := runProjectReclone(fake.X, []string{"p1"}); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := filepath.Glob(path + ".corrupt-*")
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("got parked checkouts %v (%v), want one", matches, err)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("Recloned project \"p1\" in %q; the old checkout is in %q\n", path, matches[0]),
+		"  saved branches: feature\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+		}
+	}
+	if !git.BranchExists("feature") {
+		t.Errorf("branch feature was not saved")
+	}
+	if dirty, err := git.HasUncommittedChanges(); err != nil || dirty {
+		t.Errorf("got uncommitted changes %v (%v), want none", dirty, err)
+	}
+	if _, err := project.ProjectAtPath(fake.X, path); err != nil {
+		t.Errorf("the metadata was not restored: %v", err)
+	}
+
+	// Checkouts that git cannot read are recloned, without their branches.
+	if err := os.RemoveAll(matches[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(path, ".git", "HEAD")); err != nil {
+		t.Fatal(err)
+	}
+	recloneForceFlag = false
+	stderr.Reset()
+	if err := runProjectReclone(fake.X, []string{"p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `WARNING: cannot list the branches of the old checkout of project "p1"`; !strings.Contains(stderr.String(), want) {
+		t.Errorf("got %q, want it to contain %q", stderr.String(), want)
+	}
+	if _, err := git.CurrentRevision(); err != nil {
+		t.Errorf("the new clone is broken: %v", err)
+	}
+}
+
+func TestProjectSetRemote(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "p1", Path: "p1"},
+		project.Project{Name: "p2", Path: "p2"},
+	)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		setRemoteFromFlag, setRemoteToFlag, setRemoteNamesFlag, setRemoteDryRunFlag = "", "", "", false
+	}()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	gitP1 := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(filepath.Join(fake.X.Root, "p1")))
+	gitP2 := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(filepath.Join(fake.X.Root, "p2")))
+	checkURL := func(git *gitutil.Git, remote, want string) {
+		if got, err := git.RemoteUrl(remote); err != nil || got != want {
+			t.Errorf("got %s URL %q, %v, want %q", remote, got, err, want)
+		}
+	}
+
+	// Migrate the projects to a new host in the manifest, give p1 a review
+	// remote on the old host, and p2 a custom origin.
+	oldHost, newHost := filepath.Dir(fake.Projects["p1"])+"/", "https://new.example.com/"
+	for _, name := range []string{"p1", "p2"} {
+		if err := fake.SetRemote(name, newHost+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(filepath.Join(fake.X.Root, "manifest"))).Fetch("origin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitP1.AddRemote("review", oldHost+"review/p1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitP2.SetRemoteUrl("origin", "https://custom.example.com/p2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run only prints the changes.
+	setRemoteFromFlag, setRemoteToFlag, setRemoteNamesFlag, setRemoteDryRunFlag = oldHost, newHost, "review", true
+	if err := runProjectSetRemote(fake.X, nil); err != cmdline.ErrExitCode(1) {
+		t.Fatalf("got error %v, want exit code 1", err)
+	}
+	want := fmt.Sprintf(`p1: origin %sp1 -> %sp1
+p1: review %sreview/p1 -> %sreview/p1
+p2: origin: ERROR: URL "https://custom.example.com/p2" is not the remote "%sp2" of the last update and does not start with %q, leaving it unchanged
+`, oldHost, newHost, oldHost, newHost, oldHost, oldHost)
+	if got := stdout.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	checkURL(gitP1, "origin", oldHost+"p1")
+	checkURL(gitP1, "review", oldHost+"review/p1")
+
+	// The remotes of the given projects are changed, and are then up to
+	// date.
+	setRemoteDryRunFlag = false
+	for i := 0; i < 2; i++ {
+		stdout.Reset()
+		if err := runProjectSetRemote(fake.X, []string{"p1"}); err != nil {
+			t.Fatal(err)
+		}
+		checkURL(gitP1, "origin", newHost+"p1")
+		checkURL(gitP1, "review", newHost+"review/p1")
+	}
+	if got := stdout.String(); got != "" {
+		t.Errorf("got %q, want no output", got)
+	}
+	checkURL(gitP2, "origin", "https://custom.example.com/p2")
+}
@@ -0,0 +1,255 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/github"
+	"v.io/jiri/gitutil"
+	"v.io/jiri/profiles/profilescmdline"
+	"v.io/jiri/project"
+	"v.io/jiri/runutil"
+)
+
+const pullRequestFileName = ".github_pull_request"
+
+// pullRequest records the pull request that "jiri cl mail" sent for a
+// branch, so that later invocations update it.
+type pullRequest struct {
+	// Number is the number of the pull request.
+	Number int `json:"number"`
+	// Remote is the git remote the branch is pushed to.
+	Remote string `json:"remote"`
+	// Branch is the branch of Remote the branch is pushed to.
+	Branch string `json:"branch"`
+	// Head is the head of the pull request, which is Branch prefixed with
+	// the owner of the fork and a colon if Remote is a fork.
+	Head string `json:"head"`
+}
+
+func getPullRequestFileName(jirix *jiri.X, branch string) (string, error) {
+	topLevel, err := gitutil.New(jirix.NewSeq()).TopLevel()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(topLevel, jiri.ProjectMetaDir, branch, pullRequestFileName), nil
+}
+
+// readPullRequest returns the pull request recorded for the given branch, or
+// nil if none was recorded.
+func readPullRequest(jirix *jiri.X, branch string) (*pullRequest, error) {
+	file, err := getPullRequestFileName(jirix, branch)
+	if err != nil {
+		return nil, err
+	}
+	data, err := jirix.NewSeq().ReadFile(file)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pr pullRequest
+	if err := json.Unmarshal(data, &pr); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%q) failed: %v", data, err)
+	}
+	return &pr, nil
+}
+
+// writePullRequest records the pull request of the given branch.
+func writePullRequest(jirix *jiri.X, branch string, pr *pullRequest) error {
+	file, err := getPullRequestFileName(jirix, branch)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pr, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent(%v) failed: %v", pr, err)
+	}
+	return jirix.NewSeq().MkdirAll(filepath.Dir(file), os.FileMode(0755)).
+		WriteFile(file, data, os.FileMode(0644)).Done()
+}
+
+// gitHubClient returns a client of the GitHub host of the given project.
+func gitHubClient(p project.Project) (*github.GitHub, error) {
+	repo, err := github.ParseRepo(p.ReviewHost)
+	if err != nil {
+		return nil, err
+	}
+	token, err := github.Token(repo)
+	if err != nil {
+		return nil, err
+	}
+	return github.New(repo, token), nil
+}
+
+// forkOwner returns the owner of the GitHub repository with the given git
+// url, such as "octocat" for https://github.com/octocat/repo.git or
+// git@github.com:octocat/repo.git.
+func forkOwner(fork string) (string, error) {
+	path := fork
+	if u, err := url.Parse(fork); err == nil && u.Scheme != "" {
+		path = u.Path
+	} else if i := strings.Index(fork, ":"); i >= 0 {
+		path = fork[i+1:]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[len(parts)-2] == "" {
+		return "", fmt.Errorf("cannot find the owner of fork %q", fork)
+	}
+	return parts[len(parts)-2], nil
+}
+
+// gerritOnlyFlags are the flags of "jiri cl mail" that have no equivalent for
+// pull requests.
+var gerritOnlyFlags = []string{"autosubmit", "cc", "hashtag", "label", "presubmit", "ready", "set-topic", "topic", "wip"}
+
+// mailPullRequest pushes the current branch to GitHub and creates a pull
+// request for it in the repository named by the reviewhost of the given
+// project, or updates the pull request created by a previous invocation.
+func mailPullRequest(jirix *jiri.X, p project.Project) error {
+	git := gitutil.New(jirix.NewSeq())
+	if uncommittedFlag {
+		changes, err := git.FilesWithUncommittedChanges()
+		if err != nil {
+			return err
+		}
+		if len(changes) != 0 {
+			return uncommittedChangesError(changes)
+		}
+	}
+	branch, err := git.CurrentBranchName()
+	if err != nil {
+		return err
+	}
+	if branch == remoteBranchFlag {
+		return fmt.Errorf("cannot do a review from the %q branch.", remoteBranchFlag)
+	}
+	if cmdCLMail.ParsedFlags != nil {
+		for _, name := range gerritOnlyFlags {
+			if profilescmdline.IsFlagSet(cmdCLMail.ParsedFlags, name) {
+				fmt.Fprintf(jirix.Stderr(), "WARNING: -%s is not supported for pull requests and is ignored\n", name)
+			}
+		}
+	}
+	client, err := gitHubClient(p)
+	if err != nil {
+		return err
+	}
+	recorded, err := readPullRequest(jirix, branch)
+	if err != nil {
+		return err
+	}
+	if recorded == nil {
+		// By default, the branch is pushed to a branch of origin that is
+		// unique to the user.
+		remoteBranch := "jiri/" + branch
+		if user := os.Getenv("USER"); user != "" {
+			remoteBranch = "jiri/" + user + "/" + branch
+		}
+		recorded = &pullRequest{Remote: "origin", Branch: remoteBranch, Head: remoteBranch}
+		if forkFlag != "" {
+			owner, err := forkOwner(forkFlag)
+			if err != nil {
+				return err
+			}
+			recorded = &pullRequest{Remote: forkFlag, Branch: branch, Head: owner + ":" + branch}
+		}
+	}
+
+	var pr github.PullRequest
+	if recorded.Number != 0 {
+		if pr, err = client.PullRequest(recorded.Number); err != nil {
+			return err
+		}
+		if pr.State == github.PullRequestClosed {
+			return fmt.Errorf("pull request %s of branch %q is closed, use \"jiri cl cleanup\" to delete the branch", pr.HTMLUrl, branch)
+		}
+	}
+	if jirix.Verbose() {
+		fmt.Fprintf(jirix.Stdout(), "Pushing %s to %s of %s\n", branch, recorded.Branch, recorded.Remote)
+	}
+	if err := git.Push(recorded.Remote, branch+":refs/heads/"+recorded.Branch, gitutil.ForceOpt(true), gitutil.VerifyOpt(verifyFlag)); err != nil {
+		return err
+	}
+
+	// The title and body of the pull request are the first line and the rest
+	// of -m, or of the last commit message of the branch.  Pull requests
+	// that already exist are only changed if -m is set.
+	message := messageFlag
+	if message == "" && recorded.Number == 0 {
+		if message, err = git.LatestCommitMessage(); err != nil {
+			return err
+		}
+	}
+	title, body := message, ""
+	if i := strings.Index(message, "\n"); i >= 0 {
+		title, body = message[:i], strings.TrimSpace(message[i+1:])
+	}
+	title = strings.TrimSpace(title)
+	switch {
+	case recorded.Number == 0:
+		if pr, err = client.CreatePullRequest(github.PullRequestOpts{
+			Head:  recorded.Head,
+			Base:  remoteBranchFlag,
+			Title: title,
+			Body:  body,
+			Draft: draftFlag,
+		}); err != nil {
+			return err
+		}
+		recorded.Number = pr.Number
+		if err := writePullRequest(jirix, branch, recorded); err != nil {
+			return err
+		}
+	case messageFlag != "":
+		if pr, err = client.UpdatePullRequest(recorded.Number, title, body); err != nil {
+			return err
+		}
+	}
+	// Reviewers are GitHub logins rather than email addresses.
+	reviewers := []string{}
+	for _, reviewer := range strings.Split(reviewersFlag, ",") {
+		if reviewer = strings.TrimSpace(reviewer); reviewer != "" {
+			reviewers = append(reviewers, reviewer)
+		}
+	}
+	if len(reviewers) != 0 {
+		if err := client.RequestReviewers(pr.Number, reviewers); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(jirix.Stdout(), "Pull request: %s\n", pr.HTMLUrl)
+	return nil
+}
+
+// pullRequestClosed returns whether the GitHub host of the given project
+// reports the pull request of the given branch as merged or closed.  If the
+// state cannot be fetched, a warning is printed and false is returned, so
+// that the branch is compared with the remote branch instead.
+func pullRequestClosed(jirix *jiri.X, p project.Project, branch string) (bool, error) {
+	recorded, err := readPullRequest(jirix, branch)
+	if err != nil || recorded == nil {
+		return false, err
+	}
+	client, err := gitHubClient(p)
+	if err != nil {
+		fmt.Fprintf(jirix.Stderr(), "WARNING: failed to get the state of the pull request of branch %q from %s, comparing it with the remote branch instead: %v\n", branch, p.ReviewHost, err)
+		return false, nil
+	}
+	pr, err := client.PullRequest(recorded.Number)
+	if err != nil {
+		fmt.Fprintf(jirix.Stderr(), "WARNING: failed to get the state of the pull request of branch %q from %s, comparing it with the remote branch instead: %v\n", branch, p.ReviewHost, err)
+		return false, nil
+	}
+	return pr.State == github.PullRequestClosed, nil
+}
@@ -22,12 +22,17 @@ var cmdRebuild = &cmdline.Command{
 Rebuilds all jiri tools and installs the resulting binaries into
 $JIRI_ROOT/.jiri_root/bin. This is similar to "jiri update", but does not update
 any projects before building the tools. The set of tools to rebuild is described
-in the manifest.
+in the manifest.  The tools that were installed before are kept, and can be
+reinstalled with "jiri rollback-tools".
 
 Run "jiri help manifest" for details on manifests.
 `,
 }
 
+func init() {
+	cmdRebuild.Flags.StringVar(&goRootFlag, "go-root", "", `Directory of the Go toolchain to build the tools with.  If empty, the toolchain installed by the "go" profile is used if there is one, and the "go" binary in PATH otherwise.`)
+}
+
 func runRebuild(jirix *jiri.X, args []string) (e error) {
 	projects, tools, err := project.LoadManifest(jirix)
 	if err != nil {
@@ -49,7 +54,7 @@ func runRebuild(jirix *jiri.X, args []string) (e error) {
 	}
 
 	// Build and install tools.
-	if err := project.BuildTools(jirix, projects, tools, tmpDir); err != nil {
+	if err := project.BuildTools(jirix, projects, tools, tmpDir, project.GoRootOpt(goRootFlag)); err != nil {
 		return err
 	}
 	return project.InstallTools(jirix, tmpDir)
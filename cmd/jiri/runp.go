@@ -6,6 +6,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -13,16 +14,20 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"v.io/jiri"
 	"v.io/jiri/gitutil"
+	"v.io/jiri/profiles"
 	"v.io/jiri/profiles/profilescmdline"
 	"v.io/jiri/profiles/profilesreader"
 	"v.io/jiri/project"
+	"v.io/jiri/runutil"
 	"v.io/jiri/tool"
 	"v.io/x/lib/cmdline"
 	"v.io/x/lib/envvar"
@@ -44,6 +49,26 @@ Run a command in parallel across one or more jiri projects using the specified
 profile target's environment. Commands are run using the shell specified by the
 users $SHELL environment variable, or "sh" if that's not set. Thus commands
 are run as $SHELL -c "args..."
+
+Once all the commands have completed, a table of the exit status and duration
+of the command in each project is printed to stderr if any of them failed, or
+if the -summary flag is set. The -json flag writes the same results to a file,
+or to stdout if the file is "-", as a JSON array of objects with the fields
+key, name, path, exitCode, durationMs, stdoutBytes and stderrBytes. The exit
+code is -1 for commands that could not be started or were killed, and the
+output sizes are not counted in -interactive mode.
+
+The exit code of runp is the largest exit code of the commands, or 1 if a
+command failed without an exit code.
+
+By default the commands run in all projects at once, in no particular order.
+The -ordered flag runs them one at a time, in the order of the paths of the
+projects, so that a project is processed before the projects nested in it.
+The -batch-by-depth flag groups the projects by the depth of their paths under
+$JIRI_ROOT, and runs the commands in each group in parallel, starting a group
+only once the commands in the shallower groups have completed.  With -v, the
+chosen order is printed before the commands are run.  If -exit-on-error is set
+and a command fails, the remaining projects are not processed.
  `,
 		ArgsName: "<command line>",
 		ArgsLong: `
@@ -55,35 +80,58 @@ runp by the shell.
 	}
 }
 
-type runpFlagValues struct {
-	profilescmdline.ReaderFlagValues
+// projectSelectionFlagValues holds the values of the flags used to select
+// the projects that a command is run in.
+type projectSelectionFlagValues struct {
 	projectKeys      string
-	verbose          bool
-	interactive      bool
 	hasUncommitted   bool
 	hasUntracked     bool
 	hasGerritMessage bool
-	showNamePrefix   bool
-	showKeyPrefix    bool
-	exitOnError      bool
-	collateOutput    bool
-	editMessage      bool
 	hasBranch        string
+	fromManifest     bool
 }
 
-func registerCommonFlags(flags *flag.FlagSet, values *runpFlagValues) {
-	profilescmdline.RegisterReaderFlags(flags, &values.ReaderFlagValues, "", jiri.ProfilesDBDir)
-	flags.BoolVar(&values.verbose, "v", false, "Print verbose logging information")
-	flags.StringVar(&values.projectKeys, "projects", "", "A Regular expression specifying project keys to run commands in. By default, runp will use projects that have the same branch checked as the current project unless it is run from outside of a project in which case it will default to using all projects.")
+type runpFlagValues struct {
+	profilescmdline.ReaderFlagValues
+	projectSelectionFlagValues
+	verbose        bool
+	interactive    bool
+	showNamePrefix bool
+	showKeyPrefix  bool
+	exitOnError    bool
+	collateOutput  bool
+	editMessage    bool
+	summary        bool
+	jsonFile       string
+	ordered        bool
+	batchByDepth   bool
+}
+
+// registerProjectSelectionFlags registers the flags used to select the
+// projects that a command is run in.  The defaultSelection describes the
+// projects that are selected if the -projects flag is not set.
+func registerProjectSelectionFlags(flags *flag.FlagSet, values *projectSelectionFlagValues, defaultSelection string) {
+	flags.StringVar(&values.projectKeys, "projects", "", "A Regular expression specifying project keys to run commands in. "+defaultSelection)
 	flags.BoolVar(&values.hasUncommitted, "has-uncommitted", false, "If specified, match projects that have, or have no, uncommitted changes")
 	flags.BoolVar(&values.hasUntracked, "has-untracked", false, "If specified, match projects that have, or have no, untracked files")
 	flags.BoolVar(&values.hasGerritMessage, "has-gerrit-message", false, "If specified, match branches that have, or have no, gerrit message")
+	flags.StringVar(&values.hasBranch, "has-branch", "", "A regular expression specifying branch names to use in matching projects. A project will match if the specified branch exists, even if it is not checked out.")
+	flags.BoolVar(&values.fromManifest, "from-manifest", false, "If set, also match the projects of the manifest that do not exist locally. They are reported, and skipped, so that they can be cloned with \"jiri project checkout\".")
+}
+
+func registerCommonFlags(flags *flag.FlagSet, values *runpFlagValues) {
+	profilescmdline.RegisterReaderFlags(flags, &values.ReaderFlagValues, "", jiri.ProfilesDBDir)
+	flags.BoolVar(&values.verbose, "v", false, "Print verbose logging information")
+	registerProjectSelectionFlags(flags, &values.projectSelectionFlagValues, "By default, runp will use projects that have the same branch checked as the current project unless it is run from outside of a project in which case it will default to using all projects.")
 	flags.BoolVar(&values.interactive, "interactive", true, "If set, the command to be run is interactive and should not have its stdout/stderr manipulated. This flag cannot be used with -show-name-prefix, -show-key-prefix or -collate-stdout.")
 	flags.BoolVar(&values.showNamePrefix, "show-name-prefix", false, "If set, each line of output from each project will begin with the name of the project followed by a colon. This is intended for use with long running commands where the output needs to be streamed. Stdout and stderr are spliced apart. This flag cannot be used with -interactive, -show-key-prefix or -collate-stdout.")
 	flags.BoolVar(&values.showKeyPrefix, "show-key-prefix", false, "If set, each line of output from each project will begin with the key of the project followed by a colon. This is intended for use with long running commands where the output needs to be streamed. Stdout and stderr are spliced apart. This flag cannot be used with -interactive, -show-name-prefix or -collate-stdout")
 	flags.BoolVar(&values.collateOutput, "collate-stdout", true, "Collate all stdout output from each parallel invocation and display it as if had been generated sequentially. This flag cannot be used with -show-name-prefix, -show-key-prefix or -interactive.")
 	flags.BoolVar(&values.exitOnError, "exit-on-error", false, "If set, all commands will killed as soon as one reports an error, otherwise, each will run to completion.")
-	flags.StringVar(&values.hasBranch, "has-branch", "", "A regular expression specifying branch names to use in matching projects. A project will match if the specified branch exists, even if it is not checked out.")
+	flags.BoolVar(&values.summary, "summary", false, "If set, print a table of the exit status and duration of the command in each project once all have completed, even if none failed.")
+	flags.StringVar(&values.jsonFile, "json", "", "If set, write the exit status, duration and output sizes of the command in each project as a JSON array to the given file, or to stdout if the file is \"-\".")
+	flags.BoolVar(&values.ordered, "ordered", false, "If set, run the command in one project at a time, in the order of the project paths. This flag cannot be used with -batch-by-depth.")
+	flags.BoolVar(&values.batchByDepth, "batch-by-depth", false, "If set, run the command in parallel in the projects at the same depth under JIRI_ROOT, waiting for the shallower projects to complete before starting the deeper ones. This flag cannot be used with -ordered.")
 }
 
 func init() {
@@ -135,6 +183,55 @@ type runner struct {
 	reader               *profilesreader.Reader
 	serializedWriterLock sync.Mutex
 	collatedOutputLock   sync.Mutex
+	resultsLock          sync.Mutex
+	results              runpResults
+}
+
+// runpResult records the outcome of running the command in a project.
+type runpResult struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	ExitCode    int    `json:"exitCode"`
+	DurationMs  int64  `json:"durationMs"`
+	StdoutBytes int64  `json:"stdoutBytes"`
+	StderrBytes int64  `json:"stderrBytes"`
+	err         error
+}
+
+// runpResults sorts results by project key.
+type runpResults []runpResult
+
+func (r runpResults) Len() int           { return len(r) }
+func (r runpResults) Less(i, j int) bool { return r[i].Key < r[j].Key }
+func (r runpResults) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func (r *runner) addResult(result runpResult) {
+	r.resultsLock.Lock()
+	defer r.resultsLock.Unlock()
+	r.results = append(r.results, result)
+}
+
+// byteCounter is an io.Writer that counts the bytes written to it.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(d []byte) (int, error) {
+	c.n += int64(len(d))
+	return len(d), nil
+}
+
+// exitCode returns the exit code of the command that returned the given
+// error from Wait, or -1 if it did not exit normally.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if code, ok := runutil.TranslateExitCode(err).(cmdline.ErrExitCode); ok {
+		return int(code)
+	}
+	return -1
 }
 
 func (r *runner) serializedWriter(w io.Writer) io.Writer {
@@ -184,6 +281,7 @@ func (r *runner) Map(mr *simplemr.MR, key string, val interface{}) error {
 		path = "sh"
 	}
 	var wg sync.WaitGroup
+	var stdoutCount, stderrCount byteCounter
 	cmd := exec.Command(path, "-c", strings.Join(r.args, " "))
 	cmd.Env = envvar.MapToSlice(jirix.Env())
 	cmd.Dir = mi.ProjectState.Project.Path
@@ -218,8 +316,8 @@ func (r *runner) Map(mr *simplemr.MR, key string, val interface{}) error {
 		}
 		if !runpFlags.showNamePrefix && !runpFlags.showKeyPrefix {
 			// write directly to stdout, stderr if there's no prefix
-			cmd.Stdout = stdout
-			cmd.Stderr = stderr
+			cmd.Stdout = io.MultiWriter(stdout, &stdoutCount)
+			cmd.Stderr = io.MultiWriter(stderr, &stderrCount)
 		} else {
 			stdoutReader, stdoutWriter, err := os.Pipe()
 			if err != nil {
@@ -245,32 +343,50 @@ func (r *runner) Map(mr *simplemr.MR, key string, val interface{}) error {
 				prefix = mi.ProjectState.Project.Name
 			}
 			wg.Add(2)
-			go func() { copyWithPrefix(prefix, stdout, stdoutReader); wg.Done() }()
-			go func() { copyWithPrefix(prefix, stderr, stderrReader); wg.Done() }()
+			go func() { copyWithPrefix(prefix, stdout, io.TeeReader(stdoutReader, &stdoutCount)); wg.Done() }()
+			go func() { copyWithPrefix(prefix, stderr, io.TeeReader(stderrReader, &stderrCount)); wg.Done() }()
 
 		}
 	}
+	start := time.Now()
 	if err := cmd.Start(); err != nil {
 		mi.result = err
-	}
-	done := make(chan error)
-	go func() {
-		done <- cmd.Wait()
-	}()
-	select {
-	case output.err = <-done:
-		if output.err != nil && runpFlags.exitOnError {
-			mr.Cancel()
+		output.err = err
+	} else {
+		done := make(chan error)
+		go func() {
+			done <- cmd.Wait()
+		}()
+		select {
+		case output.err = <-done:
+			if output.err != nil && runpFlags.exitOnError {
+				mr.Cancel()
+			}
+		case <-mr.CancelCh():
+			if err := cmd.Process.Kill(); err != nil {
+				output.err = err
+			} else {
+				output.err = <-done
+			}
 		}
-	case <-mr.CancelCh():
-		output.err = cmd.Process.Kill()
 	}
+	duration := time.Since(start)
 	for _, closer := range []io.Closer{stdoutCloser, stderrCloser} {
 		if closer != nil {
 			closer.Close()
 		}
 	}
 	wg.Wait()
+	r.addResult(runpResult{
+		Key:         key,
+		Name:        mi.ProjectState.Project.Name,
+		Path:        mi.ProjectState.Project.Path,
+		ExitCode:    exitCode(output.err),
+		DurationMs:  int64(duration / time.Millisecond),
+		StdoutBytes: stdoutCount.n,
+		StderrBytes: stderrCount.n,
+		err:         output.err,
+	})
 	mr.MapOut(key, output)
 	return nil
 }
@@ -281,7 +397,9 @@ func (r *runner) Reduce(mr *simplemr.MR, key string, values []interface{}) error
 		jirix := mo.mi.jirix
 		if mo.err != nil {
 			fmt.Fprintf(jirix.Stdout(), "FAILED: %v: %s %v\n", mo.key, strings.Join(r.args, " "), mo.err)
-			return mo.err
+			if mo.outputFilename != "" {
+				os.Remove(mo.outputFilename)
+			}
 		} else {
 			if runpFlags.collateOutput {
 				r.collatedOutputLock.Lock()
@@ -299,55 +417,51 @@ func (r *runner) Reduce(mr *simplemr.MR, key string, values []interface{}) error
 	return nil
 }
 
-func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
-	hasUntrackedSet := profilescmdline.IsFlagSet(cmd.ParsedFlags, "has-untracked")
-	hasUncommitedSet := profilescmdline.IsFlagSet(cmd.ParsedFlags, "has-uncommitted")
-	hasGerritSet := profilescmdline.IsFlagSet(cmd.ParsedFlags, "has-gerrit-message")
-
-	if runpFlags.interactive {
-		runpFlags.collateOutput = false
-	}
+// selectProjects returns the states and sorted keys of the projects selected
+// by the given flag values.  If the -projects flag is not set, projects that
+// have the same branch checked out as the current project are selected, unless
+// allByDefault is true or jiri is run from outside of a project, in which case
+// all projects are selected.  The projects of the manifest that do not exist
+// locally, which are matched if -from-manifest is set, are reported and
+// skipped.
+func selectProjects(jirix *jiri.X, parsedFlags *flag.FlagSet, values *projectSelectionFlagValues, allByDefault bool) (map[project.ProjectKey]*project.ProjectState, project.ProjectKeys, error) {
+	hasUntrackedSet := profilescmdline.IsFlagSet(parsedFlags, "has-untracked")
+	hasUncommitedSet := profilescmdline.IsFlagSet(parsedFlags, "has-uncommitted")
+	hasGerritSet := profilescmdline.IsFlagSet(parsedFlags, "has-gerrit-message")
 
 	var keysRE, branchRE *regexp.Regexp
 	var err error
 
-	if profilescmdline.IsFlagSet(cmd.ParsedFlags, "projects") {
+	if profilescmdline.IsFlagSet(parsedFlags, "projects") {
 		re := ""
-		for _, pre := range strings.Split(runpFlags.projectKeys, ",") {
+		for _, pre := range strings.Split(values.projectKeys, ",") {
 			re += pre + "|"
 		}
 		re = strings.TrimRight(re, "|")
 		keysRE, err = regexp.Compile(re)
 		if err != nil {
-			return fmt.Errorf("failed to compile projects regexp: %q: %v", runpFlags.projectKeys, err)
+			return nil, nil, fmt.Errorf("failed to compile projects regexp: %q: %v", values.projectKeys, err)
 		}
 	}
 
-	if profilescmdline.IsFlagSet(cmd.ParsedFlags, "has-branch") {
-		branchRE, err = regexp.Compile(runpFlags.hasBranch)
+	if profilescmdline.IsFlagSet(parsedFlags, "has-branch") {
+		branchRE, err = regexp.Compile(values.hasBranch)
 		if err != nil {
-			return fmt.Errorf("failed to compile has-branch regexp: %q: %v", runpFlags.hasBranch, err)
-		}
-	}
-
-	for _, f := range []string{"show-key-prefix", "show-name-prefix"} {
-		if profilescmdline.IsFlagSet(cmd.ParsedFlags, f) {
-			if runpFlags.interactive && profilescmdline.IsFlagSet(cmd.ParsedFlags, "interactive") {
-				fmt.Fprintf(jirix.Stderr(), "WARNING: interactive mode being disabled because %s was set\n", f)
-			}
-			runpFlags.interactive = false
-			runpFlags.collateOutput = true
-			break
+			return nil, nil, fmt.Errorf("failed to compile has-branch regexp: %q: %v", values.hasBranch, err)
 		}
 	}
 
-	git := gitutil.New(jirix.NewSeq())
-	homeBranch, err := git.CurrentBranchName()
-	if err != nil {
-		// jiri was run from outside of a project. Let's assume we'll
-		// use all projects if none have been specified via the projects flag.
-		if keysRE == nil {
+	homeBranch := ""
+	if keysRE == nil {
+		if allByDefault {
 			keysRE = regexp.MustCompile(".*")
+		} else {
+			git := gitutil.New(jirix.NewSeq())
+			if homeBranch, err = git.CurrentBranchName(); err != nil {
+				// jiri was run from outside of a project. Let's assume we'll
+				// use all projects if none have been specified via the projects flag.
+				keysRE = regexp.MustCompile(".*")
+			}
 		}
 	}
 
@@ -355,12 +469,21 @@ func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
 	if hasUntrackedSet || hasUncommitedSet {
 		dirty = true
 	}
-	states, err := project.GetProjectStates(jirix, dirty)
+	getStates := project.GetProjectStates
+	if values.fromManifest {
+		getStates = project.GetManifestProjectStates
+	}
+	states, err := getStates(jirix, dirty)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	mapInputs := map[project.ProjectKey]*mapInput{}
-	var keys project.ProjectKeys
+	if profilescmdline.IsFlagSet(parsedFlags, "projects") {
+		if err := checkSelections(states, []string{values.projectKeys}, []*regexp.Regexp{keysRE}); err != nil {
+			return nil, nil, err
+		}
+	}
+	selected := map[project.ProjectKey]*project.ProjectState{}
+	var keys, missing project.ProjectKeys
 	for key, state := range states {
 		if keysRE != nil {
 			if !keysRE.MatchString(string(key)) {
@@ -383,10 +506,10 @@ func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
 				continue
 			}
 		}
-		if hasUntrackedSet && (state.HasUntracked != runpFlags.hasUntracked) {
+		if hasUntrackedSet && (state.HasUntracked != values.hasUntracked) {
 			continue
 		}
-		if hasUncommitedSet && (state.HasUncommitted != runpFlags.hasUncommitted) {
+		if hasUncommitedSet && (state.HasUncommitted != values.hasUncommitted) {
 			continue
 		}
 		if hasGerritSet {
@@ -397,16 +520,163 @@ func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
 					break
 				}
 			}
-			if hasMsg != runpFlags.hasGerritMessage {
+			if hasMsg != values.hasGerritMessage {
 				continue
 			}
 		}
+		if !state.Present {
+			missing = append(missing, key)
+			continue
+		}
+		selected[key] = state
+		keys = append(keys, key)
+	}
+	sort.Sort(missing)
+	for _, key := range missing {
+		fmt.Fprintf(jirix.Stderr(), "WARNING: skipping project %q, which does not exist locally; run \"jiri project checkout\" to clone it\n", states[key].Project.Name)
+	}
+	sort.Sort(keys)
+	return selected, keys, nil
+}
+
+// runpBatch is a group of projects in which the command is run in parallel.
+type runpBatch struct {
+	depth int
+	keys  project.ProjectKeys
+}
+
+// projectDepth returns the number of elements of the path of the given
+// project relative to root, or of its absolute path if it is not under root.
+func projectDepth(root, path string) int {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = strings.TrimPrefix(filepath.Clean(path), string(filepath.Separator))
+	}
+	if rel == "." || rel == "" {
+		return 0
+	}
+	return len(strings.Split(rel, string(filepath.Separator)))
+}
+
+// runpBatches returns the batches the command is run in, in order.  With
+// -ordered, each project is a batch of its own, in the order of the project
+// paths, and with -batch-by-depth, the projects are batched by the depth of
+// their paths under root.  Otherwise all projects are run in a single batch.
+func runpBatches(root string, states map[project.ProjectKey]*project.ProjectState, keys project.ProjectKeys) []runpBatch {
+	if !runpFlags.ordered && !runpFlags.batchByDepth {
+		return []runpBatch{{keys: keys}}
+	}
+	sorted := append(project.ProjectKeys(nil), keys...)
+	path := func(key project.ProjectKey) string { return filepath.Clean(states[key].Project.Path) }
+	sort.SliceStable(sorted, func(i, j int) bool { return path(sorted[i]) < path(sorted[j]) })
+	batches := []runpBatch{}
+	if runpFlags.ordered {
+		for _, key := range sorted {
+			batches = append(batches, runpBatch{projectDepth(root, path(key)), project.ProjectKeys{key}})
+		}
+		return batches
+	}
+	byDepth := map[int]project.ProjectKeys{}
+	for _, key := range sorted {
+		depth := projectDepth(root, path(key))
+		byDepth[depth] = append(byDepth[depth], key)
+	}
+	depths := []int{}
+	for depth := range byDepth {
+		depths = append(depths, depth)
+	}
+	sort.Ints(depths)
+	for _, depth := range depths {
+		batches = append(batches, runpBatch{depth, byDepth[depth]})
+	}
+	return batches
+}
+
+// printRunpOrder prints the order in which the command is run in the
+// projects of the given batches.
+func printRunpOrder(w io.Writer, states map[project.ProjectKey]*project.ProjectState, batches []runpBatch) {
+	names := func(keys project.ProjectKeys) string {
+		n := []string{}
+		for _, key := range keys {
+			n = append(n, states[key].Project.Name)
+		}
+		return strings.Join(n, " ")
+	}
+	switch {
+	case runpFlags.ordered:
+		keys := project.ProjectKeys{}
+		for _, batch := range batches {
+			keys = append(keys, batch.keys...)
+		}
+		fmt.Fprintf(w, "Project Order: %s\n", names(keys))
+	case runpFlags.batchByDepth:
+		for i, batch := range batches {
+			fmt.Fprintf(w, "Batch %d (depth %d): %s\n", i+1, batch.depth, names(batch.keys))
+		}
+	}
+}
+
+// runBatch runs the command in the projects with the given keys in parallel,
+// with at most numMappers commands at a time if it is not zero, and returns
+// once all have completed or the batch was cancelled.
+func (r *runner) runBatch(mapInputs map[project.ProjectKey]*mapInput, keys project.ProjectKeys, numMappers int, interrupted <-chan struct{}) error {
+	mr := simplemr.MR{NumMappers: numMappers}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupted:
+			mr.Cancel()
+		case <-done:
+		}
+	}()
+	in, out := make(chan *simplemr.Record, len(keys)), make(chan *simplemr.Record, len(keys))
+	go mr.Run(in, out, r, r)
+	for _, key := range keys {
+		in <- &simplemr.Record{Key: string(key), Values: []interface{}{mapInputs[key]}}
+	}
+	close(in)
+	<-out
+	return mr.Error()
+}
+
+func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
+	// Interrupting runp cancels the commands gracefully and reports their
+	// outcome, rather than exit right away.
+	jirix.HandleSignalsItself()
+	if runpFlags.ordered && runpFlags.batchByDepth {
+		return jirix.UsageErrorf("-ordered and -batch-by-depth cannot be used together")
+	}
+	if runpFlags.interactive {
+		runpFlags.collateOutput = false
+	}
+
+	for _, f := range []string{"show-key-prefix", "show-name-prefix"} {
+		if profilescmdline.IsFlagSet(cmd.ParsedFlags, f) {
+			if runpFlags.interactive && profilescmdline.IsFlagSet(cmd.ParsedFlags, "interactive") {
+				fmt.Fprintf(jirix.Stderr(), "WARNING: interactive mode being disabled because %s was set\n", f)
+			}
+			runpFlags.interactive = false
+			runpFlags.collateOutput = true
+			break
+		}
+	}
+
+	if err := profiles.ResolveTarget(jirix, &runpFlags.Target); err != nil {
+		return err
+	}
+
+	states, keys, err := selectProjects(jirix, cmd.ParsedFlags, &runpFlags.projectSelectionFlagValues, false)
+	if err != nil {
+		return err
+	}
+	mapInputs := map[project.ProjectKey]*mapInput{}
+	for key, state := range states {
 		mapInputs[key] = &mapInput{
 			ProjectState: state,
 			jirix:        jirix,
 			key:          key,
 		}
-		keys = append(keys, key)
 	}
 
 	total := len(mapInputs)
@@ -421,29 +691,95 @@ func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
 		fmt.Fprintf(jirix.Stdout(), "Project Names: %s\n", strings.Join(stateNames(mapInputs), " "))
 		fmt.Fprintf(jirix.Stdout(), "Project Keys: %s\n", strings.Join(stateKeys(mapInputs), " "))
 	}
+	batches := runpBatches(jirix.Root, states, keys)
+	if runpFlags.verbose {
+		printRunpOrder(jirix.Stdout(), states, batches)
+	}
 
 	reader, err := profilesreader.NewReader(jirix, runpFlags.ProfilesMode, runpFlags.DBFilename)
 	runner := &runner{
 		reader: reader,
 		args:   args,
 	}
-	mr := simplemr.MR{}
-	if runpFlags.interactive {
+	numMappers := 0
+	if runpFlags.interactive || runpFlags.ordered {
 		// Run one mapper at a time.
-		mr.NumMappers = 1
-		sort.Sort(keys)
+		numMappers = 1
 	}
-	in, out := make(chan *simplemr.Record, len(mapInputs)), make(chan *simplemr.Record, len(mapInputs))
-	sigch := make(chan os.Signal)
+	interrupted := make(chan struct{})
+	sigch := make(chan os.Signal, 1)
 	signal.Notify(sigch, os.Interrupt)
-	go func() { <-sigch; mr.Cancel() }()
-	go mr.Run(in, out, runner, runner)
-	for _, key := range keys {
-		in <- &simplemr.Record{string(key), []interface{}{mapInputs[key]}}
+	go func() { <-sigch; close(interrupted) }()
+	var mrErr error
+	for _, batch := range batches {
+		// A batch is cancelled if runp is interrupted, or a command failed
+		// and -exit-on-error is set, in which case the remaining batches
+		// are skipped.
+		if mrErr = runner.runBatch(mapInputs, batch.keys, numMappers, interrupted); mrErr != nil {
+			break
+		}
 	}
-	close(in)
-	<-out
-	return mr.Error()
+	sort.Sort(runner.results)
+	if err := reportRunpResults(jirix, runner.results); err != nil {
+		return err
+	}
+	if code := runpExitCode(runner.results); code != 0 {
+		return cmdline.ErrExitCode(code)
+	}
+	return mrErr
+}
+
+// reportRunpResults prints a table of the given results to stderr if any of
+// them failed or -summary is set, and writes them to the -json file, if any.
+func reportRunpResults(jirix *jiri.X, results runpResults) error {
+	if runpFlags.summary || runpExitCode(results) != 0 {
+		width := 0
+		for _, result := range results {
+			if len(result.Name) > width {
+				width = len(result.Name)
+			}
+		}
+		w := jirix.Stderr()
+		for _, result := range results {
+			status := "ok"
+			switch {
+			case result.ExitCode > 0:
+				status = fmt.Sprintf("exit %d", result.ExitCode)
+			case result.err != nil:
+				status = fmt.Sprintf("failed: %v", result.err)
+			}
+			duration := time.Duration(result.DurationMs) * time.Millisecond
+			fmt.Fprintf(w, "%-*s  %-8s  %v\n", width, result.Name, status, duration)
+		}
+	}
+	if runpFlags.jsonFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent() failed: %v", err)
+	}
+	data = append(data, '\n')
+	if runpFlags.jsonFile == "-" {
+		_, err := jirix.Stdout().Write(data)
+		return err
+	}
+	return jirix.NewSeq().WriteFile(runpFlags.jsonFile, data, os.FileMode(0644)).Done()
+}
+
+// runpExitCode returns the largest exit code of the given results, or 1 if
+// a command failed without an exit code.
+func runpExitCode(results runpResults) int {
+	code := 0
+	for _, result := range results {
+		switch {
+		case result.ExitCode > code:
+			code = result.ExitCode
+		case result.err != nil && code == 0:
+			code = 1
+		}
+	}
+	return code
 }
 
 func runRunp(jirix *jiri.X, args []string) error {
@@ -5,10 +5,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
@@ -17,6 +20,8 @@ import (
 	"v.io/jiri/gitutil"
 	"v.io/jiri/jiritest"
 	"v.io/jiri/project"
+	"v.io/jiri/tool"
+	"v.io/x/lib/cmdline"
 	"v.io/x/lib/gosh"
 )
 
@@ -247,3 +252,165 @@ func TestRunP(t *testing.T) {
 	}
 
 }
+
+// TestRunPResults checks that runp reports the exit code, duration and output
+// sizes of the command in each project, and exits with the largest exit code.
+func TestRunPResults(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "p1", Path: "p1"},
+		project.Project{Name: "p2", Path: "p2"},
+		project.Project{Name: "p3", Path: "p3"},
+	)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	for name, code := range map[string]string{"p2": "3", "p3": "1"} {
+		if err := ioutil.WriteFile(filepath.Join(fake.X.Root, name, "code"), []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+
+	saved := runpFlags
+	defer func() { runpFlags = saved }()
+	cmd := newRunP()
+	registerCommonFlags(&cmd.Flags, &runpFlags)
+	jsonFile := filepath.Join(fake.X.Root, "results.json")
+	if err := cmd.Flags.Parse([]string{"-projects=p[0-9]", "-interactive=false", "-json=" + jsonFile}); err != nil {
+		t.Fatal(err)
+	}
+	cmd.ParsedFlags = &cmd.Flags
+	err := runp(fake.X, cmd, []string{"echo", "hello;", "test", "-f", "code", "&&", "exit", "$(cat", "code)", "||", "true"})
+	if got, want := err, cmdline.ErrExitCode(3); got != want {
+		t.Errorf("got error %v, want %v", got, want)
+	}
+
+	data, err := ioutil.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []runpResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatalf("json.Unmarshal(%q) failed: %v", data, err)
+	}
+	got := map[string]int{}
+	for _, result := range results {
+		got[result.Name] = result.ExitCode
+		if result.StdoutBytes != int64(len("hello\n")) {
+			t.Errorf("%s: got %d stdout bytes, want %d", result.Name, result.StdoutBytes, len("hello\n"))
+		}
+		if result.Path != filepath.Join(fake.X.Root, result.Name) {
+			t.Errorf("%s: got path %q", result.Name, result.Path)
+		}
+	}
+	if want := map[string]int{"p1": 0, "p2": 3, "p3": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got exit codes %v, want %v", got, want)
+	}
+	for _, want := range []string{"p1  ok", "p2  exit 3", "p3  exit 1"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("got stderr %q, want it to contain %q", stderr.String(), want)
+		}
+	}
+}
+
+// TestRunPOrder checks that -ordered runs the command in one project at a
+// time in the order of the project paths, and that -batch-by-depth runs the
+// command in the projects of a depth only once the shallower ones completed.
+func TestRunPOrder(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "a", Path: "a"},
+		project.Project{Name: "a-nested", Path: "a/nested"},
+		project.Project{Name: "a-nested-deeper", Path: "a/nested/deeper"},
+		project.Project{Name: "b", Path: "b"},
+		project.Project{Name: "b-nested", Path: "b/nested"},
+	)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	rel := func(path string) string {
+		rel, err := filepath.Rel(fake.X.Root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rel
+	}
+
+	// runOrdered runs a command that logs when it starts and ends in each
+	// project with the given flags, and returns the stdout of runp and the
+	// log.
+	runOrdered := func(flags ...string) (string, []string) {
+		logFile := filepath.Join(fake.X.Root, "log")
+		if err := os.RemoveAll(logFile); err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		jirix := fake.X.Clone(tool.ContextOpts{Stdout: &stdout, Stderr: ioutil.Discard})
+		saved := runpFlags
+		defer func() { runpFlags = saved }()
+		cmd := newRunP()
+		registerCommonFlags(&cmd.Flags, &runpFlags)
+		if err := cmd.Flags.Parse(append([]string{"-projects=^(a|b)", "-interactive=false", "-v"}, flags...)); err != nil {
+			t.Fatal(err)
+		}
+		cmd.ParsedFlags = &cmd.Flags
+		if err := runp(jirix, cmd, []string{"echo", "start", "$(pwd)", ">>", logFile, "&&", "sleep", "0.2", "&&", "echo", "end", "$(pwd)", ">>", logFile}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(logFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			fields := strings.Fields(line)
+			log = append(log, fields[0]+" "+rel(fields[1]))
+		}
+		return stdout.String(), log
+	}
+
+	stdout, log := runOrdered("-ordered")
+	want := []string{}
+	for _, path := range []string{"a", "a/nested", "a/nested/deeper", "b", "b/nested"} {
+		want = append(want, "start "+path, "end "+path)
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("-ordered: got log %v, want %v", log, want)
+	}
+	if want := "Project Order: a a-nested a-nested-deeper b b-nested\n"; !strings.Contains(stdout, want) {
+		t.Errorf("-ordered: got stdout %q, want it to contain %q", stdout, want)
+	}
+
+	stdout, log = runOrdered("-batch-by-depth")
+	if got, want := len(log), 10; got != want {
+		t.Fatalf("-batch-by-depth: got log %v, want %d lines", log, want)
+	}
+	// Every command of a depth ends before the commands of the next depth
+	// start.
+	lastEnd := map[int]int{}
+	firstStart := map[int]int{}
+	for i, line := range log {
+		fields := strings.Fields(line)
+		depth := strings.Count(fields[1], "/") + 1
+		switch fields[0] {
+		case "start":
+			if _, ok := firstStart[depth]; !ok {
+				firstStart[depth] = i
+			}
+		case "end":
+			lastEnd[depth] = i
+		}
+	}
+	for depth := 1; depth < 3; depth++ {
+		if lastEnd[depth] > firstStart[depth+1] {
+			t.Errorf("-batch-by-depth: depth %d started before depth %d completed: %v", depth+1, depth, log)
+		}
+	}
+	for _, want := range []string{"Batch 1 (depth 1): a b\n", "Batch 2 (depth 2): a-nested b-nested\n", "Batch 3 (depth 3): a-nested-deeper\n"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("-batch-by-depth: got stdout %q, want it to contain %q", stdout, want)
+		}
+	}
+}
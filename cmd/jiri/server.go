@@ -0,0 +1,263 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/project"
+	"v.io/x/lib/cmdline"
+)
+
+var serverTTLFlag time.Duration
+
+func init() {
+	cmdServer.Flags.DurationVar(&serverTTLFlag, "ttl", 2*time.Second, "Maximum age of the cached project states.")
+}
+
+// serverTimeout bounds the time clients wait for "jiri server", after which
+// they compute the project states themselves.
+var serverTimeout = 5 * time.Second
+
+// cmdServer represents the "jiri server" command.
+var cmdServer = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runServer),
+	Name:   "server",
+	Short:  "Serve cached project states to editors and shell prompts",
+	Long: `
+Start a long-lived process that caches the local projects and their states,
+and serves them as JSON over HTTP on the unix domain socket
+$JIRI_ROOT/.jiri_root/server.sock, for editor plugins and shell prompts that
+query them too often to scan the projects and run git each time.  The server
+runs until it is killed.
+
+The cached states are recomputed when they are older than -ttl, or when "jiri
+update" or "jiri snapshot checkout" changed the local projects, which they
+record in $JIRI_ROOT/.jiri_root/generation.  Changes made by git commands,
+such as switching branches, are therefore reported with a delay of up to
+-ttl.
+
+The server answers the following GET requests:
+
+  /states?check-dirty=<bool>
+    The states of all local projects, keyed by project key, as in "jiri
+    project info".  If check-dirty is false, uncommitted changes and
+    untracked files are not checked.
+  /project?path=<path>
+    The local project that contains the given absolute path.
+  /shell-prompt?path=<path>&check-dirty=<bool>&show-name=<bool>
+    The output of "jiri project shell-prompt" in the given directory, as
+    {"prompt": "<output>"}.
+
+Run "jiri project shell-prompt -cached" to use the server from a shell
+prompt; it computes the states itself if the server is not running.
+`,
+}
+
+func runServer(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	socket := jirix.ServerSocket()
+	// A socket that no server listens on is left behind by a server that was
+	// killed.
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("a server is already listening on %s", socket)
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	fmt.Fprintf(jirix.Stdout(), "Listening on %s\n", socket)
+	return http.Serve(listener, newStateServer(jirix, serverTTLFlag))
+}
+
+// stateCache caches the states of the local projects for "jiri server".
+type stateCache struct {
+	// mu guards jirix, which is not threadsafe, and entries.
+	mu    sync.Mutex
+	jirix *jiri.X
+	ttl   time.Duration
+	// entries holds the cached states, keyed by whether uncommitted changes
+	// and untracked files were checked.
+	entries map[bool]stateCacheEntry
+}
+
+type stateCacheEntry struct {
+	states     map[project.ProjectKey]*project.ProjectState
+	time       time.Time
+	generation string
+}
+
+// states returns the states of the local projects, which are recomputed if
+// they are older than the ttl of the cache or the local projects were updated
+// since they were computed.
+func (c *stateCache) states(checkDirty bool) (map[project.ProjectKey]*project.ProjectState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	generation := ""
+	if data, err := ioutil.ReadFile(c.jirix.GenerationFile()); err == nil {
+		generation = string(data)
+	}
+	if entry, ok := c.entries[checkDirty]; ok && time.Since(entry.time) < c.ttl && entry.generation == generation {
+		return entry.states, nil
+	}
+	states, err := project.GetProjectStates(c.jirix, checkDirty)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[checkDirty] = stateCacheEntry{states, time.Now(), generation}
+	return states, nil
+}
+
+// projectForPath returns the state of the local project that contains the
+// given path, or nil if there is none.
+func projectForPath(states map[project.ProjectKey]*project.ProjectState, path string) *project.ProjectState {
+	var found *project.ProjectState
+	for _, state := range states {
+		dir := state.Project.Path
+		if path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			continue
+		}
+		// Prefer the innermost project.
+		if found == nil || len(dir) > len(found.Project.Path) {
+			found = state
+		}
+	}
+	return found
+}
+
+// newStateServer returns the HTTP handler of "jiri server".
+func newStateServer(jirix *jiri.X, ttl time.Duration) http.Handler {
+	cache := &stateCache{jirix: jirix, ttl: ttl, entries: map[bool]stateCacheEntry{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/states", func(w http.ResponseWriter, r *http.Request) {
+		states, err := cache.states(boolParam(r, "check-dirty", true))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, states)
+	})
+	mux.HandleFunc("/project", func(w http.ResponseWriter, r *http.Request) {
+		states, err := cache.states(false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		state := projectForPath(states, r.FormValue("path"))
+		if state == nil {
+			http.Error(w, fmt.Sprintf("no project contains %q", r.FormValue("path")), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, state.Project)
+	})
+	mux.HandleFunc("/shell-prompt", func(w http.ResponseWriter, r *http.Request) {
+		checkDirty := boolParam(r, "check-dirty", true)
+		states, err := cache.states(checkDirty)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var current project.ProjectKey
+		if state := projectForPath(states, r.FormValue("path")); state != nil {
+			current = state.Project.Key()
+		}
+		writeJSON(w, shellPromptResponse{shellPrompt(states, current, checkDirty, boolParam(r, "show-name", false))})
+	})
+	return mux
+}
+
+type shellPromptResponse struct {
+	Prompt string `json:"prompt"`
+}
+
+// boolParam returns the boolean value of the given parameter of the request,
+// or def if it is not set or invalid.
+func boolParam(r *http.Request, name string, def bool) bool {
+	value, err := strconv.ParseBool(r.FormValue(name))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// queryServer sends a GET request for the given path and parameters to "jiri
+// server", and decodes its JSON response into result.  It fails if the server
+// is not running.
+func queryServer(jirix *jiri.X, path string, params url.Values, result interface{}) error {
+	socket := jirix.ServerSocket()
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+		Timeout: serverTimeout,
+	}
+	res, err := client.Get("http://jiri" + path + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// cachedShellPrompt returns the output of "jiri project shell-prompt" in the
+// current directory from "jiri server".
+func cachedShellPrompt(jirix *jiri.X) (string, error) {
+	if _, err := os.Stat(jirix.ServerSocket()); err != nil {
+		return "", err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if evaled, err := filepath.EvalSymlinks(cwd); err == nil {
+		cwd = evaled
+	}
+	params := url.Values{}
+	params.Set("path", cwd)
+	params.Set("check-dirty", strconv.FormatBool(checkDirtyFlag))
+	params.Set("show-name", strconv.FormatBool(showNameFlag))
+	var response shellPromptResponse
+	if err := queryServer(jirix, "/shell-prompt", params, &response); err != nil {
+		return "", err
+	}
+	return response.Prompt, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+	"v.io/jiri/jiritest"
+	"v.io/jiri/project"
+	"v.io/jiri/tool"
+)
+
+func shellPromptOutput(t *testing.T, jirix *jiri.X, cached bool) string {
+	cachedFlag = cached
+	var stdout bytes.Buffer
+	if err := runProjectShellPrompt(jirix.Clone(tool.ContextOpts{Stdout: &stdout}), nil); err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String()
+}
+
+func TestServerShellPrompt(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "p1", Path: "p1"},
+		project.Project{Name: "p2", Path: "p2"},
+	)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cachedFlag, showNameFlag = false, false }()
+	showNameFlag = true
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	p1 := filepath.Join(fake.X.Root, "p1")
+	if err := os.Chdir(p1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a server, the states are computed as usual.
+	want := shellPromptOutput(t, fake.X, false)
+	if got := shellPromptOutput(t, fake.X, true); got != want {
+		t.Errorf("got %q without a server, want %q", got, want)
+	}
+
+	listener, err := net.Listen("unix", fake.X.ServerSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go http.Serve(listener, newStateServer(fake.X.Clone(tool.ContextOpts{}), time.Hour))
+	if got := shellPromptOutput(t, fake.X, true); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Changes made by git are not seen until the cached states expire...
+	if err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(p1)).CreateAndCheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if got := shellPromptOutput(t, fake.X, true); got != want {
+		t.Errorf("got %q before invalidation, want %q", got, want)
+	}
+	// ...or the local projects are updated.
+	if err := ioutil.WriteFile(fake.X.GenerationFile(), []byte("updated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := shellPromptOutput(t, fake.X, false); got == want {
+		t.Fatalf("shell prompt %q did not change after switching branches", got)
+	} else {
+		want = got
+	}
+	if got := shellPromptOutput(t, fake.X, true); got != want {
+		t.Errorf("got %q after invalidation, want %q", got, want)
+	}
+}
+
+func TestServerProject(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "p1", Path: "p1"},
+		project.Project{Name: "nested", Path: "p1/nested"},
+	)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", fake.X.ServerSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go http.Serve(listener, newStateServer(fake.X.Clone(tool.ContextOpts{}), time.Hour))
+
+	tests := []struct {
+		path, name string
+	}{
+		{filepath.Join(fake.X.Root, "p1"), "p1"},
+		{filepath.Join(fake.X.Root, "p1", "dir"), "p1"},
+		{filepath.Join(fake.X.Root, "p1", "nested", "dir"), "nested"},
+	}
+	for _, test := range tests {
+		var p project.Project
+		if err := queryServer(fake.X, "/project", map[string][]string{"path": {test.path}}, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Name != test.name {
+			t.Errorf("%s: got project %q, want %q", test.path, p.Name, test.name)
+		}
+	}
+	var p project.Project
+	if err := queryServer(fake.X, "/project", map[string][]string{"path": {filepath.Join(fake.X.Root, "p10")}}, &p); err == nil {
+		t.Errorf("expected an error for a path outside the projects")
+	}
+}
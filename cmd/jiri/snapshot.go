@@ -23,20 +23,63 @@ import (
 
 const (
 	defaultSnapshotDir = ".snapshot"
+	// snapshotPushAttempts is the number of times "jiri snapshot push"
+	// rebases the snapshots onto the snapshot remote and tries to push them,
+	// e.g. when racing with a push from another machine.
+	snapshotPushAttempts = 3
 )
 
 var (
-	pushRemoteFlag  bool
-	snapshotDirFlag string
-	snapshotGcFlag  bool
-	timeFormatFlag  string
+	currentStateFlag           bool
+	pushRemoteFlag             bool
+	requireCleanFlag           bool
+	snapshotBranchFlag         string
+	snapshotDetachFlag         bool
+	snapshotDirFlag            string
+	snapshotDissociateFlag     bool
+	snapshotForceFlag          bool
+	snapshotGcFlag             bool
+	snapshotKeepFlag           int
+	snapshotNoHooksFlag        bool
+	snapshotNoVerifyFlag       bool
+	snapshotPartialFlag        bool
+	snapshotReferenceDirFlag   string
+	snapshotRemoteFlag         string
+	snapshotSkipPostUpdateFlag bool
+	snapshotTagFlag            string
+	snapshotForceTagFlag       bool
+	snapshotPushTagsFlag       bool
+	timeFormatFlag             string
 )
 
 func init() {
 	cmdSnapshot.Flags.StringVar(&snapshotDirFlag, "dir", "", "Directory where snapshot are stored.  Defaults to $JIRI_ROOT/.snapshot.")
+	cmdSnapshotCheckout.Flags.StringVar(&snapshotBranchFlag, "branch", "", "With -detach, check out the snapshot revisions on a branch with the given name, e.g. snapshot/<label>, rather than with a detached HEAD.")
+	cmdSnapshotCheckout.Flags.BoolVar(&snapshotDetachFlag, "detach", false, "Check out the snapshot revisions with a detached HEAD, leaving the master branches untouched.")
+	cmdSnapshotCheckout.Flags.BoolVar(&snapshotForceFlag, "force", false, "With -detach, discard uncommitted changes in the projects rather than fail.")
 	cmdSnapshotCheckout.Flags.BoolVar(&snapshotGcFlag, "gc", false, "Garbage collect obsolete repositories.")
+	cmdSnapshotCheckout.Flags.BoolVar(&snapshotNoHooksFlag, "no-hooks", false, "Do not run the runhooks or install the githooks of projects, list them instead.")
+	cmdSnapshotCheckout.Flags.BoolVar(&snapshotSkipPostUpdateFlag, "skip-postupdate", false, "Do not run the post-update commands of the snapshot, list them instead.")
+	cmdSnapshotCheckout.Flags.BoolVar(&snapshotNoVerifyFlag, "no-verify", false, `Do not verify the signatures of the revisions of the projects whose "verify" attribute is "signature".  For emergencies only.`)
+	cmdSnapshotCheckout.Flags.BoolVar(&snapshotPartialFlag, "partial", false, "Check out the projects whose snapshot revisions can be obtained even if the revisions of others cannot, rather than check out nothing.  For emergencies only.")
+	cmdSnapshotCheckout.Flags.StringVar(&snapshotReferenceDirFlag, "reference-dir", "", `Directory of mirror repositories, as created by "jiri project mirror", to borrow objects from when cloning new projects.`)
+	cmdSnapshotCheckout.Flags.BoolVar(&snapshotDissociateFlag, "dissociate", false, "With -reference-dir, copy the borrowed objects into the new projects, so that they do not depend on the mirror repositories.")
+	cmdSnapshotLeave.Flags.BoolVar(&snapshotForceFlag, "force", false, "Discard uncommitted changes in the projects rather than fail.")
+	cmdSnapshotDelete.Flags.BoolVar(&snapshotForceFlag, "force", false, "Delete the snapshot that the label symlink points to, pointing the symlink at the newest remaining snapshot.")
+	cmdSnapshotPrune.Flags.IntVar(&snapshotKeepFlag, "keep", 0, "Number of the newest snapshots of the label to keep.")
+	for _, cmd := range []*cmdline.Command{cmdSnapshotDelete, cmdSnapshotPrune, cmdSnapshotRename} {
+		cmd.Flags.BoolVar(&pushRemoteFlag, "push-remote", false, "Commit and push the changes upstream.")
+	}
+	cmdSnapshotCreate.Flags.BoolVar(&currentStateFlag, "current-state", false, "Record the revision checked out in each project, rather than the revision of its master branch.")
 	cmdSnapshotCreate.Flags.BoolVar(&pushRemoteFlag, "push-remote", false, "Commit and push snapshot upstream.")
+	cmdSnapshotCreate.Flags.BoolVar(&requireCleanFlag, "require-clean", false, "Fail if any project is not on its master branch or has uncommitted changes.")
 	cmdSnapshotCreate.Flags.StringVar(&timeFormatFlag, "time-format", time.RFC3339, "Time format for snapshot file name.")
+	cmdSnapshotCreate.Flags.StringVar(&snapshotTagFlag, "tag", "", "Create an annotated tag with the given name at the recorded revision of each project, and record the tag name in the snapshot.")
+	cmdSnapshotCreate.Flags.BoolVar(&snapshotForceTagFlag, "force-tag", false, "With -tag, move the tag in projects where it already points at another revision rather than fail.")
+	cmdSnapshotCreate.Flags.BoolVar(&snapshotPushTagsFlag, "push-tags", false, "With -tag, push the tag to the origin remote of each project.")
+	for _, cmd := range []*cmdline.Command{cmdSnapshotFetch, cmdSnapshotPush} {
+		cmd.Flags.StringVar(&snapshotRemoteFlag, "snapshot-remote", "", `The git repository that snapshots are shared through.  Can be configured with "jiri config set snapshot-remote <url>".`)
+	}
 }
 
 var cmdSnapshot = &cmdline.Command{
@@ -44,10 +87,10 @@ var cmdSnapshot = &cmdline.Command{
 	Short: "Manage project snapshots",
 	Long: `
 The "jiri snapshot" command can be used to manage project snapshots.
-In particular, it can be used to create new snapshots and to list
-existing snapshots.
+In particular, it can be used to create new snapshots, to list
+existing snapshots, and to delete, rename and prune them.
 `,
-	Children: []*cmdline.Command{cmdSnapshotCheckout, cmdSnapshotCreate, cmdSnapshotList},
+	Children: []*cmdline.Command{cmdSnapshotCheckout, cmdSnapshotCreate, cmdSnapshotDelete, cmdSnapshotFetch, cmdSnapshotLeave, cmdSnapshotList, cmdSnapshotPrune, cmdSnapshotPush, cmdSnapshotRename},
 }
 
 // cmdSnapshotCreate represents the "jiri snapshot create" command.
@@ -60,6 +103,21 @@ The "jiri snapshot create <label>" command captures the current project state
 in a manifest.  If the -push-remote flag is provided, the snapshot is committed
 and pushed upstream.
 
+By default, the snapshot records the revision of the master branch of each
+project.  Projects that are not on their master branch or have uncommitted
+changes are listed in a warning and in a comment in the snapshot.  If the
+-current-state flag is provided, the revision checked out in each project is
+recorded instead.  If the -require-clean flag is provided, the snapshot is not
+created if any project is not on its master branch or has uncommitted changes.
+
+If the -tag flag is provided, an annotated tag with the given name is created
+at the recorded revision of each project, and the tag name is recorded in the
+"tag" attribute of the snapshot.  Projects where the tag already points at the
+recorded revision are left alone.  If the tag points at another revision in
+any project, no tag is created and the command fails, unless the -force-tag
+flag is provided, in which case the tag is moved.  If the -push-tags flag is
+provided, the tag is then pushed to the origin remote of each project.
+
 Internally, snapshots are organized as follows:
 
  <snapshot-dir>/
@@ -90,6 +148,9 @@ func runSnapshotCreate(jirix *jiri.X, args []string) error {
 		return jirix.UsageErrorf("unexpected number of arguments")
 	}
 	label := args[0]
+	if snapshotTagFlag == "" && (snapshotForceTagFlag || snapshotPushTagsFlag) {
+		return jirix.UsageErrorf("-force-tag and -push-tags require -tag")
+	}
 	snapshotDir, err := getSnapshotDir(jirix)
 	if err != nil {
 		return err
@@ -152,20 +213,66 @@ func getSnapshotDir(jirix *jiri.X) (string, error) {
 
 func createSnapshot(jirix *jiri.X, snapshotDir, snapshotFile, label string) error {
 	// Create a snapshot that encodes the current state of master
-	// branches for all local projects.
-	if err := project.CreateSnapshot(jirix, snapshotFile, ""); err != nil {
+	// branches, or of the checked out revisions, for all local projects.
+	opts := []project.SnapshotOpt{
+		project.CurrentStateOpt(currentStateFlag),
+		project.RequireCleanOpt(requireCleanFlag),
+		project.SnapshotTagOpt(snapshotTagFlag),
+		project.WarnUncleanOpt(true),
+	}
+	if err := project.CreateSnapshot(jirix, snapshotFile, "", opts...); err != nil {
 		return err
 	}
+	if snapshotTagFlag != "" {
+		if err := project.TagSnapshotProjects(jirix, snapshotFile, snapshotForceTagFlag); err != nil {
+			return err
+		}
+		if snapshotPushTagsFlag {
+			if err := project.PushSnapshotTags(jirix, snapshotFile, snapshotForceTagFlag); err != nil {
+				return err
+			}
+		}
+	}
 
-	s := jirix.NewSeq()
 	// Update the symlink for this snapshot label to point to the
 	// latest snapshot.
-	symlink := filepath.Join(snapshotDir, label)
-	newSymlink := symlink + ".new"
 	relativeSnapshotPath := strings.TrimPrefix(snapshotFile, snapshotDir+string(os.PathSeparator))
-	return s.RemoveAll(newSymlink).
-		Symlink(relativeSnapshotPath, newSymlink).
-		Rename(newSymlink, symlink).Done()
+	return setLabelSymlink(jirix, snapshotDir, label, relativeSnapshotPath)
+}
+
+// setLabelSymlink points the symlink of the given label to the given snapshot
+// file, relative to the snapshot directory.  Where symlinks are not
+// supported, the label is a pointer file instead; see jiri.WritePointer.
+func setLabelSymlink(jirix *jiri.X, snapshotDir, label, relativeSnapshotPath string, opts ...jiri.PointerOpt) error {
+	return jirix.WritePointer(filepath.Join(snapshotDir, label), relativeSnapshotPath, opts...)
+}
+
+// latestSnapshot returns the path of the snapshot file that the symlink of the
+// given label points to, relative to the snapshot directory, or an empty
+// string if the label has no symlink.
+func latestSnapshot(snapshotDir, label string) (string, error) {
+	target, err := jiri.ResolvePointer(filepath.Join(snapshotDir, label))
+	if err == nil {
+		target, err = filepath.EvalSymlinks(target)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	dir, err := filepath.EvalSymlinks(snapshotDir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Dir(rel) != filepath.Join("labels", label) {
+		return "", fmt.Errorf("the symlink of label %q points to %q, which is not a snapshot of the label", label, target)
+	}
+	return rel, nil
 }
 
 // commitAndPushChanges commits changes identified by the given manifest file
@@ -202,6 +309,255 @@ func commitAndPushChanges(jirix *jiri.X, snapshotDir, snapshotFile, label string
 	return nil
 }
 
+// cmdSnapshotPush represents the "jiri snapshot push" command.
+var cmdSnapshotPush = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runSnapshotPush),
+	Name:   "push",
+	Short:  "Push the snapshots of a label to the snapshot remote",
+	Long: `
+The "jiri snapshot push <label>" command shares the snapshots of the given
+label through the git repository given by the -snapshot-remote flag, which is
+typically set once with "jiri config set snapshot-remote <url>".  The snapshots
+that the repository does not have yet are committed under labels/<label>/, and
+the latest snapshot of the label, which the local <label> symlink points to, is
+recorded in the file latest/<label>, since symlinks are not portable.
+
+The repository is cloned into $JIRI_ROOT/.jiri_root/snapshot-remote.  If
+another push to the repository wins a race with this one, the snapshots are
+rebased onto the new state of the repository and pushed again, up to a bounded
+number of attempts.
+`,
+	ArgsName: "<label>",
+	ArgsLong: "<label> is the snapshot label.",
+}
+
+func runSnapshotPush(jirix *jiri.X, args []string) error {
+	if len(args) != 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	label := args[0]
+	snapshotDir, err := getSnapshotDir(jirix)
+	if err != nil {
+		return err
+	}
+	latest, err := latestSnapshot(snapshotDir, label)
+	if err != nil {
+		return err
+	}
+	if latest == "" {
+		return fmt.Errorf("snapshot label %q not found", label)
+	}
+	for i := 1; ; i++ {
+		err := pushSnapshots(jirix, snapshotDir, label, latest)
+		if err == nil {
+			return nil
+		}
+		if i == snapshotPushAttempts {
+			return fmt.Errorf("pushing the snapshots of label %q failed %d times in a row: %v", label, i, err)
+		}
+		fmt.Fprintf(jirix.Stderr(), "WARNING: pushing the snapshots of label %q failed, rebasing and retrying: %v\n", label, err)
+	}
+}
+
+// pushSnapshots commits the snapshots of the given label, with the given
+// latest snapshot, on top of the current state of the snapshot remote, and
+// pushes them.
+func pushSnapshots(jirix *jiri.X, snapshotDir, label, latest string) error {
+	git, err := syncSnapshotRemote(jirix)
+	if err != nil {
+		return err
+	}
+	remoteDir := snapshotRemoteDir(jirix)
+	labelDir := filepath.Join("labels", label)
+	added, err := copySnapshots(jirix, filepath.Join(snapshotDir, labelDir), filepath.Join(remoteDir, labelDir))
+	if err != nil {
+		return err
+	}
+	latestFile := filepath.Join(remoteDir, "latest", label)
+	if err := jirix.NewSeq().
+		MkdirAll(filepath.Dir(latestFile), 0755).
+		WriteFile(latestFile, []byte(filepath.ToSlash(latest)+"\n"), 0644).Done(); err != nil {
+		return err
+	}
+	for _, file := range []string{labelDir, latestFile} {
+		if err := git.Add(file); err != nil {
+			return err
+		}
+	}
+	changed, err := git.HasUncommittedChanges()
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Fprintf(jirix.Stdout(), "the snapshots of label %q are up to date\n", label)
+		return nil
+	}
+	if err := git.CommitNoVerify(fmt.Sprintf("adding %d snapshots for label %q", added, label)); err != nil {
+		return err
+	}
+	if err := git.Push("origin", "HEAD:master", gitutil.VerifyOpt(false)); err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "pushed %d snapshots of label %q\n", added, label)
+	return nil
+}
+
+// cmdSnapshotFetch represents the "jiri snapshot fetch" command.
+var cmdSnapshotFetch = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runSnapshotFetch),
+	Name:   "fetch",
+	Short:  "Fetch snapshots from the snapshot remote",
+	Long: `
+The "jiri snapshot fetch [<label>]" command copies the snapshots of the given
+label, or of all labels, that were shared with "jiri snapshot push" into the
+local snapshot directory, and points the <label> symlinks at the latest shared
+snapshots.  A symlink that points at a snapshot that was not pushed yet is left
+unchanged.
+`,
+	ArgsName: "[<label>]",
+	ArgsLong: "<label> is the snapshot label.",
+}
+
+func runSnapshotFetch(jirix *jiri.X, args []string) error {
+	if len(args) > 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	snapshotDir, err := getSnapshotDir(jirix)
+	if err != nil {
+		return err
+	}
+	if _, err := syncSnapshotRemote(jirix); err != nil {
+		return err
+	}
+	remoteDir := snapshotRemoteDir(jirix)
+	labels := args
+	if len(labels) == 0 {
+		infos, err := ioutil.ReadDir(filepath.Join(remoteDir, "labels"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, info := range infos {
+			if info.IsDir() {
+				labels = append(labels, info.Name())
+			}
+		}
+	}
+	for _, label := range labels {
+		labelDir := filepath.Join("labels", label)
+		if _, err := jirix.NewSeq().Stat(filepath.Join(remoteDir, labelDir)); err != nil {
+			if runutil.IsNotExist(err) {
+				return fmt.Errorf("snapshot label %q not found in the snapshot remote", label)
+			}
+			return err
+		}
+		fetched, err := copySnapshots(jirix, filepath.Join(remoteDir, labelDir), filepath.Join(snapshotDir, labelDir))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(jirix.Stdout(), "fetched %d snapshots of label %q\n", fetched, label)
+		data, err := jirix.NewSeq().ReadFile(filepath.Join(remoteDir, "latest", label))
+		if err != nil {
+			if runutil.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		latest, err := latestSnapshot(snapshotDir, label)
+		if err != nil {
+			return err
+		}
+		if latest != "" {
+			if _, err := jirix.NewSeq().Stat(filepath.Join(remoteDir, latest)); runutil.IsNotExist(err) {
+				fmt.Fprintf(jirix.Stdout(), "NOTE: the latest snapshot of label %q has not been pushed, keeping it as the latest\n", label)
+				continue
+			}
+		}
+		if err := setLabelSymlink(jirix, snapshotDir, label, filepath.FromSlash(strings.TrimSpace(string(data)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotRemoteDir returns the directory of the local clone of the snapshot
+// remote.
+func snapshotRemoteDir(jirix *jiri.X) string {
+	return filepath.Join(jirix.RootMetaDir(), "snapshot-remote")
+}
+
+// syncSnapshotRemote clones the snapshot remote, or resets the existing clone
+// to the current state of the remote, discarding any unpushed changes.
+func syncSnapshotRemote(jirix *jiri.X) (*gitutil.Git, error) {
+	if snapshotRemoteFlag == "" {
+		return nil, jirix.UsageErrorf(`no snapshot remote; set the -snapshot-remote flag, or run "jiri config set snapshot-remote <url>"`)
+	}
+	dir := snapshotRemoteDir(jirix)
+	s := jirix.NewSeq()
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(dir))
+	if _, err := s.Stat(dir); err == nil {
+		if err := git.SetRemoteUrl("origin", snapshotRemoteFlag); err != nil {
+			return nil, err
+		}
+		if err := git.Fetch("origin"); err != nil {
+			return nil, err
+		}
+		if _, err := git.CurrentRevisionOfBranch("origin/master"); err == nil {
+			if err := git.Reset("origin/master"); err != nil {
+				return nil, err
+			}
+			if err := git.RemoveUntrackedFiles(); err != nil {
+				return nil, err
+			}
+			return git, nil
+		}
+		// A new snapshot remote has no master branch until the first push,
+		// so there is nothing to reset to; start over from a new clone.
+		if err := s.RemoveAll(dir).Done(); err != nil {
+			return nil, err
+		}
+	} else if !runutil.IsNotExist(err) {
+		return nil, err
+	}
+	if err := gitutil.New(s).Clone(snapshotRemoteFlag, dir); err != nil {
+		return nil, err
+	}
+	return git, nil
+}
+
+// copySnapshots copies the snapshot files of the from directory that the to
+// directory does not have into it, and returns the number of copied files.
+func copySnapshots(jirix *jiri.X, from, to string) (int, error) {
+	infos, err := ioutil.ReadDir(from)
+	if err != nil {
+		return 0, err
+	}
+	s := jirix.NewSeq()
+	if err := s.MkdirAll(to, 0755).Done(); err != nil {
+		return 0, err
+	}
+	copied := 0
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		dst := filepath.Join(to, info.Name())
+		if _, err := s.Stat(dst); err == nil {
+			continue
+		} else if !runutil.IsNotExist(err) {
+			return 0, err
+		}
+		data, err := s.ReadFile(filepath.Join(from, info.Name()))
+		if err != nil {
+			return 0, err
+		}
+		if err := s.WriteFile(dst, data, 0644).Done(); err != nil {
+			return 0, err
+		}
+		copied++
+	}
+	return copied, nil
+}
+
 // cmdSnapshotCheckout represents the "jiri snapshot checkout" command.
 var cmdSnapshotCheckout = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runSnapshotCheckout),
@@ -210,6 +566,26 @@ var cmdSnapshotCheckout = &cmdline.Command{
 	Long: `
 The "jiri snapshot checkout <snapshot>" command restores local project state to
 the state in the given snapshot manifest.
+
+By default, the master branch of each project is reset to the revision in the
+snapshot.  If the -detach flag is provided, the revision is checked out with a
+detached HEAD instead, or on the branch given by the -branch flag, and the
+master branches are left untouched.  Projects with uncommitted changes are
+listed and nothing is checked out, unless the -force flag is provided.  Run
+"jiri snapshot leave" to check the master branches back out.
+
+Before any project is changed, the snapshot revisions are fetched into the
+local projects as needed, and checked against the remotes of the projects that
+do not exist locally.  If some of them cannot be obtained, e.g. because they
+were garbage collected upstream, the projects are listed and nothing is
+checked out, unless the -partial flag is provided.
+
+The -reference-dir and -dissociate flags clone the projects that do not exist
+locally using mirror repositories, as for "jiri update".
+
+Unless -detach or -skip-postupdate is provided, the post-update commands
+recorded in the snapshot are run once the tools are installed, as for "jiri
+update".
 `,
 	ArgsName: "<snapshot>",
 	ArgsLong: "<snapshot> is the snapshot manifest file.",
@@ -219,7 +595,43 @@ func runSnapshotCheckout(jirix *jiri.X, args []string) error {
 	if len(args) != 1 {
 		return jirix.UsageErrorf("unexpected number of arguments")
 	}
-	return project.CheckoutSnapshot(jirix, args[0], snapshotGcFlag)
+	if !snapshotDetachFlag && (snapshotBranchFlag != "" || snapshotForceFlag) {
+		return jirix.UsageErrorf("-branch and -force require -detach")
+	}
+	if snapshotDetachFlag && snapshotGcFlag {
+		return jirix.UsageErrorf("-gc cannot be used with -detach")
+	}
+	return project.CheckoutSnapshot(jirix, args[0], snapshotGcFlag,
+		project.NoHooksOpt(snapshotNoHooksFlag),
+		project.NoVerifyOpt(snapshotNoVerifyFlag),
+		project.SkipPostUpdateOpt(snapshotSkipPostUpdateFlag),
+		project.ReferenceDirOpt(snapshotReferenceDirFlag),
+		project.DissociateOpt(snapshotDissociateFlag),
+		project.DetachOpt(snapshotDetachFlag),
+		project.DetachBranchOpt(snapshotBranchFlag),
+		project.ForceOpt(snapshotForceFlag),
+		project.PartialOpt(snapshotPartialFlag))
+}
+
+// cmdSnapshotLeave represents the "jiri snapshot leave" command.
+var cmdSnapshotLeave = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runSnapshotLeave),
+	Name:   "leave",
+	Short:  "Check the master branches back out after a detached checkout",
+	Long: `
+The "jiri snapshot leave" command checks the master branch back out in the
+projects that were checked out by "jiri snapshot checkout -detach", and have
+not been moved off the snapshot revision since.  Projects with uncommitted
+changes are listed and nothing is checked out, unless the -force flag is
+provided.  The next "jiri update" updates the projects as usual.
+`,
+}
+
+func runSnapshotLeave(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	return project.LeaveSnapshot(jirix, project.ForceOpt(snapshotForceFlag))
 }
 
 // cmdSnapshotList represents the "jiri snapshot list" command.
@@ -231,37 +643,56 @@ var cmdSnapshotList = &cmdline.Command{
 The "snapshot list" command lists existing snapshots of the labels
 specified as command-line arguments. If no arguments are provided, the
 command lists snapshots for all known labels.
+
+Once snapshots have been shared with "jiri snapshot push" or "jiri snapshot
+fetch", each snapshot is listed with its origin: "remote" for the snapshots in
+the snapshot remote, and "local" for those that were not pushed.
 `,
 	ArgsName: "<label ...>",
 	ArgsLong: "<label ...> is a list of snapshot labels.",
 }
 
+// snapshotLabels returns the labels of the snapshots in the given snapshot
+// directory.
+func snapshotLabels(snapshotDir string) ([]string, error) {
+	// Identify all known snapshot labels, using a heuristic that looks for
+	// all symbolic links <foo>, or pointer files <foo>.pointer, in the
+	// snapshot directory that point to a file in the "labels/<foo>"
+	// subdirectory of the snapshot directory.
+	fileInfoList, err := ioutil.ReadDir(snapshotDir)
+	if err != nil {
+		return nil, fmt.Errorf("ReadDir(%v) failed: %v", snapshotDir, err)
+	}
+	var labels []string
+	for _, fileInfo := range fileInfoList {
+		name := fileInfo.Name()
+		switch {
+		case fileInfo.Mode()&os.ModeSymlink != 0:
+		case fileInfo.Mode().IsRegular() && strings.HasSuffix(name, jiri.PointerFileSuffix):
+			name = strings.TrimSuffix(name, jiri.PointerFileSuffix)
+		default:
+			continue
+		}
+		path := filepath.Join(snapshotDir, name)
+		dst, err := jiri.ResolvePointer(path)
+		if err != nil {
+			return nil, fmt.Errorf("ResolvePointer(%v) failed: %v", path, err)
+		}
+		if strings.HasSuffix(filepath.Dir(dst), filepath.Join("labels", name)) {
+			labels = append(labels, name)
+		}
+	}
+	return labels, nil
+}
+
 func runSnapshotList(jirix *jiri.X, args []string) error {
 	snapshotDir, err := getSnapshotDir(jirix)
 	if err != nil {
 		return err
 	}
 	if len(args) == 0 {
-		// Identify all known snapshot labels, using a
-		// heuristic that looks for all symbolic links <foo>
-		// in the snapshot directory that point to a file in
-		// the "labels/<foo>" subdirectory of the snapshot
-		// directory.
-		fileInfoList, err := ioutil.ReadDir(snapshotDir)
-		if err != nil {
-			return fmt.Errorf("ReadDir(%v) failed: %v", snapshotDir, err)
-		}
-		for _, fileInfo := range fileInfoList {
-			if fileInfo.Mode()&os.ModeSymlink != 0 {
-				path := filepath.Join(snapshotDir, fileInfo.Name())
-				dst, err := filepath.EvalSymlinks(path)
-				if err != nil {
-					return fmt.Errorf("EvalSymlinks(%v) failed: %v", path, err)
-				}
-				if strings.HasSuffix(filepath.Dir(dst), filepath.Join("labels", fileInfo.Name())) {
-					args = append(args, fileInfo.Name())
-				}
-			}
+		if args, err = snapshotLabels(snapshotDir); err != nil {
+			return err
 		}
 	}
 
@@ -280,7 +711,11 @@ func runSnapshotList(jirix *jiri.X, args []string) error {
 		return fmt.Errorf("snapshot labels %v not found", notexist)
 	}
 
-	// Print snapshots for all labels.
+	// Print snapshots for all labels, with their origin if snapshots are
+	// shared through a snapshot remote.
+	remoteDir := snapshotRemoteDir(jirix)
+	_, err = jirix.NewSeq().Stat(remoteDir)
+	shared := err == nil
 	sort.Strings(args)
 	for _, label := range args {
 		// Scan the snapshot directory "labels/<label>" printing
@@ -291,9 +726,366 @@ func runSnapshotList(jirix *jiri.X, args []string) error {
 			return fmt.Errorf("ReadDir(%v) failed: %v", labelDir, err)
 		}
 		fmt.Fprintf(jirix.Stdout(), "snapshots of label %q:\n", label)
+		width := 0
+		for _, fileInfo := range fileInfoList {
+			if len(fileInfo.Name()) > width {
+				width = len(fileInfo.Name())
+			}
+		}
 		for _, fileInfo := range fileInfoList {
-			fmt.Fprintf(jirix.Stdout(), "  %v\n", fileInfo.Name())
+			if !shared {
+				fmt.Fprintf(jirix.Stdout(), "  %v\n", fileInfo.Name())
+				continue
+			}
+			origin := "local"
+			if _, err := jirix.NewSeq().Stat(filepath.Join(remoteDir, "labels", label, fileInfo.Name())); err == nil {
+				origin = "remote"
+			}
+			fmt.Fprintf(jirix.Stdout(), "  %-*v  %v\n", width, fileInfo.Name(), origin)
+		}
+	}
+	return nil
+}
+
+// removeLabelSymlink removes the symlink, or pointer file, of the given
+// label.
+func removeLabelSymlink(jirix *jiri.X, snapshotDir, label string) error {
+	path := filepath.Join(snapshotDir, label)
+	return jirix.NewSeq().RemoveAll(path).RemoveAll(path + jiri.PointerFileSuffix).Done()
+}
+
+// labelSnapshots returns the names of the snapshot files of the given label,
+// from the oldest to the newest by modification time.  The error satisfies
+// runutil.IsNotExist if the label does not exist.
+func labelSnapshots(jirix *jiri.X, snapshotDir, label string) ([]string, error) {
+	labelDir := filepath.Join(snapshotDir, "labels", label)
+	if _, err := jirix.NewSeq().Stat(labelDir); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(labelDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			files = append(files, info)
+		}
+	}
+	// ReadDir sorts the files by name, which breaks ties.
+	sort.SliceStable(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names, nil
+}
+
+// labelNotFound returns err, or if err reports that the given label does not
+// exist, an error that says so.
+func labelNotFound(err error, label string) error {
+	if runutil.IsNotExist(err) {
+		return fmt.Errorf("snapshot label %q not found", label)
+	}
+	return err
+}
+
+// labelPaths returns the paths of the files of the given label, relative to
+// the snapshot directory.
+func labelPaths(label string) []string {
+	return []string{filepath.Join("labels", label), label, label + jiri.PointerFileSuffix}
+}
+
+// changeSnapshots runs fn, which changes the snapshots in the snapshot
+// directory and returns a description of the change, or an empty string if
+// nothing changed.  If -push-remote is set, fn runs on the master branch of
+// the repository that contains the snapshot directory, updated from its
+// origin remote, and the changes to the given paths, relative to the snapshot
+// directory, are committed with the description as message and pushed.
+func changeSnapshots(jirix *jiri.X, snapshotDir string, paths []string, fn func() (string, error)) error {
+	if !pushRemoteFlag {
+		_, err := fn()
+		return err
+	}
+	changeFn := func() (e error) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		defer collect.Error(func() error { return jirix.NewSeq().Chdir(cwd).Done() }, &e)
+		if err := jirix.NewSeq().Chdir(snapshotDir).Done(); err != nil {
+			return err
+		}
+		git := gitutil.New(jirix.NewSeq())
+		if err := git.Pull("origin", "master"); err != nil {
+			return err
+		}
+		message, err := fn()
+		if err != nil || message == "" {
+			return err
+		}
+		// Only stage the paths that exist or were committed, since git
+		// rejects paths it does not know about.
+		tracked, err := git.TrackedFiles()
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			_, err := os.Lstat(path)
+			stage := err == nil
+			for _, file := range tracked {
+				stage = stage || file == filepath.ToSlash(path) || strings.HasPrefix(file, filepath.ToSlash(path)+"/")
+			}
+			if !stage {
+				continue
+			}
+			if err := git.Add(path); err != nil {
+				return err
+			}
+		}
+		changed, err := git.HasUncommittedChanges()
+		if err != nil || !changed {
+			return err
+		}
+		if err := git.CommitNoVerify(message); err != nil {
+			return err
+		}
+		return git.Push("origin", "master", gitutil.VerifyOpt(false))
+	}
+	p := project.Project{
+		Path:         snapshotDir,
+		Protocol:     "git",
+		RemoteBranch: "master",
+		Revision:     "HEAD",
+	}
+	return project.ApplyToLocalMaster(jirix, project.Projects{p.Key(): p}, changeFn)
+}
+
+// cmdSnapshotDelete represents the "jiri snapshot delete" command.
+var cmdSnapshotDelete = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runSnapshotDelete),
+	Name:   "delete",
+	Short:  "Delete a snapshot or a snapshot label",
+	Long: `
+The "jiri snapshot delete <label> [<snapshot-file>]" command deletes the given
+snapshot file of the label, as listed by "jiri snapshot list", or if no
+snapshot file is given, the label along with all its snapshots.
+
+The snapshot that the <label> symlink points to is only deleted if the -force
+flag is provided, in which case the symlink is pointed at the newest remaining
+snapshot of the label, or removed along with the label if no snapshot
+remains.  If the -push-remote flag is provided, the deletion is committed and
+pushed upstream, as for "jiri snapshot create".
+`,
+	ArgsName: "<label> [<snapshot-file>]",
+	ArgsLong: "<label> is the snapshot label, and <snapshot-file> the name of one of its snapshot files.",
+}
+
+func runSnapshotDelete(jirix *jiri.X, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	label := args[0]
+	snapshotDir, err := getSnapshotDir(jirix)
+	if err != nil {
+		return err
+	}
+	return changeSnapshots(jirix, snapshotDir, labelPaths(label), func() (string, error) {
+		snapshots, err := labelSnapshots(jirix, snapshotDir, label)
+		if err != nil {
+			return "", labelNotFound(err, label)
+		}
+		if len(args) == 1 {
+			if err := removeLabelSymlink(jirix, snapshotDir, label); err != nil {
+				return "", err
+			}
+			if err := jirix.NewSeq().RemoveAll(filepath.Join(snapshotDir, "labels", label)).Done(); err != nil {
+				return "", err
+			}
+			jirix.Audit("delete snapshot label", "deleted snapshot label %q with %d snapshots from %s", label, len(snapshots), snapshotDir)
+			fmt.Fprintf(jirix.Stdout(), "deleted label %q with %d snapshots\n", label, len(snapshots))
+			return fmt.Sprintf("deleting snapshot label %q", label), nil
+		}
+		name := args[1]
+		var remaining []string
+		for _, snapshot := range snapshots {
+			if snapshot != name {
+				remaining = append(remaining, snapshot)
+			}
+		}
+		if len(remaining) == len(snapshots) {
+			return "", fmt.Errorf("snapshot %q of label %q not found", name, label)
+		}
+		latest, err := latestSnapshot(snapshotDir, label)
+		if err != nil {
+			return "", err
+		}
+		relativeSnapshotPath := filepath.Join("labels", label, name)
+		if latest == relativeSnapshotPath && !snapshotForceFlag {
+			return "", fmt.Errorf("snapshot %q is the latest snapshot of label %q; use -force to delete it", name, label)
+		}
+		if err := removeSnapshots(jirix, snapshotDir, label, name); err != nil {
+			return "", err
+		}
+		if latest == relativeSnapshotPath {
+			if err := repointLabel(jirix, snapshotDir, label, remaining); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("deleting snapshot %q of label %q", name, label), nil
+	})
+}
+
+// removeSnapshots removes the given snapshot files of the given label.
+func removeSnapshots(jirix *jiri.X, snapshotDir, label string, names ...string) error {
+	for _, name := range names {
+		if err := jirix.NewSeq().Remove(filepath.Join(snapshotDir, "labels", label, name)).Done(); err != nil {
+			return err
+		}
+		jirix.Audit("delete snapshot", "deleted snapshot %q of label %q from %s", name, label, snapshotDir)
+		fmt.Fprintf(jirix.Stdout(), "deleted snapshot %q of label %q\n", name, label)
+	}
+	return nil
+}
+
+// repointLabel points the symlink of the given label at the newest of the
+// given remaining snapshots, or removes the label if there are none.
+func repointLabel(jirix *jiri.X, snapshotDir, label string, remaining []string) error {
+	if len(remaining) == 0 {
+		if err := removeLabelSymlink(jirix, snapshotDir, label); err != nil {
+			return err
+		}
+		if err := jirix.NewSeq().RemoveAll(filepath.Join(snapshotDir, "labels", label)).Done(); err != nil {
+			return err
 		}
+		fmt.Fprintf(jirix.Stdout(), "deleted label %q, which has no snapshots left\n", label)
+		return nil
 	}
+	newest := remaining[len(remaining)-1]
+	if err := setLabelSymlink(jirix, snapshotDir, label, filepath.Join("labels", label, newest)); err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "label %q now points at snapshot %q\n", label, newest)
 	return nil
 }
+
+// cmdSnapshotRename represents the "jiri snapshot rename" command.
+var cmdSnapshotRename = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runSnapshotRename),
+	Name:   "rename",
+	Short:  "Rename a snapshot label",
+	Long: `
+The "jiri snapshot rename <old> <new>" command renames the snapshot label <old>
+to <new>, moving its snapshots and pointing the <new> symlink at the snapshot
+that the <old> symlink pointed to.  The new label must not exist yet.  If the
+-push-remote flag is provided, the change is committed and pushed upstream, as
+for "jiri snapshot create".
+`,
+	ArgsName: "<old> <new>",
+	ArgsLong: "<old> is the snapshot label to rename, and <new> its new name.",
+}
+
+func runSnapshotRename(jirix *jiri.X, args []string) error {
+	if len(args) != 2 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	oldLabel, newLabel := args[0], args[1]
+	if newLabel == "" || strings.ContainsAny(newLabel, `/\`) || newLabel == "labels" {
+		return jirix.UsageErrorf("invalid snapshot label %q", newLabel)
+	}
+	snapshotDir, err := getSnapshotDir(jirix)
+	if err != nil {
+		return err
+	}
+	paths := append(labelPaths(oldLabel), labelPaths(newLabel)...)
+	return changeSnapshots(jirix, snapshotDir, paths, func() (string, error) {
+		s := jirix.NewSeq()
+		if _, err := labelSnapshots(jirix, snapshotDir, oldLabel); err != nil {
+			return "", labelNotFound(err, oldLabel)
+		}
+		for _, path := range labelPaths(newLabel) {
+			if _, err := os.Lstat(filepath.Join(snapshotDir, path)); err == nil {
+				return "", fmt.Errorf("snapshot label %q already exists", newLabel)
+			} else if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+		latest, err := latestSnapshot(snapshotDir, oldLabel)
+		if err != nil {
+			return "", err
+		}
+		if err := s.Rename(filepath.Join(snapshotDir, "labels", oldLabel), filepath.Join(snapshotDir, "labels", newLabel)).Done(); err != nil {
+			return "", err
+		}
+		if latest != "" {
+			if err := setLabelSymlink(jirix, snapshotDir, newLabel, filepath.Join("labels", newLabel, filepath.Base(latest))); err != nil {
+				return "", err
+			}
+		}
+		if err := removeLabelSymlink(jirix, snapshotDir, oldLabel); err != nil {
+			return "", err
+		}
+		jirix.Audit("rename snapshot label", "renamed snapshot label %q to %q in %s", oldLabel, newLabel, snapshotDir)
+		fmt.Fprintf(jirix.Stdout(), "renamed label %q to %q\n", oldLabel, newLabel)
+		return fmt.Sprintf("renaming snapshot label %q to %q", oldLabel, newLabel), nil
+	})
+}
+
+// cmdSnapshotPrune represents the "jiri snapshot prune" command.
+var cmdSnapshotPrune = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runSnapshotPrune),
+	Name:   "prune",
+	Short:  "Delete the oldest snapshots of a label",
+	Long: `
+The "jiri snapshot prune -keep=<n> <label>" command deletes the snapshots of
+the given label, except the <n> newest ones by modification time.  The
+snapshot that the <label> symlink points to is always kept.  If the
+-push-remote flag is provided, the deletions are committed and pushed
+upstream, as for "jiri snapshot create".
+`,
+	ArgsName: "<label>",
+	ArgsLong: "<label> is the snapshot label.",
+}
+
+func runSnapshotPrune(jirix *jiri.X, args []string) error {
+	if len(args) != 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	if snapshotKeepFlag < 1 {
+		return jirix.UsageErrorf("-keep must be at least 1")
+	}
+	label := args[0]
+	snapshotDir, err := getSnapshotDir(jirix)
+	if err != nil {
+		return err
+	}
+	return changeSnapshots(jirix, snapshotDir, labelPaths(label), func() (string, error) {
+		snapshots, err := labelSnapshots(jirix, snapshotDir, label)
+		if err != nil {
+			return "", labelNotFound(err, label)
+		}
+		latest, err := latestSnapshot(snapshotDir, label)
+		if err != nil {
+			return "", err
+		}
+		var pruned []string
+		for i, snapshot := range snapshots {
+			if i >= len(snapshots)-snapshotKeepFlag {
+				break
+			}
+			if filepath.Join("labels", label, snapshot) == latest {
+				fmt.Fprintf(jirix.Stdout(), "NOTE: keeping snapshot %q, which label %q points to\n", snapshot, label)
+				continue
+			}
+			pruned = append(pruned, snapshot)
+		}
+		if len(pruned) == 0 {
+			fmt.Fprintf(jirix.Stdout(), "no snapshots of label %q to prune\n", label)
+			return "", nil
+		}
+		if err := removeSnapshots(jirix, snapshotDir, label, pruned...); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("pruning %d snapshots of label %q", len(pruned), label), nil
+	})
+}
@@ -7,14 +7,18 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"v.io/jiri"
 	"v.io/jiri/gitutil"
 	"v.io/jiri/jiritest"
 	"v.io/jiri/project"
+	"v.io/jiri/runutil"
 	"v.io/jiri/tool"
 )
 
@@ -135,6 +139,57 @@ func TestList(t *testing.T) {
 	}
 }
 
+// TestListPointerFiles checks that labels whose symlinks are replaced by
+// pointer files, as on systems without symlinks, are listed and resolved.
+func TestListPointerFiles(t *testing.T) {
+	resetFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	snapshotDir := filepath.Join(fake.X.Root, defaultSnapshotDir)
+	labels := []label{
+		label{
+			name:      "beta",
+			snapshots: []string{"beta-1", "beta-2"},
+		},
+		label{
+			name:      "stable",
+			snapshots: []string{"stable-1", "stable-2"},
+		},
+	}
+	createLabelDir(t, fake.X, "", labels[0].name, labels[0].snapshots)
+	labelDir := filepath.Join(snapshotDir, "labels", "stable")
+	if err := fake.X.NewSeq().MkdirAll(labelDir, 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	for _, snapshot := range labels[1].snapshots {
+		if err := fake.X.NewSeq().WriteFile(filepath.Join(labelDir, snapshot), nil, 0644).Done(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setLabelSymlink(fake.X, snapshotDir, "stable", filepath.Join("labels", "stable", "stable-2"), jiri.PointerFileOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(snapshotDir, "stable")); !os.IsNotExist(err) {
+		t.Fatalf("the label is not a pointer file: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	if err := runSnapshotList(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), generateOutput(labels); got != want {
+		t.Errorf("unexpected output:\ngot\n%v\nwant\n%v\n", got, want)
+	}
+	latest, err := latestSnapshot(snapshotDir, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("labels", "stable", "stable-2"); latest != want {
+		t.Errorf("got latest snapshot %q, want %q", latest, want)
+	}
+}
+
 func checkReadme(t *testing.T, jirix *jiri.X, project, message string) {
 	s := jirix.NewSeq()
 	if _, err := s.Stat(project); err != nil {
@@ -180,6 +235,11 @@ func writeReadme(t *testing.T, jirix *jiri.X, projectDir, message string) {
 func resetFlags() {
 	snapshotDirFlag = ""
 	pushRemoteFlag = false
+	currentStateFlag = false
+	requireCleanFlag = false
+	snapshotRemoteFlag = ""
+	snapshotForceFlag = false
+	snapshotKeepFlag = 0
 }
 
 func TestGetSnapshotDir(t *testing.T) {
@@ -296,6 +356,111 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+// TestCreateUnclean checks that creating a snapshot while a project is not on
+// its master branch warns about the project, and records the revision that
+// the -current-state flag asks for.
+func TestCreateUnclean(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	name := remoteProjectName(0)
+	if err := fake.CreateRemoteProject(name); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := fake.AddProject(project.Project{
+		Name:   name,
+		Path:   localProjectName(0),
+		Remote: fake.Projects[name],
+	}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	writeReadme(t, fake.X, fake.Projects[name], "revision 1")
+	if err := project.UpdateUniverse(fake.X, true); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Commit to a feature branch in the local project.
+	localProject := filepath.Join(fake.X.Root, localProjectName(0))
+	git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(localProject))
+	masterRevision, err := git.CurrentRevision()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := git.CreateAndCheckoutBranch("feature"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	writeReadme(t, fake.X, localProject, "revision 2")
+	featureRevision, err := git.CurrentRevision()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+	snapshotFile := filepath.Join(fake.X.Root, defaultSnapshotDir, "test-local")
+	tests := []struct {
+		currentState bool
+		revision     string
+	}{
+		{false, masterRevision},
+		{true, featureRevision},
+	}
+	for _, test := range tests {
+		stderr.Reset()
+		currentStateFlag = test.currentState
+		if err := runSnapshotCreate(fake.X, []string{"test-local"}); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got, want := stderr.String(), name; !strings.Contains(got, want) {
+			t.Errorf("got warning %q, want it to contain %q", got, want)
+		}
+		manifest, err := project.ManifestFromFile(fake.X, snapshotFile)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !strings.Contains(manifest.Comment, `on branch "feature"`) {
+			t.Errorf("got comment %q, want it to mention the feature branch", manifest.Comment)
+		}
+		found := false
+		for _, p := range manifest.Projects {
+			if p.Name == name {
+				found = true
+				if got, want := p.Revision, test.revision; got != want {
+					t.Errorf("current state %v: got revision %v, want %v", test.currentState, got, want)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("project %v not found in snapshot", name)
+		}
+	}
+
+	// Check that the -require-clean flag turns the warning into an error.
+	currentStateFlag, requireCleanFlag = false, true
+	if err := runSnapshotCreate(fake.X, []string{"test-local"}); err == nil {
+		t.Errorf("expected snapshot creation to fail")
+	}
+
+	// Check that the update history snapshot neither warns about the project
+	// nor records it.
+	stderr.Reset()
+	if err := project.WriteUpdateHistorySnapshot(fake.X, ""); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := stderr.String(); strings.Contains(got, name) {
+		t.Errorf("got warning %q, want none", got)
+	}
+	manifest, err := project.ManifestFromFile(fake.X, fake.X.UpdateHistoryLatestLink())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if manifest.Comment != "" {
+		t.Errorf("got comment %q, want none", manifest.Comment)
+	}
+}
+
 // TestCreatePushRemote checks that creating a snapshot with the -push-remote
 // flag causes the snapshot to be committed and pushed upstream.
 func TestCreatePushRemote(t *testing.T) {
@@ -340,3 +505,293 @@ func TestCreatePushRemote(t *testing.T) {
 		t.Errorf("expected file %v to be committed but it was not", labelFile)
 	}
 }
+
+// TestPushFetch checks that "jiri snapshot push" shares the snapshots of a
+// label through the snapshot remote, retrying rejected pushes, and that "jiri
+// snapshot fetch" recreates them, along with the label symlink, in another
+// snapshot directory.
+func TestPushFetch(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+
+	// Create a snapshot remote that rejects the first push, as if another
+	// push had won a race with it.
+	remote := filepath.Join(fake.X.Root, "snapshots.git")
+	marker := filepath.Join(fake.X.Root, "rejected")
+	hook := "#!/bin/sh\nif [ ! -f " + marker + " ]; then touch " + marker + "; exit 1; fi\n"
+	s := fake.X.NewSeq()
+	if err := s.Last("git", "init", "--bare", remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteFile(filepath.Join(remote, "hooks", "pre-receive"), []byte(hook), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	snapshotRemoteFlag = remote
+
+	// Push the snapshots of a label, whose symlink points at the first one.
+	dirA := filepath.Join(fake.X.Root, "snapshots-a")
+	createLabelDir(t, fake.X, dirA, "stable", []string{"s2", "s1"})
+	snapshotDirFlag = dirA
+	if err := runSnapshotPush(fake.X, []string{"stable"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stderr.String(), "rebasing and retrying"; !strings.Contains(got, want) {
+		t.Errorf("got stderr %q, want it to contain %q", got, want)
+	}
+	if got, want := stdout.String(), `pushed 2 snapshots of label "stable"`; !strings.Contains(got, want) {
+		t.Errorf("got stdout %q, want it to contain %q", got, want)
+	}
+
+	// Fetch the snapshots into another snapshot directory.
+	dirB := filepath.Join(fake.X.Root, "snapshots-b")
+	snapshotDirFlag = dirB
+	if err := runSnapshotFetch(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"s1", "s2"} {
+		if err := s.AssertFileExists(filepath.Join(dirB, "labels", "stable", name)).Done(); err != nil {
+			t.Error(err)
+		}
+	}
+	latest, err := latestSnapshot(dirB, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("labels", "stable", "s2"); latest != want {
+		t.Errorf("got latest snapshot %q, want %q", latest, want)
+	}
+
+	// The snapshots are listed with their origin.
+	if err := s.WriteFile(filepath.Join(dirB, "labels", "stable", "s3"), nil, 0644).Done(); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := runSnapshotList(fake.X, []string{"stable"}); err != nil {
+		t.Fatal(err)
+	}
+	want := `snapshots of label "stable":
+  s1  remote
+  s2  remote
+  s3  local
+`
+	if got := stdout.String(); got != want {
+		t.Errorf("got listing\n%s\nwant\n%s", got, want)
+	}
+}
+
+// createAgedSnapshots creates the given snapshots of the given label, from the
+// oldest to the newest, and points the label symlink at the newest one.
+func createAgedSnapshots(t *testing.T, jirix *jiri.X, snapshotDir, label string, snapshots ...string) {
+	labelDir := filepath.Join(snapshotDir, "labels", label)
+	if err := jirix.NewSeq().MkdirAll(labelDir, 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	for i, snapshot := range snapshots {
+		path := filepath.Join(labelDir, snapshot)
+		if err := jirix.NewSeq().WriteFile(path, []byte(snapshot), 0644).Done(); err != nil {
+			t.Fatal(err)
+		}
+		mtime := start.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setLabelSymlink(jirix, snapshotDir, label, filepath.Join("labels", label, snapshots[len(snapshots)-1])); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkLabel checks that the given label has the given snapshots, and that
+// its symlink points at the given latest snapshot.  If there are no
+// snapshots, it checks that the label does not exist.
+func checkLabel(t *testing.T, jirix *jiri.X, snapshotDir, label, latest string, snapshots ...string) {
+	got, err := labelSnapshots(jirix, snapshotDir, label)
+	if len(snapshots) == 0 {
+		if !runutil.IsNotExist(err) {
+			t.Errorf("label %q: got snapshots %v, error %v, want it not to exist", label, got, err)
+		}
+		if _, err := jiri.ReadPointer(filepath.Join(snapshotDir, label)); !os.IsNotExist(err) {
+			t.Errorf("label %q: the symlink of the label was not removed: %v", label, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != strings.Join(snapshots, ",") {
+		t.Errorf("label %q: got snapshots %v, want %v", label, got, snapshots)
+	}
+	gotLatest, err := latestSnapshot(snapshotDir, label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("labels", label, latest); gotLatest != want {
+		t.Errorf("label %q: got latest snapshot %q, want %q", label, gotLatest, want)
+	}
+}
+
+// TestDelete checks that "jiri snapshot delete" deletes snapshots and labels,
+// refuses to delete the latest snapshot of a label without -force, and keeps
+// the label symlink pointing at an existing snapshot.
+func TestDelete(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: ioutil.Discard})
+	snapshotDir := filepath.Join(fake.X.Root, defaultSnapshotDir)
+	createAgedSnapshots(t, fake.X, snapshotDir, "stable", "s1", "s2", "s3")
+	createAgedSnapshots(t, fake.X, snapshotDir, "beta", "b1")
+
+	if err := runSnapshotDelete(fake.X, []string{"stable", "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	checkLabel(t, fake.X, snapshotDir, "stable", "s3", "s2", "s3")
+
+	err := runSnapshotDelete(fake.X, []string{"stable", "s3"})
+	if want := "use -force to delete it"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+	checkLabel(t, fake.X, snapshotDir, "stable", "s3", "s2", "s3")
+	if err := runSnapshotDelete(fake.X, []string{"stable", "s4"}); err == nil {
+		t.Errorf("deleting a missing snapshot did not fail")
+	}
+
+	// With -force, the symlink is pointed at the newest remaining
+	// snapshot, and the label is deleted along with its last snapshot.
+	snapshotForceFlag = true
+	if err := runSnapshotDelete(fake.X, []string{"stable", "s3"}); err != nil {
+		t.Fatal(err)
+	}
+	checkLabel(t, fake.X, snapshotDir, "stable", "s2", "s2")
+	if err := runSnapshotDelete(fake.X, []string{"stable", "s2"}); err != nil {
+		t.Fatal(err)
+	}
+	checkLabel(t, fake.X, snapshotDir, "stable", "")
+
+	// Deleting a label deletes all its snapshots.
+	snapshotForceFlag = false
+	if err := runSnapshotDelete(fake.X, []string{"beta"}); err != nil {
+		t.Fatal(err)
+	}
+	checkLabel(t, fake.X, snapshotDir, "beta", "")
+	err = runSnapshotDelete(fake.X, []string{"beta"})
+	if want := `snapshot label "beta" not found`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+}
+
+// TestRename checks that "jiri snapshot rename" moves the snapshots of a
+// label and its symlink, and refuses to overwrite an existing label.
+func TestRename(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: ioutil.Discard})
+	snapshotDir := filepath.Join(fake.X.Root, defaultSnapshotDir)
+	createAgedSnapshots(t, fake.X, snapshotDir, "old", "s1", "s2")
+	createAgedSnapshots(t, fake.X, snapshotDir, "other", "o1")
+
+	if err := runSnapshotRename(fake.X, []string{"old", "new"}); err != nil {
+		t.Fatal(err)
+	}
+	checkLabel(t, fake.X, snapshotDir, "old", "")
+	checkLabel(t, fake.X, snapshotDir, "new", "s2", "s1", "s2")
+
+	err := runSnapshotRename(fake.X, []string{"new", "other"})
+	if want := `snapshot label "other" already exists`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+	checkLabel(t, fake.X, snapshotDir, "new", "s2", "s1", "s2")
+}
+
+// TestPrune checks that "jiri snapshot prune" keeps the newest snapshots of a
+// label, along with the snapshot its symlink points to.
+func TestPrune(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: ioutil.Discard})
+	snapshotDir := filepath.Join(fake.X.Root, defaultSnapshotDir)
+	createAgedSnapshots(t, fake.X, snapshotDir, "stable", "s1", "s2", "s3", "s4", "s5")
+	if err := setLabelSymlink(fake.X, snapshotDir, "stable", filepath.Join("labels", "stable", "s1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runSnapshotPrune(fake.X, []string{"stable"}); err == nil {
+		t.Errorf("pruning without -keep did not fail")
+	}
+	snapshotKeepFlag = 2
+	if err := runSnapshotPrune(fake.X, []string{"stable"}); err != nil {
+		t.Fatal(err)
+	}
+	checkLabel(t, fake.X, snapshotDir, "stable", "s1", "s1", "s4", "s5")
+}
+
+// TestDeletePushRemote checks that with -push-remote, the snapshots deleted
+// from a snapshot directory in a git repository are committed and pushed.
+func TestDeletePushRemote(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: ioutil.Discard})
+	fake.EnableRemoteManifestPush()
+	defer fake.DisableRemoteManifestPush()
+
+	manifestDir := filepath.Join(fake.X.Root, "manifest")
+	snapshotDir := filepath.Join(manifestDir, "snapshot")
+	snapshotDirFlag = snapshotDir
+	pushRemoteFlag = true
+	for i := 0; i < 3; i++ {
+		if err := runSnapshotCreate(fake.X, []string{"stable"}); err != nil {
+			t.Fatal(err)
+		}
+		// Snapshot files are named after the time they are created at.
+		time.Sleep(time.Second)
+	}
+	git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(manifestDir))
+	commitCount, err := git.CountCommits("master", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := labelSnapshots(fake.X, snapshotDir, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotKeepFlag = 1
+	if err := runSnapshotPrune(fake.X, []string{"stable"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSnapshotDelete(fake.X, []string{"stable"}); err != nil {
+		t.Fatal(err)
+	}
+	newCommitCount, err := git.CountCommits("master", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := newCommitCount, commitCount+2; got != want {
+		t.Errorf("got %v commits, want %v", got, want)
+	}
+	uncommitted, err := git.HasUncommittedChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uncommitted {
+		t.Errorf("the deletions were not all committed")
+	}
+	for _, snapshot := range snapshots {
+		path := filepath.Join(snapshotDir, "labels", "stable", snapshot)
+		if git.IsFileCommitted(path) {
+			t.Errorf("snapshot %v is still committed", path)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/project"
+	"v.io/jiri/runutil"
+	"v.io/x/lib/cmdline"
+)
+
+// statusListThreshold is the largest number of dirty or non-master projects
+// whose names are listed by "jiri status" without -v.
+const statusListThreshold = 10
+
+var statusJSONFlag bool
+
+func init() {
+	cmdStatus.Flags.BoolVar(&statusJSONFlag, "json", false, "Output the status as a JSON object.")
+}
+
+// cmdStatus represents the "jiri status" command.
+var cmdStatus = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runStatus),
+	Name:   "status",
+	Short:  "Summarize the state of the jiri root",
+	Long: `
+Summarize the state of the jiri root: the root directory in use, the manifest
+that "jiri update" tracks, the time of the last successful update, the number
+of local projects along with those that have uncommitted changes or are not on
+their master branch, and the remote imports of the manifest that have not been
+fetched by an update yet.
+
+The names of the dirty and non-master projects are listed if there are at most
+10 of them, or if the -v flag is set.  The mode is "manifest" if the last
+update synced the local projects to the manifest, or "snapshot" if it checked
+out a snapshot with "jiri snapshot checkout".
+`,
+}
+
+// statusInfo is the JSON representation of the output of "jiri status".
+type statusInfo struct {
+	Root           string     `json:"root"`
+	Manifest       string     `json:"manifest"`
+	Mode           string     `json:"mode"`
+	Snapshot       string     `json:"snapshot,omitempty"`
+	LastUpdate     *time.Time `json:"lastUpdate,omitempty"`
+	Projects       int        `json:"projects"`
+	Dirty          []string   `json:"dirty"`
+	NonMaster      []string   `json:"nonMaster"`
+	PendingImports []string   `json:"pendingImports"`
+}
+
+func runStatus(jirix *jiri.X, args []string) error {
+	if len(args) > 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	info := statusInfo{
+		Root:           jirix.Root,
+		Manifest:       jirix.JiriManifestFile(),
+		Mode:           "manifest",
+		Dirty:          []string{},
+		NonMaster:      []string{},
+		PendingImports: []string{},
+	}
+	update, err := project.LatestUpdate(jirix)
+	if err != nil {
+		return err
+	}
+	if update != nil {
+		info.LastUpdate = &update.Time
+		if update.SnapshotPath != "" {
+			info.Mode, info.Snapshot = "snapshot", update.SnapshotPath
+		}
+	}
+	states, err := project.GetProjectStates(jirix, true)
+	if err != nil {
+		return err
+	}
+	info.Projects = len(states)
+	for _, state := range states {
+		if state.HasUncommitted || state.HasUntracked {
+			info.Dirty = append(info.Dirty, state.Project.Name)
+		}
+		if state.CurrentBranch != "master" {
+			info.NonMaster = append(info.NonMaster, fmt.Sprintf("%s (%s)", state.Project.Name, state.CurrentBranch))
+		}
+	}
+	sort.Strings(info.Dirty)
+	sort.Strings(info.NonMaster)
+	if info.PendingImports, err = pendingImports(jirix, update); err != nil {
+		return err
+	}
+
+	if statusJSONFlag {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return fmt.Errorf("json.MarshalIndent() failed: %v", err)
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s\n", data)
+		return nil
+	}
+	w := jirix.Stdout()
+	fmt.Fprintf(w, "Root: %s\n", info.Root)
+	fmt.Fprintf(w, "Manifest: %s\n", info.Manifest)
+	if info.Mode == "snapshot" {
+		fmt.Fprintf(w, "Mode: snapshot %s\n", info.Snapshot)
+	} else {
+		fmt.Fprintf(w, "Mode: %s\n", info.Mode)
+	}
+	if info.LastUpdate != nil {
+		fmt.Fprintf(w, "Last update: %s (%v ago)\n", info.LastUpdate.Format(time.RFC3339), time.Since(*info.LastUpdate).Round(time.Second))
+	} else {
+		fmt.Fprintf(w, "Last update: never\n")
+	}
+	fmt.Fprintf(w, "Projects: %d (%d dirty, %d not on master)\n", info.Projects, len(info.Dirty), len(info.NonMaster))
+	printStatusList(jirix, "dirty", info.Dirty)
+	printStatusList(jirix, "not on master", info.NonMaster)
+	if len(info.PendingImports) > 0 {
+		fmt.Fprintf(w, "Pending imports (run \"jiri update\" to fetch them):\n")
+		for _, name := range info.PendingImports {
+			fmt.Fprintf(w, "  %s\n", name)
+		}
+	}
+	return nil
+}
+
+// printStatusList prints the given names, unless there are too many of them
+// and verbose output was not requested.
+func printStatusList(jirix *jiri.X, label string, names []string) {
+	if len(names) == 0 || (len(names) > statusListThreshold && !jirix.Verbose()) {
+		return
+	}
+	fmt.Fprintf(jirix.Stdout(), "  %s: %s\n", label, strings.Join(names, ", "))
+}
+
+// pendingImports returns the names of the remote imports of the manifest
+// whose manifest projects are not recorded by the given update, i.e. the
+// imports that were added to the manifest since the update.
+func pendingImports(jirix *jiri.X, update *project.UpdateRecord) ([]string, error) {
+	names := []string{}
+	manifest, err := project.ManifestFromFile(jirix, jirix.JiriManifestFile())
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return names, nil
+		}
+		return nil, err
+	}
+	for _, imp := range manifest.Imports {
+		if update != nil {
+			if _, ok := update.Projects[imp.ProjectKey()]; ok {
+				continue
+			}
+		}
+		names = append(names, fmt.Sprintf("%s (%s)", imp.Name, imp.Remote))
+	}
+	sort.Strings(names)
+	return names, nil
+}
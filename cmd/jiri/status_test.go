@@ -0,0 +1,135 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"v.io/jiri/gitutil"
+	"v.io/jiri/jiritest"
+	"v.io/jiri/project"
+	"v.io/jiri/tool"
+)
+
+func TestStatus(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "p1", Path: "p1"},
+		project.Project{Name: "p2", Path: "p2"},
+		project.Project{Name: "p3", Path: "p3"},
+	)
+	defer cleanup()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+
+	// Before the first update, nothing has been fetched.
+	if err := runStatus(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "Last update: never\n"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want it to contain %q", got, want)
+	}
+
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.WriteUpdateHistorySnapshot(fake.X, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make p1 dirty, check out a branch in p2, and add an import to the
+	// manifest.
+	p1, p2 := filepath.Join(fake.X.Root, "p1"), filepath.Join(fake.X.Root, "p2")
+	if err := ioutil.WriteFile(filepath.Join(p1, "untracked"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(p2)).CreateAndCheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := fake.ReadJiriManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports := manifest.Imports
+	manifest.Imports = append(imports, project.Import{Name: "extra", Manifest: "extra", Remote: "https://example.com/extra"})
+	if err := fake.WriteJiriManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	if err := runStatus(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Root: " + fake.X.Root + "\n",
+		"Mode: manifest\n",
+		"Projects: 4 (1 dirty, 1 not on master)\n",
+		"  dirty: p1\n",
+		"  not on master: p2 (feature)\n",
+		"Pending imports (run \"jiri update\" to fetch them):\n  extra (https://example.com/extra)\n",
+	} {
+		if got := stdout.String(); !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+
+	statusJSONFlag = true
+	defer func() { statusJSONFlag = false }()
+	stdout.Reset()
+	if err := runStatus(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	var info statusInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		t.Fatalf("json.Unmarshal(%q) failed: %v", stdout.String(), err)
+	}
+	if info.LastUpdate == nil {
+		t.Errorf("got no last update time")
+	}
+	info.LastUpdate = nil
+	want := statusInfo{
+		Root:           fake.X.Root,
+		Manifest:       fake.X.JiriManifestFile(),
+		Mode:           "manifest",
+		Projects:       4,
+		Dirty:          []string{"p1"},
+		NonMaster:      []string{"p2 (feature)"},
+		PendingImports: []string{"extra (https://example.com/extra)"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %#v, want %#v", info, want)
+	}
+
+	// Checking out a snapshot changes the mode.
+	manifest.Imports = imports
+	if err := fake.WriteJiriManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(fake.X.Root, "snapshot")
+	if err := project.CreateSnapshot(fake.X, snapshot, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.CheckoutSnapshot(fake.X, snapshot, false); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := runStatus(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		t.Fatalf("json.Unmarshal(%q) failed: %v", stdout.String(), err)
+	}
+	if got, want := info.Mode, "snapshot"; got != want {
+		t.Errorf("got mode %q, want %q", got, want)
+	}
+	if got, want := info.Snapshot, "snapshot"; got != want {
+		t.Errorf("got snapshot %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/gotest"
+	"v.io/jiri/profiles"
+	"v.io/jiri/profiles/profilescmdline"
+	"v.io/jiri/profiles/profilesreader"
+	"v.io/x/lib/cmdline"
+)
+
+// cmdTest represents the "jiri test" command.
+var cmdTest = &cmdline.Command{
+	Name:     "test",
+	Short:    "Run the tests of Go packages",
+	Long:     "Run the tests of Go packages in the environment of profiles.",
+	Children: []*cmdline.Command{cmdTestRun},
+}
+
+// cmdTestRun represents the "jiri test run" command.
+var cmdTestRun = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runTestRun),
+	Name:   "run",
+	Short:  "Run the tests of Go packages",
+	Long: `
+Run the tests of the given Go packages with "go test", in the environment of
+the profiles given by -profiles, if any, testing several packages in
+parallel.  The status of each package is printed, followed by the output of
+the packages that failed, and the command fails if any package failed.
+
+Packages, or some of their tests, can be excluded with the config file, which
+defaults to $JIRI_ROOT/.jiri_root/test_config.json.  It is a JSON file of the
+form:
+
+  {
+    "exclusions": [
+      {"package": "v.io/jiri/project", "test": "TestHooks.*", "os": "windows", "reason": "..."},
+      {"package": "v.io/jiri/profiles/.*", "reason": "..."}
+    ]
+  }
+
+The "package" and "test" regular expressions match the import paths of the
+packages and the names of their tests as a whole.  An exclusion without a
+"test" excludes the packages entirely, and one with an "os", a comma-separated
+list of GOOS values, only applies on those operating systems.  Excluding tests
+requires the -skip flag of "go test", added in Go 1.20.
+`,
+	ArgsName: "<packages>",
+	ArgsLong: `<packages> is a list of the packages to test, as accepted by "go list", e.g. "v.io/jiri/...".`,
+}
+
+type testRunFlagValues struct {
+	profilescmdline.ReaderFlagValues
+	jobs        int
+	timeout     time.Duration
+	xunitDir    string
+	coverageDir string
+	configFile  string
+}
+
+var testRunFlags testRunFlagValues
+
+func init() {
+	profilescmdline.RegisterReaderFlags(&cmdTestRun.Flags, &testRunFlags.ReaderFlagValues, "", jiri.ProfilesDBDir)
+	cmdTestRun.Flags.IntVar(&testRunFlags.jobs, "j", 0, "Number of packages to test concurrently.  Defaults to the number of CPUs.")
+	cmdTestRun.Flags.DurationVar(&testRunFlags.timeout, "timeout", 10*time.Minute, "Timeout of the tests of each package.")
+	cmdTestRun.Flags.StringVar(&testRunFlags.xunitDir, "xunit-dir", "", "Directory to write an xUnit report of each package to.")
+	cmdTestRun.Flags.StringVar(&testRunFlags.coverageDir, "coverage-dir", "", "Directory to write a coverage profile of each package to.")
+	cmdTestRun.Flags.StringVar(&testRunFlags.configFile, "config", "", "Config file listing the packages and tests to exclude.  Defaults to $JIRI_ROOT/.jiri_root/test_config.json.")
+}
+
+func runTestRun(jirix *jiri.X, args []string) error {
+	if len(args) == 0 {
+		return jirix.UsageErrorf("no packages given")
+	}
+	configFile := testRunFlags.configFile
+	if configFile == "" {
+		configFile = jirix.TestConfigFile()
+	}
+	config, err := gotest.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	env, err := testEnv(jirix)
+	if err != nil {
+		return err
+	}
+	results, err := gotest.Run(jirix, args, gotest.Opts{
+		Env:         env,
+		Jobs:        testRunFlags.jobs,
+		Timeout:     testRunFlags.timeout,
+		Exclusions:  config.Exclusions,
+		CoverageDir: testRunFlags.coverageDir,
+	})
+	if err != nil {
+		return err
+	}
+	if testRunFlags.xunitDir != "" {
+		if err := gotest.WriteXUnitReports(jirix, testRunFlags.xunitDir, results); err != nil {
+			return err
+		}
+	}
+	return reportTestResults(jirix, results)
+}
+
+// testEnv returns the environment of the profiles given by -profiles, if any.
+func testEnv(jirix *jiri.X) (map[string]string, error) {
+	if testRunFlags.Profiles == "" {
+		return nil, nil
+	}
+	if err := profiles.ResolveTarget(jirix, &testRunFlags.Target); err != nil {
+		return nil, err
+	}
+	rd, err := profilesreader.NewReader(jirix, testRunFlags.ProfilesMode, testRunFlags.DBFilename)
+	if err != nil {
+		return nil, err
+	}
+	profileNames := strings.Split(testRunFlags.Profiles, ",")
+	if err := rd.ValidateRequestedProfilesAndTarget(profileNames, testRunFlags.Target); err != nil {
+		return nil, err
+	}
+	rd.MergeEnvFromProfiles(testRunFlags.MergePolicies, testRunFlags.Target, profileNames...)
+	return rd.ToMap(), nil
+}
+
+// reportTestResults prints the status of each of the given results, followed
+// by the output of those that failed, and returns an error if any failed.
+func reportTestResults(jirix *jiri.X, results []gotest.PackageResult) error {
+	var failed []gotest.PackageResult
+	for _, result := range results {
+		switch result.Status {
+		case gotest.StatusPass:
+			fmt.Fprintf(jirix.Stdout(), "ok      %s\t%.3fs\n", result.Package, result.Duration.Seconds())
+		case gotest.StatusFail:
+			fmt.Fprintf(jirix.Stdout(), "FAIL    %s\t%.3fs\n", result.Package, result.Duration.Seconds())
+			failed = append(failed, result)
+		case gotest.StatusSkip:
+			fmt.Fprintf(jirix.Stdout(), "?       %s\t[no test files]\n", result.Package)
+		case gotest.StatusExcluded:
+			if result.Reason != "" {
+				fmt.Fprintf(jirix.Stdout(), "-       %s\t[excluded: %s]\n", result.Package, result.Reason)
+			} else {
+				fmt.Fprintf(jirix.Stdout(), "-       %s\t[excluded]\n", result.Package)
+			}
+		}
+	}
+	for _, result := range failed {
+		fmt.Fprintf(jirix.Stdout(), "\n>> %s\n", result.Package)
+		for _, test := range result.Tests {
+			if test.Status == gotest.StatusFail {
+				fmt.Fprint(jirix.Stdout(), test.Output)
+			}
+		}
+		fmt.Fprint(jirix.Stdout(), result.Output)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("tests failed in %d of %d packages", len(failed), len(results))
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+	"v.io/jiri/project"
+	"v.io/x/lib/cmdline"
+	"v.io/x/lib/metadata"
+)
+
+// toolMetadataTimeout bounds the time a tool binary may take to print its
+// metadata, so that binaries that do not support -metadata cannot block the
+// listing.
+var toolMetadataTimeout = 10 * time.Second
+
+// The statuses of the tools listed by "jiri tools list".
+const (
+	toolUpToDate = "up-to-date"
+	toolStale    = "stale"
+	toolUnknown  = "unknown"
+)
+
+// cmdTools represents the "jiri tools" command.
+var cmdTools = &cmdline.Command{
+	Name:     "tools",
+	Short:    "Inspect the installed jiri tools",
+	Long:     "Inspect the jiri tools installed in $JIRI_ROOT/.jiri_root/bin.",
+	Children: []*cmdline.Command{cmdToolsList},
+}
+
+// cmdToolsList represents the "jiri tools list" command.
+var cmdToolsList = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runToolsList),
+	Name:   "list",
+	Short:  "List the installed tools and the revisions they were built from",
+	Long: `
+List the tools installed in $JIRI_ROOT/.jiri_root/bin, with the project and
+revision each tool was built from and the time it was built at, which jiri
+embeds into the tools it builds.  The information is read by running each tool
+with the -metadata flag.
+
+Tools built from a revision other than the one the current manifest selects
+for their project, i.e. the pinned revision of the project or the revision of
+its local master branch, are listed as "stale"; run "jiri rebuild" to rebuild
+them.  Tools that do not support -metadata, or were not built by jiri, are
+listed as "unknown".
+`,
+}
+
+// cmdRollbackTools represents the "jiri rollback-tools" command.
+var cmdRollbackTools = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runRollbackTools),
+	Name:   "rollback-tools",
+	Short:  "Reinstall the tools that were installed before the latest update",
+	Long: `
+Swap the tools installed in $JIRI_ROOT/.jiri_root/bin with those that were
+installed before the latest "jiri update" or "jiri rebuild", which are kept in
+$JIRI_ROOT/.jiri_root/bin.previous, e.g. to recover quickly when a newly built
+tool is broken.  Running the command again undoes the rollback.
+
+Tools are installed as a set: if an update fails to install any tool, the
+tools that were installed before are restored, so that the bin directory never
+holds a mix of old and new tools.
+`,
+}
+
+func runRollbackTools(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	if err := project.RollbackTools(jirix); err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "Rolled back the tools in %s; run \"jiri rollback-tools\" again to undo\n", jirix.BinDir())
+	return nil
+}
+
+// toolInfo describes an installed tool.
+type toolInfo struct {
+	name, project, revision, buildTime, status string
+}
+
+func runToolsList(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	projects, _, err := project.LoadManifest(jirix)
+	if err != nil {
+		return err
+	}
+	infos, err := installedTools(jirix, projects)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(jirix.Stdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPROJECT\tREVISION\tBUILT\tSTATUS")
+	for _, info := range infos {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", info.name, orUnknown(info.project), orUnknown(info.revision), orUnknown(info.buildTime), info.status)
+	}
+	return w.Flush()
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return toolUnknown
+	}
+	return s
+}
+
+// installedTools returns the tools installed in the bin directory of the jiri
+// root, sorted by name, comparing the revisions they were built from with
+// those the given projects of the manifest select.
+func installedTools(jirix *jiri.X, projects project.Projects) ([]toolInfo, error) {
+	fis, err := jirix.NewSeq().ReadDir(jirix.BinDir())
+	if err != nil {
+		return nil, err
+	}
+	var infos []toolInfo
+	for _, fi := range fis {
+		if fi.IsDir() || fi.Mode()&0111 == 0 {
+			continue
+		}
+		info := toolInfo{name: fi.Name(), status: toolUnknown}
+		md, err := toolMetadata(jirix, filepath.Join(jirix.BinDir(), fi.Name()))
+		if err == nil {
+			info.project = md.Lookup(project.ToolProjectMetadata)
+			info.revision = md.Lookup(project.ToolRevisionMetadata)
+			info.buildTime = md.Lookup(project.ToolBuildTimeMetadata)
+		}
+		if info.project != "" && info.revision != "" {
+			if want, err := manifestRevision(jirix, projects, info.project); err == nil {
+				info.status = toolStale
+				if want == info.revision {
+					info.status = toolUpToDate
+				}
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].name < infos[j].name })
+	return infos, nil
+}
+
+// toolMetadata runs the given tool binary with -metadata and returns the
+// metadata it prints.
+func toolMetadata(jirix *jiri.X, path string) (*metadata.T, error) {
+	var stdout bytes.Buffer
+	if err := jirix.NewSeq().Read(nil).Capture(&stdout, ioutil.Discard).Timeout(toolMetadataTimeout).Last(path, "-metadata"); err != nil {
+		return nil, err
+	}
+	return metadata.FromXML(bytes.TrimSpace(stdout.Bytes()))
+}
+
+// manifestRevision returns the revision that the manifest selects for the
+// project with the given name: the revision the project is pinned to, or the
+// revision of the master branch of the local project.
+func manifestRevision(jirix *jiri.X, projects project.Projects, name string) (string, error) {
+	p, err := projects.FindUnique(name)
+	if err != nil {
+		return "", err
+	}
+	if p.Revision != "" && p.Revision != "HEAD" {
+		return p.Revision, nil
+	}
+	if _, err := os.Stat(p.Path); err != nil {
+		return "", err
+	}
+	return gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(p.Path)).CurrentRevisionOfBranch("master")
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"v.io/jiri/gitutil"
+	"v.io/jiri/jiritest"
+	"v.io/jiri/project"
+	"v.io/jiri/tool"
+	"v.io/x/lib/metadata"
+)
+
+func TestToolsList(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	revision, err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(filepath.Join(fake.X.Root, "manifest"))).CurrentRevisionOfBranch("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Install fake tools that print the given metadata, or that do not
+	// support -metadata if it is nil.
+	install := func(name string, md map[string]string, mode os.FileMode) {
+		script := "#!/bin/sh\necho 'flag provided but not defined: -metadata' >&2\nexit 2\n"
+		if md != nil {
+			script = fmt.Sprintf("#!/bin/sh\ncat <<'EOF'\n%s\nEOF\n", metadata.FromMap(md).ToXML())
+		}
+		path := filepath.Join(fake.X.BinDir(), name)
+		if err := fake.X.NewSeq().MkdirAll(fake.X.BinDir(), 0755).WriteFile(path, []byte(script), 0755).Chmod(path, mode).Done(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buildTime := "2016-01-02T03:04:05Z"
+	install("current", map[string]string{
+		project.ToolProjectMetadata:   "manifest",
+		project.ToolRevisionMetadata:  revision,
+		project.ToolBuildTimeMetadata: buildTime,
+	}, 0755)
+	install("old", map[string]string{
+		project.ToolProjectMetadata:   "manifest",
+		project.ToolRevisionMetadata:  "0123456789abcdef",
+		project.ToolBuildTimeMetadata: buildTime,
+	}, 0755)
+	install("removed", map[string]string{
+		project.ToolProjectMetadata:  "nonexistent",
+		project.ToolRevisionMetadata: revision,
+	}, 0755)
+	install("unsupported", nil, 0755)
+	install("data", nil, 0644)
+
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+	if err := runToolsList(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := regexp.MustCompile(" +").ReplaceAllString(stdout.String(), " ")
+	want := fmt.Sprintf(`NAME PROJECT REVISION BUILT STATUS
+current manifest %s %s up-to-date
+old manifest 0123456789abcdef %s stale
+removed nonexistent %s unknown unknown
+unsupported unknown unknown unknown unknown
+`, revision, buildTime, buildTime, revision)
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("got errors %q, want none", stderr.String())
+	}
+}
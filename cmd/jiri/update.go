@@ -5,7 +5,14 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
 	"v.io/jiri"
+	"v.io/jiri/googlesource"
+	"v.io/jiri/profiles/profilescmdline"
 	"v.io/jiri/project"
 	"v.io/jiri/retry"
 	"v.io/jiri/tool"
@@ -13,8 +20,40 @@ import (
 )
 
 var (
-	gcFlag       bool
-	attemptsFlag int
+	gcFlag                bool
+	attemptsFlag          int
+	noHooksFlag           bool
+	summaryOnlyFlag       bool
+	fetchGroupsFlag       string
+	pruneGroupsFlag       string
+	referenceDirFlag      string
+	dissociateFlag        bool
+	offlineFlag           bool
+	googleSourceHostsFlag string
+	noRemoteCacheFlag     bool
+	remoteCacheDirFlag    string
+	remoteCacheTTLFlag    time.Duration
+	forceRemoteChangeFlag bool
+	rebaseTrackedFlag     bool
+	updateHistoryKeepFlag int
+	updateHistoryAgeFlag  time.Duration
+	goRootFlag            string
+	gitTimeoutFlag        time.Duration
+	gitTimeoutFlagSet     bool
+	hookProfilesFlag      string
+	hookTimeoutFlag       time.Duration
+	xunitOutFlag          string
+	noVerifyFlag          bool
+	forceSnapshotFlag     bool
+	skipPostUpdateFlag    bool
+	detectLFSFlag         bool
+	progressFlag          string
+	frozenFlag            bool
+	updateLockFlag        bool
+	manifestOnlyFlag      bool
+	installMissingFlag    bool
+	profilesDBFlag        string
+	profilesDirFlag       string
 )
 
 func init() {
@@ -22,6 +61,38 @@ func init() {
 
 	cmdUpdate.Flags.BoolVar(&gcFlag, "gc", false, "Garbage collect obsolete repositories.")
 	cmdUpdate.Flags.IntVar(&attemptsFlag, "attempts", 1, "Number of attempts before failing.")
+	cmdUpdate.Flags.StringVar(&fetchGroupsFlag, "fetch-groups", "", `Comma-separated list of project groups to enable, replacing the enabled groups.  See "jiri help project group".`)
+	cmdUpdate.Flags.StringVar(&pruneGroupsFlag, "prune-groups", "", "Comma-separated list of disabled project groups whose local projects are deleted if -gc is set.")
+	cmdUpdate.Flags.BoolVar(&summaryOnlyFlag, "summary-only", false, "Only print the summary of the update, rather than logging each operation.")
+	cmdUpdate.Flags.BoolVar(&noHooksFlag, "no-hooks", false, "Do not run the runhooks or install the githooks of projects, list them instead.")
+	cmdUpdate.Flags.BoolVar(&skipPostUpdateFlag, "skip-postupdate", false, "Do not run the post-update commands of the manifest, list them instead.")
+	cmdUpdate.Flags.StringVar(&hookProfilesFlag, "hook-profiles", "", "Comma-separated list of profiles whose environment variables are merged into the environment of the runhooks of all projects.")
+	cmdUpdate.Flags.BoolVar(&installMissingFlag, "install-missing-profiles", false, "Install the profiles required by the runhooks of projects that are not installed, rather than skip the runhooks.")
+	profilescmdline.RegisterDBPathFlag(&cmdUpdate.Flags, &profilesDBFlag, jiri.ProfilesDBDir)
+	profilescmdline.RegisterProfilesDirFlag(&cmdUpdate.Flags, &profilesDirFlag, jiri.ProfilesRootDir)
+	cmdUpdate.Flags.DurationVar(&hookTimeoutFlag, "hook-timeout", 5*time.Minute, "Maximum time the runhook of a project may take before it is killed and the update fails.  Zero means no limit.")
+	cmdUpdate.Flags.StringVar(&xunitOutFlag, "xunit-out", "", "File to write the outcome of the runhooks to as an xUnit report, with one test case per runhook.")
+	cmdUpdate.Flags.BoolVar(&noVerifyFlag, "no-verify", false, `Do not verify the signatures of the revisions of the projects whose "verify" attribute is "signature".  For emergencies only.`)
+	cmdUpdate.Flags.StringVar(&referenceDirFlag, "reference-dir", "", `Directory of mirror repositories, as created by "jiri project mirror", to borrow objects from when cloning new projects.`)
+	cmdUpdate.Flags.BoolVar(&dissociateFlag, "dissociate", false, "With -reference-dir, copy the borrowed objects into the new projects, so that they do not depend on the mirror repositories.")
+	cmdUpdate.Flags.BoolVar(&offlineFlag, "offline", false, "Skip the network requests that only speed up the update, such as fetching the revisions of projects from googlesource hosts.")
+	cmdUpdate.Flags.StringVar(&googleSourceHostsFlag, "googlesource-hosts", "", "Comma-separated list of googlesource hosts that the revisions of projects may be fetched from; other hosts are skipped.  If empty, all hosts are queried.")
+	cmdUpdate.Flags.BoolVar(&noRemoteCacheFlag, "no-remote-cache", false, "Fetch the revisions of projects from googlesource hosts, rather than use the responses cached by recent updates.")
+	cmdUpdate.Flags.StringVar(&remoteCacheDirFlag, "remote-cache-dir", "", "Directory to cache the responses of googlesource hosts in, e.g. one shared by the jiri roots of a machine.  Overrides $"+googlesource.CacheDirEnv+".  Defaults to $JIRI_ROOT/.jiri_root/cache/googlesource.")
+	cmdUpdate.Flags.DurationVar(&remoteCacheTTLFlag, "remote-cache-ttl", googlesource.DefaultCacheTTL, "How long the cached responses of googlesource hosts are used for.  Zero disables the cache.")
+	cmdUpdate.Flags.BoolVar(&forceRemoteChangeFlag, "force-remote-change", false, "Clone projects whose remote changed to a repository with unrelated history again, moving the old checkouts to <path>.old.")
+	cmdUpdate.Flags.BoolVar(&rebaseTrackedFlag, "rebase-tracked", false, "Rebase the current branch of each updated project that is not on master onto the updated master branch.")
+	cmdUpdate.Flags.StringVar(&goRootFlag, "go-root", "", `Directory of the Go toolchain to build the tools with.  If empty, the toolchain installed by the "go" profile is used if there is one, and the "go" binary in PATH otherwise.`)
+	cmdUpdate.Flags.IntVar(&updateHistoryKeepFlag, "update-history-keep", 0, "Number of the most recent update history snapshots to keep; older snapshots are deleted.  Zero means no limit.")
+	gitTimeoutFlag = 10 * time.Minute
+	cmdUpdate.Flags.Var(setDuration{&gitTimeoutFlag, &gitTimeoutFlagSet}, "git-timeout", "Maximum time a git command that fetches, clones or resets a project may take before it is killed and the update fails.  Zero means no limit, which is the default when stdin is a terminal.")
+	cmdUpdate.Flags.DurationVar(&updateHistoryAgeFlag, "update-history-max-age", 0, "Maximum age of the update history snapshots to keep, e.g. 720h; older snapshots are deleted.  Zero means no limit.")
+	cmdUpdate.Flags.BoolVar(&forceSnapshotFlag, "force-snapshot", false, "Add a snapshot to the update history even if it is identical to the latest one.")
+	cmdUpdate.Flags.StringVar(&progressFlag, "progress", "auto", `How to report the progress of the project operations: "auto" for a status line on a terminal, or a line per operation otherwise, or "off" to log each operation instead.`)
+	cmdUpdate.Flags.BoolVar(&frozenFlag, "frozen", false, "Load the remote manifest imports at the revisions recorded in the manifest lock by the latest update, rather than at the tips of their branches.")
+	cmdUpdate.Flags.BoolVar(&updateLockFlag, "update-lock", false, "Only update the manifest lock to the tips of the branches of the remote manifest imports, without updating the projects.")
+	cmdUpdate.Flags.BoolVar(&manifestOnlyFlag, "manifest-only", false, "Only update the manifest projects of the remote imports, and print how the manifest changed since the latest update, without updating the projects.")
+	cmdUpdate.Flags.BoolVar(&detectLFSFlag, "detect-lfs", false, `Fetch the Git LFS files of the projects whose .gitattributes file uses the LFS filter, even if their "lfs" attribute is not set.`)
 }
 
 // cmdUpdate represents the "jiri update" command.
@@ -36,11 +107,181 @@ individual updates happen guarantees that we end up with a consistent set of
 tools and source code. The set of projects and tools to update is described in
 the manifest.
 
+At the end of the update, a summary is printed that lists the number of
+projects that were created, updated, moved, deleted and left unchanged, the
+revision changes of the projects, the tools that were installed and the time
+the update took.  The -summary-only flag suppresses the logging of the
+individual operations.
+
+While the projects are updated, their progress is reported, so that long
+clones and fetches are not mistaken for a hung update.  On a terminal, a
+single status line, e.g. "[12/80] cloning release.go.x.ref (34s)", is updated
+in place, even with -summary-only; otherwise, a timestamped line is printed
+when each operation starts.  With -v or -progress=off, each operation is
+logged as it runs instead.
+
+A project that is renamed in the manifest, but keeps its remote, is renamed
+in place or moved to its new path, so that its local branches and stashes are
+preserved.  This is only done if no other local or remote project has the
+same remote; otherwise the old project is treated as deleted, and the new one
+is created.
+
+Only the projects of the enabled project groups are created and updated; see
+"jiri help project group".  Local projects of disabled groups are left
+unchanged, unless -gc is set and their groups are listed by -prune-groups.
+
+The -no-hooks flag skips running the runhooks and installing the githooks
+specified by the manifest, and lists the skipped hooks instead.  The skipped
+hooks are also recorded in the update history snapshot.  To disable hooks
+persistently, set the flag in the jiri config:
+
+  jiri config set no-hooks true
+
+The runhooks run with the environment of jiri, or with a minimal environment
+for the projects whose "hookenv" attribute is "clean", with JIRI_ROOT,
+JIRI_PROJECT_NAME and JIRI_PROJECT_PATH set.  The environment variables of the
+profiles listed by -hook-profiles, and by the "hookprofiles" attribute of each
+project, are merged into the environments of the hooks.  With -v, the
+environment of each hook is logged.
+
+The runhook of a project whose "requiresprofiles" attribute lists profiles
+that are not installed for the native target is skipped, with a message
+listing the missing profiles, unless -install-missing-profiles is set, in
+which case they are installed first.  The profiles are looked up in the
+profiles database given by -profiles-db, and installed in the directory given
+by -profiles-dir, as by the "jiri profile" commands.  An update fails before
+changing any project if a required profile is neither installed nor available
+from a profile installer.
+
+The -reference-dir flag names a directory of mirror repositories, as created
+by "jiri project mirror", that new projects are cloned with as references, so
+that objects are borrowed from the mirrors rather than fetched from the
+remotes.  A project is cloned normally if its mirror is missing or unusable.
+Unless -dissociate is set, the new projects keep borrowing objects from the
+mirrors, which therefore must not be deleted.  To use mirrors for every
+update, e.g. on bots, set the flag in the jiri config:
+
+  jiri config set reference-dir /var/cache/jiri-mirrors
+
+Before updating, the revisions of the projects that track a remote branch are
+fetched from their googlesource hosts in one request per host, to skip the
+projects that are already up to date.  The -googlesource-hosts flag restricts
+these requests to the given hosts, and the -offline flag skips them, along
+with any other requests that only speed up the update, e.g. when working
+offline or from an untrusted network:
+
+  jiri config set googlesource-hosts vanadium.googlesource.com
+
+The responses of the googlesource hosts are cached for -remote-cache-ttl, so
+that updates run in quick succession, e.g. on the executors of a presubmit
+system, share one request per host.  Concurrent updates on the same machine
+wait for the request of the first one.  The cache is kept in
+.jiri_root/cache/googlesource, or in the directory given by -remote-cache-dir
+or $JIRI_REMOTE_CACHE_DIR, which may be shared by the jiri roots of a machine.
+The -no-remote-cache flag bypasses the cache.
+
+Only the master branches of the projects are updated.  The -rebase-tracked
+flag also rebases the current branch of each updated project that is not on
+master onto the updated master branch.  Projects with uncommitted changes or
+with a merge or rebase in progress are skipped, and rebases that run into
+conflicts are aborted, leaving the branch as it was.  The summary of the
+update lists the branches that were rebased and those that need to be rebased
+manually.
+
+When the remote of a project changes in the manifest, e.g. because the
+project migrated to a new host, the change is logged and the project is
+fetched from the new remote.  If the revision the project is to be advanced
+to shares no history with the local master branch, the project appears to
+have been replaced, and the update fails rather than resetting master to an
+unrelated revision.  The -force-remote-change flag clones such projects
+again instead, moving the old checkouts to <path>.old.
+
+The git commands that fetch, clone or reset a project are killed if they take
+longer than -git-timeout, e.g. because a remote host stopped responding, and
+the update fails with an error naming the project and its remote.  With
+-attempts, the update is then retried.  Git commands run with a timeout cannot
+prompt for credentials, since they are moved out of the foreground process
+group, so -git-timeout defaults to no limit when stdin is a terminal.
+
+The runhooks of the projects are killed, along with the processes they
+started, if they take longer than -hook-timeout.  The output of a runhook is
+printed only if it fails or -v is set, and the summary of the update lists the
+runhooks that were run, with their durations and outcomes.  The -xunit-out
+flag also writes them to an xUnit report, so that continuous integration
+systems can show which runhook failed.
+
+Once the tools are installed, the post-update commands declared by the
+<postupdate> tags of the manifest are run in order, with JIRI_ROOT set, to
+keep invariants that span projects, such as generated files.  A failing
+command fails the update, unless its "failok" attribute is "true".  The
+commands that were run are listed in the summary of the update, and recorded
+in the audit log.  The -skip-postupdate flag lists the commands instead of
+running them.
+
+Projects whose "verify" attribute is "signature" are only advanced to
+revisions signed by one of the keys listed in $JIRI_ROOT/.jiri_root/keys; see
+"jiri help manifest".  The update fails if a revision is unsigned or signed by
+another key.  In an emergency, -no-verify skips the verification, with a
+warning for each such project.
+
+A snapshot of the projects is added to the update history at the end of each
+update, unless it is identical to the latest snapshot, e.g. because nothing
+changed since the last update; -force-snapshot adds it regardless.  The
+-update-history-keep and -update-history-max-age flags delete the oldest
+snapshots, except the latest two, so that the history does not grow forever;
+they are usually set in the jiri config, e.g.:
+
+  jiri config set update-history-keep 1000
+
+The Git LFS files of the projects whose "lfs" attribute is "true" are fetched
+and checked out after the projects are cloned or updated, which requires git-lfs
+to be installed; the update of such a project fails if it is not.  The
+-detect-lfs flag also fetches the LFS files of the projects whose .gitattributes
+file uses the LFS filter.
+
+The revisions of the manifest projects that the remote manifest imports were
+loaded at are recorded in $JIRI_ROOT/.jiri_root/manifest.lock at the end of
+each successful update.  The -frozen flag loads the imports at these revisions
+instead of the tips of their branches, so that the manifests are the same as
+for the latest update, e.g. to reproduce the update of another developer from
+their manifest.lock; it fails if there is no lock or if it does not record an
+import.  The -update-lock flag only updates the lock to the tips of the
+branches.  Snapshots embed the lock, and checking out a snapshot restores it.
+
+The -manifest-only flag only updates the manifest projects of the remote
+imports, as the update does before loading the manifest, and prints how the
+manifest changed since the latest update, without updating the projects or
+running any hook or tool build.  The manifest is compared to the latest update
+history snapshot: the projects and tools that were added or removed, the tools
+whose attributes changed, and the projects whose revision in the manifest
+changed are listed.  Projects that track a remote branch are not fetched, so
+their changes are not listed.  The command exits with code 0 if the manifest
+did not change, and 10 otherwise, so that e.g. a cron job can run a full
+update only when needed:
+
+  jiri update -manifest-only; if [ $? -eq 10 ]; then jiri update; fi
+
+The global -manifest flag loads the given manifest file, relative to
+$JIRI_ROOT, instead of $JIRI_ROOT/.jiri_manifest, e.g. to switch between
+several configurations of the same root.  The update history records the
+manifest file of each update, and an update from another manifest file than
+the latest update prints a warning.
+
 Run "jiri help manifest" for details on manifests.
 `,
 }
 
 func runUpdate(jirix *jiri.X, _ []string) error {
+	if progressFlag != "auto" && progressFlag != "off" {
+		return jirix.UsageErrorf(`-progress must be "auto" or "off"`)
+	}
+	if frozenFlag && updateLockFlag {
+		return jirix.UsageErrorf("-frozen and -update-lock cannot be used together")
+	}
+	if manifestOnlyFlag && (frozenFlag || updateLockFlag) {
+		return jirix.UsageErrorf("-manifest-only cannot be used with -frozen or -update-lock")
+	}
+
 	seq := jirix.NewSeq()
 	// Create the $JIRI_ROOT/.jiri_root directory if it doesn't already exist.
 	//
@@ -51,13 +292,69 @@ func runUpdate(jirix *jiri.X, _ []string) error {
 		return err
 	}
 
+	if fetchGroupsFlag != "" {
+		if err := project.SetEnabledGroups(jirix, project.ParseGroups(fetchGroupsFlag)); err != nil {
+			return err
+		}
+	}
+
+	if updateLockFlag {
+		return project.UpdateManifestLock(jirix, project.GitTimeoutOpt(gitTimeout(jirix)), project.NoVerifyOpt(noVerifyFlag))
+	}
+	if manifestOnlyFlag {
+		return updateManifestOnly(jirix)
+	}
+
+	if err := project.WarnManifestChange(jirix); err != nil {
+		fmt.Fprintf(jirix.Stderr(), "WARNING: failed to read the update history: %v\n", err)
+	}
+
 	// Update all projects to their latest version.
 	// Attempt <attemptsFlag> times before failing.
-	updateFn := func() error { return project.UpdateUniverse(jirix, gcFlag) }
+	updateFn := func() error {
+		var pruneGroups []string
+		if pruneGroupsFlag != "" {
+			pruneGroups = project.ParseGroups(pruneGroupsFlag)
+		}
+		err := project.UpdateUniverse(jirix, gcFlag,
+			project.NoHooksOpt(noHooksFlag),
+			project.SummaryOnlyOpt(summaryOnlyFlag),
+			project.PruneGroupsOpt(pruneGroups),
+			project.ReferenceDirOpt(referenceDirFlag),
+			project.DissociateOpt(dissociateFlag),
+			project.OfflineOpt(offlineFlag),
+			project.GoogleSourceHostsOpt(googleSourceHosts()),
+			project.NoRemoteCacheOpt(noRemoteCacheFlag),
+			project.RemoteCacheDirOpt(remoteCacheDirFlag),
+			project.RemoteCacheTTLOpt(remoteCacheTTLFlag),
+			project.ForceRemoteChangeOpt(forceRemoteChangeFlag),
+			project.RebaseTrackedOpt(rebaseTrackedFlag),
+			project.GoRootOpt(goRootFlag),
+			project.GitTimeoutOpt(gitTimeout(jirix)),
+			project.HookProfilesOpt(splitList(hookProfilesFlag)),
+			project.InstallMissingProfilesOpt(installMissingFlag),
+			project.ProfilesDBOpt(profilesDBFlag),
+			project.ProfilesDirOpt(profilesDirFlag),
+			project.HookTimeoutOpt(hookTimeoutFlag),
+			project.HookXUnitFileOpt(xunitOutFlag),
+			project.NoVerifyOpt(noVerifyFlag),
+			project.SkipPostUpdateOpt(skipPostUpdateFlag),
+			project.DetectLFSOpt(detectLFSFlag),
+			project.ProgressOpt(progressFlag == "auto"),
+			project.FrozenOpt(frozenFlag))
+		if project.IsManifestVersionError(err) {
+			fmt.Fprintf(jirix.Stderr(), "NOTE: the manifest requires a newer version of jiri; update the jiri project and run \"jiri rebuild\", or reinstall jiri, then run \"jiri update\" again\n")
+		}
+		return err
+	}
 	if err := retry.Function(jirix.Context, updateFn, retry.AttemptsOpt(attemptsFlag)); err != nil {
 		return err
 	}
-	if err := project.WriteUpdateHistorySnapshot(jirix, ""); err != nil {
+	if err := project.WriteUpdateHistorySnapshot(jirix, "",
+		project.NoHooksOpt(noHooksFlag),
+		project.UpdateHistoryKeepOpt(updateHistoryKeepFlag),
+		project.UpdateHistoryMaxAgeOpt(updateHistoryAgeFlag),
+		project.ForceSnapshotOpt(forceSnapshotFlag)); err != nil {
 		return err
 	}
 
@@ -65,3 +362,74 @@ func runUpdate(jirix *jiri.X, _ []string) error {
 	// avoid messy partial states.
 	return project.TransitionBinDir(jirix)
 }
+
+// manifestChangedExitCode is the exit code of "jiri update -manifest-only"
+// when the manifest changed since the latest update.
+const manifestChangedExitCode = 10
+
+// updateManifestOnly implements "jiri update -manifest-only".
+func updateManifestOnly(jirix *jiri.X) error {
+	changes, err := project.UpdateManifests(jirix, project.GitTimeoutOpt(gitTimeout(jirix)), project.NoVerifyOpt(noVerifyFlag))
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(jirix.Stdout(), "The manifest has not changed since the latest update.")
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Fprintln(jirix.Stdout(), change)
+	}
+	return cmdline.ErrExitCode(manifestChangedExitCode)
+}
+
+// gitTimeout returns the value of the -git-timeout flag, or zero if the flag
+// is not set and stdin is a terminal.  Git commands run with a timeout are
+// moved out of the foreground process group, so that they can be killed along
+// with the processes they start, and would be stopped if they prompted for
+// credentials.
+func gitTimeout(jirix *jiri.X) time.Duration {
+	if file, ok := jirix.Stdin().(*os.File); ok && !gitTimeoutFlagSet && isTerminal(file) {
+		return 0
+	}
+	return gitTimeoutFlag
+}
+
+// setDuration is a duration flag that records whether it was set.
+type setDuration struct {
+	value *time.Duration
+	set   *bool
+}
+
+func (d setDuration) Set(s string) error {
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d.value, *d.set = value, true
+	return nil
+}
+
+func (d setDuration) String() string {
+	if d.value == nil {
+		return ""
+	}
+	return d.value.String()
+}
+
+// googleSourceHosts returns the hosts listed by the -googlesource-hosts flag,
+// or nil if the flag is empty.
+func googleSourceHosts() []string {
+	return splitList(googleSourceHostsFlag)
+}
+
+// splitList returns the non-empty elements of the given comma-separated list.
+func splitList(list string) []string {
+	var elems []string
+	for _, elem := range strings.Split(list, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/project"
+	"v.io/x/lib/cmdline"
+)
+
+var (
+	pruneKeepFlag   int
+	pruneMaxAgeFlag time.Duration
+)
+
+func init() {
+	cmdUpdateHistoryPrune.Flags.IntVar(&pruneKeepFlag, "update-history-keep", 0, "Number of the most recent snapshots to keep.  Zero means no limit.")
+	cmdUpdateHistoryPrune.Flags.DurationVar(&pruneMaxAgeFlag, "update-history-max-age", 0, "Maximum age of the snapshots to keep, e.g. 720h.  Zero means no limit.")
+}
+
+// cmdUpdateHistory represents the "jiri update-history" command.
+var cmdUpdateHistory = &cmdline.Command{
+	Name:  "update-history",
+	Short: "Manage the update history",
+	Long: `
+Manage the update history, the snapshots of the projects recorded at the end
+of each "jiri update" in $JIRI_ROOT/.jiri_root/update_history.
+`,
+	Children: []*cmdline.Command{cmdUpdateHistoryPrune},
+}
+
+// cmdUpdateHistoryPrune represents the "jiri update-history prune" command.
+var cmdUpdateHistoryPrune = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runUpdateHistoryPrune),
+	Name:   "prune",
+	Short:  "Delete old update history snapshots",
+	Long: `
+Delete the oldest update history snapshots, keeping at most
+-update-history-keep snapshots and none older than -update-history-max-age,
+and report how many snapshots were deleted.  The snapshots pointed to by the
+latest and second-latest links are never deleted.  The flags have the same
+names as those of "jiri update", so that the retention policy can be set for
+both commands in the jiri config, e.g.:
+
+  jiri config set update-history-keep 1000
+`,
+}
+
+func runUpdateHistoryPrune(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	if pruneKeepFlag <= 0 && pruneMaxAgeFlag <= 0 {
+		return jirix.UsageErrorf("at least one of -update-history-keep and -update-history-max-age must be set")
+	}
+	removed, size, err := project.PruneUpdateHistory(jirix, pruneKeepFlag, pruneMaxAgeFlag)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "Removed %d snapshots (%d bytes) from %s\n", removed, size, jirix.UpdateHistoryDir())
+	return nil
+}
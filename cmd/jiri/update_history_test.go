@@ -0,0 +1,47 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"v.io/jiri/jiritest"
+	"v.io/jiri/tool"
+)
+
+func TestUpdateHistoryPrune(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+
+	dir := fake.X.UpdateHistoryDir()
+	if err := fake.X.NewSeq().MkdirAll(dir, 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		name := time.Now().Add(time.Duration(-i) * time.Hour).Format(time.RFC3339)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("snapshot"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := runUpdateHistoryPrune(fake.X, nil); err == nil {
+		t.Errorf("expected prune without limits to fail")
+	}
+	pruneKeepFlag = 2
+	defer func() { pruneKeepFlag = 0 }()
+	if err := runUpdateHistoryPrune(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), fmt.Sprintf("Removed 2 snapshots (16 bytes) from %s\n", dir); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -9,7 +9,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 
+	"v.io/jiri"
 	"v.io/x/lib/cmdline"
 )
 
@@ -26,16 +29,65 @@ If the binary is being run directly, the output looks like this:
 
   # binary
   /path/to/binary/jiri
+  # root from $JIRI_ROOT
+  /path/to/root
 
 If the script is being run, the output looks like this:
 
   # script
   /path/to/script/jiri
+
+The binary also shows the jiri root and how it was found: from $JIRI_ROOT if
+it is set, or otherwise from the closest .jiri_root directory above the
+current directory, as the script does.  The binary can thus be run without the
+script.
+
+On Windows, where the script is replaced by a jiri.bat, jiri.cmd or jiri.ps1
+shim, the binary cannot tell whether it was run via the shim, so the shim
+found first in PATH, if any, is shown before the binary:
+
+  # script
+  C:\path\to\script\jiri.bat
+  # binary
+  ...
 `,
 }
 
+// shimExts are the extensions of the jiri shim scripts on Windows, in the
+// order cmd.exe prefers them.
+var shimExts = []string{".bat", ".cmd", ".ps1"}
+
+// findShim returns the first jiri shim script in the directories of the given
+// PATH, which are joined with their entries using the given separators, or ""
+// if there is none.
+func findShim(path string, listSep, sep byte, isFile func(string) bool) string {
+	for _, dir := range strings.Split(path, string(listSep)) {
+		if dir == "" {
+			continue
+		}
+		dir = strings.TrimRight(dir, string(sep))
+		for _, ext := range shimExts {
+			if file := dir + string(sep) + "jiri" + ext; isFile(file) {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
 func runWhich(env *cmdline.Env, args []string) error {
 	if len(args) == 0 {
+		if runtime.GOOS == "windows" {
+			if shim := findShim(os.Getenv("PATH"), filepath.ListSeparator, filepath.Separator, isFile); shim != "" {
+				fmt.Fprintln(env.Stdout, "# script")
+				fmt.Fprintln(env.Stdout, shim)
+			}
+		}
 		fmt.Fprintln(env.Stdout, "# binary")
 		path, err := exec.LookPath(os.Args[0])
 		if err != nil {
@@ -46,6 +98,13 @@ func runWhich(env *cmdline.Env, args []string) error {
 			return err
 		}
 		fmt.Fprintln(env.Stdout, abs)
+		root, source, err := jiri.FindRootSource()
+		if err != nil {
+			fmt.Fprintf(env.Stdout, "# root not found: %v\n", err)
+			return nil
+		}
+		fmt.Fprintf(env.Stdout, "# root %s\n", source)
+		fmt.Fprintln(env.Stdout, root)
 		return nil
 	}
 	// TODO(toddw): Look up the path to each argument.  This will only be helpful
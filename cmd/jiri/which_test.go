@@ -7,8 +7,10 @@ package main
 import (
 	"fmt"
 	"path/filepath"
+	"reflect"
 	"testing"
 
+	"v.io/jiri"
 	"v.io/x/lib/gosh"
 )
 
@@ -18,8 +20,13 @@ func TestWhich(t *testing.T) {
 	defer sh.Cleanup()
 
 	jiriBinary := gosh.BuildGoPkg(sh, sh.MakeTempDir(), "v.io/jiri/cmd/jiri")
+	root, err := filepath.EvalSymlinks(sh.MakeTempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.Vars[jiri.RootEnv] = root
 	stdout, stderr := sh.Cmd(jiriBinary, []string{"which"}...).StdoutStderr()
-	if got, want := stdout, fmt.Sprintf("# binary\n%s\n", jiriBinary); got != want {
+	if got, want := stdout, fmt.Sprintf("# binary\n%s\n# root from $%s\n%s\n", jiriBinary, jiri.RootEnv, root); got != want {
 		t.Errorf("stdout got %q, want %q", got, want)
 	}
 	if got, want := stderr, ""; got != want {
@@ -45,3 +52,35 @@ func TestWhichScript(t *testing.T) {
 		t.Errorf("stderr got %q, want %q", got, want)
 	}
 }
+
+// TestFindShim checks that the first jiri shim script in PATH is found, using
+// the separators of Windows.
+func TestFindShim(t *testing.T) {
+	files := map[string]bool{
+		`C:\a\jiri.exe`: true,
+		`C:\b\jiri.ps1`: true,
+		`C:\b\jiri.cmd`: true,
+		`C:\c\jiri.bat`: true,
+	}
+	var checked []string
+	isFile := func(file string) bool {
+		checked = append(checked, file)
+		return files[file]
+	}
+	tests := []struct {
+		path, want string
+	}{
+		{`C:\a;C:\b\;C:\c`, `C:\b\jiri.cmd`},
+		{`;C:\c;C:\b`, `C:\c\jiri.bat`},
+		{`C:\a`, ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := findShim(test.path, ';', '\\', isFile); got != test.want {
+			t.Errorf("findShim(%q): got %q, want %q", test.path, got, test.want)
+		}
+	}
+	if got, want := checked[:5], []string{`C:\a\jiri.bat`, `C:\a\jiri.cmd`, `C:\a\jiri.ps1`, `C:\b\jiri.bat`, `C:\b\jiri.cmd`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("checked files %q, want %q", got, want)
+	}
+}
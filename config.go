@@ -0,0 +1,128 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigFile is the path of the jiri config file, relative to the jiri root.
+const ConfigFile = RootMetaDir + string(filepath.Separator) + "config"
+
+// Config holds default flag values, keyed by flag name.  A key may also be
+// qualified by the names of the subcommands the flag belongs to, separated by
+// dots, as in "cl.mail.remote-branch".
+//
+// Config files contain one key=value pair per line.  Blank lines and lines
+// starting with '#' are ignored.
+type Config map[string]string
+
+// ConfigFile returns the path to the jiri config file.
+func (x *X) ConfigFile() string {
+	return filepath.Join(x.Root, ConfigFile)
+}
+
+// UserConfigFile returns the path to the per-user jiri config file, which
+// is $XDG_CONFIG_HOME/jiri/config, or ~/.config/jiri/config if
+// XDG_CONFIG_HOME is not set.  Returns an empty string if neither
+// XDG_CONFIG_HOME nor HOME is set.
+func UserConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "jiri", "config")
+}
+
+// LoadConfig returns the config that results from merging the per-user config
+// file with the config file of the given jiri root, with values in the latter
+// taking precedence.  Either file may be missing, and the root may be empty.
+func LoadConfig(root string) (Config, error) {
+	config := Config{}
+	paths := []string{UserConfigFile()}
+	if root != "" {
+		paths = append(paths, filepath.Join(root, ConfigFile))
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		c, err := ReadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range c {
+			config[key] = value
+		}
+	}
+	return config, nil
+}
+
+// ReadConfig reads the config file at the given path.  A missing file is
+// treated as an empty config.
+func ReadConfig(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Config{}, nil
+		}
+		return nil, err
+	}
+	config := Config{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		index := strings.Index(line, "=")
+		if index == -1 {
+			return nil, fmt.Errorf("%v:%d: expected key=value, got %q", path, n, line)
+		}
+		key := strings.TrimSpace(line[:index])
+		if key == "" {
+			return nil, fmt.Errorf("%v:%d: empty key", path, n)
+		}
+		config[key] = strings.TrimSpace(line[index+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Keys returns the keys of the config in sorted order.
+func (c Config) Keys() []string {
+	keys := []string{}
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Write writes the config to the file at the given path, creating the
+// enclosing directory if necessary.
+func (c Config) Write(path string) error {
+	var buf bytes.Buffer
+	for _, key := range c.Keys() {
+		fmt.Fprintf(&buf, "%s=%s\n", key, c[key])
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	oldXDG := os.Getenv("XDG_CONFIG_HOME")
+	if err := os.Setenv("XDG_CONFIG_HOME", tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("XDG_CONFIG_HOME", oldXDG)
+
+	// Missing config files result in an empty config.
+	root := filepath.Join(tmpDir, "root")
+	config, err := LoadConfig(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(config), 0; got != want {
+		t.Errorf("got %v keys, want %v", got, want)
+	}
+
+	user := "# user defaults\ncolor = false\n\nv=true\n"
+	if err := os.MkdirAll(filepath.Join(tmpDir, "jiri"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(UserConfigFile(), []byte(user), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Config{"color": "true", "cl.mail.remote-branch": "a=b"}).Write(filepath.Join(root, ConfigFile)); err != nil {
+		t.Fatal(err)
+	}
+	config, err = LoadConfig(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{"color": "true", "v": "true", "cl.mail.remote-branch": "a=b"}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("got %v, want %v", config, want)
+	}
+
+	// Malformed lines are reported.
+	for _, data := range []string{"color\n", "=false\n"} {
+		if err := ioutil.WriteFile(UserConfigFile(), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(root); err == nil {
+			t.Errorf("%q: expected an error", data)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package errkind classifies the errors of jiri commands, so that the scripts
+// that run jiri can tell classes of failures apart by the exit code of jiri,
+// e.g. to retry after network failures but report broken manifests.
+package errkind
+
+import (
+	"fmt"
+
+	"v.io/jiri/runutil"
+	"v.io/x/lib/cmdline"
+)
+
+// Kind is the class of an error.
+type Kind int
+
+const (
+	// Unknown is the kind of errors that are not classified.
+	Unknown Kind = iota
+	// UsageError is the kind of errors in the command-line arguments.
+	UsageError
+	// NetworkError is the kind of failures to reach a remote host, which
+	// may succeed when retried.
+	NetworkError
+	// ManifestError is the kind of errors in manifests, such as invalid
+	// files, duplicate projects and import cycles.
+	ManifestError
+	// GitConflictError is the kind of failures caused by conflicting
+	// changes, such as merge conflicts and unrelated histories.
+	GitConflictError
+	// ToolBuildError is the kind of failures to build the tools.
+	ToolBuildError
+)
+
+var kindNames = map[Kind]string{
+	Unknown:          "unknown",
+	UsageError:       "usage",
+	NetworkError:     "network",
+	ManifestError:    "manifest",
+	GitConflictError: "git-conflict",
+	ToolBuildError:   "tool-build",
+}
+
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// ExitCode returns the exit code of jiri commands that fail with an error of
+// the kind.  Unknown errors have exit code 1, and usage errors have exit code
+// 2, as for all commands built with the cmdline package.
+func (k Kind) ExitCode() int {
+	switch k {
+	case UsageError:
+		return 2
+	case NetworkError:
+		return 3
+	case ManifestError:
+		return 4
+	case GitConflictError:
+		return 5
+	case ToolBuildError:
+		return 6
+	}
+	return 1
+}
+
+// Classified is implemented by errors that know their kind.
+type Classified interface {
+	error
+	ErrorKind() Kind
+}
+
+type kindError struct {
+	kind Kind
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) ErrorKind() Kind {
+	return e.kind
+}
+
+// Wrap returns an error of the given kind with the message of err.  It
+// returns err unchanged if it is nil or already classified, so that the
+// classification made closest to the failure wins.  The returned error has a
+// different type than err, so errors that callers inspect, e.g. with
+// runutil.IsNotExist, should not be wrapped.
+func Wrap(kind Kind, err error) error {
+	if err == nil || Of(err) != Unknown {
+		return err
+	}
+	return &kindError{kind, err}
+}
+
+// Errorf is like fmt.Errorf, but returns an error of the given kind.
+func Errorf(kind Kind, format string, args ...interface{}) error {
+	return &kindError{kind, fmt.Errorf(format, args...)}
+}
+
+// Of returns the kind of err, looking through the errors wrapped by
+// runutil.Sequence.  Usage errors reported by the cmdline package are of kind
+// UsageError, and errors that are not classified are of kind Unknown.
+func Of(err error) Kind {
+	for err != nil {
+		switch e := err.(type) {
+		case Classified:
+			return e.ErrorKind()
+		case cmdline.ErrExitCode:
+			if e == cmdline.ErrUsage {
+				return UsageError
+			}
+			return Unknown
+		}
+		original := runutil.GetOriginalError(err)
+		if original == err {
+			break
+		}
+		err = original
+	}
+	return Unknown
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package errkind_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"v.io/jiri/errkind"
+	"v.io/jiri/jiritest"
+	"v.io/x/lib/cmdline"
+)
+
+func TestOf(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	network := errkind.Errorf(errkind.NetworkError, "unreachable")
+	// Errors returned by a sequence are wrapped.
+	sequenceErr := jirix.NewSeq().Call(func() error { return network }, "fetch").Done()
+	tests := []struct {
+		err  error
+		want errkind.Kind
+	}{
+		{nil, errkind.Unknown},
+		{errors.New("unclassified"), errkind.Unknown},
+		{cmdline.ErrUsage, errkind.UsageError},
+		{cmdline.ErrExitCode(3), errkind.Unknown},
+		{network, errkind.NetworkError},
+		{sequenceErr, errkind.NetworkError},
+		{errkind.Wrap(errkind.ManifestError, errors.New("invalid")), errkind.ManifestError},
+		// The first classification wins.
+		{errkind.Wrap(errkind.ManifestError, network), errkind.NetworkError},
+		{errkind.Wrap(errkind.ToolBuildError, sequenceErr), errkind.NetworkError},
+	}
+	for _, test := range tests {
+		if got := errkind.Of(test.err); got != test.want {
+			t.Errorf("Of(%v): got %v, want %v", test.err, got, test.want)
+		}
+	}
+	if err := errkind.Wrap(errkind.ManifestError, nil); err != nil {
+		t.Errorf("Wrap(nil): got %v, want nil", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	kinds := []errkind.Kind{
+		errkind.Unknown,
+		errkind.UsageError,
+		errkind.NetworkError,
+		errkind.ManifestError,
+		errkind.GitConflictError,
+		errkind.ToolBuildError,
+	}
+	codes := map[int]errkind.Kind{}
+	for _, kind := range kinds {
+		code := kind.ExitCode()
+		if other, ok := codes[code]; ok {
+			t.Errorf("%v and %v have the same exit code %d", kind, other, code)
+		}
+		codes[code] = kind
+	}
+	if got, want := errkind.Unknown.ExitCode(), 1; got != want {
+		t.Errorf("got exit code %d for unknown errors, want %d", got, want)
+	}
+	if got, want := errkind.UsageError.ExitCode(), int(cmdline.ErrUsage); got != want {
+		t.Errorf("got exit code %d for usage errors, want %d", got, want)
+	}
+	if got, want := fmt.Sprint(errkind.GitConflictError), "git-conflict"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
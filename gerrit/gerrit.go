@@ -29,6 +29,7 @@ var (
 	remoteRE        = regexp.MustCompile("remote:[^\n]*")
 	multiPartRE     = regexp.MustCompile(`MultiPart:\s*(\d+)\s*/\s*(\d+)`)
 	presubmitTestRE = regexp.MustCompile(`PresubmitTest:\s*(.*)`)
+	labelNameRE     = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
 
 	queryParameters = []string{"CURRENT_REVISION", "CURRENT_COMMIT", "CURRENT_FILES", "LABELS", "DETAILED_ACCOUNTS"}
 )
@@ -61,6 +62,10 @@ type CLOpts struct {
 	// Edit determines if the user should be prompted to edit the commit
 	// message when the CL is exported to Gerrit.
 	Edit bool
+	// Hashtags records a list of hashtags to add to the CL.
+	Hashtags []string
+	// Labels records a list of votes to cast on the CL.
+	Labels []LabelVote
 	// Remote identifies the Gerrit remote that this CL will be pushed to
 	Remote string
 	// Host identifies the Gerrit host.
@@ -75,6 +80,45 @@ type CLOpts struct {
 	Topic string
 	// Verify controls whether git pre-push hooks should be run before uploading.
 	Verify bool
+	// WIP determines if the CL should be marked as work in progress.
+	WIP bool
+	// Ready determines if the CL should be marked as ready for review.
+	Ready bool
+}
+
+// LabelVote represents a vote on a Gerrit label, such as Code-Review+2.
+type LabelVote struct {
+	Name  string
+	Value int
+}
+
+// ParseLabelVote parses a vote of the form <name>=<value>, such as
+// "Commit-Queue=+1" or "Code-Review=-1".
+func ParseLabelVote(vote string) (LabelVote, error) {
+	parts := strings.SplitN(vote, "=", 2)
+	if len(parts) != 2 || !labelNameRE.MatchString(parts[0]) {
+		return LabelVote{}, fmt.Errorf("invalid label %q: want <name>=<value>, such as Code-Review=+1", vote)
+	}
+	value, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return LabelVote{}, fmt.Errorf("invalid label %q: value %q is not a number", vote, parts[1])
+	}
+	return LabelVote{parts[0], value}, nil
+}
+
+// String returns the vote in the form understood by Gerrit, such as
+// "Code-Review+2".
+func (v LabelVote) String() string {
+	return fmt.Sprintf("%s%+d", v.Name, v.Value)
+}
+
+// ValidateHashtag checks that the given hashtag can be encoded in a Gerrit
+// reference.
+func ValidateHashtag(hashtag string) error {
+	if hashtag == "" || strings.ContainsAny(hashtag, ",%# \t\n") {
+		return fmt.Errorf("invalid hashtag %q: must be non-empty and not contain whitespace, ',', '%%' or '#'", hashtag)
+	}
+	return nil
 }
 
 // Gerrit records a hostname of a Gerrit instance.
@@ -182,7 +226,9 @@ type Change struct {
 	// CL data.
 	Change_id        string
 	Current_revision string
+	Number           int `json:"_number"`
 	Project          string
+	Status           string
 	Topic            string
 	Revisions        Revisions
 	Owner            Owner
@@ -233,6 +279,13 @@ func (c Change) OwnerEmail() string {
 	return c.Owner.Email
 }
 
+// The statuses of changes.
+const (
+	ChangeStatusNew       = "NEW"
+	ChangeStatusMerged    = "MERGED"
+	ChangeStatusAbandoned = "ABANDONED"
+)
+
 type PresubmitTestType string
 
 const (
@@ -324,17 +377,25 @@ func parsePresubmitTestType(match string) PresubmitTestType {
 // See the following links for more details about Gerrit search syntax:
 // - https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#list-changes
 // - https://gerrit-review.googlesource.com/Documentation/user-search.html
-func (g *Gerrit) Query(query string) (_ CLList, e error) {
+func (g *Gerrit) Query(query string) (CLList, error) {
 	cred, err := hostCredentials(g.s, g.host)
 	if err != nil {
 		return nil, err
 	}
+	return g.query(query, cred)
+}
 
+// query runs the given query with the given credentials, or anonymously if
+// cred is nil.
+func (g *Gerrit) query(query string, cred *credentials) (_ CLList, e error) {
 	u, err := url.Parse(g.host.String())
 	if err != nil {
 		return nil, err
 	}
-	u.Path = "/a/changes/"
+	u.Path = "/changes/"
+	if cred != nil {
+		u.Path = "/a/changes/"
+	}
 	v := url.Values{}
 	v.Set("q", query)
 	for _, o := range queryParameters {
@@ -350,19 +411,77 @@ func (g *Gerrit) Query(query string) (_ CLList, e error) {
 		return nil, fmt.Errorf("NewRequest(%q, %q, %v) failed: %v", method, url, body, err)
 	}
 	req.Header.Add("Accept", "application/json")
-	req.SetBasicAuth(cred.username, cred.password)
+	if cred != nil {
+		req.SetBasicAuth(cred.username, cred.password)
+	}
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("Do(%v) failed: %v", req, err)
 	}
+	defer collect.Error(func() error { return res.Body.Close() }, &e)
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("Query:Do(%v) failed: %v", req, res.StatusCode)
 	}
-	defer collect.Error(func() error { return res.Body.Close() }, &e)
 	return parseQueryResults(res.Body)
 }
 
+// ChangeStatus returns the status of the change with the given Change-Id,
+// i.e. ChangeStatusNew, ChangeStatusMerged or ChangeStatusAbandoned, or "" if
+// there is no such change, e.g. if it has not been uploaded.  If the change
+// has been cherry-picked to other branches, the status of the most recently
+// updated change is returned.  The query is sent anonymously if there
+// are no credentials for the host, which is enough for public hosts.
+func (g *Gerrit) ChangeStatus(changeID string) (string, error) {
+	cred, err := hostCredentials(g.s, g.host)
+	if err != nil {
+		cred = nil
+	}
+	changes, err := g.query("change:"+changeID, cred)
+	if err != nil {
+		return "", err
+	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+	return changes[0].Status, nil
+}
+
+// maxChangesPerQuery is the maximum number of changes that Changes asks
+// for in one query.
+const maxChangesPerQuery = 50
+
+// Changes returns the changes with the given Change-Ids, querying the host
+// for up to maxChangesPerQuery changes at a time.  Change-Ids without a
+// change are omitted, and changes that have been cherry-picked to other
+// branches are returned once per branch.  The queries are sent anonymously
+// if there are no credentials for the host, which is enough for public
+// hosts.
+func (g *Gerrit) Changes(changeIDs []string) (CLList, error) {
+	cred, err := hostCredentials(g.s, g.host)
+	if err != nil {
+		cred = nil
+	}
+	var changes CLList
+	for len(changeIDs) > 0 {
+		n := len(changeIDs)
+		if n > maxChangesPerQuery {
+			n = maxChangesPerQuery
+		}
+		var terms []string
+		for _, changeID := range changeIDs[:n] {
+			terms = append(terms, "change:"+changeID)
+		}
+		result, err := g.query(strings.Join(terms, " OR "), cred)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, result...)
+		changeIDs = changeIDs[n:]
+	}
+	return changes, nil
+}
+
 // GetChange returns a Change object for the given changeId number.
 func (g *Gerrit) GetChange(changeNumber int) (*Change, error) {
 	clList, err := g.Query(fmt.Sprintf("%d", changeNumber))
@@ -456,6 +575,16 @@ func Reference(opts CLOpts) string {
 	var params []string
 	params = append(params, formatParams(opts.Reviewers, "r")...)
 	params = append(params, formatParams(opts.Ccs, "cc")...)
+	params = append(params, formatParams(opts.Hashtags, "hashtag")...)
+	for _, label := range opts.Labels {
+		params = append(params, "l="+label.String())
+	}
+	if opts.WIP {
+		params = append(params, "wip")
+	}
+	if opts.Ready {
+		params = append(params, "ready")
+	}
 	if len(params) > 0 {
 		ref = ref + "%" + strings.Join(params, ",")
 	}
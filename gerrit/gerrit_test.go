@@ -6,9 +6,17 @@ package gerrit
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+
+	"v.io/jiri/runutil"
 )
 
 func TestParseQueryResults(t *testing.T) {
@@ -358,5 +366,132 @@ func TestParseRefString(t *testing.T) {
 	}
 }
 
+func TestReference(t *testing.T) {
+	tests := []struct {
+		opts CLOpts
+		want string
+	}{
+		{CLOpts{RemoteBranch: "master"}, "refs/for/master"},
+		{CLOpts{RemoteBranch: "master", Draft: true}, "refs/drafts/master"},
+		{
+			CLOpts{RemoteBranch: "master", Reviewers: []string{"r@example.org"}, Ccs: []string{"c@example.org"}},
+			"refs/for/master%r=r@example.org,cc=c@example.org",
+		},
+		{
+			CLOpts{RemoteBranch: "release", Hashtags: []string{"a", "b"}, Labels: []LabelVote{{"Commit-Queue", 1}, {"Code-Review", 0}}},
+			"refs/for/release%hashtag=a,hashtag=b,l=Commit-Queue+1,l=Code-Review+0",
+		},
+		{CLOpts{RemoteBranch: "master", WIP: true}, "refs/for/master%wip"},
+		{CLOpts{RemoteBranch: "master", Reviewers: []string{"r"}, Ready: true}, "refs/for/master%r=r,ready"},
+	}
+	for _, test := range tests {
+		if got := Reference(test.opts); got != test.want {
+			t.Errorf("Reference(%#v): got %q, want %q", test.opts, got, test.want)
+		}
+	}
+}
+
+func TestParseLabelVote(t *testing.T) {
+	tests := []struct {
+		vote string
+		want LabelVote
+	}{
+		{"Code-Review=+2", LabelVote{"Code-Review", 2}},
+		{"Commit-Queue=1", LabelVote{"Commit-Queue", 1}},
+		{"Verified=-1", LabelVote{"Verified", -1}},
+		{"Verified=0", LabelVote{"Verified", 0}},
+	}
+	for _, test := range tests {
+		got, err := ParseLabelVote(test.vote)
+		if err != nil {
+			t.Errorf("ParseLabelVote(%q) failed: %v", test.vote, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseLabelVote(%q): got %v, want %v", test.vote, got, test.want)
+		}
+	}
+	for _, vote := range []string{"", "Code-Review", "Code-Review=", "Code-Review=+", "=1", "Code_Review=1", "Code-Review=1.5"} {
+		if _, err := ParseLabelVote(vote); err == nil {
+			t.Errorf("ParseLabelVote(%q) did not fail", vote)
+		}
+	}
+}
+
 // TODO(jsimsa): Add a test for the hostCredentials function that
 // exercises the logic that reads the .netrc and git cookie files.
+
+func TestChangeStatus(t *testing.T) {
+	// Use a home directory without credentials.
+	home, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	const changeID = "I26f771cebd6e512b89e98bec1fadfa1cb2aad6e8"
+	statuses := map[string]string{changeID: ChangeStatusMerged}
+	var gotPath, gotUser string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, _, _ = r.BasicAuth()
+		gotPath = r.URL.Path
+		if r.URL.Query().Get("q") == "change:broken" {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Query().Get("q"), "change:")
+		fmt.Fprintln(w, ")]}'")
+		if status, ok := statuses[id]; ok {
+			fmt.Fprintf(w, `[{"change_id": %q, "status": %q}]`, id, status)
+		} else {
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer server.Close()
+	host, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New(runutil.NewSequence(nil, os.Stdin, ioutil.Discard, ioutil.Discard, false, false), host)
+
+	// Without credentials, the query is anonymous.
+	for _, status := range []string{ChangeStatusNew, ChangeStatusMerged, ChangeStatusAbandoned} {
+		statuses[changeID] = status
+		got, err := g.ChangeStatus(changeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != status {
+			t.Errorf("got status %q, want %q", got, status)
+		}
+		if gotPath != "/changes/" || gotUser != "" {
+			t.Errorf("got path %q and user %q, want an anonymous query", gotPath, gotUser)
+		}
+	}
+	if got, err := g.ChangeStatus("Inonexistent"); err != nil || got != "" {
+		t.Errorf("got status %q and error %v for a nonexistent change, want no status", got, err)
+	}
+	if _, err := g.ChangeStatus("broken"); err == nil {
+		t.Errorf("ChangeStatus() did not fail for a server error")
+	}
+
+	// With credentials, the query is authenticated.
+	netrc := fmt.Sprintf("machine %s login git-johndoe.example.com password 12345\n", host.Host)
+	if err := ioutil.WriteFile(filepath.Join(home, ".netrc"), []byte(netrc), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.ChangeStatus(changeID); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/a/changes/" || gotUser != "git-johndoe.example.com" {
+		t.Errorf("got path %q and user %q, want an authenticated query", gotPath, gotUser)
+	}
+
+	// Network failures are reported.
+	server.Close()
+	if _, err := g.ChangeStatus(changeID); err == nil {
+		t.Errorf("ChangeStatus() did not fail for an unreachable host")
+	}
+}
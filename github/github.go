@@ -0,0 +1,243 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package github provides library functions for sending pull requests to
+// GitHub and GitHub Enterprise repositories.
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The states of pull requests.
+const (
+	PullRequestOpen   = "open"
+	PullRequestClosed = "closed"
+)
+
+// Repo identifies a repository on a GitHub host.
+type Repo struct {
+	// Host is the url of the GitHub host, such as https://github.com.
+	Host  *url.URL
+	Owner string
+	Name  string
+}
+
+// ParseRepo parses the url of a repository on a GitHub host, such as
+// https://github.com/vanadium/go.jiri.
+func ParseRepo(repoUrl string) (Repo, error) {
+	u, err := url.Parse(repoUrl)
+	if err != nil {
+		return Repo{}, err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Scheme == "" || u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Repo{}, fmt.Errorf("invalid GitHub repository %q: want <scheme>://<host>/<owner>/<repository>", repoUrl)
+	}
+	return Repo{
+		Host:  &url.URL{Scheme: u.Scheme, Host: u.Host},
+		Owner: parts[0],
+		Name:  strings.TrimSuffix(parts[1], ".git"),
+	}, nil
+}
+
+// String returns the url of the repository.
+func (r Repo) String() string {
+	return fmt.Sprintf("%s/%s/%s", r.Host, r.Owner, r.Name)
+}
+
+// APIUrl returns the url of the REST API of the GitHub host of the
+// repository, which is api.github.com for github.com and <host>/api/v3 for
+// GitHub Enterprise hosts.
+func (r Repo) APIUrl() *url.URL {
+	if r.Host.Host == "github.com" {
+		return &url.URL{Scheme: r.Host.Scheme, Host: "api.github.com"}
+	}
+	return &url.URL{Scheme: r.Host.Scheme, Host: r.Host.Host, Path: "/api/v3"}
+}
+
+// Token returns the token used to authenticate to the GitHub host of the
+// given repository, which is read from the GITHUB_TOKEN environment
+// variable, or else is the password of the host, or of its API host, in the
+// .netrc file.
+func Token(repo Repo) (string, error) {
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return token, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(os.Getenv("HOME"), ".netrc"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	passwords := parseNetrc(string(data))
+	for _, host := range []string{repo.Host.Host, repo.APIUrl().Host} {
+		if password, ok := passwords[host]; ok {
+			return password, nil
+		}
+	}
+	return "", fmt.Errorf("no token found for %s: set GITHUB_TOKEN or add the host to ~/.netrc", repo.Host)
+}
+
+// parseNetrc returns the passwords listed in the given content of a .netrc
+// file, keyed by machine.
+func parseNetrc(data string) map[string]string {
+	passwords := map[string]string{}
+	machine := ""
+	fields := strings.Fields(data)
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			i++
+			machine = fields[i]
+		case "default":
+			machine = ""
+		case "password":
+			i++
+			if machine != "" {
+				passwords[machine] = fields[i]
+			}
+		}
+	}
+	return passwords
+}
+
+// PullRequest represents a GitHub pull request.  For more details, see:
+// https://docs.github.com/en/rest/pulls/pulls
+type PullRequest struct {
+	Number  int    `json:"number"`
+	State   string `json:"state"`
+	Merged  bool   `json:"merged"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	Draft   bool   `json:"draft"`
+	HTMLUrl string `json:"html_url"`
+}
+
+// PullRequestOpts records the options of a new pull request.
+type PullRequestOpts struct {
+	// Head is the branch that contains the changes, prefixed with the
+	// owner of the repository it is in and a colon if it is a fork, such
+	// as "octocat:feature".
+	Head string `json:"head"`
+	// Base is the branch the changes are pulled into.
+	Base  string `json:"base"`
+	Title string `json:"title"`
+	Body  string `json:"body,omitempty"`
+	Draft bool   `json:"draft,omitempty"`
+}
+
+// GitHub sends requests about the pull requests of a repository to the REST
+// API of its host.
+type GitHub struct {
+	repo   Repo
+	api    *url.URL
+	token  string
+	client *http.Client
+}
+
+// New is the GitHub factory.  If token is not empty, it is used to
+// authenticate the requests.
+func New(repo Repo, token string) *GitHub {
+	return &GitHub{
+		repo:   repo,
+		api:    repo.APIUrl(),
+		token:  token,
+		client: http.DefaultClient,
+	}
+}
+
+// CreatePullRequest creates a pull request with the given options.
+func (g *GitHub) CreatePullRequest(opts PullRequestOpts) (PullRequest, error) {
+	var pr PullRequest
+	err := g.request("POST", "pulls", opts, &pr)
+	return pr, err
+}
+
+// UpdatePullRequest sets the title and body of the given pull request.
+func (g *GitHub) UpdatePullRequest(number int, title, body string) (PullRequest, error) {
+	var pr PullRequest
+	update := struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	}{title, body}
+	err := g.request("PATCH", fmt.Sprintf("pulls/%d", number), update, &pr)
+	return pr, err
+}
+
+// RequestReviewers requests review of the given pull request from the
+// users with the given logins.
+func (g *GitHub) RequestReviewers(number int, reviewers []string) error {
+	request := struct {
+		Reviewers []string `json:"reviewers"`
+	}{reviewers}
+	return g.request("POST", fmt.Sprintf("pulls/%d/requested_reviewers", number), request, nil)
+}
+
+// PullRequest returns the given pull request.
+func (g *GitHub) PullRequest(number int) (PullRequest, error) {
+	var pr PullRequest
+	err := g.request("GET", fmt.Sprintf("pulls/%d", number), nil, &pr)
+	return pr, err
+}
+
+// request sends a request with the given method and JSON-encoded input for
+// the given path relative to the repository, and decodes the JSON response
+// into output, unless it is nil.
+func (g *GitHub) request(method, path string, input, output interface{}) error {
+	u := *g.api
+	u.Path += fmt.Sprintf("/repos/%s/%s/%s", g.repo.Owner, g.repo.Name, path)
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return fmt.Errorf("Marshal(%#v) failed: %v", input, err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("NewRequest(%q, %q) failed: %v", method, u.String(), err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if g.token != "" {
+		req.Header.Set("Authorization", "token "+g.token)
+	}
+	res, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v", method, u.String(), err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		// Errors are reported as {"message": "..."}.
+		var message struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil || message.Message == "" {
+			message.Message = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("%s %s failed: %s: %s", method, u.String(), res.Status, message.Message)
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, output); err != nil {
+		return fmt.Errorf("Unmarshal(%q) failed: %v", data, err)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package github
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseRepo(t *testing.T) {
+	tests := []struct {
+		url, repo, api string
+	}{
+		{"https://github.com/vanadium/go.jiri", "https://github.com/vanadium/go.jiri", "https://api.github.com"},
+		{"https://github.com/vanadium/go.jiri.git/", "https://github.com/vanadium/go.jiri", "https://api.github.com"},
+		{"https://github.example.com/team/repo", "https://github.example.com/team/repo", "https://github.example.com/api/v3"},
+	}
+	for _, test := range tests {
+		repo, err := ParseRepo(test.url)
+		if err != nil {
+			t.Errorf("%s: %v", test.url, err)
+			continue
+		}
+		if got := repo.String(); got != test.repo {
+			t.Errorf("%s: got repository %q, want %q", test.url, got, test.repo)
+		}
+		if got := repo.APIUrl().String(); got != test.api {
+			t.Errorf("%s: got API url %q, want %q", test.url, got, test.api)
+		}
+	}
+	for _, url := range []string{"https://github.com", "https://github.com/vanadium", "github.com/vanadium/go.jiri", "https://github.com/a/b/c"} {
+		if _, err := ParseRepo(url); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
+
+func TestParseNetrc(t *testing.T) {
+	data := `machine github.com login octocat password token1
+default login anonymous password ignored
+machine github.example.com
+  login octocat
+  password token2
+`
+	want := map[string]string{"github.com": "token1", "github.example.com": "token2"}
+	if got := parseNetrc(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// fakeGitHub is a GitHub host that keeps the pull requests of a repository
+// in memory.
+type fakeGitHub struct {
+	mu        sync.Mutex
+	pulls     map[int]*PullRequest
+	heads     map[int]string
+	reviewers map[int][]string
+	auth      string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = r.Header.Get("Authorization")
+	const prefix = "/api/v3/repos/owner/repo/pulls"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/"), "/")
+	switch {
+	case r.Method == "POST" && parts[0] == "":
+		var opts PullRequestOpts
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if opts.Title == "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"message": "Validation Failed"}`))
+			return
+		}
+		number := len(f.pulls) + 1
+		f.pulls[number] = &PullRequest{Number: number, State: PullRequestOpen, Title: opts.Title, Body: opts.Body, Draft: opts.Draft}
+		f.heads[number] = opts.Head + " " + opts.Base
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f.pulls[number])
+		return
+	}
+	number, err := strconv.Atoi(parts[0])
+	pr, ok := f.pulls[number]
+	if err != nil || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case r.Method == "GET" && len(parts) == 1:
+	case r.Method == "PATCH" && len(parts) == 1:
+		if err := json.NewDecoder(r.Body).Decode(pr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case r.Method == "POST" && len(parts) == 2 && parts[1] == "requested_reviewers":
+		var request struct {
+			Reviewers []string `json:"reviewers"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.reviewers[number] = append(f.reviewers[number], request.Reviewers...)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(pr)
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, Repo, func()) {
+	fake := &fakeGitHub{pulls: map[int]*PullRequest{}, heads: map[int]string{}, reviewers: map[int][]string{}}
+	server := httptest.NewServer(fake)
+	repo, err := ParseRepo(server.URL + "/owner/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, repo, server.Close
+}
+
+// TestCreatePullRequest checks that pull requests are created with the
+// given options, and that review is requested from the given reviewers.
+func TestCreatePullRequest(t *testing.T) {
+	fake, repo, cleanup := newFakeGitHub(t)
+	defer cleanup()
+	g := New(repo, "secret")
+	pr, err := g.CreatePullRequest(PullRequestOpts{Head: "octocat:feature", Base: "master", Title: "Title", Body: "Body", Draft: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (PullRequest{Number: 1, State: PullRequestOpen, Title: "Title", Body: "Body", Draft: true}); pr != want {
+		t.Errorf("got pull request %+v, want %+v", pr, want)
+	}
+	if got, want := fake.heads[1], "octocat:feature master"; got != want {
+		t.Errorf("got head and base %q, want %q", got, want)
+	}
+	if got, want := fake.auth, "token secret"; got != want {
+		t.Errorf("got authorization %q, want %q", got, want)
+	}
+	if err := g.RequestReviewers(pr.Number, []string{"alice", "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fake.reviewers[1], []string{"alice", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got reviewers %v, want %v", got, want)
+	}
+
+	// Errors include the message of the host.
+	_, err = g.CreatePullRequest(PullRequestOpts{Head: "feature", Base: "master"})
+	if want := "422 Unprocessable Entity: Validation Failed"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+}
+
+// TestUpdatePullRequest checks that the title and body of existing pull
+// requests are updated, and that their state is reported.
+func TestUpdatePullRequest(t *testing.T) {
+	fake, repo, cleanup := newFakeGitHub(t)
+	defer cleanup()
+	g := New(repo, "")
+	pr, err := g.CreatePullRequest(PullRequestOpts{Head: "feature", Base: "master", Title: "Title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.auth != "" {
+		t.Errorf("got authorization %q without a token", fake.auth)
+	}
+	updated, err := g.UpdatePullRequest(pr.Number, "New title", "New body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != "New title" || updated.Body != "New body" {
+		t.Errorf("got pull request %+v after update", updated)
+	}
+
+	fake.pulls[pr.Number].State = PullRequestClosed
+	fake.pulls[pr.Number].Merged = true
+	got, err := g.PullRequest(pr.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != PullRequestClosed || !got.Merged || got.Title != "New title" {
+		t.Errorf("got pull request %+v, want it closed and merged", got)
+	}
+	if _, err := g.UpdatePullRequest(pr.Number+1, "Title", ""); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got error %v for a missing pull request, want 404", err)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"v.io/jiri/errkind"
+	"v.io/jiri/runutil"
+)
+
+// GitError is the error returned when a git command fails.  It records the
+// command that was run along with its exit code and captured output, so that
+// callers can report what failed and test for specific failures using the
+// predicates defined below instead of matching on error strings.
+type GitError struct {
+	// Command is the git subcommand that was run, e.g. "fetch".
+	Command string
+	// Args holds the full argument list passed to git, including Command.
+	Args []string
+	// Dir is the directory the command was run in.
+	Dir string
+	// ExitCode is the exit code of the command, or -1 if the command did
+	// not exit normally.
+	ExitCode int
+	// Output and ErrorOutput hold the captured stdout and stderr of the
+	// command.
+	Output      string
+	ErrorOutput string
+	// TimedOut is true if the command was killed because it did not
+	// finish before the timeout set by TimeoutOpt.
+	TimedOut bool
+}
+
+// Error returns a new GitError for a failed invocation of "git <args>" that
+// produced the given output and error output.  The exit code and directory
+// of the returned error are unknown.
+func Error(output, errorOutput string, args ...string) GitError {
+	return GitError{
+		Command:     subcommand(args),
+		Args:        args,
+		ExitCode:    -1,
+		Output:      output,
+		ErrorOutput: errorOutput,
+	}
+}
+
+// newError returns a new GitError for the failed invocation of "git <args>"
+// by g, which returned err.
+func (g *Git) newError(err error, output, errorOutput string, args ...string) GitError {
+	ge := Error(output, errorOutput, args...)
+	ge.Dir = g.rootDir
+	if ge.Dir == "" {
+		if wd, err := os.Getwd(); err == nil {
+			ge.Dir = wd
+		}
+	}
+	ge.TimedOut = runutil.IsTimeout(err)
+	if exit, ok := runutil.GetOriginalError(err).(*exec.ExitError); ok {
+		if wait, ok := exit.Sys().(syscall.WaitStatus); ok && wait.Exited() {
+			ge.ExitCode = wait.ExitStatus()
+		}
+	}
+	return ge
+}
+
+func (ge GitError) Error() string {
+	result := "'git "
+	result += strings.Join(ge.Args, " ")
+	result += "' failed"
+	if ge.Dir != "" {
+		result += " in " + ge.Dir
+	}
+	if ge.TimedOut {
+		result += " (timed out)"
+	} else if ge.ExitCode >= 0 {
+		result += fmt.Sprintf(" (exit code %d)", ge.ExitCode)
+	}
+	result += ":\n"
+	result += ge.ErrorOutput
+	return result
+}
+
+// networkMessages are the messages of git errors caused by failures to reach
+// a remote.
+var networkMessages = []string{
+	"Could not resolve host",
+	"Could not resolve hostname",
+	"Failed to connect to",
+	"Connection refused",
+	"Connection timed out",
+	"Operation timed out",
+	"Network is unreachable",
+	"unable to access",
+	"The remote end hung up unexpectedly",
+	"early EOF",
+}
+
+// authenticationMessages are the messages of git errors caused by a remote
+// that requires credentials that are missing or rejected.
+var authenticationMessages = []string{
+	"Authentication failed",
+	"terminal prompts disabled",
+	"could not read Username",
+	"could not read Password",
+	"Invalid username or password",
+	"HTTP Basic: Access denied",
+	"Permission denied (publickey",
+}
+
+// conflictMessages are the messages of git errors caused by conflicting
+// changes.
+var conflictMessages = []string{
+	"CONFLICT (",
+	"Automatic merge failed",
+	"could not apply",
+	"needs merge",
+	"non-fast-forward",
+	"refusing to merge unrelated histories",
+}
+
+// ErrorKind returns the kind of the failure, for errkind.Of: NetworkError if
+// git failed to reach a remote or timed out, GitConflictError if it failed
+// because of conflicting changes, and Unknown otherwise, including for
+// authentication failures.
+func (ge GitError) ErrorKind() errkind.Kind {
+	if ge.TimedOut {
+		return errkind.NetworkError
+	}
+	// Some commands, e.g. "git merge", report conflicts on stdout.
+	contains := func(messages []string) bool {
+		for _, message := range messages {
+			if strings.Contains(ge.ErrorOutput, message) || strings.Contains(ge.Output, message) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case contains(authenticationMessages):
+		// Retrying does not help when credentials are missing, even if
+		// the remote could be reached.
+		return errkind.Unknown
+	case contains(networkMessages):
+		return errkind.NetworkError
+	case contains(conflictMessages):
+		return errkind.GitConflictError
+	}
+	return errkind.Unknown
+}
+
+// subcommand returns the git subcommand in the given arguments, skipping any
+// global options that precede it.
+func subcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-c" || arg == "-C":
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			return arg
+		}
+	}
+	return ""
+}
+
+// errorOutputContains returns true if err is a GitError, possibly wrapped by
+// a runutil.Sequence, whose error output contains any of the given messages.
+func errorOutputContains(err error, messages ...string) bool {
+	var ge GitError
+	switch e := runutil.GetOriginalError(err).(type) {
+	case GitError:
+		ge = e
+	case *GitError:
+		ge = *e
+	default:
+		return false
+	}
+	for _, message := range messages {
+		if strings.Contains(ge.ErrorOutput, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTimeout returns true if err reports that a git command was killed because
+// it did not finish before the timeout set by TimeoutOpt.
+func IsTimeout(err error) bool {
+	switch e := runutil.GetOriginalError(err).(type) {
+	case GitError:
+		return e.TimedOut
+	case *GitError:
+		return e.TimedOut
+	}
+	return false
+}
+
+// IsNoSuchRemote returns true if err reports that a git command referred to
+// a remote that is not configured, or that is not a git repository.
+func IsNoSuchRemote(err error) bool {
+	return errorOutputContains(err, "No such remote", "does not appear to be a git repository")
+}
+
+// IsAuthenticationFailure returns true if err reports that a git command
+// failed because a remote requires credentials that are missing or rejected.
+func IsAuthenticationFailure(err error) bool {
+	return errorOutputContains(err, authenticationMessages...)
+}
+
+// IsLFSNotInstalled returns true if err reports that a git lfs command failed
+// because git-lfs is not installed.
+func IsLFSNotInstalled(err error) bool {
+	return errorOutputContains(err, "'lfs' is not a git command")
+}
+
+// IsNotOnBranch returns true if err reports that a git command that requires
+// a current branch was run with a detached HEAD.
+func IsNotOnBranch(err error) bool {
+	return errorOutputContains(err, "You are not currently on a branch", "is not a symbolic ref")
+}
+
+// IsUnknownRevision returns true if err reports that a git command referred
+// to a revision or branch that does not exist.
+func IsUnknownRevision(err error) bool {
+	return errorOutputContains(err, "unknown revision", "bad revision", "as a valid revision", "Could not parse object", "did not match any file(s) known to git", "couldn't find remote ref")
+}
+
+// IsNoNewChanges returns true if err reports that a push to Gerrit was
+// rejected because the pushed commits contain no new changes.
+func IsNoNewChanges(err error) bool {
+	return errorOutputContains(err, "(no new changes)")
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"v.io/jiri/gitutil"
+	"v.io/jiri/runutil"
+)
+
+// setupRepo creates a new git repository with a single commit and returns
+// its path along with a Git instance rooted at it.
+func setupRepo(t *testing.T) (string, *gitutil.Git, func()) {
+	dir, err := ioutil.TempDir("", "gitutil")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	s := runutil.NewSequence(nil, os.Stdin, ioutil.Discard, ioutil.Discard, false, false)
+	if err := gitutil.New(s).Init(dir); err != nil {
+		cleanup()
+		t.Fatalf("Init(%v) failed: %v", dir, err)
+	}
+	file := filepath.Join(dir, "README")
+	if err := ioutil.WriteFile(file, []byte("readme"), 0644); err != nil {
+		cleanup()
+		t.Fatalf("WriteFile(%v) failed: %v", file, err)
+	}
+	git := gitutil.New(s, gitutil.RootDirOpt(dir))
+	if err := git.CommitFile(file, "creating README"); err != nil {
+		cleanup()
+		t.Fatalf("CommitFile(%v) failed: %v", file, err)
+	}
+	return dir, git, cleanup
+}
+
+// runGit runs "git <args>" in the given directory and returns a GitError
+// holding its output if it fails.  It is used to check the predicates against
+// git commands that are not exposed by Git.
+func runGit(dir string, args ...string) error {
+	var stdout, stderr bytes.Buffer
+	s := runutil.NewSequence(nil, os.Stdin, ioutil.Discard, ioutil.Discard, false, false)
+	if err := s.Dir(dir).Capture(&stdout, &stderr).Last("git", args...); err != nil {
+		return gitutil.Error(stdout.String(), stderr.String(), args...)
+	}
+	return nil
+}
+
+// TestGitError checks that the errors returned by failed git commands record
+// the command that was run.
+func TestGitError(t *testing.T) {
+	dir, git, cleanup := setupRepo(t)
+	defer cleanup()
+	err := git.Reset("no-such-revision")
+	ge, ok := err.(gitutil.GitError)
+	if !ok {
+		t.Fatalf("got error %#v, want a GitError", err)
+	}
+	if got, want := ge.Command, "reset"; got != want {
+		t.Errorf("got command %q, want %q", got, want)
+	}
+	if got, want := strings.Join(ge.Args, " "), "reset --hard no-such-revision --"; got != want {
+		t.Errorf("got args %q, want %q", got, want)
+	}
+	if got, want := ge.Dir, dir; got != want {
+		t.Errorf("got dir %q, want %q", got, want)
+	}
+	if got, want := ge.ExitCode, 128; got != want {
+		t.Errorf("got exit code %d, want %d", got, want)
+	}
+	if ge.ErrorOutput == "" {
+		t.Errorf("got empty error output")
+	}
+	if got, want := err.Error(), "'git reset --hard no-such-revision --' failed in "+dir+" (exit code 128):\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got error %q, want prefix %q", got, want)
+	}
+}
+
+// TestGitErrorPredicates checks that the predicates match the output of the
+// git commands that produce the errors they test for, and nothing else.
+func TestGitErrorPredicates(t *testing.T) {
+	dir, git, cleanup := setupRepo(t)
+	defer cleanup()
+	revision, err := git.CurrentRevision()
+	if err != nil {
+		t.Fatalf("CurrentRevision() failed: %v", err)
+	}
+	if err := git.CheckoutBranch(revision); err != nil {
+		t.Fatalf("CheckoutBranch(%v) failed: %v", revision, err)
+	}
+	predicates := map[string]func(error) bool{
+		"IsAuthenticationFailure": gitutil.IsAuthenticationFailure,
+		"IsLFSNotInstalled":       gitutil.IsLFSNotInstalled,
+		"IsNoSuchRemote":          gitutil.IsNoSuchRemote,
+		"IsNotOnBranch":           gitutil.IsNotOnBranch,
+		"IsUnknownRevision":       gitutil.IsUnknownRevision,
+		"IsNoNewChanges":          gitutil.IsNoNewChanges,
+	}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"fetch from unknown remote", git.Fetch("no-such-remote"), "IsNoSuchRemote"},
+		{"set url of unknown remote", git.SetRemoteUrl("no-such-remote", "url"), "IsNoSuchRemote"},
+		{"reset to unknown revision", git.Reset("no-such-revision"), "IsUnknownRevision"},
+		{"reset to unknown commit", git.Reset("0123456789abcdef0123456789abcdef01234567"), "IsUnknownRevision"},
+		{"checkout unknown branch", git.CheckoutBranch("no-such-branch"), "IsUnknownRevision"},
+		{"fetch unknown ref", git.FetchRefspec(".", "no-such-ref"), "IsUnknownRevision"},
+		{"pull with detached head", runGit(dir, "pull"), "IsNotOnBranch"},
+		{"symbolic-ref with detached head", runGit(dir, "symbolic-ref", "HEAD"), "IsNotOnBranch"},
+		{
+			"clone with terminal prompts disabled",
+			gitutil.Error("", "fatal: could not read Username for 'https://example.com': terminal prompts disabled\n", "clone", "https://example.com/repo", "repo"),
+			"IsAuthenticationFailure",
+		},
+		{
+			"fetch with rejected credentials",
+			gitutil.Error("", "remote: Invalid username or password.\nfatal: Authentication failed for 'https://example.com/repo/'\n", "fetch", "origin"),
+			"IsAuthenticationFailure",
+		},
+		{
+			"lfs pull without git-lfs",
+			gitutil.Error("", "git: 'lfs' is not a git command. See 'git --help'.\n", "lfs", "pull"),
+			"IsLFSNotInstalled",
+		},
+		{
+			"push with no new changes",
+			gitutil.Error("", " ! [remote rejected] HEAD -> refs/for/master (no new changes)\n", "push", "origin", "HEAD:refs/for/master"),
+			"IsNoNewChanges",
+		},
+	}
+	for _, test := range tests {
+		if test.err == nil {
+			t.Errorf("%s: got no error", test.name)
+			continue
+		}
+		for name, predicate := range predicates {
+			if got, want := predicate(test.err), name == test.want; got != want {
+				t.Errorf("%s: %s(%v) = %v, want %v", test.name, name, test.err, got, want)
+			}
+		}
+	}
+	if gitutil.IsUnknownRevision(nil) {
+		t.Errorf("IsUnknownRevision(nil) = true, want false")
+	}
+}
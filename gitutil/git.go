@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"v.io/jiri/runutil"
 )
@@ -29,32 +30,22 @@ func platformSpecificGitArgs(args ...string) []string {
 	return args
 }
 
-type GitError struct {
-	args        []string
-	output      string
-	errorOutput string
-}
-
-func Error(output, errorOutput string, args ...string) GitError {
-	return GitError{
-		args:        args,
-		output:      output,
-		errorOutput: errorOutput,
-	}
-}
+// CredentialHelperEnv is the environment variable that, when set, names a
+// git credential helper that is passed to every git command as
+// "-c credential.helper=<helper>", e.g. so that bots can clone private
+// repositories over HTTPS.
+const CredentialHelperEnv = "JIRI_GIT_CREDENTIAL_HELPER"
 
-func (ge GitError) Error() string {
-	result := "'git "
-	result += strings.Join(ge.args, " ")
-	result += "' failed:\n"
-	result += ge.errorOutput
-	return result
-}
+// nonInteractiveEnv holds the environment of the git commands that are not run
+// interactively.  Disabling terminal prompts makes git fail right away, rather
+// than hang, when a remote asks for credentials that are not configured.
+var nonInteractiveEnv = map[string]string{"GIT_TERMINAL_PROMPT": "0"}
 
 type Git struct {
 	s       runutil.Sequence
 	opts    map[string]string
 	rootDir string
+	timeout time.Duration
 }
 
 type gitOpt interface {
@@ -64,13 +55,20 @@ type AuthorDateOpt string
 type CommitterDateOpt string
 type RootDirOpt string
 
+// TimeoutOpt is the time after which each git command is killed, along with
+// the processes it started, and fails with an error for which IsTimeout
+// returns true.  Zero means no timeout.
+type TimeoutOpt time.Duration
+
 func (AuthorDateOpt) gitOpt()    {}
 func (CommitterDateOpt) gitOpt() {}
 func (RootDirOpt) gitOpt()       {}
+func (TimeoutOpt) gitOpt()       {}
 
 // New is the Git factory.
 func New(s runutil.Sequence, opts ...gitOpt) *Git {
 	rootDir := ""
+	var timeout time.Duration
 	env := map[string]string{}
 	for _, opt := range opts {
 		switch typedOpt := opt.(type) {
@@ -80,12 +78,15 @@ func New(s runutil.Sequence, opts ...gitOpt) *Git {
 			env["GIT_COMMITTER_DATE"] = string(typedOpt)
 		case RootDirOpt:
 			rootDir = string(typedOpt)
+		case TimeoutOpt:
+			timeout = time.Duration(typedOpt)
 		}
 	}
 	return &Git{
 		s:       s,
 		opts:    env,
 		rootDir: rootDir,
+		timeout: timeout,
 	}
 }
 
@@ -119,6 +120,87 @@ func (g *Git) BranchesDiffer(branch1, branch2 string) (bool, error) {
 	return true, nil
 }
 
+// FileChange describes a file changed between two revisions.
+type FileChange struct {
+	// Path is the path of the file, relative to the root of the
+	// repository.
+	Path string
+	// Added is true if the file does not exist in the base revision.
+	Added bool
+	// Deleted is true if the file does not exist in the new revision.
+	Deleted bool
+	// Binary is true if git considers the contents of the file binary.
+	Binary bool
+	// Size is the size in bytes of the file in the new revision, or zero if
+	// it was deleted.
+	Size int64
+}
+
+// ChangedFiles returns the files changed between the <base> and
+// <revision> revisions, in the order reported by git.  Renames are
+// reported as a deletion and an addition.
+func (g *Git) ChangedFiles(base, revision string) ([]FileChange, error) {
+	// Each record of "diff --name-status -z" is a status followed by a path.
+	status, err := g.runOutputZ("diff", "--no-renames", "--name-status", "-z", base, revision)
+	if err != nil {
+		return nil, err
+	}
+	if len(status)%2 != 0 {
+		return nil, fmt.Errorf("unexpected output of git diff --name-status: %q", status)
+	}
+	changes := []FileChange{}
+	index := map[string]int{}
+	for i := 0; i < len(status); i += 2 {
+		path := status[i+1]
+		index[path] = len(changes)
+		changes = append(changes, FileChange{
+			Path:    path,
+			Added:   status[i] == "A",
+			Deleted: status[i] == "D",
+		})
+	}
+	// Binary files are reported by "diff --numstat" with "-" in place of the
+	// numbers of added and deleted lines.
+	numstat, err := g.runOutputZ("diff", "--no-renames", "--numstat", "-z", base, revision)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range numstat {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected output of git diff --numstat: %q", line)
+		}
+		if i, ok := index[fields[2]]; ok && fields[0] == "-" && fields[1] == "-" {
+			changes[i].Binary = true
+		}
+	}
+	// Each record of "ls-tree -l -z" is "<mode> <type> <object> <size>\t<path>".
+	tree, err := g.runOutputZ("ls-tree", "-r", "-l", "-z", revision)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range tree {
+		tab := strings.Index(line, "\t")
+		if tab == -1 {
+			return nil, fmt.Errorf("unexpected output of git ls-tree: %q", line)
+		}
+		i, ok := index[line[tab+1:]]
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(line[:tab])
+		if len(fields) != 4 || fields[3] == "-" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output of git ls-tree: %q", line)
+		}
+		changes[i].Size = size
+	}
+	return changes, nil
+}
+
 // CheckoutBranch checks out the given branch.
 func (g *Git) CheckoutBranch(branch string, opts ...CheckoutOpt) error {
 	args := []string{"checkout"}
@@ -136,9 +218,42 @@ func (g *Git) CheckoutBranch(branch string, opts ...CheckoutOpt) error {
 	return g.run(args...)
 }
 
-// Clone clones the given repository to the given local path.
-func (g *Git) Clone(repo, path string) error {
-	return g.run("clone", repo, path)
+// Clone clones the given repository to the given local path.  With
+// ReferenceOpt, objects are borrowed from the given reference repository if
+// it exists, and with DissociateOpt they are then copied so that the clone
+// does not depend on the reference repository.  With MirrorOpt, a bare
+// mirror of the repository is created.  With FilterOpt, a partial clone is
+// created that omits the objects excluded by the given filter, e.g.
+// "blob:none", and fetches them on demand.  With NoCheckoutOpt, the working
+// tree is not checked out.
+func (g *Git) Clone(repo, path string, opts ...CloneOpt) error {
+	args := []string{"clone"}
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case DissociateOpt:
+			if typedOpt {
+				args = append(args, "--dissociate")
+			}
+		case FilterOpt:
+			if typedOpt != "" {
+				args = append(args, "--filter="+string(typedOpt))
+			}
+		case MirrorOpt:
+			if typedOpt {
+				args = append(args, "--mirror")
+			}
+		case NoCheckoutOpt:
+			if typedOpt {
+				args = append(args, "--no-checkout")
+			}
+		case ReferenceOpt:
+			if typedOpt != "" {
+				args = append(args, "--reference-if-able", string(typedOpt))
+			}
+		}
+	}
+	args = append(args, repo, path)
+	return g.run(args...)
 }
 
 // CloneRecursive clones the given repository recursively to the given local path.
@@ -146,6 +261,22 @@ func (g *Git) CloneRecursive(repo, path string) error {
 	return g.run("clone", "--recursive", repo, path)
 }
 
+// CherryPickInProgress returns a boolean flag that indicates if a
+// cherry-pick operation is in progress for the current repository.
+func (g *Git) CherryPickInProgress() (bool, error) {
+	repoRoot, err := g.TopLevel()
+	if err != nil {
+		return false, err
+	}
+	if _, err := g.s.Stat(filepath.Join(repoRoot, ".git", "CHERRY_PICK_HEAD")); err != nil {
+		if runutil.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // Commit commits all files in staging with an empty message.
 func (g *Git) Commit() error {
 	return g.run("commit", "--allow-empty", "--allow-empty-message", "--no-edit")
@@ -209,6 +340,12 @@ func (g *Git) CommitWithMessageAndEdit(message string) error {
 	return g.runInteractive(args...)
 }
 
+// CommitExists returns whether the given revision names a commit that exists
+// in the local repository.
+func (g *Git) CommitExists(revision string) bool {
+	return g.run("cat-file", "-e", revision+"^{commit}") == nil
+}
+
 // Committers returns a list of committers for the current repository
 // along with the number of their commits.
 func (g *Git) Committers() ([]string, error) {
@@ -246,6 +383,26 @@ func (g *Git) CreateBranch(branch string) error {
 	return g.run("branch", branch)
 }
 
+// CreateTag creates an annotated tag with the given name at the given
+// revision.  The message of the tag defaults to its name.  Unless ForceOpt is
+// given, creating a tag that already exists fails.
+func (g *Git) CreateTag(name, revision string, opts ...TagOpt) error {
+	args := []string{"tag", "--annotate"}
+	message := name
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case ForceOpt:
+			if typedOpt {
+				args = append(args, "--force")
+			}
+		case MessageOpt:
+			message = string(typedOpt)
+		}
+	}
+	args = append(args, "--message", message, name, revision)
+	return g.run(args...)
+}
+
 // CreateAndCheckoutBranch creates a new branch with the given name
 // and checks it out.
 func (g *Git) CreateAndCheckoutBranch(branch string) error {
@@ -258,6 +415,12 @@ func (g *Git) CreateBranchWithUpstream(branch, upstream string) error {
 	return g.run("branch", branch, upstream)
 }
 
+// CreateBundle writes the given refs, along with the commits they point to,
+// to a bundle file, from which they can be fetched.
+func (g *Git) CreateBundle(file string, refs ...string) error {
+	return g.run(append([]string{"bundle", "create", file}, refs...)...)
+}
+
 // CurrentBranchName returns the name of the current branch.
 func (g *Git) CurrentBranchName() (string, error) {
 	out, err := g.runOutput("rev-parse", "--abbrev-ref", "HEAD")
@@ -324,19 +487,29 @@ func (g *Git) Fetch(remote string, opts ...FetchOpt) error {
 	return g.FetchRefspec(remote, "", opts...)
 }
 
+// FetchAll fetches refs and tags from all remotes.
+func (g *Git) FetchAll(opts ...FetchOpt) error {
+	return g.FetchRefspec("--all", "", opts...)
+}
+
 // FetchRefspec fetches refs and tags from the given remote for a particular refspec.
 func (g *Git) FetchRefspec(remote, refspec string, opts ...FetchOpt) error {
 	args := []string{"fetch"}
-	tags := false
+	tags, prune := false, false
 	for _, opt := range opts {
 		switch typedOpt := opt.(type) {
 		case TagsOpt:
 			tags = bool(typedOpt)
+		case PruneOpt:
+			prune = bool(typedOpt)
 		}
 	}
 	if tags {
 		args = append(args, "--tags")
 	}
+	if prune {
+		args = append(args, "--prune")
+	}
 
 	args = append(args, remote)
 	if refspec != "" {
@@ -406,6 +579,33 @@ func (g *Git) Init(path string) error {
 	return g.run("init", path)
 }
 
+// InProgressOperation returns the operation that is in progress for the
+// current repository, and must be finished or aborted before its branches can
+// be changed: "rebase", "merge" or "cherry-pick", or "" if there is none.
+func (g *Git) InProgressOperation() (string, error) {
+	repoRoot, err := g.TopLevel()
+	if err != nil {
+		return "", err
+	}
+	for _, check := range []struct {
+		operation string
+		files     []string
+	}{
+		{"rebase", []string{"rebase-merge", "rebase-apply"}},
+		{"merge", []string{"MERGE_HEAD"}},
+		{"cherry-pick", []string{"CHERRY_PICK_HEAD"}},
+	} {
+		for _, file := range check.files {
+			if _, err := g.s.Stat(filepath.Join(repoRoot, ".git", file)); err == nil {
+				return check.operation, nil
+			} else if !runutil.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+	return "", nil
+}
+
 // IsFileCommitted tests whether the given file has been committed to
 // the repository.
 func (g *Git) IsFileCommitted(file string) bool {
@@ -427,6 +627,19 @@ func (g *Git) LatestCommitMessage() (string, error) {
 	return strings.Join(out, "\n"), nil
 }
 
+// LFSInstall installs the Git LFS hooks and filters in the configuration of
+// the repository, rather than in the global configuration of the user, so
+// that the LFS files of the repository are checked out with their contents.
+func (g *Git) LFSInstall() error {
+	return g.run("lfs", "install", "--local")
+}
+
+// LFSPull fetches the LFS objects of the current revision and replaces the
+// LFS pointer files of the working tree with their contents.
+func (g *Git) LFSPull() error {
+	return g.run("lfs", "pull")
+}
+
 // Log returns a list of commits on <branch> that are not on <base>,
 // using the specified format.
 func (g *Git) Log(branch, base, format string) ([][]string, error) {
@@ -486,6 +699,19 @@ func (g *Git) Merge(branch string, opts ...MergeOpt) error {
 	return nil
 }
 
+// MergeBase returns the best common ancestor of the given revisions.  It
+// returns an error if the revisions share no history.
+func (g *Git) MergeBase(revision1, revision2 string) (string, error) {
+	out, err := g.runOutput("merge-base", revision1, revision2)
+	if err != nil {
+		return "", err
+	}
+	if got, want := len(out), 1; got != want {
+		return "", fmt.Errorf("unexpected length of %v: got %v, want %v", out, got, want)
+	}
+	return out[0], nil
+}
+
 // MergeInProgress returns a boolean flag that indicates if a merge
 // operation is in progress for the current repository.
 func (g *Git) MergeInProgress() (bool, error) {
@@ -515,9 +741,9 @@ func (g *Git) ModifiedFiles(baseBranch, currentBranch string) ([]string, error)
 
 // Pull pulls the given branch from the given remote.
 func (g *Git) Pull(remote, branch string) error {
-	if out, err := g.runOutput("pull", remote, branch); err != nil {
+	if _, err := g.runOutput("pull", remote, branch); err != nil {
 		g.run("reset", "--merge")
-		return fmt.Errorf("%v\n%v", err, strings.Join(out, "\n"))
+		return err
 	}
 	major, minor, err := g.Version()
 	if err != nil {
@@ -580,6 +806,23 @@ func (g *Git) RebaseAbort() error {
 	return g.run("rebase", "--abort")
 }
 
+// RebaseInProgress returns a boolean flag that indicates if a rebase
+// operation is in progress for the current repository.
+func (g *Git) RebaseInProgress() (bool, error) {
+	repoRoot, err := g.TopLevel()
+	if err != nil {
+		return false, err
+	}
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if _, err := g.s.Stat(filepath.Join(repoRoot, ".git", dir)); err == nil {
+			return true, nil
+		} else if !runutil.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
 // Remove removes the given files.
 func (g *Git) Remove(fileNames ...string) error {
 	args := []string{"rm"}
@@ -587,6 +830,23 @@ func (g *Git) Remove(fileNames ...string) error {
 	return g.run(args...)
 }
 
+// RemoteBranches returns the names of the branches of the given remote, as
+// listed by "git ls-remote", in the order of their refs.
+func (g *Git) RemoteBranches(remote string) ([]string, error) {
+	out, err := g.runOutput("ls-remote", "--heads", remote)
+	if err != nil {
+		return nil, err
+	}
+	var branches []string
+	for _, line := range out {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.HasPrefix(fields[1], "refs/heads/") {
+			branches = append(branches, strings.TrimPrefix(fields[1], "refs/heads/"))
+		}
+	}
+	return branches, nil
+}
+
 // RemoteUrl gets the url of the remote with the given name.
 func (g *Git) RemoteUrl(name string) (string, error) {
 	configKey := fmt.Sprintf("remote.%s.url", name)
@@ -620,11 +880,41 @@ func (g *Git) Reset(target string, opts ...ResetOpt) error {
 	return g.run(args...)
 }
 
+// SetConfig sets the given git configuration variable of the repository to
+// the given value.
+func (g *Git) SetConfig(key, value string) error {
+	return g.run("config", key, value)
+}
+
 // SetRemoteUrl sets the url of the remote with given name to the given url.
 func (g *Git) SetRemoteUrl(name, url string) error {
 	return g.run("remote", "set-url", name, url)
 }
 
+// SparseCheckout restricts the working tree to the given directories, and
+// the files at the top of the repository, using a cone-mode sparse checkout.
+// If no directories are given, the sparse checkout is disabled and the whole
+// tree is checked out again.
+func (g *Git) SparseCheckout(dirs []string) error {
+	if len(dirs) == 0 {
+		return g.run("sparse-checkout", "disable")
+	}
+	if err := g.run("sparse-checkout", "init", "--cone"); err != nil {
+		return err
+	}
+	return g.run(append([]string{"sparse-checkout", "set"}, dirs...)...)
+}
+
+// SparseCheckoutEnabled returns whether the working tree is restricted by a
+// sparse checkout.
+func (g *Git) SparseCheckoutEnabled() (bool, error) {
+	out, err := g.runOutput("config", "--bool", "--default", "false", "core.sparseCheckout")
+	if err != nil {
+		return false, err
+	}
+	return len(out) == 1 && out[0] == "true", nil
+}
+
 // Stash attempts to stash any unsaved changes. It returns true if
 // anything was actually stashed, otherwise false. An error is
 // returned if the stash command fails.
@@ -643,6 +933,23 @@ func (g *Git) Stash() (bool, error) {
 	return newSize > oldSize, nil
 }
 
+// StashApply applies the stash entry with the given index, e.g. 0 for the
+// most recent entry, to the current working tree, keeping the entry.
+func (g *Git) StashApply(index int) error {
+	return g.run("stash", "apply", stashRef(index))
+}
+
+// StashDrop removes the stash entry with the given index.
+func (g *Git) StashDrop(index int) error {
+	return g.run("stash", "drop", stashRef(index))
+}
+
+// StashMessages returns the messages of the stash entries, from the most
+// recent to the oldest, e.g. "On master: <message>".
+func (g *Git) StashMessages() ([]string, error) {
+	return g.runOutput("stash", "list", "--format=%gs")
+}
+
 // StashSize returns the size of the stash stack.
 func (g *Git) StashSize() (int, error) {
 	out, err := g.runOutput("stash", "list")
@@ -662,6 +969,44 @@ func (g *Git) StashPop() error {
 	return g.run("stash", "pop")
 }
 
+// StashPush stashes the uncommitted changes and the untracked files with the
+// given message. It returns true if anything was actually stashed.
+func (g *Git) StashPush(message string) (bool, error) {
+	oldSize, err := g.StashSize()
+	if err != nil {
+		return false, err
+	}
+	if err := g.run("stash", "push", "--include-untracked", "--message", message); err != nil {
+		return false, err
+	}
+	newSize, err := g.StashSize()
+	if err != nil {
+		return false, err
+	}
+	return newSize > oldSize, nil
+}
+
+// stashRef returns the name of the stash entry with the given index.
+func stashRef(index int) string {
+	return fmt.Sprintf("stash@{%d}", index)
+}
+
+// TagRevision returns the revision of the commit that the tag with the given
+// name points to, or an empty string if there is no such tag.
+func (g *Git) TagRevision(name string) (string, error) {
+	out, err := g.runOutput("for-each-ref", "--format=%(objectname) %(*objectname)", "refs/tags/"+name)
+	if err != nil {
+		return "", err
+	}
+	if len(out) == 0 {
+		return "", nil
+	}
+	// Annotated tags point to a tag object, whose commit is given by
+	// %(*objectname); lightweight tags point to the commit directly.
+	fields := strings.Fields(out[0])
+	return fields[len(fields)-1], nil
+}
+
 // TopLevel returns the top level path of the current repository.
 func (g *Git) TopLevel() (string, error) {
 	// TODO(sadovsky): If g.rootDir is set, perhaps simply return that?
@@ -669,7 +1014,8 @@ func (g *Git) TopLevel() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return strings.Join(out, "\n"), nil
+	// Git prints the path with forward slashes on Windows too.
+	return filepath.FromSlash(strings.Join(out, "\n")), nil
 }
 
 // TrackedFiles returns the list of files that are tracked.
@@ -690,6 +1036,45 @@ func (g *Git) UntrackedFiles() ([]string, error) {
 	return out, nil
 }
 
+// VerifySignature verifies the signature of the given revision, with "git
+// verify-tag" if it names an annotated tag and "git verify-commit" otherwise,
+// and returns the fingerprints of the signing key and its primary key.  It
+// fails if the revision is not signed or its signature cannot be verified,
+// e.g. because the key is not in the keyring of gpg.
+func (g *Git) VerifySignature(revision string) ([]string, error) {
+	out, err := g.runOutput("cat-file", "-t", revision)
+	if err != nil {
+		return nil, err
+	}
+	command := "verify-commit"
+	if len(out) == 1 && out[0] == "tag" {
+		command = "verify-tag"
+	}
+	var stdout, stderr bytes.Buffer
+	args := []string{command, "--raw", revision}
+	fn := func(s runutil.Sequence) runutil.Sequence { return s.Capture(&stdout, &stderr) }
+	if err := g.runWithFn(fn, args...); err != nil {
+		return nil, g.newError(err, stdout.String(), stderr.String(), args...)
+	}
+	// The status lines of gpg have the form:
+	// [GNUPG:] VALIDSIG <fingerprint> <date> ... <primary key fingerprint>
+	var fingerprints []string
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+		fingerprints = append(fingerprints, fields[2])
+		if primary := fields[len(fields)-1]; len(fields) > 3 && primary != fields[2] {
+			fingerprints = append(fingerprints, primary)
+		}
+	}
+	if len(fingerprints) == 0 {
+		return nil, fmt.Errorf("no valid signature: %s", strings.TrimSpace(stderr.String()))
+	}
+	return fingerprints, nil
+}
+
 // Version returns the major and minor git version.
 func (g *Git) Version() (int, int, error) {
 	out, err := g.runOutput("version")
@@ -709,11 +1094,11 @@ func (g *Git) Version() (int, int, error) {
 	}
 	major, err := strconv.Atoi(version[0])
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed parsing %q to integer", major)
+		return 0, 0, fmt.Errorf("failed parsing %q to integer", version[0])
 	}
 	minor, err := strconv.Atoi(version[1])
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed parsing %q to integer", minor)
+		return 0, 0, fmt.Errorf("failed parsing %q to integer", version[1])
 	}
 	return major, minor, nil
 }
@@ -722,7 +1107,7 @@ func (g *Git) run(args ...string) error {
 	var stdout, stderr bytes.Buffer
 	capture := func(s runutil.Sequence) runutil.Sequence { return s.Capture(&stdout, &stderr) }
 	if err := g.runWithFn(capture, args...); err != nil {
-		return Error(stdout.String(), stderr.String(), args...)
+		return g.newError(err, stdout.String(), stderr.String(), args...)
 	}
 	return nil
 }
@@ -739,29 +1124,54 @@ func (g *Git) runOutput(args ...string) ([]string, error) {
 	var stdout, stderr bytes.Buffer
 	fn := func(s runutil.Sequence) runutil.Sequence { return s.Capture(&stdout, &stderr) }
 	if err := g.runWithFn(fn, args...); err != nil {
-		return nil, Error(stdout.String(), stderr.String(), args...)
+		return nil, g.newError(err, stdout.String(), stderr.String(), args...)
 	}
 	return trimOutput(stdout.String()), nil
 }
 
+// runOutputZ runs the given git command and returns the fields of its
+// output, which are expected to be terminated by NUL characters.
+func (g *Git) runOutputZ(args ...string) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	fn := func(s runutil.Sequence) runutil.Sequence { return s.Capture(&stdout, &stderr) }
+	if err := g.runWithFn(fn, args...); err != nil {
+		return nil, g.newError(err, stdout.String(), stderr.String(), args...)
+	}
+	output := strings.TrimSuffix(stdout.String(), "\x00")
+	if len(output) == 0 {
+		return nil, nil
+	}
+	return strings.Split(output, "\x00"), nil
+}
+
 func (g *Git) runInteractive(args ...string) error {
 	var stderr bytes.Buffer
 	// In order for the editing to work correctly with
 	// terminal-based editors, notably "vim", use os.Stdout.
 	capture := func(s runutil.Sequence) runutil.Sequence { return s.Capture(os.Stdout, &stderr) }
-	if err := g.runWithFn(capture, args...); err != nil {
-		return Error("", stderr.String(), args...)
+	if err := g.runGit(capture, args...); err != nil {
+		return g.newError(err, "", stderr.String(), args...)
 	}
 	return nil
 }
 
 func (g *Git) runWithFn(fn func(s runutil.Sequence) runutil.Sequence, args ...string) error {
-	g.s.Dir(g.rootDir)
-	args = platformSpecificGitArgs(args...)
 	if fn == nil {
 		fn = func(s runutil.Sequence) runutil.Sequence { return s }
 	}
-	return fn(g.s).Env(g.opts).Last("git", args...)
+	nonInteractive := func(s runutil.Sequence) runutil.Sequence { return fn(s).Env(nonInteractiveEnv) }
+	return g.runGit(nonInteractive, args...)
+}
+
+// runGit runs git with the given arguments, preceded by the credential helper
+// named by CredentialHelperEnv, if any.
+func (g *Git) runGit(fn func(s runutil.Sequence) runutil.Sequence, args ...string) error {
+	g.s.Dir(g.rootDir)
+	args = platformSpecificGitArgs(args...)
+	if helper := os.Getenv(CredentialHelperEnv); helper != "" {
+		args = append([]string{"-c", "credential.helper=" + helper}, args...)
+	}
+	return fn(g.s).Env(g.opts).Timeout(g.timeout).Last("git", args...)
 }
 
 // Committer encapsulates the process of create a commit.
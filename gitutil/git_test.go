@@ -0,0 +1,75 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"v.io/jiri/gitutil"
+	"v.io/jiri/runutil"
+)
+
+// fakeGit returns a Git that runs a fake git, which records its arguments and
+// the value of GIT_TERMINAL_PROMPT, and a function that returns the records.
+func fakeGit(t *testing.T) (*gitutil.Git, func() string, func()) {
+	dir, err := ioutil.TempDir("", "fake-git")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	log := filepath.Join(dir, "log")
+	script := "#!/bin/sh\necho \"GIT_TERMINAL_PROMPT=$GIT_TERMINAL_PROMPT $*\" >> " + log + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "git"), []byte(script), 0755); err != nil {
+		cleanup()
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	env := map[string]string{"PATH": dir + string(os.PathListSeparator) + os.Getenv("PATH")}
+	s := runutil.NewSequence(env, os.Stdin, ioutil.Discard, ioutil.Discard, false, false)
+	records := func() string {
+		data, err := ioutil.ReadFile(log)
+		if err != nil {
+			t.Fatalf("ReadFile(%v) failed: %v", log, err)
+		}
+		os.Remove(log)
+		return string(data)
+	}
+	return gitutil.New(s, gitutil.RootDirOpt(dir)), records, cleanup
+}
+
+// TestGitCredentials checks that git commands do not prompt for credentials,
+// unless they are run interactively, and that they use the credential helper
+// named by CredentialHelperEnv.
+func TestGitCredentials(t *testing.T) {
+	git, records, cleanup := fakeGit(t)
+	defer cleanup()
+	oldHelper := os.Getenv(gitutil.CredentialHelperEnv)
+	defer os.Setenv(gitutil.CredentialHelperEnv, oldHelper)
+
+	os.Setenv(gitutil.CredentialHelperEnv, "")
+	if err := git.Fetch("origin"); err != nil {
+		t.Fatalf("Fetch() failed: %v", err)
+	}
+	if got, want := records(), "GIT_TERMINAL_PROMPT=0 fetch origin\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	os.Setenv(gitutil.CredentialHelperEnv, "store")
+	if err := git.Clone("https://example.com/repo", "repo"); err != nil {
+		t.Fatalf("Clone() failed: %v", err)
+	}
+	if got, want := records(), "GIT_TERMINAL_PROMPT=0 -c credential.helper=store clone "; !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want prefix %q", got, want)
+	}
+	if err := git.CommitAndEdit(); err != nil {
+		t.Fatalf("CommitAndEdit() failed: %v", err)
+	}
+	if got, want := records(), "GIT_TERMINAL_PROMPT= -c credential.helper=store commit"; !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want prefix %q", got, want)
+	}
+}
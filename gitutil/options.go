@@ -7,6 +7,9 @@ package gitutil
 type CheckoutOpt interface {
 	checkoutOpt()
 }
+type CloneOpt interface {
+	cloneOpt()
+}
 type CommitOpt interface {
 	commitOpt()
 }
@@ -25,6 +28,17 @@ type PushOpt interface {
 type ResetOpt interface {
 	resetOpt()
 }
+type TagOpt interface {
+	tagOpt()
+}
+
+type DissociateOpt bool
+
+func (DissociateOpt) cloneOpt() {}
+
+type FilterOpt string
+
+func (FilterOpt) cloneOpt() {}
 
 type FollowTagsOpt bool
 
@@ -35,15 +49,33 @@ type ForceOpt bool
 func (ForceOpt) checkoutOpt()     {}
 func (ForceOpt) deleteBranchOpt() {}
 func (ForceOpt) pushOpt()         {}
+func (ForceOpt) tagOpt()          {}
 
 type MessageOpt string
 
 func (MessageOpt) commitOpt() {}
+func (MessageOpt) tagOpt()    {}
+
+type MirrorOpt bool
+
+func (MirrorOpt) cloneOpt() {}
 
 type ModeOpt string
 
 func (ModeOpt) resetOpt() {}
 
+type NoCheckoutOpt bool
+
+func (NoCheckoutOpt) cloneOpt() {}
+
+type PruneOpt bool
+
+func (PruneOpt) fetchOpt() {}
+
+type ReferenceOpt string
+
+func (ReferenceOpt) cloneOpt() {}
+
 type ResetOnFailureOpt bool
 
 func (ResetOnFailureOpt) mergeOpt() {}
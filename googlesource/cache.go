@@ -0,0 +1,156 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package googlesource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+)
+
+const (
+	// CacheDirEnv is the environment variable that, when set, names the
+	// directory that the repo statuses are cached in, e.g. a directory
+	// shared by all jiri roots of a machine.
+	CacheDirEnv = "JIRI_REMOTE_CACHE_DIR"
+	// DefaultCacheTTL is how long a cached response of a host is used for
+	// by default.
+	DefaultCacheTTL = 60 * time.Second
+)
+
+// cacheLockTimeout is how long a Cache waits for another process that is
+// fetching the same repo statuses before fetching them itself.  A lock older
+// than that is left over by a process that died, and is removed.
+var cacheLockTimeout = 2 * RepoStatusesTimeout
+
+// Cache caches the repo statuses returned by GetRepoStatuses on disk, so that
+// jiri processes that update projects in quick succession, e.g. on the
+// executors of a presubmit system, share one request per host and set of
+// branches.  Processes that want the same repo statuses while they are being
+// fetched wait for the response rather than fetching them too.
+//
+// The cache fails open: if it cannot be read or written, or the lock held by
+// another process is not released in time, the repo statuses are fetched
+// from the host.  Cached responses that are older than the TTL, or that
+// cannot be parsed, are ignored.
+type Cache struct {
+	// Dir is the directory the responses are cached in.
+	Dir string
+	// TTL is how long a cached response is used for.
+	TTL time.Duration
+}
+
+// cacheEntry is the on-disk form of a cached response.
+type cacheEntry struct {
+	Host     string       `json:"host"`
+	Branches []string     `json:"branches"`
+	Time     time.Time    `json:"time"`
+	Statuses RepoStatuses `json:"statuses"`
+}
+
+// GetRepoStatuses returns the repo statuses of the given host and branches
+// from the cache, or fetches them with GetRepoStatuses and caches them.
+func (c *Cache) GetRepoStatuses(jirix *jiri.X, host string, branches []string) (RepoStatuses, error) {
+	branches = append([]string(nil), branches...)
+	sort.Strings(branches)
+	file := c.file(host, branches)
+	if statuses := c.read(file, host, branches); statuses != nil {
+		return statuses, nil
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return GetRepoStatuses(jirix, host, branches)
+	}
+	unlock, locked := c.lock(jirix, file)
+	if locked {
+		defer unlock()
+		// Another process may have cached the repo statuses while this
+		// one was waiting for the lock.
+		if statuses := c.read(file, host, branches); statuses != nil {
+			return statuses, nil
+		}
+	}
+	statuses, err := GetRepoStatuses(jirix, host, branches)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		if err := c.write(file, cacheEntry{Host: host, Branches: branches, Time: time.Now(), Statuses: statuses}); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "WARNING: failed to cache the repo statuses of %s: %v\n", host, err)
+		}
+	}
+	return statuses, nil
+}
+
+// file returns the file that the repo statuses of the given host and sorted
+// branches are cached in.
+func (c *Cache) file(host string, branches []string) string {
+	sum := sha256.Sum256([]byte(host + "\x00" + strings.Join(branches, "\x00")))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// read returns the repo statuses cached in the given file for the given host
+// and sorted branches, or nil if there is no usable entry.
+func (c *Cache) read(file, host string, branches []string) RepoStatuses {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil
+	}
+	if entry.Host != host || strings.Join(entry.Branches, "\x00") != strings.Join(branches, "\x00") || entry.Statuses == nil {
+		return nil
+	}
+	if age := time.Since(entry.Time); age < 0 || age >= c.TTL {
+		return nil
+	}
+	return entry.Statuses
+}
+
+// write writes the given entry to the given file.  The file is replaced
+// atomically, so that readers never see a partial entry.
+func (c *Cache) write(file string, entry cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.Dir, filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// lock acquires the lock on the given cache file, waiting for up to
+// cacheLockTimeout for another process to release it.  It returns a function
+// that releases the lock, which is also released if jiri is interrupted, and
+// whether the lock was acquired.
+func (c *Cache) lock(jirix *jiri.X, file string) (func() error, bool) {
+	unlock, err := jirix.LockFile(file+".lock", cacheLockTimeout, cacheLockTimeout)
+	return unlock, err == nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package googlesource
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/tool"
+)
+
+var wantStatuses = RepoStatuses{
+	"proj": RepoStatus{Name: "proj", Branches: map[string]string{"master": "abc"}},
+}
+
+// newRepoStatusesServer returns a fake googlesource host that responds with
+// wantStatuses after the given delay, and counts the requests it receives.
+func newRepoStatusesServer(delay time.Duration) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(delay)
+		fmt.Fprint(w, `)]}'
+{"proj": {"name": "proj", "branches": {"master": "abc"}}}`)
+	}))
+	return server, &requests
+}
+
+func newCacheX(t *testing.T) (*jiri.X, string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jiri.X{Context: tool.NewDefaultContext(), Root: dir}, dir, func() { os.RemoveAll(dir) }
+}
+
+// TestCacheTTL checks that cached responses are used until they are older
+// than the TTL, and that unusable cache entries and directories are ignored.
+func TestCacheTTL(t *testing.T) {
+	jirix, dir, cleanup := newCacheX(t)
+	defer cleanup()
+	server, requests := newRepoStatusesServer(0)
+	defer server.Close()
+
+	cache := &Cache{Dir: filepath.Join(dir, "cache"), TTL: time.Minute}
+	get := func(wantRequests int32) {
+		statuses, err := cache.GetRepoStatuses(jirix, server.URL, []string{"master"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(statuses, wantStatuses) {
+			t.Errorf("got statuses %v, want %v", statuses, wantStatuses)
+		}
+		if got := atomic.LoadInt32(requests); got != wantRequests {
+			t.Errorf("got %v requests, want %v", got, wantRequests)
+		}
+	}
+	get(1)
+	get(1)
+
+	// Responses for other branches are cached separately.
+	if _, err := cache.GetRepoStatuses(jirix, server.URL, []string{"master", "release"}); err != nil {
+		t.Fatal(err)
+	}
+	get(2)
+
+	// An entry older than the TTL is ignored and replaced.
+	file := cache.file(server.URL, []string{"master"})
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	entry.Time = entry.Time.Add(-2 * time.Minute)
+	if err := cache.write(file, entry); err != nil {
+		t.Fatal(err)
+	}
+	get(3)
+	get(3)
+
+	// An entry that cannot be parsed is ignored and replaced.
+	if err := ioutil.WriteFile(file, []byte("{garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	get(4)
+	get(4)
+
+	// A cache directory that cannot be created falls through to the host.
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache.Dir = filepath.Join(dir, "file", "cache")
+	get(5)
+	get(6)
+}
+
+// TestCacheConcurrent checks that concurrent requests for the same repo
+// statuses share one request to the host.  The requests also register the
+// cleanups of their locks concurrently with the same jirix, which the race
+// detector checks when the test is run with -race.
+func TestCacheConcurrent(t *testing.T) {
+	jirix, dir, cleanup := newCacheX(t)
+	defer cleanup()
+	server, requests := newRepoStatusesServer(200 * time.Millisecond)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each cache stands for a different jiri process.
+			cache := &Cache{Dir: dir, TTL: time.Minute}
+			statuses, err := cache.GetRepoStatuses(jirix, server.URL, []string{"master"})
+			if err == nil && !reflect.DeepEqual(statuses, wantStatuses) {
+				err = fmt.Errorf("got statuses %v, want %v", statuses, wantStatuses)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got, want := atomic.LoadInt32(requests), int32(1); got != want {
+		t.Errorf("got %v requests, want %v", got, want)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.lock")); len(matches) != 0 {
+		t.Errorf("got lock files %v, want none", matches)
+	}
+}
@@ -8,6 +8,7 @@
 package googlesource
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -23,6 +24,11 @@ import (
 	"v.io/jiri"
 )
 
+// RepoStatusesTimeout is the time that GetRepoStatuses waits for a response
+// from the remote host.  The repo statuses are only used to speed up updates,
+// so it is kept short to avoid hanging on an unreachable host.
+const RepoStatusesTimeout = 2 * time.Second
+
 // RepoStatus represents the status of a remote repository on googlesource.
 type RepoStatus struct {
 	Name        string            `json:"name"`
@@ -139,12 +145,17 @@ func GetRepoStatuses(jirix *jiri.X, host string, branches []string) (RepoStatuse
 	for _, c := range gitCookies(jirix) {
 		req.AddCookie(c)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	ctx, cancel := context.WithTimeout(context.Background(), RepoStatusesTimeout)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("Do(%v) failed: %v", req, err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading the response from %s failed: %v", host, err)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("got status code %v fetching %s: %s", resp.StatusCode, host, string(body))
 	}
@@ -0,0 +1,102 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Exclusion excludes packages, or some of their tests, from being run.
+type Exclusion struct {
+	// Package is a regular expression that matches the import paths of the
+	// excluded packages as a whole.
+	Package string `json:"package"`
+	// Test is a regular expression that matches the names of the excluded
+	// tests of the packages as a whole.  If it is empty, the packages are
+	// excluded entirely.
+	Test string `json:"test,omitempty"`
+	// OS is a comma-separated list of the operating systems, as named by
+	// GOOS, that the exclusion applies on.  If it is empty, the exclusion
+	// applies on all of them.
+	OS string `json:"os,omitempty"`
+	// Reason describes why the packages or tests are excluded.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Config is the configuration of the test runner, as read from a JSON file
+// with an "exclusions" array of Exclusion objects.
+type Config struct {
+	Exclusions []Exclusion `json:"exclusions"`
+}
+
+// LoadConfig reads the configuration of the test runner from the given
+// file.  A file that does not exist holds the empty configuration.
+func LoadConfig(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("Unmarshal(%v) failed: %v", file, err)
+	}
+	if _, err := compileExclusions(config.Exclusions, runtime.GOOS); err != nil {
+		return nil, fmt.Errorf("bad config %v: %v", file, err)
+	}
+	return &config, nil
+}
+
+// exclusion is an Exclusion that applies on the host, with its regular
+// expressions compiled.
+type exclusion struct {
+	Exclusion
+	pkg, test *regexp.Regexp
+}
+
+// compileExclusions returns the given exclusions that apply on the given
+// operating system, with their regular expressions compiled.
+func compileExclusions(exclusions []Exclusion, goos string) ([]exclusion, error) {
+	var result []exclusion
+	for _, e := range exclusions {
+		if e.Package == "" {
+			return nil, fmt.Errorf("exclusion %+v has no package", e)
+		}
+		pkg, err := regexp.Compile("^(?:" + e.Package + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("bad package regexp %q: %v", e.Package, err)
+		}
+		compiled := exclusion{Exclusion: e, pkg: pkg}
+		if e.Test != "" {
+			if compiled.test, err = regexp.Compile("^(?:" + e.Test + ")$"); err != nil {
+				return nil, fmt.Errorf("bad test regexp %q: %v", e.Test, err)
+			}
+		}
+		if e.OS != "" && !inList(e.OS, goos) {
+			continue
+		}
+		result = append(result, compiled)
+	}
+	return result, nil
+}
+
+// inList returns whether the given comma-separated list includes the given
+// value.
+func inList(list, value string) bool {
+	for _, elem := range strings.Split(list, ",") {
+		if strings.TrimSpace(elem) == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,294 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gotest runs the tests of Go packages with "go test", in parallel
+// and subject to exclusions, and reports their results, optionally as xUnit
+// reports for continuous integration systems.  It only depends on the jiri
+// execution environment, so that it can be used by "jiri test run" as well
+// as by other tools built on jiri.
+package gotest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/tool"
+)
+
+// The statuses of packages and tests.
+const (
+	// StatusPass is the status of packages and tests that passed.
+	StatusPass = "pass"
+	// StatusFail is the status of packages and tests that failed, or of
+	// packages that could not be built.
+	StatusFail = "fail"
+	// StatusSkip is the status of tests that were skipped, and of packages
+	// that have no tests.
+	StatusSkip = "skip"
+	// StatusExcluded is the status of packages that were excluded.
+	StatusExcluded = "excluded"
+)
+
+// Opts holds the options of Run.
+type Opts struct {
+	// Env is the environment that "go" is run with, merged with that of
+	// jirix, e.g. the environment of some profiles.
+	Env map[string]string
+	// Jobs is the number of packages that are tested concurrently.  If it
+	// is not positive, runtime.NumCPU() packages are.
+	Jobs int
+	// Timeout is the timeout of the tests of each package.  If it is zero,
+	// the default timeout of "go test" applies.
+	Timeout time.Duration
+	// Exclusions are the exclusions that apply to the packages.
+	Exclusions []Exclusion
+	// CoverageDir, if set, is the directory that a coverage profile of each
+	// package is written to.
+	CoverageDir string
+	// Args are extra arguments of "go test", e.g. "-race".
+	Args []string
+}
+
+// TestResult is the result of a test of a package.
+type TestResult struct {
+	Name     string
+	Status   string
+	Duration time.Duration
+	Output   string
+}
+
+// PackageResult is the result of testing a package.
+type PackageResult struct {
+	Package  string
+	Status   string
+	Duration time.Duration
+	Tests    []TestResult
+	// Output is the output of testing the package that is not that of any
+	// of its tests, such as build errors.
+	Output string
+	// Reason describes why the package was excluded.
+	Reason string
+	// CoverageFile is the coverage profile of the package, if any.
+	CoverageFile string
+}
+
+// Failed returns whether any of the given results failed.
+func Failed(results []PackageResult) bool {
+	for _, result := range results {
+		if result.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Run tests the packages that the given patterns of "go list" match with
+// "go test", using up to opts.Jobs concurrent workers, and returns the
+// results sorted by package.  Packages and tests that fail do not stop the
+// others and are reported in the results; the error is only set if the
+// packages cannot be listed or the options are invalid.
+func Run(jirix *jiri.X, patterns []string, opts Opts) ([]PackageResult, error) {
+	exclusions, err := compileExclusions(opts.Exclusions, runtime.GOOS)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CoverageDir != "" {
+		if err := jirix.NewSeq().MkdirAll(opts.CoverageDir, 0755).Done(); err != nil {
+			return nil, err
+		}
+	}
+	pkgs, err := listPackages(jirix, patterns, opts.Env)
+	if err != nil {
+		return nil, err
+	}
+	var results []PackageResult
+	var tested []string
+	for _, pkg := range pkgs {
+		if reason, ok := excludedPackage(exclusions, pkg); ok {
+			results = append(results, PackageResult{Package: pkg, Status: StatusExcluded, Reason: reason})
+			continue
+		}
+		tested = append(tested, pkg)
+	}
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	if jobs > len(tested) {
+		jobs = len(tested)
+	}
+	pending := make(chan string, len(tested))
+	done := make(chan PackageResult, len(tested))
+	for i := 0; i < jobs; i++ {
+		// jirix is not threadsafe, so we make a clone for each goroutine.
+		go func(jirix *jiri.X) {
+			for pkg := range pending {
+				done <- testPackage(jirix, pkg, excludedTests(exclusions, pkg), opts)
+			}
+		}(jirix.Clone(tool.ContextOpts{}))
+	}
+	for _, pkg := range tested {
+		pending <- pkg
+	}
+	close(pending)
+	for _ = range tested {
+		results = append(results, <-done)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Package < results[j].Package })
+	return results, nil
+}
+
+// listPackages returns the import paths of the packages that the given
+// patterns match.
+func listPackages(jirix *jiri.X, patterns []string, env map[string]string) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	args := append([]string{"list"}, patterns...)
+	if err := jirix.NewSeq().Env(env).Capture(&stdout, &stderr).Last("go", args...); err != nil {
+		return nil, fmt.Errorf("go list failed: %v\n%s", err, stderr.String())
+	}
+	return strings.Fields(stdout.String()), nil
+}
+
+// excludedPackage returns the reason why the given package is excluded
+// entirely, and whether it is.
+func excludedPackage(exclusions []exclusion, pkg string) (string, bool) {
+	for _, e := range exclusions {
+		if e.test == nil && e.pkg.MatchString(pkg) {
+			return e.Reason, true
+		}
+	}
+	return "", false
+}
+
+// excludedTests returns a regular expression, for the -skip flag of "go
+// test", that matches the excluded tests of the given package, or the empty
+// string if none of them is.
+func excludedTests(exclusions []exclusion, pkg string) string {
+	var tests []string
+	for _, e := range exclusions {
+		if e.test != nil && e.pkg.MatchString(pkg) {
+			tests = append(tests, "(?:"+e.Test+")")
+		}
+	}
+	if len(tests) == 0 {
+		return ""
+	}
+	return "^(?:" + strings.Join(tests, "|") + ")$"
+}
+
+// testPackage tests the given package, skipping the tests that the given
+// regular expression matches, if any.
+func testPackage(jirix *jiri.X, pkg, skip string, opts Opts) PackageResult {
+	args := []string{"test", "-json"}
+	if opts.Timeout > 0 {
+		args = append(args, "-timeout", opts.Timeout.String())
+	}
+	if skip != "" {
+		args = append(args, "-skip", skip)
+	}
+	var coverageFile string
+	if opts.CoverageDir != "" {
+		coverageFile = filepath.Join(opts.CoverageDir, fileName(pkg)+".out")
+		args = append(args, "-coverprofile", coverageFile)
+	}
+	args = append(args, opts.Args...)
+	args = append(args, pkg)
+	var stdout, stderr bytes.Buffer
+	start := time.Now()
+	err := jirix.NewSeq().Env(opts.Env).Capture(&stdout, &stderr).Last("go", args...)
+	result := parseTestEvents(pkg, &stdout)
+	if result.Duration == 0 {
+		result.Duration = time.Since(start)
+	}
+	if err != nil {
+		// Build failures are reported on stderr, and may not be reported
+		// as events.
+		result.Status = StatusFail
+		result.Output += stderr.String()
+	} else if result.Status == "" {
+		result.Status = StatusPass
+	}
+	if result.Status == StatusPass && coverageFile != "" {
+		result.CoverageFile = coverageFile
+	}
+	return result
+}
+
+// testEvent is an event of the output of "go test -json".
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// parseTestEvents returns the result of testing the given package, as
+// described by the given output of "go test -json".  Lines that are not
+// events are part of the output of the package, and tests that never end,
+// e.g. because the package timed out, fail.
+func parseTestEvents(pkg string, output *bytes.Buffer) PackageResult {
+	result := PackageResult{Package: pkg}
+	var tests []*TestResult
+	byName := map[string]*TestResult{}
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var event testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Action == "" {
+			result.Output += scanner.Text() + "\n"
+			continue
+		}
+		if event.Test == "" {
+			switch event.Action {
+			case "output", "build-output":
+				result.Output += event.Output
+			case StatusPass, StatusFail, StatusSkip:
+				result.Status = event.Action
+				result.Duration = seconds(event.Elapsed)
+			}
+			continue
+		}
+		test, ok := byName[event.Test]
+		if !ok {
+			test = &TestResult{Name: event.Test}
+			byName[event.Test] = test
+			tests = append(tests, test)
+		}
+		switch event.Action {
+		case "output":
+			test.Output += event.Output
+		case StatusPass, StatusFail, StatusSkip:
+			test.Status = event.Action
+			test.Duration = seconds(event.Elapsed)
+		}
+	}
+	for _, test := range tests {
+		if test.Status == "" {
+			test.Status = StatusFail
+			result.Status = StatusFail
+		}
+		result.Tests = append(result.Tests, *test)
+	}
+	return result
+}
+
+// seconds returns the duration of the given number of seconds.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// fileName returns the name of the files of the given package in the
+// coverage and xUnit directories.
+func fileName(pkg string) string {
+	return strings.Replace(pkg, "/", "_", -1)
+}
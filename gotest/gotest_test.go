@@ -0,0 +1,187 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gotest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"v.io/jiri/jiritest"
+)
+
+// TestRun checks that Run reports passing, failing, skipped, excluded and
+// unbuildable packages, and that WriteXUnitReports reports them.
+func TestRun(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	gopath := filepath.Join(jirix.Root, "go")
+	files := map[string]string{
+		"a/a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestOK(t *testing.T) {}\n\nfunc TestSkip(t *testing.T) { t.Skip() }\n\nfunc TestExcluded(t *testing.T) { t.Fatal(\"ran\") }\n",
+		"b/b_test.go": "package b\n\nimport \"testing\"\n\nfunc TestFail(t *testing.T) { t.Fatal(\"boom\") }\n",
+		"c/c.go":      "package c\n\nfunc C() { undefined() }\n",
+		"c/c_test.go": "package c\n\nimport \"testing\"\n\nfunc TestC(t *testing.T) {}\n",
+		"d/d.go":      "package d\n",
+		"e/e_test.go": "package e\n\nimport \"testing\"\n\nfunc TestE(t *testing.T) { t.Fatal(\"ran\") }\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(gopath, "src", "example.com", "p", name)
+		if err := jirix.NewSeq().MkdirAll(filepath.Dir(path), 0755).WriteFile(path, []byte(content), 0644).Done(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := Opts{
+		Env:     map[string]string{"GOPATH": gopath, "GO111MODULE": "off", "GOFLAGS": ""},
+		Jobs:    2,
+		Timeout: time.Minute,
+		Exclusions: []Exclusion{
+			{Package: "example.com/p/a", Test: "TestExcluded"},
+			{Package: "example.com/p/e", Reason: "flaky"},
+			{Package: "example.com/p/b", OS: "nosuchos"},
+		},
+		CoverageDir: filepath.Join(jirix.Root, "coverage"),
+	}
+	results, err := Run(jirix, []string{"example.com/p/..."}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	var pkgs []string
+	for _, result := range results {
+		pkgs = append(pkgs, result.Package)
+		got[result.Package] = result.Status
+		for _, test := range result.Tests {
+			got[result.Package+"."+test.Name] = test.Status
+		}
+	}
+	want := map[string]string{
+		"example.com/p/a":          StatusPass,
+		"example.com/p/a.TestOK":   StatusPass,
+		"example.com/p/a.TestSkip": StatusSkip,
+		"example.com/p/b":          StatusFail,
+		"example.com/p/b.TestFail": StatusFail,
+		"example.com/p/c":          StatusFail,
+		"example.com/p/d":          StatusSkip,
+		"example.com/p/e":          StatusExcluded,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statuses %v, want %v", got, want)
+	}
+	if want := []string{"example.com/p/a", "example.com/p/b", "example.com/p/c", "example.com/p/d", "example.com/p/e"}; !reflect.DeepEqual(pkgs, want) {
+		t.Errorf("got packages %v, want %v", pkgs, want)
+	}
+	if !Failed(results) {
+		t.Errorf("got no failures, want some")
+	}
+	if got, want := results[2].Output, "undefined"; !strings.Contains(got, want) {
+		t.Errorf("got output %q, want it to contain %q", got, want)
+	}
+	if _, err := os.Stat(results[0].CoverageFile); err != nil {
+		t.Errorf("got no coverage profile: %v", err)
+	}
+
+	dir := filepath.Join(jirix.Root, "xunit")
+	if err := WriteXUnitReports(jirix, dir, results); err != nil {
+		t.Fatal(err)
+	}
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fileInfos {
+		names = append(names, fi.Name())
+	}
+	if want := []string{"example.com_p_a.xml", "example.com_p_b.xml", "example.com_p_c.xml", "example.com_p_e.xml"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got reports %v, want %v", names, want)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "example.com_p_a.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites xunitTestSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(suites.Suites), 1; got != want {
+		t.Fatalf("got %v suites, want %v", got, want)
+	}
+	if suite := suites.Suites[0]; suite.Tests != 2 || suite.Failures != 0 || suite.Skipped != 1 {
+		t.Errorf("got suite %+v, want 2 tests with 1 skipped", suite)
+	}
+}
+
+// TestParseTestEvents checks that tests that never end, e.g. because the
+// package timed out, fail, and that lines that are not events are kept.
+func TestParseTestEvents(t *testing.T) {
+	output := `{"Action":"run","Package":"p","Test":"TestA"}
+{"Action":"output","Package":"p","Test":"TestA","Output":"=== RUN   TestA\n"}
+{"Action":"run","Package":"p","Test":"TestA/sub"}
+{"Action":"pass","Package":"p","Test":"TestA/sub","Elapsed":0.5}
+not an event
+{"Action":"output","Package":"p","Output":"panic: test timed out after 1s\n"}
+{"Action":"fail","Package":"p","Elapsed":1}
+`
+	result := parseTestEvents("p", bytes.NewBufferString(output))
+	want := PackageResult{
+		Package:  "p",
+		Status:   StatusFail,
+		Duration: time.Second,
+		Tests: []TestResult{
+			{Name: "TestA", Status: StatusFail, Output: "=== RUN   TestA\n"},
+			{Name: "TestA/sub", Status: StatusPass, Duration: 500 * time.Millisecond},
+		},
+		Output: "not an event\npanic: test timed out after 1s\n",
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+}
+
+// TestExclusions checks that exclusions match packages and tests as a whole,
+// and only apply on the operating systems they list.
+func TestExclusions(t *testing.T) {
+	exclusions, err := compileExclusions([]Exclusion{
+		{Package: "v.io/a", Reason: "a"},
+		{Package: "v.io/b/.*", Test: "TestB"},
+		{Package: "v.io/b/c", Test: "TestC.*"},
+		{Package: "v.io/d", OS: "nosuchos, " + runtime.GOOS},
+		{Package: "v.io/e", OS: "nosuchos"},
+	}, runtime.GOOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		pkg, reason string
+		excluded    bool
+		skip        string
+	}{
+		{"v.io/a", "a", true, ""},
+		{"v.io/ab", "", false, ""},
+		{"v.io/b", "", false, ""},
+		{"v.io/b/x", "", false, "^(?:(?:TestB))$"},
+		{"v.io/b/c", "", false, "^(?:(?:TestB)|(?:TestC.*))$"},
+		{"v.io/d", "", true, ""},
+		{"v.io/e", "", false, ""},
+	} {
+		reason, excluded := excludedPackage(exclusions, test.pkg)
+		if reason != test.reason || excluded != test.excluded {
+			t.Errorf("%v: got excluded %v (%q), want %v (%q)", test.pkg, excluded, reason, test.excluded, test.reason)
+		}
+		if got := excludedTests(exclusions, test.pkg); got != test.skip {
+			t.Errorf("%v: got skip %q, want %q", test.pkg, got, test.skip)
+		}
+	}
+	if _, err := compileExclusions([]Exclusion{{Package: "("}}, runtime.GOOS); err == nil {
+		t.Errorf("got no error for a bad regexp, want one")
+	}
+}
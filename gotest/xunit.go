@@ -0,0 +1,138 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gotest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"v.io/jiri"
+)
+
+// xunitTestSuites is the root element of an xUnit report.
+type xunitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []xunitTestSuite `xml:"testsuite"`
+}
+
+type xunitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []xunitTestCase `xml:"testcase"`
+}
+
+type xunitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *xunitFailure `xml:"failure,omitempty"`
+	Skipped   *xunitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type xunitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type xunitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteXUnitReports writes an xUnit report of each of the given results,
+// but those of packages without tests, to a file named after its package in
+// the given directory, with one test case per test.  Packages that were
+// excluded are reported as one skipped test case, and packages that failed
+// to build, or otherwise failed outside of their tests, as one failed test
+// case.
+func WriteXUnitReports(jirix *jiri.X, dir string, results []PackageResult) error {
+	s := jirix.NewSeq()
+	if err := s.MkdirAll(dir, 0755).Done(); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.Status == StatusSkip && len(result.Tests) == 0 {
+			continue
+		}
+		data, err := xml.MarshalIndent(xunitTestSuites{Suites: []xunitTestSuite{xunitSuite(result)}}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("xml.MarshalIndent() failed: %v", err)
+		}
+		data = append([]byte(xml.Header), data...)
+		data = append(data, '\n')
+		file := filepath.Join(dir, fileName(result.Package)+".xml")
+		if err := s.WriteFile(file, data, 0644).Done(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xunitSuite returns the xUnit test suite of the given result.
+func xunitSuite(result PackageResult) xunitTestSuite {
+	suite := xunitTestSuite{Name: result.Package, Time: xunitTime(result.Duration)}
+	addCase := func(testCase xunitTestCase) {
+		suite.Tests++
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+		if testCase.Skipped != nil {
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	switch {
+	case result.Status == StatusExcluded:
+		addCase(xunitTestCase{
+			ClassName: result.Package,
+			Name:      "[excluded]",
+			Time:      xunitTime(0),
+			Skipped:   &xunitSkipped{Message: result.Reason},
+		})
+		return suite
+	case result.Status == StatusFail && !failedTest(result):
+		addCase(xunitTestCase{
+			ClassName: result.Package,
+			Name:      "[build]",
+			Time:      xunitTime(result.Duration),
+			Failure:   &xunitFailure{Message: "package failed", Text: result.Output},
+		})
+	}
+	for _, test := range result.Tests {
+		testCase := xunitTestCase{
+			ClassName: result.Package,
+			Name:      test.Name,
+			Time:      xunitTime(test.Duration),
+			SystemOut: test.Output,
+		}
+		switch test.Status {
+		case StatusFail:
+			testCase.Failure = &xunitFailure{Message: "test failed", Text: test.Output}
+		case StatusSkip:
+			testCase.Skipped = &xunitSkipped{Message: "test skipped"}
+		}
+		addCase(testCase)
+	}
+	return suite
+}
+
+// failedTest returns whether any of the tests of the given result failed.
+func failedTest(result PackageResult) bool {
+	for _, test := range result.Tests {
+		if test.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+func xunitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,170 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiritest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"v.io/jiri/gitutil"
+	"v.io/jiri/project"
+)
+
+// FakeUniverse is a FakeJiriRoot whose remote manifest describes a set of
+// projects, along with helpers to change the state of the remote projects and
+// the remote manifest.  It is meant for tests of code that updates local
+// projects to match their remote counterparts.  The remote projects are real
+// git repositories, so setting up and changing the remote state runs git just
+// as FakeJiriRoot does.
+type FakeUniverse struct {
+	*FakeJiriRoot
+}
+
+// InitialReadme is the content of the README file committed to each project
+// created by NewFakeUniverse.
+const InitialReadme = "initial readme"
+
+// NewFakeUniverse returns a new FakeUniverse whose remote manifest contains the
+// given projects, and a cleanup closure that must be run to cleanup temporary
+// directories and restore the original environment.  Relative project paths
+// are interpreted relative to the fake JIRI_ROOT, and the remote of each
+// project is set to a new remote repository with a single commit that adds a
+// README file containing InitialReadme.  Local copies of the projects are not
+// created until UpdateUniverse is called.
+func NewFakeUniverse(t *testing.T, projects ...project.Project) (*FakeUniverse, func()) {
+	fake, cleanup := NewFakeJiriRoot(t)
+	success := false
+	defer func() {
+		if !success {
+			cleanup()
+		}
+	}()
+	manifest, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := fake.X.NewSeq()
+	for _, p := range projects {
+		projectDir := filepath.Join(fake.remote, p.Name)
+		readme := filepath.Join(projectDir, "README")
+		if err := s.MkdirAll(projectDir, os.FileMode(0700)).Done(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gitutil.New(s).Init(projectDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.WriteFile(readme, []byte(InitialReadme), os.FileMode(0644)).Done(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gitutil.New(s, gitutil.RootDirOpt(projectDir)).CommitFile(readme, "creating README"); err != nil {
+			t.Fatal(err)
+		}
+		fake.Projects[p.Name] = projectDir
+		if !filepath.IsAbs(p.Path) {
+			p.Path = filepath.Join(fake.X.Root, p.Path)
+		}
+		p.Remote = projectDir
+		manifest.Projects = append(manifest.Projects, p)
+	}
+	if err := fake.WriteRemoteManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	success = true
+	return &FakeUniverse{fake}, cleanup
+}
+
+// RemoteProject returns the project with the given name from the remote
+// manifest.  The path of the project, which the manifest records relative to
+// the fake JIRI_ROOT, is returned as an absolute path.
+func (u FakeUniverse) RemoteProject(name string) (project.Project, error) {
+	manifest, err := u.ReadRemoteManifest()
+	if err != nil {
+		return project.Project{}, err
+	}
+	for _, p := range manifest.Projects {
+		if p.Name == name {
+			if !filepath.IsAbs(p.Path) {
+				p.Path = filepath.Join(u.X.Root, p.Path)
+			}
+			return p, nil
+		}
+	}
+	return project.Project{}, fmt.Errorf("project %q not found in the remote manifest", name)
+}
+
+// AddCommit commits the given content to the given file of the remote
+// project with the given name, and returns the revision of the new commit.
+func (u FakeUniverse) AddCommit(name, file, content string) (string, error) {
+	projectDir, ok := u.Projects[name]
+	if !ok {
+		return "", fmt.Errorf("project %q not found", name)
+	}
+	path := filepath.Join(projectDir, file)
+	if err := u.X.NewSeq().WriteFile(path, []byte(content), os.FileMode(0644)).Done(); err != nil {
+		return "", err
+	}
+	git := gitutil.New(u.X.NewSeq(), gitutil.RootDirOpt(projectDir))
+	if err := git.CommitFile(path, "updating "+file); err != nil {
+		return "", err
+	}
+	return git.CurrentRevision()
+}
+
+// MoveProject changes the local path of the project with the given name in
+// the remote manifest.  A relative path is interpreted relative to the fake
+// JIRI_ROOT.
+func (u FakeUniverse) MoveProject(name, path string) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(u.X.Root, path)
+	}
+	return u.updateRemoteProject(name, func(p *project.Project) { p.Path = path })
+}
+
+// SetRevision pins the project with the given name to the given revision in
+// the remote manifest.
+func (u FakeUniverse) SetRevision(name, revision string) error {
+	return u.updateRemoteProject(name, func(p *project.Project) { p.Revision = revision })
+}
+
+// SetRemote changes the remote of the project with the given name in the
+// remote manifest, e.g. to simulate a migration to another host.  The
+// repository of the project is not moved.
+func (u FakeUniverse) SetRemote(name, remote string) error {
+	return u.updateRemoteProject(name, func(p *project.Project) { p.Remote = remote })
+}
+
+// DeleteProject removes the project with the given name from the remote
+// manifest.
+func (u FakeUniverse) DeleteProject(name string) error {
+	return u.updateRemoteProject(name, nil)
+}
+
+// updateRemoteProject applies fn to the project with the given name in the
+// remote manifest, or removes the project if fn is nil.
+func (u FakeUniverse) updateRemoteProject(name string, fn func(*project.Project)) error {
+	manifest, err := u.ReadRemoteManifest()
+	if err != nil {
+		return err
+	}
+	found := false
+	projects := []project.Project{}
+	for _, p := range manifest.Projects {
+		if p.Name == name {
+			found = true
+			if fn == nil {
+				continue
+			}
+			fn(&p)
+		}
+		projects = append(projects, p)
+	}
+	if !found {
+		return fmt.Errorf("project %q not found in the remote manifest", name)
+	}
+	manifest.Projects = projects
+	return u.WriteRemoteManifest(manifest)
+}
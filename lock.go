@@ -0,0 +1,61 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLockTimeout is returned by LockFile if the lock is still held by another
+// process when the timeout expires.
+var ErrLockTimeout = errors.New("timed out waiting for the lock")
+
+// lockPollInterval is how often LockFile tries the lock while waiting.
+var lockPollInterval = 20 * time.Millisecond
+
+// LockFile acquires the lock represented by the file at the given path, which
+// is created exclusively and records the pid of the process holding it.  It
+// waits for up to timeout for another process to release the lock, and
+// returns ErrLockTimeout if it does not.  If stale is not zero, a lock file
+// older than stale is assumed to be left over by a process that died, and is
+// removed.  LockFile returns a function that releases the lock, which is also
+// released if jiri is interrupted.
+func (x *X) LockFile(path string, timeout, stale time.Duration) (func() error, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			// Record the process holding the lock, to help with removing
+			// the locks of processes that died.
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			if err := file.Close(); err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return x.AddCleanup(func() error {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				return nil
+			}), nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if stale != 0 {
+			if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > stale {
+				os.Remove(path)
+				continue
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
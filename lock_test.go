@@ -0,0 +1,67 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"v.io/jiri/tool"
+)
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jiri-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	x := &X{Context: tool.NewDefaultContext()}
+	path := filepath.Join(dir, "lock")
+
+	unlock, err := x.LockFile(path, time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(data)), strconv.Itoa(os.Getpid()); got != want {
+		t.Errorf("got pid %q, want %q", got, want)
+	}
+	// The lock is not acquired while it is held.
+	if _, err := x.LockFile(path, 50*time.Millisecond, 0); err != ErrLockTimeout {
+		t.Errorf("got error %v, want %v", err, ErrLockTimeout)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want the lock file to be removed", err)
+	}
+
+	// A stale lock is removed, and a lock is released by the cleanups.
+	if err := ioutil.WriteFile(path, []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.LockFile(path, 50*time.Millisecond, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.RunCleanups(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want the lock file to be removed", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PointerFileSuffix is the suffix of pointer files.  Pointers, such as the
+// "latest" link of the update history, are symlinks where symlinks are
+// supported.  Elsewhere, e.g. on Windows without developer mode, the pointer
+// <path> is a file named <path>.pointer that contains the target.
+const PointerFileSuffix = ".pointer"
+
+// PointerOpt is an option for WritePointer.
+type PointerOpt interface {
+	pointerOpt()
+}
+
+// PointerFileOpt causes WritePointer to write a pointer file even where
+// symlinks are supported.
+type PointerFileOpt bool
+
+func (PointerFileOpt) pointerOpt() {}
+
+// WritePointer points the pointer at the given path to the given target,
+// which is relative to the directory of the pointer unless it is absolute.
+// The pointer is a symlink, or a pointer file if symlinks cannot be created.
+// The pointer is replaced atomically, and a pointer of the other form is
+// removed.
+func (x *X) WritePointer(path, target string, opts ...PointerOpt) error {
+	pointerFile := false
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case PointerFileOpt:
+			pointerFile = bool(typedOpt)
+		}
+	}
+	if !pointerFile {
+		tmp := path + ".new"
+		if err := x.NewSeq().RemoveAll(tmp).Symlink(target, tmp).Rename(tmp, path).Done(); err == nil {
+			return x.NewSeq().RemoveAll(path + PointerFileSuffix).Done()
+		}
+		// Fall back on a pointer file.
+	}
+	file := path + PointerFileSuffix
+	tmp := file + ".new"
+	if err := x.NewSeq().WriteFile(tmp, []byte(filepath.ToSlash(target)+"\n"), 0644).Rename(tmp, file).Done(); err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return x.NewSeq().Remove(path).Done()
+	}
+	return nil
+}
+
+// ReadPointer returns the target of the pointer at the given path, as passed
+// to WritePointer, whether the pointer is a symlink or a pointer file.  The
+// error satisfies os.IsNotExist if there is no pointer at the path.
+func ReadPointer(path string) (string, error) {
+	fi, err := os.Lstat(path)
+	switch {
+	case err == nil && fi.Mode()&os.ModeSymlink != 0:
+		return os.Readlink(path)
+	case err == nil:
+		return "", fmt.Errorf("%q is not a pointer", path)
+	case !os.IsNotExist(err):
+		return "", err
+	}
+	data, err := ioutil.ReadFile(path + PointerFileSuffix)
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(strings.TrimSpace(string(data))), nil
+}
+
+// ResolvePointer returns the path that the pointer at the given path points
+// to, following any further symlinks or pointers.  A path that is not a
+// pointer, such as a regular file, is returned unchanged.  The error
+// satisfies os.IsNotExist if there is no pointer or file at the path, or if
+// the pointer is dangling.
+func ResolvePointer(path string) (string, error) {
+	// Bound the number of pointers followed, in case of cycles.
+	for i := 0; i < 255; i++ {
+		fi, err := os.Lstat(path)
+		if err == nil && fi.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		target, err := ReadPointer(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", fmt.Errorf("too many levels of pointers at %q", path)
+}
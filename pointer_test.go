@@ -0,0 +1,76 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"v.io/jiri/tool"
+)
+
+// TestPointer checks that pointers can be written as symlinks or pointer
+// files, and that either form is read and resolved.
+func TestPointer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pointer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	x := &X{Context: tool.NewContext(tool.ContextOpts{})}
+	for _, name := range []string{"a", "b"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pointer := filepath.Join(dir, "latest")
+	if _, err := ResolvePointer(pointer); !os.IsNotExist(err) {
+		t.Errorf("got error %v for a missing pointer, want a not-exist error", err)
+	}
+
+	check := func(target string, pointerFile bool) {
+		if got, err := ReadPointer(pointer); err != nil || got != target {
+			t.Errorf("ReadPointer() got %q, %v, want %q", got, err, target)
+		}
+		if got, err := ResolvePointer(pointer); err != nil || got != filepath.Join(dir, target) {
+			t.Errorf("ResolvePointer() got %q, %v, want %q", got, err, filepath.Join(dir, target))
+		}
+		_, symlinkErr := os.Lstat(pointer)
+		_, fileErr := os.Stat(pointer + PointerFileSuffix)
+		if pointerFile && (symlinkErr == nil || fileErr != nil) {
+			t.Errorf("got symlink error %v, pointer file error %v, want only a pointer file", symlinkErr, fileErr)
+		}
+		if !pointerFile && (symlinkErr != nil || fileErr == nil) {
+			t.Errorf("got symlink error %v, pointer file error %v, want only a symlink", symlinkErr, fileErr)
+		}
+	}
+	if err := x.WritePointer(pointer, "a"); err != nil {
+		t.Fatal(err)
+	}
+	check("a", false)
+	// Writing a pointer file replaces the symlink, and vice versa.
+	if err := x.WritePointer(pointer, "b", PointerFileOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	check("b", true)
+	if err := x.WritePointer(pointer, "a"); err != nil {
+		t.Fatal(err)
+	}
+	check("a", false)
+
+	// A path that is not a pointer resolves to itself.
+	if got, err := ResolvePointer(filepath.Join(dir, "a")); err != nil || got != filepath.Join(dir, "a") {
+		t.Errorf("ResolvePointer() got %q, %v, want %q", got, err, filepath.Join(dir, "a"))
+	}
+	// A dangling pointer does not resolve.
+	if err := x.WritePointer(pointer, "c", PointerFileOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolvePointer(pointer); !os.IsNotExist(err) {
+		t.Errorf("got error %v for a dangling pointer, want a not-exist error", err)
+	}
+}
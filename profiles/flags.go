@@ -4,10 +4,24 @@
 
 package profiles
 
-import "flag"
+import (
+	"flag"
+	"strings"
+
+	"v.io/jiri"
+)
 
 const (
 	targetDefValue = "<runtime.GOARCH>-<runtime.GOOS>"
+
+	// NativeTargetName is the target that stands for the architecture and
+	// operating system of the host, at the default version.
+	NativeTargetName = "native"
+
+	// TargetAliasPrefix is the prefix of the jiri config keys that define
+	// target aliases: target-alias.<name>=<arch>-<os>[@<version>] lets
+	// --target=<name> stand for the given target.
+	TargetAliasPrefix = "target-alias."
 )
 
 // RegisterTargetAndEnvFlags registers the commonly used --target and --env
@@ -26,3 +40,29 @@ func RegisterTargetFlag(flags *flag.FlagSet, target *Target) {
 	flags.Var(target, "target", target.Usage())
 	flags.Lookup("target").DefValue = targetDefValue
 }
+
+// TargetAliases returns the target aliases defined by the given jiri config,
+// keyed by alias name.
+func TargetAliases(config jiri.Config) map[string]string {
+	aliases := map[string]string{}
+	for key, value := range config {
+		if strings.HasPrefix(key, TargetAliasPrefix) {
+			aliases[strings.TrimPrefix(key, TargetAliasPrefix)] = value
+		}
+	}
+	return aliases
+}
+
+// ResolveTarget resolves the target alias the given target was set to, if
+// any, using the aliases defined by the jiri config.  It must be called after
+// the command line has been parsed, and before the target is looked up.
+func ResolveTarget(jirix *jiri.X, target *Target) error {
+	if target.Alias() == "" {
+		return nil
+	}
+	config, err := jiri.LoadConfig(jirix.Root)
+	if err != nil {
+		return err
+	}
+	return target.ResolveAlias(TargetAliases(config))
+}
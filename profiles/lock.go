@@ -0,0 +1,40 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profiles
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"v.io/jiri"
+)
+
+const (
+	// lockFileSuffix is the suffix of the lock file that serializes the
+	// writers of a database.  The lock file is next to the database file or
+	// directory, rather than in it, so that it is not read as a database
+	// file.
+	lockFileSuffix = ".lock"
+	// tmpFileSuffix is the suffix of the temporary files that Write renames
+	// to database files.
+	tmpFileSuffix = ".tmp"
+)
+
+// DBLockTimeout is how long Write waits for the lock on a database held by
+// another writer before giving up.
+var DBLockTimeout = 5 * time.Minute
+
+// lockDB acquires the lock on the database at path, waiting for up to
+// DBLockTimeout for other writers to release it.  It returns a function that
+// releases the lock, which is also released if jiri is interrupted.
+func lockDB(jirix *jiri.X, path string) (func() error, error) {
+	lockFile := filepath.Clean(path) + lockFileSuffix
+	unlock, err := jirix.LockFile(lockFile, DBLockTimeout, 0)
+	if err == jiri.ErrLockTimeout {
+		return nil, fmt.Errorf("timed out after %v waiting for the lock %q on the profiles database; if no other jiri profile command is running, remove the lock file and try again", DBLockTimeout, lockFile)
+	}
+	return unlock, err
+}
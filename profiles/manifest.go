@@ -5,6 +5,7 @@
 package profiles
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
 	"os"
@@ -69,6 +70,15 @@ type DB struct {
 	version Version
 	path    string
 	db      map[string]*Profile
+	// removed records the targets removed since the database was read, so
+	// that Write does not restore them when it merges the database on disk.
+	removed []removedTarget
+}
+
+// removedTarget identifies a target removed from the named profile.
+type removedTarget struct {
+	qname  string
+	target Target
 }
 
 // NewDB returns a new instance of a profile database.
@@ -108,6 +118,7 @@ func (pdb *DB) AddProfileTarget(installer, name string, target Target) error {
 			}
 		}
 		pi.targets = InsertTarget(pi.targets, &target)
+		pdb.unremoveUnlocked(qname, &target)
 		return nil
 	}
 	return fmt.Errorf("profile %v is not installed", qname)
@@ -128,6 +139,7 @@ func (pdb *DB) UpdateProfileTarget(installer, name string, target Target) error
 		if target.Match(t) {
 			*t = target
 			t.UpdateTime = time.Now()
+			pdb.unremoveUnlocked(qname, &target)
 			return nil
 		}
 	}
@@ -147,6 +159,7 @@ func (pdb *DB) RemoveProfileTarget(installer, name string, target Target) bool {
 		return true
 	}
 	pi.targets = RemoveTarget(pi.targets, &target)
+	pdb.removed = append(pdb.removed, removedTarget{qname, target})
 	if len(pi.targets) == 0 {
 		delete(pdb.db, qname)
 		return true
@@ -174,6 +187,29 @@ func (pdb *DB) Profiles() []*Profile {
 	return r
 }
 
+// unremoveUnlocked forgets that the given target was removed from the named
+// profile, since it has been added again.
+func (pdb *DB) unremoveUnlocked(qname string, target *Target) {
+	removed := pdb.removed[:0]
+	for _, r := range pdb.removed {
+		if r.qname != qname || !r.target.Match(target) {
+			removed = append(removed, r)
+		}
+	}
+	pdb.removed = removed
+}
+
+// wasRemovedUnlocked returns true if the given target was removed from the
+// named profile since the database was read.
+func (pdb *DB) wasRemovedUnlocked(qname string, target *Target) bool {
+	for _, r := range pdb.removed {
+		if r.qname == qname && r.target.Match(target) {
+			return true
+		}
+	}
+	return false
+}
+
 func (pdb *DB) profilesUnlocked() []string {
 	names := make([]string, 0, len(pdb.db))
 	for name := range pdb.db {
@@ -231,7 +267,9 @@ func getDBFilenames(jirix *jiri.X, path string) (bool, []string, error) {
 	}
 	paths := []string{}
 	for _, fi := range fis {
-		if strings.HasSuffix(fi.Name(), ".prev") {
+		// Skip the previous versions of the files, and the temporary
+		// files that Write renames to the files.
+		if strings.HasSuffix(fi.Name(), ".prev") || strings.HasSuffix(fi.Name(), tmpFileSuffix) {
 			continue
 		}
 		paths = append(paths, filepath.Join(path, fi.Name()))
@@ -247,6 +285,7 @@ func (pdb *DB) Read(jirix *jiri.X, path string) error {
 	pdb.mu.Lock()
 	defer pdb.mu.Unlock()
 	pdb.db = make(map[string]*Profile)
+	pdb.removed = nil
 	isDir, filenames, err := getDBFilenames(jirix, path)
 	if err != nil {
 		return err
@@ -266,9 +305,14 @@ func (pdb *DB) Read(jirix *jiri.X, path string) error {
 			}
 			return err
 		}
+		// Files left empty or truncated, e.g. by a crash, are reported
+		// rather than parsed.
+		if len(bytes.TrimSpace(data)) == 0 {
+			return corruptDBError(filename, fmt.Errorf("the file is empty"))
+		}
 		var schema profilesSchema
 		if err := xml.Unmarshal(data, &schema); err != nil {
-			return fmt.Errorf("Unmarshal(%v) failed: %v", string(data), err)
+			return corruptDBError(filename, err)
 		}
 		if isDir {
 			if schema.Version < V5 {
@@ -306,9 +350,21 @@ func (pdb *DB) Read(jirix *jiri.X, path string) error {
 	return nil
 }
 
+// corruptDBError returns the error reported for a database file that cannot
+// be parsed.
+func corruptDBError(filename string, err error) error {
+	return fmt.Errorf("profiles database file %q is corrupt: %v; restore it from %q if that exists, or remove it and reinstall the profiles it listed", filename, err, filename+".prev")
+}
+
 // Write writes the current set of installed profiles to the specified
 // database location. No data will be written and an error returned if the
 // path is a directory and installer is an empty string.
+//
+// Concurrent writers, e.g. profile installers run in parallel, are
+// serialized by a lock file next to the database.  While holding the lock,
+// Write reads the database again and merges the profiles and targets that
+// were added to it since the receiver was read, other than those that the
+// receiver removed, so that concurrent installs are all recorded.
 func (pdb *DB) Write(jirix *jiri.X, installer, path string) error {
 	pdb.mu.Lock()
 	defer pdb.mu.Unlock()
@@ -317,6 +373,15 @@ func (pdb *DB) Write(jirix *jiri.X, installer, path string) error {
 		return fmt.Errorf("please specify a profiles database path")
 	}
 
+	unlock, err := lockDB(jirix, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := pdb.mergeUnlocked(jirix, path); err != nil {
+		return err
+	}
+
 	s := jirix.NewSeq()
 	isdir, err := s.IsDir(path)
 	if err != nil && !runutil.IsNotExist(err) {
@@ -365,12 +430,19 @@ func (pdb *DB) Write(jirix *jiri.X, installer, path string) error {
 		return fmt.Errorf("MarshalIndent() failed: %v", err)
 	}
 
+	// The new file replaces the old one atomically, so that readers, which
+	// do not take the lock, never see a partial or missing file.
 	oldName := filename + ".prev"
-	newName := filename + fmt.Sprintf(".%d", time.Now().UnixNano())
+	newName := filename + fmt.Sprintf(".%d", time.Now().UnixNano()) + tmpFileSuffix
 
-	if err := s.WriteFile(newName, data, defaultFileMode).
-		AssertFileExists(filename).
-		Rename(filename, oldName).Done(); err != nil && !runutil.IsNotExist(err) {
+	if err := s.WriteFile(newName, data, defaultFileMode).Done(); err != nil {
+		return err
+	}
+	if old, err := s.ReadFile(filename); err == nil {
+		if err := s.WriteFile(oldName, old, defaultFileMode).Done(); err != nil {
+			return err
+		}
+	} else if !runutil.IsNotExist(err) {
 		return err
 	}
 	if err := s.Rename(newName, filename).Done(); err != nil {
@@ -379,6 +451,34 @@ func (pdb *DB) Write(jirix *jiri.X, installer, path string) error {
 	return nil
 }
 
+// mergeUnlocked reads the database at path and adds the profiles and targets
+// in it that the receiver does not have and did not remove.  The targets of
+// the receiver take precedence over those with the same version read from
+// the database.
+func (pdb *DB) mergeUnlocked(jirix *jiri.X, path string) error {
+	onDisk := NewDB()
+	if err := onDisk.Read(jirix, path); err != nil {
+		return err
+	}
+	for qname, profile := range onDisk.db {
+		current := pdb.db[qname]
+		if current == nil {
+			current = &Profile{name: profile.name, installer: profile.installer, root: profile.root}
+		}
+		for _, target := range profile.targets {
+			if pdb.wasRemovedUnlocked(qname, target) || FindTarget(current.targets, target) != nil {
+				continue
+			}
+			current.targets = InsertTarget(current.targets, target)
+		}
+		// Profiles whose targets were all removed stay removed.
+		if pdb.db[qname] == nil && (len(current.targets) > 0 || len(profile.targets) == 0) {
+			pdb.db[qname] = current
+		}
+	}
+	return nil
+}
+
 // SchemaVersion returns the version of the xml schema used to implement
 // the database.
 func (pdb *DB) SchemaVersion() Version {
@@ -7,13 +7,16 @@ package profiles_test
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"v.io/jiri/jiritest"
 	"v.io/jiri/profiles"
@@ -175,6 +178,128 @@ func TestWriteDir(t *testing.T) {
 	}
 }
 
+func TestConcurrentWrites(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "pdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Simulate installers that each read the database, install a target of
+	// a shared profile and a profile of their own, and write the database,
+	// all at the same time.
+	const installers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, installers)
+	for i := 0; i < installers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pdb := profiles.NewDB()
+			if err := pdb.Read(jirix, dir); err != nil {
+				errs <- err
+				return
+			}
+			target, _ := profiles.NewTarget(fmt.Sprintf("cpu%d-os@1", i))
+			pdb.InstallProfile("test", "shared", "")
+			if err := pdb.AddProfileTarget("test", "shared", target); err != nil {
+				errs <- err
+				return
+			}
+			name := fmt.Sprintf("p%02d", i)
+			pdb.InstallProfile("test", name, "")
+			if err := pdb.AddProfileTarget("test", name, target); err != nil {
+				errs <- err
+				return
+			}
+			errs <- pdb.Write(jirix, "test", dir)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pdb := profiles.NewDB()
+	if err := pdb.Read(jirix, dir); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(pdb.Profiles()), installers+1; got != want {
+		t.Errorf("got %v profiles, want %v", got, want)
+	}
+	if got, want := len(pdb.LookupProfile("test", "shared").Targets()), installers; got != want {
+		t.Errorf("got %v targets, want %v", got, want)
+	}
+	if _, err := os.Stat(dir + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file was not removed: %v", err)
+	}
+
+	// A target removed by a writer is not restored from the database.
+	target, _ := profiles.NewTarget("cpu0-os@1")
+	pdb.RemoveProfileTarget("test", "p00", target)
+	pdb.RemoveProfileTarget("test", "shared", target)
+	if err := pdb.Write(jirix, "test", dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.Read(jirix, dir); err != nil {
+		t.Fatal(err)
+	}
+	if pdb.LookupProfile("test", "p00") != nil {
+		t.Errorf("removed profile p00 was restored")
+	}
+	if pdb.LookupProfileTarget("test", "shared", target) != nil {
+		t.Errorf("removed target %v was restored", target)
+	}
+}
+
+func TestWriteLockTimeout(t *testing.T) {
+	pdb := profiles.NewDB()
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	filename := tmpFile()
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	timeout := profiles.DBLockTimeout
+	profiles.DBLockTimeout = 100 * time.Millisecond
+	defer func() { profiles.DBLockTimeout = timeout }()
+	if err := ioutil.WriteFile(filename+".lock", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	addProfileAndTargets(t, pdb, "b")
+	if err := pdb.Write(jirix, "test", filename); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+	if exists(t, filename) {
+		t.Errorf("%q was written without the lock", filename)
+	}
+}
+
+func TestReadCorrupt(t *testing.T) {
+	pdb := profiles.NewDB()
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	filename := tmpFile()
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	for _, data := range []string{"", "\n", "<profiles version=\"5\"><profile name="} {
+		if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := pdb.Read(jirix, filename); err == nil || !strings.Contains(err.Error(), "is corrupt") || !strings.Contains(err.Error(), ".prev") {
+			t.Errorf("%q: got error %v, want a corrupt database error", data, err)
+		}
+		addProfileAndTargets(t, pdb, "b")
+		if err := pdb.Write(jirix, "test", filename); err == nil || !strings.Contains(err.Error(), "is corrupt") {
+			t.Errorf("%q: got error %v, want a corrupt database error", data, err)
+		}
+	}
+}
+
 func TestRead(t *testing.T) {
 	pdb := profiles.NewDB()
 	jirix, cleanup := jiritest.NewX(t)
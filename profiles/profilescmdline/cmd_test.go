@@ -13,5 +13,5 @@ func Reset() {
 	cmdList.Runner = jiri.RunnerFunc(runList)
 	cmdEnv = newCmdEnv()
 	listFlags.ReaderFlagValues = nil
-	envFlags.ReaderFlagValues = nil
+	envFlags = envFlagValues{}
 }
@@ -0,0 +1,108 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profilescmdline
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var exportVars = map[string]string{
+	"EMPTY":    "",
+	"SPACES":   "  a  b  ",
+	"DQUOTES":  `say "hi"`,
+	"SQUOTES":  `it's 'quoted'`,
+	"DOLLARS":  "$HOME ${PATH} $(echo no) `echo no`",
+	"ESCAPES":  `back\slash \" \\ \$ \n`,
+	"NEWLINES": "line1\nline2\n\n",
+	"MIXED":    "a\"b'c$d`e\\f\ng!h*i",
+}
+
+// sourceVar sources the given script with the given shell and returns the
+// value of the given environment variable, as seen by a child process.
+func sourceVar(t *testing.T, shell, script, name string) string {
+	var cmd *exec.Cmd
+	switch shell {
+	case "sh":
+		cmd = exec.Command("sh", "-c", `. "$0" && exec printenv "$1"`, script, name)
+	case "fish":
+		cmd = exec.Command("fish", "-c", "source $argv[1]; and exec printenv $argv[2]", script, name)
+	}
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=/nonexistent"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("sourcing %s script for %s failed: %v", shell, name, err)
+	}
+	// printenv terminates the value with a newline.
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+func TestExportScriptRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, shell := range []string{"sh", "fish"} {
+		if _, err := exec.LookPath(shell); err != nil {
+			t.Logf("%s not found, skipping", shell)
+			continue
+		}
+		data, err := exportScript(exportVars, nil, shell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script := filepath.Join(dir, shell+".env")
+		if err := writeFileAtomically(script, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range exportVars {
+			if got := sourceVar(t, shell, script, name); got != want {
+				t.Errorf("%s: %s: got %q, want %q", shell, name, got, want)
+			}
+		}
+	}
+	// Only the temporary files renamed to the scripts were created.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".env") {
+			t.Errorf("unexpected file %q", fi.Name())
+		}
+	}
+}
+
+func TestExportScript(t *testing.T) {
+	vars := map[string]string{"B": "b", "A": "a", "C": `"c"`}
+	for _, tc := range []struct {
+		args   []string
+		format string
+		want   string
+	}{
+		{nil, "sh", "export A=\"a\"\nexport B=\"b\"\nexport C=\"\\\"c\\\"\"\n"},
+		{[]string{"C=", "A", "X"}, "sh", "export C=\"\\\"c\\\"\"\nexport A=\"a\"\n"},
+		{[]string{"C", "B"}, "fish", "set -x C '\"c\"'\nset -x B 'b'\n"},
+	} {
+		got, err := exportScript(vars, tc.args, tc.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%v, %v: got %q, want %q", tc.args, tc.format, got, tc.want)
+		}
+	}
+	if _, err := exportScript(map[string]string{"A-B": "x"}, nil, "sh"); err == nil {
+		t.Errorf("expected an error for an invalid name")
+	}
+	if _, err := exportScript(vars, nil, "csh"); err == nil {
+		t.Errorf("expected an error for an unsupported format")
+	}
+}
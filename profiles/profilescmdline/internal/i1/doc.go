@@ -101,7 +101,13 @@ The global flags are:
 
 Jiri profile-i1 install - Install the given profiles
 
-Install the given profiles.
+Install the given profiles. With -dry-run, print the plan of the installation of
+each profile instead: the commands it would run, the URLs it would download and
+their destinations, the file system changes it would make and the environment
+variables it would set, grouped by installation step. Profiles whose
+installation depends on the output of commands, which are not run, are planned
+up to that point, which is marked as indeterminate; the profiles database is not
+changed.
 
 Usage:
    jiri profile-i1 install [flags] <profiles>
@@ -109,10 +115,20 @@ Usage:
 <profiles> is a list of profiles to install.
 
 The jiri profile-i1 install flags are:
+ -dry-run=false
+   print the commands, downloads, file system changes and environment variables
+   that installing the profiles would run, fetch, make and set, without
+   installing them
  -env=
    specify an environment variable in the form: <var>=[<val>],...
  -force=false
    force install the profile even if it is already installed
+ -mirror=
+   base URL of a mirror to fetch profile downloads from, overrides
+   $JIRI_PROFILE_MIRROR
+ -mirror-strict=false
+   fail rather than fall back to the original URL if a download is not found on
+   the mirror
  -profiles-db=$JIRI_ROOT/.jiri_root/profile_db
    the path, relative to JIRI_ROOT, that contains the profiles database.
  -profiles-dir=.jiri_root/profiles
@@ -203,7 +219,11 @@ Jiri profile-i1 cleanup - Cleanup the locally installed profiles
 
 Cleanup the locally installed profiles. This is generally required when
 recovering from earlier bugs or when preparing for a subsequent change to the
-profiles implementation.
+profiles implementation. With -gc, the disk space reclaimed by removing the
+installation directories of the old targets is reported; installation
+directories that are shared with the targets that are kept are not removed, and
+are not counted. Use -dry-run to report the disk space that would be reclaimed
+without removing anything.
 
 Usage:
    jiri profile-i1 cleanup [flags] <profiles>
@@ -212,6 +232,9 @@ Usage:
 cleaned.
 
 The jiri profile-i1 cleanup flags are:
+ -dry-run=false
+   with -gc, report the disk space that would be reclaimed without uninstalling
+   any targets
  -gc=false
    uninstall profile targets that are older than the current default
  -profiles-db=$JIRI_ROOT/.jiri_root/profile_db
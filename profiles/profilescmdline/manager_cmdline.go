@@ -34,6 +34,9 @@ import (
 	"v.io/jiri"
 	"v.io/jiri/profiles"
 	"v.io/jiri/profiles/profilesmanager"
+	"v.io/jiri/profiles/profilesutil"
+	"v.io/jiri/runutil"
+	"v.io/jiri/tool"
 	"v.io/x/lib/cmdline"
 	"v.io/x/lib/lookpath"
 )
@@ -56,7 +59,7 @@ func newCmdInstall() *cmdline.Command {
 		Runner:   jiri.RunnerFunc(runInstall),
 		Name:     "install",
 		Short:    "Install the given profiles",
-		Long:     "Install the given profiles.",
+		Long:     "Install the given profiles. With -dry-run, print the plan of the installation of each profile instead: the commands it would run, the URLs it would download and their destinations, the file system changes it would make and the environment variables it would set, grouped by installation step. Profiles whose installation depends on the output of commands, which are not run, are planned up to that point, which is marked as indeterminate; the profiles database is not changed.",
 		ArgsName: "<profiles>",
 		ArgsLong: "<profiles> is a list of profiles to install.",
 	}
@@ -92,7 +95,7 @@ func newCmdCleanup() *cmdline.Command {
 		Runner:   jiri.RunnerFunc(runCleanup),
 		Name:     "cleanup",
 		Short:    "Cleanup the locally installed profiles",
-		Long:     "Cleanup the locally installed profiles. This is generally required when recovering from earlier bugs or when preparing for a subsequent change to the profiles implementation.",
+		Long:     "Cleanup the locally installed profiles. This is generally required when recovering from earlier bugs or when preparing for a subsequent change to the profiles implementation. With -gc, the disk space reclaimed by removing the installation directories of the old targets is reported; installation directories that are shared with the targets that are kept are not removed, and are not counted. Use -dry-run to report the disk space that would be reclaimed without removing anything.",
 		ArgsName: "<profiles>",
 		ArgsLong: "<profiles> is a list of profiles to cleanup, if omitted all profiles are cleaned.",
 	}
@@ -141,7 +144,13 @@ type commonFlagValues struct {
 
 func initCommon(flags *flag.FlagSet, c *commonFlagValues, installer, defaultDBPath, defaultProfilesPath string) {
 	RegisterDBPathFlag(flags, &c.dbPath, defaultDBPath)
-	flags.StringVar(&c.root, "profiles-dir", defaultProfilesPath, "the directory, relative to JIRI_ROOT, that profiles are installed in")
+	RegisterProfilesDirFlag(flags, &c.root, defaultProfilesPath)
+}
+
+// RegisterProfilesDirFlag registers the --profiles-dir flag with the supplied
+// FlagSet.
+func RegisterProfilesDirFlag(flags *flag.FlagSet, dir *string, defaultProfilesPath string) {
+	flags.StringVar(dir, "profiles-dir", defaultProfilesPath, "the directory, relative to JIRI_ROOT, that profiles are installed in")
 }
 
 func (cv *commonFlagValues) args() []string {
@@ -188,12 +197,21 @@ type installFlagValues struct {
 	target profiles.Target
 	// The value of --force
 	force bool
+	// The value of --mirror
+	mirror string
+	// The value of --mirror-strict
+	mirrorStrict bool
+	// The value of --dry-run
+	dryRun bool
 }
 
 func initInstallCommand(flags *flag.FlagSet, installer, defaultDBPath, defaultProfilesPath string) {
 	initCommon(flags, &installFlags.commonFlagValues, installer, defaultDBPath, defaultProfilesPath)
 	profiles.RegisterTargetAndEnvFlags(flags, &installFlags.target)
 	flags.BoolVar(&installFlags.force, "force", false, "force install the profile even if it is already installed")
+	flags.BoolVar(&installFlags.dryRun, "dry-run", false, "print the commands, downloads, file system changes and environment variables that installing the profiles would run, fetch, make and set, without installing them")
+	flags.StringVar(&installFlags.mirror, "mirror", "", "base URL of a mirror to fetch profile downloads from, overrides $"+profilesutil.MirrorEnv)
+	flags.BoolVar(&installFlags.mirrorStrict, "mirror-strict", false, "fail rather than fall back to the original URL if a download is not found on the mirror")
 	for _, name := range profilesmanager.Managers() {
 		profilesmanager.LookupManager(name).AddFlags(flags, profiles.Install)
 	}
@@ -207,7 +225,19 @@ func (iv *installFlagValues) args() []string {
 	if e := iv.target.CommandLineEnv().String(); e != "" {
 		a = append(a, "--target="+e)
 	}
-	return append(a, fmt.Sprintf("--%s=%v", "force", iv.force))
+	a = append(a, fmt.Sprintf("--%s=%v", "force", iv.force))
+	if iv.mirror != "" {
+		a = append(a, "--mirror="+iv.mirror)
+	}
+	// Only pass --mirror-strict and --dry-run if set, so that installers
+	// that predate them can still be used for actual installations.
+	if iv.mirrorStrict {
+		a = append(a, "--mirror-strict")
+	}
+	if iv.dryRun {
+		a = append(a, "--dry-run")
+	}
+	return a
 }
 
 type uninstallFlagValues struct {
@@ -244,6 +274,8 @@ type cleanupFlagValues struct {
 	commonFlagValues
 	// The value of --gc
 	gc bool
+	// The value of --dry-run
+	dryRun bool
 	// The value of --rewrite-profiles-db
 	rewriteDB bool
 	// The value of --rm-all
@@ -255,6 +287,7 @@ type cleanupFlagValues struct {
 func initCleanupCommand(flags *flag.FlagSet, installer, defaultDBPath, defaultProfilesPath string) {
 	initCommon(flags, &cleanupFlags.commonFlagValues, installer, defaultDBPath, defaultProfilesPath)
 	flags.BoolVar(&cleanupFlags.gc, "gc", false, "uninstall profile targets that are older than the current default")
+	flags.BoolVar(&cleanupFlags.dryRun, "dry-run", false, "with -gc, report the disk space that would be reclaimed without uninstalling any targets")
 	flags.BoolVar(&cleanupFlags.rmAll, "rm-all", false, "remove profiles database and all profile generated output files.")
 	flags.BoolVar(&cleanupFlags.rewriteDB, "rewrite-profiles-db", false, "rewrite the profiles database to use the latest schema version")
 	flags.BoolVar(&cleanupFlags.verbose, "v", false, "print more detailed information")
@@ -263,6 +296,7 @@ func initCleanupCommand(flags *flag.FlagSet, installer, defaultDBPath, defaultPr
 func (cv *cleanupFlagValues) args() []string {
 	return append(cv.commonFlagValues.args(),
 		fmt.Sprintf("--%s=%v", "gc", cv.gc),
+		fmt.Sprintf("--%s=%v", "dry-run", cv.dryRun),
 		fmt.Sprintf("--%s=%v", "rewrite-profiles-db", cv.rewriteDB),
 		fmt.Sprintf("--%s=%v", "v", cv.verbose),
 		fmt.Sprintf("--%s=%v", "rm-all", cv.rmAll))
@@ -478,13 +512,16 @@ func cleanupImpl(jirix *jiri.X, cl *cleanupFlagValues, args []string) error {
 			return err
 		}
 	}
-	if !cl.rmAll {
+	if !cl.rmAll && !(cl.gc && cl.dryRun) {
 		return writeDB(jirix, db, profileInstaller, cl.dbPath)
 	}
 	return nil
 }
 
 func packagesImpl(jirix *jiri.X, cl *packagesFlagValues, args []string) error {
+	if err := profiles.ResolveTarget(jirix, &cl.target); err != nil {
+		return err
+	}
 	mgrs, _, err := availableProfileManagers(jirix, cl.dbPath, args)
 	if err != nil {
 		return err
@@ -514,11 +551,22 @@ func packagesImpl(jirix *jiri.X, cl *packagesFlagValues, args []string) error {
 }
 
 func installImpl(jirix *jiri.X, cl *installFlagValues, args []string) error {
+	if err := profiles.ResolveTarget(jirix, &cl.target); err != nil {
+		return err
+	}
 	mgrs, db, err := availableProfileManagers(jirix, cl.dbPath, args)
 	if err != nil {
 		return err
 	}
 	cl.target.UseCommandLineEnv()
+	// Make the mirror settings available to in-process installers via the
+	// environment; external installers receive them as flags.
+	if cl.mirror != "" {
+		jirix.Env()[profilesutil.MirrorEnv] = cl.mirror
+	}
+	if cl.mirrorStrict {
+		jirix.Env()[profilesutil.MirrorStrictEnv] = "true"
+	}
 	newMgrs := []profileManager{}
 	for _, mgr := range mgrs {
 		name := mgr.mgrName()
@@ -532,6 +580,15 @@ func installImpl(jirix *jiri.X, cl *installFlagValues, args []string) error {
 		newMgrs = append(newMgrs, mgr)
 	}
 	root := jiri.NewRelPath(cl.root).Join(profileInstaller)
+	if cl.dryRun {
+		// The database is left untouched by a dry run.
+		for _, mgr := range newMgrs {
+			if err := dryRunInstall(jirix, cl, root, mgr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	for _, mgr := range newMgrs {
 		if err := mgr.install(jirix, cl, root); err != nil {
 			return err
@@ -540,7 +597,62 @@ func installImpl(jirix *jiri.X, cl *installFlagValues, args []string) error {
 	return writeDB(jirix, db, profileInstaller, cl.dbPath)
 }
 
+// dryRunInstall prints the plan of the installation of the given profile: the
+// commands, downloads, file system changes and environment variables that
+// installing it would run, fetch, make and set.  In-process profile managers
+// are run with sequences that record these actions instead of performing
+// them; installer subcommands are run with --dry-run and print their own
+// plans.  A failure of the installation is reported as the point where the
+// plan becomes indeterminate rather than as an error, since it may be caused
+// by a recorded action not having been performed.
+func dryRunInstall(jirix *jiri.X, cl *installFlagValues, root jiri.RelPath, mgr profileManager) error {
+	ip, ok := mgr.(*inproc)
+	if !ok {
+		return mgr.install(jirix, cl, root)
+	}
+	pmgr := profilesmanager.LookupManager(ip.qname)
+	if pmgr == nil {
+		return fmt.Errorf("profile %v is not available via this installer %q", ip.qname, ip.installer)
+	}
+	def, err := targetAtDefaultVersion(pmgr, cl.target)
+	if err != nil {
+		return err
+	}
+	rec := runutil.NewRecorder()
+	if err := pmgr.Install(jirix.Clone(tool.ContextOpts{Recorder: rec}), ip.db, root, def); err != nil {
+		rec.Indeterminate("the installation failed: %v", err)
+	} else if target := ip.db.LookupProfileTarget(pmgr.Installer(), pmgr.Name(), def); target != nil {
+		for _, v := range target.Env.Vars {
+			rec.Record(runutil.EnvAction, "%s", v)
+		}
+	}
+	fmt.Fprintf(jirix.Stdout(), "Plan to install %s %s:\n", ip.qname, def)
+	rec.WritePlan(jirix.Stdout())
+	return nil
+}
+
+// InstallProfiles installs the given profiles for the given target, unless
+// they are already installed, as "profile install" does when given the
+// database path and profiles directory as its --profiles-db and --profiles-dir
+// flags.
+func InstallProfiles(jirix *jiri.X, dbPath, profilesDir string, target profiles.Target, names ...string) error {
+	cl := &installFlagValues{
+		commonFlagValues: commonFlagValues{dbPath: dbPath, root: profilesDir},
+		target:           target,
+	}
+	return installImpl(jirix, cl, names)
+}
+
+// AvailableProfiles returns the names of the profiles that can be installed,
+// whether by this process or by a "jiri-profile-<installer>" subcommand.
+func AvailableProfiles(jirix *jiri.X) ([]string, error) {
+	return allAvailableManagers(jirix)
+}
+
 func uninstallImpl(jirix *jiri.X, cl *uninstallFlagValues, args []string) error {
+	if err := profiles.ResolveTarget(jirix, &cl.target); err != nil {
+		return err
+	}
 	mgrs, db, err := availableProfileManagers(jirix, cl.dbPath, args)
 	if err != nil {
 		return err
@@ -245,6 +245,33 @@ func TestManagerInstallUninstall(t *testing.T) {
 	cmpFiles(t, i2, filepath.Join("testdata", "i2b.xml"))
 }
 
+func TestManagerInstallDryRun(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	dir, sh := buildInstallers(t), gosh.NewShell(t)
+	createProfilesDB(t, fake.X)
+	sh.Vars["JIRI_ROOT"] = fake.X.Root
+	sh.Vars["PATH"] = envvar.PrependUniqueToken(sh.Vars["PATH"], ":", dir)
+
+	out := run(sh, dir, "jiri", "profile", "install", "--dry-run", "--target=arch-os", "i1:eg")
+	tdir := filepath.Join(fake.X.Root, jiri.ProfilesRootDir, "i1", "eg", "arch_os")
+	for _, want := range []string{
+		"Plan to install i1:eg arch-os@3",
+		fmt.Sprintf("mkdir -p %q", tdir),
+		fmt.Sprintf("write %q", filepath.Join(tdir, "version")),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got %q, want it to contain %q", out, want)
+		}
+	}
+	if got, want := exists(tdir), false; got != want {
+		t.Errorf("%s: got %v, want %v", tdir, got, want)
+	}
+	if got, want := exists(filepath.Join(fake.X.ProfilesDBDir(), "i1")), false; got != want {
+		t.Errorf("%s: got %v, want %v", filepath.Join(fake.X.ProfilesDBDir(), "i1"), got, want)
+	}
+}
+
 func TestManagerUpdate(t *testing.T) {
 	fake, cleanup := jiritest.NewFakeJiriRoot(t)
 	defer cleanup()
@@ -264,7 +291,24 @@ func TestManagerUpdate(t *testing.T) {
 	cmpFiles(t, i1, filepath.Join("testdata", "i1e.xml"))
 	cmpFiles(t, i2, filepath.Join("testdata", "i2e.xml"))
 
-	run(sh, dir, "jiri", "profile", "cleanup", "-gc")
+	// A dry run reports the targets that would be removed, but leaves the
+	// profiles database untouched.
+	out := run(sh, dir, "jiri", "profile", "cleanup", "-gc", "-dry-run")
+	for _, want := range []string{"i1:eg arch-os@2: would reclaim", "i2:eg: would reclaim 0.0 MB in total"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got %q, want it to contain %q", out, want)
+		}
+	}
+	if strings.Contains(out, "success") {
+		t.Errorf("got %q, want no targets to be uninstalled", out)
+	}
+	cmpFiles(t, i1, filepath.Join("testdata", "i1e.xml"))
+	cmpFiles(t, i2, filepath.Join("testdata", "i2e.xml"))
+
+	out = run(sh, dir, "jiri", "profile", "cleanup", "-gc")
+	if want := "i1:eg: reclaimed"; !strings.Contains(out, want) {
+		t.Errorf("got %q, want it to contain %q", out, want)
+	}
 	cmpFiles(t, i1, filepath.Join("testdata", "i1f.xml"))
 	cmpFiles(t, i2, filepath.Join("testdata", "i2f.xml"))
 
@@ -316,6 +360,25 @@ func TestList(t *testing.T) {
 			t.Errorf("got %v, want %v", got, want)
 		}
 	}
+
+	// Target aliases are resolved before the targets are looked up.
+	config := jiri.Config{profiles.TargetAliasPrefix + "eg": "arch-os@2"}
+	if err := config.Write(fake.X.ConfigFile()); err != nil {
+		t.Fatal(err)
+	}
+	sh.ContinueOnError, sh.Err = false, nil
+	if got, want := run(sh, dir, "jiri", "profile", "list", "--target=eg"), "i1:eg, i2:eg\n"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	sh.ContinueOnError = true
+	sh.Err = nil
+	got := run(sh, dir, "jiri", "profile", "list", "--target=nosuch")
+	if sh.Err == nil {
+		t.Errorf("expected an error for: jiri profile list --target=nosuch")
+	}
+	if want := `ERROR: unknown target "nosuch", known aliases: eg` + "\n"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
 }
 
 // Test using a fake jiri root.
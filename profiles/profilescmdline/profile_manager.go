@@ -8,6 +8,8 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -155,7 +157,113 @@ func (ip *inproc) update(jirix *jiri.X, cl *updateFlagValues, root jiri.RelPath)
 	return nil
 }
 
-func cleanupGC(jirix *jiri.X, db *profiles.DB, root jiri.RelPath, verbose bool, name string) error {
+// installationDir returns the absolute installation directory of the given
+// target, or "" if it has none.
+func installationDir(jirix *jiri.X, target *profiles.Target) string {
+	if target.InstallationDir == "" {
+		return ""
+	}
+	return filepath.Clean(jiri.NewRelPath(target.InstallationDir).Abs(jirix))
+}
+
+// overlaps returns true if the given directories are the same, or one
+// contains the other.
+func overlaps(a, b string) bool {
+	sep := string(filepath.Separator)
+	return a == b || strings.HasPrefix(a, b+sep) || strings.HasPrefix(b, a+sep)
+}
+
+// dirSize returns the total size of the files under the given directory, or
+// zero if it does not exist.
+func dirSize(dir string) (int64, error) {
+	size := int64(0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// formatSize formats the given number of bytes in megabytes.
+func formatSize(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+}
+
+// gcTarget is a target that is removed by cleanup -gc.
+type gcTarget struct {
+	profile string
+	target  *profiles.Target
+}
+
+// gcTargets returns the targets, across all profiles in the database, that
+// are older than the default version of their profile, along with the
+// installation directories of the targets that are kept, which includes
+// those of profiles that are not linked into this binary.
+func gcTargets(jirix *jiri.X, db *profiles.DB) ([]gcTarget, []string) {
+	removed, kept := []gcTarget{}, []string{}
+	for _, profile := range db.Profiles() {
+		mgr := profilesmanager.LookupManager(profile.Name())
+		for _, target := range profile.Targets() {
+			if mgr != nil && mgr.VersionInfo().IsTargetOlderThanDefault(target.Version()) {
+				removed = append(removed, gcTarget{profile.Name(), target})
+			} else if dir := installationDir(jirix, target); dir != "" {
+				kept = append(kept, dir)
+			}
+		}
+	}
+	return removed, kept
+}
+
+// reclaimableDirs returns the installation directories of the targets of the
+// given profile that are removed by cleanup -gc and not shared with a target
+// that is kept, mapped to the target they are accounted to.  A directory
+// shared by several removed targets is accounted to the first of them in the
+// database, so that it is only counted once.
+func reclaimableDirs(jirix *jiri.X, db *profiles.DB, name string) map[*profiles.Target]string {
+	removed, kept := gcTargets(jirix, db)
+	accounted := map[string]bool{}
+	result := map[*profiles.Target]string{}
+outer:
+	for _, t := range removed {
+		dir := installationDir(jirix, t.target)
+		if dir == "" || accounted[dir] {
+			continue
+		}
+		for _, k := range kept {
+			if overlaps(dir, k) {
+				continue outer
+			}
+		}
+		accounted[dir] = true
+		if t.profile == name {
+			result[t.target] = dir
+		}
+	}
+	return result
+}
+
+// stillReferenced returns true if the given directory overlaps with the
+// installation directory of a target in the database.
+func stillReferenced(jirix *jiri.X, db *profiles.DB, dir string) bool {
+	for _, profile := range db.Profiles() {
+		for _, target := range profile.Targets() {
+			if d := installationDir(jirix, target); d != "" && overlaps(dir, d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func cleanupGC(jirix *jiri.X, db *profiles.DB, root jiri.RelPath, verbose, dryRun bool, name string) error {
 	mgr := profilesmanager.LookupManager(name)
 	if mgr == nil {
 		fmt.Fprintf(jirix.Stderr(), "%s is not linked into this binary\n", name)
@@ -164,15 +272,74 @@ func cleanupGC(jirix *jiri.X, db *profiles.DB, root jiri.RelPath, verbose bool,
 	vi := mgr.VersionInfo()
 	installer, profileName := profiles.SplitProfileName(name)
 	profile := db.LookupProfile(installer, profileName)
+	if profile == nil {
+		return nil
+	}
+	targets := []*profiles.Target{}
 	for _, target := range profile.Targets() {
 		if vi.IsTargetOlderThanDefault(target.Version()) {
-			err := mgr.Uninstall(jirix, db, root, *target)
-			logResult(jirix, "Cleanup: -gc", mgr, *target, err)
-			if err != nil {
-				return err
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	reclaimable := reclaimableDirs(jirix, db, name)
+	// The sizes of all the installation directories of the removed targets
+	// are measured, so that the space actually reclaimed by the uninstallers
+	// is reported even for directories shared with the targets that are kept.
+	before := map[string]int64{}
+	total := int64(0)
+	for _, target := range targets {
+		if dir := installationDir(jirix, target); dir != "" {
+			if _, ok := before[dir]; !ok {
+				size, err := dirSize(dir)
+				if err != nil {
+					return err
+				}
+				before[dir] = size
 			}
 		}
+		size := int64(0)
+		if dir, ok := reclaimable[target]; ok {
+			size = before[dir]
+		}
+		total += size
+		fmt.Fprintf(jirix.Stdout(), "Cleanup: -gc: %s %s: would reclaim %s\n", name, target, formatSize(size))
+	}
+	fmt.Fprintf(jirix.Stdout(), "Cleanup: -gc: %s: would reclaim %s in total\n", name, formatSize(total))
+	if dryRun {
+		return nil
+	}
+	for _, target := range targets {
+		err := mgr.Uninstall(jirix, db, root, *target)
+		logResult(jirix, "Cleanup: -gc", mgr, *target, err)
+		if err != nil {
+			return err
+		}
+	}
+	// Remove the installation directories left behind by the uninstallers
+	// once no remaining target references them.
+	s := jirix.NewSeq()
+	for _, dir := range reclaimable {
+		if stillReferenced(jirix, db, dir) {
+			continue
+		}
+		if err := s.AssertDirExists(dir).Run("chmod", "-R", "u+w", dir).RemoveAll(dir).Done(); err != nil && !runutil.IsNotExist(err) {
+			return err
+		}
+	}
+	reclaimed := int64(0)
+	for dir, size := range before {
+		after, err := dirSize(dir)
+		if err != nil {
+			return err
+		}
+		if after < size {
+			reclaimed += size - after
+		}
 	}
+	fmt.Fprintf(jirix.Stdout(), "Cleanup: -gc: %s: reclaimed %s\n", name, formatSize(reclaimed))
 	return nil
 }
 
@@ -204,7 +371,7 @@ func (ip *inproc) cleanup(jirix *jiri.X, cl *cleanupFlagValues, root jiri.RelPat
 		if cl.verbose {
 			fmt.Fprintf(jirix.Stdout(), "Removing targets older than the default version for %s\n", ip.qname)
 		}
-		if err := cleanupGC(jirix, ip.db, root, cl.verbose, ip.qname); err != nil {
+		if err := cleanupGC(jirix, ip.db, root, cl.verbose, cl.dryRun, ip.qname); err != nil {
 			return fmt.Errorf("gc: %v", err)
 		}
 	}
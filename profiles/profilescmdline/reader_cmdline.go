@@ -12,7 +12,11 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 
@@ -79,6 +83,17 @@ just "foo".
 
 If no environment variable names are requested then all will be printed
 in <name>=<val> format.
+
+If the -export flag is set, the requested variables, or all of them, are
+instead printed as a script that exports them when sourced by the shell
+selected by the -format flag: export NAME="value" lines for sh, bash and zsh,
+or set -x NAME 'value' lines for fish. The values are quoted so that the
+variables are set to their exact values, e.g.
+  eval "$(jiri profile env -export --profiles=<profiles>)"
+
+If the -write flag is set, the script is written to the given file rather
+than printed. The file is replaced atomically, so that build systems can
+source it while it is being rewritten.
 `,
 		ArgsName: "[<environment variable names>]",
 		ArgsLong: "[<environment variable names>] is an optional list of environment variables to display",
@@ -112,6 +127,12 @@ type listFlagValues struct {
 // envFlagValues contains the flag values expected by the env subcommand
 type envFlagValues struct {
 	*ReaderFlagValues
+	// The value of --export
+	export bool
+	// The value of --format
+	format string
+	// The value of --write
+	write string
 }
 
 // All flag values are stored in listFlags and envFlags.
@@ -202,6 +223,9 @@ func registerEnvCommand(parent *cmdline.Command, defaultProfiles, defaultDBPath
 		RegisterReaderFlags(&cmdEnv.Flags, envFlags.ReaderFlagValues, defaultProfiles, defaultDBPath)
 	}
 	cmdEnv.Flags.BoolVar(&envFlags.Verbose, "v", false, "print more detailed information")
+	cmdEnv.Flags.BoolVar(&envFlags.export, "export", false, "print the variables as a script that exports them when sourced by a shell")
+	cmdEnv.Flags.StringVar(&envFlags.format, "format", "sh", "the shell syntax of the script printed by --export or written by --write, one of sh or fish")
+	cmdEnv.Flags.StringVar(&envFlags.write, "write", "", "write the script printed by --export to the given file, atomically, instead of printing it")
 }
 
 func matchingTargets(rd *profilesreader.Reader, profile *profiles.Profile) profiles.Targets {
@@ -218,6 +242,11 @@ func matchingTargets(rd *profilesreader.Reader, profile *profiles.Profile) profi
 }
 
 func runList(jirix *jiri.X, args []string) error {
+	// Resolve the target before looking it up, so that the canonical target
+	// is printed rather than the alias.
+	if err := profiles.ResolveTarget(jirix, &listFlags.Target); err != nil {
+		return err
+	}
 	if listFlags.Verbose {
 		fmt.Fprintf(jirix.Stdout(), "Profiles Database Path: %s\n", listFlags.DBFilename)
 	}
@@ -412,6 +441,12 @@ func runEnv(jirix *jiri.X, args []string) error {
 	if len(envFlags.Profiles) == 0 {
 		return fmt.Errorf("no profiles were specified using --profiles")
 	}
+	if envFlags.format != "sh" && envFlags.format != "fish" {
+		return jirix.UsageErrorf("unsupported --format %q, must be one of sh or fish", envFlags.format)
+	}
+	if err := profiles.ResolveTarget(jirix, &envFlags.Target); err != nil {
+		return err
+	}
 	rd, err := profilesreader.NewReader(jirix, envFlags.ProfilesMode, envFlags.DBFilename)
 	if err != nil {
 		return err
@@ -421,6 +456,17 @@ func runEnv(jirix *jiri.X, args []string) error {
 		return err
 	}
 	rd.MergeEnvFromProfiles(envFlags.MergePolicies, envFlags.Target, profileNames...)
+	if envFlags.export || envFlags.write != "" {
+		script, err := exportScript(rd.ToMap(), args, envFlags.format)
+		if err != nil {
+			return err
+		}
+		if envFlags.write != "" {
+			return writeFileAtomically(envFlags.write, []byte(script))
+		}
+		fmt.Fprint(jirix.Stdout(), script)
+		return nil
+	}
 	out := fmtVars(rd.ToMap(), args)
 	if len(out) > 0 {
 		fmt.Fprintln(jirix.Stdout(), out)
@@ -454,3 +500,106 @@ func fmtVars(vars map[string]string, args []string) string {
 	}
 	return strings.TrimSuffix(buf.String(), " ")
 }
+
+// envNameRE matches the environment variable names that can be exported by
+// a shell script.
+var envNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// exportScript returns a script that exports the variables named by args, or
+// all of them if args is empty, when sourced by a shell of the given format:
+// "sh" for POSIX shells, or "fish". A trailing = on a name is ignored.
+func exportScript(vars map[string]string, args []string, format string) (string, error) {
+	names := []string{}
+	if len(args) == 0 {
+		for k := range vars {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+	} else {
+		for _, arg := range args {
+			name := strings.TrimSuffix(arg, "=")
+			if _, ok := vars[name]; ok {
+				names = append(names, name)
+			}
+		}
+	}
+	buf := bytes.Buffer{}
+	for _, name := range names {
+		if !envNameRE.MatchString(name) {
+			return "", fmt.Errorf("cannot export environment variable with invalid name %q", name)
+		}
+		switch format {
+		case "sh":
+			fmt.Fprintf(&buf, "export %s=%s\n", name, shQuote(vars[name]))
+		case "fish":
+			fmt.Fprintf(&buf, "set -x %s %s\n", name, fishQuote(vars[name]))
+		default:
+			return "", fmt.Errorf("unsupported script format %q", format)
+		}
+	}
+	return buf.String(), nil
+}
+
+// shQuote returns v as a double-quoted POSIX shell word. Within double quotes
+// only $, `, " and \ are special, and newlines are preserved.
+func shQuote(v string) string {
+	buf := bytes.Buffer{}
+	buf.WriteByte('"')
+	for _, r := range v {
+		switch r {
+		case '$', '`', '"', '\\':
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// fishQuote returns v as a single-quoted fish word. Within single quotes only
+// ' and \ are special, and newlines are preserved.
+func fishQuote(v string) string {
+	buf := bytes.Buffer{}
+	buf.WriteByte('\'')
+	for _, r := range v {
+		switch r {
+		case '\'', '\\':
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
+
+// writeFileAtomically writes data to the given file by writing it to a
+// temporary file in the same directory and renaming that over the file, so
+// that readers see either the old or the new contents.
+func writeFileAtomically(filename string, data []byte) error {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+"-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -9,15 +9,21 @@ import (
 	"archive/zip"
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 
 	"v.io/jiri"
+	"v.io/jiri/runutil"
 	"v.io/jiri/tool"
 )
 
@@ -217,9 +223,102 @@ func OSPackageInstallCommands(jirix *jiri.X, pkgs []string) [][]string {
 	return cmds
 }
 
-// Fetch downloads the specified url and saves it to dst.
-func Fetch(jirix *jiri.X, dst, url string) error {
+const (
+	// MirrorEnv is the environment variable that specifies the base URL of
+	// a mirror to fetch profile downloads from.
+	MirrorEnv = "JIRI_PROFILE_MIRROR"
+	// MirrorStrictEnv is the environment variable that, when set to "true",
+	// prevents falling back to the original URL if the mirror does not have
+	// the requested file.
+	MirrorStrictEnv = "JIRI_PROFILE_MIRROR_STRICT"
+)
+
+// FetchOpt is an option for Fetch.
+type FetchOpt interface {
+	fetchOpt()
+}
+
+// ChecksumOpt is the expected hex-encoded SHA-256 checksum of the download.
+type ChecksumOpt string
+
+// MirrorOpt is the base URL of a mirror to fetch from.  It overrides the
+// value of the JIRI_PROFILE_MIRROR environment variable.
+type MirrorOpt string
+
+// MirrorStrictOpt prevents falling back to the original URL if the mirror
+// does not have the requested file.  It overrides the value of the
+// JIRI_PROFILE_MIRROR_STRICT environment variable.
+type MirrorStrictOpt bool
+
+func (ChecksumOpt) fetchOpt()     {}
+func (MirrorOpt) fetchOpt()       {}
+func (MirrorStrictOpt) fetchOpt() {}
+
+// MirrorURL returns the location of the given url on the given mirror.  The
+// host and path of the original url are appended to the mirror base URL, e.g.
+// https://foo.com/a/b.tar.gz is fetched from <mirror>/foo.com/a/b.tar.gz.
+func MirrorURL(mirror, rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %v", rawurl, err)
+	}
+	return strings.TrimSuffix(mirror, "/") + "/" + u.Host + u.Path, nil
+}
+
+type fetchStatusError struct {
+	url    string
+	status int
+}
+
+func (e fetchStatusError) Error() string {
+	return fmt.Sprintf("got non-200 status code while getting %v: %v", e.url, e.status)
+}
+
+// Fetch downloads the specified url and saves it to dst.  If a mirror is
+// configured, either via MirrorOpt or the JIRI_PROFILE_MIRROR environment
+// variable, the url is fetched from the mirror first, falling back to the
+// original url if the mirror doesn't have it, unless strict mirroring is
+// requested.  If a ChecksumOpt is given, the SHA-256 checksum of the download
+// is verified and dst is removed on mismatch.  Sequences that record their
+// actions record the download instead of performing it.
+func Fetch(jirix *jiri.X, dst, url string, opts ...FetchOpt) error {
+	checksum := ""
+	mirror := jirix.Env()[MirrorEnv]
+	strict := jirix.Env()[MirrorStrictEnv] == "true"
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case ChecksumOpt:
+			checksum = string(typedOpt)
+		case MirrorOpt:
+			mirror = string(typedOpt)
+		case MirrorStrictOpt:
+			strict = bool(typedOpt)
+		}
+	}
+	if mirror != "" {
+		mirrorURL, err := MirrorURL(mirror, url)
+		if err != nil {
+			return err
+		}
+		err = fetch(jirix, dst, mirrorURL, checksum)
+		if statusErr, ok := err.(fetchStatusError); !ok || statusErr.status != http.StatusNotFound || strict {
+			return err
+		}
+		jirix.NewSeq().Output([]string{mirrorURL + " not found on mirror, falling back to " + url})
+	}
+	return fetch(jirix, dst, url, checksum)
+}
+
+func fetch(jirix *jiri.X, dst, url, checksum string) error {
 	s := jirix.NewSeq()
+	if rec := s.Recorder(); rec != nil {
+		if checksum != "" {
+			rec.Record(runutil.DownloadAction, "%s -> %s (sha256 %s)", url, dst, checksum)
+		} else {
+			rec.Record(runutil.DownloadAction, "%s -> %s", url, dst)
+		}
+		return nil
+	}
 	s.Output([]string{"fetching " + url})
 	resp, err := http.Get(url)
 	if err != nil {
@@ -227,16 +326,28 @@ func Fetch(jirix *jiri.X, dst, url string) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("got non-200 status code while getting %v: %v", url, resp.StatusCode)
+		return fetchStatusError{url, resp.StatusCode}
 	}
 	file, err := s.Create(dst)
 	if err != nil {
 		return err
 	}
-	if _, err := s.Copy(file, resp.Body); err != nil {
+	hash := sha256.New()
+	if _, err := s.Copy(file, io.TeeReader(resp.Body, hash)); err != nil {
+		file.Close()
 		return err
 	}
-	return file.Close()
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if checksum == "" {
+		return nil
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(got, checksum) {
+		s.Remove(dst).Done()
+		return fmt.Errorf("checksum mismatch for %v: got sha256 %v, want %v", url, got, checksum)
+	}
+	return nil
 }
 
 // Untar untars the file in srcFile and puts resulting files in directory dstDir.
@@ -252,6 +363,10 @@ func Untar(jirix *jiri.X, srcFile, dstDir string) error {
 
 // Unzip unzips the file in srcFile and puts resulting files in directory dstDir.
 func Unzip(jirix *jiri.X, srcFile, dstDir string) error {
+	if rec := jirix.NewSeq().Recorder(); rec != nil {
+		rec.Record(runutil.FileAction, "unzip %q into %q", srcFile, dstDir)
+		return nil
+	}
 	r, err := zip.OpenReader(srcFile)
 	if err != nil {
 		return err
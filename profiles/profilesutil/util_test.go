@@ -0,0 +1,125 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profilesutil_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"v.io/jiri/jiritest"
+	"v.io/jiri/profiles/profilesutil"
+)
+
+const content = "profile tarball"
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// newServer returns a test server that serves content at the given paths and
+// a 404 for everything else.
+func newServer(paths ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range paths {
+			if r.URL.Path == path {
+				w.Write([]byte(content))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func TestFetch(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	upstream := newServer("/a/b.tar.gz")
+	defer upstream.Close()
+	upstreamURL := upstream.URL + "/a/b.tar.gz"
+	unreachableURL := "http://unreachable.invalid/a/b.tar.gz"
+	mirrorPaths := []string{}
+	for _, url := range []string{upstreamURL, unreachableURL} {
+		path, err := profilesutil.MirrorURL("", url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mirrorPaths = append(mirrorPaths, path)
+	}
+	mirror := newServer(mirrorPaths...)
+	defer mirror.Close()
+	emptyMirror := newServer()
+	defer emptyMirror.Close()
+
+	tests := []struct {
+		url  string
+		opts []profilesutil.FetchOpt
+		err  string
+	}{
+		// No checksum or mirror.
+		{upstreamURL, nil, ""},
+		{upstream.URL + "/missing", nil, "non-200 status code"},
+		// Checksum match and mismatch.
+		{upstreamURL, []profilesutil.FetchOpt{profilesutil.ChecksumOpt(checksum(content))}, ""},
+		{upstreamURL, []profilesutil.FetchOpt{profilesutil.ChecksumOpt(checksum("other"))}, "checksum mismatch"},
+		// Mirror hit; the upstream url is unreachable.
+		{unreachableURL, []profilesutil.FetchOpt{profilesutil.MirrorOpt(mirror.URL)}, ""},
+		// Mirror miss, with and without fallback.
+		{upstreamURL, []profilesutil.FetchOpt{profilesutil.MirrorOpt(emptyMirror.URL)}, ""},
+		{upstreamURL, []profilesutil.FetchOpt{profilesutil.MirrorOpt(emptyMirror.URL), profilesutil.MirrorStrictOpt(true)}, "non-200 status code"},
+		// Checksum mismatch on the mirror does not fall back.
+		{upstreamURL, []profilesutil.FetchOpt{profilesutil.MirrorOpt(mirror.URL), profilesutil.ChecksumOpt(checksum("other"))}, "checksum mismatch"},
+	}
+	for i, test := range tests {
+		dst := filepath.Join(jirix.Root, "download")
+		os.Remove(dst)
+		err := profilesutil.Fetch(jirix, dst, test.url, test.opts...)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%d: Fetch(%v) failed: %v", i, test.url, err)
+				continue
+			}
+			data, err := ioutil.ReadFile(dst)
+			if err != nil {
+				t.Errorf("%d: %v", i, err)
+				continue
+			}
+			if got, want := string(data), content; got != want {
+				t.Errorf("%d: got %q, want %q", i, got, want)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%d: got error %v, want substr %q", i, err, test.err)
+		}
+		if _, err := os.Stat(dst); test.err == "checksum mismatch" && !os.IsNotExist(err) {
+			t.Errorf("%d: expected %v to be removed, got %v", i, dst, err)
+		}
+	}
+}
+
+func TestFetchMirrorEnv(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	mirrorPath, err := profilesutil.MirrorURL("", "http://unreachable.invalid/a/b.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := newServer(mirrorPath)
+	defer mirror.Close()
+	jirix.Env()[profilesutil.MirrorEnv] = mirror.URL
+	defer delete(jirix.Env(), profilesutil.MirrorEnv)
+	dst := filepath.Join(jirix.Root, "download")
+	if err := profilesutil.Fetch(jirix, dst, "http://unreachable.invalid/a/b.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+}
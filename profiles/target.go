@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"v.io/x/lib/envvar"
 )
@@ -24,8 +25,16 @@ import (
 // with the flag package. Two flags are required, one to specify the target
 // in <arch>-<os>@<version> format and a second to specify environment
 // variables either as comma separated values or as repeated arguments.
+//
+// The target flag also accepts "native", for the architecture and operating
+// system of the host, and the names of target aliases, optionally followed by
+// @<version> to override the version of the alias.  Aliases are defined by
+// the jiri config and must be resolved with ResolveTarget before the target
+// is used.
 type Target struct {
 	arch, opsys, version string
+	// The alias named on the command line, until it is resolved.
+	alias string
 	// The environment as specified on the command line
 	commandLineEnv Environment
 	// The environment as modified by a profile implementation
@@ -152,7 +161,7 @@ func (pt Target) CrossCompiling() bool {
 
 // Usage returns the usage string for Target.
 func (pt *Target) Usage() string {
-	return "specifies a profile target in the following form: <arch>-<os>[@<version>]"
+	return `specifies a profile target in the following form: <arch>-<os>[@<version>], or "native" or the name of a target alias, optionally followed by @<version>`
 }
 
 // Set implements flag.Value.
@@ -162,16 +171,82 @@ func (t *Target) Set(val string) error {
 		t.version = val[index+1:]
 		val = val[:index]
 	}
+	if val == NativeTargetName {
+		t.arch, _ = goarch()
+		t.opsys = runtime.GOOS
+		t.alias = ""
+		t.isSet = true
+		return nil
+	}
+	if isTargetAliasName(val) {
+		t.arch, t.opsys = "", ""
+		t.alias = val
+		t.isSet = true
+		return nil
+	}
 	parts := strings.Split(val, "-")
 	if len(parts) != 2 || (len(parts[0]) == 0 || len(parts[1]) == 0) {
 		return fmt.Errorf("%q doesn't look like <arch>-<os>[@<version>]", val)
 	}
 	t.arch = parts[0]
 	t.opsys = parts[1]
+	t.alias = ""
 	t.isSet = true
 	return nil
 }
 
+// Alias returns the name of the target alias that the target was set to, if
+// it has not been resolved yet.
+func (pt *Target) Alias() string {
+	return pt.alias
+}
+
+// ResolveAlias resolves the target alias the target was set to, if any,
+// using the given map from alias names to targets in
+// <arch>-<os>[@<version>] format.  A version given along with the alias
+// overrides the version of the alias.
+func (t *Target) ResolveAlias(aliases map[string]string) error {
+	if t.alias == "" {
+		return nil
+	}
+	value, ok := aliases[t.alias]
+	if !ok {
+		names := []string{}
+		for name := range aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		known := "none are defined"
+		if len(names) > 0 {
+			known = "known aliases: " + strings.Join(names, ", ")
+		}
+		return fmt.Errorf("unknown target %q, %s", t.alias, known)
+	}
+	resolved := Target{}
+	if err := resolved.Set(value); err != nil || resolved.alias != "" {
+		return fmt.Errorf("invalid target %q for alias %q: want <arch>-<os>[@<version>]", value, t.alias)
+	}
+	t.arch, t.opsys, t.alias = resolved.arch, resolved.opsys, ""
+	if t.version == "" {
+		t.version = resolved.version
+	}
+	return nil
+}
+
+// isTargetAliasName returns whether the given name can name a target alias,
+// i.e. it is not empty and only contains letters, digits, '_' and '.'.
+func isTargetAliasName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
 // Get implements flag.Getter.
 func (t Target) Get() interface{} {
 	if !t.isSet {
@@ -230,6 +305,12 @@ func (pt Target) IsSet() bool {
 
 // String implements flag.Getter.
 func (pt Target) String() string {
+	if pt.alias != "" {
+		if pt.version != "" {
+			return pt.alias + "@" + pt.version
+		}
+		return pt.alias
+	}
 	v := pt.Get().(Target)
 	return fmt.Sprintf("%v-%v@%s", v.arch, v.opsys, v.version)
 }
@@ -56,6 +56,56 @@ func TestProfileTargetArgs(t *testing.T) {
 	}
 }
 
+func TestTargetAlias(t *testing.T) {
+	native := profiles.NativeTarget()
+	target := &profiles.Target{}
+	if err := target.Set("native"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := target.String(), native.String(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	aliases := map[string]string{"rpi": "arm-linux@2", "bad": "rpi"}
+	for i, c := range []struct {
+		arg, want, err string
+	}{
+		{"rpi", "arm-linux@2", ""},
+		{"rpi@3", "arm-linux@3", ""},
+		{"a-b", "a-b@", ""},
+		{"nosuch", "", `unknown target "nosuch", known aliases: bad, rpi`},
+		{"bad", "", `invalid target "rpi" for alias "bad": want <arch>-<os>[@<version>]`},
+	} {
+		target := &profiles.Target{}
+		if err := target.Set(c.arg); err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		err := target.ResolveAlias(aliases)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%d: got error %v, want %v", i, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if got := target.String(); got != c.want {
+			t.Errorf("%d: got %v, want %v", i, got, c.want)
+		}
+		if target.Alias() != "" {
+			t.Errorf("%d: got unresolved alias %q", i, target.Alias())
+		}
+	}
+	target = &profiles.Target{}
+	target.Set("rpi")
+	if err := target.ResolveAlias(nil); err == nil || err.Error() != `unknown target "rpi", none are defined` {
+		t.Errorf("got error %v", err)
+	}
+}
+
 func TestProfileEnvArgs(t *testing.T) {
 	for i, c := range []struct {
 		args []string
@@ -0,0 +1,243 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+	"v.io/jiri/runutil"
+)
+
+const (
+	// backupMessagePrefix prefixes the messages of the stash entries that
+	// CleanupProjects creates, which are followed by the backup ID.
+	backupMessagePrefix = "jiri-clean-backup "
+	// backupIDFormat is the time format of backup IDs.
+	backupIDFormat = "20060102-150405"
+	// backupBundleSuffix is the suffix of the bundle files that
+	// CleanupProjects writes to the backup directories of projects.
+	backupBundleSuffix = ".bundle"
+)
+
+// Backup is a backup of the local state of a project that CleanupProjects
+// created before discarding it.  The uncommitted changes and untracked files
+// of a project are backed up as a stash entry, and the branches deleted by
+// CleanupProjects as a git bundle in the backup directory of the project.
+type Backup struct {
+	// ID identifies the clean that created the backup, which is the time
+	// the clean started, e.g. "20161017-150405".
+	ID string
+	// Project is the project that was backed up.
+	Project Project
+	// Stash is the message of the stash entry of the backup, e.g. "On
+	// master: jiri-clean-backup 20161017-150405", or empty.
+	Stash string
+	// Bundle is the path of the bundle file of the backup, or empty.
+	Bundle string
+	// stashIndex is the index of the stash entry of the backup.
+	stashIndex int
+}
+
+// Time returns the time the backup was created.
+func (b Backup) Time() (time.Time, error) {
+	return time.ParseInLocation(backupIDFormat, b.ID, time.Local)
+}
+
+// Description returns a description of what the backup holds.
+func (b Backup) Description() string {
+	if b.Bundle != "" {
+		return "deleted branches in " + b.Bundle
+	}
+	return fmt.Sprintf("uncommitted changes in stash entry stash@{%d}", b.stashIndex)
+}
+
+// newBackupID returns the ID of the backups created by a clean starting now.
+func newBackupID() string {
+	return time.Now().Format(backupIDFormat)
+}
+
+// backupDir returns the directory of the backup bundles of the given project.
+func backupDir(project Project) string {
+	return filepath.Join(project.Path, jiri.ProjectMetaDir, "backups")
+}
+
+// backupProject backs up the uncommitted changes and untracked files of the
+// given project, which is the current directory, as a stash entry, and, if
+// deleteBranches is true, its non-master branches as a bundle.  Stashing
+// removes the changes from the working tree, but the bundle is written to
+// the metadata directory, which git ignores, so that backing up a project
+// never makes it dirty.
+func backupProject(jirix *jiri.X, project Project, id string, deleteBranches bool) error {
+	git := gitutil.New(jirix.NewSeq())
+	uncommitted, err := git.HasUncommittedChanges()
+	if err != nil {
+		return err
+	}
+	untracked, err := git.HasUntrackedFiles()
+	if err != nil {
+		return err
+	}
+	if uncommitted || untracked {
+		stashed, err := git.StashPush(backupMessagePrefix + id)
+		if err != nil {
+			return err
+		}
+		if stashed {
+			jirix.Audit("back up project", "stashed the uncommitted changes and untracked files of project %q in %s as backup %s", project.Name, project.Path, id)
+			fmt.Fprintf(jirix.Stdout(), "Backed up the uncommitted changes of project %q as backup %s.\n", project.Name, id)
+		}
+	}
+	if !deleteBranches {
+		return nil
+	}
+	branches, _, err := git.GetBranches()
+	if err != nil {
+		return err
+	}
+	var deleted, refs []string
+	for _, branch := range branches {
+		if branch != "master" {
+			deleted = append(deleted, branch)
+			refs = append(refs, "refs/heads/"+branch)
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	dir := backupDir(project)
+	if err := jirix.NewSeq().MkdirAll(dir, 0755).Done(); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, id+backupBundleSuffix)
+	if err := git.CreateBundle(file, refs...); err != nil {
+		return err
+	}
+	jirix.Audit("back up project", "wrote the branches %s of project %q in %s to %s", strings.Join(deleted, ", "), project.Name, project.Path, file)
+	fmt.Fprintf(jirix.Stdout(), "Backed up the branches of project %q as backup %s.\n", project.Name, id)
+	return nil
+}
+
+// projectBackups returns the backups of the given project, from the oldest to
+// the most recent.
+func projectBackups(jirix *jiri.X, project Project) ([]Backup, error) {
+	var backups []Backup
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+	messages, err := git.StashMessages()
+	if err != nil {
+		return nil, err
+	}
+	for i, message := range messages {
+		index := strings.Index(message, backupMessagePrefix)
+		if index == -1 {
+			continue
+		}
+		backups = append(backups, Backup{
+			ID:         strings.TrimSpace(message[index+len(backupMessagePrefix):]),
+			Project:    project,
+			Stash:      message,
+			stashIndex: i,
+		})
+	}
+	infos, err := jirix.NewSeq().ReadDir(backupDir(project))
+	if err != nil && !runutil.IsNotExist(err) {
+		return nil, err
+	}
+	for _, info := range infos {
+		if name := info.Name(); strings.HasSuffix(name, backupBundleSuffix) {
+			backups = append(backups, Backup{
+				ID:      strings.TrimSuffix(name, backupBundleSuffix),
+				Project: project,
+				Bundle:  filepath.Join(backupDir(project), name),
+			})
+		}
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].ID < backups[j].ID })
+	return backups, nil
+}
+
+// ListBackups returns the backups of the given projects that CleanupProjects
+// created, ordered by ID and project.
+func ListBackups(jirix *jiri.X, projects Projects) ([]Backup, error) {
+	var backups []Backup
+	for _, key := range sortedKeys(projects) {
+		projectBackups, err := projectBackups(jirix, projects[key])
+		if err != nil {
+			return nil, fmt.Errorf("listing the backups of project %q failed: %v", projects[key].Name, err)
+		}
+		backups = append(backups, projectBackups...)
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].ID < backups[j].ID })
+	return backups, nil
+}
+
+// RestoreBackup restores the backups with the given ID of the given projects.
+// Stashed changes are applied to the current branch of their project, which
+// should be the branch they were stashed on, as given by the message of the
+// stash entry, and deleted branches are fetched back from their bundle.  The
+// backups themselves are kept until they are pruned.
+func RestoreBackup(jirix *jiri.X, projects Projects, id string) error {
+	backups, err := ListBackups(jirix, projects)
+	if err != nil {
+		return err
+	}
+	restored := false
+	for _, backup := range backups {
+		if backup.ID != id {
+			continue
+		}
+		project := backup.Project
+		git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+		if backup.Bundle != "" {
+			err = git.FetchRefspec(backup.Bundle, "refs/heads/*:refs/heads/*")
+		} else {
+			err = git.StashApply(backup.stashIndex)
+		}
+		if err != nil {
+			return fmt.Errorf("restoring the %s of project %q failed: %v", backup.Description(), project.Name, err)
+		}
+		jirix.Audit("restore backup", "restored the %s of project %q in %s", backup.Description(), project.Name, project.Path)
+		fmt.Fprintf(jirix.Stdout(), "Restored the %s of project %q.\n", backup.Description(), project.Name)
+		restored = true
+	}
+	if !restored {
+		return fmt.Errorf("backup %q not found", id)
+	}
+	return nil
+}
+
+// pruneBackups deletes the backups of the given project that are older than
+// the given age.
+func pruneBackups(jirix *jiri.X, project Project, maxAge time.Duration) error {
+	backups, err := projectBackups(jirix, project)
+	if err != nil {
+		return err
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+	// Drop the stash entries from the oldest, which has the highest index,
+	// so that the indexes of the other entries do not change.
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].stashIndex > backups[j].stashIndex })
+	for _, backup := range backups {
+		created, err := backup.Time()
+		if err != nil || time.Since(created) <= maxAge {
+			continue
+		}
+		if backup.Bundle != "" {
+			err = jirix.NewSeq().RemoveAll(backup.Bundle).Done()
+		} else {
+			err = git.StashDrop(backup.stashIndex)
+		}
+		if err != nil {
+			return err
+		}
+		jirix.Audit("prune backup", "deleted backup %s of project %q in %s", backup.ID, project.Name, project.Path)
+	}
+	return nil
+}
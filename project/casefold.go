@@ -0,0 +1,101 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/errkind"
+)
+
+// foldPath returns the given path in the form in which paths that differ only
+// in case are equal, as on case-insensitive filesystems.
+func foldPath(path string) string {
+	return strings.ToLower(filepath.Clean(path))
+}
+
+// caseCollisions returns the pairs of the given projects whose paths differ,
+// but only in case, sorted by path.
+func caseCollisions(projects Projects) [][2]Project {
+	byFolded := map[string][]Project{}
+	for _, key := range sortedKeys(projects) {
+		p := projects[key]
+		byFolded[foldPath(p.Path)] = append(byFolded[foldPath(p.Path)], p)
+	}
+	folded := []string{}
+	for path, ps := range byFolded {
+		if len(ps) > 1 {
+			folded = append(folded, path)
+		}
+	}
+	sort.Strings(folded)
+	var collisions [][2]Project
+	for _, path := range folded {
+		ps := byFolded[path]
+		sort.SliceStable(ps, func(i, j int) bool { return ps[i].Path < ps[j].Path })
+		for i := range ps {
+			for j := i + 1; j < len(ps); j++ {
+				if ps[i].Path != ps[j].Path {
+					collisions = append(collisions, [2]Project{ps[i], ps[j]})
+				}
+			}
+		}
+	}
+	return collisions
+}
+
+// caseInsensitiveFS returns whether the filesystem of the given directory
+// ignores case, by creating a probe file in it and looking it up with its
+// name in upper case.  It is a variable so that tests can simulate either
+// kind of filesystem.
+var caseInsensitiveFS = func(dir string) (bool, error) {
+	file, err := ioutil.TempFile(dir, ".jiri-case-probe-")
+	if err != nil {
+		return false, err
+	}
+	name := file.Name()
+	file.Close()
+	defer os.Remove(name)
+	if _, err := os.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(name)))); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// checkCaseCollisions checks that the paths of the given projects do not
+// differ only in case.  Such projects would be checked out in the same
+// directory on a case-insensitive filesystem, so they are reported as an
+// error if the filesystem of the jiri root ignores case, and with a warning
+// otherwise.
+func checkCaseCollisions(jirix *jiri.X, projects Projects) error {
+	collisions := caseCollisions(projects)
+	if len(collisions) == 0 {
+		return nil
+	}
+	insensitive, err := caseInsensitiveFS(jirix.Root)
+	if err != nil {
+		return err
+	}
+	descriptions := []string{}
+	for _, c := range collisions {
+		descriptions = append(descriptions, fmt.Sprintf("projects %q at %s and %q at %s", c[0].Name, shortFileName(jirix.Root, c[0].Path), c[1].Name, shortFileName(jirix.Root, c[1].Path)))
+	}
+	if insensitive {
+		return errkind.Errorf(errkind.ManifestError, "the paths of %s differ only in case, and collide on the case-insensitive filesystem of %s", strings.Join(descriptions, ", and of "), jirix.Root)
+	}
+	for _, description := range descriptions {
+		fmt.Fprintf(jirix.Stderr(), "WARNING: the paths of %s differ only in case, and collide on case-insensitive filesystems\n", description)
+	}
+	return nil
+}
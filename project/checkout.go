@@ -0,0 +1,49 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"sort"
+
+	"v.io/jiri"
+	"v.io/jiri/collect"
+)
+
+// CheckoutProjects clones the given projects of the manifest, which must not
+// exist locally, at their revisions, as "jiri update" creates projects: the
+// metadata of the projects is written, and their githooks are installed, but
+// runhooks are not run and no other project is changed.  The projects are
+// cloned in the order of their paths, so that projects nested in others are
+// cloned after them, and none is cloned if any of their paths already exists.
+func CheckoutProjects(jirix *jiri.X, projects Projects) (e error) {
+	if len(projects) == 0 {
+		return nil
+	}
+	var ops []operation
+	for _, project := range projects {
+		ops = append(ops, createOperation{commonOperation: commonOperation{
+			destination: project.Path,
+			project:     project,
+		}})
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Project().Path < ops[j].Project().Path })
+	updates := newFsUpdates()
+	for _, op := range ops {
+		if err := op.Test(jirix, updates); err != nil {
+			return err
+		}
+	}
+	defer collect.Error(func() error { return bumpGeneration(jirix) }, &e)
+	s := jirix.NewSeq()
+	for _, op := range ops {
+		if err := s.Verbose(true).Call(func() error { return op.Run(jirix) }, "%v", op).Done(); err != nil {
+			return err
+		}
+	}
+	if err := excludeMetadataDirs(jirix, ops); err != nil {
+		return err
+	}
+	return applyGitHooks(jirix, ops)
+}
@@ -0,0 +1,207 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+)
+
+// DetachOpt causes CheckoutSnapshot to check out the snapshot revision of
+// each local project with a detached HEAD, rather than resetting its master
+// branch to the revision.  Projects of the snapshot that do not exist locally
+// are skipped, and hooks are not run.  Use LeaveSnapshot to check the master
+// branches back out.
+type DetachOpt bool
+
+// DetachBranchOpt causes CheckoutSnapshot, along with DetachOpt, to check out
+// the snapshot revisions on a branch with the given name, rather than with a
+// detached HEAD.  An existing branch with the same name is reset to the
+// snapshot revision.
+type DetachBranchOpt string
+
+// ForceOpt causes CheckoutSnapshot, along with DetachOpt, and LeaveSnapshot to
+// discard uncommitted changes in the projects they check out, rather than
+// fail.
+type ForceOpt bool
+
+func (DetachOpt) updateOpt()       {}
+func (DetachBranchOpt) updateOpt() {}
+func (ForceOpt) updateOpt()        {}
+
+// checkoutSnapshotDetached checks out the revisions recorded in the given
+// snapshot in the local projects, leaving their master branches untouched.
+// Unless partial is set, it first checks that all the revisions can be
+// obtained.
+func checkoutSnapshotDetached(jirix *jiri.X, snapshot, branch string, force, noVerify, partial bool) error {
+	localProjects, err := LocalProjects(jirix, FastScan)
+	if err != nil {
+		return err
+	}
+	remoteProjects, _, err := LoadSnapshotFile(jirix, snapshot)
+	if err != nil {
+		return err
+	}
+	projects, missing := Projects{}, []string{}
+	for key, remote := range remoteProjects {
+		local, ok := localProjects[key]
+		if !ok {
+			missing = append(missing, remote.Name)
+			continue
+		}
+		remote.Path = local.Path
+		projects[key] = remote
+	}
+	if !force {
+		if err := checkNoUncommittedChanges(jirix, projects); err != nil {
+			return err
+		}
+	}
+	if !partial {
+		if err := verifySnapshotRevisions(jirix, localProjects, projects, 0); err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		fmt.Fprintf(jirix.Stderr(), "WARNING: the following projects of the snapshot do not exist locally and were skipped:\n  %s\n", strings.Join(missing, "\n  "))
+	}
+	for _, key := range sortedKeys(projects) {
+		if noVerify {
+			warnNoVerify(jirix, projects[key])
+		}
+		if err := detachProject(jirix, projects[key], branch, force, noVerify); err != nil {
+			return fmt.Errorf("error checking out project %q: %v", projects[key].Name, err)
+		}
+	}
+	return WriteUpdateHistorySnapshot(jirix, snapshot, CurrentStateOpt(true))
+}
+
+// detachProject fetches the given project and checks out its revision, with a
+// detached HEAD or on the given branch.
+func detachProject(jirix *jiri.X, project Project, branch string, force, noVerify bool) error {
+	if project.Protocol != "git" {
+		return UnsupportedProtocolErr(project.Protocol)
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+	if err := git.Fetch("origin"); err != nil {
+		return err
+	}
+	target, fallback, err := resolveResetTarget(jirix, project, project.Path, 0)
+	if err != nil {
+		return err
+	}
+	if fallback {
+		warnFallbackBranch(jirix, project)
+	}
+	if err := checkSignature(jirix, project, project.Path, target, noVerify); err != nil {
+		return err
+	}
+	if err := git.CheckoutBranch(target, gitutil.ForceOpt(force)); err != nil {
+		return partialCloneError(project, err)
+	}
+	if branch == "" {
+		return nil
+	}
+	if git.BranchExists(branch) {
+		if err := git.DeleteBranch(branch, gitutil.ForceOpt(true)); err != nil {
+			return err
+		}
+	}
+	if err := git.CreateBranchWithUpstream(branch, target); err != nil {
+		return err
+	}
+	return git.CheckoutBranch(branch)
+}
+
+// LeaveSnapshot checks the master branch back out in the local projects that
+// were checked out by CheckoutSnapshot with DetachOpt, and have not been
+// moved off the snapshot revision since.  It returns an error if the latest
+// update did not check out a snapshot.
+func LeaveSnapshot(jirix *jiri.X, opts ...UpdateOpt) error {
+	force := false
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case ForceOpt:
+			force = bool(typedOpt)
+		}
+	}
+	update, err := LatestUpdate(jirix)
+	if err != nil {
+		return err
+	}
+	if update == nil || update.SnapshotPath == "" {
+		return fmt.Errorf("no snapshot is checked out")
+	}
+	localProjects, err := LocalProjects(jirix, FastScan)
+	if err != nil {
+		return err
+	}
+	projects := Projects{}
+	for key, recorded := range update.Projects {
+		local, ok := localProjects[key]
+		if !ok {
+			continue
+		}
+		git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(local.Path))
+		branch, err := git.CurrentBranchName()
+		if err != nil {
+			return err
+		}
+		revision, err := git.CurrentRevision()
+		if err != nil {
+			return err
+		}
+		if branch != "master" && revision == recorded.Revision {
+			projects[key] = local
+		}
+	}
+	if !force {
+		if err := checkNoUncommittedChanges(jirix, projects); err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(projects) {
+		git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(projects[key].Path))
+		if err := git.CheckoutBranch("master", gitutil.ForceOpt(force)); err != nil {
+			return fmt.Errorf("error checking out master in project %q: %v", projects[key].Name, err)
+		}
+	}
+	return WriteUpdateHistorySnapshot(jirix, "")
+}
+
+// checkNoUncommittedChanges returns an error listing the given projects that
+// have uncommitted changes, if any.
+func checkNoUncommittedChanges(jirix *jiri.X, projects Projects) error {
+	states, err := projectStates(jirix, projects, true)
+	if err != nil {
+		return err
+	}
+	dirty := []string{}
+	for _, state := range states {
+		if state.HasUncommitted {
+			dirty = append(dirty, fmt.Sprintf("%s (%s)", state.Project.Name, state.Project.Path))
+		}
+	}
+	if len(dirty) > 0 {
+		sort.Strings(dirty)
+		return fmt.Errorf("the following projects have uncommitted changes, commit them or use -force to discard them:\n  %s", strings.Join(dirty, "\n  "))
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of the given projects in sorted order.
+func sortedKeys(projects Projects) ProjectKeys {
+	keys := ProjectKeys{}
+	for key := range projects {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	return keys
+}
@@ -0,0 +1,308 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/collect"
+	"v.io/jiri/gitutil"
+)
+
+// The kinds of differences between the local projects and the manifest.
+const (
+	// DiffMissing is a project of the manifest that does not exist locally.
+	DiffMissing = "missing"
+	// DiffNotInManifest is a local project that is not in the manifest.
+	DiffNotInManifest = "not-in-manifest"
+	// DiffPath is a local project whose path differs from the manifest.
+	DiffPath = "path"
+	// DiffRevision is a local project whose master branch is not at the
+	// revision of the manifest.
+	DiffRevision = "revision"
+	// DiffUnresolvable is a remote import whose manifest project does not
+	// exist locally, so that the projects it imports are unknown.
+	DiffUnresolvable = "unresolvable"
+)
+
+// ProjectDiff describes a difference between the local projects and the
+// manifest.
+type ProjectDiff struct {
+	// Kind is the kind of the difference, one of the Diff constants.
+	Kind string `json:"kind"`
+	// Name is the name of the project, or of the remote import.
+	Name string `json:"name"`
+	// Remote is the remote of the project, or of the remote import.
+	Remote string `json:"remote,omitempty"`
+	// LocalPath and ManifestPath are the paths of the project in the local
+	// tree and in the manifest.
+	LocalPath    string `json:"localPath,omitempty"`
+	ManifestPath string `json:"manifestPath,omitempty"`
+	// LocalRevision and ManifestRevision are the revisions of the project in
+	// the local tree and in the manifest.
+	LocalRevision    string `json:"localRevision,omitempty"`
+	ManifestRevision string `json:"manifestRevision,omitempty"`
+}
+
+// projectDiffs sorts project differences by kind and name.
+type projectDiffs []ProjectDiff
+
+func (d projectDiffs) Len() int      { return len(d) }
+func (d projectDiffs) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d projectDiffs) Less(i, j int) bool {
+	if d[i].Kind != d[j].Kind {
+		return d[i].Kind < d[j].Kind
+	}
+	return d[i].Name < d[j].Name
+}
+
+// DiffManifest returns the differences between the local projects and the
+// projects of the manifest, sorted by kind and name, without changing the
+// local projects.  Remotes are not fetched, so a project of the manifest at
+// HEAD is compared to the local copy of its remote branch.  Remote imports
+// whose manifest projects do not exist locally are reported as unresolvable,
+// rather than cloned, and local projects that are not in an enabled group are
+// not reported.
+func DiffManifest(jirix *jiri.X) ([]ProjectDiff, error) {
+	localProjects, err := LocalProjects(jirix, FullScan)
+	if err != nil {
+		return nil, err
+	}
+	ld := newManifestLoader(localProjects, false)
+	ld.skipUnresolvable = true
+	if err := ld.Load(jirix, "", jirix.JiriManifestFile(), ""); err != nil {
+		return nil, err
+	}
+	enabled, err := enabledGroupSet(jirix)
+	if err != nil {
+		return nil, err
+	}
+	// Renamed projects are matched to local projects with a different key,
+	// so the local projects of the operations are looked up by path.
+	localByPath := map[string]Project{}
+	for _, local := range localProjects {
+		localByPath[local.Path] = local
+	}
+	diffs := projectDiffs{}
+	for _, remote := range ld.unresolvable {
+		diffs = append(diffs, ProjectDiff{Kind: DiffUnresolvable, Name: remote.Name, Remote: remote.Remote})
+	}
+	for _, op := range computeOperations(localProjects, ld.Projects, false) {
+		project := op.Project()
+		diff := ProjectDiff{Name: project.Name, Remote: project.Remote}
+		switch op.Kind() {
+		case "create":
+			diff.Kind, diff.ManifestPath = DiffMissing, project.Path
+			diff.ManifestRevision = project.Revision
+		case "delete":
+			if !inGroups(project.Groups, enabled) {
+				continue
+			}
+			diff.Kind, diff.LocalPath = DiffNotInManifest, project.Path
+			diff.LocalRevision = project.Revision
+		case "move":
+			diff.Kind, diff.LocalPath, diff.ManifestPath = DiffPath, op.(moveOperation).source, project.Path
+		case "update":
+			local := localByPath[op.(updateOperation).source]
+			manifestRevision := manifestRevision(jirix, local, project)
+			if manifestRevision == "" || manifestRevision == local.Revision {
+				continue
+			}
+			diff.Kind, diff.LocalPath = DiffRevision, local.Path
+			diff.LocalRevision, diff.ManifestRevision = local.Revision, manifestRevision
+		default:
+			continue
+		}
+		diffs = append(diffs, diff)
+	}
+	sort.Sort(diffs)
+	return diffs, nil
+}
+
+// manifestRevision returns the revision that the manifest specifies for the
+// given local project, resolving HEAD to the local copy of the remote branch
+// of the project.  It returns an empty string if the revision is HEAD and the
+// remote branch does not exist locally.
+func manifestRevision(jirix *jiri.X, local, remote Project) string {
+	if remote.Revision != "HEAD" {
+		return remote.Revision
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(local.Path))
+	revision, err := git.CurrentRevisionOfBranch("origin/" + remote.RemoteBranch)
+	if err != nil {
+		return ""
+	}
+	return revision
+}
+
+// String returns a one-line description of the difference.
+func (d ProjectDiff) String() string {
+	switch d.Kind {
+	case DiffMissing:
+		return fmt.Sprintf("%s: missing locally, manifest path %s", d.Name, d.ManifestPath)
+	case DiffNotInManifest:
+		return fmt.Sprintf("%s: not in the manifest, local path %s", d.Name, d.LocalPath)
+	case DiffPath:
+		return fmt.Sprintf("%s: local path %s, manifest path %s", d.Name, d.LocalPath, d.ManifestPath)
+	case DiffRevision:
+		return fmt.Sprintf("%s: local revision %s, manifest revision %s", d.Name, shortRevision(d.LocalRevision), shortRevision(d.ManifestRevision))
+	case DiffUnresolvable:
+		return fmt.Sprintf("%s: unresolvable remote import of %s, run \"jiri update\" to fetch it", d.Name, d.Remote)
+	}
+	return fmt.Sprintf("%s: %s", d.Name, d.Kind)
+}
+
+// The kinds of changes of the manifest since the latest update.
+const (
+	// ChangeAdded is a project added to the manifest.
+	ChangeAdded = "added"
+	// ChangeRemoved is a project removed from the manifest.
+	ChangeRemoved = "removed"
+	// ChangeRevision is a project whose revision changed in the manifest.
+	ChangeRevision = "revision"
+	// ChangeToolAdded is a tool added to the manifest.
+	ChangeToolAdded = "tool-added"
+	// ChangeToolRemoved is a tool removed from the manifest.
+	ChangeToolRemoved = "tool-removed"
+	// ChangeTool is a tool whose attributes changed in the manifest.
+	ChangeTool = "tool"
+)
+
+// ManifestChange describes a change of the manifest since the latest update.
+type ManifestChange struct {
+	// Kind is the kind of the change, one of the Change constants.
+	Kind string `json:"kind"`
+	// Name is the name of the project or tool.
+	Name string `json:"name"`
+	// Remote is the remote of the project.
+	Remote string `json:"remote,omitempty"`
+	// OldRevision and NewRevision are the revisions of the project as of
+	// the latest update and in the manifest.
+	OldRevision string `json:"oldRevision,omitempty"`
+	NewRevision string `json:"newRevision,omitempty"`
+	// Attributes are the changed attributes of the tool.
+	Attributes []string `json:"attributes,omitempty"`
+}
+
+// String returns a one-line description of the change.
+func (c ManifestChange) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("%s: added, remote %s", c.Name, c.Remote)
+	case ChangeRemoved:
+		return fmt.Sprintf("%s: removed", c.Name)
+	case ChangeRevision:
+		return fmt.Sprintf("%s: revision %s -> %s", c.Name, shortRevision(c.OldRevision), shortRevision(c.NewRevision))
+	case ChangeToolAdded:
+		return fmt.Sprintf("tool %s: added", c.Name)
+	case ChangeToolRemoved:
+		return fmt.Sprintf("tool %s: removed", c.Name)
+	case ChangeTool:
+		return fmt.Sprintf("tool %s: changed %s", c.Name, strings.Join(c.Attributes, ", "))
+	}
+	return fmt.Sprintf("%s: %s", c.Name, c.Kind)
+}
+
+// manifestChanges sorts manifest changes by kind and name.
+type manifestChanges []ManifestChange
+
+func (c manifestChanges) Len() int      { return len(c) }
+func (c manifestChanges) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c manifestChanges) Less(i, j int) bool {
+	if c[i].Kind != c[j].Kind {
+		return c[i].Kind < c[j].Kind
+	}
+	return c[i].Name < c[j].Name
+}
+
+// UpdateManifests updates the manifest projects of the remote imports to
+// match their remote counterparts, as UpdateUniverse does before updating the
+// projects, and returns the changes of the reloaded manifest since the latest
+// update, sorted by kind and name, without updating any other project or
+// running any hook or tool build.  The manifest is compared to the snapshot
+// written by the latest update: projects and tools that were added or removed,
+// tools whose attributes changed, and projects whose revision in the manifest
+// is not HEAD and differs from the revision they were updated to.  The changes
+// of projects that track a remote branch are not known until they are
+// fetched, so they are not reported.  Projects that are not in an enabled
+// group are ignored, and if the update history is empty, all projects and
+// tools are reported as added.
+func UpdateManifests(jirix *jiri.X, opts ...UpdateOpt) (_ []ManifestChange, e error) {
+	localProjects, err := LocalProjects(jirix, FastScan)
+	if err != nil {
+		return nil, err
+	}
+	var gitTimeout time.Duration
+	noVerify := false
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case GitTimeoutOpt:
+			gitTimeout = time.Duration(typedOpt)
+		case NoVerifyOpt:
+			noVerify = bool(typedOpt)
+		}
+	}
+	ld, err := loadUpdatedManifest(jirix, localProjects, gitTimeout, noVerify, nil)
+	defer collect.Error(ld.removeTmpDir, &e)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, _, err := LatestUpdateSnapshot(jirix)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		snapshot = &Manifest{}
+	}
+	enabled, err := enabledGroupSet(jirix)
+	if err != nil {
+		return nil, err
+	}
+	changes := manifestChanges{}
+	oldProjects := Projects{}
+	for _, project := range snapshot.Projects {
+		oldProjects[project.Key()] = project
+	}
+	for key, project := range ld.Projects {
+		if !inGroups(project.Groups, enabled) {
+			continue
+		}
+		old, ok := oldProjects[key]
+		switch {
+		case !ok:
+			changes = append(changes, ManifestChange{Kind: ChangeAdded, Name: project.Name, Remote: project.Remote, NewRevision: project.Revision})
+		case project.Revision != "" && project.Revision != "HEAD" && project.Revision != old.Revision:
+			changes = append(changes, ManifestChange{Kind: ChangeRevision, Name: project.Name, Remote: project.Remote, OldRevision: old.Revision, NewRevision: project.Revision})
+		}
+	}
+	for key, old := range oldProjects {
+		if _, ok := ld.Projects[key]; !ok {
+			changes = append(changes, ManifestChange{Kind: ChangeRemoved, Name: old.Name, Remote: old.Remote, OldRevision: old.Revision})
+		}
+	}
+	oldTools := Tools{}
+	for _, tool := range snapshot.Tools {
+		oldTools[tool.Name] = tool
+	}
+	for name, tool := range ld.Tools {
+		old, ok := oldTools[name]
+		if !ok {
+			changes = append(changes, ManifestChange{Kind: ChangeToolAdded, Name: name})
+		} else if attrs := conflictingAttributes(old, tool); len(attrs) > 0 {
+			changes = append(changes, ManifestChange{Kind: ChangeTool, Name: name, Attributes: attrs})
+		}
+	}
+	for name := range oldTools {
+		if _, ok := ld.Tools[name]; !ok {
+			changes = append(changes, ManifestChange{Kind: ChangeToolRemoved, Name: name})
+		}
+	}
+	sort.Sort(changes)
+	return changes, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"sort"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+	"v.io/jiri/tool"
+)
+
+// FetchResult describes the outcome of fetching a project.
+type FetchResult struct {
+	Project Project
+	// NewCommits is the number of commits fetched on the remote branch
+	// tracked by the project.
+	NewCommits int
+	Err        error
+}
+
+// String returns a one-line description of the fetch result.
+func (r FetchResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: ERROR: %v", r.Project.Name, r.Err)
+	}
+	return fmt.Sprintf("%s: %d new commits on origin/%s", r.Project.Name, r.NewCommits, r.Project.RemoteBranch)
+}
+
+type fetchResults []FetchResult
+
+func (r fetchResults) Len() int {
+	return len(r)
+}
+func (r fetchResults) Less(i, j int) bool {
+	return r[i].Project.Key() < r[j].Project.Key()
+}
+func (r fetchResults) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+// FetchProjects fetches the origin remote of each of the given projects, or
+// all of their remotes if all is set, using up to jobs concurrent workers.  If
+// prune is set, remote-tracking refs that no longer exist on the remote are
+// deleted.  Fetching only updates remote-tracking refs, so the working trees
+// and the local branches of the projects are left unchanged.  A project that
+// fails to fetch does not stop the others; the results, sorted by project
+// key, record the failures.
+func FetchProjects(jirix *jiri.X, projects Projects, all, prune bool, jobs int) []FetchResult {
+	keys := make(chan ProjectKey, len(projects))
+	results := make(chan FetchResult, len(projects))
+	if jobs > len(projects) {
+		jobs = len(projects)
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	for i := 0; i < jobs; i++ {
+		// jirix is not threadsafe, so we make a clone for each goroutine.
+		go func(jirix *jiri.X) {
+			for key := range keys {
+				results <- fetchProjectRemotes(jirix, projects[key], all, prune)
+			}
+		}(jirix.Clone(tool.ContextOpts{}))
+	}
+	for key := range projects {
+		keys <- key
+	}
+	close(keys)
+	var fetched fetchResults
+	for _ = range projects {
+		fetched = append(fetched, <-results)
+	}
+	sort.Sort(fetched)
+	return fetched
+}
+
+// fetchProjectRemotes fetches the remotes of the given project and counts
+// the commits fetched on its tracked remote branch.
+func fetchProjectRemotes(jirix *jiri.X, project Project, all, prune bool) FetchResult {
+	if err := project.fillDefaults(); err != nil {
+		return FetchResult{Project: project, Err: err}
+	}
+	result := FetchResult{Project: project}
+	if project.Protocol != "git" {
+		result.Err = UnsupportedProtocolErr(project.Protocol)
+		return result
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+	tracked := "refs/remotes/origin/" + project.RemoteBranch
+	// The tracked branch does not exist before the first fetch.
+	before, _ := git.CurrentRevisionOfBranch(tracked)
+	if all {
+		result.Err = git.FetchAll(gitutil.PruneOpt(prune))
+	} else {
+		result.Err = git.Fetch("origin", gitutil.PruneOpt(prune))
+	}
+	if result.Err != nil {
+		return result
+	}
+	after, err := git.CurrentRevisionOfBranch(tracked)
+	if err != nil || after == before {
+		return result
+	}
+	result.NewCommits, result.Err = git.CountCommits(after, before)
+	return result
+}
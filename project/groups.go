@@ -0,0 +1,91 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"sort"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/runutil"
+)
+
+// DefaultGroup is the group of the projects and imports that do not specify
+// any groups, and the only group that is enabled by default.
+const DefaultGroup = "default"
+
+// ParseGroups returns the groups in the given comma-separated list of groups,
+// or DefaultGroup if the list is empty.
+func ParseGroups(groups string) []string {
+	var result []string
+	for _, group := range strings.Split(groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			result = append(result, group)
+		}
+	}
+	if len(result) == 0 {
+		result = []string{DefaultGroup}
+	}
+	return result
+}
+
+// inGroups checks whether any of the given comma-separated list of groups is
+// enabled.
+func inGroups(groups string, enabled map[string]bool) bool {
+	for _, group := range ParseGroups(groups) {
+		if enabled[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// EnabledGroups returns the project groups that are enabled in the jiri root,
+// in sorted order.  Only DefaultGroup is enabled if no groups were set with
+// SetEnabledGroups.
+func EnabledGroups(jirix *jiri.X) ([]string, error) {
+	data, err := jirix.NewSeq().ReadFile(jirix.GroupsFile())
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return []string{DefaultGroup}, nil
+		}
+		return nil, err
+	}
+	groups := ParseGroups(strings.Replace(string(data), "\n", ",", -1))
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// SetEnabledGroups sets the project groups that are enabled in the jiri root.
+// Projects that are not in any of the enabled groups are not loaded from the
+// manifest, and thus not created by "jiri update".  If no groups are given,
+// only DefaultGroup is enabled.
+func SetEnabledGroups(jirix *jiri.X, groups []string) error {
+	set := map[string]bool{}
+	var sorted []string
+	for _, group := range groups {
+		if !set[group] {
+			set[group] = true
+			sorted = append(sorted, group)
+		}
+	}
+	sort.Strings(sorted)
+	data := strings.Join(sorted, "\n") + "\n"
+	return jirix.NewSeq().MkdirAll(jirix.RootMetaDir(), 0755).
+		WriteFile(jirix.GroupsFile(), []byte(data), 0644).Done()
+}
+
+// enabledGroupSet returns the enabled project groups as a set.
+func enabledGroupSet(jirix *jiri.X) (map[string]bool, error) {
+	groups, err := EnabledGroups(jirix)
+	if err != nil {
+		return nil, err
+	}
+	set := map[string]bool{}
+	for _, group := range groups {
+		set[group] = true
+	}
+	return set, nil
+}
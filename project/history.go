@@ -0,0 +1,346 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+)
+
+// UpdateRecord describes an update of the local projects recorded in the
+// update history.
+type UpdateRecord struct {
+	// File is the path to the snapshot of the local projects written at
+	// the end of the update.
+	File string
+	// Time is the time the update finished.
+	Time time.Time
+	// SnapshotPath is the path, relative to JIRI_ROOT, of the snapshot the
+	// local projects were updated to, or empty if they were updated to
+	// match the manifest.
+	SnapshotPath string
+	// ManifestPath is the path, relative to JIRI_ROOT, of the manifest file
+	// the local projects were updated from, e.g. ".jiri_manifest".  It is
+	// empty if they were updated to match a snapshot.
+	ManifestPath string
+	// Projects holds the projects recorded in the snapshot.
+	Projects Projects
+}
+
+// LatestUpdate returns the latest successful update recorded in the update
+// history, or nil if the update history is empty.
+func LatestUpdate(jirix *jiri.X) (*UpdateRecord, error) {
+	file, err := historyLinkTarget(jirix.UpdateHistoryLatestLink())
+	if err != nil || file == "" {
+		return nil, err
+	}
+	if file, err = filepath.EvalSymlinks(file); err != nil {
+		return nil, err
+	}
+	manifest, err := ManifestFromFile(jirix, file)
+	if err != nil {
+		return nil, err
+	}
+	projects, _, err := LoadSnapshotFile(jirix, file)
+	if err != nil {
+		return nil, err
+	}
+	update := &UpdateRecord{
+		File:     file,
+		Projects: projects,
+	}
+	// Snapshots written by "jiri update" record their own path in the
+	// update history, while those written by "jiri snapshot checkout" record
+	// the path of the snapshot that was checked out.
+	historyDir, err := filepath.EvalSymlinks(jirix.UpdateHistoryDir())
+	if err != nil {
+		return nil, err
+	}
+	if snapshotPath := manifest.SnapshotPath; snapshotPath != "" {
+		if !filepath.IsAbs(snapshotPath) {
+			snapshotPath = filepath.Join(jirix.Root, snapshotPath)
+		}
+		if evaled, err := filepath.EvalSymlinks(snapshotPath); err == nil {
+			snapshotPath = evaled
+		}
+		if filepath.Dir(snapshotPath) != historyDir {
+			update.SnapshotPath = manifest.SnapshotPath
+		}
+	}
+	if update.SnapshotPath == "" {
+		update.ManifestPath = manifest.ManifestPath
+		if update.ManifestPath == "" {
+			update.ManifestPath = jiri.JiriManifestFile
+		}
+	}
+	if update.Time, err = snapshotFileTime(file); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// relativeManifestPath returns the path of the manifest file loaded by jirix,
+// relative to JIRI_ROOT if it is under it.
+func relativeManifestPath(jirix *jiri.X) string {
+	file := jirix.JiriManifestFile()
+	if rel, err := filepath.Rel(jirix.Root, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
+
+// WarnManifestChange prints a warning if the latest update in the update
+// history updated the local projects from another manifest file than the one
+// loaded by jirix, e.g. because it was run with another -manifest flag.
+func WarnManifestChange(jirix *jiri.X) error {
+	update, err := LatestUpdate(jirix)
+	if err != nil || update == nil || update.ManifestPath == "" {
+		return err
+	}
+	if manifestPath := relativeManifestPath(jirix); manifestPath != update.ManifestPath {
+		fmt.Fprintf(jirix.Stderr(), "WARNING: the projects were last updated from %s, not from %s\n", update.ManifestPath, manifestPath)
+	}
+	return nil
+}
+
+// LatestUpdateSnapshot returns the snapshot of the local projects written at
+// the end of the latest successful update, and the time of the update.  It
+// returns a nil manifest if the update history is empty, or if the latest
+// link points to a snapshot that no longer exists.
+func LatestUpdateSnapshot(jirix *jiri.X) (*Manifest, time.Time, error) {
+	return SnapshotAt(jirix, "latest")
+}
+
+// SnapshotAt returns a snapshot from the update history, and the time of the
+// update that wrote it.  The snapshot is identified by the label "latest" or
+// "second-latest", or by a time in RFC3339 format, which selects the latest
+// snapshot written at or before that time; the names of the snapshots in the
+// update history are such times.  It returns a nil manifest if there is no
+// such snapshot.
+func SnapshotAt(jirix *jiri.X, timeOrLabel string) (*Manifest, time.Time, error) {
+	var file string
+	var err error
+	switch timeOrLabel {
+	case "latest":
+		file, err = historyLinkTarget(jirix.UpdateHistoryLatestLink())
+	case "second-latest":
+		file, err = historyLinkTarget(jirix.UpdateHistorySecondLatestLink())
+	default:
+		t, parseErr := time.Parse(time.RFC3339, timeOrLabel)
+		if parseErr != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid update history snapshot %q: want \"latest\", \"second-latest\" or a time in RFC3339 format", timeOrLabel)
+		}
+		var entries historyEntries
+		if entries, err = readHistoryEntries(jirix.UpdateHistoryDir()); err != nil {
+			break
+		}
+		for _, entry := range entries {
+			if !entry.time.After(t) {
+				file = filepath.Join(jirix.UpdateHistoryDir(), entry.name)
+				break
+			}
+		}
+	}
+	if err != nil || file == "" {
+		return nil, time.Time{}, err
+	}
+	manifest, err := ManifestFromFile(jirix, file)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	t, err := snapshotFileTime(file)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return manifest, t, nil
+}
+
+// historyLinkTarget returns the snapshot file that the given update history
+// link points to, or an empty string if the link or the file does not exist.
+// The link is a symlink or a pointer file, as written by jiri.WritePointer.
+func historyLinkTarget(link string) (string, error) {
+	target, err := jiri.ResolvePointer(link)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return target, nil
+}
+
+// snapshotFileTime returns the time the given update history snapshot was
+// written.  Update history snapshots are named after the time they were
+// written; fall back on the modification time for files that are not.
+func snapshotFileTime(file string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, filepath.Base(file)); err == nil {
+		return t, nil
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// UpdateHistoryKeepOpt causes WriteUpdateHistorySnapshot to prune the update
+// history down to the given number of the most recent snapshots, after
+// writing the new one.  Zero means no limit.
+type UpdateHistoryKeepOpt int
+
+// UpdateHistoryMaxAgeOpt causes WriteUpdateHistorySnapshot to prune the
+// snapshots older than the given age from the update history, after writing
+// the new one.  Zero means no limit.
+type UpdateHistoryMaxAgeOpt time.Duration
+
+// UpdateHistoryPointerFileOpt causes WriteUpdateHistorySnapshot to write the
+// latest and second-latest links as pointer files, even where symlinks are
+// supported.  See jiri.WritePointer.
+type UpdateHistoryPointerFileOpt bool
+
+// ForceSnapshotOpt causes WriteUpdateHistorySnapshot to write a new snapshot
+// even if it is identical to the latest one.
+type ForceSnapshotOpt bool
+
+func (UpdateHistoryKeepOpt) snapshotOpt()        {}
+func (UpdateHistoryMaxAgeOpt) snapshotOpt()      {}
+func (UpdateHistoryPointerFileOpt) snapshotOpt() {}
+func (ForceSnapshotOpt) snapshotOpt()            {}
+
+// sameSnapshot returns true if the given snapshot manifest serializes to the
+// contents of the latest update history snapshot file, or false if there is
+// no latest file.  If ownPath is set, the manifest records its own path, and
+// the path recorded by the latest file is used for the comparison instead, as
+// update history snapshots are named after the time they were written.
+func sameSnapshot(jirix *jiri.X, manifest *Manifest, latest string, ownPath bool) (bool, error) {
+	if latest == "" {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(latest)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	m := manifest.deepCopy()
+	if ownPath {
+		latestManifest, err := ManifestFromBytes(data)
+		if err != nil {
+			// Snapshots that cannot be parsed are never the same.
+			return false, nil
+		}
+		if filepath.Base(latestManifest.SnapshotPath) != filepath.Base(latest) {
+			return false, nil
+		}
+		m.SnapshotPath = latestManifest.SnapshotPath
+	}
+	newData, err := m.toFileBytes(jirix.Root)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(data, newData), nil
+}
+
+// pruneTmpPrefix is the prefix of the names that update history snapshots are
+// renamed to before they are deleted.
+const pruneTmpPrefix = ".pruning-"
+
+// historyEntry is a snapshot in the update history directory.
+type historyEntry struct {
+	name string
+	time time.Time
+	size int64
+}
+
+// historyEntries sorts history entries from the newest to the oldest.
+type historyEntries []historyEntry
+
+func (h historyEntries) Len() int           { return len(h) }
+func (h historyEntries) Less(i, j int) bool { return h[i].time.After(h[j].time) }
+func (h historyEntries) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+// readHistoryEntries returns the snapshots in the given update history
+// directory, from the newest to the oldest.  A missing directory has no
+// snapshots.
+func readHistoryEntries(dir string) (historyEntries, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := historyEntries{}
+	for _, info := range infos {
+		// Skip the pointer files that replace the latest and second-latest
+		// symlinks where symlinks are not supported.
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), pruneTmpPrefix) || strings.HasSuffix(info.Name(), jiri.PointerFileSuffix) {
+			continue
+		}
+		entry := historyEntry{name: info.Name(), time: info.ModTime(), size: info.Size()}
+		if t, err := time.Parse(time.RFC3339, info.Name()); err == nil {
+			entry.time = t
+		}
+		entries = append(entries, entry)
+	}
+	sort.Sort(entries)
+	return entries, nil
+}
+
+// PruneUpdateHistory deletes the oldest snapshots from the update history,
+// keeping at most keep snapshots and none older than maxAge; a zero keep or
+// maxAge means no limit.  The snapshots pointed to by the latest and
+// second-latest links are never deleted.  It returns the number of snapshots
+// deleted and their total size in bytes.
+//
+// Snapshots are renamed before they are deleted, so that concurrent readers
+// see either the complete file or no file, and snapshots deleted by a
+// concurrent prune are skipped.
+func PruneUpdateHistory(jirix *jiri.X, keep int, maxAge time.Duration) (int, int64, error) {
+	if keep <= 0 && maxAge <= 0 {
+		return 0, 0, nil
+	}
+	dir := jirix.UpdateHistoryDir()
+	entries, err := readHistoryEntries(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	protected := map[string]bool{}
+	for _, link := range []string{jirix.UpdateHistoryLatestLink(), jirix.UpdateHistorySecondLatestLink()} {
+		if target, err := jiri.ReadPointer(link); err == nil {
+			protected[filepath.Base(target)] = true
+		}
+	}
+	removed, size := 0, int64(0)
+	for i, entry := range entries {
+		tooMany := keep > 0 && i >= keep
+		tooOld := maxAge > 0 && time.Since(entry.time) > maxAge
+		if protected[entry.name] || !(tooMany || tooOld) {
+			continue
+		}
+		path, tmpPath := filepath.Join(dir, entry.name), filepath.Join(dir, pruneTmpPrefix+entry.name)
+		if err := os.Rename(path, tmpPath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, size, err
+		}
+		if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+			return removed, size, err
+		}
+		removed++
+		size += entry.size
+	}
+	return removed, size, nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"v.io/jiri"
+)
+
+// InstallTools installs the tools from the given directory into
+// $JIRI_ROOT/.jiri_root/bin.
+//
+// The tools are installed as a set: the tools that were installed before are
+// backed up first, and restored if any tool fails to install, so that the bin
+// directory never holds a mix of old and new tools.  After a successful
+// installation, the backup replaces the previous set of tools in
+// $JIRI_ROOT/.jiri_root/bin.previous, which RollbackTools swaps back in.
+func InstallTools(jirix *jiri.X, dir string) error {
+	_, err := installTools(jirix, dir, true)
+	return err
+}
+
+// installTools implements InstallTools, logging each installation if verbose
+// is true, and returns the names of the tools that were installed.
+func installTools(jirix *jiri.X, dir string, verbose bool) ([]string, error) {
+	jirix.TimerPushCategory(jiri.TimerInstallTools, "install tools")
+	defer jirix.TimerPop()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ReadDir(%v) failed: %v", dir, err)
+	}
+	binDir := jirix.BinDir()
+	if err := jirix.NewSeq().MkdirAll(binDir, 0755).Done(); err != nil {
+		return nil, fmt.Errorf("MkdirAll(%v) failed: %v", binDir, err)
+	}
+	if err := recoverToolsBackup(jirix); err != nil {
+		return nil, err
+	}
+	backupDir := toolsBackupDir(jirix)
+	if err := backupTools(jirix, binDir, backupDir); err != nil {
+		return nil, fmt.Errorf("error backing up tools: %v", err)
+	}
+	s := jirix.NewSeq()
+	var installed []string
+	for _, fi := range fis {
+		installFn := func() error {
+			src := filepath.Join(dir, fi.Name())
+			dst := filepath.Join(binDir, fi.Name())
+			_, statErr := os.Stat(dst)
+			if err := jirix.NewSeq().Rename(src, dst).Done(); err != nil {
+				return err
+			}
+			if statErr == nil {
+				jirix.Audit("replace tool", "replaced tool %s", dst)
+			}
+			return nil
+		}
+		if err := s.Verbose(verbose).Call(installFn, "install tool %q", fi.Name()).Done(); err != nil {
+			err = fmt.Errorf("error installing tool %q: %v", fi.Name(), err)
+			if restoreErr := removeNewTools(jirix, installed); restoreErr != nil {
+				return nil, fmt.Errorf("%v; error removing the new tools: %v", err, restoreErr)
+			}
+			if restoreErr := recoverToolsBackup(jirix); restoreErr != nil {
+				return nil, fmt.Errorf("%v; error restoring the previous tools: %v", err, restoreErr)
+			}
+			return nil, err
+		}
+		installed = append(installed, fi.Name())
+	}
+	// Keep the backup as the previous set of tools.
+	previousDir := jirix.PreviousBinDir()
+	if err := jirix.NewSeq().RemoveAll(previousDir).Rename(backupDir, previousDir).Done(); err != nil {
+		return installed, fmt.Errorf("error keeping the previous tools: %v", err)
+	}
+	return installed, nil
+}
+
+// toolsBackupDir returns the directory that the installed tools are backed up
+// to while new tools are installed.
+func toolsBackupDir(jirix *jiri.X) string {
+	return jirix.PreviousBinDir() + ".tmp"
+}
+
+// backupTools backs up the files of binDir to a new backupDir.  The files are
+// hard links where possible, so that backups are cheap.
+func backupTools(jirix *jiri.X, binDir, backupDir string) error {
+	s := jirix.NewSeq()
+	if err := s.RemoveAll(backupDir).MkdirAll(backupDir, 0755).Done(); err != nil {
+		return err
+	}
+	fis, err := s.ReadDir(binDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		src, dst := filepath.Join(binDir, fi.Name()), filepath.Join(backupDir, fi.Name())
+		if err := os.Link(src, dst); err == nil {
+			continue
+		}
+		data, err := s.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := s.WriteFile(dst, data, fi.Mode().Perm()).Done(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeNewTools removes the given installed tools that have no backup, i.e.
+// that were not installed before.
+func removeNewTools(jirix *jiri.X, installed []string) error {
+	s := jirix.NewSeq()
+	for _, name := range installed {
+		if _, err := os.Stat(filepath.Join(toolsBackupDir(jirix), name)); !os.IsNotExist(err) {
+			continue
+		}
+		if err := s.RemoveAll(filepath.Join(jirix.BinDir(), name)).Done(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverToolsBackup restores the tools backed up by an installation that
+// failed or was interrupted, if there is a backup.  Tools that were not
+// installed before the installation are left in place.
+func recoverToolsBackup(jirix *jiri.X) error {
+	backupDir, binDir := toolsBackupDir(jirix), jirix.BinDir()
+	s := jirix.NewSeq()
+	fis, err := ioutil.ReadDir(backupDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range fis {
+		if err := s.Rename(filepath.Join(backupDir, fi.Name()), filepath.Join(binDir, fi.Name())).Done(); err != nil {
+			return err
+		}
+	}
+	return s.RemoveAll(backupDir).Done()
+}
+
+// RollbackTools swaps the tools installed in $JIRI_ROOT/.jiri_root/bin with
+// the previous set of tools, i.e. those installed before the latest successful
+// InstallTools, so that calling it again undoes the rollback.
+func RollbackTools(jirix *jiri.X) error {
+	if err := recoverToolsBackup(jirix); err != nil {
+		return err
+	}
+	binDir, previousDir := jirix.BinDir(), jirix.PreviousBinDir()
+	s := jirix.NewSeq()
+	if _, err := os.Stat(previousDir); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no previous tools to roll back to: %s does not exist", previousDir)
+		}
+		return err
+	}
+	tmpDir := binDir + ".rollback"
+	if err := s.RemoveAll(tmpDir).Rename(binDir, tmpDir).Done(); err != nil {
+		return err
+	}
+	if err := s.Rename(previousDir, binDir).Done(); err != nil {
+		if restoreErr := jirix.NewSeq().Rename(tmpDir, binDir).Done(); restoreErr != nil {
+			return fmt.Errorf("%v; error restoring the current tools: %v", err, restoreErr)
+		}
+		return err
+	}
+	return s.Rename(tmpDir, previousDir).Done()
+}
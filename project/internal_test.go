@@ -4,5 +4,50 @@
 
 package project
 
+import "sort"
+
 // InternalWriteMetadata exports writeMetadata for tests.
 var InternalWriteMetadata = writeMetadata
+
+// InternalComputeOperations exports computeOperations for tests.
+func InternalComputeOperations(localProjects, remoteProjects Projects, gc bool) []interface {
+	Kind() string
+	Project() Project
+} {
+	var ops []interface {
+		Kind() string
+		Project() Project
+	}
+	for _, op := range computeOperations(localProjects, remoteProjects, gc) {
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// InternalRemoteHeadHosts returns the sorted googlesource hosts that
+// getRemoteHeadRevisions queries for the given projects and allowed hosts.
+func InternalRemoteHeadHosts(projects Projects, hosts []string) []string {
+	var result []string
+	for host := range allowedGoogleSourceHosts(groupByGoogleSourceHosts(projects), hosts) {
+		result = append(result, host)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// InternalSetCaseInsensitiveFS makes the filesystem of the jiri root appear
+// case-insensitive, or not, and returns a function that restores the check.
+func InternalSetCaseInsensitiveFS(insensitive bool) func() {
+	saved := caseInsensitiveFS
+	caseInsensitiveFS = func(string) (bool, error) { return insensitive, nil }
+	return func() { caseInsensitiveFS = saved }
+}
+
+// InternalRemoteHost exports remoteHost for tests.
+var InternalRemoteHost = remoteHost
+
+// InternalGoWorkspace exports goWorkspace for tests.
+var InternalGoWorkspace = goWorkspace
+
+// InternalHookCommand exports hookCommand for tests.
+var InternalHookCommand = hookCommand
@@ -0,0 +1,157 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"v.io/jiri"
+)
+
+// ManifestProblem describes a problem found in a manifest by LintManifest.
+type ManifestProblem struct {
+	// File is the manifest file the problem was found in, relative to the
+	// jiri root if it is inside the root.
+	File string `json:"file"`
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+// String returns a one-line description of the problem.
+func (p ManifestProblem) String() string {
+	return p.File + ": " + p.Message
+}
+
+// reservedPaths are the paths of the jiri root that projects cannot be
+// stored at.
+var reservedPaths = []string{jiri.RootMetaDir, jiri.JiriManifestFile, ".manifest"}
+
+// manifestLint records the problems found by a loader in lint mode.
+type manifestLint struct {
+	problems []ManifestProblem
+}
+
+func newManifestLint() *manifestLint {
+	return &manifestLint{}
+}
+
+func (l *manifestLint) report(jirix *jiri.X, file, format string, args ...interface{}) {
+	l.problems = append(l.problems, ManifestProblem{
+		File:    shortFileName(jirix.Root, file),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkProject reports the problems of the given project of the manifest
+// file, whose paths are relative to the given root of the import.
+func (l *manifestLint) checkProject(jirix *jiri.X, file, root string, p Project) {
+	base := filepath.Join(jirix.Root, root)
+	path := p.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	rel, err := filepath.Rel(jirix.Root, path)
+	switch {
+	case err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)):
+		l.report(jirix, file, "path %s of project %q is outside the jiri root", p.Path, p.Name)
+	case rel == ".":
+		l.report(jirix, file, "path %s of project %q is the jiri root", p.Path, p.Name)
+	default:
+		for _, reserved := range reservedPaths {
+			if rel == reserved || strings.HasPrefix(rel, reserved+string(filepath.Separator)) {
+				l.report(jirix, file, "path %s of project %q collides with %s", p.Path, p.Name, reserved)
+			}
+		}
+	}
+	for _, hook := range []struct{ attr, path string }{
+		{"githooks", p.GitHooks},
+		{"runhook", p.RunHook},
+	} {
+		if hook.path == "" || filepath.IsAbs(hook.path) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(base, hook.path)); err != nil {
+			l.report(jirix, file, "%s %s of project %q does not exist", hook.attr, hook.path, p.Name)
+		}
+	}
+	l.checkRemote(jirix, file, p.Remote, fmt.Sprintf("project %q", p.Name))
+}
+
+// checkRemote reports the given remote of the manifest file, described by
+// what, unless it is a URL, an scp-like address such as "git@host:path", or
+// an absolute path, which are the remotes git accepts.
+func (l *manifestLint) checkRemote(jirix *jiri.X, file, remote, what string) {
+	if remote == "" || filepath.IsAbs(remote) {
+		return
+	}
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" {
+		return
+	}
+	if i := strings.Index(remote, ":"); i > 0 && !strings.Contains(remote[:i], "/") {
+		return
+	}
+	l.report(jirix, file, "remote %q of %s has no scheme", remote, what)
+}
+
+// lintLoad loads the manifest file imported by the given file in lint mode,
+// or reports it as unreachable if it does not exist.
+func (ld *loader) lintLoad(jirix *jiri.X, importer, root, file, cycleKey string) error {
+	if _, err := os.Stat(file); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		ld.lint.report(jirix, importer, "unreachable import: %s does not exist", shortFileName(jirix.Root, file))
+		return nil
+	}
+	return ld.Load(jirix, root, file, cycleKey)
+}
+
+// LintManifest loads the manifest starting with each of the given files, or
+// with $JIRI_ROOT/.jiri_manifest if there are none, and returns the problems
+// found in the manifests: files that cannot be parsed, elements and
+// attributes unknown to this version of jiri, duplicate projects and tools,
+// project paths outside the jiri root, colliding with its metadata or
+// differing from other project paths only in case,
+// missing relative githooks and runhook paths, remotes without a scheme, and
+// imports that are cyclic or unreachable.
+//
+// Unlike LoadManifest, LintManifest does not run git: the projects of all
+// groups are loaded, and the manifests of remote imports are read from the
+// working trees of their local projects, without resetting the projects to
+// the revisions of the imports.  Remote imports whose projects do not exist
+// locally are reported as unreachable, rather than cloned.
+func LintManifest(jirix *jiri.X, files ...string) ([]ManifestProblem, error) {
+	localProjects, err := LocalProjects(jirix, FastScan)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		files = []string{jirix.JiriManifestFile()}
+	}
+	var problems []ManifestProblem
+	seen := map[ManifestProblem]bool{}
+	for _, file := range files {
+		ld := newManifestLoader(localProjects, false)
+		ld.lint = newManifestLint()
+		if err := ld.Load(jirix, "", file, ""); err != nil {
+			return nil, err
+		}
+		for _, c := range caseCollisions(ld.Projects) {
+			ld.lint.report(jirix, ld.projectSources[c[1].Key()].File, "paths %s of project %q and %s of project %q differ only in case, and collide on case-insensitive filesystems", shortFileName(jirix.Root, c[0].Path), c[0].Name, shortFileName(jirix.Root, c[1].Path), c[1].Name)
+		}
+		// The given files may import the same manifests.
+		for _, problem := range ld.lint.problems {
+			if !seen[problem] {
+				seen[problem] = true
+				problems = append(problems, problem)
+			}
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/collect"
+	"v.io/jiri/runutil"
+)
+
+// ImportLock records the revision of the manifest project that a remote
+// import was loaded at.  The manifest lock, $JIRI_ROOT/.jiri_root/manifest.lock,
+// holds the import locks of the latest update, so that "jiri update -frozen"
+// can load the same manifests again, and snapshots embed it.
+type ImportLock struct {
+	// Name is the name of the manifest project of the import, including
+	// the root of the import, if any.
+	Name string `xml:"name,attr,omitempty"`
+	// Remote is the remote of the manifest project of the import.
+	Remote string `xml:"remote,attr,omitempty"`
+	// Manifest is the manifest file of the import.
+	Manifest string `xml:"manifest,attr,omitempty"`
+	// Revision is the revision of the manifest project the import was
+	// loaded at.
+	Revision string   `xml:"revision,attr,omitempty"`
+	XMLName  struct{} `xml:"lock"`
+}
+
+// key returns the key that identifies the import of the lock.
+func (l ImportLock) key() string {
+	return importLockKey(l.Name, l.Remote, l.Manifest)
+}
+
+// importLockKey returns the key that identifies the import of the given
+// manifest file from the given manifest project.
+func importLockKey(name, remote, manifest string) string {
+	return name + "\x00" + remote + "\x00" + manifest
+}
+
+// manifestLock is the contents of the manifest lock file.
+type manifestLock struct {
+	Locks   []ImportLock `xml:"lock"`
+	XMLName struct{}     `xml:"locks"`
+}
+
+// sortImportLocks sorts the given locks by name, remote and manifest, so
+// that serializing the same locks yields the same bytes.
+func sortImportLocks(locks []ImportLock) {
+	sort.SliceStable(locks, func(i, j int) bool { return locks[i].key() < locks[j].key() })
+}
+
+// readManifestLock returns the import locks of the manifest lock file, or an
+// error satisfying runutil.IsNotExist if there is none.
+func readManifestLock(jirix *jiri.X) ([]ImportLock, error) {
+	data, err := jirix.NewSeq().ReadFile(jirix.ManifestLockFile())
+	if err != nil {
+		return nil, err
+	}
+	var lock manifestLock
+	if err := xml.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("invalid manifest lock %s: %v", jirix.ManifestLockFile(), err)
+	}
+	return lock.Locks, nil
+}
+
+// writeManifestLock writes the given import locks to the manifest lock file.
+func writeManifestLock(jirix *jiri.X, locks []ImportLock) error {
+	lock := manifestLock{Locks: append([]ImportLock(nil), locks...)}
+	sortImportLocks(lock.Locks)
+	data, err := xml.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("manifest lock xml.Marshal failed: %v", err)
+	}
+	data = bytes.Replace(data, endLockBytes, endElemBytes, -1)
+	return safeWriteFile(jirix, jirix.ManifestLockFile(), append(data, '\n'))
+}
+
+// lockedRevisions returns the revisions of the manifest lock, keyed by the
+// imports they lock, for FrozenOpt.
+func lockedRevisions(jirix *jiri.X) (map[string]string, error) {
+	locks, err := readManifestLock(jirix)
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot load the manifest at the locked revisions: %s does not exist; run \"jiri update\" or \"jiri update -update-lock\" to create it", jirix.ManifestLockFile())
+		}
+		return nil, err
+	}
+	revisions := map[string]string{}
+	for _, lock := range locks {
+		revisions[lock.key()] = lock.Revision
+	}
+	return revisions, nil
+}
+
+// restoreManifestLock writes the import locks embedded in the given snapshot
+// file, if any, to the manifest lock file.
+func restoreManifestLock(jirix *jiri.X, snapshot string) error {
+	m, err := ManifestFromFile(jirix, snapshot)
+	if err != nil {
+		return err
+	}
+	if len(m.Locks) == 0 {
+		return nil
+	}
+	return writeManifestLock(jirix, m.Locks)
+}
+
+// UpdateManifestLock loads the manifest, fetching the manifest projects of
+// the remote imports and resolving their branches, and records the revisions
+// they were loaded at in the manifest lock, without updating the other
+// projects.  The manifest lock is otherwise only written by UpdateUniverse,
+// once the update succeeds.
+func UpdateManifestLock(jirix *jiri.X, opts ...UpdateOpt) (e error) {
+	localProjects, err := LocalProjects(jirix, FastScan)
+	if err != nil {
+		return err
+	}
+	var gitTimeout time.Duration
+	noVerify := false
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case GitTimeoutOpt:
+			gitTimeout = time.Duration(typedOpt)
+		case NoVerifyOpt:
+			noVerify = bool(typedOpt)
+		}
+	}
+	ld, err := loadUpdatedManifest(jirix, localProjects, gitTimeout, noVerify, nil)
+	defer collect.Error(ld.removeTmpDir, &e)
+	if err != nil {
+		return err
+	}
+	return writeManifestLock(jirix, ld.resolved)
+}
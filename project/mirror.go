@@ -0,0 +1,170 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/collect"
+	"v.io/jiri/gitutil"
+	"v.io/jiri/runutil"
+)
+
+// ReferenceDirOpt causes UpdateUniverse and CheckoutSnapshot to clone new
+// projects using the mirror repositories in the given directory, as created
+// by MirrorProjects, as references, borrowing objects from them instead of
+// fetching the objects from the remotes.
+type ReferenceDirOpt string
+
+// DissociateOpt causes UpdateUniverse and CheckoutSnapshot, along with
+// ReferenceDirOpt, to copy the objects borrowed from the reference
+// repositories into the new projects, so that the projects do not depend on
+// the reference repositories once cloned.
+type DissociateOpt bool
+
+func (ReferenceDirOpt) updateOpt() {}
+func (DissociateOpt) updateOpt()   {}
+
+// referenceRepos describes the reference repositories used to clone projects.
+type referenceRepos struct {
+	dir        string
+	dissociate bool
+}
+
+// MirrorPath returns the path of the mirror repository of the given project
+// in the given mirror directory.
+func MirrorPath(dir string, project Project) string {
+	return filepath.Join(dir, project.Name+".git")
+}
+
+// remoteMirrorPath returns the path of a mirror repository of the given
+// project in the given mirror directory that is derived from the remote URL
+// of the project, e.g. <dir>/example.com/foo/bar.git for the remote
+// https://example.com/foo/bar, or "" if the remote is not a URL.
+func remoteMirrorPath(dir string, project Project) string {
+	u, err := url.Parse(project.Remote)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if path == "" {
+		return ""
+	}
+	return filepath.Join(dir, u.Host, filepath.FromSlash(path)+".git")
+}
+
+// lookup returns the path of a usable reference repository for the given
+// project, or "" if there is none.
+func (r referenceRepos) lookup(jirix *jiri.X, project Project) string {
+	if r.dir == "" {
+		return ""
+	}
+	for _, path := range []string{MirrorPath(r.dir, project), remoteMirrorPath(r.dir, project)} {
+		if path == "" {
+			continue
+		}
+		if isDir, err := jirix.NewSeq().IsDir(path); err != nil || !isDir {
+			continue
+		}
+		// A repository without a valid HEAD is not used, so that a corrupt
+		// mirror degrades to a normal clone.
+		if _, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(path)).CurrentRevision(); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "WARNING: ignoring reference repository %q of project %q: %v\n", path, project.Name, err)
+			continue
+		}
+		return path
+	}
+	return ""
+}
+
+// clone clones the given project into the given empty directory, using a
+// reference repository if one is available.  If cloning with the reference
+// repository fails, the project is cloned again without it.  Projects with a
+// clonefilter are partially cloned, without checking out a working tree.
+func (r referenceRepos) clone(jirix *jiri.X, project Project, dir string, gitTimeout time.Duration) error {
+	git := gitutil.New(jirix.NewSeq(), gitutil.TimeoutOpt(gitTimeout))
+	var opts []gitutil.CloneOpt
+	if project.CloneFilter != "" {
+		opts = append(opts, gitutil.FilterOpt(project.CloneFilter), gitutil.NoCheckoutOpt(true))
+	}
+	if reference := r.lookup(jirix, project); reference != "" {
+		refOpts := append([]gitutil.CloneOpt{gitutil.ReferenceOpt(reference), gitutil.DissociateOpt(r.dissociate)}, opts...)
+		err := git.Clone(project.Remote, dir, refOpts...)
+		if err == nil || gitutil.IsTimeout(err) {
+			return timeoutError(err, gitTimeout, "cloning project %q from %q", project.Name, project.Remote)
+		}
+		fmt.Fprintf(jirix.Stderr(), "WARNING: cloning project %q using reference repository %q failed, cloning it without the reference: %v\n", project.Name, reference, err)
+		if err := jirix.NewSeq().RemoveAll(dir).MkdirAll(dir, os.FileMode(0755)).Done(); err != nil {
+			return err
+		}
+	}
+	err := authenticationError(git.Clone(project.Remote, dir, opts...), project.Remote, "cloning project %q", project.Name)
+	return timeoutError(err, gitTimeout, "cloning project %q from %q", project.Name, project.Remote)
+}
+
+// MirrorProjects creates or refreshes a bare mirror repository, in the given
+// directory, of each git project in the manifest, for use with
+// ReferenceDirOpt.  The manifest is read from the local manifest projects,
+// so the projects of remote imports are only mirrored once they have been
+// fetched by UpdateUniverse.  New mirrors are cloned into a temporary
+// directory first, so that an interrupted clone does not leave a partial
+// mirror behind.  All projects are mirrored even if some of them fail.
+func MirrorProjects(jirix *jiri.X, dir string) error {
+	projects, _, err := LoadManifest(jirix)
+	if err != nil {
+		return err
+	}
+	if err := jirix.NewSeq().MkdirAll(dir, os.FileMode(0755)).Done(); err != nil {
+		return err
+	}
+	failed := []string{}
+	for _, key := range sortedKeys(projects) {
+		project := projects[key]
+		if project.Protocol != "git" {
+			continue
+		}
+		if err := mirrorProject(jirix, dir, project); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "ERROR: mirroring project %q failed: %v\n", project.Name, err)
+			failed = append(failed, project.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to mirror projects: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// mirrorProject creates or refreshes the mirror of the given project.
+func mirrorProject(jirix *jiri.X, dir string, project Project) (e error) {
+	s := jirix.NewSeq()
+	path := MirrorPath(dir, project)
+	if _, err := s.Stat(path); err == nil {
+		fmt.Fprintf(jirix.Stdout(), "Updating mirror of project %q in %q\n", project.Name, path)
+		return gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(path)).Fetch("origin", gitutil.PruneOpt(true))
+	} else if !runutil.IsNotExist(err) {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "Creating mirror of project %q in %q\n", project.Name, path)
+	parent := filepath.Dir(path)
+	tmpDir, err := s.MkdirAll(parent, os.FileMode(0755)).TempDir(parent, filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer collect.Error(func() error { return jirix.NewSeq().RemoveAll(tmpDir).Done() }, &e)
+	if err := gitutil.New(jirix.NewSeq()).Clone(project.Remote, tmpDir, gitutil.MirrorOpt(true)); err != nil {
+		return err
+	}
+	if runtime.GOOS != "windows" {
+		s.Chmod(tmpDir, os.FileMode(0755))
+	}
+	return s.Rename(tmpDir, path).Done()
+}
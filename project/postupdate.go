@@ -0,0 +1,115 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/tool"
+	"v.io/x/lib/envvar"
+)
+
+// postUpdateResult records the outcome of running a post-update command.
+type postUpdateResult struct {
+	command  string
+	failOk   bool
+	duration time.Duration
+	// output holds the combined stdout and stderr of the command.
+	output string
+	err    error
+}
+
+// describePostUpdate returns a description of the given post-update command.
+func describePostUpdate(postUpdate PostUpdate) string {
+	if postUpdate.Project == "" {
+		return fmt.Sprintf("%q", postUpdate.Command)
+	}
+	return fmt.Sprintf("%q in project %q", postUpdate.Command, postUpdate.Project)
+}
+
+// reportSkippedPostUpdates prints the post-update commands that
+// runPostUpdates would have run.
+func reportSkippedPostUpdates(jirix *jiri.X, postUpdates []PostUpdate) {
+	var commands []string
+	for _, postUpdate := range postUpdates {
+		commands = append(commands, "  "+describePostUpdate(postUpdate))
+	}
+	if len(commands) > 0 {
+		fmt.Fprintf(jirix.Stdout(), "Post-update commands are disabled, skipped the following commands:\n%s\n", strings.Join(commands, "\n"))
+	}
+}
+
+// runPostUpdates runs the given post-update commands in order, in the
+// directories of their projects, which are looked up in the given projects,
+// or in the jiri root.  The commands run with the environment of jiri, with
+// JIRI_ROOT set.  A failing command fails the update, unless its failok
+// attribute is set, in which case the failure is only reported.
+func runPostUpdates(jirix *jiri.X, summary *updateSummary, projects Projects, postUpdates []PostUpdate) error {
+	if len(postUpdates) == 0 {
+		return nil
+	}
+	jirix.TimerPush("run post-update commands")
+	defer jirix.TimerPop()
+	env := jirix.Env()
+	if len(env) == 0 {
+		env = envvar.SliceToMap(os.Environ())
+	}
+	env = envvar.CopyMap(env)
+	env["JIRI_ROOT"] = jirix.Root
+	for _, postUpdate := range postUpdates {
+		desc := describePostUpdate(postUpdate)
+		dir := jirix.Root
+		if postUpdate.Project != "" {
+			project, err := projects.FindUnique(postUpdate.Project)
+			if err != nil {
+				return fmt.Errorf("error running post-update command %s: %v", desc, err)
+			}
+			dir = project.Path
+		}
+		s := jirix.Clone(tool.ContextOpts{Env: env}).NewSeq()
+		s.Verbose(true).Output([]string{fmt.Sprintf("running post-update command %s", desc)})
+		// As for runhooks, the output is printed only if the command fails
+		// or -v is set.
+		var output bytes.Buffer
+		start := time.Now()
+		err := s.Dir(dir).Capture(&output, &output).Last("sh", "-c", postUpdate.Command)
+		result := postUpdateResult{
+			command:  postUpdate.Command,
+			failOk:   postUpdate.FailOk,
+			duration: time.Since(start),
+			output:   output.String(),
+			err:      err,
+		}
+		summary.postUpdates = append(summary.postUpdates, result)
+		status := "succeeded"
+		if err != nil {
+			status = fmt.Sprintf("failed: %v", err)
+		}
+		jirix.Audit("run post-update command", "ran post-update command %q in %s: %s", postUpdate.Command, dir, status)
+		if err != nil || jirix.Verbose() {
+			w := jirix.Stdout()
+			if err != nil {
+				w = jirix.Stderr()
+			}
+			fmt.Fprintf(w, "output of post-update command %s:\n%s", desc, result.output)
+			if result.output != "" && !strings.HasSuffix(result.output, "\n") {
+				fmt.Fprintln(w)
+			}
+		}
+		if err != nil {
+			if postUpdate.FailOk {
+				fmt.Fprintf(jirix.Stderr(), "WARNING: post-update command %s failed: %v\n", desc, err)
+				continue
+			}
+			return fmt.Errorf("error running post-update command %s: %v", desc, err)
+		}
+	}
+	return nil
+}
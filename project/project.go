@@ -6,6 +6,7 @@ package project
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/xml"
 	"fmt"
 	"hash/fnv"
@@ -13,15 +14,24 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"v.io/jiri"
 	"v.io/jiri/collect"
+	"v.io/jiri/errkind"
 	"v.io/jiri/gitutil"
 	"v.io/jiri/googlesource"
+	"v.io/jiri/profiles"
+	"v.io/jiri/profiles/profilesreader"
 	"v.io/jiri/runutil"
+	"v.io/jiri/tool"
+	"v.io/x/lib/envvar"
+	"v.io/x/lib/metadata"
 	"v.io/x/lib/set"
 )
 
@@ -32,32 +42,67 @@ var JiriPackage = "v.io/jiri"
 // CL represents a changelist.
 type CL struct {
 	// Author identifies the author of the changelist.
-	Author string
+	Author string `json:"author"`
 	// Email identifies the author's email.
-	Email string
+	Email string `json:"email"`
 	// Description holds the description of the changelist.
-	Description string
+	Description string `json:"description"`
+	// ChangeID is the Change-Id of the changelist, or "" if its description
+	// has none.
+	ChangeID string `json:"changeId,omitempty"`
+	// Change, Owner, Topic and Status describe the gerrit change of the
+	// changelist: its number, the email of its owner, its topic and its
+	// status, e.g. gerrit.ChangeStatusMerged.  They are only set if the
+	// change was looked up with GerritChangesOpt, and left empty if the
+	// lookup failed.
+	Change int    `json:"change,omitempty"`
+	Owner  string `json:"owner,omitempty"`
+	Topic  string `json:"topic,omitempty"`
+	Status string `json:"status,omitempty"`
 }
 
 // Manifest represents a setting used for updating the universe.
 type Manifest struct {
+	// Comment is written as an XML comment in the manifest.  Snapshots use it
+	// to list the projects that were not captured from a clean master branch.
+	Comment      string        `xml:",comment"`
 	Imports      []Import      `xml:"imports>import"`
 	LocalImports []LocalImport `xml:"imports>localimport"`
 	Projects     []Project     `xml:"projects>project"`
 	Tools        []Tool        `xml:"tools>tool"`
+	PostUpdates  []PostUpdate  `xml:"postupdates>postupdate"`
+	// Locks records the revisions of the manifest projects that the remote
+	// imports were loaded at, as in the manifest lock.  It is only set in
+	// snapshots, and checking out a snapshot restores the manifest lock.
+	Locks []ImportLock `xml:"locks>lock"`
+	// ManifestPath is the relative path from JIRI_ROOT to the manifest file
+	// the projects were updated from, if it is not .jiri_manifest.  It is
+	// only set in the snapshots of the update history.
+	ManifestPath string `xml:"manifestpath,attr,omitempty"`
 	// SnapshotPath is the relative path to the snapshot file from JIRI_ROOT.
 	// It is only set when creating a snapshot.
-	SnapshotPath string   `xml:"snapshotpath,attr,omitempty"`
-	XMLName      struct{} `xml:"manifest"`
+	SnapshotPath string `xml:"snapshotpath,attr,omitempty"`
+	// Tag is the name of the git tag created at the recorded revision of
+	// each project of a snapshot, if any.  It is only set when creating a
+	// snapshot.
+	Tag string `xml:"tag,attr,omitempty"`
+	// Version is the version of the manifest schema, of the form "<major>" or
+	// "<major>.<minor>".  Manifests without a version predate versioning.
+	Version string   `xml:"version,attr,omitempty"`
+	XMLName struct{} `xml:"manifest"`
 }
 
 // ManifestFromBytes returns a manifest parsed from data, with defaults filled
-// in.
+// in.  It returns a *ManifestVersionError if the manifest is too new for this
+// version of jiri.
 func ManifestFromBytes(data []byte) (*Manifest, error) {
 	m := new(Manifest)
 	if err := xml.Unmarshal(data, m); err != nil {
 		return nil, err
 	}
+	if err := checkManifestVersion(m.Version); err != nil {
+		return nil, err
+	}
 	if err := m.fillDefaults(); err != nil {
 		return nil, err
 	}
@@ -65,7 +110,9 @@ func ManifestFromBytes(data []byte) (*Manifest, error) {
 }
 
 // ManifestFromFile returns a manifest parsed from the contents of filename,
-// with defaults filled in.
+// with defaults filled in.  A warning is printed if the manifest contains
+// elements or attributes that this version of jiri does not know, and would
+// thus ignore.
 //
 // Note that unlike ProjectFromFile, ManifestFromFile does not convert project
 // paths to absolute paths because it's possible to load a manifest with a
@@ -73,28 +120,54 @@ func ManifestFromBytes(data []byte) (*Manifest, error) {
 // manifest is through LoadManifest, which does absolutize the paths, and uses
 // the correct root directory.
 func ManifestFromFile(jirix *jiri.X, filename string) (*Manifest, error) {
-	data, err := jirix.NewSeq().ReadFile(filename)
+	m, unknown, err := readManifest(jirix, filename)
 	if err != nil {
 		return nil, err
 	}
+	if len(unknown) > 0 {
+		fmt.Fprintf(jirix.Stderr(), "WARNING: manifest %s contains fields unknown to this version of jiri, which were ignored:\n  %s\n", filename, strings.Join(unknown, "\n  "))
+	}
+	return m, nil
+}
+
+// readManifest returns the manifest parsed from the contents of filename, with
+// defaults filled in, and the elements and attributes of the file that this
+// version of jiri does not know.
+func readManifest(jirix *jiri.X, filename string) (*Manifest, []string, error) {
+	data, err := jirix.NewSeq().ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
 	m, err := ManifestFromBytes(data)
 	if err != nil {
-		return nil, fmt.Errorf("invalid manifest %s: %v", filename, err)
+		if versionErr, ok := err.(*ManifestVersionError); ok {
+			versionErr.File = filename
+			return nil, nil, versionErr
+		}
+		return nil, nil, errkind.Errorf(errkind.ManifestError, "invalid manifest %s: %v", filename, err)
+	}
+	unknown, err := unknownManifestFields(data)
+	if err != nil {
+		return nil, nil, errkind.Errorf(errkind.ManifestError, "invalid manifest %s: %v", filename, err)
 	}
-	return m, nil
+	return m, unknown, nil
 }
 
 var (
-	newlineBytes       = []byte("\n")
-	emptyImportsBytes  = []byte("\n  <imports></imports>\n")
-	emptyProjectsBytes = []byte("\n  <projects></projects>\n")
-	emptyToolsBytes    = []byte("\n  <tools></tools>\n")
+	newlineBytes          = []byte("\n")
+	emptyImportsBytes     = []byte("\n  <imports></imports>\n")
+	emptyProjectsBytes    = []byte("\n  <projects></projects>\n")
+	emptyToolsBytes       = []byte("\n  <tools></tools>\n")
+	emptyPostUpdatesBytes = []byte("\n  <postupdates></postupdates>\n")
+	emptyLocksBytes       = []byte("\n  <locks></locks>\n")
 
 	endElemBytes        = []byte("/>\n")
 	endImportBytes      = []byte("></import>\n")
 	endLocalImportBytes = []byte("></localimport>\n")
 	endProjectBytes     = []byte("></project>\n")
 	endToolBytes        = []byte("></tool>\n")
+	endPostUpdateBytes  = []byte("></postupdate>\n")
+	endLockBytes        = []byte("></lock>\n")
 
 	endImportSoloBytes  = []byte("></import>")
 	endProjectSoloBytes = []byte("></project>")
@@ -104,11 +177,17 @@ var (
 // deepCopy returns a deep copy of Manifest.
 func (m *Manifest) deepCopy() *Manifest {
 	x := new(Manifest)
+	x.Comment = m.Comment
+	x.ManifestPath = m.ManifestPath
 	x.SnapshotPath = m.SnapshotPath
+	x.Tag = m.Tag
+	x.Version = m.Version
 	x.Imports = append([]Import(nil), m.Imports...)
 	x.LocalImports = append([]LocalImport(nil), m.LocalImports...)
 	x.Projects = append([]Project(nil), m.Projects...)
 	x.Tools = append([]Tool(nil), m.Tools...)
+	x.PostUpdates = append([]PostUpdate(nil), m.PostUpdates...)
+	x.Locks = append([]ImportLock(nil), m.Locks...)
 	return x
 }
 
@@ -127,16 +206,41 @@ func (m *Manifest) ToBytes() ([]byte, error) {
 	data = bytes.Replace(data, emptyImportsBytes, newlineBytes, -1)
 	data = bytes.Replace(data, emptyProjectsBytes, newlineBytes, -1)
 	data = bytes.Replace(data, emptyToolsBytes, newlineBytes, -1)
+	data = bytes.Replace(data, emptyPostUpdatesBytes, newlineBytes, -1)
+	data = bytes.Replace(data, emptyLocksBytes, newlineBytes, -1)
 	data = bytes.Replace(data, endImportBytes, endElemBytes, -1)
 	data = bytes.Replace(data, endLocalImportBytes, endElemBytes, -1)
 	data = bytes.Replace(data, endProjectBytes, endElemBytes, -1)
 	data = bytes.Replace(data, endToolBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endPostUpdateBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endLockBytes, endElemBytes, -1)
 	if !bytes.HasSuffix(data, newlineBytes) {
 		data = append(data, '\n')
 	}
 	return data, nil
 }
 
+// Sort sorts the imports by remote and manifest file, the local imports by
+// file, the projects by key, the tools by name and the import locks by name,
+// remote and manifest file, so that serializing
+// manifests with the same contents yields the same bytes.  The post-update
+// commands are run in order, and are thus never sorted.  Only generated
+// manifests, such as snapshots, are sorted; the order of manifests written by
+// users is preserved.
+func (m *Manifest) Sort() {
+	sort.SliceStable(m.Imports, func(i, j int) bool {
+		a, b := m.Imports[i], m.Imports[j]
+		if a.Remote != b.Remote {
+			return a.Remote < b.Remote
+		}
+		return a.Manifest < b.Manifest
+	})
+	sort.SliceStable(m.LocalImports, func(i, j int) bool { return m.LocalImports[i].File < m.LocalImports[j].File })
+	sort.SliceStable(m.Projects, func(i, j int) bool { return m.Projects[i].Key() < m.Projects[j].Key() })
+	sort.SliceStable(m.Tools, func(i, j int) bool { return m.Tools[i].Name < m.Tools[j].Name })
+	sortImportLocks(m.Locks)
+}
+
 func safeWriteFile(jirix *jiri.X, filename string, data []byte) error {
 	tmp := filename + ".tmp"
 	return jirix.NewSeq().
@@ -149,21 +253,26 @@ func safeWriteFile(jirix *jiri.X, filename string, data []byte) error {
 // ToFile writes the manifest m to a file with the given filename, with
 // defaults unfilled and all project paths relative to the jiri root.
 func (m *Manifest) ToFile(jirix *jiri.X, filename string) error {
+	data, err := m.toFileBytes(jirix.Root)
+	if err != nil {
+		return err
+	}
+	return safeWriteFile(jirix, filename, data)
+}
+
+// toFileBytes returns m as serialized bytes, as written by ToFile.
+func (m *Manifest) toFileBytes(root string) ([]byte, error) {
 	// Replace absolute paths with relative paths to make it possible to move
 	// the $JIRI_ROOT directory locally.
 	projects := []Project{}
 	for _, project := range m.Projects {
-		if err := project.relativizePaths(jirix.Root); err != nil {
-			return err
+		if err := project.relativizePaths(root); err != nil {
+			return nil, err
 		}
 		projects = append(projects, project)
 	}
 	m.Projects = projects
-	data, err := m.ToBytes()
-	if err != nil {
-		return err
-	}
-	return safeWriteFile(jirix, filename, data)
+	return m.ToBytes()
 }
 
 func (m *Manifest) fillDefaults() error {
@@ -187,6 +296,11 @@ func (m *Manifest) fillDefaults() error {
 			return err
 		}
 	}
+	for index := range m.PostUpdates {
+		if err := m.PostUpdates[index].validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -211,6 +325,11 @@ func (m *Manifest) unfillDefaults() error {
 			return err
 		}
 	}
+	for index := range m.PostUpdates {
+		if err := m.PostUpdates[index].validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -230,6 +349,19 @@ type Import struct {
 	// the name of the local branch that jiri maintains, which is always
 	// "master". If not set, "master" is used as the default.
 	RemoteBranch string `xml:"remotebranch,attr,omitempty"`
+	// Revision is the revision of the remote manifest project to use.  It
+	// trumps RemoteBranch when set.  If not set, "HEAD" is used as the default,
+	// which means the tip of RemoteBranch.
+	Revision string `xml:"revision,attr,omitempty"`
+	// Verify is VerifySignature if the revisions of the remote manifest
+	// project must be signed by one of the trusted keys.  If not set,
+	// revisions are not verified.
+	Verify string `xml:"verify,attr,omitempty"`
+	// Groups is a comma-separated list of the project groups of the import.
+	// The import is only loaded if one of its groups is enabled, and it is
+	// the default for the groups of the projects it contains.  If not set,
+	// "default" is used as the default.
+	Groups string `xml:"groups,attr,omitempty"`
 	// Root path, prepended to all project paths specified in the manifest file.
 	Root    string   `xml:"root,attr,omitempty"`
 	XMLName struct{} `xml:"import"`
@@ -242,6 +374,9 @@ func (i *Import) fillDefaults() error {
 	if i.RemoteBranch == "" {
 		i.RemoteBranch = "master"
 	}
+	if i.Revision == "" {
+		i.Revision = "HEAD"
+	}
 	return i.validate()
 }
 
@@ -252,6 +387,9 @@ func (i *Import) unfillDefaults() error {
 	if i.RemoteBranch == "master" {
 		i.RemoteBranch = ""
 	}
+	if i.Revision == "HEAD" {
+		i.Revision = ""
+	}
 	return i.validate()
 }
 
@@ -259,6 +397,9 @@ func (i *Import) validate() error {
 	if i.Manifest == "" || i.Remote == "" {
 		return fmt.Errorf("bad import: both manifest and remote must be specified")
 	}
+	if i.Verify != "" && i.Verify != VerifySignature {
+		return fmt.Errorf("bad import: verify must be %q: %+v", VerifySignature, *i)
+	}
 	return nil
 }
 
@@ -269,6 +410,8 @@ func (i *Import) toProject(path string) (Project, error) {
 		Protocol:     i.Protocol,
 		Remote:       i.Remote,
 		RemoteBranch: i.RemoteBranch,
+		Revision:     i.Revision,
+		Verify:       i.Verify,
 	}
 	err := p.fillDefaults()
 	return p, err
@@ -354,22 +497,83 @@ type Project struct {
 	// RemoteBranch is the name of the remote branch to track.  It doesn't affect
 	// the name of the local branch that jiri maintains, which is always "master".
 	RemoteBranch string `xml:"remotebranch,attr,omitempty"`
+	// FallbackBranch is the name of the remote branch to track instead of
+	// RemoteBranch while RemoteBranch does not exist in the remote, e.g.
+	// because it was deleted or renamed upstream.
+	FallbackBranch string `xml:"fallbackbranch,attr,omitempty"`
 	// Revision is the revision the project should be advanced to during "jiri
 	// update".  If Revision is set, RemoteBranch will be ignored.  If Revision
 	// is not set, "HEAD" is used as the default.
 	Revision string `xml:"revision,attr,omitempty"`
+	// Verify is VerifySignature if the revisions the project is advanced to
+	// must be signed by one of the trusted keys.  If not set, revisions are
+	// not verified.
+	Verify string `xml:"verify,attr,omitempty"`
+	// CloneFilter is the filter, e.g. "blob:none" or "tree:0", used to create
+	// a partial clone of the project, whose omitted objects are fetched from
+	// the remote on demand.  If not set, the project is cloned in full.
+	CloneFilter string `xml:"clonefilter,attr,omitempty"`
+	// SparseCheckout is a comma-separated list of the directories, relative
+	// to the root of the project, that the working tree of the project is
+	// restricted to, along with the files at the root.  If not set, the whole
+	// tree is checked out.
+	SparseCheckout string `xml:"sparsecheckout,attr,omitempty"`
+	// LFS is true if the project stores files with Git LFS, whose contents
+	// are fetched and checked out after the project is cloned or updated.
+	LFS bool `xml:"lfs,attr,omitempty"`
 	// GerritHost is the gerrit host where project CLs will be sent.
 	GerritHost string `xml:"gerrithost,attr,omitempty"`
+	// GerritRemote is the name of the git remote that project CLs are pushed
+	// to.  If set, "jiri update" configures the remote to point at the
+	// project on GerritHost, which must also be set.  If not set, CLs are
+	// pushed directly to the project on GerritHost.
+	GerritRemote string `xml:"gerritremote,attr,omitempty"`
+	// ReviewHost is the url of the repository of the project on a GitHub
+	// host, such as https://github.com/vanadium/go.jiri, that "jiri cl mail"
+	// sends pull requests to.  It cannot be set with GerritHost.
+	ReviewHost string `xml:"reviewhost,attr,omitempty"`
+	// Groups is a comma-separated list of the project groups the project
+	// belongs to.  The project is only loaded from the manifest if one of its
+	// groups is enabled.  If not set, the groups of the import the project
+	// was loaded through are used, or "default" if there are none.
+	Groups string `xml:"groups,attr,omitempty"`
 	// GitHooks is a directory containing git hooks that will be installed for
 	// this project.
 	GitHooks string `xml:"githooks,attr,omitempty"`
 	// RunHook is a script that will run when the project is created, updated,
 	// or moved.  The argument to the script will be "create", "update" or
 	// "move" depending on the type of operation being performed.
-	RunHook string   `xml:"runhook,attr,omitempty"`
-	XMLName struct{} `xml:"project"`
+	RunHook string `xml:"runhook,attr,omitempty"`
+	// HookEnv selects the environment RunHook runs with, HookEnvInherit or
+	// HookEnvClean.  If not set, HookEnvInherit is used.
+	HookEnv string `xml:"hookenv,attr,omitempty"`
+	// HookProfiles is a comma-separated list of the profiles whose
+	// environment variables are merged into the environment of RunHook.
+	HookProfiles string `xml:"hookprofiles,attr,omitempty"`
+	// Exclude, if true, makes the project a stub that removes the projects
+	// with the same name, and the same remote if it is set, from the
+	// manifest, whether they are declared before or after the stub, e.g. to
+	// drop projects of an imported manifest.  The other attributes of the
+	// stub, including Groups, are ignored.  Projects of a rooted import are
+	// named filepath.Join(root, name), and must be excluded by that name.
+	Exclude bool `xml:"exclude,attr,omitempty"`
+	// RequiresProfiles is a comma-separated list of the profiles that must
+	// be installed for the native target before RunHook runs, e.g.
+	// "go,base".  RunHook is skipped if they are not, unless
+	// InstallMissingProfilesOpt is given.
+	RequiresProfiles string   `xml:"requiresprofiles,attr,omitempty"`
+	XMLName          struct{} `xml:"project"`
 }
 
+// The environments that the runhook of a project can run with.
+const (
+	// HookEnvInherit is the environment of jiri.
+	HookEnvInherit = "inherit"
+	// HookEnvClean is a minimal environment, with only the PATH and HOME
+	// variables of jiri, so that hooks behave the same on all machines.
+	HookEnvClean = "clean"
+)
+
 // ProjectFromFile returns a project parsed from the contents of filename,
 // with defaults filled in and all paths absolute.
 func ProjectFromFile(jirix *jiri.X, filename string) (*Project, error) {
@@ -380,7 +584,7 @@ func ProjectFromFile(jirix *jiri.X, filename string) (*Project, error) {
 
 	p := new(Project)
 	if err := xml.Unmarshal(data, p); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("parsing %q failed: %v", filename, err)
 	}
 	if err := p.fillDefaults(); err != nil {
 		return nil, err
@@ -486,12 +690,83 @@ func (p *Project) validate() error {
 	if strings.Contains(p.Name, projectKeySeparator) {
 		return fmt.Errorf("bad project: name cannot contain %q: %+v", projectKeySeparator, *p)
 	}
+	if p.Exclude {
+		// The other attributes of excluded project stubs are ignored.
+		return nil
+	}
+	if p.CloneFilter != "" {
+		if p.Protocol != "" && p.Protocol != "git" {
+			return fmt.Errorf("bad project: clonefilter requires the git protocol: %+v", *p)
+		}
+		if !validCloneFilter(p.CloneFilter) {
+			return fmt.Errorf("bad project: invalid clonefilter %q: %+v", p.CloneFilter, *p)
+		}
+	}
+	for _, dir := range p.sparseCheckoutDirs() {
+		if filepath.IsAbs(dir) || dir != filepath.Clean(dir) || dir == "." || dir == ".." || strings.HasPrefix(dir, "../") {
+			return fmt.Errorf("bad project: sparsecheckout directory %q is not a clean relative path: %+v", dir, *p)
+		}
+	}
 	if p.Protocol != "" && p.Protocol != "git" {
 		return fmt.Errorf("bad project: only git protocol is supported: %+v", *p)
 	}
+	if p.GerritRemote != "" && p.GerritHost == "" {
+		return fmt.Errorf("bad project: gerritremote requires gerrithost: %+v", *p)
+	}
+	if p.ReviewHost != "" && p.GerritHost != "" {
+		return fmt.Errorf("bad project: reviewhost and gerrithost cannot both be set: %+v", *p)
+	}
+	if p.HookEnv != "" && p.HookEnv != HookEnvInherit && p.HookEnv != HookEnvClean {
+		return fmt.Errorf("bad project: hookenv must be %q or %q: %+v", HookEnvInherit, HookEnvClean, *p)
+	}
+	if p.RequiresProfiles != "" && p.RunHook == "" {
+		return fmt.Errorf("bad project: requiresprofiles requires runhook: %+v", *p)
+	}
+	if p.Verify != "" && p.Verify != VerifySignature {
+		return fmt.Errorf("bad project: verify must be %q: %+v", VerifySignature, *p)
+	}
 	return nil
 }
 
+// sparseCheckoutDirs returns the directories listed by the sparsecheckout
+// attribute of the project.
+func (p Project) sparseCheckoutDirs() []string {
+	var dirs []string
+	for _, dir := range strings.Split(p.SparseCheckout, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// validCloneFilter returns whether the given filter has the form of one of
+// the object filters accepted by "git clone --filter".
+func validCloneFilter(filter string) bool {
+	for _, prefix := range []string{"blob:limit=", "tree:", "sparse:oid=", "object:type=", "combine:"} {
+		if strings.HasPrefix(filter, prefix) && len(filter) > len(prefix) {
+			return true
+		}
+	}
+	return filter == "blob:none"
+}
+
+// GerritPushUrl returns the URL of the project on the given gerrit host,
+// which is the host with the path of the project remote.
+func (p Project) GerritPushUrl(host string) (*url.URL, error) {
+	hostUrl, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Gerrit host %q: %v", host, err)
+	}
+	remoteUrl, err := url.Parse(p.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("invalid project remote %q: %v", p.Remote, err)
+	}
+	pushUrl := *hostUrl
+	pushUrl.Path = remoteUrl.Path
+	return &pushUrl, nil
+}
+
 // Projects maps ProjectKeys to Projects.
 type Projects map[ProjectKey]Project
 
@@ -551,14 +826,32 @@ func (ts Tools) toSlice() []Tool {
 
 // Tool represents a jiri tool.
 type Tool struct {
+	// Arch is a comma-separated list of the architectures, as in GOARCH,
+	// that the tool is built on, e.g. "amd64,arm64".  If empty, the tool is
+	// built on all architectures.
+	Arch string `xml:"arch,attr,omitempty"`
+	// BuildFlags is a space-separated list of flags passed to "go install"
+	// when building the tool, e.g. "-tags=leveldb".
+	BuildFlags string `xml:"buildflags,attr,omitempty"`
 	// Data is a relative path to a directory for storing tool data
 	// (e.g. tool configuration files). The purpose of this field is to
 	// decouple the configuration of the data directory from the tool
 	// itself so that the location of the data directory can change
 	// without the need to change the tool.
 	Data string `xml:"data,attr,omitempty"`
+	// Env is a space-separated list of environment variables, in the form
+	// KEY=VALUE, that are set when building the tool, e.g. "CGO_ENABLED=0".
+	Env string `xml:"env,attr,omitempty"`
+	// GoVersion is the oldest Go version the tool can be built with, e.g.
+	// "1.6".  Updates fail before changing any project if the Go toolchain
+	// is older.
+	GoVersion string `xml:"goversion,attr,omitempty"`
 	// Name is the name of the tool binary.
 	Name string `xml:"name,attr,omitempty"`
+	// OS is a comma-separated list of the operating systems, as in GOOS,
+	// that the tool is built on, e.g. "linux", for tools that do not build
+	// elsewhere.  If empty, the tool is built on all operating systems.
+	OS string `xml:"os,attr,omitempty"`
 	// Package is the package path of the tool.
 	Package string `xml:"package,attr,omitempty"`
 	// Project identifies the project that contains the tool. If not
@@ -575,7 +868,7 @@ func (t *Tool) fillDefaults() error {
 	if t.Project == "" {
 		t.Project = "https://vanadium.googlesource.com/" + JiriProject
 	}
-	return nil
+	return t.validate()
 }
 
 func (t *Tool) unfillDefaults() error {
@@ -584,9 +877,125 @@ func (t *Tool) unfillDefaults() error {
 	}
 	// Don't unfill the jiri project setting, since that's not meant to be
 	// optional.
+	return t.validate()
+}
+
+func (t *Tool) validate() error {
+	for _, flag := range strings.Fields(t.BuildFlags) {
+		if !strings.HasPrefix(flag, "-") {
+			return fmt.Errorf("bad tool %q: build flag %q does not start with \"-\"", t.Name, flag)
+		}
+	}
+	for _, kv := range strings.Fields(t.Env) {
+		key := strings.SplitN(kv, "=", 2)[0]
+		switch {
+		case !strings.Contains(kv, "=") || key == "":
+			return fmt.Errorf("bad tool %q: environment variable %q is not of the form KEY=VALUE", t.Name, kv)
+		case key == "GOBIN" || key == "GOPATH":
+			return fmt.Errorf("bad tool %q: environment variable %v is set by jiri and cannot be overridden", t.Name, key)
+		}
+	}
+	if t.GoVersion != "" {
+		if _, err := parseGoVersion(t.GoVersion); err != nil {
+			return fmt.Errorf("bad tool %q: %v", t.Name, err)
+		}
+	}
+	for _, attr := range []struct{ name, list string }{{"os", t.OS}, {"arch", t.Arch}} {
+		if attr.list == "" {
+			continue
+		}
+		for _, value := range strings.Split(attr.list, ",") {
+			if strings.TrimSpace(value) == "" {
+				return fmt.Errorf("bad tool %q: %s %q has an empty element", t.Name, attr.name, attr.list)
+			}
+		}
+	}
+	return nil
+}
+
+// builtOn returns whether the tool is built on hosts with the given GOOS and
+// GOARCH, i.e. whether they are in its OS and Arch lists, if set.
+func (t Tool) builtOn(goos, goarch string) bool {
+	return inList(t.OS, goos) && inList(t.Arch, goarch)
+}
+
+// inList returns whether the given comma-separated list is empty or contains
+// the given value.
+func inList(list, value string) bool {
+	if list == "" {
+		return true
+	}
+	for _, elem := range strings.Split(list, ",") {
+		if strings.TrimSpace(elem) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// PostUpdate represents a command that is run after each update of the jiri
+// root, once the tools have been installed, to restore invariants that span
+// projects, such as generated files.
+type PostUpdate struct {
+	// Command is the command line that is run, with "sh -c".
+	Command string `xml:"command,attr,omitempty"`
+	// FailOk, if true, causes a failure of the command to be reported without
+	// failing the update.
+	FailOk bool `xml:"failok,attr,omitempty"`
+	// Project is the name of the project whose directory the command is run
+	// in.  If empty, the command is run in the jiri root.
+	Project string   `xml:"project,attr,omitempty"`
+	XMLName struct{} `xml:"postupdate"`
+}
+
+func (pu *PostUpdate) validate() error {
+	if strings.TrimSpace(pu.Command) == "" {
+		return fmt.Errorf("bad postupdate: no command")
+	}
 	return nil
 }
 
+// The ids of the metadata that BuildTools embeds into the tool binaries, which
+// the binaries print when run with -metadata.  See the v.io/x/lib/metadata
+// package for details.
+const (
+	// ToolProjectMetadata is the name of the project of the tool.
+	ToolProjectMetadata = "jiri.Project"
+	// ToolRevisionMetadata is the revision of the project the tool was
+	// built at.
+	ToolRevisionMetadata = "jiri.Revision"
+	// ToolBuildTimeMetadata is the time the tool was built at, in the
+	// RFC 3339 format.
+	ToolBuildTimeMetadata = "jiri.BuildTime"
+)
+
+// addLDFlag returns the given build flags with the given linker flag added to
+// their -ldflags, or to a new -ldflags if there is none, since "go install"
+// honors only one -ldflags.
+func addLDFlag(flags []string, ldflag string) []string {
+	var result []string
+	found := false
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "-ldflags=") || strings.HasPrefix(flag, "--ldflags=") {
+			flag += " " + ldflag
+			found = true
+		}
+		result = append(result, flag)
+	}
+	if !found {
+		result = append(result, "-ldflags="+ldflag)
+	}
+	return result
+}
+
+// buildKey returns a key that is identical for tools built with the same
+// flags and environment, which are installed by a single "go install".
+func (t Tool) buildKey() string {
+	env := strings.Fields(t.Env)
+	sort.Strings(env)
+	return strings.Join(strings.Fields(t.BuildFlags), " ") + "\x00" + strings.Join(env, " ")
+}
+
 // ScanMode determines whether LocalProjects should scan the local filesystem
 // for projects (FullScan), or optimistically assume that the local projects
 // will match those in the manifest (FastScan).
@@ -607,16 +1016,228 @@ func (e UnsupportedProtocolErr) Error() string {
 // project names to a collections of commits.
 type Update map[string][]CL
 
+// SnapshotOpt is an option for CreateSnapshot.
+type SnapshotOpt interface {
+	snapshotOpt()
+}
+
+// CurrentStateOpt causes CreateSnapshot to record the revision that is checked
+// out in each project, rather than the revision of its master branch.
+type CurrentStateOpt bool
+
+// RequireCleanOpt causes CreateSnapshot to fail if any project is not on its
+// master branch or has uncommitted changes.
+type RequireCleanOpt bool
+
+// WarnUncleanOpt causes CreateSnapshot to list the projects that are not on
+// their master branch or have uncommitted changes in a warning, and in a
+// comment in the snapshot.
+type WarnUncleanOpt bool
+
+// SnapshotTagOpt causes CreateSnapshot to record the name of the tag that
+// TagSnapshotProjects creates in the projects of the snapshot.
+type SnapshotTagOpt string
+
+func (CurrentStateOpt) snapshotOpt() {}
+func (RequireCleanOpt) snapshotOpt() {}
+func (SnapshotTagOpt) snapshotOpt()  {}
+func (WarnUncleanOpt) snapshotOpt()  {}
+
+// UpdateOpt is an option for UpdateUniverse and CheckoutSnapshot.
+type UpdateOpt interface {
+	updateOpt()
+}
+
+// NoHooksOpt causes UpdateUniverse and CheckoutSnapshot to skip running the
+// runhooks and installing the githooks of projects, and to list the skipped
+// hooks instead.  Passed to CreateSnapshot, it causes the projects whose hooks
+// are skipped to be listed in a comment in the snapshot.
+type NoHooksOpt bool
+
+// SummaryOnlyOpt causes UpdateUniverse and CheckoutSnapshot to print only the
+// summary of the update, rather than logging each operation.
+type SummaryOnlyOpt bool
+
+// PruneGroupsOpt causes UpdateUniverse and CheckoutSnapshot, when garbage
+// collecting, to delete the local projects that are not in any enabled group
+// but are in one of the given groups.  Other local projects that are not in
+// any enabled group are left unchanged.
+type PruneGroupsOpt []string
+
+// OfflineOpt causes UpdateUniverse and CheckoutSnapshot to skip the network
+// requests that only serve to speed up the update, such as fetching the
+// revisions of the projects at HEAD from googlesource hosts.
+type OfflineOpt bool
+
+// GoogleSourceHostsOpt causes UpdateUniverse and CheckoutSnapshot to only
+// fetch the revisions of the projects at HEAD from the given googlesource
+// hosts, given as URLs or host names.  Other hosts are skipped.  By default,
+// all googlesource hosts are queried.
+type GoogleSourceHostsOpt []string
+
+// NoRemoteCacheOpt causes UpdateUniverse and CheckoutSnapshot to fetch the
+// revisions of the projects at HEAD from googlesource hosts, rather than use
+// the responses cached by recent updates; see googlesource.Cache.
+type NoRemoteCacheOpt bool
+
+// RemoteCacheDirOpt causes UpdateUniverse and CheckoutSnapshot to cache the
+// responses of googlesource hosts in the given directory, e.g. one shared by
+// the jiri roots of a machine.  It defaults to $JIRI_REMOTE_CACHE_DIR if set,
+// or to $JIRI_ROOT/.jiri_root/cache/googlesource otherwise.
+type RemoteCacheDirOpt string
+
+// RemoteCacheTTLOpt causes UpdateUniverse and CheckoutSnapshot to use the
+// cached responses of googlesource hosts for the given duration, rather than
+// for googlesource.DefaultCacheTTL.  Zero disables the cache.
+type RemoteCacheTTLOpt time.Duration
+
+// ForceRemoteChangeOpt causes UpdateUniverse and CheckoutSnapshot to replace
+// a project whose remote changed to a repository that shares no history with
+// the local master branch by a new clone of the project.  The old checkout is
+// moved to "<path>.old".  By default, such projects cause the update to fail.
+type ForceRemoteChangeOpt bool
+
+// RebaseTrackedOpt causes UpdateUniverse and CheckoutSnapshot to rebase the
+// current branch of each updated project that is not on its master branch
+// onto the updated master branch.  Branches with uncommitted changes or with
+// a merge or rebase in progress are skipped, and branches that cannot be
+// rebased without conflicts are left unchanged.  The outcome is listed in the
+// summary of the update.
+type RebaseTrackedOpt bool
+
+// GitTimeoutOpt causes UpdateUniverse and CheckoutSnapshot to kill the git
+// commands that fetch, clone or reset projects, along with the processes they
+// started, if they do not finish within the given duration, and to fail with
+// an error of kind errkind.NetworkError.  Zero means no timeout.
+type GitTimeoutOpt time.Duration
+
+// HookProfilesOpt causes UpdateUniverse and CheckoutSnapshot to merge the
+// environment variables of the given profiles into the environment of the
+// runhooks of all projects, in addition to the profiles listed by the
+// hookprofiles attribute of each project.
+type HookProfilesOpt []string
+
+// InstallMissingProfilesOpt causes UpdateUniverse and CheckoutSnapshot to
+// install the profiles listed by the requiresprofiles attribute of a project
+// that are not installed, before running its runhook, rather than skip the
+// runhook.
+type InstallMissingProfilesOpt bool
+
+// ProfilesDBOpt causes UpdateUniverse and CheckoutSnapshot to read the
+// profiles that the runhooks use or require from the given profiles
+// database, rather than from $JIRI_ROOT/.jiri_root/profile_db.
+type ProfilesDBOpt string
+
+// ProfilesDirOpt causes UpdateUniverse and CheckoutSnapshot to install the
+// profiles required by the runhooks in the given directory, relative to
+// $JIRI_ROOT, rather than in .jiri_root/profiles.
+type ProfilesDirOpt string
+
+// NoVerifyOpt causes UpdateUniverse and CheckoutSnapshot to skip verifying
+// the signatures of the revisions of the projects that require signed
+// revisions, which is logged with a warning for each such project.
+type NoVerifyOpt bool
+
+// HookTimeoutOpt causes UpdateUniverse and CheckoutSnapshot to kill a runhook,
+// along with the processes it started, if it does not finish within the given
+// duration, and to fail.  Zero means no timeout.
+type HookTimeoutOpt time.Duration
+
+// HookXUnitFileOpt causes UpdateUniverse and CheckoutSnapshot to write the
+// outcome of the runhooks to the given file as an xUnit report, with one test
+// case per runhook, even if the update fails.
+type HookXUnitFileOpt string
+
+// SkipPostUpdateOpt is an UpdateOpt that skips running the post-update
+// commands of the manifest, which are listed instead.
+type SkipPostUpdateOpt bool
+
+// ProgressOpt causes UpdateUniverse and CheckoutSnapshot to report the
+// progress of the project operations, unless the verbose flag is set, instead
+// of logging each operation; see runutil.Progress.  On a terminal, the
+// progress is reported even if SummaryOnlyOpt is set.
+type ProgressOpt bool
+
+// FrozenOpt causes UpdateUniverse to load the remote imports at the revisions
+// recorded in the manifest lock by the latest update, rather than at the tips
+// of their branches, so that the manifests are the same.  The update fails if
+// there is no manifest lock, or if it does not record an import.
+type FrozenOpt bool
+
+// DetectLFSOpt causes UpdateUniverse and CheckoutSnapshot to treat the
+// projects whose .gitattributes file configures the Git LFS filter as if
+// their lfs attribute was set.
+type DetectLFSOpt bool
+
+// PartialOpt causes CheckoutSnapshot to check out the snapshot without first
+// verifying that the revisions of all the local projects can be obtained, so
+// that the projects whose revisions are available are checked out even if
+// others fail.  For emergencies only.
+type PartialOpt bool
+
+func (NoHooksOpt) updateOpt()           {}
+func (NoHooksOpt) snapshotOpt()         {}
+func (GitTimeoutOpt) updateOpt()        {}
+func (HookProfilesOpt) updateOpt()      {}
+func (HookTimeoutOpt) updateOpt()       {}
+func (NoVerifyOpt) updateOpt()          {}
+func (HookXUnitFileOpt) updateOpt()     {}
+func (SkipPostUpdateOpt) updateOpt()    {}
+func (SummaryOnlyOpt) updateOpt()       {}
+func (PruneGroupsOpt) updateOpt()       {}
+func (OfflineOpt) updateOpt()           {}
+func (GoogleSourceHostsOpt) updateOpt() {}
+func (NoRemoteCacheOpt) updateOpt()     {}
+func (RemoteCacheDirOpt) updateOpt()    {}
+func (RemoteCacheTTLOpt) updateOpt()    {}
+func (ForceRemoteChangeOpt) updateOpt() {}
+func (RebaseTrackedOpt) updateOpt()     {}
+func (DetectLFSOpt) updateOpt()         {}
+func (ProgressOpt) updateOpt()          {}
+func (FrozenOpt) updateOpt()            {}
+func (PartialOpt) updateOpt()           {}
+
+func (InstallMissingProfilesOpt) updateOpt() {}
+func (ProfilesDBOpt) updateOpt()             {}
+func (ProfilesDirOpt) updateOpt()            {}
+
 // CreateSnapshot creates a manifest that encodes the current state of master
 // branches of all projects and writes this snapshot out to the given file.
-func CreateSnapshot(jirix *jiri.X, file, snapshotPath string) error {
-	jirix.TimerPush("create snapshot")
-	defer jirix.TimerPop()
-
+func CreateSnapshot(jirix *jiri.X, file, snapshotPath string, opts ...SnapshotOpt) error {
 	// If snapshotPath is empty, use the file as the path.
 	if snapshotPath == "" {
 		snapshotPath = file
 	}
+	manifest, err := snapshotManifest(jirix, snapshotPath, opts...)
+	if err != nil {
+		return err
+	}
+	return manifest.ToFile(jirix, file)
+}
+
+// snapshotManifest returns the manifest written by CreateSnapshot, with the
+// projects sorted by key and the tools sorted by name, so that snapshots of
+// the same state are identical.
+func snapshotManifest(jirix *jiri.X, snapshotPath string, opts ...SnapshotOpt) (*Manifest, error) {
+	jirix.TimerPush("create snapshot")
+	defer jirix.TimerPop()
+
+	currentState, requireClean, warnUnclean, noHooks := false, false, false, false
+	tag := ""
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case SnapshotTagOpt:
+			tag = string(typedOpt)
+		case CurrentStateOpt:
+			currentState = bool(typedOpt)
+		case RequireCleanOpt:
+			requireClean = bool(typedOpt)
+		case WarnUncleanOpt:
+			warnUnclean = bool(typedOpt)
+		case NoHooksOpt:
+			noHooks = bool(typedOpt)
+		}
+	}
 
 	// Get a clean, symlink-free, relative path to the snapshot.
 	snapshotPath = filepath.Clean(snapshotPath)
@@ -629,35 +1250,142 @@ func CreateSnapshot(jirix *jiri.X, file, snapshotPath string) error {
 
 	manifest := Manifest{
 		SnapshotPath: snapshotPath,
+		Tag:          tag,
 	}
 
 	// Add all local projects to manifest.
 	localProjects, err := LocalProjects(jirix, FullScan)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// Checking the state of every project runs git in each of them, so it is
+	// only done when asked for.
+	var unclean []string
+	if currentState || requireClean || warnUnclean {
+		if unclean, err = uncleanProjects(jirix, localProjects, currentState); err != nil {
+			return nil, err
+		}
 	}
-	for _, project := range localProjects {
-		manifest.Projects = append(manifest.Projects, project)
+	if len(unclean) > 0 && (requireClean || warnUnclean) {
+		if requireClean {
+			return nil, fmt.Errorf("cannot create snapshot, the following projects are not on master or have uncommitted changes:\n%s", strings.Join(unclean, "\n"))
+		}
+		fmt.Fprintf(jirix.Stderr(), "WARNING: the following projects are not on master or have uncommitted changes:\n%s\n", strings.Join(unclean, "\n"))
+		manifest.Comment = "\nThe following projects were not captured from a clean master branch:\n" + strings.Join(unclean, "\n") + "\n"
+	}
+	if noHooks {
+		var hooks []string
+		for _, key := range sortedKeys(localProjects) {
+			hooks = append(hooks, projectHooks(jirix, localProjects[key])...)
+		}
+		if len(hooks) > 0 {
+			manifest.Comment += "\nThe following hooks were skipped, because hooks are disabled:\n" + strings.Join(hooks, "\n") + "\n"
+		}
 	}
+	manifest.Projects = localProjects.toSlice()
 
-	// Add all tools from the current manifest to the snapshot manifest.
+	// Add all tools and post-update commands from the current manifest to
+	// the snapshot manifest.
 	// We can't just call LoadManifest here, since that determines the
 	// local projects using FastScan, but if we're calling CreateSnapshot
 	// during "jiri update" and we added some new projects, they won't be
 	// found anymore.
-	_, tools, err := loadManifestFile(jirix, jirix.JiriManifestFile(), localProjects)
+	ld, err := loadManifestFileLoader(jirix, jirix.JiriManifestFile(), localProjects)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, tool := range tools {
-		manifest.Tools = append(manifest.Tools, tool)
+	manifest.Tools = ld.Tools.toSlice()
+	manifest.PostUpdates = ld.PostUpdates
+	// Embed the manifest lock, so that checking out the snapshot restores it.
+	if manifest.Locks, err = readManifestLock(jirix); err != nil && !runutil.IsNotExist(err) {
+		return nil, err
 	}
-	return manifest.ToFile(jirix, file)
+	manifest.Sort()
+	return &manifest, nil
+}
+
+// uncleanProjects returns a description of each of the given projects that is
+// not on its master branch or has uncommitted changes, in the order of the
+// project keys.  If currentState is true, the revisions of projects that are
+// not on their master branch are replaced with the revisions they have
+// checked out.
+func uncleanProjects(jirix *jiri.X, projects Projects, currentState bool) ([]string, error) {
+	states, err := projectStates(jirix, projects, true)
+	if err != nil {
+		return nil, err
+	}
+	keys := ProjectKeys{}
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	var unclean []string
+	for _, key := range keys {
+		state := states[key]
+		if state.CurrentBranch == "master" && !state.HasUncommitted {
+			continue
+		}
+		project := projects[key]
+		var problems []string
+		if state.CurrentBranch != "master" {
+			problems = append(problems, fmt.Sprintf("on branch %q", state.CurrentBranch))
+		}
+		if state.HasUncommitted {
+			problems = append(problems, "has uncommitted changes")
+		}
+		recorded := "recorded master"
+		if currentState && state.CurrentBranch != "master" {
+			revision, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path)).CurrentRevision()
+			if err != nil {
+				return nil, err
+			}
+			project.Revision = revision
+			projects[key] = project
+			recorded = "recorded the checked out revision"
+		}
+		path, err := filepath.Rel(jirix.Root, project.Path)
+		if err != nil {
+			return nil, err
+		}
+		desc := fmt.Sprintf("  %s (%s): %s; %s", project.Name, path, strings.Join(problems, ", "), recorded)
+		// XML comments must not contain "--".
+		unclean = append(unclean, strings.Replace(desc, "--", "-", -1))
+	}
+	return unclean, nil
 }
 
 // CheckoutSnapshot updates project state to the state specified in the given
 // snapshot file.  Note that the snapshot file must not contain remote imports.
-func CheckoutSnapshot(jirix *jiri.X, snapshot string, gc bool) error {
+//
+// Unless PartialOpt is set, the revisions of the local projects are fetched
+// as needed before any project is changed, and if some of them cannot be
+// obtained, no project is changed and the error lists them all.
+func CheckoutSnapshot(jirix *jiri.X, snapshot string, gc bool, opts ...UpdateOpt) error {
+	detach, branch, force, noVerify, partial := false, "", false, false, false
+	var gitTimeout time.Duration
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case DetachOpt:
+			detach = bool(typedOpt)
+		case DetachBranchOpt:
+			branch = string(typedOpt)
+		case ForceOpt:
+			force = bool(typedOpt)
+		case NoVerifyOpt:
+			noVerify = bool(typedOpt)
+		case PartialOpt:
+			partial = bool(typedOpt)
+		case GitTimeoutOpt:
+			gitTimeout = time.Duration(typedOpt)
+		}
+	}
+	if detach {
+		if gc {
+			return fmt.Errorf("cannot garbage collect projects when detaching")
+		}
+		return checkoutSnapshotDetached(jirix, snapshot, branch, force, noVerify, partial)
+	}
+	summary := newUpdateSummary()
 	// Find all local projects.
 	scanMode := FastScan
 	if gc {
@@ -667,14 +1395,120 @@ func CheckoutSnapshot(jirix *jiri.X, snapshot string, gc bool) error {
 	if err != nil {
 		return err
 	}
-	remoteProjects, remoteTools, err := LoadSnapshotFile(jirix, snapshot)
+	ld, err := loadManifestFileLoader(jirix, snapshot, nil)
+	if err != nil {
+		return err
+	}
+	if !partial {
+		if err := verifySnapshotRevisions(jirix, localProjects, ld.Projects, gitTimeout); err != nil {
+			return err
+		}
+	}
+	if err := updateTo(jirix, summary, localProjects, ld.Projects, ld.Tools, ld.PostUpdates, gc, opts...); err != nil {
+		return err
+	}
+	if err := restoreManifestLock(jirix, snapshot); err != nil {
+		return err
+	}
+	var snapshotOpts []SnapshotOpt
+	for _, opt := range opts {
+		if noHooks, ok := opt.(NoHooksOpt); ok {
+			snapshotOpts = append(snapshotOpts, noHooks)
+		}
+	}
+	return WriteUpdateHistorySnapshot(jirix, snapshot, snapshotOpts...)
+}
+
+// verifySnapshotRevisions checks that the revision of each project of a
+// snapshot is available, so that checking out the snapshot cannot fail halfway
+// because of a revision that no longer exists.  The revisions of projects that
+// exist locally are fetched into the local project if they are not there yet.
+// Projects that do not exist locally are checked as well, by fetching their
+// remote into a temporary repository, since the checkout deletes and moves
+// projects before it creates any.  The projects whose revisions cannot be
+// obtained are listed in a single error.
+func verifySnapshotRevisions(jirix *jiri.X, localProjects, snapshotProjects Projects, gitTimeout time.Duration) error {
+	var unavailable []string
+	for _, key := range sortedKeys(snapshotProjects) {
+		project := snapshotProjects[key]
+		if project.Revision == "" || project.Revision == "HEAD" {
+			continue
+		}
+		local, ok := localProjects[key]
+		var err error
+		if ok {
+			err = fetchSnapshotRevision(jirix, project, local, gitTimeout)
+		} else {
+			err = fetchMissingSnapshotRevision(jirix, project, gitTimeout)
+		}
+		if err != nil {
+			path := local.Path
+			if !ok {
+				path = project.Path
+			}
+			unavailable = append(unavailable, fmt.Sprintf("%s (%s): %v", project.Name, path, err))
+		}
+	}
+	if len(unavailable) > 0 {
+		return fmt.Errorf("the revisions of the following projects of the snapshot cannot be obtained, no project was changed (use -partial to check out the others anyway):\n  %s", strings.Join(unavailable, "\n  "))
+	}
+	return nil
+}
+
+// fetchMissingSnapshotRevision makes sure that the revision of the given
+// project of a snapshot, which does not exist locally, can be fetched from
+// the remote of the project.  The remote is fetched into a temporary
+// repository that is deleted afterwards.
+func fetchMissingSnapshotRevision(jirix *jiri.X, project Project, gitTimeout time.Duration) (e error) {
+	if project.Protocol != "git" {
+		return UnsupportedProtocolErr(project.Protocol)
+	}
+	s := jirix.NewSeq()
+	tmpDir, err := s.TempDir("", "jiri-verify-")
 	if err != nil {
 		return err
 	}
-	if err := updateTo(jirix, localProjects, remoteProjects, remoteTools, gc); err != nil {
+	defer collect.Error(func() error { return jirix.NewSeq().RemoveAll(tmpDir).Done() }, &e)
+	git := gitutil.New(s, gitutil.RootDirOpt(tmpDir))
+	if err := git.Init(tmpDir); err != nil {
+		return err
+	}
+	if err := git.AddRemote("origin", project.Remote); err != nil {
 		return err
 	}
-	return WriteUpdateHistorySnapshot(jirix, snapshot)
+	return fetchSnapshotRevision(jirix, project, Project{Path: tmpDir, Remote: project.Remote}, gitTimeout)
+}
+
+// fetchSnapshotRevision makes sure that the revision of the given project of
+// a snapshot exists in the given local project, fetching it from the remote of
+// the snapshot project if it does not.
+func fetchSnapshotRevision(jirix *jiri.X, project, local Project, gitTimeout time.Duration) error {
+	if project.Protocol != "git" {
+		return UnsupportedProtocolErr(project.Protocol)
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(local.Path), gitutil.TimeoutOpt(gitTimeout))
+	if git.CommitExists(project.Revision) {
+		return nil
+	}
+	// Fetch through the origin remote if it is the remote of the snapshot,
+	// so that its remote-tracking branches are updated as by the checkout,
+	// and leave the remotes of the project unchanged otherwise.
+	remote := project.Remote
+	if remote == local.Remote {
+		remote = "origin"
+	}
+	if err := git.Fetch(remote); err != nil {
+		return timeoutError(err, gitTimeout, "fetching project %q from %q", project.Name, project.Remote)
+	}
+	if git.CommitExists(project.Revision) {
+		return nil
+	}
+	// The revision may not be reachable from the branches of the remote, but
+	// some servers allow fetching such commits by their full SHA-1.
+	if fullRevisionRE.MatchString(project.Revision) && git.FetchRefspec(remote, project.Revision) == nil && git.CommitExists(project.Revision) {
+		return nil
+	}
+	return fmt.Errorf("revision %q does not exist in %q", project.Revision, project.Remote)
 }
 
 // LoadSnapshotFile loads the specified snapshot manifest.  If the snapshot
@@ -693,7 +1527,7 @@ func CurrentProjectKey(jirix *jiri.X) (ProjectKey, error) {
 	}
 	metadataDir := filepath.Join(topLevel, jiri.ProjectMetaDir)
 	if _, err := jirix.NewSeq().Stat(metadataDir); err == nil {
-		project, err := ProjectFromFile(jirix, filepath.Join(metadataDir, jiri.ProjectMetaFile))
+		project, err := ProjectAtPath(jirix, topLevel)
 		if err != nil {
 			return "", err
 		}
@@ -702,25 +1536,132 @@ func CurrentProjectKey(jirix *jiri.X) (ProjectKey, error) {
 	return "", nil
 }
 
+// maxRevisionWorkers bounds the number of projects whose revisions are
+// determined concurrently by setProjectRevisions.
+const maxRevisionWorkers = 8
+
+// revisionCacheFile is the name of the file in the project metadata directory
+// that caches the revision of the master branch.
+const revisionCacheFile = "revision.cache"
+
 // setProjectRevisions sets the current project revision from the master for
 // each project as found on the filesystem
 func setProjectRevisions(jirix *jiri.X, projects Projects) (_ Projects, e error) {
-	for name, project := range projects {
-		switch project.Protocol {
-		case "git":
-			revision, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path)).CurrentRevisionOfBranch("master")
-			if err != nil {
-				return nil, err
+	jirix.TimerPush("set revisions")
+	defer jirix.TimerPop()
+
+	type result struct {
+		key      ProjectKey
+		revision string
+		err      error
+	}
+	keys := make(chan ProjectKey, len(projects))
+	results := make(chan result, len(projects))
+	workers := maxRevisionWorkers
+	if len(projects) < workers {
+		workers = len(projects)
+	}
+	for i := 0; i < workers; i++ {
+		// jirix is not threadsafe, so we make a clone for each goroutine.
+		go func(jirix *jiri.X) {
+			for key := range keys {
+				project := projects[key]
+				switch project.Protocol {
+				case "git":
+					revision, err := projectRevision(jirix, project)
+					results <- result{key, revision, err}
+				default:
+					results <- result{key, "", UnsupportedProtocolErr(project.Protocol)}
+				}
 			}
-			project.Revision = revision
-		default:
-			return nil, UnsupportedProtocolErr(project.Protocol)
+		}(jirix.Clone(tool.ContextOpts{}))
+	}
+	for key := range projects {
+		keys <- key
+	}
+	close(keys)
+	revisions := map[ProjectKey]string{}
+	var err error
+	for _ = range projects {
+		r := <-results
+		if r.err != nil && err == nil {
+			err = r.err
 		}
-		projects[name] = project
+		revisions[r.key] = r.revision
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key, project := range projects {
+		project.Revision = revisions[key]
+		projects[key] = project
 	}
 	return projects, nil
 }
 
+// projectRevision returns the revision of the master branch of the given git
+// project.  The revision is cached in the project metadata directory, keyed by
+// the state of the master branch ref, so that projects whose master branch
+// hasn't moved don't require running git.
+func projectRevision(jirix *jiri.X, project Project) (string, error) {
+	key, err := masterRefKey(project.Path)
+	if err != nil {
+		return "", err
+	}
+	cacheFile := filepath.Join(project.Path, jiri.ProjectMetaDir, revisionCacheFile)
+	if key != "" {
+		if data, err := ioutil.ReadFile(cacheFile); err == nil {
+			if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) == 2 && lines[0] == key {
+				return lines[1], nil
+			}
+		}
+	}
+	revision, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path)).CurrentRevisionOfBranch("master")
+	if err != nil {
+		return "", err
+	}
+	if key != "" {
+		// The key was computed before running git, so if master moved in the
+		// meantime the key will be stale and the entry will not be used.
+		// Failing to write the cache only makes the next lookup slower.
+		ioutil.WriteFile(cacheFile, []byte(key+"\n"+revision+"\n"), 0644)
+	}
+	return revision, nil
+}
+
+// masterRefKey returns a key that changes whenever the master branch of the
+// git repository at the given path moves.  The key is derived from the
+// contents of the loose master ref and the packed refs, rather than their
+// modification times, so that a ref rewritten within the timestamp
+// granularity is still noticed.  An empty key is returned if the repository
+// layout is not recognized, in which case the revision should not be cached.
+func masterRefKey(path string) (string, error) {
+	gitDir := filepath.Join(path, ".git")
+	fi, err := os.Stat(gitDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", nil
+	}
+	parts := []string{}
+	for _, file := range []string{filepath.Join("refs", "heads", "master"), "packed-refs"} {
+		data, err := ioutil.ReadFile(filepath.Join(gitDir, file))
+		switch {
+		case err == nil:
+			parts = append(parts, fmt.Sprintf("%x", sha1.Sum(data)))
+		case os.IsNotExist(err):
+			parts = append(parts, "-")
+		default:
+			return "", err
+		}
+	}
+	return strings.Join(parts, ","), nil
+}
+
 // LocalProjects returns projects on the local filesystem.  If all projects in
 // the manifest exist locally and scanMode is set to FastScan, then only the
 // projects in the manifest that exist locally will be returned.  Otherwise, a
@@ -730,15 +1671,24 @@ func LocalProjects(jirix *jiri.X, scanMode ScanMode) (Projects, error) {
 	jirix.TimerPush("local projects")
 	defer jirix.TimerPop()
 
-	latestSnapshot := jirix.UpdateHistoryLatestLink()
-	latestSnapshotExists, err := jirix.NewSeq().IsFile(latestSnapshot)
+	projects, err := scanLocalProjects(jirix, scanMode)
 	if err != nil {
 		return nil, err
 	}
-	if scanMode == FastScan && latestSnapshotExists {
-		// Fast path: Full scan was not requested, and we have a snapshot containing
-		// the latest update.  Check that the projects listed in the snapshot exist
-		// locally.  If not, then fall back on the slow path.
+	return setProjectRevisions(jirix, projects)
+}
+
+// scanLocalProjects implements LocalProjects, without reading the revisions of
+// the projects, which fails if any of their git repositories is broken.
+func scanLocalProjects(jirix *jiri.X, scanMode ScanMode) (Projects, error) {
+	latestSnapshot, err := historyLinkTarget(jirix.UpdateHistoryLatestLink())
+	if err != nil {
+		return nil, err
+	}
+	if scanMode == FastScan && latestSnapshot != "" {
+		// Fast path: Full scan was not requested, and we have a snapshot containing
+		// the latest update.  Check that the projects listed in the snapshot exist
+		// locally.  If not, then fall back on the slow path.
 		//
 		// An error will be returned if the snapshot contains remote imports, since
 		// that would cause an infinite loop; we'd need local projects, in order to
@@ -752,7 +1702,7 @@ func LocalProjects(jirix *jiri.X, scanMode ScanMode) (Projects, error) {
 			return nil, err
 		}
 		if projectsExist {
-			return setProjectRevisions(jirix, snapshotProjects)
+			return snapshotProjects, nil
 		}
 	}
 
@@ -760,13 +1710,19 @@ func LocalProjects(jirix *jiri.X, scanMode ScanMode) (Projects, error) {
 	// that were not found locally.  Do a recursive scan of all projects under
 	// JIRI_ROOT.
 	projects := Projects{}
-	jirix.TimerPush("scan fs")
-	err = findLocalProjects(jirix, jirix.Root, projects)
+	ignore, err := scanIgnoreDirs(jirix)
+	if err != nil {
+		return nil, err
+	}
+	invalid := map[string]error{}
+	jirix.TimerPushCategory(jiri.TimerScanFS, "scan fs")
+	err = findLocalProjects(jirix, jirix.Root, projects, ignore, invalid)
 	jirix.TimerPop()
 	if err != nil {
 		return nil, err
 	}
-	return setProjectRevisions(jirix, projects)
+	reportInvalidMetadata(jirix, invalid)
+	return projects, nil
 }
 
 // projectsExistLocally returns true iff all the given projects exist on the
@@ -788,13 +1744,34 @@ func projectsExistLocally(jirix *jiri.X, projects Projects) (bool, error) {
 	return true, nil
 }
 
+// PollOpt is an option for PollProjects.
+type PollOpt interface {
+	pollOpt()
+}
+
+// GerritChangesOpt causes PollProjects to look up the gerrit change of each
+// changelist with a Change-Id on the gerrit host of its project.  The lookups
+// are batched per host; hosts that cannot be queried are reported as warnings
+// and leave the changes unknown.
+type GerritChangesOpt bool
+
+func (GerritChangesOpt) pollOpt() {}
+
 // PollProjects returns the set of changelists that exist remotely but not
 // locally. Changes are grouped by projects and contain author identification
 // and a description of their content.
-func PollProjects(jirix *jiri.X, projectSet map[string]struct{}) (_ Update, e error) {
+func PollProjects(jirix *jiri.X, projectSet map[string]struct{}, opts ...PollOpt) (_ Update, e error) {
 	jirix.TimerPush("poll projects")
 	defer jirix.TimerPop()
 
+	gerritChanges := false
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case GerritChangesOpt:
+			gerritChanges = bool(typedOpt)
+		}
+	}
+
 	// Switch back to current working directory when we're done.
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -814,6 +1791,7 @@ func PollProjects(jirix *jiri.X, projectSet map[string]struct{}) (_ Update, e er
 
 	// Compute difference between local and remote.
 	update := Update{}
+	gerritHosts := map[string]string{}
 	ops := computeOperations(localProjects, remoteProjects, false)
 	s := jirix.NewSeq()
 	for _, op := range ops {
@@ -853,18 +1831,26 @@ func PollProjects(jirix *jiri.X, projectSet map[string]struct{}) (_ Update, e er
 					if got, want := len(commitText), 3; got < want {
 						return nil, fmt.Errorf("Unexpected length of %v: got %v, want at least %v", commitText, got, want)
 					}
-					cls = append(cls, CL{
+					cl := CL{
 						Author:      commitText[0],
 						Email:       commitText[1],
 						Description: strings.Join(commitText[2:], "\n"),
-					})
+					}
+					if match := changeIDRE.FindStringSubmatch(cl.Description); match != nil {
+						cl.ChangeID = match[1]
+					}
+					cls = append(cls, cl)
 				}
+				gerritHosts[name] = updateOp.project.GerritHost
 			default:
 				return nil, UnsupportedProtocolErr(updateOp.project.Protocol)
 			}
 		}
 		update[name] = cls
 	}
+	if gerritChanges {
+		setGerritChanges(jirix, update, gerritHosts)
+	}
 	return update, nil
 }
 
@@ -877,7 +1863,7 @@ func PollProjects(jirix *jiri.X, projectSet map[string]struct{}) (_ Update, e er
 // about ".git/index.lock exists", you are likely calling LoadManifest in
 // parallel.
 func LoadManifest(jirix *jiri.X) (Projects, Tools, error) {
-	jirix.TimerPush("load manifest")
+	jirix.TimerPushCategory(jiri.TimerLoadManifest, "load manifest")
 	defer jirix.TimerPop()
 	file := jirix.JiriManifestFile()
 	localProjects, err := LocalProjects(jirix, FastScan)
@@ -896,13 +1882,26 @@ func LoadManifest(jirix *jiri.X) (Projects, Tools, error) {
 // errors about ".git/index.lock exists", you are likely calling
 // loadManifestFile in parallel.
 func loadManifestFile(jirix *jiri.X, file string, localProjects Projects) (Projects, Tools, error) {
-	ld := newManifestLoader(localProjects, false)
-	if err := ld.Load(jirix, "", file, ""); err != nil {
+	ld, err := loadManifestFileLoader(jirix, file, localProjects)
+	if err != nil {
 		return nil, nil, err
 	}
 	return ld.Projects, ld.Tools, nil
 }
 
+// loadManifestFileLoader is like loadManifestFile, but returns the loader,
+// which also holds the post-update commands of the manifest.
+func loadManifestFileLoader(jirix *jiri.X, file string, localProjects Projects) (*loader, error) {
+	ld := newManifestLoader(localProjects, false)
+	if err := ld.Load(jirix, "", file, ""); err != nil {
+		return nil, err
+	}
+	if err := checkCaseCollisions(jirix, ld.Projects); err != nil {
+		return nil, err
+	}
+	return ld, nil
+}
+
 // getManifestRemote returns the remote url of the origin from the manifest
 // repo.
 // TODO(nlacasse,toddw): Once the manifest project is specified in the
@@ -917,23 +1916,37 @@ func getManifestRemote(jirix *jiri.X, manifestPath string) (string, error) {
 		}, "get manifest origin").Done()
 }
 
-func loadUpdatedManifest(jirix *jiri.X, localProjects Projects) (Projects, Tools, string, error) {
-	jirix.TimerPush("load updated manifest")
+// loadUpdatedManifest loads the manifest, updating all manifest projects to
+// match their remote counterparts, or to the given locked revisions, keyed by
+// importLockKey, if they are not nil.  The removeTmpDir function of the
+// returned loader removes the temporary directory that remote imports were
+// cloned into, and must be called even if an error is returned.  If noVerify
+// is true, the signatures of the revisions of the manifest projects are not
+// verified.
+func loadUpdatedManifest(jirix *jiri.X, localProjects Projects, gitTimeout time.Duration, noVerify bool, locked map[string]string) (*loader, error) {
+	jirix.TimerPushCategory(jiri.TimerLoadManifest, "load updated manifest")
 	defer jirix.TimerPop()
 	ld := newManifestLoader(localProjects, true)
+	ld.gitTimeout = gitTimeout
+	ld.noVerify = noVerify
+	ld.locked = locked
 	if err := ld.Load(jirix, "", jirix.JiriManifestFile(), ""); err != nil {
-		return nil, nil, ld.TmpDir, err
+		return ld, err
+	}
+	if err := checkCaseCollisions(jirix, ld.Projects); err != nil {
+		return ld, err
 	}
-	return ld.Projects, ld.Tools, ld.TmpDir, nil
+	return ld, nil
 }
 
 // UpdateUniverse updates all local projects and tools to match the remote
 // counterparts identified in the manifest. Optionally, the 'gc' flag can be
 // used to indicate that local projects that no longer exist remotely should be
 // removed.
-func UpdateUniverse(jirix *jiri.X, gc bool) (e error) {
+func UpdateUniverse(jirix *jiri.X, gc bool, opts ...UpdateOpt) (e error) {
 	jirix.TimerPush("update universe")
 	defer jirix.TimerPop()
+	summary := newUpdateSummary()
 
 	// Find all local projects.
 	scanMode := FastScan
@@ -947,39 +1960,158 @@ func UpdateUniverse(jirix *jiri.X, gc bool) (e error) {
 
 	// Load the manifest, updating all manifest projects to match their remote
 	// counterparts.
-	s := jirix.NewSeq()
-	remoteProjects, remoteTools, tmpLoadDir, err := loadUpdatedManifest(jirix, localProjects)
-	if tmpLoadDir != "" {
-		defer collect.Error(func() error { return s.RemoveAll(tmpLoadDir).Done() }, &e)
+	var gitTimeout time.Duration
+	noVerify, frozen := false, false
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case GitTimeoutOpt:
+			gitTimeout = time.Duration(typedOpt)
+		case NoVerifyOpt:
+			noVerify = bool(typedOpt)
+		case FrozenOpt:
+			frozen = bool(typedOpt)
+		}
 	}
+	var locked map[string]string
+	if frozen {
+		if locked, err = lockedRevisions(jirix); err != nil {
+			return err
+		}
+	}
+	ld, err := loadUpdatedManifest(jirix, localProjects, gitTimeout, noVerify, locked)
+	defer collect.Error(ld.removeTmpDir, &e)
 	if err != nil {
 		return err
 	}
-	return updateTo(jirix, localProjects, remoteProjects, remoteTools, gc)
+	if err := updateTo(jirix, summary, localProjects, ld.Projects, ld.Tools, ld.PostUpdates, gc, opts...); err != nil {
+		return err
+	}
+	return writeManifestLock(jirix, ld.resolved)
 }
 
 // updateTo updates the local projects and tools to the state specified in
-// remoteProjects and remoteTools.
-func updateTo(jirix *jiri.X, localProjects, remoteProjects Projects, remoteTools Tools, gc bool) (e error) {
+// remoteProjects and remoteTools, runs the given post-update commands, and
+// prints a summary of the update, which is recorded in the given summary.
+func updateTo(jirix *jiri.X, summary *updateSummary, localProjects, remoteProjects Projects, remoteTools Tools, postUpdates []PostUpdate, gc bool, opts ...UpdateOpt) (e error) {
+	noHooks, verbose, forceRemoteChange, rebaseTracked := false, true, false, false
+	skipPostUpdate := false
+	var pruneGroups []string
+	var reference referenceRepos
+	heads := remoteHeadsOpts{cacheTTL: googlesource.DefaultCacheTTL}
+	var gitTimeout time.Duration
+	hooks := hookOpts{profilesDB: jirix.ProfilesDBDir(), profilesDir: jiri.ProfilesRootDir}
+	noVerify, detectLFS, progress := false, false, false
+	goRoot := ""
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case NoHooksOpt:
+			noHooks = bool(typedOpt)
+		case SummaryOnlyOpt:
+			verbose = !bool(typedOpt)
+		case PruneGroupsOpt:
+			pruneGroups = []string(typedOpt)
+		case ReferenceDirOpt:
+			reference.dir = string(typedOpt)
+		case DissociateOpt:
+			reference.dissociate = bool(typedOpt)
+		case OfflineOpt:
+			heads.offline = bool(typedOpt)
+		case GoogleSourceHostsOpt:
+			heads.hosts = []string(typedOpt)
+		case NoRemoteCacheOpt:
+			heads.noCache = bool(typedOpt)
+		case RemoteCacheDirOpt:
+			heads.cacheDir = string(typedOpt)
+		case RemoteCacheTTLOpt:
+			heads.cacheTTL = time.Duration(typedOpt)
+		case ForceRemoteChangeOpt:
+			forceRemoteChange = bool(typedOpt)
+		case RebaseTrackedOpt:
+			rebaseTracked = bool(typedOpt)
+		case GoRootOpt:
+			goRoot = string(typedOpt)
+		case GitTimeoutOpt:
+			gitTimeout = time.Duration(typedOpt)
+		case HookProfilesOpt:
+			hooks.profiles = []string(typedOpt)
+		case InstallMissingProfilesOpt:
+			hooks.installMissingProfiles = bool(typedOpt)
+		case ProfilesDBOpt:
+			hooks.profilesDB = string(typedOpt)
+		case ProfilesDirOpt:
+			hooks.profilesDir = string(typedOpt)
+		case HookTimeoutOpt:
+			hooks.timeout = time.Duration(typedOpt)
+		case HookXUnitFileOpt:
+			hooks.xunitFile = string(typedOpt)
+		case NoVerifyOpt:
+			noVerify = bool(typedOpt)
+		case SkipPostUpdateOpt:
+			skipPostUpdate = bool(typedOpt)
+		case DetectLFSOpt:
+			detectLFS = bool(typedOpt)
+		case ProgressOpt:
+			progress = bool(typedOpt)
+		}
+	}
+	defer func() { fmt.Fprint(jirix.Stdout(), summary) }()
 	s := jirix.NewSeq()
-	// 1. Update all local projects to match the specified projects argument.
-	if err := updateProjects(jirix, localProjects, remoteProjects, gc); err != nil {
+	// 1. Check that the tools can be built, before changing any project.
+	goEnv, err := goToolchainEnv(jirix, goRoot)
+	if err != nil {
+		return err
+	}
+	if err := checkGoVersion(jirix, goEnv, remoteTools); err != nil {
+		summary.toolsErr = err
 		return err
 	}
-	// 2. Build all tools in a temporary directory.
+	if !noHooks {
+		if err := checkRequiredProfiles(jirix, remoteProjects, hooks); err != nil {
+			return err
+		}
+	}
+	// 2. Update all local projects to match the specified projects argument.
+	// Caches of the states of the projects are invalidated even if the update
+	// fails halfway.
+	defer collect.Error(func() error { return bumpGeneration(jirix) }, &e)
+	if hooks.xunitFile != "" {
+		defer collect.Error(func() error { return writeHookXUnitReport(jirix, hooks.xunitFile, summary.hooks) }, &e)
+	}
+	if err := updateProjects(jirix, summary, localProjects, remoteProjects, gc, noHooks, verbose, progress, forceRemoteChange, rebaseTracked, pruneGroups, reference, heads, gitTimeout, noVerify, detectLFS, hooks); err != nil {
+		return err
+	}
+	// 3. Build all tools in a temporary directory.
 	tmpToolsDir, err := s.TempDir("", "tmp-jiri-tools-build")
 	if err != nil {
 		return fmt.Errorf("TempDir() failed: %v", err)
 	}
-	defer collect.Error(func() error { return s.RemoveAll(tmpToolsDir).Done() }, &e)
-	if err := buildToolsFromMaster(jirix, remoteProjects, remoteTools, tmpToolsDir); err != nil {
+	defer collect.Error(jirix.AddCleanup(func() error { return jirix.NewSeq().RemoveAll(tmpToolsDir).Done() }), &e)
+	built, buildErr := buildToolsFromMaster(jirix, remoteProjects, remoteTools, tmpToolsDir, verbose, GoRootOpt(goRoot))
+	summary.skippedTools, summary.failedTools = built.skipped, built.failed
+	if buildErr != nil && len(built.built) == 0 {
+		summary.toolsErr = buildErr
+		return buildErr
+	}
+	// 4. Install the tools into $JIRI_ROOT/.jiri_root/bin.  The tools that
+	// were built are installed even if others failed to build, which keep
+	// their previous binaries.
+	tools, err := installTools(jirix, tmpToolsDir, verbose)
+	summary.tools = tools
+	if err != nil {
+		summary.toolsErr = err
 		return err
 	}
-	// 3. Install the tools into $JIRI_ROOT/.jiri_root/bin.
-	if err := InstallTools(jirix, tmpToolsDir); err != nil {
+	if buildErr != nil {
+		summary.toolsErr = buildErr
+		return buildErr
+	}
+	// 5. Run the post-update commands, now that the tree is consistent.
+	if skipPostUpdate {
+		reportSkippedPostUpdates(jirix, postUpdates)
+	} else if err := runPostUpdates(jirix, summary, remoteProjects, postUpdates); err != nil {
 		return err
 	}
-	// 4. If we have the jiri project, then update the jiri script in
+	// 6. If we have the jiri project, then update the jiri script in
 	// $JIRI_ROOT/.jiri_root/scripts.
 	jiriProject, err := remoteProjects.FindUnique(JiriProject)
 	if err != nil {
@@ -990,38 +2122,86 @@ func updateTo(jirix *jiri.X, localProjects, remoteProjects Projects, remoteTools
 }
 
 // WriteUpdateHistorySnapshot creates a snapshot of the current state of all
-// projects and writes it to the update history directory.
-func WriteUpdateHistorySnapshot(jirix *jiri.X, snapshotPath string) error {
-	seq := jirix.NewSeq()
+// projects and writes it to the update history directory.  If
+// UpdateHistoryKeepOpt or UpdateHistoryMaxAgeOpt is given, the update history
+// is then pruned with PruneUpdateHistory.
+//
+// Unless ForceSnapshotOpt is given, no snapshot is written if the snapshot is
+// identical to the latest one, apart from the path of the snapshot file.  The
+// update history links are then left unchanged.
+func WriteUpdateHistorySnapshot(jirix *jiri.X, snapshotPath string, opts ...SnapshotOpt) error {
+	keep, maxAge := 0, time.Duration(0)
+	force := false
+	var pointerOpts []jiri.PointerOpt
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case ForceSnapshotOpt:
+			force = bool(typedOpt)
+		case UpdateHistoryKeepOpt:
+			keep = int(typedOpt)
+		case UpdateHistoryMaxAgeOpt:
+			maxAge = time.Duration(typedOpt)
+		case UpdateHistoryPointerFileOpt:
+			pointerOpts = append(pointerOpts, jiri.PointerFileOpt(typedOpt))
+		}
+	}
 	snapshotFile := filepath.Join(jirix.UpdateHistoryDir(), time.Now().Format(time.RFC3339))
-	if err := CreateSnapshot(jirix, snapshotFile, snapshotPath); err != nil {
-		return err
+	path := snapshotPath
+	if path == "" {
+		path = snapshotFile
 	}
-
-	latestLink, secondLatestLink := jirix.UpdateHistoryLatestLink(), jirix.UpdateHistorySecondLatestLink()
-
-	// If the "latest" symlink exists, point the "second-latest" symlink to its value.
-	latestLinkExists, err := seq.IsFile(latestLink)
+	manifest, err := snapshotManifest(jirix, path, opts...)
 	if err != nil {
 		return err
 	}
-	if latestLinkExists {
-		latestFile, err := os.Readlink(latestLink)
+	if snapshotPath == "" {
+		if manifestPath := relativeManifestPath(jirix); manifestPath != jiri.JiriManifestFile {
+			manifest.ManifestPath = manifestPath
+		}
+	}
+	latestLink, secondLatestLink := jirix.UpdateHistoryLatestLink(), jirix.UpdateHistorySecondLatestLink()
+	if !force {
+		latest, err := historyLinkTarget(latestLink)
 		if err != nil {
 			return err
 		}
-		if err := seq.RemoveAll(secondLatestLink).Symlink(latestFile, secondLatestLink).Done(); err != nil {
+		unchanged, err := sameSnapshot(jirix, manifest, latest, snapshotPath == "")
+		if err != nil {
 			return err
 		}
+		if unchanged {
+			fmt.Fprintf(jirix.Stdout(), "Snapshot unchanged since %s, not writing a new one\n", shortFileName(jirix.Root, latest))
+			return nil
+		}
+	}
+	if err := manifest.ToFile(jirix, snapshotFile); err != nil {
+		return err
 	}
 
-	// Point the "latest" update history symlink to the new snapshot file.  Try
-	// to keep the symlink relative, to make it easy to move or copy the entire
+	// If the "latest" link exists, point the "second-latest" link to its
+	// value.  The links are symlinks, or pointer files where symlinks are not
+	// supported.
+	latestFile, err := jiri.ReadPointer(latestLink)
+	switch {
+	case err == nil:
+		if err := jirix.WritePointer(secondLatestLink, latestFile, pointerOpts...); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	// Point the "latest" update history link to the new snapshot file.  Try to
+	// keep the link relative, to make it easy to move or copy the entire
 	// update_history directory.
 	if rel, err := filepath.Rel(filepath.Dir(latestLink), snapshotFile); err == nil {
 		snapshotFile = rel
 	}
-	return seq.RemoveAll(latestLink).Symlink(snapshotFile, latestLink).Done()
+	if err := jirix.WritePointer(latestLink, snapshotFile, pointerOpts...); err != nil {
+		return err
+	}
+	_, _, err = PruneUpdateHistory(jirix, keep, maxAge)
+	return err
 }
 
 // ApplyToLocalMaster applies an operation expressed as the given function to
@@ -1056,9 +2236,10 @@ func ApplyToLocalMaster(jirix *jiri.X, projects Projects, fn func() error) (e er
 			if err := git.CheckoutBranch("master"); err != nil {
 				return err
 			}
-			// After running the function, return to this project's directory,
-			// checkout the original branch, and stash pop if necessary.
-			defer collect.Error(func() error {
+			// After running the function, or if jiri is interrupted while
+			// running it, return to this project's directory, checkout the
+			// original branch, and stash pop if necessary.
+			restore := jirix.AddCleanup(func() error {
 				if err := s.Chdir(p.Path).Done(); err != nil {
 					return err
 				}
@@ -1069,7 +2250,8 @@ func ApplyToLocalMaster(jirix *jiri.X, projects Projects, fn func() error) (e er
 					return git.StashPop()
 				}
 				return nil
-			}, &e)
+			})
+			defer collect.Error(restore, &e)
 		default:
 			return UnsupportedProtocolErr(p.Protocol)
 		}
@@ -1078,38 +2260,106 @@ func ApplyToLocalMaster(jirix *jiri.X, projects Projects, fn func() error) (e er
 }
 
 // BuildTools builds the given tools and places the resulting binaries into the
-// given directory.
-func BuildTools(jirix *jiri.X, projects Projects, tools Tools, outputDir string) (e error) {
-	jirix.TimerPush("build tools")
+// given directory.  The tools are built with the Go toolchain selected by
+// GoRootOpt, or the one installed by the "go" profile, or the "go" binary in
+// PATH, in this order; the build fails if the toolchain is older than the Go
+// version the tools require.  The name and the current revision of the
+// project of each tool, and the build time, are embedded into the binaries as
+// metadata.
+//
+// Tools whose OS or Arch lists do not include the host are skipped.  Each
+// tool is built by its own "go install", so that a tool that fails to build
+// does not prevent the others from being built; the returned error reports
+// all the tools that failed.
+func BuildTools(jirix *jiri.X, projects Projects, tools Tools, outputDir string, opts ...BuildToolsOpt) error {
+	results, err := buildTools(jirix, projects, tools, outputDir, opts...)
+	reportSkippedTools(jirix, results.skipped)
+	return err
+}
+
+// toolBuildResults records the names of the tools that were built, that were
+// skipped because they are not built on the host, and that failed to build.
+type toolBuildResults struct {
+	built, skipped, failed []string
+}
+
+// reportSkippedTools logs the given tools that were skipped because they are
+// not built on the host.
+func reportSkippedTools(jirix *jiri.X, skipped []string) {
+	for _, name := range skipped {
+		fmt.Fprintf(jirix.Stdout(), "skipped tool %q, which is not built on %s/%s\n", name, runtime.GOOS, runtime.GOARCH)
+	}
+}
+
+// buildTools implements BuildTools, and returns which tools were built,
+// skipped and failed.
+func buildTools(jirix *jiri.X, projects Projects, tools Tools, outputDir string, opts ...BuildToolsOpt) (results toolBuildResults, e error) {
+	jirix.TimerPushCategory(jiri.TimerBuildTools, "build tools")
 	defer jirix.TimerPop()
-	if len(tools) == 0 {
+	goRoot := ""
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case GoRootOpt:
+			goRoot = string(typedOpt)
+		}
+	}
+	names := []string{}
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hostTools := Tools{}
+	for _, name := range names {
+		if !tools[name].builtOn(runtime.GOOS, runtime.GOARCH) {
+			results.skipped = append(results.skipped, name)
+			continue
+		}
+		hostTools[name] = tools[name]
+	}
+	if len(hostTools) == 0 {
 		// Nothing to do here...
-		return nil
+		return results, nil
+	}
+	goEnv, err := goToolchainEnv(jirix, goRoot)
+	if err != nil {
+		return results, err
+	}
+	if err := checkGoVersion(jirix, goEnv, hostTools); err != nil {
+		return results, errkind.Wrap(errkind.ToolBuildError, err)
 	}
-	toolPkgs := []string{}
+	// failures describes the tools that failed to build.
+	var failures []string
+	fail := func(name, format string, args ...interface{}) {
+		results.failed = append(results.failed, name)
+		failures = append(failures, fmt.Sprintf(format, args...))
+	}
+	// Group the tools by the flags and environment they are built with, and
+	// by their project, whose revision is embedded into the binaries.
+	groups := map[string]Tools{}
+	groupProjects := map[string]Project{}
 	workspaceSet := map[string]bool{}
-	for _, tool := range tools {
-		toolPkgs = append(toolPkgs, tool.Package)
+	for _, name := range names {
+		tool, ok := hostTools[name]
+		if !ok {
+			continue
+		}
 		toolProject, err := projects.FindUnique(tool.Project)
 		if err != nil {
-			return err
-		}
-		// Identify the Go workspace the tool is in. To this end we use a
-		// heuristic that identifies the maximal suffix of the project path
-		// that corresponds to a prefix of the package name.
-		workspace := ""
-		for i := 0; i < len(toolProject.Path); i++ {
-			if toolProject.Path[i] == filepath.Separator {
-				if strings.HasPrefix("src/"+tool.Package, filepath.ToSlash(toolProject.Path[i+1:])) {
-					workspace = toolProject.Path[:i]
-					break
-				}
-			}
+			fail(name, "tool %q: %v", name, err)
+			continue
 		}
+		workspace := goWorkspace(toolProject.Path, tool.Package, filepath.Separator)
 		if workspace == "" {
-			return fmt.Errorf("could not identify go workspace for tool %v", tool.Name)
+			fail(name, "could not identify go workspace for tool %v", tool.Name)
+			continue
 		}
 		workspaceSet[workspace] = true
+		key := tool.buildKey() + "\x00" + string(toolProject.Key())
+		if groups[key] == nil {
+			groups[key] = Tools{}
+		}
+		groups[key][name] = tool
+		groupProjects[key] = toolProject
 	}
 	workspaces := []string{}
 	for workspace := range workspaceSet {
@@ -1124,32 +2374,82 @@ func BuildTools(jirix *jiri.X, projects Projects, tools Tools, outputDir string)
 	// weird errors when they share a pkgdir.
 	tmpPkgDir, err := s.TempDir("", "tmp-pkg-dir")
 	if err != nil {
-		return fmt.Errorf("TempDir() failed: %v", err)
-	}
-	defer collect.Error(func() error { return jirix.NewSeq().RemoveAll(tmpPkgDir).Done() }, &e)
-
-	// We unset GOARCH and GOOS because jiri update should always build for the
-	// native architecture and OS.  Also, as of go1.5, setting GOBIN is not
-	// compatible with GOARCH or GOOS.
-	env := map[string]string{
-		"GOARCH": "",
-		"GOOS":   "",
-		"GOBIN":  outputDir,
-		"GOPATH": strings.Join(workspaces, string(filepath.ListSeparator)),
+		return results, fmt.Errorf("TempDir() failed: %v", err)
+	}
+	defer collect.Error(jirix.AddCleanup(func() error { return jirix.NewSeq().RemoveAll(tmpPkgDir).Done() }), &e)
+
+	// Run one "go install" for each tool, in a deterministic order.  Each
+	// group of tools uses its own pkgdir, since packages built with different
+	// flags must not be shared, but the tools of a group share theirs, so
+	// that their common packages are built once.
+	keys := []string{}
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buildTime := time.Now().UTC().Format(time.RFC3339)
+	for i, key := range keys {
+		// We unset GOARCH and GOOS because jiri update should always build for
+		// the native architecture and OS.  Also, as of go1.5, setting GOBIN is
+		// not compatible with GOARCH or GOOS.
+		env := map[string]string{
+			"GOARCH": "",
+			"GOOS":   "",
+		}
+		for key, value := range goEnv {
+			env[key] = value
+		}
+		var flags, groupNames []string
+		for name, tool := range groups[key] {
+			flags = strings.Fields(tool.BuildFlags)
+			for _, kv := range strings.Fields(tool.Env) {
+				parts := strings.SplitN(kv, "=", 2)
+				env[parts[0]] = parts[1]
+			}
+			groupNames = append(groupNames, name)
+		}
+		sort.Strings(groupNames)
+		env["GOBIN"] = outputDir
+		env["GOPATH"] = strings.Join(workspaces, string(filepath.ListSeparator))
+		pkgDir := filepath.Join(tmpPkgDir, strconv.Itoa(i))
+		toolProject, revision := groupProjects[key], ""
+		if toolProject.Protocol == "git" {
+			git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(toolProject.Path))
+			if revision, err = git.CurrentRevision(); err != nil {
+				return results, err
+			}
+		}
+		md := metadata.FromMap(map[string]string{
+			ToolProjectMetadata:   toolProject.Name,
+			ToolRevisionMetadata:  revision,
+			ToolBuildTimeMetadata: buildTime,
+		})
+		flags = addLDFlag(flags, metadata.LDFlag(md))
+		for _, name := range groupNames {
+			args := append([]string{"install", "-pkgdir", pkgDir}, flags...)
+			args = append(args, groups[key][name].Package)
+			var stderr bytes.Buffer
+			if err := s.Env(env).Capture(ioutil.Discard, &stderr).Last("go", args...); err != nil {
+				fail(name, "tool %q build failed\n%v", name, stderr.String())
+				continue
+			}
+			results.built = append(results.built, name)
+		}
 	}
-	args := append([]string{"install", "-pkgdir", tmpPkgDir}, toolPkgs...)
-	var stderr bytes.Buffer
-	if err := s.Env(env).Capture(ioutil.Discard, &stderr).Last("go", args...); err != nil {
-		return fmt.Errorf("tool build failed\n%v", stderr.String())
+	sort.Strings(results.built)
+	if len(failures) > 0 {
+		sort.Strings(results.failed)
+		return results, errkind.Errorf(errkind.ToolBuildError, "%s", strings.Join(failures, "\n"))
 	}
-	return nil
+	return results, nil
 }
 
 // buildToolsFromMaster builds and installs all jiri tools using the version
 // available in the local master branch of the tools repository. Notably, this
 // function does not perform any version control operation on the master
-// branch.
-func buildToolsFromMaster(jirix *jiri.X, projects Projects, tools Tools, outputDir string) error {
+// branch.  The tools that were built are placed into outputDir even if others
+// failed to build.
+func buildToolsFromMaster(jirix *jiri.X, projects Projects, tools Tools, outputDir string, verbose bool, opts ...BuildToolsOpt) (toolBuildResults, error) {
 	toolsToBuild := Tools{}
 	toolNames := []string{} // Used for logging purposes.
 	for _, tool := range tools {
@@ -1164,31 +2464,88 @@ func buildToolsFromMaster(jirix *jiri.X, projects Projects, tools Tools, outputD
 		toolNames = append(toolNames, tool.Name)
 	}
 
+	// Projects with a git operation in progress, which the update left
+	// unchanged, cannot be switched to their master branch.
+	masterProjects := Projects{}
+	for key, project := range projects {
+		if project.Protocol == "git" {
+			git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+			if operation, err := git.InProgressOperation(); err == nil && operation != "" {
+				continue
+			}
+		}
+		masterProjects[key] = project
+	}
+	var results toolBuildResults
 	updateFn := func() error {
-		return ApplyToLocalMaster(jirix, projects, func() error {
-			return BuildTools(jirix, projects, toolsToBuild, outputDir)
+		return ApplyToLocalMaster(jirix, masterProjects, func() error {
+			var err error
+			results, err = buildTools(jirix, projects, toolsToBuild, outputDir, opts...)
+			return err
 		})
 	}
 
-	// Always log the output of updateFn, irrespective of the value of the
-	// verbose flag.
-	return jirix.NewSeq().Verbose(true).
+	// Log the output of updateFn irrespective of the value of the verbose
+	// flag, unless only a summary was requested.
+	err := jirix.NewSeq().Verbose(verbose).
 		Call(updateFn, "build tools: %v", strings.Join(toolNames, " ")).
 		Done()
+	if verbose {
+		reportSkippedTools(jirix, results.skipped)
+	}
+	return results, err
 }
 
+// CleanupOpt is an option for CleanupProjects.
+type CleanupOpt interface {
+	cleanupOpt()
+}
+
+// NoBackupOpt causes CleanupProjects to discard the local changes and
+// branches of projects without backing them up first.
+type NoBackupOpt bool
+
+// BackupMaxAgeOpt causes CleanupProjects to delete the backups of the cleaned
+// projects that are older than the given duration before cleaning them.  Zero
+// means that backups are never deleted.
+type BackupMaxAgeOpt time.Duration
+
+func (NoBackupOpt) cleanupOpt()     {}
+func (BackupMaxAgeOpt) cleanupOpt() {}
+
 // CleanupProjects restores the given jiri projects back to their master
 // branches, resets to the specified revision if there is one, and gets rid of
 // all the local changes. If "cleanupBranches" is true, it will also delete all
-// the non-master branches.
-func CleanupProjects(jirix *jiri.X, projects Projects, cleanupBranches bool) (e error) {
+// the non-master branches.  Unless NoBackupOpt is set, the local changes and
+// the deleted branches are backed up first, with the same ID for all the
+// projects, so that they can be restored by RestoreBackup; see Backup.
+func CleanupProjects(jirix *jiri.X, projects Projects, cleanupBranches bool, opts ...CleanupOpt) (e error) {
+	backup, maxAge := true, time.Duration(0)
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case NoBackupOpt:
+			backup = !bool(typedOpt)
+		case BackupMaxAgeOpt:
+			maxAge = time.Duration(typedOpt)
+		}
+	}
 	wd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("Getwd() failed: %v", err)
 	}
 	defer collect.Error(func() error { return jirix.NewSeq().Chdir(wd).Done() }, &e)
-	for _, project := range projects {
-		if err := resetLocalProject(jirix, project, cleanupBranches); err != nil {
+	backupID := ""
+	if backup {
+		backupID = newBackupID()
+	}
+	for _, key := range sortedKeys(projects) {
+		project := projects[key]
+		if maxAge > 0 {
+			if err := pruneBackups(jirix, project, maxAge); err != nil {
+				return fmt.Errorf("pruning the backups of project %q failed: %v", project.Name, err)
+			}
+		}
+		if err := resetLocalProject(jirix, project, cleanupBranches, backupID); err != nil {
 			return err
 		}
 	}
@@ -1196,12 +2553,19 @@ func CleanupProjects(jirix *jiri.X, projects Projects, cleanupBranches bool) (e
 }
 
 // resetLocalProject checks out the master branch, cleans up untracked files
-// and uncommitted changes, and optionally deletes all the other branches.
-func resetLocalProject(jirix *jiri.X, project Project, cleanupBranches bool) error {
+// and uncommitted changes, and optionally deletes all the other branches.  If
+// backupID is not empty, the changes and branches are backed up with that ID
+// first, and the project is not cleaned if the backup fails.
+func resetLocalProject(jirix *jiri.X, project Project, cleanupBranches bool, backupID string) error {
 	git := gitutil.New(jirix.NewSeq())
 	if err := jirix.NewSeq().Chdir(project.Path).Done(); err != nil {
 		return err
 	}
+	if backupID != "" {
+		if err := backupProject(jirix, project, backupID, cleanupBranches); err != nil {
+			return fmt.Errorf("backing up project %q failed, not cleaning it: %v", project.Name, err)
+		}
+	}
 	// Check out master.
 	curBranchName, err := git.CurrentBranchName()
 	if err != nil {
@@ -1213,12 +2577,23 @@ func resetLocalProject(jirix *jiri.X, project Project, cleanupBranches bool) err
 		}
 	}
 	// Cleanup changes.
+	uncommitted, err := git.HasUncommittedChanges()
+	if err != nil {
+		return err
+	}
+	untracked, err := git.HasUntrackedFiles()
+	if err != nil {
+		return err
+	}
 	if err := git.RemoveUntrackedFiles(); err != nil {
 		return err
 	}
-	if err := resetProjectCurrentBranch(jirix, project); err != nil {
+	if err := resetProjectCurrentBranch(jirix, project, 0, false); err != nil {
 		return err
 	}
+	if uncommitted || untracked {
+		jirix.Audit("reset project", "discarded the uncommitted changes and untracked files of project %q in %s", project.Name, project.Path)
+	}
 	if !cleanupBranches {
 		return nil
 	}
@@ -1236,6 +2611,7 @@ func resetLocalProject(jirix *jiri.X, project Project, cleanupBranches bool) err
 		if err := git.DeleteBranch(branch, gitutil.ForceOpt(true)); err != nil {
 			return err
 		}
+		jirix.Audit("delete branch", "deleted branch %q of project %q in %s", branch, project.Name, project.Path)
 	}
 	return nil
 }
@@ -1254,20 +2630,40 @@ func isLocalProject(jirix *jiri.X, path string) (bool, error) {
 	return true, nil
 }
 
+// metadataError is returned when the metadata file of the local project in
+// the given directory is missing or cannot be parsed.
+type metadataError struct {
+	dir string
+	err error
+}
+
+func (e metadataError) Error() string {
+	return fmt.Sprintf("invalid metadata of the project in %q: %v; run \"jiri project repair %s\" to reconstruct it", e.dir, e.err, e.dir)
+}
+
 // ProjectAtPath returns a Project struct corresponding to the project at the
 // path in the filesystem.
 func ProjectAtPath(jirix *jiri.X, path string) (Project, error) {
 	metadataFile := filepath.Join(path, jiri.ProjectMetaDir, jiri.ProjectMetaFile)
 	project, err := ProjectFromFile(jirix, metadataFile)
 	if err != nil {
-		return Project{}, err
+		return Project{}, metadataError{path, err}
 	}
 	return *project, nil
 }
 
 // findLocalProjects scans the filesystem for all projects.  Note that project
-// directories can be nested recursively.
-func findLocalProjects(jirix *jiri.X, path string, projects Projects) error {
+// directories can be nested recursively.  Directories whose project metadata
+// is missing or invalid are not treated as projects; the errors are recorded
+// in invalid, keyed by directory.
+func findLocalProjects(jirix *jiri.X, path string, projects Projects, ignore map[string]bool, invalid map[string]error) error {
+	// The projects of a nested jiri root belong to that root.
+	if path != jirix.Root {
+		if _, err := jirix.NewSeq().Stat(filepath.Join(path, jiri.RootMetaDir)); err == nil {
+			fmt.Fprintf(jirix.Stderr(), "WARNING: not scanning %v for projects, it contains another jiri root\n", path)
+			return nil
+		}
+	}
 	isLocal, err := isLocalProject(jirix, path)
 	if err != nil {
 		return err
@@ -1275,15 +2671,14 @@ func findLocalProjects(jirix *jiri.X, path string, projects Projects) error {
 	if isLocal {
 		project, err := ProjectAtPath(jirix, path)
 		if err != nil {
-			return err
-		}
-		if path != project.Path {
+			invalid[path] = err
+		} else if path != project.Path {
 			return fmt.Errorf("project %v has path %v but was found in %v", project.Name, project.Path, path)
-		}
-		if p, ok := projects[project.Key()]; ok {
+		} else if p, ok := projects[project.Key()]; ok {
 			return fmt.Errorf("name conflict: both %v and %v contain project with key %v", p.Path, project.Path, project.Key())
+		} else {
+			projects[project.Key()] = project
 		}
-		projects[project.Key()] = project
 	}
 
 	// Recurse into all the sub directories.
@@ -1292,8 +2687,9 @@ func findLocalProjects(jirix *jiri.X, path string, projects Projects) error {
 		return err
 	}
 	for _, fileInfo := range fileInfos {
-		if fileInfo.IsDir() && !strings.HasPrefix(fileInfo.Name(), ".") {
-			if err := findLocalProjects(jirix, filepath.Join(path, fileInfo.Name()), projects); err != nil {
+		subdir := filepath.Join(path, fileInfo.Name())
+		if fileInfo.IsDir() && !strings.HasPrefix(fileInfo.Name(), ".") && !ignore[subdir] {
+			if err := findLocalProjects(jirix, subdir, projects, ignore, invalid); err != nil {
 				return err
 			}
 		}
@@ -1301,31 +2697,49 @@ func findLocalProjects(jirix *jiri.X, path string, projects Projects) error {
 	return nil
 }
 
-// InstallTools installs the tools from the given directory into
-// $JIRI_ROOT/.jiri_root/bin.
-func InstallTools(jirix *jiri.X, dir string) error {
-	jirix.TimerPush("install tools")
-	defer jirix.TimerPop()
-	fis, err := ioutil.ReadDir(dir)
-	if err != nil {
-		return fmt.Errorf("ReadDir(%v) failed: %v", dir, err)
+// reportInvalidMetadata prints a warning that lists the directories that were
+// not treated as projects by findLocalProjects because their metadata is
+// missing or invalid.
+func reportInvalidMetadata(jirix *jiri.X, invalid map[string]error) {
+	if len(invalid) == 0 {
+		return
 	}
-	binDir := jirix.BinDir()
-	if err := jirix.NewSeq().MkdirAll(binDir, 0755).Done(); err != nil {
-		return fmt.Errorf("MkdirAll(%v) failed: %v", binDir, err)
+	var dirs []string
+	for dir := range invalid {
+		dirs = append(dirs, dir)
 	}
-	s := jirix.NewSeq()
-	for _, fi := range fis {
-		installFn := func() error {
-			src := filepath.Join(dir, fi.Name())
-			dst := filepath.Join(binDir, fi.Name())
-			return jirix.NewSeq().Rename(src, dst).Done()
+	sort.Strings(dirs)
+	fmt.Fprintf(jirix.Stderr(), "WARNING: skipped %d local projects with missing or invalid metadata:\n", len(dirs))
+	for _, dir := range dirs {
+		fmt.Fprintf(jirix.Stderr(), "  %v\n", invalid[dir].(metadataError).err)
+	}
+	fmt.Fprintf(jirix.Stderr(), "Run \"jiri project repair\" to reconstruct their metadata from the manifest.\n")
+}
+
+// scanIgnoreDirs returns the set of directories that findLocalProjects should
+// not descend into, as listed in the $JIRI_ROOT/.jiri_root/scan-ignore file.
+// The file contains one directory per line, either absolute or relative to
+// $JIRI_ROOT; empty lines and lines starting with "#" are ignored.
+func scanIgnoreDirs(jirix *jiri.X) (map[string]bool, error) {
+	ignore := map[string]bool{}
+	data, err := jirix.NewSeq().ReadFile(jirix.ScanIgnoreFile())
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return ignore, nil
+		}
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-		if err := s.Verbose(true).Call(installFn, "install tool %q", fi.Name()).Done(); err != nil {
-			return fmt.Errorf("error installing tool %q: %v", fi.Name(), err)
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(jirix.Root, line)
 		}
+		ignore[filepath.Clean(line)] = true
 	}
-	return nil
+	return ignore, nil
 }
 
 // updateJiriScript copies the scripts/jiri script from the jiri repo to
@@ -1348,8 +2762,12 @@ func updateJiriScript(jirix *jiri.X, jiriProject Project) error {
 			if _, err := s.Copy(jiriScriptOut, newJiriScript); err != nil {
 				return err
 			}
-			if err := s.Chmod(jiriScriptOutPath, 0750).Done(); err != nil {
-				return err
+			// File modes other than the read-only attribute do not exist
+			// on Windows.
+			if runtime.GOOS != "windows" {
+				if err := s.Chmod(jiriScriptOutPath, 0750).Done(); err != nil {
+					return err
+				}
 			}
 
 			return nil
@@ -1374,11 +2792,16 @@ func TransitionBinDir(jirix *jiri.X) error {
 	oldDir, newDir := filepath.Join(jirix.Root, "devtools", "bin"), jirix.BinDir()
 	switch info, err := s.Lstat(oldDir); {
 	case runutil.IsNotExist(err):
+		// Where symlinks are not supported, the old dir may be a pointer
+		// file instead.
+		if link, err := jiri.ReadPointer(oldDir); err == nil && filepath.Clean(link) == newDir {
+			return nil
+		}
 		// Drop down to create the symlink below.
 	case err != nil:
 		return fmt.Errorf("Failed to stat old bin dir: %v", err)
 	case info.Mode()&os.ModeSymlink != 0:
-		link, err := s.Readlink(oldDir)
+		link, err := jiri.ReadPointer(oldDir)
 		if err != nil {
 			return fmt.Errorf("Failed to read link from old bin dir: %v", err)
 		}
@@ -1403,56 +2826,404 @@ func TransitionBinDir(jirix *jiri.X) error {
 			return fmt.Errorf("Backup bin dir %v already exists", backupDir)
 		}
 	}
-	// Create the symlink.
-	if err := s.MkdirAll(filepath.Dir(oldDir), 0755).Symlink(newDir, oldDir).Done(); err != nil {
+	// Create the symlink, or a pointer file where symlinks are not supported.
+	if err := s.MkdirAll(filepath.Dir(oldDir), 0755).Done(); err != nil {
+		return fmt.Errorf("Failed to create the parent of the old bin dir %v: %v", oldDir, err)
+	}
+	if err := jirix.WritePointer(oldDir, newDir); err != nil {
 		return fmt.Errorf("Failed to symlink to new bin dir %v from %v: %v", newDir, oldDir, err)
 	}
 	return nil
 }
 
-// fetchProject fetches from the project remote.
-func fetchProject(jirix *jiri.X, project Project) error {
+// fetchProject fetches from the project remote.  The fetch fails if it takes
+// longer than the given timeout, unless it is zero.
+func fetchProject(jirix *jiri.X, project Project, gitTimeout time.Duration) error {
 	switch project.Protocol {
 	case "git":
-		if project.Remote == "" {
-			return fmt.Errorf("project %q does not have a remote", project.Name)
-		}
-		if err := gitutil.New(jirix.NewSeq()).SetRemoteUrl("origin", project.Remote); err != nil {
+		if err := configureRemote(jirix, project); err != nil {
 			return err
 		}
-		return gitutil.New(jirix.NewSeq()).Fetch("origin")
+		if err := gitutil.New(jirix.NewSeq(), gitutil.TimeoutOpt(gitTimeout)).Fetch("origin"); err != nil {
+			if gitutil.IsTimeout(err) {
+				return timeoutError(err, gitTimeout, "fetching project %q from %q", project.Name, project.Remote)
+			}
+			if gitutil.IsNoSuchRemote(err) {
+				return fmt.Errorf("remote %q of project %q is not a git repository: %v", project.Remote, project.Name, err)
+			}
+			return authenticationError(err, project.Remote, "fetching project %q", project.Name)
+		}
+		return nil
 	default:
 		return UnsupportedProtocolErr(project.Protocol)
 	}
 }
 
+// configureRemote sets the origin remote of the given git project, which is
+// the current directory, and its gerrit remote and clone filter, if any.
+func configureRemote(jirix *jiri.X, project Project) error {
+	if project.Remote == "" {
+		return fmt.Errorf("project %q does not have a remote", project.Name)
+	}
+	if err := gitutil.New(jirix.NewSeq()).SetRemoteUrl("origin", project.Remote); err != nil {
+		return err
+	}
+	if err := setGerritRemote(jirix, project); err != nil {
+		return err
+	}
+	return setCloneFilter(jirix, project)
+}
+
+// fullRevisionRE matches the full SHA-1 revisions of git commits.
+var fullRevisionRE = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// pinnedRevisionExists returns whether the given git project, which is the
+// current directory, is pinned to the full SHA-1 of a commit that already
+// exists locally, so that it can be reset to the revision without fetching.
+// Abbreviated revisions and tag names may resolve to other commits once
+// fetched, so they are always fetched.
+func pinnedRevisionExists(jirix *jiri.X, project Project) bool {
+	return fullRevisionRE.MatchString(project.Revision) && gitutil.New(jirix.NewSeq()).CommitExists(project.Revision)
+}
+
+// timeoutError returns err, or if err reports that a git command timed out, an
+// error of kind errkind.NetworkError explaining which operation, described by
+// format and args, timed out.
+func timeoutError(err error, gitTimeout time.Duration, format string, args ...interface{}) error {
+	if !gitutil.IsTimeout(err) {
+		return err
+	}
+	return errkind.Errorf(errkind.NetworkError, "timed out after %v %s", gitTimeout, fmt.Sprintf(format, args...))
+}
+
+// authenticationError returns err, or if err reports that git failed because
+// the given remote requires credentials that are missing or rejected, an error
+// that names the host of the remote and explains how to configure credentials.
+// The operation that failed is described by format and args.
+func authenticationError(err error, remote, format string, args ...interface{}) error {
+	if !gitutil.IsAuthenticationFailure(err) {
+		return err
+	}
+	return fmt.Errorf("authentication to %s failed %s; configure credentials for %s in ~/.gitcookies, or set a git credential helper with the -credential-helper flag or \"jiri config set credential-helper <helper>\":\n%v", remoteHost(remote), fmt.Sprintf(format, args...), remoteHost(remote), err)
+}
+
+// remoteHost returns the host of the given git remote, which is either a URL
+// or of the form [user@]host:path, or the remote itself if it has no host.
+func remoteHost(remote string) string {
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		return u.Host
+	}
+	if i := strings.Index(remote, ":"); i > 0 && !strings.Contains(remote[:i], "/") {
+		host := remote[:i]
+		return host[strings.Index(host, "@")+1:]
+	}
+	return remote
+}
+
+// setGerritRemote configures the gerritremote of the project, if any, to
+// point at the project on its gerrit host.
+func setGerritRemote(jirix *jiri.X, project Project) error {
+	if project.GerritRemote == "" || project.GerritRemote == "origin" {
+		return nil
+	}
+	pushUrl, err := project.GerritPushUrl(project.GerritHost)
+	if err != nil {
+		return err
+	}
+	git := gitutil.New(jirix.NewSeq())
+	if _, err := git.RemoteUrl(project.GerritRemote); err != nil {
+		return git.AddRemote(project.GerritRemote, pushUrl.String())
+	}
+	return git.SetRemoteUrl(project.GerritRemote, pushUrl.String())
+}
+
+// setCloneFilter configures the origin remote of a partially cloned project
+// as a promisor remote with the clonefilter of the project, so that fetches
+// keep omitting the filtered objects and fetch them on demand.  "git clone"
+// configures the remote the same way, but the configuration is repeated in
+// case the origin remote was replaced.
+func setCloneFilter(jirix *jiri.X, project Project) error {
+	if project.CloneFilter == "" {
+		return nil
+	}
+	git := gitutil.New(jirix.NewSeq())
+	if err := git.SetConfig("remote.origin.promisor", "true"); err != nil {
+		return err
+	}
+	return git.SetConfig("remote.origin.partialclonefilter", project.CloneFilter)
+}
+
+// applySparseCheckout restricts the working tree of the project in the given
+// directory to the directories listed by its sparsecheckout attribute, or
+// checks out the whole tree again if the attribute is not set but the project
+// is a sparse checkout, so that switching between sparse and full checkouts
+// converges to the state requested by the manifest.  The jiri metadata
+// directory, which git would otherwise remove as an ignored directory outside
+// the sparse checkout, is always kept.
+func applySparseCheckout(jirix *jiri.X, project Project, dir string) error {
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(dir))
+	dirs := project.sparseCheckoutDirs()
+	if len(dirs) == 0 {
+		sparse, err := git.SparseCheckoutEnabled()
+		if err != nil || !sparse {
+			return err
+		}
+	} else {
+		dirs = append(dirs, jiri.ProjectMetaDir)
+	}
+	if err := git.SparseCheckout(dirs); err != nil {
+		return fmt.Errorf("failed to apply the sparse checkout of project %q: %v", project.Name, err)
+	}
+	return nil
+}
+
+// usesLFS returns whether the project in the given directory stores files
+// with Git LFS, i.e. whether its lfs attribute is set or, if detect is set,
+// whether its .gitattributes file configures the LFS filter.
+func usesLFS(jirix *jiri.X, project Project, dir string, detect bool) (bool, error) {
+	if project.LFS || !detect {
+		return project.LFS, nil
+	}
+	data, err := jirix.NewSeq().ReadFile(filepath.Join(dir, ".gitattributes"))
+	if err != nil {
+		if runutil.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Contains(data, []byte("filter=lfs")), nil
+}
+
+// applyLFS installs the Git LFS filters in the repository of the project in
+// the given directory and replaces the LFS pointer files of its working tree
+// with their contents, if the project uses Git LFS.  Fetching the contents
+// fails if it takes longer than the given timeout, unless it is zero.
+func applyLFS(jirix *jiri.X, project Project, dir string, detect bool, gitTimeout time.Duration) error {
+	lfs, err := usesLFS(jirix, project, dir, detect)
+	if err != nil || !lfs {
+		return err
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(dir), gitutil.TimeoutOpt(gitTimeout))
+	if err := git.LFSInstall(); err != nil {
+		if gitutil.IsLFSNotInstalled(err) {
+			return fmt.Errorf("project %q uses Git LFS, but the git-lfs binary was not found in PATH; install git-lfs (https://git-lfs.github.com) and run \"jiri update\" again", project.Name)
+		}
+		return fmt.Errorf("failed to install Git LFS in project %q: %v", project.Name, err)
+	}
+	if err := git.LFSPull(); err != nil {
+		if gitutil.IsTimeout(err) {
+			return timeoutError(err, gitTimeout, "fetching the LFS files of project %q", project.Name)
+		}
+		return fmt.Errorf("failed to fetch the LFS files of project %q from %q: %v", project.Name, project.Remote, err)
+	}
+	return nil
+}
+
+// partialCloneError returns the given error of a git command that accesses
+// the objects of the project, explaining that the objects of a partial clone
+// may have to be fetched from the remote.
+func partialCloneError(project Project, err error) error {
+	if project.CloneFilter == "" {
+		return err
+	}
+	return errkind.Errorf(errkind.Of(err), "%v\nproject %q is a partial clone (clonefilter %q) whose missing objects are fetched from %q on demand; check that the remote is reachable", err, project.Name, project.CloneFilter, project.Remote)
+}
+
+// checkRemoteChange checks whether the origin remote of the local checkout of
+// the project differs from the project remote.  If so, it fetches from the
+// new remote and checks that the revision the project is to be advanced to
+// shares history with the local master branch.  If it does not, the project
+// appears to have been replaced: checkRemoteChange returns true if force is
+// set, and an error otherwise.
+func checkRemoteChange(jirix *jiri.X, project Project, force bool, gitTimeout time.Duration) (_ bool, e error) {
+	if err := project.fillDefaults(); err != nil {
+		return false, err
+	}
+	if project.Protocol != "git" {
+		return false, nil
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+	oldRemote, err := git.RemoteUrl("origin")
+	if err != nil || oldRemote == project.Remote {
+		return false, nil
+	}
+	fmt.Fprintf(jirix.Stdout(), "NOTE: remote of project %q changed from %q to %q\n", project.Name, oldRemote, project.Remote)
+	// Restore the old remote if the project cannot be updated, so that the
+	// change is detected again by the next update.
+	restore := true
+	defer func() {
+		if restore {
+			collect.Error(func() error { return git.SetRemoteUrl("origin", oldRemote) }, &e)
+		}
+	}()
+	pushd := jirix.NewSeq().Pushd(project.Path)
+	defer collect.Error(pushd.Done, &e)
+	if err := fetchProject(jirix, project, gitTimeout); err != nil {
+		return false, err
+	}
+	target := project.Revision
+	if target == "HEAD" {
+		target = "origin/" + project.RemoteBranch
+	}
+	if _, err := git.MergeBase(target, "master"); err == nil {
+		restore = false
+		return false, nil
+	}
+	if force {
+		return true, nil
+	}
+	return false, errkind.Errorf(errkind.GitConflictError, "project %q appears to have been replaced: revision %q of the new remote %q shares no history with the local master branch.  Run \"jiri update -force-remote-change\" to clone the project again, keeping the old checkout in %q", project.Name, target, project.Remote, project.Path+".old")
+}
+
 // resetProjectCurrentBranch resets the current branch to the revision and
-// branch specified on the project.
-func resetProjectCurrentBranch(jirix *jiri.X, project Project) error {
+// branch specified on the project.  The reset fails if it takes longer than
+// the given timeout, unless it is zero.
+func resetProjectCurrentBranch(jirix *jiri.X, project Project, gitTimeout time.Duration, noVerify bool) error {
 	if err := project.fillDefaults(); err != nil {
 		return err
 	}
 	switch project.Protocol {
 	case "git":
-		// Having a specific revision trumps everything else.
-		if project.Revision != "HEAD" {
-			return gitutil.New(jirix.NewSeq()).Reset(project.Revision)
+		target, fallback, err := resolveResetTarget(jirix, project, "", gitTimeout)
+		if err != nil {
+			return err
+		}
+		if fallback {
+			warnFallbackBranch(jirix, project)
+		}
+		if err := checkSignature(jirix, project, "", target, noVerify); err != nil {
+			return err
+		}
+		if err := gitutil.New(jirix.NewSeq(), gitutil.TimeoutOpt(gitTimeout)).Reset(target); err != nil {
+			if gitutil.IsTimeout(err) {
+				return timeoutError(err, gitTimeout, "resetting project %q to %q", project.Name, target)
+			}
+			if gitutil.IsUnknownRevision(err) {
+				return fmt.Errorf("revision %q of project %q does not exist in remote %q: %v", target, project.Name, project.Remote, err)
+			}
+			return partialCloneError(project, err)
 		}
-		// If no revision, reset to the configured remote branch.
-		return gitutil.New(jirix.NewSeq()).Reset("origin/" + project.RemoteBranch)
+		return nil
 	default:
 		return UnsupportedProtocolErr(project.Protocol)
 	}
 }
 
+// resetTarget returns the revision the local master branch of the given git
+// project is reset to.
+func resetTarget(project Project) string {
+	// Having a specific revision trumps everything else.
+	if project.Revision != "" && project.Revision != "HEAD" {
+		return project.Revision
+	}
+	// If no revision, reset to the configured remote branch.
+	if project.RemoteBranch == "" {
+		return "origin/master"
+	}
+	return "origin/" + project.RemoteBranch
+}
+
+// maxListedBranches is the maximum number of the branches of a remote that a
+// missingBranchError lists.
+const maxListedBranches = 10
+
+// missingBranchError is the error of a project whose remote branch, and
+// fallback branch if any, do not exist in its remote.
+type missingBranchError struct {
+	project Project
+	// branches holds the branches of the remote.
+	branches []string
+}
+
+func (e *missingBranchError) Error() string {
+	branch := e.project.RemoteBranch
+	if branch == "" {
+		branch = "master"
+	}
+	msg := fmt.Sprintf("remote branch %q of project %q does not exist in %q", branch, e.project.Name, e.project.Remote)
+	if e.project.FallbackBranch != "" {
+		msg += fmt.Sprintf(", and neither does its fallback branch %q", e.project.FallbackBranch)
+	}
+	switch n := len(e.branches); {
+	case n == 0:
+		msg += "; the remote has no branches"
+	case n > maxListedBranches:
+		msg += fmt.Sprintf("; the branches of the remote are: %s and %d more", strings.Join(e.branches[:maxListedBranches], ", "), n-maxListedBranches)
+	default:
+		msg += fmt.Sprintf("; the branches of the remote are: %s", strings.Join(e.branches, ", "))
+	}
+	return msg + ".  Update the remotebranch attribute of the project in the manifest, or set its fallbackbranch attribute to the branch to track while the remote branch is missing"
+}
+
+// ErrorKind returns errkind.ManifestError, since the manifest refers to a
+// branch that does not exist.
+func (e *missingBranchError) ErrorKind() errkind.Kind {
+	return errkind.ManifestError
+}
+
+// isMissingBranch returns whether err is a *missingBranchError, possibly
+// wrapped by runutil.Sequence.
+func isMissingBranch(err error) bool {
+	_, ok := runutil.GetOriginalError(err).(*missingBranchError)
+	return ok
+}
+
+// resolveResetTarget returns the revision that the local master branch of
+// the given git project, in the given directory, is reset to once the
+// project has been fetched, as resetTarget does.  If the project tracks a
+// remote branch that does not exist, its fallback branch is used instead if
+// it exists, which the returned bool reports, and a *missingBranchError is
+// returned otherwise.  Listing the branches of the remote fails if it takes
+// longer than the given timeout, unless it is zero.
+func resolveResetTarget(jirix *jiri.X, project Project, dir string, gitTimeout time.Duration) (string, bool, error) {
+	target := resetTarget(project)
+	if project.Revision != "" && project.Revision != "HEAD" {
+		return target, false, nil
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(dir), gitutil.TimeoutOpt(gitTimeout))
+	if _, err := git.CurrentRevisionOfBranch("refs/remotes/" + target); err == nil {
+		return target, false, nil
+	}
+	branches, err := git.RemoteBranches("origin")
+	if err != nil {
+		// Let resetting the project report why the target is missing.
+		return target, false, nil
+	}
+	exists := map[string]bool{}
+	for _, branch := range branches {
+		exists[branch] = true
+	}
+	if exists[strings.TrimPrefix(target, "origin/")] {
+		return target, false, nil
+	}
+	if project.FallbackBranch != "" && exists[project.FallbackBranch] {
+		return "origin/" + project.FallbackBranch, true, nil
+	}
+	return "", false, &missingBranchError{project: project, branches: branches}
+}
+
+// warnFallbackBranch warns that the given project tracks its fallback branch,
+// since its remote branch does not exist.
+func warnFallbackBranch(jirix *jiri.X, project Project) {
+	fmt.Fprintf(jirix.Stderr(), "WARNING: remote branch %q of project %q does not exist in %q, tracking its fallback branch %q; update the remotebranch attribute of the project in the manifest\n", project.RemoteBranch, project.Name, project.Remote, project.FallbackBranch)
+}
+
 // syncProjectMaster fetches from the project remote and resets the local master
-// branch to the revision and branch specified on the project.
-func syncProjectMaster(jirix *jiri.X, project Project) error {
+// branch to the revision and branch specified on the project.  The fetch is
+// skipped if the project is pinned to a commit that already exists locally.
+func syncProjectMaster(jirix *jiri.X, project Project, gitTimeout time.Duration, noVerify bool) error {
 	return ApplyToLocalMaster(jirix, Projects{project.Key(): project}, func() error {
-		if err := fetchProject(jirix, project); err != nil {
+		if err := project.fillDefaults(); err != nil {
 			return err
 		}
-		return resetProjectCurrentBranch(jirix, project)
+		if project.Protocol == "git" && pinnedRevisionExists(jirix, project) {
+			if err := configureRemote(jirix, project); err != nil {
+				return err
+			}
+		} else if err := fetchProject(jirix, project, gitTimeout); err != nil {
+			return err
+		}
+		return resetProjectCurrentBranch(jirix, project, gitTimeout, noVerify)
 	})
 }
 
@@ -1466,10 +3237,13 @@ func syncProjectMaster(jirix *jiri.X, project Project) error {
 // directories, and added to localProjects.
 func newManifestLoader(localProjects Projects, update bool) *loader {
 	return &loader{
-		Projects:      make(Projects),
-		Tools:         make(Tools),
-		localProjects: localProjects,
-		update:        update,
+		Projects:       make(Projects),
+		Tools:          make(Tools),
+		localProjects:  localProjects,
+		update:         update,
+		removeTmpDir:   func() error { return nil },
+		projectSources: map[ProjectKey]manifestSource{},
+		toolSources:    map[string]manifestSource{},
 	}
 }
 
@@ -1480,6 +3254,43 @@ type loader struct {
 	localProjects Projects
 	update        bool
 	cycleStack    []cycleInfo
+	// removeTmpDir removes TmpDir, and is also run if jiri is interrupted.
+	removeTmpDir func() error
+	// PostUpdates holds the post-update commands in the order they were
+	// declared, with duplicates removed.
+	PostUpdates []PostUpdate
+	// groups holds the enabled project groups, and importGroups the groups of
+	// the remote import that is being loaded.
+	groups       map[string]bool
+	importGroups string
+	// skipUnresolvable causes the remote imports whose manifest projects do
+	// not exist locally to be recorded in unresolvable, rather than fail the
+	// load.
+	skipUnresolvable bool
+	unresolvable     []Import
+	// gitTimeout is the timeout of the git commands that fetch, clone or
+	// reset the manifest projects, or zero if there is none.
+	gitTimeout time.Duration
+	// noVerify disables the verification of the signatures of the revisions
+	// of the manifest projects.
+	noVerify bool
+	// locked holds the revisions that the remote imports are loaded at,
+	// keyed by importLockKey, or is nil if they are loaded at the revisions
+	// given by the manifests.  resolved records the revisions that the remote
+	// imports were loaded at, if update is true.
+	locked   map[string]string
+	resolved []ImportLock
+	// lint causes the problems found in the manifests to be recorded rather
+	// than fail the load, and the manifests of remote imports to be read
+	// from the working trees of their local projects, without running git.
+	lint *manifestLint
+	// projectSources and toolSources hold the sources that the loaded
+	// projects and tools were first found in.
+	projectSources map[ProjectKey]manifestSource
+	toolSources    map[string]manifestSource
+	// excluded holds the stubs of the excluded projects, whose matching
+	// projects are dropped whenever they are found.
+	excluded []Project
 }
 
 type cycleInfo struct {
@@ -1518,11 +3329,20 @@ type cycleInfo struct {
 func (ld *loader) loadNoCycles(jirix *jiri.X, root, file, cycleKey string) error {
 	info := cycleInfo{file, cycleKey}
 	for _, c := range ld.cycleStack {
+		var err error
 		switch {
 		case file == c.file:
-			return fmt.Errorf("import cycle detected in local manifest files: %q", append(ld.cycleStack, info))
+			err = errkind.Errorf(errkind.ManifestError, "import cycle detected in local manifest files: %q", append(ld.cycleStack, info))
 		case cycleKey == c.key && cycleKey != "":
-			return fmt.Errorf("import cycle detected in remote manifest imports: %q", append(ld.cycleStack, info))
+			err = errkind.Errorf(errkind.ManifestError, "import cycle detected in remote manifest imports: %q", append(ld.cycleStack, info))
+		}
+		if err != nil {
+			if ld.lint != nil {
+				// Report the cycle in the file that closes it.
+				ld.lint.report(jirix, ld.cycleStack[len(ld.cycleStack)-1].file, "%v", err)
+				return nil
+			}
+			return err
 		}
 	}
 	ld.cycleStack = append(ld.cycleStack, info)
@@ -1549,19 +3369,51 @@ func (ld *loader) Load(jirix *jiri.X, root, file, cycleKey string) error {
 }
 
 func (ld *loader) load(jirix *jiri.X, root, file string) error {
-	m, err := ManifestFromFile(jirix, file)
-	if err != nil {
+	var m *Manifest
+	var err error
+	if ld.lint != nil {
+		var unknown []string
+		if m, unknown, err = readManifest(jirix, file); err != nil {
+			ld.lint.report(jirix, file, "%v", err)
+			return nil
+		}
+		for _, field := range unknown {
+			ld.lint.report(jirix, file, "unknown %s", field)
+		}
+	} else if m, err = ManifestFromFile(jirix, file); err != nil {
 		return err
 	}
+	if ld.groups == nil {
+		if ld.groups, err = enabledGroupSet(jirix); err != nil {
+			return err
+		}
+	}
 	// Process remote imports.
 	for _, remote := range m.Imports {
+		if remote.Groups == "" {
+			remote.Groups = ld.importGroups
+		}
+		if !ld.enabled(remote.Groups) {
+			continue
+		}
 		nextRoot := filepath.Join(root, remote.Root)
 		remote.Name = filepath.Join(nextRoot, remote.Name)
 		key := remote.ProjectKey()
+		if ld.lint != nil {
+			ld.lint.checkRemote(jirix, file, remote.Remote, fmt.Sprintf("import of %s", remote.Manifest))
+		}
 		p, ok := ld.localProjects[key]
 		if !ok {
+			if ld.lint != nil {
+				ld.lint.report(jirix, file, "unreachable import of %s from %s: project %q not found locally, run \"jiri update\" to fetch it", remote.Manifest, remote.Remote, key)
+				continue
+			}
+			if ld.skipUnresolvable {
+				ld.unresolvable = append(ld.unresolvable, remote)
+				continue
+			}
 			if !ld.update {
-				return fmt.Errorf("can't resolve remote import: project %q not found locally", key)
+				return errkind.Errorf(errkind.ManifestError, "can't resolve remote import: project %q not found locally", key)
 			}
 			// The remote manifest project doesn't exist locally.  Clone it into a
 			// temp directory, and add it to ld.localProjects.
@@ -1569,6 +3421,8 @@ func (ld *loader) load(jirix *jiri.X, root, file string) error {
 				if ld.TmpDir, err = jirix.NewSeq().TempDir("", "jiri-load"); err != nil {
 					return fmt.Errorf("TempDir() failed: %v", err)
 				}
+				tmpDir := ld.TmpDir
+				ld.removeTmpDir = jirix.AddCleanup(func() error { return jirix.NewSeq().RemoveAll(tmpDir).Done() })
 			}
 			path := filepath.Join(ld.TmpDir, remote.projectKeyFileName())
 			if p, err = remote.toProject(path); err != nil {
@@ -1577,56 +3431,183 @@ func (ld *loader) load(jirix *jiri.X, root, file string) error {
 			if err := jirix.NewSeq().MkdirAll(path, 0755).Done(); err != nil {
 				return err
 			}
-			if err := gitutil.New(jirix.NewSeq()).Clone(p.Remote, path); err != nil {
-				return err
+			if err := gitutil.New(jirix.NewSeq(), gitutil.TimeoutOpt(ld.gitTimeout)).Clone(p.Remote, path); err != nil {
+				err = authenticationError(err, p.Remote, "cloning project %q", p.Name)
+				return timeoutError(err, ld.gitTimeout, "cloning project %q from %q", p.Name, p.Remote)
 			}
 			ld.localProjects[key] = p
 		}
-		// Reset the project to its specified branch and load the next file.  Note
-		// that we call load() recursively, so multiple files may be loaded by
-		// resetAndLoad.
-		p.Revision = "HEAD"
+		// Reset the project to its specified revision or branch, or to its
+		// locked revision, and load the next file.  Note that we call load()
+		// recursively, so multiple files may be loaded by resetAndLoad.
+		p.Revision = remote.Revision
 		p.RemoteBranch = remote.RemoteBranch
+		lockKey := importLockKey(remote.Name, remote.Remote, remote.Manifest)
+		revision := ""
+		if ld.locked != nil && ld.lint == nil {
+			var ok bool
+			if revision, ok = ld.locked[lockKey]; !ok {
+				return errkind.Errorf(errkind.ManifestError, "the import of %s from %s is not in the manifest lock; run \"jiri update -update-lock\" to update it", remote.Manifest, remote.Remote)
+			}
+		}
 		nextFile := filepath.Join(p.Path, remote.Manifest)
-		if err := ld.resetAndLoad(jirix, nextRoot, nextFile, remote.cycleKey(), p); err != nil {
+		outerGroups := ld.importGroups
+		ld.importGroups = remote.Groups
+		var err error
+		if ld.lint != nil {
+			err = ld.lintLoad(jirix, file, nextRoot, nextFile, remote.cycleKey())
+		} else {
+			err = ld.resetAndLoad(jirix, nextRoot, nextFile, remote.cycleKey(), p, revision)
+		}
+		ld.importGroups = outerGroups
+		if err != nil {
 			return err
 		}
+		if ld.update && ld.lint == nil {
+			// The master branch of the project is left at the revision
+			// that the import was loaded at.
+			loaded, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(p.Path)).CurrentRevisionOfBranch("master")
+			if err != nil {
+				return err
+			}
+			ld.resolved = append(ld.resolved, ImportLock{
+				Name:     remote.Name,
+				Remote:   remote.Remote,
+				Manifest: remote.Manifest,
+				Revision: loaded,
+			})
+		}
 	}
 	// Process local imports.
 	for _, local := range m.LocalImports {
 		// TODO(toddw): Add our invariant check that the file is in the same
 		// repository as the current remote import repository.
 		nextFile := filepath.Join(filepath.Dir(file), local.File)
+		if ld.lint != nil {
+			if err := ld.lintLoad(jirix, file, root, nextFile, ""); err != nil {
+				return err
+			}
+			continue
+		}
 		if err := ld.Load(jirix, root, nextFile, ""); err != nil {
 			return err
 		}
 	}
 	// Collect projects.
 	for _, project := range m.Projects {
+		// Excluded project stubs apply regardless of groups, since their
+		// other attributes are ignored.  Like all project names, the name of
+		// a stub is prefixed with the root of the import, so a stub excludes
+		// a project of a rooted import only if it names it as
+		// filepath.Join(root, name).
+		if project.Exclude {
+			project.Name = filepath.Join(root, project.Name)
+			ld.exclude(project)
+			continue
+		}
+		// Skip projects that are not in an enabled group.
+		if project.Groups == "" {
+			project.Groups = ld.importGroups
+		}
+		if !ld.enabled(project.Groups) {
+			continue
+		}
+		if ld.lint != nil {
+			ld.lint.checkProject(jirix, file, root, project)
+		}
 		// Make paths absolute by prepending JIRI_ROOT/<root>.
 		project.absolutizePaths(filepath.Join(jirix.Root, root))
 		// Prepend the root to the project name.  This will be a noop if the import is not rooted.
 		project.Name = filepath.Join(root, project.Name)
+		if ld.isExcluded(project) {
+			continue
+		}
 		key := project.Key()
-		if dup, ok := ld.Projects[key]; ok && dup != project {
-			// TODO(toddw): Tell the user the other conflicting file.
-			return fmt.Errorf("duplicate project %q found in %v", key, shortFileName(jirix.Root, file))
+		dup, ok := ld.Projects[key]
+		if ok && dup != project {
+			if ld.lint != nil {
+				ld.lint.report(jirix, file, "duplicate project %q, also in %s", key, ld.projectSources[key].File)
+				continue
+			}
+			return duplicateError("project", string(key), dup, project, ld.projectSources[key], ld.source(jirix, file))
+		}
+		if !ok {
+			ld.projectSources[key] = ld.source(jirix, file)
 		}
 		ld.Projects[key] = project
 	}
 	// Collect tools.
 	for _, tool := range m.Tools {
 		name := tool.Name
-		if dup, ok := ld.Tools[name]; ok && dup != tool {
-			// TODO(toddw): Tell the user the other conflicting file.
-			return fmt.Errorf("duplicate tool %q found in %v", name, shortFileName(jirix.Root, file))
+		dup, ok := ld.Tools[name]
+		if ok && dup != tool {
+			if ld.lint != nil {
+				ld.lint.report(jirix, file, "duplicate tool %q, also in %s", name, ld.toolSources[name].File)
+				continue
+			}
+			return duplicateError("tool", name, dup, tool, ld.toolSources[name], ld.source(jirix, file))
+		}
+		if !ok {
+			ld.toolSources[name] = ld.source(jirix, file)
 		}
 		ld.Tools[name] = tool
 	}
+	// Collect post-update commands.  A command that is declared again, e.g.
+	// by a manifest that is imported twice, is only run once.
+	for _, postUpdate := range m.PostUpdates {
+		dup := false
+		for _, pu := range ld.PostUpdates {
+			dup = dup || pu == postUpdate
+		}
+		if !dup {
+			ld.PostUpdates = append(ld.PostUpdates, postUpdate)
+		}
+	}
 	return nil
 }
 
-func (ld *loader) resetAndLoad(jirix *jiri.X, root, file, cycleKey string, project Project) (e error) {
+// exclude records the given excluded project stub, and drops the projects it
+// matches that were already loaded.
+func (ld *loader) exclude(stub Project) {
+	ld.excluded = append(ld.excluded, stub)
+	for key, project := range ld.Projects {
+		if excludedBy(project, stub) {
+			delete(ld.Projects, key)
+			delete(ld.projectSources, key)
+		}
+	}
+}
+
+// isExcluded returns whether the given project is matched by an excluded
+// project stub.
+func (ld *loader) isExcluded(project Project) bool {
+	for _, stub := range ld.excluded {
+		if excludedBy(project, stub) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludedBy returns whether the given project has the name of the given
+// excluded project stub, and its remote if the stub has one.
+func excludedBy(project, stub Project) bool {
+	return project.Name == stub.Name && (stub.Remote == "" || project.Remote == stub.Remote)
+}
+
+// enabled returns whether the given comma-separated groups of an import or a
+// project include an enabled group.  All groups are enabled when linting.
+func (ld *loader) enabled(groups string) bool {
+	return ld.lint != nil || inGroups(groups, ld.groups)
+}
+
+// resetAndLoad resets the master branch of the given manifest project to its
+// revision, or to the given revision if it is not empty, and loads the given
+// manifest file from it.
+func (ld *loader) resetAndLoad(jirix *jiri.X, root, file, cycleKey string, project Project, revision string) (e error) {
+	if revision != "" {
+		project.Revision = revision
+	}
 	// Change to the project.Path directory, and revert when done.
 	pushd := jirix.NewSeq().Pushd(project.Path)
 	defer collect.Error(pushd.Done, &e)
@@ -1637,11 +3618,14 @@ func (ld *loader) resetAndLoad(jirix *jiri.X, root, file, cycleKey string, proje
 	// for the given projects, rather than ApplyToLocalMaster(fetch+reset+load).
 	return ApplyToLocalMaster(jirix, Projects{project.Key(): project}, func() error {
 		if ld.update {
-			if err := fetchProject(jirix, project); err != nil {
+			if err := fetchProject(jirix, project, ld.gitTimeout); err != nil {
 				return err
 			}
 		}
-		if err := resetProjectCurrentBranch(jirix, project); err != nil {
+		if ld.noVerify {
+			warnNoVerify(jirix, project)
+		}
+		if err := resetProjectCurrentBranch(jirix, project, ld.gitTimeout, ld.noVerify); err != nil {
 			return err
 		}
 		return ld.Load(jirix, root, file, cycleKey)
@@ -1677,6 +3661,53 @@ func reportNonMaster(jirix *jiri.X, project Project) (e error) {
 	}
 }
 
+// rebaseCurrentBranch rebases the current branch of the given project onto
+// its master branch, unless the current branch is master, the project has
+// uncommitted changes, or a merge or rebase is in progress.  If the rebase
+// fails, it is aborted, which leaves the branch unchanged.  The outcome is
+// returned for the summary of the update; nil means that there was nothing
+// to rebase.
+func rebaseCurrentBranch(jirix *jiri.X, project Project) *rebaseResult {
+	if project.Protocol != "git" {
+		return nil
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+	branch, err := git.CurrentBranchName()
+	if err != nil || branch == "master" || branch == "HEAD" {
+		return nil
+	}
+	result := &rebaseResult{project: project.Name, branch: branch}
+	if operation, err := git.InProgressOperation(); err != nil {
+		result.problem = err.Error()
+		return result
+	} else if operation != "" {
+		result.problem = operation + " in progress, skipped"
+		return result
+	}
+	if dirty, err := git.HasUncommittedChanges(); err != nil {
+		result.problem = err.Error()
+		return result
+	} else if dirty {
+		result.problem = "uncommitted changes, skipped"
+		return result
+	}
+	// Abort the rebase if it fails, or if jiri is interrupted while running
+	// it, so that the branch is left as it was.
+	abort := jirix.AddCleanup(func() error {
+		if rebasing, err := git.RebaseInProgress(); err != nil || !rebasing {
+			return err
+		}
+		return git.RebaseAbort()
+	})
+	if err := git.Rebase("master"); err != nil {
+		result.problem = "conflicts, left unchanged"
+	}
+	if err := abort(); err != nil {
+		result.problem = fmt.Sprintf("failed to abort the rebase: %v", err)
+	}
+	return result
+}
+
 // groupByGoogleSourceHosts returns a map of googlesource host to a Projects
 // map where all project remotes come from that host.
 func groupByGoogleSourceHosts(ps Projects) map[string]Projects {
@@ -1698,24 +3729,98 @@ func groupByGoogleSourceHosts(ps Projects) map[string]Projects {
 	return m
 }
 
+// hookOpts configures the running of the runhooks of the projects.
+type hookOpts struct {
+	// profiles holds the profiles whose environment variables are merged into
+	// the environment of all runhooks.
+	profiles []string
+	// timeout is the maximum duration of a runhook, or zero for no limit.
+	timeout time.Duration
+	// xunitFile is the file the xUnit report of the runhooks is written to,
+	// if any.
+	xunitFile string
+	// profilesDB is the profiles database, and profilesDir the directory,
+	// relative to $JIRI_ROOT, that the profiles required by the runhooks
+	// are installed in if installMissingProfiles is set.
+	profilesDB             string
+	profilesDir            string
+	installMissingProfiles bool
+}
+
+// remoteHeadsOpts configures the fetching of the revisions of the projects
+// at HEAD from googlesource hosts.
+type remoteHeadsOpts struct {
+	offline bool
+	// hosts holds the googlesource hosts that may be queried, or nil if all
+	// hosts may be queried.
+	hosts []string
+	// noCache disables the cache of the responses of the hosts, and cacheDir
+	// and cacheTTL configure it.
+	noCache  bool
+	cacheDir string
+	cacheTTL time.Duration
+}
+
+// allowedGoogleSourceHosts returns the subset of the given map of
+// googlesource host to Projects whose hosts are in the given list of hosts,
+// which may hold URLs or host names.  A nil list allows all hosts.
+func allowedGoogleSourceHosts(gsHostsMap map[string]Projects, hosts []string) map[string]Projects {
+	if hosts == nil {
+		return gsHostsMap
+	}
+	allowed := map[string]bool{}
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if u, err := url.Parse(host); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		allowed[strings.ToLower(strings.TrimSuffix(host, "/"))] = true
+	}
+	m := make(map[string]Projects)
+	for host, projects := range gsHostsMap {
+		u, err := url.Parse(host)
+		if err != nil || !allowed[strings.ToLower(u.Host)] {
+			continue
+		}
+		m[host] = projects
+	}
+	return m
+}
+
 // getRemoteHeadRevisions attempts to get the repo statuses from remote for
 // projects at HEAD so we can detect when a local project is already
-// up-to-date.
-func getRemoteHeadRevisions(jirix *jiri.X, remoteProjects Projects) {
+// up-to-date.  Nothing is fetched in offline mode, and only the allowed
+// googlesource hosts are queried.  Unless disabled, the responses cached by
+// recent updates are used.
+func getRemoteHeadRevisions(jirix *jiri.X, remoteProjects Projects, opts remoteHeadsOpts) {
+	if opts.offline {
+		return
+	}
+	getRepoStatuses := googlesource.GetRepoStatuses
+	if !opts.noCache && opts.cacheTTL > 0 {
+		cache := &googlesource.Cache{Dir: opts.cacheDir, TTL: opts.cacheTTL}
+		if cache.Dir == "" {
+			cache.Dir = os.Getenv(googlesource.CacheDirEnv)
+		}
+		if cache.Dir == "" {
+			cache.Dir = jirix.RemoteCacheDir()
+		}
+		getRepoStatuses = cache.GetRepoStatuses
+	}
 	projectsAtHead := Projects{}
 	for _, rp := range remoteProjects {
 		if rp.Revision == "HEAD" {
 			projectsAtHead[rp.Key()] = rp
 		}
 	}
-	gsHostsMap := groupByGoogleSourceHosts(projectsAtHead)
+	gsHostsMap := allowedGoogleSourceHosts(groupByGoogleSourceHosts(projectsAtHead), opts.hosts)
 	for host, projects := range gsHostsMap {
 		branchesMap := make(map[string]bool)
 		for _, p := range projects {
 			branchesMap[p.RemoteBranch] = true
 		}
 		branches := set.StringBool.ToSlice(branchesMap)
-		repoStatuses, err := googlesource.GetRepoStatuses(jirix, host, branches)
+		repoStatuses, err := getRepoStatuses(jirix, host, branches)
 		if err != nil {
 			// Log the error but don't fail.
 			fmt.Fprintf(jirix.Stderr(), "Error fetching repo statuses from remote: %v\n", err)
@@ -1737,47 +3842,367 @@ func getRemoteHeadRevisions(jirix *jiri.X, remoteProjects Projects) {
 	}
 }
 
-func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, gc bool) error {
-	jirix.TimerPush("update projects")
+func updateProjects(jirix *jiri.X, summary *updateSummary, localProjects, remoteProjects Projects, gc, noHooks, verbose, progress, forceRemoteChange, rebaseTracked bool, pruneGroups []string, reference referenceRepos, heads remoteHeadsOpts, gitTimeout time.Duration, noVerify, detectLFS bool, hooks hookOpts) error {
+	jirix.TimerPushCategory(jiri.TimerUpdateProjects, "update projects")
 	defer jirix.TimerPop()
 
-	getRemoteHeadRevisions(jirix, remoteProjects)
+	getRemoteHeadRevisions(jirix, remoteProjects, heads)
 	ops := computeOperations(localProjects, remoteProjects, gc)
+	if err := keepDisabledGroups(jirix, ops, gc, pruneGroups); err != nil {
+		return err
+	}
+	if err := keepInProgress(jirix, summary, ops, gc); err != nil {
+		return err
+	}
+	// The progress is stopped if jiri is interrupted, to clear the status
+	// line, and before the hooks run, which print to jirix directly.
+	prog, opx := newUpdateProgress(jirix, ops, verbose, progress)
+	stopProgress := jirix.AddCleanup(func() error { prog.Stop(); return nil })
+	defer stopProgress()
+	for i, op := range ops {
+		switch typedOp := op.(type) {
+		case createOperation:
+			typedOp.reference = reference
+			typedOp.gitTimeout = gitTimeout
+			typedOp.noVerify = noVerify
+			typedOp.detectLFS = detectLFS
+			typedOp.progress = prog
+			ops[i] = typedOp
+		case updateOperation:
+			typedOp.reference = reference
+			typedOp.forceRemoteChange = forceRemoteChange
+			typedOp.gitTimeout = gitTimeout
+			typedOp.noVerify = noVerify
+			typedOp.detectLFS = detectLFS
+			typedOp.progress = prog
+			ops[i] = typedOp
+		case moveOperation:
+			typedOp.gitTimeout = gitTimeout
+			typedOp.noVerify = noVerify
+			typedOp.detectLFS = detectLFS
+			ops[i] = typedOp
+		}
+		if noVerify && (op.Kind() == "create" || op.Kind() == "update" || op.Kind() == "move") {
+			warnNoVerify(jirix, op.Project())
+		}
+	}
 	updates := newFsUpdates()
 	for _, op := range ops {
 		if err := op.Test(jirix, updates); err != nil {
+			summary.failed = fmt.Sprintf("%v", op)
+			summary.notRun = len(ops)
 			return err
 		}
 	}
-	s := jirix.NewSeq()
+	s := opx.NewSeq()
+	ran := make(operations, 0, len(ops))
+	var missing []string
+	for i, op := range ops {
+		oldRevision := ""
+		switch op.Kind() {
+		case "move":
+			oldRevision = revision(jirix, localProjects[op.Project().Key()])
+		case "update":
+			// The local project has a different key if its remote changed,
+			// but always has the same path.
+			oldRevision = revision(jirix, op.Project())
+		}
+		if op.Kind() != "null" {
+			prog.Step("%s %s", progressVerbs[op.Kind()], op.Project().Name)
+		}
+		updateFn := func() error { return op.Run(opx) }
+		// Log the output of updateFn irrespective of the value of the
+		// verbose flag, unless only a summary was requested or the progress
+		// is reported instead.
+		if err := s.Verbose(verbose && prog == nil).Call(updateFn, "%v", op).Done(); err != nil {
+			// A project whose remote branch is missing does not stop the
+			// update of the others, but fails the update once they are
+			// updated.
+			if isMissingBranch(err) {
+				summary.addMissingBranch(op.Project())
+				missing = append(missing, fmt.Sprintf("error updating project %q: %v", op.Project().Name, err))
+				continue
+			}
+			summary.failed = fmt.Sprintf("%v", op)
+			summary.notRun = len(ops) - i - 1
+			return errkind.Errorf(errkind.Of(err), "error updating project %q: %v", op.Project().Name, err)
+		}
+		summary.addOp(jirix, op, oldRevision, gc)
+		ran = append(ran, op)
+	}
+	stopProgress()
+	ops = ran
+	var missingErr error
+	if len(missing) > 0 {
+		missingErr = errkind.Errorf(errkind.ManifestError, "%s", strings.Join(missing, "\n"))
+	}
+	if err := excludeMetadataDirs(jirix, ops); err != nil {
+		return err
+	}
+	if rebaseTracked {
+		for _, op := range ops {
+			if op.Kind() == "update" || op.Kind() == "move" {
+				summary.addRebase(rebaseCurrentBranch(jirix, op.Project()))
+			}
+		}
+	}
+	if noHooks {
+		reportSkippedHooks(jirix, ops)
+		return missingErr
+	}
+	if err := runHooks(jirix, summary, ops, hooks); err != nil {
+		return err
+	}
+	if err := applyGitHooks(jirix, ops); err != nil {
+		return err
+	}
+	return missingErr
+}
+
+// progressVerbs describe the project operations of each kind in the progress
+// of an update.
+var progressVerbs = map[string]string{
+	"create": "cloning",
+	"delete": "deleting",
+	"move":   "moving",
+	"update": "updating",
+}
+
+// newUpdateProgress returns the progress of the given operations, or nil if
+// the progress is not reported, along with the X to run the operations with,
+// whose output does not interleave with the status line of the progress.  The
+// progress replaces the logging of the operations, unless the verbose flag is
+// set; without a terminal, it is only reported if the operations are logged
+// otherwise, i.e. if logOps is set.
+func newUpdateProgress(jirix *jiri.X, ops operations, logOps, progress bool) (*runutil.Progress, *jiri.X) {
+	if !progress || jirix.Verbose() {
+		return nil, jirix
+	}
+	total := 0
 	for _, op := range ops {
-		updateFn := func() error { return op.Run(jirix) }
-		// Always log the output of updateFn, irrespective of
-		// the value of the verbose flag.
-		if err := s.Verbose(true).Call(updateFn, "%v", op).Done(); err != nil {
-			return fmt.Errorf("error updating project %q: %v", op.Project().Name, err)
+		if op.Kind() != "null" {
+			total++
 		}
 	}
-	if err := runHooks(jirix, ops); err != nil {
+	prog := runutil.NewProgress(jirix.Stdout(), total)
+	if !prog.Terminal() && !logOps {
+		return nil, jirix
+	}
+	return prog, jirix.Clone(tool.ContextOpts{
+		Stdout: prog.Writer(jirix.Stdout()),
+		Stderr: prog.Writer(jirix.Stderr()),
+	})
+}
+
+// keepDisabledGroups replaces the operations that delete local projects that
+// are not in any enabled group, and thus were not loaded from the manifest,
+// with operations that leave the projects unchanged.  Projects in one of the
+// given groups to prune are still deleted when garbage collecting.
+func keepDisabledGroups(jirix *jiri.X, ops operations, gc bool, pruneGroups []string) error {
+	enabled, err := enabledGroupSet(jirix)
+	if err != nil {
 		return err
 	}
-	return applyGitHooks(jirix, ops)
+	prune := map[string]bool{}
+	for _, group := range pruneGroups {
+		prune[group] = true
+	}
+	for i, op := range ops {
+		project := op.Project()
+		if op.Kind() != "delete" || inGroups(project.Groups, enabled) {
+			continue
+		}
+		if gc && inGroups(project.Groups, prune) {
+			continue
+		}
+		fmt.Fprintf(jirix.Stdout(), "NOTE: project %q is not in an enabled group (groups %q), leaving it unchanged\n", project.Name, strings.Join(ParseGroups(project.Groups), ","))
+		ops[i] = nullOperation{commonOperation{
+			destination: project.Path,
+			project:     project,
+			source:      project.Path,
+		}}
+	}
+	return nil
+}
+
+// keepInProgress replaces the operations that would change local projects in
+// which a git operation, such as a rebase, is in progress with operations that
+// leave the projects unchanged, since resetting or moving them would fail or
+// lose the state of the git operation.  The projects are recorded in the
+// summary.
+func keepInProgress(jirix *jiri.X, summary *updateSummary, ops operations, gc bool) error {
+	for i, op := range ops {
+		var source string
+		switch typedOp := op.(type) {
+		case moveOperation:
+			source = typedOp.source
+		case updateOperation:
+			source = typedOp.source
+		case deleteOperation:
+			if !gc {
+				continue
+			}
+			source = typedOp.source
+		default:
+			continue
+		}
+		project := op.Project()
+		if project.Protocol != "git" {
+			continue
+		}
+		operation, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(source)).InProgressOperation()
+		if err != nil {
+			return err
+		}
+		if operation == "" {
+			continue
+		}
+		summary.addInProgress(project.Name, operation)
+		project.Path = source
+		ops[i] = nullOperation{commonOperation{
+			destination: source,
+			project:     project,
+			source:      source,
+		}}
+	}
+	return nil
+}
+
+// hookOp returns whether the hooks of the project of the given operation
+// should be run or installed.
+func hookOp(op operation) bool {
+	return op.Kind() == "create" || op.Kind() == "move" || op.Kind() == "update"
+}
+
+// projectHooks returns a description of each hook of the given project.
+func projectHooks(jirix *jiri.X, project Project) []string {
+	rel := func(path string) string {
+		if relPath, err := filepath.Rel(jirix.Root, path); err == nil {
+			return relPath
+		}
+		return path
+	}
+	var hooks []string
+	if project.RunHook != "" {
+		hooks = append(hooks, fmt.Sprintf("  %s: runhook %s", project.Name, rel(project.RunHook)))
+	}
+	if project.GitHooks != "" {
+		hooks = append(hooks, fmt.Sprintf("  %s: githooks %s", project.Name, rel(project.GitHooks)))
+	}
+	return hooks
+}
+
+// reportSkippedHooks prints a summary of the hooks that runHooks and
+// applyGitHooks would have run or installed for the given operations.
+func reportSkippedHooks(jirix *jiri.X, ops []operation) {
+	var hooks []string
+	for _, op := range ops {
+		if hookOp(op) {
+			hooks = append(hooks, projectHooks(jirix, op.Project())...)
+		}
+	}
+	if len(hooks) > 0 {
+		fmt.Fprintf(jirix.Stdout(), "Hooks are disabled, skipped the following hooks:\n%s\n", strings.Join(hooks, "\n"))
+	}
+}
+
+// goWorkspace returns the Go workspace that the package with the given import
+// path is in, given the path of the project that contains it and the path
+// separator of the platform, or "" if it cannot be identified.  To this end
+// it uses a heuristic that identifies the maximal suffix of the project path
+// that corresponds to a prefix of the package name.
+func goWorkspace(projectPath, pkg string, sep byte) string {
+	for i := 0; i < len(projectPath); i++ {
+		if projectPath[i] == sep {
+			suffix := strings.Replace(projectPath[i+1:], string(sep), "/", -1)
+			if strings.HasPrefix("src/"+pkg, suffix) {
+				return projectPath[:i]
+			}
+		}
+	}
+	return ""
+}
+
+// hookCommand returns the command and arguments that run the given runhook
+// for the given kind of operation on the given operating system.  Windows
+// cannot execute scripts directly, so there hooks are run by the command
+// interpreter named by COMSPEC, or by PowerShell for .ps1 scripts.
+func hookCommand(goos, comspec, runhook, kind string) (string, []string) {
+	if goos != "windows" {
+		return runhook, []string{kind}
+	}
+	if strings.EqualFold(filepath.Ext(runhook), ".ps1") {
+		return "powershell", []string{"-NoProfile", "-ExecutionPolicy", "Bypass", "-File", runhook, kind}
+	}
+	if comspec == "" {
+		comspec = "cmd"
+	}
+	return comspec, []string{"/c", runhook, kind}
 }
 
-// runHooks runs all hooks for the given operations.
-func runHooks(jirix *jiri.X, ops []operation) error {
+// runHooks runs all hooks for the given operations, merging the environment
+// variables of the given profiles into the environment of each hook.
+func runHooks(jirix *jiri.X, summary *updateSummary, ops []operation, hooks hookOpts) error {
 	jirix.TimerPush("run hooks")
 	defer jirix.TimerPop()
 	for _, op := range ops {
-		if op.Project().RunHook == "" {
+		if op.Project().RunHook == "" || !hookOp(op) {
 			continue
 		}
-		if op.Kind() != "create" && op.Kind() != "move" && op.Kind() != "update" {
+		skipped, err := installRequiredProfiles(jirix, op.Project(), hooks)
+		if err != nil {
+			return fmt.Errorf("error running hook for project %q: %v", op.Project().Name, err)
+		}
+		if skipped != "" {
+			fmt.Fprintf(jirix.Stdout(), "skipped the hook for project %q: %s\n", op.Project().Name, skipped)
+			summary.hooks = append(summary.hooks, hookResult{
+				project: op.Project().Name,
+				kind:    op.Kind(),
+				skipped: skipped,
+			})
 			continue
 		}
-		s := jirix.NewSeq()
+		env, err := hookEnv(jirix, op.Project(), hooks.profiles, hooks.profilesDB)
+		if err != nil {
+			return fmt.Errorf("error running hook for project %q: %v", op.Project().Name, err)
+		}
+		s := jirix.Clone(tool.ContextOpts{Env: env}).NewSeq()
 		s.Verbose(true).Output([]string{fmt.Sprintf("running hook for project %q", op.Project().Name)})
-		if err := s.Dir(op.Project().Path).Capture(os.Stdout, os.Stderr).Last(op.Project().RunHook, op.Kind()); err != nil {
+		if jirix.Verbose() {
+			s.Verbose(true).Output(append([]string{"with environment:"}, envvar.MapToSlice(env)...))
+		}
+		// The output of the hook is printed only if it fails or -v is set,
+		// so that the output of different hooks is not interleaved with the
+		// progress of the update.
+		var output bytes.Buffer
+		start := time.Now()
+		if hooks.timeout > 0 {
+			s = s.Timeout(hooks.timeout)
+		}
+		hook, args := hookCommand(runtime.GOOS, os.Getenv("COMSPEC"), op.Project().RunHook, op.Kind())
+		err = s.Dir(op.Project().Path).Capture(&output, &output).Last(hook, args...)
+		if runutil.IsTimeout(err) {
+			err = fmt.Errorf("timed out after %v", hooks.timeout)
+		}
+		result := hookResult{
+			project:  op.Project().Name,
+			kind:     op.Kind(),
+			duration: time.Since(start),
+			output:   output.String(),
+			err:      err,
+		}
+		summary.hooks = append(summary.hooks, result)
+		if err != nil || jirix.Verbose() {
+			w := jirix.Stdout()
+			if err != nil {
+				w = jirix.Stderr()
+			}
+			fmt.Fprintf(w, "output of hook for project %q:\n%s", op.Project().Name, result.output)
+			if result.output != "" && !strings.HasSuffix(result.output, "\n") {
+				fmt.Fprintln(w)
+			}
+		}
+		if err != nil {
 			// TODO(nlacasse): Should we delete projectDir or perform some
 			// other cleanup in the event of a hook failure?
 			return fmt.Errorf("error running hook for project %q: %v", op.Project().Name, err)
@@ -1786,9 +4211,55 @@ func runHooks(jirix *jiri.X, ops []operation) error {
 	return nil
 }
 
-func applyGitHooks(jirix *jiri.X, ops []operation) error {
-	jirix.TimerPush("apply githooks")
-	defer jirix.TimerPop()
+// hookEnv returns the environment that the runhook of the given project runs
+// with: the environment of jiri, or only its PATH and HOME variables if the
+// hookenv of the project is HookEnvClean, with JIRI_ROOT, JIRI_PROJECT_NAME
+// and JIRI_PROJECT_PATH set, and the environment variables of the given
+// profiles and of the hookprofiles of the project, read from the given
+// profiles database, merged in, using the merge policies of jiri.
+func hookEnv(jirix *jiri.X, project Project, profileNames []string, dbPath string) (map[string]string, error) {
+	inherited := jirix.Env()
+	if len(inherited) == 0 {
+		inherited = envvar.SliceToMap(os.Environ())
+	}
+	env := map[string]string{}
+	if project.HookEnv == HookEnvClean {
+		for _, key := range []string{"PATH", "HOME"} {
+			if value, ok := inherited[key]; ok {
+				env[key] = value
+			}
+		}
+	} else {
+		env = envvar.CopyMap(inherited)
+	}
+	env["JIRI_ROOT"] = jirix.Root
+	env["JIRI_PROJECT_NAME"] = project.Name
+	env["JIRI_PROJECT_PATH"] = project.Path
+	profileNames = append([]string{}, profileNames...)
+	for _, name := range strings.Split(project.HookProfiles, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			profileNames = append(profileNames, name)
+		}
+	}
+	if len(profileNames) == 0 {
+		return env, nil
+	}
+	rd, err := profilesreader.NewReader(jirix, profilesreader.UseProfiles, dbPath)
+	if err != nil {
+		return nil, err
+	}
+	target := profiles.NativeTarget()
+	if err := rd.ValidateRequestedProfilesAndTarget(profileNames, target); err != nil {
+		return nil, err
+	}
+	rd.Vars = envvar.VarsFromMap(env)
+	rd.MergeEnvFromProfiles(profilesreader.JiriMergePolicies(), target, profileNames...)
+	return rd.ToMap(), nil
+}
+
+// excludeMetadataDirs excludes the jiri metadata directory from git in the
+// projects of the given operations.
+func excludeMetadataDirs(jirix *jiri.X, ops []operation) error {
 	s := jirix.NewSeq()
 	for _, op := range ops {
 		if op.Kind() == "create" || op.Kind() == "move" {
@@ -1804,10 +4275,16 @@ func applyGitHooks(jirix *jiri.X, ops []operation) error {
 				return err
 			}
 		}
-		if op.Project().GitHooks == "" {
-			continue
-		}
-		if op.Kind() != "create" && op.Kind() != "move" && op.Kind() != "update" {
+	}
+	return nil
+}
+
+func applyGitHooks(jirix *jiri.X, ops []operation) error {
+	jirix.TimerPush("apply githooks")
+	defer jirix.TimerPop()
+	s := jirix.NewSeq()
+	for _, op := range ops {
+		if op.Project().GitHooks == "" || !hookOp(op) {
 			continue
 		}
 		// Apply git hooks, overwriting any existing hooks.  Jiri is in control of
@@ -1909,6 +4386,17 @@ type commonOperation struct {
 	destination string
 	// source is the current project path.
 	source string
+	// gitTimeout is the timeout of the git commands that fetch, clone or
+	// reset the project, or zero if there is none.
+	gitTimeout time.Duration
+	// noVerify disables the verification of the signatures of the revisions
+	// of the project.
+	noVerify bool
+	// detectLFS enables Git LFS for the project if its .gitattributes file
+	// configures the LFS filter, even if its lfs attribute is not set.
+	detectLFS bool
+	// progress reports the progress of the operation, if not nil.
+	progress *runutil.Progress
 }
 
 func (op commonOperation) Project() Project {
@@ -1918,6 +4406,9 @@ func (op commonOperation) Project() Project {
 // createOperation represents the creation of a project.
 type createOperation struct {
 	commonOperation
+	// reference describes the reference repositories used to clone the
+	// project, if any.
+	reference referenceRepos
 }
 
 func (op createOperation) Kind() string {
@@ -1940,9 +4431,10 @@ func (op createOperation) Run(jirix *jiri.X) (e error) {
 	defer collect.Error(func() error { return jirix.NewSeq().RemoveAll(tmpDir).Done() }, &e)
 	switch op.project.Protocol {
 	case "git":
-		if err := gitutil.New(jirix.NewSeq()).Clone(op.project.Remote, tmpDir); err != nil {
+		if err := op.reference.clone(jirix, op.project, tmpDir, op.gitTimeout); err != nil {
 			return err
 		}
+		op.progress.Status("checking out %s", op.project.Name)
 		cwd, err := os.Getwd()
 		if err != nil {
 			return err
@@ -1951,21 +4443,51 @@ func (op createOperation) Run(jirix *jiri.X) (e error) {
 		if err := s.Chdir(tmpDir).Done(); err != nil {
 			return err
 		}
+		// Partial clones are created without a working tree, which would
+		// appear to be deleted when syncing the master branch, so check out
+		// the revision of the project, fetching only the objects it needs.
+		if op.project.CloneFilter != "" {
+			if err := resetProjectCurrentBranch(jirix, op.project, op.gitTimeout, op.noVerify); err != nil {
+				return err
+			}
+		} else {
+			// Fail before the clone, which has the default branch of the
+			// remote checked out, is moved into place.
+			target, _, err := resolveResetTarget(jirix, op.project, "", op.gitTimeout)
+			if err != nil {
+				return err
+			}
+			if err := checkSignature(jirix, op.project, "", target, op.noVerify); err != nil {
+				return err
+			}
+		}
+		if err := applySparseCheckout(jirix, op.project, tmpDir); err != nil {
+			return err
+		}
+		// Install the LFS filters before the project is moved into place,
+		// so that a missing git-lfs fails the update rather than leaving
+		// pointer files behind, and so that the files checked out when
+		// syncing the master branch below get their contents.
+		if err := applyLFS(jirix, op.project, tmpDir, op.detectLFS, op.gitTimeout); err != nil {
+			return err
+		}
 	default:
 		return UnsupportedProtocolErr(op.project.Protocol)
 	}
 	if err := writeMetadata(jirix, op.project, tmpDir); err != nil {
 		return err
 	}
-	if err := s.Chmod(tmpDir, os.FileMode(0755)).
-		Rename(tmpDir, op.destination).Done(); err != nil {
+	if runtime.GOOS != "windows" {
+		s.Chmod(tmpDir, os.FileMode(0755))
+	}
+	if err := s.Rename(tmpDir, op.destination).Done(); err != nil {
 		return err
 	}
-	return syncProjectMaster(jirix, op.project)
+	return syncProjectMaster(jirix, op.project, op.gitTimeout, op.noVerify)
 }
 
 func (op createOperation) String() string {
-	return fmt.Sprintf("create project %q in %q and advance it to %q", op.project.Name, op.destination, fmtRevision(op.project.Revision))
+	return fmt.Sprintf("create project %q in %q and advance it to %q", op.project.Name, op.destination, FmtRevision(op.project.Revision))
 }
 
 func (op createOperation) Test(jirix *jiri.X, updates *fsUpdates) error {
@@ -1975,6 +4497,9 @@ func (op createOperation) Test(jirix *jiri.X, updates *fsUpdates) error {
 			return err
 		}
 	} else if !updates.isDeleted(op.destination) {
+		if _, err := jirix.NewSeq().Stat(filepath.Join(op.destination, ".git")); err == nil {
+			return fmt.Errorf("cannot create %q as it already exists; if it is a checkout of project %q with missing or invalid metadata, run \"jiri project repair %s\"", op.destination, op.project.Name, op.destination)
+		}
 		return fmt.Errorf("cannot create %q as it already exists", op.destination)
 	}
 	return nil
@@ -2018,7 +4543,11 @@ func (op deleteOperation) Run(jirix *jiri.X) error {
 			s.Verbose(true).Output(lines)
 			return nil
 		}
-		return s.RemoveAll(op.source).Done()
+		if err := s.RemoveAll(op.source).Done(); err != nil {
+			return err
+		}
+		jirix.Audit("delete project", "deleted project %q in %s, which is not in the manifest", op.project.Name, op.source)
+		return nil
 	}
 	lines := []string{
 		fmt.Sprintf("NOTE: project %v was not found in the project manifest", op.project.Name),
@@ -2056,20 +4585,40 @@ func (op moveOperation) Kind() string {
 func (op moveOperation) Run(jirix *jiri.X) error {
 	s := jirix.NewSeq()
 	path, perm := filepath.Dir(op.destination), os.FileMode(0755)
-	if err := s.MkdirAll(path, perm).Rename(op.source, op.destination).Done(); err != nil {
+	if foldPath(op.source) == foldPath(op.destination) {
+		// The source and destination may be the same directory on a
+		// case-insensitive filesystem, where renaming it to a name that only
+		// differs in case may do nothing, so rename it in two steps.
+		tmp := op.source + ".jiri-move"
+		if err := s.Rename(op.source, tmp).MkdirAll(path, perm).Rename(tmp, op.destination).Done(); err != nil {
+			return err
+		}
+	} else if err := s.MkdirAll(path, perm).Rename(op.source, op.destination).Done(); err != nil {
 		return err
 	}
+	jirix.Audit("move project", "moved project %q from %s to %s", op.project.Name, op.source, op.destination)
 	if err := reportNonMaster(jirix, op.project); err != nil {
 		return err
 	}
-	if err := syncProjectMaster(jirix, op.project); err != nil {
+	// The remote of a project moved to a path that only differs in case may
+	// have changed too.
+	if _, err := checkRemoteChange(jirix, op.project, false, op.gitTimeout); err != nil {
+		return err
+	}
+	if err := syncProjectMaster(jirix, op.project, op.gitTimeout, op.noVerify); err != nil {
+		return err
+	}
+	if err := applySparseCheckout(jirix, op.project, op.project.Path); err != nil {
+		return err
+	}
+	if err := applyLFS(jirix, op.project, op.project.Path, op.detectLFS, op.gitTimeout); err != nil {
 		return err
 	}
 	return writeMetadata(jirix, op.project, op.project.Path)
 }
 
 func (op moveOperation) String() string {
-	return fmt.Sprintf("move project %q located in %q to %q and advance it to %q", op.project.Name, op.source, op.destination, fmtRevision(op.project.Revision))
+	return fmt.Sprintf("move project %q located in %q to %q and advance it to %q", op.project.Name, op.source, op.destination, FmtRevision(op.project.Revision))
 }
 
 func (op moveOperation) Test(jirix *jiri.X, updates *fsUpdates) error {
@@ -2080,11 +4629,13 @@ func (op moveOperation) Test(jirix *jiri.X, updates *fsUpdates) error {
 		}
 		return err
 	}
-	if _, err := s.Stat(op.destination); err != nil {
+	if info, err := s.Stat(op.destination); err != nil {
 		if !runutil.IsNotExist(err) {
 			return err
 		}
-	} else {
+	} else if source, err := s.Stat(op.source); err != nil || !os.SameFile(info, source) {
+		// On a case-insensitive filesystem, the destination of a move to a
+		// path that only differs in case is the source itself.
 		return fmt.Errorf("cannot move %q to %q as the destination already exists", op.source, op.destination)
 	}
 	updates.deleteDir(op.source)
@@ -2094,6 +4645,16 @@ func (op moveOperation) Test(jirix *jiri.X, updates *fsUpdates) error {
 // updateOperation represents the update of a project.
 type updateOperation struct {
 	commonOperation
+	// forceRemoteChange determines whether the project is cloned again if
+	// its remote changed to a repository with unrelated history.
+	forceRemoteChange bool
+	// reference describes the reference repositories used to clone the
+	// project again, if any.
+	reference referenceRepos
+	// lfsChanged determines whether the lfs attribute of the project
+	// changed, in which case Git LFS is applied even if the checked out
+	// revision did not change.
+	lfsChanged bool
 }
 
 func (op updateOperation) Kind() string {
@@ -2103,14 +4664,70 @@ func (op updateOperation) Run(jirix *jiri.X) error {
 	if err := reportNonMaster(jirix, op.project); err != nil {
 		return err
 	}
-	if err := syncProjectMaster(jirix, op.project); err != nil {
+	replaced, err := checkRemoteChange(jirix, op.project, op.forceRemoteChange, op.gitTimeout)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		op.progress.Status("cloning %s again", op.project.Name)
+		return op.reclone(jirix)
+	}
+	op.progress.Status("fetching %s", op.project.Name)
+	// Projects at revision HEAD are updated by every update, so Git LFS is
+	// only applied if the checked out revision changed, rather than running
+	// git-lfs each time.
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(op.project.Path))
+	before, _ := git.CurrentRevision()
+	if err := syncProjectMaster(jirix, op.project, op.gitTimeout, op.noVerify); err != nil {
+		return err
+	}
+	if err := applySparseCheckout(jirix, op.project, op.project.Path); err != nil {
 		return err
 	}
+	if after, err := git.CurrentRevision(); op.lfsChanged || before == "" || err != nil || after != before {
+		if err := applyLFS(jirix, op.project, op.project.Path, op.detectLFS, op.gitTimeout); err != nil {
+			return err
+		}
+	}
 	return writeMetadata(jirix, op.project, op.project.Path)
 }
 
+// reclone moves the local checkout of the project to "<path>.old" and clones
+// the project into its place.
+func (op updateOperation) reclone(jirix *jiri.X) error {
+	s := jirix.NewSeq()
+	oldPath := op.project.Path + ".old"
+	if _, err := s.Stat(oldPath); err == nil {
+		return fmt.Errorf("cannot move project %q to %q as the destination already exists", op.project.Name, oldPath)
+	} else if !runutil.IsNotExist(err) {
+		return err
+	}
+	if err := s.Rename(op.project.Path, oldPath).Done(); err != nil {
+		return err
+	}
+	jirix.Audit("move project", "moved project %q from %s to %s to clone it again", op.project.Name, op.project.Path, oldPath)
+	create := createOperation{commonOperation{
+		destination: op.project.Path,
+		project:     op.project,
+		gitTimeout:  op.gitTimeout,
+		noVerify:    op.noVerify,
+		detectLFS:   op.detectLFS,
+		progress:    op.progress,
+	}, op.reference}
+	if err := create.Run(jirix); err != nil {
+		if _, statErr := s.Stat(op.project.Path); runutil.IsNotExist(statErr) {
+			if renameErr := s.Rename(oldPath, op.project.Path).Done(); renameErr != nil {
+				return errkind.Errorf(errkind.Of(err), "%v\nfailed to restore the old checkout from %q: %v", err, oldPath, renameErr)
+			}
+		}
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "NOTE: cloned project %q again, the old checkout was moved to %q\n", op.project.Name, oldPath)
+	return nil
+}
+
 func (op updateOperation) String() string {
-	return fmt.Sprintf("advance project %q located in %q to %q", op.project.Name, op.source, fmtRevision(op.project.Revision))
+	return fmt.Sprintf("advance project %q located in %q to %q", op.project.Name, op.source, FmtRevision(op.project.Revision))
 }
 
 func (op updateOperation) Test(jirix *jiri.X, _ *fsUpdates) error {
@@ -2132,7 +4749,7 @@ func (op nullOperation) Run(jirix *jiri.X) error {
 }
 
 func (op nullOperation) String() string {
-	return fmt.Sprintf("project %q located in %q at revision %q is up-to-date", op.project.Name, op.source, fmtRevision(op.project.Revision))
+	return fmt.Sprintf("project %q located in %q at revision %q is up-to-date", op.project.Name, op.source, FmtRevision(op.project.Revision))
 }
 
 func (op nullOperation) Test(jirix *jiri.X, _ *fsUpdates) error {
@@ -2188,11 +4805,31 @@ func (ops operations) Swap(i, j int) {
 // system and manifest file respectively) and outputs a collection of
 // operations that describe the actions needed to update the target
 // projects.
+//
+// A local project whose key does not match any remote project is treated as
+// renamed to a remote project whose key does not match any local project, if
+// they are the only local and remote projects with their remote URL.  The
+// local project is then moved or updated in place, preserving its branches
+// and stashes, rather than deleted and created again.
 func computeOperations(localProjects, remoteProjects Projects, gc bool) operations {
 	result := operations{}
+	renames := renamedProjects(localProjects, remoteProjects)
+	renamed := map[ProjectKey]bool{}
+	for _, localKey := range renames {
+		renamed[localKey] = true
+	}
+	for remoteKey, localKey := range changedRemoteProjects(localProjects, remoteProjects) {
+		if _, ok := renames[remoteKey]; ok || renamed[localKey] {
+			continue
+		}
+		renames[remoteKey] = localKey
+		renamed[localKey] = true
+	}
 	allProjects := map[ProjectKey]bool{}
 	for _, p := range localProjects {
-		allProjects[p.Key()] = true
+		if !renamed[p.Key()] {
+			allProjects[p.Key()] = true
+		}
 	}
 	for _, p := range remoteProjects {
 		allProjects[p.Key()] = true
@@ -2205,16 +4842,83 @@ func computeOperations(localProjects, remoteProjects Projects, gc bool) operatio
 		if project, ok := remoteProjects[key]; ok {
 			remote = &project
 		}
+		if localKey, ok := renames[key]; ok {
+			project := localProjects[localKey]
+			local = &project
+		}
 		result = append(result, computeOp(local, remote, gc))
 	}
 	sort.Sort(result)
 	return result
 }
 
+// renamedProjects returns a map from the keys of the remote projects that
+// rename a local project to the keys of the local projects they rename.  A
+// remote project renames a local project if neither key exists on the other
+// side and their remote URL is not used by any other local or remote project;
+// ambiguous matches are ignored.
+func renamedProjects(localProjects, remoteProjects Projects) map[ProjectKey]ProjectKey {
+	localByRemote, remoteByRemote := map[string][]ProjectKey{}, map[string][]ProjectKey{}
+	for key, p := range localProjects {
+		localByRemote[p.Remote] = append(localByRemote[p.Remote], key)
+	}
+	for key, p := range remoteProjects {
+		remoteByRemote[p.Remote] = append(remoteByRemote[p.Remote], key)
+	}
+	renames := map[ProjectKey]ProjectKey{}
+	for remote, localKeys := range localByRemote {
+		remoteKeys := remoteByRemote[remote]
+		if len(localKeys) != 1 || len(remoteKeys) != 1 {
+			continue
+		}
+		localKey, remoteKey := localKeys[0], remoteKeys[0]
+		if _, ok := remoteProjects[localKey]; ok {
+			continue
+		}
+		if _, ok := localProjects[remoteKey]; ok {
+			continue
+		}
+		renames[remoteKey] = localKey
+	}
+	return renames
+}
+
+// changedRemoteProjects returns a map from the keys of the remote projects
+// that change the remote of a local project to the keys of the local projects
+// they change.  A remote project changes the remote of a local project if
+// neither key exists on the other side and they have the same name and path,
+// ignoring the case of the paths, so that the local project is moved rather
+// than deleted on case-insensitive filesystems; ambiguous matches are ignored.
+func changedRemoteProjects(localProjects, remoteProjects Projects) map[ProjectKey]ProjectKey {
+	namePath := func(p Project) string {
+		return p.Name + projectKeySeparator + foldPath(p.Path)
+	}
+	localByNamePath, remoteByNamePath := map[string][]ProjectKey{}, map[string][]ProjectKey{}
+	for key, p := range localProjects {
+		if _, ok := remoteProjects[key]; !ok {
+			localByNamePath[namePath(p)] = append(localByNamePath[namePath(p)], key)
+		}
+	}
+	for key, p := range remoteProjects {
+		if _, ok := localProjects[key]; !ok {
+			remoteByNamePath[namePath(p)] = append(remoteByNamePath[namePath(p)], key)
+		}
+	}
+	changes := map[ProjectKey]ProjectKey{}
+	for namePath, localKeys := range localByNamePath {
+		remoteKeys := remoteByNamePath[namePath]
+		if len(localKeys) != 1 || len(remoteKeys) != 1 {
+			continue
+		}
+		changes[remoteKeys[0]] = localKeys[0]
+	}
+	return changes
+}
+
 func computeOp(local, remote *Project, gc bool) operation {
 	switch {
 	case local == nil && remote != nil:
-		return createOperation{commonOperation{
+		return createOperation{commonOperation: commonOperation{
 			destination: remote.Path,
 			project:     *remote,
 			source:      "",
@@ -2235,12 +4939,14 @@ func computeOp(local, remote *Project, gc bool) operation {
 				project:     *remote,
 				source:      local.Path,
 			}}
-		case local.Revision != remote.Revision:
-			return updateOperation{commonOperation{
+		// The revision of a local project is the revision of its master
+		// branch, so a project pinned to the commit it is at is not updated.
+		case local.Revision != remote.Revision || local.Remote != remote.Remote || local.SparseCheckout != remote.SparseCheckout || local.LFS != remote.LFS:
+			return updateOperation{commonOperation: commonOperation{
 				destination: remote.Path,
 				project:     *remote,
 				source:      local.Path,
-			}}
+			}, lfsChanged: local.LFS != remote.LFS}
 		default:
 			return nullOperation{commonOperation{
 				destination: remote.Path,
@@ -2279,8 +4985,8 @@ func ParseNames(jirix *jiri.X, args []string, defaultProjects map[string]struct{
 	return result, nil
 }
 
-// fmtRevision returns the first 8 chars of a revision hash.
-func fmtRevision(r string) string {
+// FmtRevision returns the first 8 chars of a revision hash.
+func FmtRevision(r string) string {
 	l := 8
 	if len(r) < l {
 		return r
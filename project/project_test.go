@@ -9,16 +9,27 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"v.io/jiri"
+	"v.io/jiri/errkind"
 	"v.io/jiri/gitutil"
 	"v.io/jiri/jiritest"
+	"v.io/jiri/profiles"
 	"v.io/jiri/project"
+	"v.io/jiri/tool"
+	"v.io/x/lib/envvar"
+	"v.io/x/lib/metadata"
 )
 
 func checkReadme(t *testing.T, jirix *jiri.X, p project.Project, message string) {
@@ -169,43 +180,221 @@ func TestLocalProjects(t *testing.T) {
 	checkProjectsMatchPaths(t, foundProjects, projectPaths[1:])
 }
 
-// setupUniverse creates a fake jiri root with 3 remote projects.  Each project
-// has a README with text "initial readme".
-func setupUniverse(t *testing.T) ([]project.Project, *jiritest.FakeJiriRoot, func()) {
-	fake, cleanup := jiritest.NewFakeJiriRoot(t)
-	success := false
-	defer func() {
-		if !success {
-			cleanup()
+// TestLocalProjectsScanIgnore checks that LocalProjects doesn't scan the
+// directories listed in the scan-ignore file.
+func TestLocalProjectsScanIgnore(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	ignore := "# comment\n\n" + localProjects[1].Path + "\n" + filepath.Base(localProjects[2].Path) + "\n"
+	if err := ioutil.WriteFile(fake.X.ScanIgnoreFile(), []byte(ignore), 0644); err != nil {
+		t.Fatal(err)
+	}
+	foundProjects, err := project.LocalProjects(fake.X, project.FullScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProjectsMatchPaths(t, foundProjects, []string{filepath.Join(fake.X.Root, "manifest"), localProjects[0].Path})
+}
+
+// TestLocalProjectsNestedRoot checks that LocalProjects doesn't scan the
+// directories that contain another jiri root, whose projects have paths in
+// that root.
+func TestLocalProjectsNestedRoot(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(fake.X.Root, "nested")
+	if err := os.MkdirAll(filepath.Join(nested, jiri.RootMetaDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(localProjects[2].Path, filepath.Join(nested, filepath.Base(localProjects[2].Path))); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stderr: &stderr})
+	foundProjects, err := project.LocalProjects(fake.X, project.FullScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProjectsMatchPaths(t, foundProjects, []string{filepath.Join(fake.X.Root, "manifest"), localProjects[0].Path, localProjects[1].Path})
+	if want := "not scanning " + nested; !strings.Contains(stderr.String(), want) {
+		t.Errorf("got output %q, want it to contain %q", stderr.String(), want)
+	}
+}
+
+// TestLocalProjectsInvalidMetadata checks that LocalProjects skips the
+// projects whose metadata is truncated or has wrong-cased elements with a
+// warning, rather than failing, and that RepairAllMetadata reconstructs the
+// metadata from the manifest.
+func TestLocalProjectsInvalidMetadata(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	metadataFile := func(p project.Project) string {
+		return filepath.Join(p.Path, jiri.ProjectMetaDir, jiri.ProjectMetaFile)
+	}
+	data, err := ioutil.ReadFile(metadataFile(localProjects[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(metadataFile(localProjects[0]), data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(metadataFile(localProjects[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("<project"), []byte("<Project"), 1)
+	data = bytes.Replace(data, []byte("</project>"), []byte("</Project>"), 1)
+	if err := ioutil.WriteFile(metadataFile(localProjects[1]), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+	foundProjects, err := project.LocalProjects(fake.X, project.FullScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProjectsMatchPaths(t, foundProjects, []string{filepath.Join(fake.X.Root, "manifest"), localProjects[2].Path})
+	for _, want := range []string{"WARNING: skipped 2 local projects", localProjects[0].Path, localProjects[1].Path, "jiri project repair"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("got stderr %q, want it to contain %q", stderr.String(), want)
+		}
+	}
+	// The update refuses to create the projects on top of their checkouts.
+	if err := fake.UpdateUniverse(false); err == nil || !strings.Contains(err.Error(), "jiri project repair") {
+		t.Errorf("got error %v, want it to suggest repairing the project", err)
+	}
+
+	repaired, err := project.RepairAllMetadata(fake.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repairedNames []string
+	for _, p := range repaired {
+		repairedNames = append(repairedNames, p.Name)
+	}
+	if want := []string{localProjects[0].Name, localProjects[1].Name}; !reflect.DeepEqual(repairedNames, want) {
+		t.Errorf("got repaired projects %v, want %v", repairedNames, want)
+	}
+	foundProjects, err = project.LocalProjects(fake.X, project.FullScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProjectsMatchPaths(t, foundProjects, []string{filepath.Join(fake.X.Root, "manifest"), localProjects[0].Path, localProjects[1].Path, localProjects[2].Path})
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRepairMetadata checks that RepairMetadata reconstructs the metadata of
+// a project whose metadata directory was deleted by matching its git remote
+// against the manifest, and fails for a repository that is not in the
+// manifest.
+func TestRepairMetadata(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(localProjects[1].Path, jiri.ProjectMetaDir)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := project.RepairMetadata(fake.X, localProjects[1].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != localProjects[1].Name || p.Path != localProjects[1].Path {
+		t.Errorf("got project %q in %q, want %q in %q", p.Name, p.Path, localProjects[1].Name, localProjects[1].Path)
+	}
+	if got, err := project.ProjectAtPath(fake.X, localProjects[1].Path); err != nil || got.Key() != localProjects[1].Key() {
+		t.Errorf("got project %v (%v), want key %v", got.Key(), err, localProjects[1].Key())
+	}
+
+	git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(localProjects[2].Path))
+	if err := git.SetRemoteUrl("origin", "https://example.com/unknown"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := project.RepairMetadata(fake.X, localProjects[2].Path); err == nil || !strings.Contains(err.Error(), "no project in the manifest") {
+		t.Errorf("got error %v, want no matching project", err)
+	}
+}
+
+// TestLocalProjectsRevision checks that LocalProjects reports the current
+// revision of the master branch, even after it moves.
+func TestLocalProjectsRevision(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkRevision := func() {
+		git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(localProjects[1].Path))
+		want, err := git.CurrentRevisionOfBranch("master")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, scanMode := range []project.ScanMode{project.FastScan, project.FullScan} {
+			projects, err := project.LocalProjects(fake.X, scanMode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := projects.FindUnique(localProjects[1].Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.Revision; got != want {
+				t.Errorf("scan mode %v: got revision %v, want %v", scanMode, got, want)
+			}
 		}
-	}()
+	}
+	checkRevision()
+	// Move master in the local project, both by committing and by resetting,
+	// and check that the cached revision is not used.
+	writeReadme(t, fake.X, localProjects[1].Path, "new commit")
+	checkRevision()
+	git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(localProjects[1].Path))
+	if err := git.Reset("HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+	checkRevision()
+	// Pack the refs, which removes the loose master ref.
+	if err := fake.X.NewSeq().Dir(localProjects[1].Path).Last("git", "pack-refs", "--all"); err != nil {
+		t.Fatal(err)
+	}
+	checkRevision()
+}
 
+// setupUniverse creates a fake jiri root with 3 remote projects.  Each project
+// has a README with text "initial readme".
+func setupUniverse(t *testing.T) ([]project.Project, *jiritest.FakeUniverse, func()) {
 	// Create some projects and add them to the remote manifest.
 	numProjects := 3
-	localProjects := []project.Project{}
+	projects := []project.Project{}
 	for i := 0; i < numProjects; i++ {
-		name := projectName(i)
-		path := fmt.Sprintf("path-%d", i)
-		if err := fake.CreateRemoteProject(name); err != nil {
+		projects = append(projects, project.Project{
+			Name: projectName(i),
+			Path: fmt.Sprintf("path-%d", i),
+		})
+	}
+	fake, cleanup := jiritest.NewFakeUniverse(t, projects...)
+	localProjects := []project.Project{}
+	for _, p := range projects {
+		p, err := fake.RemoteProject(p.Name)
+		if err != nil {
+			cleanup()
 			t.Fatal(err)
 		}
-		p := project.Project{
-			Name:   name,
-			Path:   filepath.Join(fake.X.Root, path),
-			Remote: fake.Projects[name],
-		}
 		localProjects = append(localProjects, p)
-		if err := fake.AddProject(p); err != nil {
-			t.Fatal(err)
-		}
-	}
-
-	// Create initial commit in each repo.
-	for _, remoteProjectDir := range fake.Projects {
-		writeReadme(t, fake.X, remoteProjectDir, "initial readme")
 	}
-
-	success = true
 	return localProjects, fake, cleanup
 }
 
@@ -230,6 +419,45 @@ func TestUpdateUniverseSimple(t *testing.T) {
 	}
 }
 
+// TestUpdateUniverseSummary checks that UpdateUniverse prints a summary of
+// the update, and only the summary if SummaryOnlyOpt is set.
+func TestUpdateUniverseSummary(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	if err := project.UpdateUniverse(fake.X, false, project.SummaryOnlyOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "projects: 3 created"; !strings.Contains(got, want) {
+		t.Errorf("got output %q, want it to contain %q", got, want)
+	}
+	if got := stdout.String(); strings.Contains(got, "create project") {
+		t.Errorf("got output %q, want only the summary", got)
+	}
+
+	// Check that revision changes are listed, and that the other projects,
+	// including the manifest project, are unchanged.
+	stdout.Reset()
+	revision, err := fake.AddCommit(localProjects[0].Name, "README", "new readme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateUniverse(fake.X, false, project.SummaryOnlyOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"1 updated",
+		"3 unchanged",
+		fmt.Sprintf("%s: ", localProjects[0].Name),
+		" -> " + revision[:7],
+	} {
+		if got := stdout.String(); !strings.Contains(got, want) {
+			t.Errorf("got output %q, want it to contain %q", got, want)
+		}
+	}
+}
+
 // TestUpdateUniverseWithRevision checks that UpdateUniverse will pull remote
 // projects at the specified revision.
 func TestUpdateUniverseWithRevision(t *testing.T) {
@@ -276,6 +504,72 @@ func TestUpdateUniverseWithRevision(t *testing.T) {
 	}
 }
 
+// TestUpdateUniversePinnedRevisionNoFetch checks that a project pinned to the
+// full revision of a commit that exists locally is reset to it without
+// fetching from its remote, and that abbreviated revisions are still fetched.
+func TestUpdateUniversePinnedRevisionNoFetch(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Advance project 1, so that its local repository has both revisions.
+	remoteDir := fake.Projects[localProjects[1].Name]
+	rev, err := gitutil.New(s, gitutil.RootDirOpt(remoteDir)).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, remoteDir, "new revision")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, localProjects[1], "new revision")
+
+	// Pin project 1 to its first revision, and make its remote unreachable,
+	// so that fetching it fails.
+	pin := func(revision string) {
+		m, err := fake.ReadRemoteManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		projects := []project.Project{}
+		for _, p := range m.Projects {
+			if p.Name == localProjects[1].Name {
+				p.Revision = revision
+			}
+			projects = append(projects, p)
+		}
+		m.Projects = projects
+		if err := fake.WriteRemoteManifest(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pin(rev)
+	if err := s.Rename(remoteDir, remoteDir+".moved").Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatalf("update of a project pinned to a local commit failed: %v", err)
+	}
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+
+	// Check that an abbreviated revision is fetched, even though it names
+	// a local commit.
+	pin(rev[:12])
+	if err := fake.UpdateUniverse(false); err == nil {
+		t.Fatalf("expected the update of a project pinned to an abbreviated revision to fetch from its missing remote and fail")
+	}
+	if err := s.Rename(remoteDir+".moved", remoteDir).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+}
+
 // TestUpdateUniverseWithUncommitted checks that uncommitted files are not droped
 // by UpdateUniverse(). This ensures that the "git reset --hard" mechanism used
 // for pointing the master branch to a fixed revision does not lose work in
@@ -304,108 +598,320 @@ func TestUpdateUniverseWithUncommitted(t *testing.T) {
 	}
 }
 
-// TestUpdateUniverseMovedProject checks that UpdateUniverse can move a
-// project.
-func TestUpdateUniverseMovedProject(t *testing.T) {
+// TestApplyToLocalMasterInterrupted checks that if jiri is interrupted while
+// ApplyToLocalMaster is running its function, the cleanup functions restore
+// the original branch and pop the stashed changes, and that they are not run
+// again once the function returns.
+func TestApplyToLocalMasterInterrupted(t *testing.T) {
 	localProjects, fake, cleanup := setupUniverse(t)
 	defer cleanup()
-	s := fake.X.NewSeq()
 	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
 
-	// Update the local path at which project 1 is located.
-	m, err := fake.ReadRemoteManifest()
-	if err != nil {
+	// Put project 0 on a feature branch with uncommitted changes.
+	p := localProjects[0]
+	p.Protocol = "git"
+	git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(p.Path))
+	if err := git.CreateAndCheckoutBranch("feature"); err != nil {
 		t.Fatal(err)
 	}
-	oldProjectPath := localProjects[1].Path
-	localProjects[1].Path = filepath.Join(fake.X.Root, "new-project-path")
-	projects := []project.Project{}
-	for _, p := range m.Projects {
-		if p.Name == localProjects[1].Name {
-			p.Path = localProjects[1].Path
-		}
-		projects = append(projects, p)
-	}
-	m.Projects = projects
-	if err := fake.WriteRemoteManifest(m); err != nil {
+	file, want := filepath.Join(p.Path, "README"), []byte("work in progress")
+	if err := ioutil.WriteFile(file, want, 0644); err != nil {
 		t.Fatal(err)
 	}
-	// Check that UpdateUniverse() moves the local copy of the project 1.
-	if err := fake.UpdateUniverse(false); err != nil {
-		t.Fatal(err)
+	checkState := func() {
+		branch, err := git.CurrentBranchName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := branch, "feature"; got != want {
+			t.Errorf("got branch %v, want %v", got, want)
+		}
+		got, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(got, want) != 0 {
+			t.Errorf("got %s, want %s", got, want)
+		}
 	}
-	if err := s.AssertDirExists(oldProjectPath).Done(); err == nil {
-		t.Fatalf("expected project %q at path %q not to exist but it did", localProjects[1].Name, oldProjectPath)
+
+	errAborted := fmt.Errorf("aborted")
+	err := project.ApplyToLocalMaster(fake.X, project.Projects{p.Key(): p}, func() error {
+		branch, err := git.CurrentBranchName()
+		if err != nil {
+			return err
+		}
+		if got, want := branch, "master"; got != want {
+			t.Errorf("got branch %v, want %v", got, want)
+		}
+		// Simulate the signal handler running while the function is in
+		// progress.
+		if err := fake.X.RunCleanups(); err != nil {
+			return err
+		}
+		checkState()
+		return errAborted
+	})
+	if err != errAborted {
+		t.Fatalf("got error %v, want %v", err, errAborted)
 	}
-	if err := s.AssertDirExists(localProjects[2].Path).Done(); err != nil {
-		t.Fatalf("expected project %q at path %q to exist but it did not", localProjects[1].Name, localProjects[1].Path)
+	checkState()
+	if size, err := git.StashSize(); err != nil || size != 0 {
+		t.Errorf("got stash size %v, err %v, want 0", size, err)
 	}
-	checkReadme(t, fake.X, localProjects[1], "initial readme")
 }
 
-// TestUpdateUniverseDeletedProject checks that UpdateUniverse will delete a
-// project iff gc=true.
-func TestUpdateUniverseDeletedProject(t *testing.T) {
+// TestCleanupProjectsBackup checks that CleanupProjects backs up the local
+// changes and the deleted branches of projects, that the backups can be
+// restored, and that old backups are pruned.
+func TestCleanupProjectsBackup(t *testing.T) {
 	localProjects, fake, cleanup := setupUniverse(t)
 	defer cleanup()
-	s := fake.X.NewSeq()
 	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
+	s := fake.X.NewSeq()
 
-	// Delete project 1.
-	m, err := fake.ReadRemoteManifest()
-	if err != nil {
+	// Create a feature branch with a commit in project 1, and an untracked
+	// file on its master branch.
+	p := localProjects[1]
+	git := gitutil.New(s, gitutil.RootDirOpt(p.Path))
+	if err := git.CreateAndCheckoutBranch("feature"); err != nil {
 		t.Fatal(err)
 	}
-	projects := []project.Project{}
-	for _, p := range m.Projects {
-		if p.Name == localProjects[1].Name {
-			continue
-		}
-		projects = append(projects, p)
-	}
-	m.Projects = projects
-	if err := fake.WriteRemoteManifest(m); err != nil {
+	writeReadme(t, fake.X, p.Path, "feature readme")
+	if err := git.CheckoutBranch("master"); err != nil {
 		t.Fatal(err)
 	}
-	// Check that UpdateUniverse() with gc=false does not delete the local copy
-	// of the project.
-	if err := fake.UpdateUniverse(false); err != nil {
+	untracked, want := filepath.Join(p.Path, "untracked_file"), []byte("work in progress")
+	if err := ioutil.WriteFile(untracked, want, 0644); err != nil {
 		t.Fatal(err)
 	}
-	if err := s.AssertDirExists(localProjects[1].Path).Done(); err != nil {
-		t.Fatalf("expected project %q at path %q to exist but it did not", localProjects[1].Name, localProjects[1].Path)
+	projects := project.Projects{}
+	for _, p := range localProjects {
+		projects[p.Key()] = p
 	}
-	checkReadme(t, fake.X, localProjects[1], "initial readme")
-	// Check that UpdateUniverse() with gc=true does delete the local copy of
-	// the project.
-	if err := fake.UpdateUniverse(true); err != nil {
+	if err := project.CleanupProjects(fake.X, projects, true); err != nil {
 		t.Fatal(err)
 	}
-	if err := s.AssertDirExists(localProjects[1].Path).Done(); err == nil {
-		t.Fatalf("expected project %q at path %q not to exist but it did", localProjects[1].Name, localProjects[3].Path)
+	if _, err := os.Stat(untracked); !os.IsNotExist(err) {
+		t.Fatalf("got error %v for %s, want it not to exist", err, untracked)
 	}
-}
-
-// TestUpdateUniverseNewProjectSamePath checks that UpdateUniverse can handle a
-// new project with the same path as a deleted project, but a different path.
-func TestUpdateUniverseNewProjectSamePath(t *testing.T) {
-	localProjects, fake, cleanup := setupUniverse(t)
-	defer cleanup()
-	if err := fake.UpdateUniverse(false); err != nil {
-		t.Fatal(err)
+	if git.BranchExists("feature") {
+		t.Fatalf("branch feature was not deleted")
 	}
 
-	// Delete a project 1 and create a new one with a different name but the
-	// same path.
-	m, err := fake.ReadRemoteManifest()
+	// Only project 1 was backed up, and its backups share the same ID.
+	backups, err := project.ListBackups(fake.X, projects)
 	if err != nil {
 		t.Fatal(err)
 	}
-	newProjectName := "new-project-name"
+	if got, want := len(backups), 2; got != want {
+		t.Fatalf("got %d backups, want %d: %v", got, want, backups)
+	}
+	for _, backup := range backups {
+		if got, want := backup.Project.Name, p.Name; got != want {
+			t.Errorf("got backup of project %v, want %v", got, want)
+		}
+		if got, want := backup.ID, backups[0].ID; got != want {
+			t.Errorf("got backup ID %v, want %v", got, want)
+		}
+	}
+	checkMetadataIsIgnored(t, fake.X, p)
+	if dirty, err := git.HasUntrackedFiles(); err != nil || dirty {
+		t.Errorf("got untracked files %v, err %v, want none", dirty, err)
+	}
+
+	// Restoring the backups brings back the untracked file and the branch.
+	if err := project.RestoreBackup(fake.X, projects, backups[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(untracked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(got, want) != 0 {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if !git.BranchExists("feature") {
+		t.Errorf("branch feature was not restored")
+	}
+	if err := project.RestoreBackup(fake.X, projects, "20000101-000000"); err == nil {
+		t.Errorf("restoring a missing backup did not fail")
+	}
+
+	// Backups older than the maximum age are deleted, and no backups are
+	// created with NoBackupOpt.
+	bundle := backups[1].Bundle
+	old := filepath.Join(filepath.Dir(bundle), "20000101-000000.bundle")
+	if err := s.Rename(bundle, old).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.CleanupProjects(fake.X, projects, true, project.NoBackupOpt(true), project.BackupMaxAgeOpt(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	backups, err = project.ListBackups(fake.X, projects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(backups), 1; got != want || backups[0].Stash == "" {
+		t.Fatalf("got backups %v, want only the stash entry", backups)
+	}
+	if _, err := os.Stat(untracked); !os.IsNotExist(err) {
+		t.Errorf("got error %v for %s, want it not to exist", err, untracked)
+	}
+}
+
+// TestUpdateUniverseMovedProject checks that UpdateUniverse can move a
+// project.
+func TestUpdateUniverseMovedProject(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Update the local path at which project 1 is located.
+	oldProjectPath := localProjects[1].Path
+	localProjects[1].Path = filepath.Join(fake.X.Root, "new-project-path")
+	if err := fake.MoveProject(localProjects[1].Name, localProjects[1].Path); err != nil {
+		t.Fatal(err)
+	}
+	// Check that UpdateUniverse() moves the local copy of the project 1.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertDirExists(oldProjectPath).Done(); err == nil {
+		t.Fatalf("expected project %q at path %q not to exist but it did", localProjects[1].Name, oldProjectPath)
+	}
+	if err := s.AssertDirExists(localProjects[2].Path).Done(); err != nil {
+		t.Fatalf("expected project %q at path %q to exist but it did not", localProjects[1].Name, localProjects[1].Path)
+	}
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+}
+
+// TestUpdateUniverseReferenceDir checks that UpdateUniverse clones new
+// projects using the mirrors created by MirrorProjects, and falls back to
+// normal clones if a mirror is missing or corrupt.
+func TestUpdateUniverseReferenceDir(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	// The projects of remote imports are only mirrored once they have been
+	// fetched by an update.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	mirrorDir := filepath.Join(fake.X.Root, ".mirrors")
+	if err := project.MirrorProjects(fake.X, mirrorDir); err != nil {
+		t.Fatal(err)
+	}
+	mirror := func(i int) string { return project.MirrorPath(mirrorDir, localProjects[i]) }
+	for i := range localProjects {
+		if _, err := os.Stat(filepath.Join(mirror(i), "HEAD")); err != nil {
+			t.Fatalf("mirror of project %q was not created: %v", localProjects[i].Name, err)
+		}
+	}
+
+	// Refreshing the mirrors fetches new commits.
+	revision, err := fake.AddCommit(localProjects[0].Name, "README", "new readme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := project.MirrorProjects(fake.X, mirrorDir); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(mirror(0))).CurrentRevision(); err != nil || got != revision {
+		t.Errorf("got mirror revision %v, err %v, want %v", got, err, revision)
+	}
+
+	// Corrupt the mirror of project 1 and delete the mirror of project 2.
+	if err := ioutil.WriteFile(filepath.Join(mirror(1), "HEAD"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(mirror(2)); err != nil {
+		t.Fatal(err)
+	}
+	// Delete the local projects, so that the update clones them again.
+	for _, p := range localProjects {
+		if err := os.RemoveAll(p.Path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := project.UpdateUniverse(fake.X, false, project.ReferenceDirOpt(mirrorDir)); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, localProjects[0], "new readme")
+	for i, p := range localProjects {
+		if i > 0 {
+			checkReadme(t, fake.X, p, "initial readme")
+		}
+		_, err := os.Stat(filepath.Join(p.Path, ".git", "objects", "info", "alternates"))
+		if got, want := err == nil, i == 0; got != want {
+			t.Errorf("project %q: got alternates %v, want %v", p.Name, got, want)
+		}
+	}
+}
+
+// TestUpdateUniverseDeletedProject checks that UpdateUniverse will delete a
+// project iff gc=true.
+func TestUpdateUniverseDeletedProject(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete project 1.
+	if err := fake.DeleteProject(localProjects[1].Name); err != nil {
+		t.Fatal(err)
+	}
+	// Check that UpdateUniverse() with gc=false does not delete the local copy
+	// of the project.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertDirExists(localProjects[1].Path).Done(); err != nil {
+		t.Fatalf("expected project %q at path %q to exist but it did not", localProjects[1].Name, localProjects[1].Path)
+	}
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+	// Check that UpdateUniverse() with gc=true does delete the local copy of
+	// the project.
+	if err := fake.UpdateUniverse(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertDirExists(localProjects[1].Path).Done(); err == nil {
+		t.Fatalf("expected project %q at path %q not to exist but it did", localProjects[1].Name, localProjects[3].Path)
+	}
+	// Check that the deletion is recorded in the audit log.
+	entries, err := fake.X.AuditLog(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != "delete project" || !strings.Contains(entries[0].Details, localProjects[1].Path) {
+		t.Errorf("got audit log %v, want the deletion of project %q", entries, localProjects[1].Name)
+	}
+}
+
+// TestUpdateUniverseNewProjectSamePath checks that UpdateUniverse can handle a
+// new project with the same path as a deleted project, but a different path.
+func TestUpdateUniverseNewProjectSamePath(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete a project 1 and create a new one with a different name but the
+	// same path.
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProjectName := "new-project-name"
 	projects := []project.Project{}
 	for _, p := range m.Projects {
 		if p.Path == localProjects[1].Path {
@@ -465,329 +971,2796 @@ func TestUpdateUniverseRemoteBranch(t *testing.T) {
 	checkReadme(t, fake.X, localProjects[1], "non-master commit")
 }
 
-func TestFileImportCycle(t *testing.T) {
-	jirix, cleanup := jiritest.NewX(t)
+// TestUpdateUniverseMissingRemoteBranch checks that UpdateUniverse tracks the
+// fallback branch of a project whose remote branch is missing, and that a
+// project without a usable fallback branch fails the update with an error
+// listing the branches of its remote, without stopping the update of the
+// other projects.
+func TestUpdateUniverseMissingRemoteBranch(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
 	defer cleanup()
-
-	// Set up the cycle .jiri_manifest -> A -> B -> A
-	jiriManifest := project.Manifest{
-		LocalImports: []project.LocalImport{
-			{File: "A"},
-		},
-	}
-	manifestA := project.Manifest{
-		LocalImports: []project.LocalImport{
-			{File: "B"},
-		},
-	}
-	manifestB := project.Manifest{
-		LocalImports: []project.LocalImport{
-			{File: "A"},
-		},
-	}
-	if err := jiriManifest.ToFile(jirix, jirix.JiriManifestFile()); err != nil {
+	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
-	if err := manifestA.ToFile(jirix, filepath.Join(jirix.Root, "A")); err != nil {
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "fallback readme")
+	writeReadme(t, fake.X, fake.Projects[localProjects[2].Name], "new readme")
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
 		t.Fatal(err)
 	}
-	if err := manifestB.ToFile(jirix, filepath.Join(jirix.Root, "B")); err != nil {
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		switch p.Name {
+		case localProjects[0].Name:
+			p.RemoteBranch = "gone"
+		case localProjects[1].Name:
+			p.RemoteBranch = "gone"
+			p.FallbackBranch = "master"
+		}
+		projects = append(projects, p)
+	}
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
 		t.Fatal(err)
 	}
 
-	// The update should complain about the cycle.
-	err := project.UpdateUniverse(jirix, false)
-	if got, want := fmt.Sprint(err), "import cycle detected in local manifest files"; !strings.Contains(got, want) {
-		t.Errorf("got error %v, want substr %v", got, want)
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+	err = project.UpdateUniverse(fake.X, false)
+	if err == nil {
+		t.Fatalf("expected the update of a project whose remote branch is missing to fail")
+	}
+	if got, want := errkind.Of(err), errkind.ManifestError; got != want {
+		t.Errorf("got error kind %v, want %v", got, want)
+	}
+	for _, want := range []string{
+		fmt.Sprintf(`remote branch "gone" of project %q does not exist`, localProjects[0].Name),
+		"the branches of the remote are: master.",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %q, want it to contain %q", err, want)
+		}
+	}
+	if want := `tracking its fallback branch "master"`; !strings.Contains(stderr.String(), want) {
+		t.Errorf("got stderr %q, want it to contain %q", stderr.String(), want)
 	}
+	if want := fmt.Sprintf("%s: gone", localProjects[0].Name); !strings.Contains(stdout.String(), want) {
+		t.Errorf("got summary %q, want it to contain %q", stdout.String(), want)
+	}
+	checkReadme(t, fake.X, localProjects[1], "fallback readme")
+	checkReadme(t, fake.X, localProjects[2], "new readme")
 }
 
-func TestRemoteImportCycle(t *testing.T) {
-	fake, cleanup := jiritest.NewFakeJiriRoot(t)
-	defer cleanup()
-
-	// Set up two remote manifest projects, remote1 and remote1.
-	if err := fake.CreateRemoteProject("remote1"); err != nil {
+// setProjectRemote points the project with the given name in the remote
+// manifest to the given remote.
+func setProjectRemote(t *testing.T, fake *jiritest.FakeUniverse, name, remote string) {
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
 		t.Fatal(err)
 	}
-	if err := fake.CreateRemoteProject("remote2"); err != nil {
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		if p.Name == name {
+			p.Remote = remote
+		}
+		projects = append(projects, p)
+	}
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
 		t.Fatal(err)
 	}
-	remote1 := fake.Projects["remote1"]
-	remote2 := fake.Projects["remote2"]
-
-	fileA, fileB := filepath.Join(remote1, "A"), filepath.Join(remote2, "B")
+}
 
-	// Set up the cycle .jiri_manifest -> remote1+A -> remote2+B -> remote1+A
-	jiriManifest := project.Manifest{
-		Imports: []project.Import{
-			{Manifest: "A", Name: "n1", Remote: remote1},
-		},
-	}
-	manifestA := project.Manifest{
-		Imports: []project.Import{
-			{Manifest: "B", Name: "n2", Remote: remote2},
-		},
+// TestUpdateUniverseRemoteChange checks that UpdateUniverse follows a project
+// to its new remote if the new remote shares history with the local master
+// branch, and otherwise fails unless ForceRemoteChangeOpt is set, in which
+// case the project is cloned again and the old checkout is kept.
+func TestUpdateUniverseRemoteChange(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
 	}
-	manifestB := project.Manifest{
-		Imports: []project.Import{
-			{Manifest: "A", Name: "n3", Remote: remote1},
-		},
+	remoteUrl := func(p project.Project) string {
+		url, err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(p.Path)).RemoteUrl("origin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return url
 	}
-	if err := jiriManifest.ToFile(fake.X, fake.X.JiriManifestFile()); err != nil {
+
+	// Move the remote of project 0 to a new location.
+	movedRemote := fake.Projects[localProjects[0].Name] + "-moved"
+	if err := gitutil.New(fake.X.NewSeq()).Clone(fake.Projects[localProjects[0].Name], movedRemote); err != nil {
 		t.Fatal(err)
 	}
-	if err := manifestA.ToFile(fake.X, fileA); err != nil {
+	writeReadme(t, fake.X, movedRemote, "moved commit")
+	setProjectRemote(t, fake, localProjects[0].Name, movedRemote)
+	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
-	if err := manifestB.ToFile(fake.X, fileB); err != nil {
+	checkReadme(t, fake.X, localProjects[0], "moved commit")
+	if got, want := remoteUrl(localProjects[0]), movedRemote; got != want {
+		t.Errorf("got remote %q, want %q", got, want)
+	}
+	if want := "changed from"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+	}
+
+	// Replace the remote of project 1 with an unrelated repository.
+	oldRemote := localProjects[1].Remote
+	if err := fake.CreateRemoteProject("unrelated"); err != nil {
 		t.Fatal(err)
 	}
-	commitFile(t, fake.X, remote1, fileA, "commit A")
-	commitFile(t, fake.X, remote2, fileB, "commit B")
+	writeReadme(t, fake.X, fake.Projects["unrelated"], "unrelated commit")
+	setProjectRemote(t, fake, localProjects[1].Name, fake.Projects["unrelated"])
+	err := fake.UpdateUniverse(false)
+	if want := "appears to have been replaced"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v, want it to contain %q", err, want)
+	}
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+	if got, want := remoteUrl(localProjects[1]), oldRemote; got != want {
+		t.Errorf("got remote %q, want %q", got, want)
+	}
 
-	// The update should complain about the cycle.
-	err := project.UpdateUniverse(fake.X, false)
-	if got, want := fmt.Sprint(err), "import cycle detected in remote manifest imports"; !strings.Contains(got, want) {
-		t.Errorf("got error %v, want substr %v", got, want)
+	// Check that the project is cloned again if the change is forced.
+	if err := project.UpdateUniverse(fake.X, false, project.ForceRemoteChangeOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, localProjects[1], "unrelated commit")
+	if got, want := remoteUrl(localProjects[1]), fake.Projects["unrelated"]; got != want {
+		t.Errorf("got remote %q, want %q", got, want)
 	}
+	oldCheckout := localProjects[1]
+	oldCheckout.Path += ".old"
+	checkReadme(t, fake.X, oldCheckout, "initial readme")
 }
 
-func TestFileAndRemoteImportCycle(t *testing.T) {
-	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+// TestUpdateUniverseRebaseTracked checks that UpdateUniverse rebases the
+// current branches of the projects onto the updated master branches if
+// RebaseTrackedOpt is set, skipping projects with uncommitted changes and
+// leaving branches with conflicts unchanged.
+func TestUpdateUniverseRebaseTracked(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
 	defer cleanup()
-
-	// Set up two remote manifest projects, remote1 and remote2.
-	// Set up two remote manifest projects, remote1 and remote1.
-	if err := fake.CreateRemoteProject("remote1"); err != nil {
-		t.Fatal(err)
-	}
-	if err := fake.CreateRemoteProject("remote2"); err != nil {
+	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
-	remote1 := fake.Projects["remote1"]
-	remote2 := fake.Projects["remote2"]
-	fileA, fileD := filepath.Join(remote1, "A"), filepath.Join(remote1, "D")
-	fileB, fileC := filepath.Join(remote2, "B"), filepath.Join(remote2, "C")
-
-	// Set up the cycle .jiri_manifest -> remote1+A -> remote2+B -> C -> remote1+D -> A
-	jiriManifest := project.Manifest{
-		Imports: []project.Import{
-			{Manifest: "A", Root: "r1", Name: "n1", Remote: remote1},
-		},
+	s := fake.X.NewSeq()
+	gits := []*gitutil.Git{}
+	for _, p := range localProjects {
+		git := gitutil.New(s, gitutil.RootDirOpt(p.Path))
+		if err := git.CreateAndCheckoutBranch("feature"); err != nil {
+			t.Fatal(err)
+		}
+		gits = append(gits, git)
 	}
-	manifestA := project.Manifest{
-		Imports: []project.Import{
-			{Manifest: "B", Root: "r2", Name: "n2", Remote: remote2},
-		},
+	// Project 0 has a commit that rebases cleanly, project 1 a commit that
+	// conflicts with the update, and project 2 uncommitted changes.
+	featureFile := filepath.Join(localProjects[0].Path, "feature")
+	if err := ioutil.WriteFile(featureFile, []byte("feature"), 0644); err != nil {
+		t.Fatal(err)
 	}
-	manifestB := project.Manifest{
-		LocalImports: []project.LocalImport{
-			{File: "C"},
-		},
+	commitFile(t, fake.X, localProjects[0].Path, featureFile, "feature commit")
+	writeReadme(t, fake.X, localProjects[1].Path, "conflicting commit")
+	if err := ioutil.WriteFile(filepath.Join(localProjects[2].Path, "README"), []byte("uncommitted"), 0644); err != nil {
+		t.Fatal(err)
 	}
-	manifestC := project.Manifest{
-		Imports: []project.Import{
-			{Manifest: "D", Root: "r3", Name: "n3", Remote: remote1},
-		},
+	conflictRevision, err := gits[1].CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
 	}
-	manifestD := project.Manifest{
-		LocalImports: []project.LocalImport{
-			{File: "A"},
-		},
+	for _, p := range localProjects {
+		writeReadme(t, fake.X, fake.Projects[p.Name], "updated readme")
 	}
-	if err := jiriManifest.ToFile(fake.X, fake.X.JiriManifestFile()); err != nil {
+
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	if err := project.UpdateUniverse(fake.X, false, project.RebaseTrackedOpt(true)); err != nil {
 		t.Fatal(err)
 	}
-	if err := manifestA.ToFile(fake.X, fileA); err != nil {
+	for i, git := range gits {
+		if branch, err := git.CurrentBranchName(); err != nil || branch != "feature" {
+			t.Errorf("project %d: got branch %q (%v), want %q", i, branch, err, "feature")
+		}
+	}
+	master, err := gits[0].CurrentRevisionOfBranch("master")
+	if err != nil {
 		t.Fatal(err)
 	}
-	if err := manifestB.ToFile(fake.X, fileB); err != nil {
+	if base, err := gits[0].MergeBase("feature", "master"); err != nil || base != master {
+		t.Errorf("got merge base %q (%v), want %q", base, err, master)
+	}
+	if got, err := gits[1].CurrentRevision(); err != nil || got != conflictRevision {
+		t.Errorf("got revision %q (%v), want %q", got, err, conflictRevision)
+	}
+	for _, want := range []string{
+		"rebased branches:\n    " + localProjects[0].Name + ": feature\n",
+		localProjects[1].Name + ": feature (conflicts, left unchanged)",
+		localProjects[2].Name + ": feature (uncommitted changes, skipped)",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+		}
+	}
+}
+
+// TestUpdateUniverseInProgress checks that the project states report the
+// stashes and the git operations in progress in the local projects, and that
+// UpdateUniverse leaves the projects with an operation in progress unchanged
+// and lists them.
+func TestUpdateUniverseInProgress(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
-	if err := manifestC.ToFile(fake.X, fileC); err != nil {
+	s := fake.X.NewSeq()
+	// Give each project a branch that conflicts with its master branch, then
+	// leave project 0 in a merge, with a stash, project 1 in a rebase and
+	// project 2 in a cherry-pick of the branch.
+	gits := []*gitutil.Git{}
+	for _, p := range localProjects {
+		git := gitutil.New(s, gitutil.RootDirOpt(p.Path))
+		if err := git.CreateAndCheckoutBranch("other"); err != nil {
+			t.Fatal(err)
+		}
+		writeReadme(t, fake.X, p.Path, "other readme")
+		if err := git.CheckoutBranch("master"); err != nil {
+			t.Fatal(err)
+		}
+		writeReadme(t, fake.X, p.Path, "local readme")
+		gits = append(gits, git)
+	}
+	if err := ioutil.WriteFile(filepath.Join(localProjects[0].Path, "README"), []byte("stashed"), 0644); err != nil {
 		t.Fatal(err)
 	}
-	if err := manifestD.ToFile(fake.X, fileD); err != nil {
+	if _, err := gits[0].Stash(); err != nil {
 		t.Fatal(err)
 	}
-	commitFile(t, fake.X, remote1, fileA, "commit A")
-	commitFile(t, fake.X, remote2, fileB, "commit B")
-	commitFile(t, fake.X, remote2, fileC, "commit C")
-	commitFile(t, fake.X, remote1, fileD, "commit D")
-
-	// The update should complain about the cycle.
-	err := project.UpdateUniverse(fake.X, false)
-	if got, want := fmt.Sprint(err), "import cycle detected"; !strings.Contains(got, want) {
-		t.Errorf("got error %v, want substr %v", got, want)
+	if err := exec.Command("git", "-C", localProjects[0].Path, "merge", "other").Run(); err == nil {
+		t.Fatalf("merge did not conflict")
+	}
+	if err := gits[1].CheckoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gits[1].Rebase("master"); err == nil {
+		t.Fatalf("rebase did not conflict")
+	}
+	if err := exec.Command("git", "-C", localProjects[2].Path, "cherry-pick", "other").Run(); err == nil {
+		t.Fatalf("cherry-pick did not conflict")
 	}
-}
-
-// TestUnsupportedProtocolErr checks that calling
-// UnsupportedPrototoclErr.Error() does not result in an infinite loop.
-func TestUnsupportedPrototocolErr(t *testing.T) {
-	err := project.UnsupportedProtocolErr("foo")
-	_ = err.Error()
-}
 
-type binDirTest struct {
-	Name        string
-	Setup       func(old, new string) error
-	Teardown    func(old, new string) error
-	Error       string
-	CheckBackup bool
-}
+	states, err := project.GetProjectStates(fake.X, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		operation string
+		stashes   int
+	}{{"merge", 1}, {"rebase", 0}, {"cherry-pick", 0}} {
+		state := states[localProjects[i].Key()]
+		if state.InProgressOperation != want.operation || state.Stashes != want.stashes {
+			t.Errorf("project %d: got operation %q and %d stashes, want %q and %d", i, state.InProgressOperation, state.Stashes, want.operation, want.stashes)
+		}
+	}
 
-func TestTransitionBinDir(t *testing.T) {
-	tests := []binDirTest{
-		{
-			"No old dir",
-			func(old, new string) error { return nil },
-			nil,
-			"",
-			false,
-		},
-		{
-			"Empty old dir",
-			func(old, new string) error {
-				return os.MkdirAll(old, 0777)
-			},
-			nil,
-			"",
-			true,
-		},
-		{
-			"Populated old dir",
-			func(old, new string) error {
-				if err := os.MkdirAll(old, 0777); err != nil {
-					return err
-				}
-				return ioutil.WriteFile(filepath.Join(old, "tool"), []byte("foo"), 0777)
-			},
-			nil,
-			"",
-			true,
-		},
-		{
-			"Symlinked old dir",
-			func(old, new string) error {
-				if err := os.MkdirAll(filepath.Dir(old), 0777); err != nil {
-					return err
-				}
-				return os.Symlink(new, old)
-			},
-			nil,
-			"",
-			false,
-		},
-		{
-			"Symlinked old dir pointing elsewhere",
-			func(old, new string) error {
-				if err := os.MkdirAll(filepath.Dir(old), 0777); err != nil {
-					return err
-				}
-				return os.Symlink(filepath.Dir(new), old)
-			},
-			nil,
-			"",
-			true,
-		},
-		{
-			"Unreadable old dir parent",
-			func(old, new string) error {
-				if err := os.MkdirAll(old, 0777); err != nil {
-					return err
-				}
-				return os.Chmod(filepath.Dir(old), 0222)
-			},
-			func(old, new string) error {
-				return os.Chmod(filepath.Dir(old), 0777)
-			},
-			"Failed to stat old bin dir",
-			false,
-		},
-		{
-			"Unwritable old dir",
-			func(old, new string) error {
-				if err := os.MkdirAll(old, 0777); err != nil {
-					return err
-				}
-				return os.Chmod(old, 0444)
-			},
-			func(old, new string) error {
-				return os.Chmod(old, 0777)
-			},
-			"Failed to backup old bin dir",
-			false,
-		},
-		{
-			"Unreadable backup dir parent",
-			func(old, new string) error {
-				if err := os.MkdirAll(old, 0777); err != nil {
-					return err
-				}
-				return os.Chmod(filepath.Dir(new), 0222)
-			},
-			func(old, new string) error {
-				return os.Chmod(filepath.Dir(new), 0777)
-			},
-			"Failed to stat backup bin dir",
-			false,
-		},
-		{
-			"Existing backup dir",
-			func(old, new string) error {
-				if err := os.MkdirAll(old, 0777); err != nil {
-					return err
-				}
-				return os.MkdirAll(new+".BACKUP", 0777)
-			},
-			nil,
-			"Backup bin dir",
-			false,
-		},
+	// The update leaves the projects unchanged.
+	revisions := []string{}
+	for i, p := range localProjects {
+		revision, err := gits[i].CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		revisions = append(revisions, revision)
+		writeReadme(t, fake.X, fake.Projects[p.Name], "updated readme")
 	}
-	for _, test := range tests {
-		jirix, cleanup := jiritest.NewX(t)
-		if err := testTransitionBinDir(jirix, test); err != nil {
-			t.Errorf("%s: %v", test.Name, err)
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range localProjects {
+		if got, err := gits[i].CurrentRevision(); err != nil || got != revisions[i] {
+			t.Errorf("project %d: got revision %q (%v), want %q", i, got, err, revisions[i])
+		}
+		if state := states[p.Key()]; !strings.Contains(stdout.String(), p.Name+": "+state.InProgressOperation+" in progress") {
+			t.Errorf("got output %q, want it to list project %q", stdout.String(), p.Name)
 		}
-		cleanup()
 	}
 }
 
-func testTransitionBinDir(jirix *jiri.X, test binDirTest) (e error) {
-	oldDir, newDir := filepath.Join(jirix.Root, "devtools", "bin"), jirix.BinDir()
-	// The new bin dir always exists.
-	if err := os.MkdirAll(newDir, 0777); err != nil {
-		return fmt.Errorf("make new dir failed: %v", err)
+// TestUpdateUniverseGerritRemote checks that UpdateUniverse configures the
+// gerritremote of a project to point at the project on its gerrit host.
+func TestUpdateUniverseGerritRemote(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
 	}
-	if err := test.Setup(oldDir, newDir); err != nil {
-		return fmt.Errorf("setup failed: %v", err)
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		if p.Name == localProjects[1].Name {
+			p.GerritHost = "https://example-review.googlesource.com"
+			p.GerritRemote = "review"
+		}
+		projects = append(projects, p)
 	}
-	if test.Teardown != nil {
-		defer func() {
-			if err := test.Teardown(oldDir, newDir); err != nil && e == nil {
-				e = fmt.Errorf("teardown failed: %v", err)
-			}
-		}()
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
 	}
-	oldInfo, _ := os.Stat(oldDir)
-	switch err := project.TransitionBinDir(jirix); {
-	case err != nil && test.Error == "":
-		return fmt.Errorf("got error %q, want success", err)
-	case err != nil && !strings.Contains(fmt.Sprint(err), test.Error):
-		return fmt.Errorf("got error %q, want prefix %q", err, test.Error)
-	case err == nil && test.Error != "":
-		return fmt.Errorf("got no error, want %q", test.Error)
-	case err == nil && test.Error == "":
-		// Make sure the symlink exists and is correctly linked.
-		link, err := os.Readlink(oldDir)
+	// Update twice, to check both adding the remote to a new clone and
+	// updating an existing remote.
+	for i := 0; i < 2; i++ {
+		if err := fake.UpdateUniverse(false); err != nil {
+			t.Fatal(err)
+		}
+		git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(localProjects[1].Path))
+		got, err := git.RemoteUrl("review")
 		if err != nil {
-			return fmt.Errorf("old dir isn't a symlink: %v", err)
+			t.Fatal(err)
 		}
-		if got, want := link, newDir; got != want {
-			return fmt.Errorf("old dir symlink got %v, want %v", got, want)
+		if want := "https://example-review.googlesource.com" + fake.Projects[localProjects[1].Name]; got != want {
+			t.Errorf("got remote url %q, want %q", got, want)
+		}
+	}
+}
+
+// TestUpdateUniverseCloneFilter checks that UpdateUniverse creates a partial
+// clone of a project with a clonefilter, and fetches the omitted objects on
+// demand when the project is advanced to an older revision.
+func TestUpdateUniverseCloneFilter(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	p := localProjects[1]
+	remoteDir := fake.Projects[p.Name]
+	remoteGit := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(remoteDir))
+	initial, err := remoteGit.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remoteGit.SetConfig("uploadpack.allowFilter", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.AddCommit(p.Name, "README", "new readme"); err != nil {
+		t.Fatal(err)
+	}
+	// Local paths are cloned without filtering, so use a file URL.
+	setProjectRemote(t, fake, p.Name, "file://"+remoteDir)
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].CloneFilter = "blob:none"
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, p, "new readme")
+	missing := func() string {
+		out, err := exec.Command("git", "-C", p.Path, "rev-list", "--objects", "--missing=print", "--all").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result []string
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(line, "?") {
+				result = append(result, line)
+			}
+		}
+		return strings.Join(result, "\n")
+	}
+	if missing() == "" {
+		t.Errorf("project %q is not a partial clone", p.Name)
+	}
+
+	// Advancing the project to the initial revision fetches its README.
+	if err := fake.SetRevision(p.Name, initial); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, p, jiritest.InitialReadme)
+}
+
+// TestUpdateUniverseSparseCheckout checks that the working trees of projects
+// with a sparsecheckout attribute are restricted to the given directories,
+// and that switching between sparse and full checkouts converges.
+func TestUpdateUniverseSparseCheckout(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	p := localProjects[1]
+	for _, dir := range []string{"dir1", "dir2"} {
+		if err := fake.X.NewSeq().MkdirAll(filepath.Join(fake.Projects[p.Name], dir), 0755).Done(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fake.AddCommit(p.Name, filepath.Join(dir, "file"), dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setSparseCheckout := func(dirs string) {
+		m, err := fake.ReadRemoteManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range m.Projects {
+			if m.Projects[i].Name == p.Name {
+				m.Projects[i].SparseCheckout = dirs
+			}
+		}
+		if err := fake.WriteRemoteManifest(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkDirs := func(want ...string) {
+		for _, dir := range []string{"dir1", "dir2"} {
+			_, err := os.Stat(filepath.Join(p.Path, dir, "file"))
+			wanted := false
+			for _, w := range want {
+				wanted = wanted || w == dir
+			}
+			if got := err == nil; got != wanted {
+				t.Errorf("%s checked out: got %v, want %v", dir, got, wanted)
+			}
+		}
+		checkReadme(t, fake.X, p, jiritest.InitialReadme)
+		if _, err := project.ProjectFromFile(fake.X, filepath.Join(p.Path, jiri.ProjectMetaDir, jiri.ProjectMetaFile)); err != nil {
+			t.Errorf("metadata of project %q is missing: %v", p.Name, err)
+		}
+	}
+
+	// A new project is cloned sparsely.
+	setSparseCheckout("dir1")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkDirs("dir1")
+
+	// Changing the directories updates the working tree, even if the
+	// revision did not change.
+	setSparseCheckout("dir2")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkDirs("dir2")
+
+	// Removing the attribute checks out the whole tree, and adding it again
+	// restricts the working tree again.
+	setSparseCheckout("")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkDirs("dir1", "dir2")
+	setSparseCheckout("dir1")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkDirs("dir1")
+}
+
+// TestUpdateUniverseLFS checks that UpdateUniverse installs the Git LFS
+// filters and fetches the LFS files of the projects that use Git LFS, and
+// that it fails with an instructive error if git-lfs is missing.  The lfs
+// subcommands are run by a fake git, which runs all other commands with the
+// real git.
+func TestUpdateUniverseLFS(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	realGit, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatalf("LookPath(git) failed: %v", err)
+	}
+	binDir := filepath.Join(fake.X.Root, "fake-bin")
+	log, missing := filepath.Join(binDir, "log"), filepath.Join(binDir, "missing")
+	script := `#!/bin/sh
+if [ "$1" = lfs ]; then
+  if [ -f ` + missing + ` ]; then
+    echo "git: 'lfs' is not a git command. See 'git --help'." >&2
+    exit 1
+  fi
+  echo "$*" >> ` + log + `
+  exit 0
+fi
+exec ` + realGit + ` "$@"
+`
+	if err := fake.X.NewSeq().MkdirAll(binDir, 0755).WriteFile(filepath.Join(binDir, "git"), []byte(script), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+oldPath)
+	records := func() string {
+		data, err := ioutil.ReadFile(log)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		os.Remove(log)
+		return string(data)
+	}
+	setLFS := func(name string, lfs bool) {
+		m, err := fake.ReadRemoteManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range m.Projects {
+			if m.Projects[i].Name == name {
+				m.Projects[i].LFS = lfs
+			}
+		}
+		if err := fake.WriteRemoteManifest(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const lfsCommands = "lfs install --local\nlfs pull\n"
+
+	// Projects without the attribute do not use Git LFS.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := records(); got != "" {
+		t.Errorf("got lfs commands %q, want none", got)
+	}
+
+	// Setting the attribute of an existing project updates it, even if its
+	// revision did not change.
+	setLFS(localProjects[1].Name, true)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := records(), lfsCommands; got != want {
+		t.Errorf("got lfs commands %q, want %q", got, want)
+	}
+
+	// A new project that uses Git LFS cannot be created without git-lfs.
+	if err := fake.CreateRemoteProject("lfs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddProject(project.Project{Name: "lfs", Path: filepath.Join(fake.X.Root, "lfs"), Remote: fake.Projects["lfs"], LFS: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.AddCommit("lfs", "README", "initial readme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(missing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = fake.UpdateUniverse(false)
+	if want := "the git-lfs binary was not found"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+	if _, err := os.Stat(filepath.Join(fake.X.Root, "lfs")); !os.IsNotExist(err) {
+		t.Errorf("project %q was created without git-lfs: %v", "lfs", err)
+	}
+	os.Remove(missing)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := records(), lfsCommands; got != want {
+		t.Errorf("got lfs commands %q, want %q", got, want)
+	}
+
+	// With DetectLFSOpt, projects whose .gitattributes file uses the LFS
+	// filter use Git LFS too.
+	p := localProjects[2]
+	if _, err := fake.AddCommit(p.Name, ".gitattributes", "*.bin filter=lfs diff=lfs merge=lfs -text\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := records(); got != "" {
+		t.Errorf("got lfs commands %q, want none", got)
+	}
+	if _, err := fake.AddCommit(p.Name, "README", "new readme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateUniverse(fake.X, false, project.DetectLFSOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := records(), lfsCommands; got != want {
+		t.Errorf("got lfs commands %q, want %q", got, want)
+	}
+}
+
+// TestUpdateUniverseNoHooks checks that UpdateUniverse skips running and
+// installing hooks when hooks are disabled, and that the skipped hooks are
+// listed and recorded in the update history.
+func TestUpdateUniverseNoHooks(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+
+	// Add a runhook that creates a file, and a githooks directory.
+	marker := filepath.Join(fake.X.Root, "hook-ran")
+	runHook := filepath.Join(fake.X.Root, "runhook.sh")
+	gitHooks := filepath.Join(fake.X.Root, "githooks")
+	if err := s.WriteFile(runHook, []byte("#!/bin/sh\ntouch "+marker+"\n"), 0755).
+		MkdirAll(gitHooks, 0755).
+		WriteFile(filepath.Join(gitHooks, "pre-push"), []byte("#!/bin/sh\n"), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		switch p.Name {
+		case localProjects[0].Name:
+			p.RunHook = runHook
+		case localProjects[1].Name:
+			p.GitHooks = gitHooks
+		}
+		projects = append(projects, p)
+	}
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	if err := project.UpdateUniverse(fake.X, false, project.NoHooksOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertFileExists(marker).Done(); err == nil {
+		t.Errorf("runhook was run")
+	}
+	if err := s.AssertFileExists(filepath.Join(localProjects[1].Path, ".git", "hooks", "pre-push")).Done(); err == nil {
+		t.Errorf("githooks were installed")
+	}
+	for _, p := range localProjects {
+		checkMetadataIsIgnored(t, fake.X, p)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("  %s: runhook runhook.sh", localProjects[0].Name),
+		fmt.Sprintf("  %s: githooks githooks", localProjects[1].Name),
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+		}
+	}
+
+	// Check that the skipped hooks are recorded in the update history.
+	if err := project.WriteUpdateHistorySnapshot(fake.X, "", project.NoHooksOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fake.X.UpdateHistoryLatestLink())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "The following hooks were skipped"; !strings.Contains(string(data), want) {
+		t.Errorf("got snapshot %s, want it to contain %q", data, want)
+	}
+}
+
+// TestUpdateUniverseHookEnv checks that runhooks run in the environment
+// requested by the hookenv and hookprofiles attributes of their projects.
+func TestUpdateUniverseHookEnv(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+
+	// Add a runhook that records its environment.
+	runHook := filepath.Join(fake.X.Root, "runhook.sh")
+	script := "#!/bin/sh\nenv > " + fake.X.Root + "/env-$JIRI_PROJECT_NAME\n"
+	if err := s.WriteFile(runHook, []byte(script), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		switch p.Name {
+		case localProjects[0].Name:
+			p.RunHook = runHook
+			p.HookEnv = project.HookEnvClean
+			p.HookProfiles = "test:hook"
+		case localProjects[1].Name:
+			p.RunHook = runHook
+		}
+		projects = append(projects, p)
+	}
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Install a profile that sets a variable.
+	pdb := profiles.NewDB()
+	pdb.InstallProfile("test", "hook", "")
+	target := profiles.NativeTarget()
+	target.SetVersion("1")
+	target.Env.Vars = []string{"HOOK_PROFILE_VAR=profile"}
+	if err := pdb.AddProfileTarget("test", "hook", target); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MkdirAll(fake.X.ProfilesDBDir(), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.Write(fake.X, "test", fake.X.ProfilesDBDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	env := envvar.SliceToMap(os.Environ())
+	env["HOOK_TEST_VAR"] = "inherited"
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Env: env, Stdout: &stdout})
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	readEnv := func(p project.Project) map[string]string {
+		data, err := ioutil.ReadFile(filepath.Join(fake.X.Root, "env-"+p.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return envvar.SliceToMap(strings.Split(strings.TrimSpace(string(data)), "\n"))
+	}
+
+	// The clean environment only has PATH and HOME of the inherited
+	// environment, and the variables of the profile.
+	clean := readEnv(localProjects[0])
+	for key, want := range map[string]string{
+		"PATH":              env["PATH"],
+		"JIRI_ROOT":         fake.X.Root,
+		"JIRI_PROJECT_NAME": localProjects[0].Name,
+		"JIRI_PROJECT_PATH": localProjects[0].Path,
+		"HOOK_PROFILE_VAR":  "profile",
+	} {
+		if got := clean[key]; got != want {
+			t.Errorf("clean hook environment: got %s=%q, want %q", key, got, want)
+		}
+	}
+	for key := range clean {
+		switch key {
+		case "PATH", "HOME", "PWD", "JIRI_ROOT":
+		default:
+			if _, ok := env[key]; ok {
+				t.Errorf("clean hook environment: inherited %s", key)
+			}
+		}
+	}
+
+	// The default environment is inherited.
+	inherited := readEnv(localProjects[1])
+	for key, want := range map[string]string{
+		"HOOK_TEST_VAR":     "inherited",
+		"JIRI_PROJECT_NAME": localProjects[1].Name,
+		"HOOK_PROFILE_VAR":  "",
+	} {
+		if got := inherited[key]; got != want {
+			t.Errorf("inherited hook environment: got %s=%q, want %q", key, got, want)
+		}
+	}
+}
+
+// TestUpdateUniverseRequiresProfiles checks that a runhook is skipped if the
+// profiles listed by the requiresprofiles attribute of its project are not
+// installed for the native target, and that requiring an unknown profile
+// fails the update.
+func TestUpdateUniverseRequiresProfiles(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+
+	// Add a runhook that records that it ran.
+	runHook := filepath.Join(fake.X.Root, "runhook.sh")
+	script := "#!/bin/sh\ntouch " + fake.X.Root + "/ran-$JIRI_PROJECT_NAME\n"
+	if err := s.WriteFile(runHook, []byte(script), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	setRequiredProfiles := func(required map[string]string) {
+		m, err := fake.ReadRemoteManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		projects := []project.Project{}
+		for _, p := range m.Projects {
+			if names, ok := required[p.Name]; ok {
+				p.RunHook = runHook
+				p.RequiresProfiles = names
+			}
+			projects = append(projects, p)
+		}
+		m.Projects = projects
+		if err := fake.WriteRemoteManifest(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setRequiredProfiles(map[string]string{
+		localProjects[0].Name: "test:other",
+		localProjects[1].Name: "test:native",
+	})
+
+	// Install test:native for the native target, and test:other for another
+	// target only, in a profiles database given by ProfilesDBOpt.
+	pdb := profiles.NewDB()
+	pdb.InstallProfile("test", "native", "")
+	native := profiles.NativeTarget()
+	native.SetVersion("1")
+	if err := pdb.AddProfileTarget("test", "native", native); err != nil {
+		t.Fatal(err)
+	}
+	pdb.InstallProfile("test", "other", "")
+	other, err := profiles.NewTarget("arm-plan9@1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.AddProfileTarget("test", "other", other); err != nil {
+		t.Fatal(err)
+	}
+	dbDir := filepath.Join(fake.X.Root, "profile_db")
+	if err := s.MkdirAll(dbDir, 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.Write(fake.X, "test", dbDir); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	if err := project.UpdateUniverse(fake.X, false, project.ProfilesDBOpt(dbDir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(fake.X.Root, "ran-"+localProjects[0].Name)); !os.IsNotExist(err) {
+		t.Errorf("expected the hook of project %q to be skipped, got error %v", localProjects[0].Name, err)
+	}
+	if err := s.AssertFileExists(filepath.Join(fake.X.Root, "ran-"+localProjects[1].Name)).Done(); err != nil {
+		t.Errorf("expected the hook of project %q to run: %v", localProjects[1].Name, err)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("skipped the hook for project %q: the required profiles test:other are not installed", localProjects[0].Name),
+		fmt.Sprintf("    %s (create): skipped: ", localProjects[0].Name),
+		fmt.Sprintf("    %s (create): ok, ", localProjects[1].Name),
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("output %q does not contain %q", stdout.String(), want)
+		}
+	}
+
+	// Check that requiring an unknown profile fails the update.
+	setRequiredProfiles(map[string]string{localProjects[2].Name: "test:unknown"})
+	err = project.UpdateUniverse(fake.X, false, project.ProfilesDBOpt(dbDir))
+	if got, want := fmt.Sprint(err), `requires the unknown profile "test:unknown"`; !strings.Contains(got, want) {
+		t.Errorf("got error %v, want substr %v", got, want)
+	}
+}
+
+// TestUpdateUniverseHookTimeout checks that a runhook that does not finish
+// within the hook timeout is killed along with the processes it started, and
+// that the outcome of the runhooks is summarized and written to an xUnit
+// report.
+func TestUpdateUniverseHookTimeout(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+
+	okHook := filepath.Join(fake.X.Root, "ok-hook.sh")
+	hangingHook := filepath.Join(fake.X.Root, "hanging-hook.sh")
+	pidFile := filepath.Join(fake.X.Root, "pid")
+	if err := s.WriteFile(okHook, []byte("#!/bin/sh\necho ok hook output\n"), 0755).
+		WriteFile(hangingHook, []byte("#!/bin/sh\necho hanging hook output\nsh -c 'echo $$ > "+pidFile+"; exec sleep 60' | cat\n"), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		switch p.Name {
+		case localProjects[0].Name:
+			p.RunHook = okHook
+		case localProjects[1].Name:
+			p.RunHook = hangingHook
+		}
+		projects = append(projects, p)
+	}
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: &stderr})
+	xunitFile := filepath.Join(fake.X.Root, "hooks.xml")
+	err = project.UpdateUniverse(fake.X, false, project.HookTimeoutOpt(time.Second), project.HookXUnitFileOpt(xunitFile))
+	if err == nil || !strings.Contains(err.Error(), "timed out after 1s") {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+
+	// The process started by the hanging runhook was killed.
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A killed process remains a zombie until it is reaped.
+	running := func() bool {
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		return syscall.Kill(pid, 0) == nil && (err != nil || !strings.Contains(string(stat), ") Z "))
+	}
+	for i := 0; i < 50 && running(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if running() {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Errorf("process %d started by the runhook is still running", pid)
+	}
+
+	// Only the output of the failed runhook is printed.
+	if got := stdout.String() + stderr.String(); strings.Contains(got, "ok hook output") || !strings.Contains(got, "hanging hook output") {
+		t.Errorf("got output %q, want only the output of the failed runhook", got)
+	}
+	for _, want := range []string{
+		"  hooks:\n",
+		fmt.Sprintf("    %s (create): ok, ", localProjects[0].Name),
+		fmt.Sprintf("    %s (create): failed: timed out after 1s, ", localProjects[1].Name),
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("summary %q does not contain %q", stdout.String(), want)
+		}
+	}
+
+	data, err = ioutil.ReadFile(xunitFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<testsuite name="jiri-hooks" tests="2" failures="1"`,
+		fmt.Sprintf(`<testcase classname="hooks" name="%s"`, localProjects[0].Name),
+		`<failure message="timed out after 1s">hanging hook output`,
+		`<system-out>ok hook output`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("xUnit report %s does not contain %q", data, want)
+		}
+	}
+}
+
+// TestUpdateUniversePostUpdate checks that the post-update commands of the
+// manifest are run in order, in the directories of their projects, and that
+// only failures of commands without failok fail the update.
+func TestUpdateUniversePostUpdate(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	log := filepath.Join(fake.X.Root, "postupdate.log")
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.PostUpdates = []project.PostUpdate{
+		{Command: "echo root $JIRI_ROOT >> " + log},
+		{Command: "echo project $(pwd) >> " + log, Project: localProjects[0].Name},
+		{Command: "exit 1", FailOk: true},
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that skipped commands are only listed.
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Stderr: ioutil.Discard})
+	if err := project.UpdateUniverse(fake.X, false, project.SkipPostUpdateOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(log); err == nil {
+		t.Errorf("post-update commands were run")
+	}
+	if want := "skipped the following commands:\n  \"echo root"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+	}
+
+	stdout.Reset()
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), fmt.Sprintf("root %s\nproject %s\n", fake.X.Root, localProjects[0].Path); got != want {
+		t.Errorf("got log %q, want %q", got, want)
+	}
+	if want := "exit 1: failed (ignored)"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+	}
+
+	// Check that a failing command without failok fails the update.
+	m.PostUpdates[2].FailOk = false
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateUniverse(fake.X, false); err == nil {
+		t.Errorf("expected a failing post-update command to fail the update")
+	}
+}
+
+// TestUpdateUniverseUnknownRevision checks that updating a project to a
+// revision that does not exist reports the revision and the failed git
+// command.
+func TestUpdateUniverseUnknownRevision(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+
+	revision := "0123456789abcdef0123456789abcdef01234567"
+	if err := fake.SetRevision(localProjects[1].Name, revision); err != nil {
+		t.Fatal(err)
+	}
+	err := project.UpdateUniverse(fake.X, false)
+	if err == nil {
+		t.Fatalf("UpdateUniverse() did not fail")
+	}
+	for _, want := range []string{
+		fmt.Sprintf("error updating project %q", localProjects[1].Name),
+		fmt.Sprintf("revision %q of project %q does not exist", revision, localProjects[1].Name),
+		"'git reset --hard " + revision,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+// TestUpdateUniverseGitTimeout checks that git commands that hang during an
+// update are killed after the timeout, failing the update with an error that
+// names the project, and that the next update succeeds.
+func TestUpdateUniverseGitTimeout(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shadow git with a script whose fetches hang, recording the pid of the
+	// hanging process.
+	realGit, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binDir, pidFile := filepath.Join(fake.X.Root, "slow-git"), filepath.Join(fake.X.Root, "slow-git.pid")
+	script := fmt.Sprintf("#!/bin/sh\nfor arg in \"$@\"; do\n  if [ \"$arg\" = fetch ]; then echo $$ > %s; exec sleep 1000; fi\ndone\nexec %s \"$@\"\n", pidFile, realGit)
+	if err := fake.X.NewSeq().MkdirAll(binDir, 0755).WriteFile(filepath.Join(binDir, "git"), []byte(script), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	// Commands inherit the environment of the test unless the context sets
+	// one, so start from a copy of it.
+	env := fake.X.Env()
+	if len(env) == 0 {
+		for key, value := range envvar.SliceToMap(os.Environ()) {
+			env[key] = value
+		}
+	}
+	path := env["PATH"]
+	env["PATH"] = binDir + string(os.PathListSeparator) + path
+
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "new revision")
+	start := time.Now()
+	err = project.UpdateUniverse(fake.X, false, project.GitTimeoutOpt(time.Second))
+	if err == nil {
+		t.Fatalf("UpdateUniverse() did not fail")
+	}
+	if got, max := time.Since(start), time.Minute; got > max {
+		t.Errorf("UpdateUniverse() took %v, want at most %v", got, max)
+	}
+	if want := regexp.MustCompile(`timed out after 1s fetching project "[^"]+" from "[^"]+"`); !want.MatchString(err.Error()) {
+		t.Errorf("got error %q, want it to match %q", err, want)
+	}
+	if got, want := errkind.Of(err), errkind.NetworkError; got != want {
+		t.Errorf("got error kind %v, want %v", got, want)
+	}
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(pid, 0); err == nil {
+		t.Errorf("hanging git process %d was not killed", pid)
+	}
+
+	// Once git no longer hangs, the update succeeds.
+	env["PATH"] = path
+	if err := project.UpdateUniverse(fake.X, false, project.GitTimeoutOpt(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, localProjects[1], "new revision")
+}
+
+// TestUpdateUniverseVerifySignature checks that projects whose verify
+// attribute is "signature" are only updated to revisions signed by a trusted
+// key, unless verification is disabled.
+func TestUpdateUniverseVerifySignature(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Generate a signing key in a keyring of the test.
+	gnupgHome, err := ioutil.TempDir("", "jiri-gnupg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gnupgHome)
+	defer os.Setenv("GNUPGHOME", os.Getenv("GNUPGHOME"))
+	if err := os.Setenv("GNUPGHOME", gnupgHome); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("gpg", "--batch", "--pinentry-mode", "loopback", "--passphrase", "", "--quick-gen-key", "Test <test@example.com>", "ed25519", "sign", "never").CombinedOutput(); err != nil {
+		t.Skipf("cannot generate a gpg key: %v\n%s", err, out)
+	}
+	out, err := exec.Command("gpg", "--with-colons", "--list-keys").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && len(fields) > 9 {
+			fingerprint = fields[9]
+			break
+		}
+	}
+	if fingerprint == "" {
+		t.Fatalf("no fingerprint in %s", out)
+	}
+
+	p := localProjects[1]
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].Verify = project.VerifySignature
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[p.Name], "unsigned commit")
+
+	checkError := func(want string, opts ...project.UpdateOpt) {
+		err := project.UpdateUniverse(fake.X, false, opts...)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want it to contain %q", err, want)
+		}
+	}
+	// Without trusted keys, nothing can be verified.
+	checkError("no trusted keys are listed")
+	keysFile := filepath.Join(fake.X.KeysDir(), "keys")
+	if err := fake.X.NewSeq().MkdirAll(fake.X.KeysDir(), 0755).
+		WriteFile(keysFile, []byte("# Test key.\n"+fingerprint+"\n"), 0644).Done(); err != nil {
+		t.Fatal(err)
+	}
+	checkError("is not signed by a trusted key")
+	checkReadme(t, fake.X, p, "initial readme")
+
+	// Verification can be disabled, loudly.
+	var stderr bytes.Buffer
+	ctx := fake.X.Context
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stderr: &stderr})
+	if err := project.UpdateUniverse(fake.X, false, project.NoVerifyOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	fake.X.Context = ctx
+	if want := fmt.Sprintf("WARNING: the signatures of the revisions of project %q are NOT verified", p.Name); !strings.Contains(stderr.String(), want) {
+		t.Errorf("got stderr %q, want it to contain %q", stderr.String(), want)
+	}
+	checkReadme(t, fake.X, p, "unsigned commit")
+
+	// Signed revisions are accepted.
+	writeReadme(t, fake.X, fake.Projects[p.Name], "signed commit")
+	cmd := exec.Command("git", "-c", "user.signingkey="+fingerprint, "commit", "--amend", "--no-edit", "-S")
+	cmd.Dir = fake.Projects[p.Name]
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, p, "signed commit")
+
+	// But not once their key is no longer trusted.
+	writeReadme(t, fake.X, fake.Projects[p.Name], "untrusted commit")
+	cmd = exec.Command("git", "-c", "user.signingkey="+fingerprint, "commit", "--amend", "--no-edit", "-S")
+	cmd.Dir = fake.Projects[p.Name]
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if err := ioutil.WriteFile(keysFile, []byte(strings.Repeat("0", len(fingerprint))+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkError("which is not a trusted key")
+	checkReadme(t, fake.X, p, "signed commit")
+}
+
+// TestComputeOperations checks that the operations that update local projects
+// to match remote projects are computed correctly.
+func TestComputeOperations(t *testing.T) {
+	newProject := func(name, path, revision string) project.Project {
+		return project.Project{Name: name, Path: path, Remote: "remote-" + name, Revision: revision}
+	}
+	projects := func(ps ...project.Project) project.Projects {
+		result := project.Projects{}
+		for _, p := range ps {
+			result[p.Key()] = p
+		}
+		return result
+	}
+	local := projects(
+		newProject("unchanged", "unchanged", "rev1"),
+		newProject("updated", "updated", "rev1"),
+		newProject("moved", "moved", "rev1"),
+		newProject("deleted", "deleted", "rev1"),
+		newProject("remote-changed", "remote-changed", "rev1"),
+	)
+	changed := newProject("remote-changed", "remote-changed", "rev1")
+	changed.Remote = "new-remote"
+	remote := projects(
+		newProject("unchanged", "unchanged", "rev1"),
+		newProject("updated", "updated", "rev2"),
+		newProject("moved", "new-path", "rev1"),
+		newProject("created", "created", "rev1"),
+		changed,
+	)
+	got := map[string]string{}
+	for _, op := range project.InternalComputeOperations(local, remote, false) {
+		got[op.Project().Name] = op.Kind()
+	}
+	want := map[string]string{
+		"unchanged":      "null",
+		"updated":        "update",
+		"moved":          "move",
+		"deleted":        "delete",
+		"created":        "create",
+		"remote-changed": "update",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got operations %v, want %v", got, want)
+	}
+}
+
+// TestComputeOperationsRenamed checks that a local project is renamed rather
+// than deleted and created again if it is the only local and remote project
+// with its remote URL, and not otherwise.
+func TestComputeOperationsRenamed(t *testing.T) {
+	newProject := func(name, path, remote string) project.Project {
+		return project.Project{Name: name, Path: path, Remote: remote, Revision: "rev1"}
+	}
+	projects := func(ps ...project.Project) project.Projects {
+		result := project.Projects{}
+		for _, p := range ps {
+			result[p.Key()] = p
+		}
+		return result
+	}
+	tests := []struct {
+		local, remote project.Projects
+		want          map[string]string
+	}{
+		// A rename in place updates the metadata of the local project.
+		{
+			projects(newProject("old", "path", "remote")),
+			projects(newProject("new", "path", "remote")),
+			map[string]string{"new": "null"},
+		},
+		// A rename to a new path moves the local project.
+		{
+			projects(newProject("old", "path", "remote")),
+			projects(newProject("new", "new-path", "remote")),
+			map[string]string{"new": "move"},
+		},
+		// Two local projects with the same remote are ambiguous.
+		{
+			projects(newProject("old1", "path1", "remote"), newProject("old2", "path2", "remote")),
+			projects(newProject("new", "path1", "remote")),
+			map[string]string{"old1": "delete", "old2": "delete", "new": "create"},
+		},
+		// Two remote projects with the same remote are ambiguous.
+		{
+			projects(newProject("old", "path1", "remote")),
+			projects(newProject("new1", "path1", "remote"), newProject("new2", "path2", "remote")),
+			map[string]string{"old": "delete", "new1": "create", "new2": "create"},
+		},
+		// A project that still exists remotely is not renamed, even if
+		// another remote project shares its remote.
+		{
+			projects(newProject("same", "path1", "remote")),
+			projects(newProject("same", "path1", "remote"), newProject("new", "path2", "remote")),
+			map[string]string{"same": "null", "new": "create"},
+		},
+		// Projects with different remotes are not renamed.
+		{
+			projects(newProject("old", "path", "remote1")),
+			projects(newProject("new", "path", "remote2")),
+			map[string]string{"old": "delete", "new": "create"},
+		},
+		// A project whose remote changes along with the case of its path is
+		// moved, rather than deleted and created again at what may be the
+		// same directory.
+		{
+			projects(newProject("same", "Path", "remote1")),
+			projects(newProject("same", "path", "remote2")),
+			map[string]string{"same": "move"},
+		},
+	}
+	for i, test := range tests {
+		got := map[string]string{}
+		for _, op := range project.InternalComputeOperations(test.local, test.remote, false) {
+			got[op.Project().Name] = op.Kind()
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("test %d: got operations %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+// TestUpdateUniverseRenamedProject checks that UpdateUniverse renames a
+// project whose name changed in the manifest, keeping its local branches.
+func TestUpdateUniverseRenamedProject(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(localProjects[1].Path))
+	if err := git.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rename project 1 and move it to a new path.
+	manifest, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPath := filepath.Join(fake.X.Root, "renamed-path")
+	for i, p := range manifest.Projects {
+		if p.Name == localProjects[1].Name {
+			manifest.Projects[i].Name = "renamed"
+			manifest.Projects[i].Path = newPath
+		}
+	}
+	if err := fake.WriteRemoteManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(localProjects[1].Path); !os.IsNotExist(err) {
+		t.Errorf("expected %q not to exist, got %v", localProjects[1].Path, err)
+	}
+	git = gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(newPath))
+	if !git.BranchExists("feature") {
+		t.Errorf("branch %q was not preserved by the rename", "feature")
+	}
+	projects, err := project.LocalProjects(fake.X, project.FullScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := projects.Find("renamed")
+	if len(found) != 1 {
+		t.Fatalf("got %d local projects named %q, want 1", len(found), "renamed")
+	}
+	for _, p := range found {
+		if p.Path != newPath {
+			t.Errorf("got path %q, want %q", p.Path, newPath)
+		}
+	}
+	if got := projects.Find(localProjects[1].Name); len(got) != 0 {
+		t.Errorf("got local projects %v named %q, want none", got, localProjects[1].Name)
+	}
+}
+
+// TestUpdateUniverseCaseCollision checks that UpdateUniverse fails if the
+// paths of two projects differ only in case on a case-insensitive
+// filesystem, and only warns about them otherwise.
+func TestUpdateUniverseCaseCollision(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a project whose path only differs in case from that of project 1.
+	manifest, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	collision := localProjects[2]
+	collision.Name = "collision"
+	collision.Path = filepath.Join(filepath.Dir(localProjects[1].Path), strings.ToUpper(filepath.Base(localProjects[1].Path)))
+	manifest.Projects = append(manifest.Projects, collision)
+	if err := fake.WriteRemoteManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	restore := project.InternalSetCaseInsensitiveFS(true)
+	err = fake.UpdateUniverse(false)
+	restore()
+	if err == nil || !strings.Contains(err.Error(), "differ only in case") || !strings.Contains(err.Error(), `"collision"`) || !strings.Contains(err.Error(), fmt.Sprintf("%q", localProjects[1].Name)) {
+		t.Fatalf("got error %v, want one naming the colliding projects", err)
+	}
+	if _, err := os.Stat(collision.Path); !os.IsNotExist(err) {
+		t.Errorf("expected %q not to exist, got %v", collision.Path, err)
+	}
+
+	defer project.InternalSetCaseInsensitiveFS(false)()
+	var stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stderr: &stderr})
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stderr.String(), "WARNING: the paths of projects"; !strings.Contains(got, want) {
+		t.Errorf("got stderr %q, want it to contain %q", got, want)
+	}
+	checkReadme(t, fake.X, collision, "initial readme")
+}
+
+// TestUpdateUniverseCaseMove checks that UpdateUniverse moves a project to a
+// path that only differs in case.
+func TestUpdateUniverseCaseMove(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := localProjects[1].Path
+	localProjects[1].Path = filepath.Join(filepath.Dir(oldPath), strings.ToUpper(filepath.Base(oldPath)))
+	if err := fake.MoveProject(localProjects[1].Name, localProjects[1].Path); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+	if _, err := os.Stat(oldPath + ".jiri-move"); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary directory of the move not to exist, got %v", err)
+	}
+}
+
+// TestCheckoutSnapshot checks that CheckoutSnapshot restores projects to the
+// revisions recorded in a snapshot, and records the snapshot in the update
+// history.
+func TestRemoteHeadHosts(t *testing.T) {
+	projects := project.Projects{}
+	for i, remote := range []string{
+		"https://vanadium.googlesource.com/a",
+		"https://vanadium.googlesource.com/b",
+		"https://fuchsia.googlesource.com/c",
+		"https://github.com/d",
+	} {
+		p := project.Project{Name: fmt.Sprintf("p%d", i), Path: fmt.Sprintf("p%d", i), Remote: remote}
+		projects[p.Key()] = p
+	}
+	tests := []struct {
+		hosts []string
+		want  []string
+	}{
+		{nil, []string{"https://fuchsia.googlesource.com", "https://vanadium.googlesource.com"}},
+		{[]string{}, nil},
+		{[]string{"vanadium.googlesource.com"}, []string{"https://vanadium.googlesource.com"}},
+		{[]string{"https://Fuchsia.googlesource.com/"}, []string{"https://fuchsia.googlesource.com"}},
+		{[]string{"github.com", "other.googlesource.com"}, nil},
+	}
+	for _, test := range tests {
+		if got := project.InternalRemoteHeadHosts(projects, test.hosts); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.hosts, got, test.want)
+		}
+	}
+}
+
+func TestCheckoutSnapshot(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(fake.X.Root, "snapshot")
+	if err := project.CreateSnapshot(fake.X, snapshot, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Advance the remote projects and update the local projects.
+	for _, p := range localProjects {
+		if _, err := fake.AddCommit(p.Name, "README", "new readme"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range localProjects {
+		checkReadme(t, fake.X, p, "new readme")
+	}
+
+	// Check that checking out the snapshot restores the original state.
+	if err := project.CheckoutSnapshot(fake.X, snapshot, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range localProjects {
+		checkReadme(t, fake.X, p, jiritest.InitialReadme)
+	}
+	if _, err := os.Stat(fake.X.UpdateHistoryLatestLink()); err != nil {
+		t.Errorf("update history was not recorded: %v", err)
+	}
+}
+
+// TestCheckoutSnapshotMissingRevision checks that CheckoutSnapshot changes no
+// project if the revision of one of them cannot be obtained.
+func TestCheckoutSnapshotMissingRevision(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(fake.X.Root, "snapshot")
+	if err := project.CreateSnapshot(fake.X, snapshot, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range localProjects {
+		if _, err := fake.AddCommit(p.Name, "README", "new readme"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the revision of a project with one that does not exist.
+	snapshotProjects, _, err := project.LoadSnapshotFile(fake.X, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := snapshotProjects[localProjects[1].Key()]
+	data, err := ioutil.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bogus := strings.Repeat("0", 40)
+	data = bytes.Replace(data, []byte(missing.Revision), []byte(bogus), -1)
+	if err := ioutil.WriteFile(snapshot, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = project.CheckoutSnapshot(fake.X, snapshot, false)
+	if err == nil || !strings.Contains(err.Error(), missing.Name) || !strings.Contains(err.Error(), bogus) {
+		t.Fatalf("got error %v, want it to list project %q and revision %v", err, missing.Name, bogus)
+	}
+	for _, p := range localProjects {
+		checkReadme(t, fake.X, p, "new readme")
+	}
+	err = project.CheckoutSnapshot(fake.X, snapshot, false, project.DetachOpt(true))
+	if err == nil || !strings.Contains(err.Error(), missing.Name) {
+		t.Fatalf("got error %v, want it to list project %q", err, missing.Name)
+	}
+	for _, p := range localProjects {
+		checkReadme(t, fake.X, p, "new readme")
+	}
+
+	// Check that the revision is also verified if the project does not
+	// exist locally, before any other project is changed.
+	if err := os.RemoveAll(localProjects[1].Path); err != nil {
+		t.Fatal(err)
+	}
+	err = project.CheckoutSnapshot(fake.X, snapshot, false)
+	if err == nil || !strings.Contains(err.Error(), missing.Name) || !strings.Contains(err.Error(), bogus) {
+		t.Fatalf("got error %v, want it to list project %q and revision %v", err, missing.Name, bogus)
+	}
+	for i, p := range localProjects {
+		if i == 1 {
+			if _, err := os.Stat(p.Path); !os.IsNotExist(err) {
+				t.Errorf("%s: got error %v, want it not to exist", p.Path, err)
+			}
+			continue
+		}
+		checkReadme(t, fake.X, p, "new readme")
+	}
+}
+
+// TestCheckoutSnapshotDetached checks that CheckoutSnapshot with DetachOpt
+// checks out the snapshot revisions without touching the master branches,
+// and that LeaveSnapshot checks the master branches back out.
+func TestCheckoutSnapshotDetached(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(fake.X.Root, "snapshot")
+	if err := project.CreateSnapshot(fake.X, snapshot, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range localProjects {
+		if _, err := fake.AddCommit(p.Name, "README", "new readme"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	masters := map[string]string{}
+	for _, p := range localProjects {
+		rev, err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(p.Path)).CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		masters[p.Name] = rev
+	}
+	checkBranches := func(want string) {
+		for _, p := range localProjects {
+			git := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(p.Path))
+			branch, err := git.CurrentBranchName()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if branch != want {
+				t.Errorf("project %q: got branch %q, want %q", p.Name, branch, want)
+			}
+			master, err := git.CurrentRevisionOfBranch("master")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if master != masters[p.Name] {
+				t.Errorf("project %q: master was moved from %v to %v", p.Name, masters[p.Name], master)
+			}
+		}
+	}
+
+	// Uncommitted changes are refused, unless forced.
+	readme := filepath.Join(localProjects[0].Path, "README")
+	if err := ioutil.WriteFile(readme, []byte("local change"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := project.CheckoutSnapshot(fake.X, snapshot, false, project.DetachOpt(true))
+	if err == nil || !strings.Contains(err.Error(), localProjects[0].Name) {
+		t.Fatalf("got error %v, want it to list project %q", err, localProjects[0].Name)
+	}
+	checkReadme(t, fake.X, localProjects[0], "local change")
+	if err := project.CheckoutSnapshot(fake.X, snapshot, false, project.DetachOpt(true), project.ForceOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range localProjects {
+		checkReadme(t, fake.X, p, jiritest.InitialReadme)
+	}
+	checkBranches("HEAD")
+	update, err := project.LatestUpdate(fake.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := update.SnapshotPath, "snapshot"; got != want {
+		t.Errorf("got snapshot path %q, want %q", got, want)
+	}
+
+	// Leaving the snapshot checks out master.
+	if err := project.LeaveSnapshot(fake.X); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range localProjects {
+		checkReadme(t, fake.X, p, "new readme")
+	}
+	checkBranches("master")
+	if err := project.LeaveSnapshot(fake.X); err == nil {
+		t.Errorf("LeaveSnapshot() did not fail without a snapshot checked out")
+	}
+
+	// The snapshot can be checked out on a branch.
+	if err := project.CheckoutSnapshot(fake.X, snapshot, false, project.DetachOpt(true), project.DetachBranchOpt("snapshot/test")); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range localProjects {
+		checkReadme(t, fake.X, p, jiritest.InitialReadme)
+	}
+	checkBranches("snapshot/test")
+	if err := project.LeaveSnapshot(fake.X); err != nil {
+		t.Fatal(err)
+	}
+	checkBranches("master")
+}
+
+// TestPruneUpdateHistory checks that PruneUpdateHistory deletes the oldest
+// update history snapshots, except those pointed to by the latest links.
+func TestPruneUpdateHistory(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	dir := jirix.UpdateHistoryDir()
+	if err := jirix.NewSeq().MkdirAll(dir, 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	// Create snapshots 0 to 9, one hour apart, with 9 the newest, and point
+	// the latest links at the two oldest.
+	now := time.Now()
+	names := []string{}
+	for i := 0; i < 10; i++ {
+		name := now.Add(time.Duration(i-9) * time.Hour).Format(time.RFC3339)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("snapshot"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := jirix.NewSeq().
+		Symlink(names[0], jirix.UpdateHistoryLatestLink()).
+		Symlink(names[1], jirix.UpdateHistorySecondLatestLink()).Done(); err != nil {
+		t.Fatal(err)
+	}
+	remaining := func() []string {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := []string{}
+		for _, info := range infos {
+			if info.Mode().IsRegular() {
+				result = append(result, info.Name())
+			}
+		}
+		return result
+	}
+
+	// Without limits, nothing is deleted.
+	if removed, _, err := project.PruneUpdateHistory(jirix, 0, 0); err != nil || removed != 0 {
+		t.Errorf("got %d, %v, want 0, <nil>", removed, err)
+	}
+	// Keep the 5 newest snapshots, along with the two linked ones.
+	removed, size, err := project.PruneUpdateHistory(jirix, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := removed, 3; got != want {
+		t.Errorf("got %d removed, want %d", got, want)
+	}
+	if got, want := size, int64(3*len("snapshot")); got != want {
+		t.Errorf("got %d bytes removed, want %d", got, want)
+	}
+	want := append([]string{names[0], names[1]}, names[5:]...)
+	sort.Strings(want)
+	if got := remaining(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Delete the snapshots older than two and a half hours.
+	if _, _, err := project.PruneUpdateHistory(jirix, 0, 150*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	want = append([]string{names[0], names[1]}, names[7:]...)
+	sort.Strings(want)
+	if got := remaining(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestUpdateHistorySnapshots checks that LatestUpdateSnapshot and SnapshotAt
+// read the snapshots of the update history through both relative and
+// absolute links, and report a dangling link as no history.
+func TestUpdateHistorySnapshots(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	dir := jirix.UpdateHistoryDir()
+	if err := jirix.NewSeq().MkdirAll(dir, 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	checkSnapshot := func(manifest *project.Manifest, when time.Time, err error, want string) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == "" {
+			if manifest != nil {
+				t.Errorf("got snapshot %+v, want none", manifest)
+			}
+			return
+		}
+		if manifest == nil {
+			t.Fatalf("got no snapshot, want %q", want)
+		}
+		if got := manifest.Projects[0].Revision; got != want {
+			t.Errorf("got snapshot %q, want %q", got, want)
+		}
+		if got := when.Format(time.RFC3339); got != want {
+			t.Errorf("got time %q, want %q", got, want)
+		}
+	}
+
+	// Without update history, there is no snapshot.
+	manifest, when, err := project.LatestUpdateSnapshot(jirix)
+	checkSnapshot(manifest, when, err, "")
+
+	// Create snapshots 0 to 2, one hour apart, each recording its own name
+	// as the revision of a project.
+	now := time.Now()
+	names := []string{}
+	for i := 0; i < 3; i++ {
+		name := now.Add(time.Duration(i-2) * time.Hour).Format(time.RFC3339)
+		manifest := &project.Manifest{Projects: []project.Project{{Name: "p", Remote: "r", Revision: name}}}
+		if err := manifest.ToFile(jirix, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := jirix.NewSeq().
+		Symlink(names[2], jirix.UpdateHistoryLatestLink()).
+		Symlink(filepath.Join(dir, names[1]), jirix.UpdateHistorySecondLatestLink()).Done(); err != nil {
+		t.Fatal(err)
+	}
+	manifest, when, err = project.LatestUpdateSnapshot(jirix)
+	checkSnapshot(manifest, when, err, names[2])
+	manifest, when, err = project.SnapshotAt(jirix, "second-latest")
+	checkSnapshot(manifest, when, err, names[1])
+	manifest, when, err = project.SnapshotAt(jirix, names[0])
+	checkSnapshot(manifest, when, err, names[0])
+	// A time between two snapshots selects the older one.
+	between := now.Add(-90 * time.Minute).Format(time.RFC3339)
+	manifest, when, err = project.SnapshotAt(jirix, between)
+	checkSnapshot(manifest, when, err, names[0])
+	// A time before the oldest snapshot selects none.
+	before := now.Add(-3 * time.Hour).Format(time.RFC3339)
+	manifest, when, err = project.SnapshotAt(jirix, before)
+	checkSnapshot(manifest, when, err, "")
+	if _, _, err := project.SnapshotAt(jirix, "yesterday"); err == nil {
+		t.Errorf("SnapshotAt() did not fail for an invalid label")
+	}
+
+	// A link to a deleted snapshot is reported as no history.
+	if err := os.Remove(filepath.Join(dir, names[2])); err != nil {
+		t.Fatal(err)
+	}
+	manifest, when, err = project.LatestUpdateSnapshot(jirix)
+	checkSnapshot(manifest, when, err, "")
+}
+
+// TestUpdateHistoryPointerFiles checks that the update history can be written
+// and read with pointer files in place of the latest and second-latest
+// symlinks, as on systems without symlinks.
+func TestUpdateHistoryPointerFiles(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t, project.Project{Name: "p", Path: "p"})
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	dir := fake.X.UpdateHistoryDir()
+	latestLink, secondLatestLink := fake.X.UpdateHistoryLatestLink(), fake.X.UpdateHistorySecondLatestLink()
+	if err := project.WriteUpdateHistorySnapshot(fake.X, "", project.UpdateHistoryPointerFileOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(latestLink); !os.IsNotExist(err) {
+		t.Fatalf("the latest link is not a pointer file: %v", err)
+	}
+	first, err := project.LatestUpdate(fake.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || len(first.Projects) != 2 {
+		t.Fatalf("got latest update %+v, want one with the manifest project and p", first)
+	}
+	// The fast path of LocalProjects reads the latest snapshot.
+	localProjects, err := project.LocalProjects(fake.X, project.FastScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(localProjects) != 2 {
+		t.Errorf("got local projects %v, want 2", localProjects)
+	}
+
+	// Give the first snapshot an older name, so that the next one does not
+	// overwrite it.
+	older := filepath.Join(dir, time.Now().Add(-time.Hour).Format(time.RFC3339))
+	if err := os.Rename(first.File, older); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.X.WritePointer(latestLink, filepath.Base(older), jiri.PointerFileOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.WriteUpdateHistorySnapshot(fake.X, "", project.UpdateHistoryPointerFileOpt(true), project.UpdateHistoryKeepOpt(1)); err != nil {
+		t.Fatal(err)
+	}
+	_, when, err := project.SnapshotAt(fake.X, "second-latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := when.Format(time.RFC3339), filepath.Base(older); got != want {
+		t.Errorf("got second-latest snapshot %v, want %v", got, want)
+	}
+	// Pruning keeps the snapshots that the pointer files point to.
+	if _, err := os.Stat(older); err != nil {
+		t.Errorf("the second-latest snapshot was pruned: %v", err)
+	}
+	for _, link := range []string{latestLink, secondLatestLink} {
+		if _, err := os.Stat(link + jiri.PointerFileSuffix); err != nil {
+			t.Errorf("pointer file of %v: %v", link, err)
+		}
+	}
+}
+
+// TestUpdateHistoryUnchanged checks that WriteUpdateHistorySnapshot does not
+// write a snapshot that is identical to the latest one, unless
+// ForceSnapshotOpt is given.
+func TestUpdateHistoryUnchanged(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t, project.Project{Name: "p", Path: "p"})
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	dir := fake.X.UpdateHistoryDir()
+	latestLink := fake.X.UpdateHistoryLatestLink()
+
+	// Write the latest snapshot under an older name, so that the next one
+	// does not overwrite it.
+	older := filepath.Join(dir, time.Now().Add(-time.Hour).Format(time.RFC3339))
+	writeLatest := func() {
+		if err := project.CreateSnapshot(fake.X, older, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := fake.X.WritePointer(latestLink, filepath.Base(older)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkLatest := func(want string) {
+		got, err := jiri.ResolvePointer(latestLink)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (want == older) != (got == older) {
+			t.Errorf("got latest snapshot %v, want %v", got, want)
+		}
+	}
+	writeLatest()
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	if err := project.WriteUpdateHistorySnapshot(fake.X, ""); err != nil {
+		t.Fatal(err)
+	}
+	checkLatest(older)
+	if want := "Snapshot unchanged since " + filepath.Join(".jiri_root", "update_history", filepath.Base(older)); !strings.Contains(stdout.String(), want) {
+		t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if _, err := time.Parse(time.RFC3339, entry.Name()); err == nil && entry.Name() != filepath.Base(older) {
+			t.Errorf("unexpected snapshot %v", entry.Name())
+		}
+	}
+
+	// ForceSnapshotOpt writes the snapshot regardless.
+	if err := project.WriteUpdateHistorySnapshot(fake.X, "", project.ForceSnapshotOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	checkLatest("new")
+
+	// A change to a project is a new snapshot.
+	writeLatest()
+	writeReadme(t, fake.X, filepath.Join(fake.X.Root, "p"), "changed")
+	if err := project.WriteUpdateHistorySnapshot(fake.X, ""); err != nil {
+		t.Fatal(err)
+	}
+	checkLatest("new")
+}
+
+// TestUpdateUniverseManifestFlag checks that the -manifest flag selects the
+// manifest file that is loaded, and that the update history records it.
+func TestUpdateUniverseManifestFlag(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t, project.Project{Name: "p", Path: "p"})
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.WriteUpdateHistorySnapshot(fake.X, ""); err != nil {
+		t.Fatal(err)
+	}
+	checkManifestPath := func(want string) {
+		update, err := project.LatestUpdate(fake.X)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := update.ManifestPath; got != want {
+			t.Errorf("got manifest path %q, want %q", got, want)
+		}
+	}
+	checkManifestPath(".jiri_manifest")
+
+	// Write another manifest file with an additional project, and update
+	// from it.
+	if err := fake.CreateRemoteProject("extra"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := fake.ReadJiriManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Projects = append(m.Projects, project.Project{Name: "extra", Path: "extra", Remote: fake.Projects["extra"]})
+	if err := m.ToFile(fake.X, filepath.Join(fake.X.Root, "minimal")); err != nil {
+		t.Fatal(err)
+	}
+	manifest := "minimal"
+	x := fake.X.Clone(tool.ContextOpts{Manifest: &manifest})
+	if got, want := x.JiriManifestFile(), filepath.Join(fake.X.Root, "minimal"); got != want {
+		t.Errorf("got manifest file %q, want %q", got, want)
+	}
+	if err := project.UpdateUniverse(x, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.NewSeq().AssertDirExists(filepath.Join(fake.X.Root, "extra")).Done(); err != nil {
+		t.Errorf("expected project %q to be created: %v", "extra", err)
+	}
+	if err := project.WriteUpdateHistorySnapshot(x, ""); err != nil {
+		t.Fatal(err)
+	}
+	checkManifestPath("minimal")
+
+	// An update from the default manifest file warns about the change.
+	var stderr bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stderr: &stderr})
+	if err := project.WarnManifestChange(fake.X); err != nil {
+		t.Fatal(err)
+	}
+	if want := "last updated from minimal, not from .jiri_manifest"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("got output %q, want it to contain %q", stderr.String(), want)
+	}
+	stderr.Reset()
+	x = fake.X.Clone(tool.ContextOpts{Manifest: &manifest})
+	if err := project.WarnManifestChange(x); err != nil {
+		t.Fatal(err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("got output %q, want none", stderr.String())
+	}
+}
+
+// TestUpdateUniverseGroups checks that UpdateUniverse only creates the
+// projects of the enabled groups, and leaves the local projects of disabled
+// groups unchanged unless they are pruned.
+func TestUpdateUniverseGroups(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeUniverse(t,
+		project.Project{Name: "default-project", Path: "default-project"},
+		project.Project{Name: "web-project", Path: "web-project", Groups: "web,extra"},
+	)
+	defer cleanup()
+	s := fake.X.NewSeq()
+	webProject, err := fake.RemoteProject("web-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertDirExists(webProject.Path).Done(); err == nil {
+		t.Fatalf("project %q was created but its groups are not enabled", webProject.Name)
+	}
+
+	// Enable the web group.
+	if err := project.SetEnabledGroups(fake.X, []string{"web", project.DefaultGroup}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, webProject, jiritest.InitialReadme)
+
+	// Disable the web group, and check that the project is only deleted if
+	// the group is pruned.
+	if err := project.SetEnabledGroups(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(true); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, webProject, jiritest.InitialReadme)
+	if err := project.UpdateUniverse(fake.X, true, project.PruneGroupsOpt{"web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertDirExists(webProject.Path).Done(); err == nil {
+		t.Fatalf("project %q was not pruned", webProject.Name)
+	}
+}
+
+// TestUpdateUniverseImportRevision checks that UpdateUniverse loads remote
+// manifest imports at the specified revision.
+func TestUpdateUniverseImportRevision(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pin the remote manifest import to the current manifest revision.
+	git := gitutil.New(s, gitutil.RootDirOpt(fake.Projects["manifest"]))
+	rev, err := git.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := fake.ReadJiriManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Imports[0].Revision = rev
+	if err := fake.WriteJiriManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	// Add a new project to the remote manifest.
+	name := projectName(len(localProjects))
+	if err := fake.CreateRemoteProject(name); err != nil {
+		t.Fatal(err)
+	}
+	newProject := project.Project{
+		Name:   name,
+		Path:   filepath.Join(fake.X.Root, "path-new"),
+		Remote: fake.Projects[name],
+	}
+	if err := fake.AddProject(newProject); err != nil {
+		t.Fatal(err)
+	}
+	// Check that the new project is not created, since the manifest is pinned
+	// to the revision before it was added.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(newProject.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected project %q to not exist, got error %v", newProject.Path, err)
+	}
+	// Check that the new project is created once the pin is removed.
+	m.Imports[0].Revision = ""
+	if err := fake.WriteJiriManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertDirExists(newProject.Path).Done(); err != nil {
+		t.Fatalf("expected project to exist at path %q but none found", newProject.Path)
+	}
+}
+
+// TestUpdateUniverseFrozen checks that UpdateUniverse records the revisions
+// of the remote imports in the manifest lock, that FrozenOpt loads the
+// imports at these revisions, and that UpdateManifestLock updates them.
+func TestUpdateUniverseFrozen(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+
+	// Check that updating frozen fails without a manifest lock.  Setting up
+	// the universe updated it, which wrote a lock, so remove it first.
+	if err := s.RemoveAll(fake.X.ManifestLockFile()).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateUniverse(fake.X, false, project.FrozenOpt(true)); err == nil {
+		t.Fatalf("expected update without a manifest lock to fail")
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertFileExists(fake.X.ManifestLockFile()).Done(); err != nil {
+		t.Fatalf("expected the manifest lock to exist: %v", err)
+	}
+
+	// Add a new project to the remote manifest.
+	name := projectName(len(localProjects))
+	if err := fake.CreateRemoteProject(name); err != nil {
+		t.Fatal(err)
+	}
+	newProject := project.Project{
+		Name:   name,
+		Path:   filepath.Join(fake.X.Root, "path-new"),
+		Remote: fake.Projects[name],
+	}
+	if err := fake.AddProject(newProject); err != nil {
+		t.Fatal(err)
+	}
+	// Check that the new project is not created by a frozen update, since
+	// the lock records the revision of the manifest before it was added.
+	if err := project.UpdateUniverse(fake.X, false, project.FrozenOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(newProject.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected project %q to not exist, got error %v", newProject.Path, err)
+	}
+	// Check that the new project is created by a frozen update once the lock
+	// is updated.
+	if err := project.UpdateManifestLock(fake.X); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(newProject.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected project %q to not exist, got error %v", newProject.Path, err)
+	}
+	if err := project.UpdateUniverse(fake.X, false, project.FrozenOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertDirExists(newProject.Path).Done(); err != nil {
+		t.Fatalf("expected project to exist at path %q but none found", newProject.Path)
+	}
+
+	// Check that snapshots embed the manifest lock.
+	snapshot := filepath.Join(fake.X.Root, "snapshot")
+	if err := project.CreateSnapshot(fake.X, snapshot, ""); err != nil {
+		t.Fatal(err)
+	}
+	m, err := project.ManifestFromFile(fake.X, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(m.Locks), 1; got != want {
+		t.Fatalf("got %d import locks in the snapshot, want %d", got, want)
+	}
+}
+
+func TestUpdateManifests(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.WriteUpdateHistorySnapshot(fake.X, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that no changes are reported right after an update.
+	changes, err := project.UpdateManifests(fake.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("got changes %v, want none", changes)
+	}
+
+	// Add a new project to the remote manifest.
+	name := projectName(len(localProjects))
+	if err := fake.CreateRemoteProject(name); err != nil {
+		t.Fatal(err)
+	}
+	newProject := project.Project{
+		Name:   name,
+		Path:   filepath.Join(fake.X.Root, "path-new"),
+		Remote: fake.Projects[name],
+	}
+	if err := fake.AddProject(newProject); err != nil {
+		t.Fatal(err)
+	}
+	// Check that the new project is reported, until the next update, but
+	// not created.
+	want := []project.ManifestChange{{Kind: project.ChangeAdded, Name: name, Remote: newProject.Remote, NewRevision: "HEAD"}}
+	for i := 0; i < 2; i++ {
+		changes, err := project.UpdateManifests(fake.X)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(changes, want) {
+			t.Fatalf("got changes %v, want %v", changes, want)
+		}
+	}
+	if _, err := os.Stat(newProject.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected project %q to not exist, got error %v", newProject.Path, err)
+	}
+}
+
+func TestFileImportCycle(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	// Set up the cycle .jiri_manifest -> A -> B -> A
+	jiriManifest := project.Manifest{
+		LocalImports: []project.LocalImport{
+			{File: "A"},
+		},
+	}
+	manifestA := project.Manifest{
+		LocalImports: []project.LocalImport{
+			{File: "B"},
+		},
+	}
+	manifestB := project.Manifest{
+		LocalImports: []project.LocalImport{
+			{File: "A"},
+		},
+	}
+	if err := jiriManifest.ToFile(jirix, jirix.JiriManifestFile()); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifestA.ToFile(jirix, filepath.Join(jirix.Root, "A")); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifestB.ToFile(jirix, filepath.Join(jirix.Root, "B")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The update should complain about the cycle.
+	err := project.UpdateUniverse(jirix, false)
+	if got, want := fmt.Sprint(err), "import cycle detected in local manifest files"; !strings.Contains(got, want) {
+		t.Errorf("got error %v, want substr %v", got, want)
+	}
+}
+
+func TestRemoteImportCycle(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	// Set up two remote manifest projects, remote1 and remote1.
+	if err := fake.CreateRemoteProject("remote1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.CreateRemoteProject("remote2"); err != nil {
+		t.Fatal(err)
+	}
+	remote1 := fake.Projects["remote1"]
+	remote2 := fake.Projects["remote2"]
+
+	fileA, fileB := filepath.Join(remote1, "A"), filepath.Join(remote2, "B")
+
+	// Set up the cycle .jiri_manifest -> remote1+A -> remote2+B -> remote1+A
+	jiriManifest := project.Manifest{
+		Imports: []project.Import{
+			{Manifest: "A", Name: "n1", Remote: remote1},
+		},
+	}
+	manifestA := project.Manifest{
+		Imports: []project.Import{
+			{Manifest: "B", Name: "n2", Remote: remote2},
+		},
+	}
+	manifestB := project.Manifest{
+		Imports: []project.Import{
+			{Manifest: "A", Name: "n3", Remote: remote1},
+		},
+	}
+	if err := jiriManifest.ToFile(fake.X, fake.X.JiriManifestFile()); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifestA.ToFile(fake.X, fileA); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifestB.ToFile(fake.X, fileB); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, fake.X, remote1, fileA, "commit A")
+	commitFile(t, fake.X, remote2, fileB, "commit B")
+
+	// The update should complain about the cycle.
+	err := project.UpdateUniverse(fake.X, false)
+	if got, want := fmt.Sprint(err), "import cycle detected in remote manifest imports"; !strings.Contains(got, want) {
+		t.Errorf("got error %v, want substr %v", got, want)
+	}
+}
+
+// TestDuplicateConflict checks that the error for conflicting definitions of
+// a project or a tool reports both definitions with the imports that led to
+// them and the conflicting attributes, and that identical definitions are
+// merged.
+func TestDuplicateConflict(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	// Set up the imports .jiri_manifest -> remote1+A -> remote2+B.
+	for _, name := range []string{"remote1", "remote2", "p1"} {
+		if err := fake.CreateRemoteProject(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remote1 := fake.Projects["remote1"]
+	remote2 := fake.Projects["remote2"]
+	fileA, fileB := filepath.Join(remote1, "A"), filepath.Join(remote2, "B")
+	p1 := project.Project{Name: "p1", Path: "p1", Remote: fake.Projects["p1"]}
+	writeManifests := func(jiriProject, bProject project.Project, jiriTools, bTools []project.Tool) {
+		jiriManifest := project.Manifest{
+			Imports:  []project.Import{{Manifest: "A", Name: "n1", Remote: remote1}},
+			Projects: []project.Project{jiriProject},
+			Tools:    jiriTools,
+		}
+		manifestA := project.Manifest{
+			Imports: []project.Import{{Manifest: "B", Name: "n2", Remote: remote2}},
+		}
+		manifestB := project.Manifest{
+			Projects: []project.Project{bProject},
+			Tools:    bTools,
+		}
+		if err := jiriManifest.ToFile(fake.X, fake.X.JiriManifestFile()); err != nil {
+			t.Fatal(err)
+		}
+		if err := manifestA.ToFile(fake.X, fileA); err != nil {
+			t.Fatal(err)
+		}
+		if err := manifestB.ToFile(fake.X, fileB); err != nil {
+			t.Fatal(err)
+		}
+		commitFile(t, fake.X, remote1, fileA, "commit A")
+		commitFile(t, fake.X, remote2, fileB, "commit B")
+	}
+
+	// Check that identical definitions are merged.
+	writeManifests(p1, p1, nil, nil)
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that conflicting projects are reported with both sources.
+	conflicting := p1
+	conflicting.Revision = "abc123"
+	writeManifests(conflicting, p1, nil, nil)
+	err := project.UpdateUniverse(fake.X, false)
+	for _, want := range []string{
+		`duplicate project "` + string(p1.Key()) + `" with conflicting definitions`,
+		"B (imported by .jiri_manifest -> ",
+		"A)\nand in\n  .jiri_manifest\n",
+		`revision: "HEAD" vs "abc123"`,
+	} {
+		if got := fmt.Sprint(err); !strings.Contains(got, want) {
+			t.Errorf("got error %v, want substr %v", got, want)
+		}
+	}
+
+	// Check that conflicting tools are reported with both sources.
+	tool := project.Tool{Name: "tool", Package: "v.io/x/tool", Project: "p1"}
+	otherTool := tool
+	otherTool.Package = "v.io/x/other"
+	writeManifests(p1, p1, []project.Tool{otherTool}, []project.Tool{tool})
+	err = project.UpdateUniverse(fake.X, false)
+	for _, want := range []string{
+		`duplicate tool "tool" with conflicting definitions`,
+		"B (imported by .jiri_manifest -> ",
+		`package: "v.io/x/tool" vs "v.io/x/other"`,
+	} {
+		if got := fmt.Sprint(err); !strings.Contains(got, want) {
+			t.Errorf("got error %v, want substr %v", got, want)
+		}
+	}
+}
+
+func TestExcludeProject(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	// Set up the imports .jiri_manifest -> remote1+A -> remote2+B.
+	for _, name := range []string{"remote1", "remote2", "p1", "p2"} {
+		if err := fake.CreateRemoteProject(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remote1 := fake.Projects["remote1"]
+	remote2 := fake.Projects["remote2"]
+	fileA, fileB := filepath.Join(remote1, "A"), filepath.Join(remote2, "B")
+	p1 := project.Project{Name: "p1", Path: "p1", Remote: fake.Projects["p1"]}
+	p2 := project.Project{Name: "p2", Path: "p2", Remote: fake.Projects["p2"]}
+	writeManifests := func(jiriProjects, aProjects, bProjects []project.Project) {
+		jiriManifest := project.Manifest{
+			Imports:  []project.Import{{Manifest: "A", Name: "n1", Remote: remote1}},
+			Projects: jiriProjects,
+		}
+		manifestA := project.Manifest{
+			Imports:  []project.Import{{Manifest: "B", Name: "n2", Remote: remote2}},
+			Projects: aProjects,
+		}
+		manifestB := project.Manifest{
+			Projects: bProjects,
+		}
+		if err := jiriManifest.ToFile(fake.X, fake.X.JiriManifestFile()); err != nil {
+			t.Fatal(err)
+		}
+		if err := manifestA.ToFile(fake.X, fileA); err != nil {
+			t.Fatal(err)
+		}
+		if err := manifestB.ToFile(fake.X, fileB); err != nil {
+			t.Fatal(err)
+		}
+		commitFile(t, fake.X, remote1, fileA, "commit A")
+		commitFile(t, fake.X, remote2, fileB, "commit B")
+	}
+	checkExists := func(p project.Project, want bool) {
+		path := filepath.Join(fake.X.Root, p.Path)
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s: got error %v, want exists %v", path, err, want)
+		}
+	}
+
+	writeManifests(nil, nil, []project.Project{p1, p2})
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(p1, true)
+	checkExists(p2, true)
+
+	// Check that a stub in the root manifest excludes a project declared by
+	// an import, even if its attributes conflict, and that the local copy of
+	// the project is only deleted with gc.
+	stub := project.Project{Name: "p1", Revision: "abc123", Exclude: true}
+	writeManifests([]project.Project{stub}, nil, []project.Project{p1, p2})
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(p1, true)
+	if err := project.UpdateUniverse(fake.X, true); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(p1, false)
+	checkExists(p2, true)
+
+	// Check that a stub with a remote also excludes the projects declared
+	// after it, across import boundaries: B is loaded before the projects of
+	// A are collected.
+	stub = project.Project{Name: "p1", Remote: p1.Remote, Exclude: true}
+	writeManifests(nil, []project.Project{p1}, []project.Project{stub, p2})
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(p1, false)
+	checkExists(p2, true)
+
+	// Check that a stub with another remote does not exclude the project.
+	stub.Remote = p2.Remote
+	writeManifests(nil, []project.Project{p1}, []project.Project{stub, p2})
+	if err := project.UpdateUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(p1, true)
+
+	// Check that a stub excludes the project even if none of its groups is
+	// enabled.
+	stub = project.Project{Name: "p1", Groups: "disabled", Exclude: true}
+	writeManifests([]project.Project{stub}, []project.Project{p1}, []project.Project{p2})
+	if err := project.UpdateUniverse(fake.X, true); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(p1, false)
+	checkExists(p2, true)
+}
+
+func TestFileAndRemoteImportCycle(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	// Set up two remote manifest projects, remote1 and remote2.
+	// Set up two remote manifest projects, remote1 and remote1.
+	if err := fake.CreateRemoteProject("remote1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.CreateRemoteProject("remote2"); err != nil {
+		t.Fatal(err)
+	}
+	remote1 := fake.Projects["remote1"]
+	remote2 := fake.Projects["remote2"]
+	fileA, fileD := filepath.Join(remote1, "A"), filepath.Join(remote1, "D")
+	fileB, fileC := filepath.Join(remote2, "B"), filepath.Join(remote2, "C")
+
+	// Set up the cycle .jiri_manifest -> remote1+A -> remote2+B -> C -> remote1+D -> A
+	jiriManifest := project.Manifest{
+		Imports: []project.Import{
+			{Manifest: "A", Root: "r1", Name: "n1", Remote: remote1},
+		},
+	}
+	manifestA := project.Manifest{
+		Imports: []project.Import{
+			{Manifest: "B", Root: "r2", Name: "n2", Remote: remote2},
+		},
+	}
+	manifestB := project.Manifest{
+		LocalImports: []project.LocalImport{
+			{File: "C"},
+		},
+	}
+	manifestC := project.Manifest{
+		Imports: []project.Import{
+			{Manifest: "D", Root: "r3", Name: "n3", Remote: remote1},
+		},
+	}
+	manifestD := project.Manifest{
+		LocalImports: []project.LocalImport{
+			{File: "A"},
+		},
+	}
+	if err := jiriManifest.ToFile(fake.X, fake.X.JiriManifestFile()); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifestA.ToFile(fake.X, fileA); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifestB.ToFile(fake.X, fileB); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifestC.ToFile(fake.X, fileC); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifestD.ToFile(fake.X, fileD); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, fake.X, remote1, fileA, "commit A")
+	commitFile(t, fake.X, remote2, fileB, "commit B")
+	commitFile(t, fake.X, remote2, fileC, "commit C")
+	commitFile(t, fake.X, remote1, fileD, "commit D")
+
+	// The update should complain about the cycle.
+	err := project.UpdateUniverse(fake.X, false)
+	if got, want := fmt.Sprint(err), "import cycle detected"; !strings.Contains(got, want) {
+		t.Errorf("got error %v, want substr %v", got, want)
+	}
+}
+
+// TestUnsupportedProtocolErr checks that calling
+// UnsupportedPrototoclErr.Error() does not result in an infinite loop.
+func TestUnsupportedPrototocolErr(t *testing.T) {
+	err := project.UnsupportedProtocolErr("foo")
+	_ = err.Error()
+}
+
+type binDirTest struct {
+	Name        string
+	Setup       func(old, new string) error
+	Teardown    func(old, new string) error
+	Error       string
+	CheckBackup bool
+}
+
+func TestTransitionBinDir(t *testing.T) {
+	tests := []binDirTest{
+		{
+			"No old dir",
+			func(old, new string) error { return nil },
+			nil,
+			"",
+			false,
+		},
+		{
+			"Empty old dir",
+			func(old, new string) error {
+				return os.MkdirAll(old, 0777)
+			},
+			nil,
+			"",
+			true,
+		},
+		{
+			"Populated old dir",
+			func(old, new string) error {
+				if err := os.MkdirAll(old, 0777); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(filepath.Join(old, "tool"), []byte("foo"), 0777)
+			},
+			nil,
+			"",
+			true,
+		},
+		{
+			"Symlinked old dir",
+			func(old, new string) error {
+				if err := os.MkdirAll(filepath.Dir(old), 0777); err != nil {
+					return err
+				}
+				return os.Symlink(new, old)
+			},
+			nil,
+			"",
+			false,
+		},
+		{
+			"Symlinked old dir pointing elsewhere",
+			func(old, new string) error {
+				if err := os.MkdirAll(filepath.Dir(old), 0777); err != nil {
+					return err
+				}
+				return os.Symlink(filepath.Dir(new), old)
+			},
+			nil,
+			"",
+			true,
+		},
+		{
+			"Unreadable old dir parent",
+			func(old, new string) error {
+				if err := os.MkdirAll(old, 0777); err != nil {
+					return err
+				}
+				return os.Chmod(filepath.Dir(old), 0222)
+			},
+			func(old, new string) error {
+				return os.Chmod(filepath.Dir(old), 0777)
+			},
+			"Failed to stat old bin dir",
+			false,
+		},
+		{
+			"Unwritable old dir",
+			func(old, new string) error {
+				if err := os.MkdirAll(old, 0777); err != nil {
+					return err
+				}
+				return os.Chmod(old, 0444)
+			},
+			func(old, new string) error {
+				return os.Chmod(old, 0777)
+			},
+			"Failed to backup old bin dir",
+			false,
+		},
+		{
+			"Unreadable backup dir parent",
+			func(old, new string) error {
+				if err := os.MkdirAll(old, 0777); err != nil {
+					return err
+				}
+				return os.Chmod(filepath.Dir(new), 0222)
+			},
+			func(old, new string) error {
+				return os.Chmod(filepath.Dir(new), 0777)
+			},
+			"Failed to stat backup bin dir",
+			false,
+		},
+		{
+			"Existing backup dir",
+			func(old, new string) error {
+				if err := os.MkdirAll(old, 0777); err != nil {
+					return err
+				}
+				return os.MkdirAll(new+".BACKUP", 0777)
+			},
+			nil,
+			"Backup bin dir",
+			false,
+		},
+	}
+	for _, test := range tests {
+		jirix, cleanup := jiritest.NewX(t)
+		if err := testTransitionBinDir(jirix, test); err != nil {
+			t.Errorf("%s: %v", test.Name, err)
+		}
+		cleanup()
+	}
+}
+
+func testTransitionBinDir(jirix *jiri.X, test binDirTest) (e error) {
+	oldDir, newDir := filepath.Join(jirix.Root, "devtools", "bin"), jirix.BinDir()
+	// The new bin dir always exists.
+	if err := os.MkdirAll(newDir, 0777); err != nil {
+		return fmt.Errorf("make new dir failed: %v", err)
+	}
+	if err := test.Setup(oldDir, newDir); err != nil {
+		return fmt.Errorf("setup failed: %v", err)
+	}
+	if test.Teardown != nil {
+		defer func() {
+			if err := test.Teardown(oldDir, newDir); err != nil && e == nil {
+				e = fmt.Errorf("teardown failed: %v", err)
+			}
+		}()
+	}
+	oldInfo, _ := os.Stat(oldDir)
+	switch err := project.TransitionBinDir(jirix); {
+	case err != nil && test.Error == "":
+		return fmt.Errorf("got error %q, want success", err)
+	case err != nil && !strings.Contains(fmt.Sprint(err), test.Error):
+		return fmt.Errorf("got error %q, want prefix %q", err, test.Error)
+	case err == nil && test.Error != "":
+		return fmt.Errorf("got no error, want %q", test.Error)
+	case err == nil && test.Error == "":
+		// Make sure the symlink exists and is correctly linked.
+		link, err := os.Readlink(oldDir)
+		if err != nil {
+			return fmt.Errorf("old dir isn't a symlink: %v", err)
+		}
+		if got, want := link, newDir; got != want {
+			return fmt.Errorf("old dir symlink got %v, want %v", got, want)
 		}
 		if test.CheckBackup {
 			// Make sure the oldDir was backed up correctly.
@@ -801,101 +3774,889 @@ func testTransitionBinDir(jirix *jiri.X, test binDirTest) (e error) {
 			}
 		}
 	}
-	return nil
+	return nil
+}
+
+func TestManifestToFromBytes(t *testing.T) {
+	tests := []struct {
+		Manifest project.Manifest
+		XML      string
+	}{
+		{
+			project.Manifest{},
+			`<manifest>
+</manifest>
+`,
+		},
+		{
+			project.Manifest{
+				Imports: []project.Import{
+					{
+						Manifest:     "manifest1",
+						Name:         "remoteimport1",
+						Protocol:     "git",
+						Remote:       "remote1",
+						RemoteBranch: "master",
+						Revision:     "HEAD",
+					},
+					{
+						Manifest:     "manifest2",
+						Name:         "remoteimport2",
+						Protocol:     "git",
+						Remote:       "remote2",
+						RemoteBranch: "branch2",
+						Revision:     "rev2",
+					},
+				},
+				LocalImports: []project.LocalImport{
+					{File: "fileimport"},
+				},
+				Projects: []project.Project{
+					{
+						Name:         "project1",
+						Path:         "path1",
+						Protocol:     "git",
+						Remote:       "remote1",
+						RemoteBranch: "master",
+						Revision:     "HEAD",
+						GerritHost:   "https://test-review.googlesource.com",
+						GitHooks:     "path/to/githooks",
+						RunHook:      "path/to/hook",
+					},
+					{
+						Name:         "project2",
+						Path:         "path2",
+						Protocol:     "git",
+						Remote:       "remote2",
+						RemoteBranch: "branch2",
+						Revision:     "rev2",
+					},
+				},
+				Tools: []project.Tool{
+					{
+						Data:    "tooldata",
+						Name:    "tool",
+						Project: "toolproject",
+					},
+					{
+						BuildFlags: "-tags=leveldb",
+						Data:       "data",
+						Env:        "CGO_ENABLED=0",
+						Name:       "tool2",
+						Project:    "toolproject",
+					},
+				},
+				PostUpdates: []project.PostUpdate{
+					{Command: "make all", Project: "project1"},
+					{Command: "true", FailOk: true},
+				},
+			},
+			`<manifest>
+  <imports>
+    <import manifest="manifest1" name="remoteimport1" remote="remote1"/>
+    <import manifest="manifest2" name="remoteimport2" remote="remote2" remotebranch="branch2" revision="rev2"/>
+    <localimport file="fileimport"/>
+  </imports>
+  <projects>
+    <project name="project1" path="path1" remote="remote1" gerrithost="https://test-review.googlesource.com" githooks="path/to/githooks" runhook="path/to/hook"/>
+    <project name="project2" path="path2" remote="remote2" remotebranch="branch2" revision="rev2"/>
+  </projects>
+  <tools>
+    <tool data="tooldata" name="tool" project="toolproject"/>
+    <tool buildflags="-tags=leveldb" env="CGO_ENABLED=0" name="tool2" project="toolproject"/>
+  </tools>
+  <postupdates>
+    <postupdate command="make all" project="project1"/>
+    <postupdate command="true" failok="true"/>
+  </postupdates>
+</manifest>
+`,
+		},
+	}
+	for _, test := range tests {
+		gotBytes, err := test.Manifest.ToBytes()
+		if err != nil {
+			t.Errorf("%+v ToBytes failed: %v", test.Manifest, err)
+		}
+		if got, want := string(gotBytes), test.XML; got != want {
+			t.Errorf("%+v ToBytes GOT\n%v\nWANT\n%v", test.Manifest, got, want)
+		}
+		manifest, err := project.ManifestFromBytes([]byte(test.XML))
+		if err != nil {
+			t.Errorf("%+v FromBytes failed: %v", test.Manifest, err)
+		}
+		if got, want := manifest, &test.Manifest; !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v FromBytes got %#v, want %#v", test.Manifest, got, want)
+		}
+	}
+}
+
+// TestManifestBadTool checks that invalid tool build flags and environments
+// are rejected when the manifest is loaded.
+// TestManifestSort checks that manifests with the same contents in different
+// orders are serialized to the same bytes after sorting.
+func TestManifestSort(t *testing.T) {
+	imports := []project.Import{
+		{Remote: "remote2", Manifest: "a"},
+		{Remote: "remote1", Manifest: "b"},
+		{Remote: "remote1", Manifest: "a"},
+	}
+	localImports := []project.LocalImport{{File: "b"}, {File: "a"}}
+	projects := []project.Project{
+		{Name: "p2", Path: "p2", Remote: "remote-p2"},
+		{Name: "p1", Path: "p1b", Remote: "remote-p1b"},
+		{Name: "p1", Path: "p1a", Remote: "remote-p1a"},
+	}
+	tools := []project.Tool{{Name: "tool2", Project: "p2"}, {Name: "tool1", Project: "p1"}}
+	reverse := func(n int, swap func(i, j int)) {
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+	var data [][]byte
+	for i := 0; i < 2; i++ {
+		m := &project.Manifest{
+			Imports:      append([]project.Import(nil), imports...),
+			LocalImports: append([]project.LocalImport(nil), localImports...),
+			Projects:     append([]project.Project(nil), projects...),
+			Tools:        append([]project.Tool(nil), tools...),
+		}
+		if i == 1 {
+			reverse(len(m.Imports), func(i, j int) { m.Imports[i], m.Imports[j] = m.Imports[j], m.Imports[i] })
+			reverse(len(m.LocalImports), func(i, j int) { m.LocalImports[i], m.LocalImports[j] = m.LocalImports[j], m.LocalImports[i] })
+			reverse(len(m.Projects), func(i, j int) { m.Projects[i], m.Projects[j] = m.Projects[j], m.Projects[i] })
+			reverse(len(m.Tools), func(i, j int) { m.Tools[i], m.Tools[j] = m.Tools[j], m.Tools[i] })
+		}
+		m.Sort()
+		if got, want := m.Imports[0], (project.Import{Remote: "remote1", Manifest: "a"}); got != want {
+			t.Errorf("got first import %#v, want %#v", got, want)
+		}
+		if got, want := m.LocalImports[0].File, "a"; got != want {
+			t.Errorf("got first local import %q, want %q", got, want)
+		}
+		if got, want := m.Tools[0].Name, "tool1"; got != want {
+			t.Errorf("got first tool %q, want %q", got, want)
+		}
+		out, err := m.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, out)
+	}
+	if string(data[0]) != string(data[1]) {
+		t.Errorf("sorted manifests differ:\n%s\n%s", data[0], data[1])
+	}
+}
+
+// TestCreateSnapshotDeterministic checks that snapshots of the same projects
+// are byte-identical.
+func TestCreateSnapshotDeterministic(t *testing.T) {
+	var projects []project.Project
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("p%d", i)
+		projects = append(projects, project.Project{Name: name, Path: name})
+	}
+	fake, cleanup := jiritest.NewFakeUniverse(t, projects...)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	var data []string
+	for i := 0; i < 3; i++ {
+		file := filepath.Join(fake.X.Root, fmt.Sprintf("snapshot%d", i))
+		if err := project.CreateSnapshot(fake.X, file, "snapshot"); err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, string(out))
+	}
+	for i := 1; i < len(data); i++ {
+		if data[i] != data[0] {
+			t.Errorf("snapshots differ:\n%s\n%s", data[0], data[i])
+		}
+	}
+}
+
+// TestTagSnapshotProjects checks that TagSnapshotProjects tags the recorded
+// revision of each project, and only moves existing tags if forced.
+func TestTagSnapshotProjects(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	tagRevision := func(p project.Project) string {
+		revision, err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(p.Path)).TagRevision("v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return revision
+	}
+	currentRevision := func(p project.Project) string {
+		revision, err := gitutil.New(fake.X.NewSeq(), gitutil.RootDirOpt(p.Path)).CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return revision
+	}
+	snapshot := filepath.Join(fake.X.Root, "snapshot")
+	if err := project.CreateSnapshot(fake.X, snapshot, "", project.SnapshotTagOpt("v1")); err != nil {
+		t.Fatal(err)
+	}
+	m, err := project.ManifestFromFile(fake.X, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Tag, "v1"; got != want {
+		t.Errorf("got tag %q, want %q", got, want)
+	}
+	// Tagging twice is a no-op the second time.
+	for i := 0; i < 2; i++ {
+		if err := project.TagSnapshotProjects(fake.X, snapshot, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldRevision := currentRevision(localProjects[0])
+	for _, p := range localProjects {
+		if got, want := tagRevision(p), currentRevision(p); got != want {
+			t.Errorf("project %q: got tag at %q, want %q", p.Name, got, want)
+		}
+	}
+
+	// Check that a tag pointing elsewhere is only moved with force.
+	if _, err := fake.AddCommit(localProjects[0].Name, "README", "new readme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.CreateSnapshot(fake.X, snapshot, "", project.SnapshotTagOpt("v1")); err != nil {
+		t.Fatal(err)
+	}
+	err = project.TagSnapshotProjects(fake.X, snapshot, false)
+	if err == nil || !strings.Contains(err.Error(), localProjects[0].Name) {
+		t.Errorf("got error %v, want it to name project %q", err, localProjects[0].Name)
+	}
+	if got, want := tagRevision(localProjects[0]), oldRevision; got != want {
+		t.Errorf("got tag at %q, want %q", got, want)
+	}
+	if err := project.TagSnapshotProjects(fake.X, snapshot, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tagRevision(localProjects[0]), currentRevision(localProjects[0]); got != want {
+		t.Errorf("got tag at %q, want %q", got, want)
+	}
+}
+
+// TestRemoteHost checks that the host of a remote is found in URLs and in
+// scp-like remotes, which are named in authentication errors.
+func TestRemoteHost(t *testing.T) {
+	tests := []struct {
+		remote, want string
+	}{
+		{"https://example.googlesource.com/repo", "example.googlesource.com"},
+		{"ssh://git@example.com:29418/repo", "example.com:29418"},
+		{"git@github.com:org/repo.git", "github.com"},
+		{"example.com:repo", "example.com"},
+		{"/path/to/repo", "/path/to/repo"},
+	}
+	for _, test := range tests {
+		if got := project.InternalRemoteHost(test.remote); got != test.want {
+			t.Errorf("remoteHost(%q) = %q, want %q", test.remote, got, test.want)
+		}
+	}
+}
+
+func TestManifestBadTool(t *testing.T) {
+	tests := []struct {
+		attrs, want string
+	}{
+		{`buildflags="tags=leveldb"`, `bad tool "tool": build flag "tags=leveldb"`},
+		{`env="CGO_ENABLED"`, `bad tool "tool": environment variable "CGO_ENABLED"`},
+		{`env="=0"`, `bad tool "tool": environment variable "=0"`},
+		{`env="GOBIN=/tmp"`, `bad tool "tool": environment variable GOBIN`},
+		{`os="linux,"`, `bad tool "tool": os "linux," has an empty element`},
+		{`arch=","`, `bad tool "tool": arch "," has an empty element`},
+	}
+	for _, test := range tests {
+		xml := `<manifest><tools><tool name="tool" ` + test.attrs + `/></tools></manifest>`
+		_, err := project.ManifestFromBytes([]byte(xml))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want it to contain %q", test.attrs, err, test.want)
+		}
+	}
+}
+
+// TestManifestGerritRemote checks that a project with a gerritremote but no
+// gerrithost is rejected.
+func TestManifestGerritRemote(t *testing.T) {
+	xml := `<manifest><projects><project name="p" remote="r" gerritremote="review"/></projects></manifest>`
+	_, err := project.ManifestFromBytes([]byte(xml))
+	if want := "gerritremote requires gerrithost"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+	xml = `<manifest><projects><project name="p" remote="r" gerrithost="https://h" gerritremote="review"/></projects></manifest>`
+	if _, err := project.ManifestFromBytes([]byte(xml)); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestManifestReviewHost checks that a project with both a reviewhost and a
+// gerrithost is rejected.
+func TestManifestReviewHost(t *testing.T) {
+	xml := `<manifest><projects><project name="p" remote="r" gerrithost="https://h" reviewhost="https://github.com/o/p"/></projects></manifest>`
+	_, err := project.ManifestFromBytes([]byte(xml))
+	if want := "reviewhost and gerrithost cannot both be set"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+	xml = `<manifest><projects><project name="p" remote="r" reviewhost="https://github.com/o/p"/></projects></manifest>`
+	if _, err := project.ManifestFromBytes([]byte(xml)); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestManifestHookEnv checks that a project with an unknown hookenv is
+// rejected.
+func TestManifestHookEnv(t *testing.T) {
+	xml := `<manifest><projects><project name="p" remote="r" hookenv="dirty"/></projects></manifest>`
+	_, err := project.ManifestFromBytes([]byte(xml))
+	if want := `hookenv must be "inherit" or "clean"`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+	xml = `<manifest><projects><project name="p" remote="r" hookenv="clean" hookprofiles="a,b"/></projects></manifest>`
+	if _, err := project.ManifestFromBytes([]byte(xml)); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// TestManifestVerify checks that invalid verify attributes of projects and
+// imports are rejected.
+func TestManifestVerify(t *testing.T) {
+	for _, xml := range []string{
+		`<manifest><projects><project name="p" remote="r" verify="signature"/></projects></manifest>`,
+		`<manifest><imports><import name="m" remote="r" manifest="m" verify="signature"/></imports></manifest>`,
+	} {
+		if _, err := project.ManifestFromBytes([]byte(xml)); err != nil {
+			t.Errorf("%s: %v", xml, err)
+		}
+	}
+	for _, xml := range []string{
+		`<manifest><projects><project name="p" remote="r" verify="gpg"/></projects></manifest>`,
+		`<manifest><imports><import name="m" remote="r" manifest="m" verify="gpg"/></imports></manifest>`,
+	} {
+		_, err := project.ManifestFromBytes([]byte(xml))
+		if want := `verify must be "signature"`; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want it to contain %q", xml, err, want)
+		}
+	}
+}
+
+// TestManifestCloneFilter checks that a clonefilter survives a round trip
+// through a manifest, and that invalid clonefilters are rejected.
+func TestManifestCloneFilter(t *testing.T) {
+	for _, filter := range []string{"blob:none", "blob:limit=1m", "tree:0"} {
+		m := &project.Manifest{Projects: []project.Project{{Name: "p", Remote: "r", CloneFilter: filter}}}
+		data, err := m.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `clonefilter="` + filter + `"`; !strings.Contains(string(data), want) {
+			t.Errorf("got manifest %s, want it to contain %s", data, want)
+		}
+		got, err := project.ManifestFromBytes(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Projects[0].CloneFilter != filter {
+			t.Errorf("got clonefilter %q, want %q", got.Projects[0].CloneFilter, filter)
+		}
+	}
+	for _, test := range []struct{ attrs, want string }{
+		{`clonefilter="blob"`, "invalid clonefilter"},
+		{`clonefilter="tree:"`, "invalid clonefilter"},
+		{`clonefilter="blob:none" protocol="svn"`, "clonefilter requires the git protocol"},
+	} {
+		xml := `<manifest><projects><project name="p" remote="r" ` + test.attrs + `/></projects></manifest>`
+		_, err := project.ManifestFromBytes([]byte(xml))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want it to contain %q", test.attrs, err, test.want)
+		}
+	}
+}
+
+func TestManifestSparseCheckout(t *testing.T) {
+	m := &project.Manifest{Projects: []project.Project{{Name: "p", Remote: "r", SparseCheckout: "dir1,dir2/sub"}}}
+	data, err := m.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `sparsecheckout="dir1,dir2/sub"`; !strings.Contains(string(data), want) {
+		t.Errorf("got manifest %s, want it to contain %s", data, want)
+	}
+	got, err := project.ManifestFromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := got.Projects[0].SparseCheckout, m.Projects[0].SparseCheckout; got != want {
+		t.Errorf("got sparsecheckout %q, want %q", got, want)
+	}
+	for _, dirs := range []string{"/abs", "../up", "a/../b", ".", "a//b"} {
+		xml := `<manifest><projects><project name="p" remote="r" sparsecheckout="` + dirs + `"/></projects></manifest>`
+		_, err := project.ManifestFromBytes([]byte(xml))
+		if want := "is not a clean relative path"; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want it to contain %q", dirs, err, want)
+		}
+	}
 }
 
-func TestManifestToFromBytes(t *testing.T) {
+// TestManifestVersion checks that manifests without a version or with a
+// supported major version are loaded, and that newer major versions are
+// refused.
+func TestManifestVersion(t *testing.T) {
 	tests := []struct {
-		Manifest project.Manifest
-		XML      string
+		version string
+		wantErr string
 	}{
-		{
-			project.Manifest{},
-			`<manifest>
-</manifest>
-`,
-		},
-		{
-			project.Manifest{
-				Imports: []project.Import{
-					{
-						Manifest:     "manifest1",
-						Name:         "remoteimport1",
-						Protocol:     "git",
-						Remote:       "remote1",
-						RemoteBranch: "master",
-					},
-					{
-						Manifest:     "manifest2",
-						Name:         "remoteimport2",
-						Protocol:     "git",
-						Remote:       "remote2",
-						RemoteBranch: "branch2",
-					},
-				},
-				LocalImports: []project.LocalImport{
-					{File: "fileimport"},
-				},
-				Projects: []project.Project{
-					{
-						Name:         "project1",
-						Path:         "path1",
-						Protocol:     "git",
-						Remote:       "remote1",
-						RemoteBranch: "master",
-						Revision:     "HEAD",
-						GerritHost:   "https://test-review.googlesource.com",
-						GitHooks:     "path/to/githooks",
-						RunHook:      "path/to/hook",
-					},
-					{
-						Name:         "project2",
-						Path:         "path2",
-						Protocol:     "git",
-						Remote:       "remote2",
-						RemoteBranch: "branch2",
-						Revision:     "rev2",
-					},
-				},
-				Tools: []project.Tool{
-					{
-						Data:    "tooldata",
-						Name:    "tool",
-						Project: "toolproject",
-					},
-				},
-			},
-			`<manifest>
+		{"", ""},
+		{`version="1"`, ""},
+		{`version="1.7"`, ""},
+		{`version="2.0"`, "only supports manifest versions up to " + project.SupportedManifestVersion},
+		{`version="one"`, "invalid manifest version"},
+	}
+	for _, test := range tests {
+		xml := `<manifest ` + test.version + `><projects><project name="p" remote="r"/></projects></manifest>`
+		m, err := project.ManifestFromBytes([]byte(xml))
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.version, err)
+			} else if len(m.Projects) != 1 {
+				t.Errorf("%s: got projects %v, want one", test.version, m.Projects)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got error %v, want it to contain %q", test.version, err, test.wantErr)
+		}
+	}
+	if _, err := project.ManifestFromBytes([]byte(`<manifest version="2"/>`)); !project.IsManifestVersionError(err) {
+		t.Errorf("got error %v, want a manifest version error", err)
+	}
+}
+
+// TestManifestUnknownFields checks that ManifestFromFile warns about the
+// elements and attributes of a manifest that jiri does not know.
+func TestManifestUnknownFields(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	var stderr bytes.Buffer
+	jirix.Context = tool.NewContext(tool.ContextOpts{Stderr: &stderr})
+	file := filepath.Join(jirix.Root, "manifest")
+	load := func(data string) {
+		stderr.Reset()
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := project.ManifestFromFile(jirix, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	load(`<manifest version="1.0" snapshotpath="s">
+  <!-- comment -->
   <imports>
-    <import manifest="manifest1" name="remoteimport1" remote="remote1"/>
-    <import manifest="manifest2" name="remoteimport2" remote="remote2" remotebranch="branch2"/>
-    <localimport file="fileimport"/>
+    <import name="i" remote="r" manifest="m" groups="g"/>
+    <localimport file="f"/>
   </imports>
   <projects>
-    <project name="project1" path="path1" remote="remote1" gerrithost="https://test-review.googlesource.com" githooks="path/to/githooks" runhook="path/to/hook"/>
-    <project name="project2" path="path2" remote="remote2" remotebranch="branch2" revision="rev2"/>
+    <project name="p" remote="r" revision="abc" githooks="h"/>
   </projects>
-  <tools>
-    <tool data="tooldata" name="tool" project="toolproject"/>
-  </tools>
-</manifest>
-`,
-		},
+  <tools><tool name="t" package="pkg" project="p"/></tools>
+</manifest>`)
+	if stderr.Len() != 0 {
+		t.Errorf("got warning %q for a known manifest, want none", stderr.String())
 	}
-	for _, test := range tests {
-		gotBytes, err := test.Manifest.ToBytes()
+
+	load(`<manifest version="1.1">
+  <projects>
+    <project name="p" remote="r" depth="1"/>
+    <project name="q" remote="r" depth="1"/>
+  </projects>
+  <hooks><hook name="h"><action/></hook></hooks>
+</manifest>`)
+	for _, want := range []string{"attribute manifest>projects>project@depth", "element manifest>hooks"} {
+		if got := stderr.String(); strings.Count(got, want) != 1 {
+			t.Errorf("got warning %q, want it to contain %q once", got, want)
+		}
+	}
+	if strings.Contains(stderr.String(), "action") {
+		t.Errorf("got warning %q, want no children of unknown elements", stderr.String())
+	}
+}
+
+// TestInstallTools checks that InstallTools installs tools as a set, keeping
+// the previous set for RollbackTools, and restores the previous set if a tool
+// fails to install.
+func TestInstallTools(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	s := jirix.NewSeq()
+	binDir, previousDir := jirix.BinDir(), jirix.PreviousBinDir()
+	// install installs tools with the given contents; a tool whose content
+	// is empty is a directory, which cannot replace a non-empty directory.
+	install := func(tools map[string]string) error {
+		dir, err := s.TempDir("", "install-tools")
 		if err != nil {
-			t.Errorf("%+v ToBytes failed: %v", test.Manifest, err)
+			t.Fatal(err)
 		}
-		if got, want := string(gotBytes), test.XML; got != want {
-			t.Errorf("%+v ToBytes GOT\n%v\nWANT\n%v", test.Manifest, got, want)
+		defer jirix.NewSeq().RemoveAll(dir)
+		for name, content := range tools {
+			if content == "" {
+				s.MkdirAll(filepath.Join(dir, name), 0755)
+			} else {
+				s.WriteFile(filepath.Join(dir, name), []byte(content), 0755)
+			}
 		}
-		manifest, err := project.ManifestFromBytes([]byte(test.XML))
+		if err := s.Done(); err != nil {
+			t.Fatal(err)
+		}
+		return project.InstallTools(jirix, dir)
+	}
+	checkTools := func(dir string, want map[string]string) {
+		fis, err := ioutil.ReadDir(dir)
 		if err != nil {
-			t.Errorf("%+v FromBytes failed: %v", test.Manifest, err)
+			t.Fatal(err)
 		}
-		if got, want := manifest, &test.Manifest; !reflect.DeepEqual(got, want) {
-			t.Errorf("%+v FromBytes got %#v, want %#v", test.Manifest, got, want)
+		got := map[string]string{}
+		for _, fi := range fis {
+			data, _ := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+			got[fi.Name()] = string(data)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got tools %v in %s, want %v", got, dir, want)
+		}
+	}
+
+	if err := install(map[string]string{"alpha": "1", "zeta": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := install(map[string]string{"alpha": "2", "zeta": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	checkTools(binDir, map[string]string{"alpha": "2", "zeta": "2"})
+	checkTools(previousDir, map[string]string{"alpha": "1", "zeta": "1"})
+
+	// A failure restores the tools that were installed before, and removes
+	// the new ones.
+	if err := s.RemoveAll(filepath.Join(binDir, "zeta")).MkdirAll(filepath.Join(binDir, "zeta", "dir"), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := install(map[string]string{"alpha": "3", "beta": "3", "zeta": ""}); err == nil {
+		t.Fatalf("InstallTools() did not fail")
+	}
+	checkTools(binDir, map[string]string{"alpha": "2", "zeta": ""})
+	checkTools(previousDir, map[string]string{"alpha": "1", "zeta": "1"})
+	if err := s.RemoveAll(filepath.Join(binDir, "zeta")).WriteFile(filepath.Join(binDir, "zeta"), []byte("2"), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	// RollbackTools swaps the previous tools in, and back out again.
+	if err := project.RollbackTools(jirix); err != nil {
+		t.Fatal(err)
+	}
+	checkTools(binDir, map[string]string{"alpha": "1", "zeta": "1"})
+	checkTools(previousDir, map[string]string{"alpha": "2", "zeta": "2"})
+	if err := project.RollbackTools(jirix); err != nil {
+		t.Fatal(err)
+	}
+	checkTools(binDir, map[string]string{"alpha": "2", "zeta": "2"})
+}
+
+// TestBuildToolsFlags checks that BuildTools builds tools with their own build
+// flags and environment.  The two tools cannot be built by the same "go
+// install", since each fails to build with the flags of the other.
+func TestBuildToolsFlags(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	projectDir := filepath.Join(jirix.Root, "go", "src", "example.com", "tools")
+	files := map[string]string{
+		"a/main.go": "// +build tagged\n\npackage main\n\nfunc main() {}\n",
+		"b/main.go": "// +build !tagged\n\npackage main\n\nfunc main() {}\n",
+		"b/cgo.go":  "// +build cgo\n\npackage main\n\nvar _ = cgoMustBeDisabled\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(projectDir, name)
+		if err := jirix.NewSeq().MkdirAll(filepath.Dir(path), 0755).WriteFile(path, []byte(content), 0644).Done(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := project.Project{Name: "tools", Path: projectDir, Remote: "tools"}
+	projects := project.Projects{p.Key(): p}
+	tools := project.Tools{
+		"a": project.Tool{Name: "a", Package: "example.com/tools/a", Project: "tools", BuildFlags: "-tags=tagged", Env: "GO111MODULE=off GOFLAGS="},
+		"b": project.Tool{Name: "b", Package: "example.com/tools/b", Project: "tools", Env: "CGO_ENABLED=0 GO111MODULE=off GOFLAGS="},
+	}
+	outputDir := filepath.Join(jirix.Root, "bin")
+	if err := project.BuildTools(jirix, projects, tools, outputDir); err != nil {
+		t.Fatal(err)
+	}
+	for name := range tools {
+		if err := jirix.NewSeq().AssertFileExists(filepath.Join(outputDir, name)).Done(); err != nil {
+			t.Errorf("tool %q was not installed: %v", name, err)
+		}
+	}
+}
+
+// TestBuildToolsPerTool checks that BuildTools skips the tools that are not
+// built on the host, and that a tool that fails to build does not prevent the
+// others from being built.
+func TestBuildToolsPerTool(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	projectDir := filepath.Join(jirix.Root, "go", "src", "example.com", "tools")
+	files := map[string]string{
+		"a/main.go":      "package main\n\nfunc main() {}\n",
+		"b/main.go":      "package main\n\nfunc main() { undefined() }\n",
+		"c/main.go":      "package main\n\nfunc main() {}\n",
+		"elsewhere/x.go": "package main\n\nfunc main() { undefined() }\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(projectDir, name)
+		if err := jirix.NewSeq().MkdirAll(filepath.Dir(path), 0755).WriteFile(path, []byte(content), 0644).Done(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := project.Project{Name: "tools", Path: projectDir, Remote: "tools"}
+	projects := project.Projects{p.Key(): p}
+	env := "GO111MODULE=off GOFLAGS="
+	tools := project.Tools{
+		"a":         project.Tool{Name: "a", Package: "example.com/tools/a", Project: "tools", Env: env, OS: runtime.GOOS},
+		"b":         project.Tool{Name: "b", Package: "example.com/tools/b", Project: "tools", Env: env},
+		"c":         project.Tool{Name: "c", Package: "example.com/tools/c", Project: "tools", Env: env, Arch: "nosucharch," + runtime.GOARCH},
+		"elsewhere": project.Tool{Name: "elsewhere", Package: "example.com/tools/elsewhere", Project: "tools", Env: env, OS: "nosuchos"},
+	}
+	var stdout bytes.Buffer
+	jirix.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout})
+	outputDir := filepath.Join(jirix.Root, "bin")
+	err := project.BuildTools(jirix, projects, tools, outputDir)
+	if want := `tool "b" build failed`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want it to contain %q", err, want)
+	}
+	for name, want := range map[string]bool{"a": true, "b": false, "c": true, "elsewhere": false} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); (err == nil) != want {
+			t.Errorf("tool %q: got installed %v, want %v", name, err == nil, want)
+		}
+	}
+	if want := `skipped tool "elsewhere"`; !strings.Contains(stdout.String(), want) {
+		t.Errorf("got output %q, want it to contain %q", stdout.String(), want)
+	}
+}
+
+// TestBuildToolsMetadata checks that BuildTools embeds the project and revision
+// the tools are built from, and the build time, into the binaries.
+func TestBuildToolsMetadata(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	projectDir := filepath.Join(jirix.Root, "go", "src", "example.com", "tools")
+	main := "package main\n\nimport (\n\t\"flag\"\n\n\t_ \"v.io/x/lib/metadata\"\n)\n\nfunc main() { flag.Parse() }\n"
+	path := filepath.Join(projectDir, "a", "main.go")
+	if err := jirix.NewSeq().MkdirAll(filepath.Dir(path), 0755).WriteFile(path, []byte(main), 0644).Done(); err != nil {
+		t.Fatal(err)
+	}
+	// The metadata package is copied into the workspace of the tool, so that
+	// building it does not depend on the GOPATH the test is run with.
+	var dir bytes.Buffer
+	if err := jirix.NewSeq().Capture(&dir, nil).Last("go", "list", "-f", "{{.Dir}}", "v.io/x/lib/metadata"); err != nil {
+		t.Skipf("skipping: the sources of v.io/x/lib/metadata, which the tool imports, were not found: %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(strings.TrimSpace(dir.String()), "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadataDir := filepath.Join(jirix.Root, "go", "src", "v.io", "x", "lib", "metadata")
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := jirix.NewSeq().MkdirAll(metadataDir, 0755).WriteFile(filepath.Join(metadataDir, filepath.Base(file)), data, 0644).Done(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(projectDir))
+	if err := git.Init(projectDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.Add(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.CommitWithMessage("add tool"); err != nil {
+		t.Fatal(err)
+	}
+	revision, err := git.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := project.Project{Name: "tools", Path: projectDir, Protocol: "git", Remote: "tools"}
+	projects := project.Projects{p.Key(): p}
+	// The metadata is added to the linker flags of the tool.
+	tools := project.Tools{
+		"a": project.Tool{Name: "a", Package: "example.com/tools/a", Project: "tools", BuildFlags: "-ldflags=-s", Env: "GO111MODULE=off GOFLAGS="},
+	}
+	outputDir := filepath.Join(jirix.Root, "bin")
+	start := time.Now().Add(-time.Second)
+	if err := project.BuildTools(jirix, projects, tools, outputDir); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	if err := jirix.NewSeq().Capture(&stdout, nil).Last(filepath.Join(outputDir, "a"), "-metadata"); err != nil {
+		t.Fatal(err)
+	}
+	md, err := metadata.FromXML(stdout.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := md.Lookup(project.ToolProjectMetadata), "tools"; got != want {
+		t.Errorf("got project %q, want %q", got, want)
+	}
+	if got, want := md.Lookup(project.ToolRevisionMetadata), revision; got != want {
+		t.Errorf("got revision %q, want %q", got, want)
+	}
+	buildTime, err := time.Parse(time.RFC3339, md.Lookup(project.ToolBuildTimeMetadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buildTime.Before(start) || buildTime.After(time.Now()) {
+		t.Errorf("got build time %v, want a time after %v", buildTime, start)
+	}
+}
+
+// TestBuildToolsGoToolchain checks that BuildTools builds the tools with the
+// Go toolchain selected by GoRootOpt or the "go" profile, and fails if it is
+// older than the Go version the tools require.
+func TestBuildToolsGoToolchain(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	projectDir := filepath.Join(jirix.Root, "go", "src", "example.com", "tools")
+	if err := jirix.NewSeq().MkdirAll(projectDir, 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	p := project.Project{Name: "tools", Path: projectDir, Remote: "tools"}
+	projects := project.Projects{p.Key(): p}
+	outputDir := filepath.Join(jirix.Root, "bin")
+
+	// newGoRoot creates a fake Go toolchain of the given version, whose go
+	// binary records the environment it is run with.
+	envFile := filepath.Join(jirix.Root, "go-env")
+	newGoRoot := func(name, version string) string {
+		goRoot := filepath.Join(jirix.Root, name)
+		script := fmt.Sprintf("#!/bin/sh\nif [ \"$1\" = version ]; then echo \"go version go%s linux/amd64\"; exit 0; fi\necho \"GOROOT=$GOROOT\" > %s\necho \"PATH=$PATH\" >> %s\n", version, envFile, envFile)
+		if err := jirix.NewSeq().MkdirAll(filepath.Join(goRoot, "bin"), 0755).WriteFile(filepath.Join(goRoot, "bin", "go"), []byte(script), 0755).Done(); err != nil {
+			t.Fatal(err)
+		}
+		return goRoot
+	}
+	checkEnv := func(goRoot string) {
+		data, err := ioutil.ReadFile(envFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(data), "GOROOT="+goRoot+"\nPATH="+filepath.Join(goRoot, "bin")+string(os.PathListSeparator); !strings.HasPrefix(got, want) {
+			t.Errorf("got environment %q, want prefix %q", got, want)
+		}
+		if err := os.Remove(envFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tools := project.Tools{
+		"a": project.Tool{Name: "a", Package: "example.com/tools/a", Project: "tools", GoVersion: "1.6"},
+	}
+	flagGoRoot := newGoRoot("flag-go", "1.6.2")
+	if err := project.BuildTools(jirix, projects, tools, outputDir, project.GoRootOpt(flagGoRoot)); err != nil {
+		t.Fatal(err)
+	}
+	checkEnv(flagGoRoot)
+
+	// A toolchain installed by the "go" profile is used by default.
+	profileGoRoot := newGoRoot("profile-go", "1.7")
+	pdb := profiles.NewDB()
+	pdb.InstallProfile("v23", "go", "profile-go")
+	target := profiles.NativeTarget()
+	target.SetVersion("1.7")
+	target.Env.Vars = []string{"GOROOT=" + profileGoRoot}
+	if err := pdb.AddProfileTarget("v23", "go", target); err != nil {
+		t.Fatal(err)
+	}
+	if err := jirix.NewSeq().MkdirAll(jirix.ProfilesDBDir(), 0755).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.Write(jirix, "v23", jirix.ProfilesDBDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.BuildTools(jirix, projects, tools, outputDir); err != nil {
+		t.Fatal(err)
+	}
+	checkEnv(profileGoRoot)
+
+	// A toolchain that is too old fails the build without running "go
+	// install".
+	tools["a"] = project.Tool{Name: "a", Package: "example.com/tools/a", Project: "tools", GoVersion: "1.7.1"}
+	err := project.BuildTools(jirix, projects, tools, outputDir)
+	if err == nil || !strings.Contains(err.Error(), "requires Go 1.7.1 or newer") {
+		t.Errorf("got error %v, want Go version error", err)
+	}
+	if _, err := os.Stat(envFile); !os.IsNotExist(err) {
+		t.Errorf("go install was run with a too old toolchain")
+	}
+}
+
+// TestGoWorkspace checks that the Go workspace of a tool is identified from
+// the path of its project with the path separators of both POSIX and
+// Windows.
+func TestGoWorkspace(t *testing.T) {
+	tests := []struct {
+		path, pkg string
+		sep       byte
+		want      string
+	}{
+		{"/root/go/src/v.io/jiri", "v.io/jiri/cmd/jiri", '/', "/root/go"},
+		{"/root/go/src/v.io", "v.io/jiri/cmd/jiri", '/', "/root/go"},
+		{"/root/src/go/src/v.io/jiri", "v.io/jiri/cmd/jiri", '/', "/root/src/go"},
+		{"/root/go/src/v.io/jiri", "example.com/tool", '/', ""},
+		{`C:\root\go\src\v.io\jiri`, "v.io/jiri/cmd/jiri", '\\', `C:\root\go`},
+		{`C:\root\go\src\v.io`, "v.io/jiri/cmd/jiri", '\\', `C:\root\go`},
+		{`C:\root\go\src\v.io\jiri`, "example.com/tool", '\\', ""},
+	}
+	for _, test := range tests {
+		if got := project.InternalGoWorkspace(test.path, test.pkg, test.sep); got != test.want {
+			t.Errorf("goWorkspace(%q, %q, %q): got %q, want %q", test.path, test.pkg, test.sep, got, test.want)
+		}
+	}
+}
+
+// TestHookCommand checks that hooks are run directly on POSIX systems, and
+// by the command interpreter or PowerShell on Windows.
+func TestHookCommand(t *testing.T) {
+	tests := []struct {
+		goos, comspec, runhook string
+		want                   []string
+	}{
+		{"linux", "", "/root/hook.sh", []string{"/root/hook.sh", "create"}},
+		{"darwin", `C:\Windows\cmd.exe`, "/root/hook.sh", []string{"/root/hook.sh", "create"}},
+		{"windows", "", `C:\root\hook.bat`, []string{"cmd", "/c", `C:\root\hook.bat`, "create"}},
+		{"windows", `C:\Windows\cmd.exe`, `C:\root\hook.bat`, []string{`C:\Windows\cmd.exe`, "/c", `C:\root\hook.bat`, "create"}},
+		{"windows", "", `C:\root\hook.PS1`, []string{"powershell", "-NoProfile", "-ExecutionPolicy", "Bypass", "-File", `C:\root\hook.PS1`, "create"}},
+	}
+	for _, test := range tests {
+		command, args := project.InternalHookCommand(test.goos, test.comspec, test.runhook, "create")
+		if got := append([]string{command}, args...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("hookCommand(%q, %q, %q): got %q, want %q", test.goos, test.comspec, test.runhook, got, test.want)
 		}
 	}
 }
@@ -0,0 +1,135 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+)
+
+// RemoteChange describes the change of the URL of a git remote of a local
+// project by SetRemotes.
+type RemoteChange struct {
+	Project Project
+	// Remote is the name of the git remote, e.g. "origin".
+	Remote string
+	OldURL string
+	NewURL string
+	// Err explains why the remote was left unchanged.
+	Err error
+}
+
+// String returns a one-line description of the change.
+func (c RemoteChange) String() string {
+	if c.Err != nil {
+		return fmt.Sprintf("%s: %s: ERROR: %v", c.Project.Name, c.Remote, c.Err)
+	}
+	return fmt.Sprintf("%s: %s %s -> %s", c.Project.Name, c.Remote, c.OldURL, c.NewURL)
+}
+
+// SetRemotes sets the origin remote of each of the given local projects to
+// the remote of the project in the manifest, as "jiri update" would, e.g.
+// after the projects migrated to a new host.  The origin is only changed if
+// its URL is the remote the project had in the manifest when it was last
+// updated, or starts with from, so that custom remotes are not clobbered.
+//
+// If from is not empty, the git remotes with the given names are changed too,
+// if their URLs start with from, by replacing from with to.  Remotes whose URLs
+// start with to are already up to date.
+//
+// The remotes are left unchanged if dryRun is set.  The changes, sorted by
+// project key, include the remotes that were left unchanged because their URLs
+// did not match, with an error; remotes that are already up to date are
+// omitted.  The manifest is loaded without fetching any project.
+func SetRemotes(jirix *jiri.X, projects Projects, from, to string, remoteNames []string, dryRun bool) ([]RemoteChange, error) {
+	manifestProjects, _, err := LoadManifest(jirix)
+	if err != nil {
+		return nil, err
+	}
+	// The keys of projects whose remote changed in the manifest differ from
+	// the keys of the local projects.
+	changed := map[ProjectKey]ProjectKey{}
+	for remoteKey, localKey := range changedRemoteProjects(projects, manifestProjects) {
+		changed[localKey] = remoteKey
+	}
+	var changes []RemoteChange
+	for _, key := range sortedKeys(projects) {
+		local := projects[key]
+		manifestKey, ok := changed[key]
+		if !ok {
+			manifestKey = key
+		}
+		manifestProject, ok := manifestProjects[manifestKey]
+		if !ok {
+			changes = append(changes, RemoteChange{Project: local, Remote: "origin", Err: fmt.Errorf("project is not in the manifest")})
+			continue
+		}
+		git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(local.Path))
+		if change, ok := setOrigin(git, local, manifestProject.Remote, from, dryRun); ok {
+			changes = append(changes, change)
+		}
+		if from == "" {
+			continue
+		}
+		for _, name := range remoteNames {
+			if change, ok := rewriteRemote(git, local, name, from, to, dryRun); ok {
+				changes = append(changes, change)
+			}
+		}
+	}
+	return changes, nil
+}
+
+// setOrigin sets the origin remote of the given local project to the given
+// manifest remote for SetRemotes.  It returns false if the origin is already
+// up to date.
+func setOrigin(git *gitutil.Git, local Project, remote, from string, dryRun bool) (RemoteChange, bool) {
+	change := RemoteChange{Project: local, Remote: "origin", NewURL: remote}
+	if change.OldURL, change.Err = git.RemoteUrl("origin"); change.Err != nil {
+		return change, true
+	}
+	switch {
+	case change.OldURL == remote:
+		return change, false
+	case normalizeRemote(change.OldURL) == normalizeRemote(local.Remote):
+		// The origin was set by the last update.
+	case from != "" && strings.HasPrefix(change.OldURL, from):
+		// The origin is on the host being migrated from.
+	case from != "":
+		change.Err = fmt.Errorf("URL %q is not the remote %q of the last update and does not start with %q, leaving it unchanged", change.OldURL, local.Remote, from)
+		return change, true
+	default:
+		change.Err = fmt.Errorf("URL %q is not the remote %q of the last update, leaving it unchanged", change.OldURL, local.Remote)
+		return change, true
+	}
+	if !dryRun {
+		change.Err = git.SetRemoteUrl("origin", remote)
+	}
+	return change, true
+}
+
+// rewriteRemote replaces the prefix from of the URL of the git remote with
+// the given name of the local project with to, for SetRemotes.  It returns
+// false if the project does not have the remote, or if the remote is already
+// up to date.
+func rewriteRemote(git *gitutil.Git, local Project, name, from, to string, dryRun bool) (RemoteChange, bool) {
+	oldURL, err := git.RemoteUrl(name)
+	if err != nil || strings.HasPrefix(oldURL, to) {
+		return RemoteChange{}, false
+	}
+	change := RemoteChange{Project: local, Remote: name, OldURL: oldURL}
+	if !strings.HasPrefix(oldURL, from) {
+		change.Err = fmt.Errorf("URL %q does not start with %q, leaving it unchanged", oldURL, from)
+		return change, true
+	}
+	change.NewURL = to + strings.TrimPrefix(oldURL, from)
+	if !dryRun {
+		change.Err = git.SetRemoteUrl(name, change.NewURL)
+	}
+	return change, true
+}
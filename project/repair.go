@@ -0,0 +1,234 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+)
+
+// RepairMetadata reconstructs the metadata of the local project in the given
+// directory, e.g. after the metadata file was deleted or truncated.  The
+// project is identified by matching the origin remote of the git repository
+// in the directory against the remotes of the projects in the manifest,
+// preferring the project whose path is the directory if several match.  The
+// repaired project is returned.
+func RepairMetadata(jirix *jiri.X, dir string) (Project, error) {
+	projects, _, err := LoadManifest(jirix)
+	if err != nil {
+		return Project{}, err
+	}
+	return repairMetadata(jirix, projects, dir)
+}
+
+// RepairAllMetadata reconstructs the metadata of each project in the manifest
+// whose directory exists but whose metadata is missing or invalid, as
+// described for RepairMetadata.  The repaired projects are returned.  A
+// project that cannot be repaired does not stop the others.
+func RepairAllMetadata(jirix *jiri.X) ([]Project, error) {
+	projects, _, err := LoadManifest(jirix)
+	if err != nil {
+		return nil, err
+	}
+	var repaired []Project
+	var failed []string
+	for _, key := range sortedKeys(projects) {
+		dir := projects[key].Path
+		if _, err := jirix.NewSeq().Stat(filepath.Join(dir, ".git")); err != nil {
+			continue
+		}
+		if _, err := ProjectAtPath(jirix, dir); err == nil {
+			continue
+		}
+		project, err := repairMetadata(jirix, projects, dir)
+		if err != nil {
+			fmt.Fprintf(jirix.Stderr(), "ERROR: repairing the metadata of the project in %q failed: %v\n", dir, err)
+			failed = append(failed, dir)
+			continue
+		}
+		repaired = append(repaired, project)
+	}
+	if len(failed) > 0 {
+		return repaired, fmt.Errorf("failed to repair the metadata of the projects in: %s", strings.Join(failed, ", "))
+	}
+	return repaired, nil
+}
+
+// repairMetadata implements RepairMetadata for the given manifest projects.
+func repairMetadata(jirix *jiri.X, projects Projects, dir string) (Project, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Project{}, err
+	}
+	if _, err := jirix.NewSeq().Stat(filepath.Join(dir, ".git")); err != nil {
+		return Project{}, fmt.Errorf("%q is not a git repository", dir)
+	}
+	remote, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(dir)).RemoteUrl("origin")
+	if err != nil {
+		return Project{}, fmt.Errorf("cannot determine the origin remote of %q: %v", dir, err)
+	}
+	var matches []Project
+	for _, key := range sortedKeys(projects) {
+		project := projects[key]
+		if normalizeRemote(project.Remote) != normalizeRemote(remote) {
+			continue
+		}
+		if project.Path == dir {
+			matches = []Project{project}
+			break
+		}
+		matches = append(matches, project)
+	}
+	switch len(matches) {
+	case 0:
+		return Project{}, fmt.Errorf("no project in the manifest has the remote %q of %q", remote, dir)
+	case 1:
+	default:
+		var names []string
+		for _, match := range matches {
+			names = append(names, match.Name)
+		}
+		return Project{}, fmt.Errorf("several projects in the manifest have the remote %q of %q: %s", remote, dir, strings.Join(names, ", "))
+	}
+	// The metadata records the directory the project was found in, so that
+	// a project at a different path than in the manifest is moved by the
+	// next update.
+	project := matches[0]
+	project.Path = dir
+	if err := writeMetadata(jirix, project, dir); err != nil {
+		return Project{}, err
+	}
+	return project, nil
+}
+
+// normalizeRemote returns the given remote URL without a trailing slash or
+// ".git" suffix, which do not change the repository the URL refers to.
+func normalizeRemote(remote string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+}
+
+// RecloneResult describes the reclone of a project by RecloneProjects.
+type RecloneResult struct {
+	Project Project
+	// Err explains why the project was not recloned.
+	Err error
+	// ParkedPath is the path that the old checkout was moved to.
+	ParkedPath string
+	// SavedBranches holds the local branches of the old checkout that were
+	// fetched into the new clone, and LostBranches those that could not be
+	// read, e.g. because their commits are corrupt.
+	SavedBranches []string
+	LostBranches  []string
+	// BranchesErr explains why the branches of the old checkout could not be
+	// listed, if they could not.
+	BranchesErr error
+}
+
+// RecloneProjects replaces the checkouts of the projects of the manifest with
+// the given names or keys, e.g. checkouts whose git directories are corrupt,
+// by new clones of the projects from their remotes, checked out at their
+// revisions, as "jiri update" creates projects.  The old checkouts are moved
+// to "<path>.corrupt-<time>", and their local branches other than master that
+// can still be read are fetched into the new clones.  An old checkout is
+// moved back if its project cannot be cloned.
+//
+// Unless force is true, projects with uncommitted changes are not recloned;
+// checkouts that are too broken to tell are recloned.  Githooks are installed
+// in the new clones, but runhooks are not run.  The manifest is loaded without
+// reading the revisions of the local projects, which fails for broken
+// checkouts.  A project that cannot be recloned does not stop the others.
+func RecloneProjects(jirix *jiri.X, names []string, force bool) ([]RecloneResult, error) {
+	localProjects, err := scanLocalProjects(jirix, FastScan)
+	if err != nil {
+		return nil, err
+	}
+	projects, _, err := loadManifestFile(jirix, jirix.JiriManifestFile(), localProjects)
+	if err != nil {
+		return nil, err
+	}
+	var selected []Project
+	for _, name := range names {
+		project, err := projects.FindUnique(name)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, project)
+	}
+	var results []RecloneResult
+	for _, project := range selected {
+		result, err := recloneProject(jirix, project, force)
+		result.Err = err
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// recloneProject reclones the given project for RecloneProjects.
+func recloneProject(jirix *jiri.X, project Project, force bool) (RecloneResult, error) {
+	result := RecloneResult{Project: project}
+	if project.Protocol != "git" {
+		return result, UnsupportedProtocolErr(project.Protocol)
+	}
+	s := jirix.NewSeq()
+	if _, err := s.Stat(project.Path); err != nil {
+		return result, fmt.Errorf("cannot reclone project %q: %v", project.Name, err)
+	}
+	old := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+	if dirty, err := old.HasUncommittedChanges(); err == nil && dirty && !force {
+		return result, fmt.Errorf("project %q has uncommitted changes, not recloning it without -force", project.Name)
+	}
+	result.ParkedPath = project.Path + ".corrupt-" + time.Now().Format("20060102-150405")
+	if err := s.Rename(project.Path, result.ParkedPath).Done(); err != nil {
+		return result, err
+	}
+	op := createOperation{commonOperation: commonOperation{
+		destination: project.Path,
+		project:     project,
+	}}
+	ops := []operation{op}
+	if err := s.Verbose(true).Call(func() error { return op.Run(jirix) }, "%v", op).Done(); err != nil {
+		if restoreErr := jirix.NewSeq().Rename(result.ParkedPath, project.Path).Done(); restoreErr != nil {
+			return result, fmt.Errorf("%v; error moving %q back: %v", err, result.ParkedPath, restoreErr)
+		}
+		result.ParkedPath = ""
+		return result, err
+	}
+	if err := excludeMetadataDirs(jirix, ops); err != nil {
+		return result, err
+	}
+	if err := applyGitHooks(jirix, ops); err != nil {
+		return result, err
+	}
+	result.SavedBranches, result.LostBranches, result.BranchesErr = saveBranches(jirix, result.ParkedPath, project.Path)
+	return result, nil
+}
+
+// saveBranches fetches the local branches other than master of the old
+// checkout into the new one.  It returns the branches that were fetched and
+// those that could not be.
+func saveBranches(jirix *jiri.X, oldPath, newPath string) ([]string, []string, error) {
+	branches, _, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(oldPath)).GetBranches()
+	if err != nil {
+		return nil, nil, err
+	}
+	git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(newPath))
+	var saved, lost []string
+	for _, branch := range branches {
+		if branch == "master" || strings.HasPrefix(branch, "(") {
+			continue
+		}
+		if err := git.FetchRefspec(oldPath, "refs/heads/"+branch+":refs/heads/"+branch); err != nil {
+			lost = append(lost, branch)
+			continue
+		}
+		saved = append(saved, branch)
+	}
+	return saved, lost, nil
+}
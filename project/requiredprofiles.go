@@ -0,0 +1,116 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/errkind"
+	"v.io/jiri/profiles"
+	"v.io/jiri/profiles/profilescmdline"
+)
+
+// requiredProfiles returns the profiles listed by the requiresprofiles
+// attribute of the given project.
+func requiredProfiles(project Project) []string {
+	var names []string
+	for _, name := range strings.Split(project.RequiresProfiles, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// readProfilesDB reads the profiles database at the given path, which is
+// empty if the database does not exist.
+func readProfilesDB(jirix *jiri.X, path string) (*profiles.DB, error) {
+	db := profiles.NewDB()
+	if err := db.Read(jirix, path); err != nil {
+		return nil, fmt.Errorf("reading the profiles database %s failed: %v", path, err)
+	}
+	return db, nil
+}
+
+// profileInstalled returns whether the given profile is installed for the
+// native target in the given database.
+func profileInstalled(db *profiles.DB, name string) bool {
+	installer, profile := profiles.SplitProfileName(name)
+	return db.LookupProfileTarget(installer, profile, profiles.NativeTarget()) != nil
+}
+
+// checkRequiredProfiles returns an error of kind errkind.ManifestError if a
+// runhook of the given projects requires a profile that is neither installed
+// nor available from a profile installer.
+func checkRequiredProfiles(jirix *jiri.X, projects Projects, hooks hookOpts) error {
+	var db *profiles.DB
+	var available map[string]bool
+	for _, key := range sortedKeys(projects) {
+		project := projects[key]
+		for _, name := range requiredProfiles(project) {
+			if db == nil {
+				var err error
+				if db, err = readProfilesDB(jirix, hooks.profilesDB); err != nil {
+					return err
+				}
+			}
+			installer, profile := profiles.SplitProfileName(name)
+			if db.LookupProfile(installer, profile) != nil {
+				continue
+			}
+			// Listing the available profiles may run the profile
+			// installers, so it is only done if needed.
+			if available == nil {
+				names, err := profilescmdline.AvailableProfiles(jirix)
+				if err != nil {
+					return err
+				}
+				available = map[string]bool{}
+				for _, name := range names {
+					available[name] = true
+				}
+			}
+			if !available[name] {
+				return errkind.Errorf(errkind.ManifestError, "bad project %q: the runhook requires the unknown profile %q", project.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// installRequiredProfiles checks that the profiles required by the runhook of
+// the given project are installed for the native target, and installs the
+// missing ones if hooks.installMissingProfiles is set.  It returns the
+// reason the runhook must be skipped, if the profiles are still missing.
+func installRequiredProfiles(jirix *jiri.X, project Project, hooks hookOpts) (string, error) {
+	names := requiredProfiles(project)
+	if len(names) == 0 {
+		return "", nil
+	}
+	db, err := readProfilesDB(jirix, hooks.profilesDB)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for _, name := range names {
+		if !profileInstalled(db, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return "", nil
+	}
+	target := profiles.NativeTarget()
+	if !hooks.installMissingProfiles {
+		return fmt.Sprintf("the required profiles %s are not installed for %v; run \"jiri profile install %s\", or update with -install-missing-profiles", strings.Join(missing, ", "), target, strings.Join(missing, " ")), nil
+	}
+	fmt.Fprintf(jirix.Stdout(), "installing the profiles %s required by the hook for project %q\n", strings.Join(missing, ", "), project.Name)
+	if err := profilescmdline.InstallProfiles(jirix, hooks.profilesDB, hooks.profilesDir, target, missing...); err != nil {
+		return "", fmt.Errorf("installing the profiles %s failed: %v", strings.Join(missing, ", "), err)
+	}
+	return "", nil
+}
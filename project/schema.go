@@ -0,0 +1,209 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"v.io/jiri/errkind"
+)
+
+// SupportedManifestVersion is the newest manifest version understood by this
+// version of jiri.  Manifests with a newer major version are refused, since
+// they may rely on features that jiri would silently ignore.  Manifests with
+// a newer minor version are loaded, and jiri warns about the elements and
+// attributes it does not know.
+const SupportedManifestVersion = "1.0"
+
+// ManifestVersionError is returned when a manifest has a major version newer
+// than SupportedManifestVersion.
+type ManifestVersionError struct {
+	// File is the manifest file, if the manifest was read from a file.
+	File string
+	// Version is the version of the manifest.
+	Version string
+}
+
+func (e *ManifestVersionError) Error() string {
+	manifest := "manifest"
+	if e.File != "" {
+		manifest = "manifest " + e.File
+	}
+	return fmt.Sprintf("%s has version %s, but this jiri binary only supports manifest versions up to %s", manifest, e.Version, SupportedManifestVersion)
+}
+
+// ErrorKind returns errkind.ManifestError.
+func (e *ManifestVersionError) ErrorKind() errkind.Kind {
+	return errkind.ManifestError
+}
+
+// IsManifestVersionError returns whether err reports that a manifest is too
+// new for this jiri binary.
+func IsManifestVersionError(err error) bool {
+	_, ok := err.(*ManifestVersionError)
+	return ok
+}
+
+// parseManifestVersion returns the major and minor numbers of the given
+// manifest version, which has the form "<major>" or "<major>.<minor>".
+func parseManifestVersion(version string) (int, int, error) {
+	parts := strings.SplitN(version, ".", 2)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 0 {
+		return 0, 0, fmt.Errorf("invalid manifest version %q", version)
+	}
+	minor := 0
+	if len(parts) == 2 {
+		if minor, err = strconv.Atoi(parts[1]); err != nil || minor < 0 {
+			return 0, 0, fmt.Errorf("invalid manifest version %q", version)
+		}
+	}
+	return major, minor, nil
+}
+
+// checkManifestVersion returns an error if the given manifest version is
+// invalid, or too new for this jiri binary.  An empty version is the version
+// of the manifests that predate versioning, which are always supported.
+func checkManifestVersion(version string) error {
+	if version == "" {
+		return nil
+	}
+	major, _, err := parseManifestVersion(version)
+	if err != nil {
+		return err
+	}
+	supported, _, err := parseManifestVersion(SupportedManifestVersion)
+	if err != nil {
+		return err
+	}
+	if major > supported {
+		return &ManifestVersionError{Version: version}
+	}
+	return nil
+}
+
+// xmlSchema describes the attributes and child elements of an XML element
+// that are consumed when unmarshalling it into a Go type.
+type xmlSchema struct {
+	attrs    map[string]bool
+	children map[string]*xmlSchema
+}
+
+// manifestSchema is the schema of the <manifest> element.
+var manifestSchema = newXMLSchema(reflect.TypeOf(Manifest{}))
+
+// newXMLSchema returns the schema of the elements unmarshalled into the given
+// type, following the rules of encoding/xml for the field tags used in
+// manifests.
+func newXMLSchema(t reflect.Type) *xmlSchema {
+	schema := &xmlSchema{attrs: map[string]bool{}, children: map[string]*xmlSchema{}}
+	if t.Kind() != reflect.Struct {
+		return schema
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Name == "XMLName" {
+			continue
+		}
+		tag := field.Tag.Get("xml")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name, flags := parts[0], parts[1:]
+		if len(flags) > 0 && flags[0] == "attr" {
+			if name == "" {
+				name = field.Name
+			}
+			schema.attrs[name] = true
+			continue
+		}
+		if len(flags) > 0 && flags[0] != "omitempty" {
+			// Comments, character data and the like.
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Slice {
+			fieldType = fieldType.Elem()
+		}
+		path := strings.Split(name, ">")
+		parent := schema
+		for _, elem := range path[:len(path)-1] {
+			child, ok := parent.children[elem]
+			if !ok {
+				child = &xmlSchema{attrs: map[string]bool{}, children: map[string]*xmlSchema{}}
+				parent.children[elem] = child
+			}
+			parent = child
+		}
+		parent.children[path[len(path)-1]] = newXMLSchema(fieldType)
+	}
+	return schema
+}
+
+// unknownManifestFields returns the elements and attributes of the given
+// manifest data that are not consumed by unmarshalling it into a Manifest,
+// e.g. because they were added by a newer version of jiri.  Each is reported
+// once, by its path from the <manifest> element, and the children of unknown
+// elements are not reported.
+func unknownManifestFields(data []byte) ([]string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	unknown := map[string]bool{}
+	// The schemas of the open elements, with nil for unknown elements.
+	var schemas []*xmlSchema
+	var path []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			var schema, parent *xmlSchema
+			if len(schemas) == 0 {
+				if t.Name.Local == "manifest" {
+					schema = manifestSchema
+				}
+			} else if parent = schemas[len(schemas)-1]; parent != nil {
+				schema = parent.children[t.Name.Local]
+			}
+			path = append(path, t.Name.Local)
+			elem := strings.Join(path, ">")
+			switch {
+			case schema != nil:
+				for _, attr := range t.Attr {
+					if attr.Name.Space == "" && !schema.attrs[attr.Name.Local] {
+						unknown[fmt.Sprintf("attribute %s@%s", elem, attr.Name.Local)] = true
+					}
+				}
+			case parent != nil:
+				unknown["element "+elem] = true
+			}
+			schemas = append(schemas, schema)
+		case xml.EndElement:
+			schemas = schemas[:len(schemas)-1]
+			path = path[:len(path)-1]
+		}
+	}
+	var result []string
+	for field := range unknown {
+		result = append(result, field)
+	}
+	sort.Strings(result)
+	return result, nil
+}
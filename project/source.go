@@ -0,0 +1,73 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/errkind"
+)
+
+// manifestSource records where the loader found a project or a tool: the
+// manifest file that declares it, and the chain of manifest files that
+// imported that file, starting with the root manifest file.  File names are
+// relative to $JIRI_ROOT when possible.
+type manifestSource struct {
+	File  string
+	Chain []string
+}
+
+// String returns a description of the source, e.g. "manifest/public
+// (imported by .jiri_manifest -> manifest/default)".
+func (s manifestSource) String() string {
+	if len(s.Chain) == 0 {
+		return s.File
+	}
+	return fmt.Sprintf("%s (imported by %s)", s.File, strings.Join(s.Chain, " -> "))
+}
+
+// source returns the source of the projects and tools declared by the given
+// manifest file, which is being loaded.
+func (ld *loader) source(jirix *jiri.X, file string) manifestSource {
+	s := manifestSource{File: shortFileName(jirix.Root, file)}
+	for _, c := range ld.cycleStack {
+		if c.file != file {
+			s.Chain = append(s.Chain, shortFileName(jirix.Root, c.file))
+		}
+	}
+	return s
+}
+
+// conflictingAttributes returns the attributes of the given projects or tools,
+// which must have the same type, whose values differ, e.g. "revision: abc123
+// vs def456".
+func conflictingAttributes(a, b interface{}) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var conflicts []string
+	for i := 0; i < va.NumField(); i++ {
+		field := va.Type().Field(i)
+		name := strings.Split(field.Tag.Get("xml"), ",")[0]
+		if name == "" || field.Name == "XMLName" {
+			continue
+		}
+		if x, y := va.Field(i).Interface(), vb.Field(i).Interface(); !reflect.DeepEqual(x, y) {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %q vs %q", name, fmt.Sprint(x), fmt.Sprint(y)))
+		}
+	}
+	return conflicts
+}
+
+// duplicateError returns the error for the given conflicting definitions of
+// the project or tool with the given name, found in the given sources.  The
+// definitions are compared once their defaults are filled in, so the values of
+// the attributes are reported with their defaults too, e.g. a revision that is
+// not set as "HEAD".
+func duplicateError(kind, name string, first, second interface{}, firstSource, secondSource manifestSource) error {
+	return errkind.Errorf(errkind.ManifestError, "duplicate %s %q with conflicting definitions in\n  %v\nand in\n  %v\nconflicting attributes: %s\nRemove one of the definitions, or make them identical.",
+		kind, name, firstSource, secondSource, strings.Join(conflictingAttributes(first, second), ", "))
+}
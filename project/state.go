@@ -6,15 +6,30 @@ package project
 
 import (
 	"fmt"
+	"net/url"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
 
 	"v.io/jiri"
+	"v.io/jiri/gerrit"
 	"v.io/jiri/gitutil"
 	"v.io/jiri/runutil"
 	"v.io/jiri/tool"
 )
 
+// changeIDRE matches the Change-Id of a commit message.
+var changeIDRE = regexp.MustCompile("Change-Id: (I[0123456789abcdefABCDEF]{40})")
+
 type BranchState struct {
+	// ChangeID is the Change-Id of the CL of the branch, or "" if the CL
+	// has not been exported to gerrit.
+	ChangeID string
+	// ChangeStatus is the status of the CL on the gerrit host of the
+	// project, e.g. gerrit.ChangeStatusMerged, or "" if it is unknown.  It
+	// is only set by SetChangeStatuses.
+	ChangeStatus     string
 	HasGerritMessage bool
 	Name             string
 }
@@ -24,7 +39,19 @@ type ProjectState struct {
 	CurrentBranch  string
 	HasUncommitted bool
 	HasUntracked   bool
-	Project        Project
+	// InProgressOperation is the git operation in progress in the project,
+	// "rebase", "merge" or "cherry-pick", or "" if there is none.
+	InProgressOperation string
+	Project             Project
+	// Stashes is the number of stashed changes in the project.
+	Stashes int
+	// LastUpdateRevision is the revision of the project recorded by the
+	// latest successful update, if requested by the caller.
+	LastUpdateRevision string
+	// Present is false for projects of the manifest that do not exist
+	// locally, whose Project is that of the manifest, with the remote and
+	// revision it would be cloned from.
+	Present bool
 }
 
 func setProjectState(jirix *jiri.X, state *ProjectState, checkDirty bool, ch chan<- error) {
@@ -41,18 +68,34 @@ func setProjectState(jirix *jiri.X, state *ProjectState, checkDirty bool, ch cha
 		for _, branch := range branches {
 			file := filepath.Join(state.Project.Path, jiri.ProjectMetaDir, branch, ".gerrit_commit_message")
 			hasFile := true
-			if _, err := jirix.NewSeq().Stat(file); err != nil {
+			message, err := jirix.NewSeq().ReadFile(file)
+			if err != nil {
 				if !runutil.IsNotExist(err) {
 					ch <- err
 					return
 				}
 				hasFile = false
 			}
+			changeID := ""
+			if match := changeIDRE.FindSubmatch(message); match != nil {
+				changeID = string(match[1])
+			}
 			state.Branches = append(state.Branches, BranchState{
+				ChangeID:         changeID,
 				Name:             branch,
 				HasGerritMessage: hasFile,
 			})
 		}
+		state.InProgressOperation, err = scm.InProgressOperation()
+		if err != nil {
+			ch <- err
+			return
+		}
+		state.Stashes, err = scm.StashSize()
+		if err != nil {
+			ch <- err
+			return
+		}
 		if checkDirty {
 			state.HasUncommitted, err = scm.HasUncommittedChanges()
 			if err != nil {
@@ -77,11 +120,37 @@ func GetProjectStates(jirix *jiri.X, checkDirty bool) (map[ProjectKey]*ProjectSt
 	if err != nil {
 		return nil, err
 	}
+	return projectStates(jirix, projects, checkDirty)
+}
+
+// GetManifestProjectStates is like GetProjectStates, but also returns the
+// states of the projects of the manifest that do not exist locally, which
+// have Present set to false and no branches.
+func GetManifestProjectStates(jirix *jiri.X, checkDirty bool) (map[ProjectKey]*ProjectState, error) {
+	manifestProjects, _, err := LoadManifest(jirix)
+	if err != nil {
+		return nil, err
+	}
+	states, err := GetProjectStates(jirix, checkDirty)
+	if err != nil {
+		return nil, err
+	}
+	for key, project := range manifestProjects {
+		if _, ok := states[key]; !ok {
+			states[key] = &ProjectState{Project: project}
+		}
+	}
+	return states, nil
+}
+
+// projectStates returns the states of the given projects.
+func projectStates(jirix *jiri.X, projects Projects, checkDirty bool) (map[ProjectKey]*ProjectState, error) {
 	states := make(map[ProjectKey]*ProjectState, len(projects))
 	sem := make(chan error, len(projects))
 	for key, project := range projects {
 		state := &ProjectState{
 			Project: project,
+			Present: true,
 		}
 		states[key] = state
 		// jirix is not threadsafe, so we make a clone for each goroutine.
@@ -106,6 +175,7 @@ func GetProjectState(jirix *jiri.X, key ProjectKey, checkDirty bool) (*ProjectSt
 		if k == key {
 			state := &ProjectState{
 				Project: project,
+				Present: true,
 			}
 			setProjectState(jirix, state, checkDirty, sem)
 			return state, <-sem
@@ -113,3 +183,104 @@ func GetProjectState(jirix *jiri.X, key ProjectKey, checkDirty bool) (*ProjectSt
 	}
 	return nil, fmt.Errorf("failed to find project key %v", key)
 }
+
+// bumpGeneration changes the contents of the generation file of the jiri
+// root, to tell the caches of the project states, such as that of "jiri
+// server", that the local projects may have changed.
+func bumpGeneration(jirix *jiri.X) error {
+	return safeWriteFile(jirix, jirix.GenerationFile(), []byte(time.Now().Format(time.RFC3339Nano)+"\n"))
+}
+
+// ChangeStatus returns the status of the CL with the given Change-Id on the
+// gerrit host of the given project.
+func ChangeStatus(jirix *jiri.X, project Project, changeID string) (string, error) {
+	if project.GerritHost == "" {
+		return "", fmt.Errorf("project %q has no gerrit host", project.Name)
+	}
+	host, err := url.Parse(project.GerritHost)
+	if err != nil {
+		return "", err
+	}
+	return jirix.Gerrit(host).ChangeStatus(changeID)
+}
+
+// SetChangeStatuses sets the ChangeStatus of the branches of the given states
+// whose CLs have been exported to gerrit, querying the gerrit hosts of the
+// projects.  Failures to query a host are reported as warnings and leave the
+// statuses unknown; hosts that fail are not queried again.
+func SetChangeStatuses(jirix *jiri.X, states []*ProjectState) {
+	failed := map[string]bool{}
+	for _, state := range states {
+		host := state.Project.GerritHost
+		if host == "" {
+			continue
+		}
+		for i, branch := range state.Branches {
+			if branch.ChangeID == "" || failed[host] {
+				continue
+			}
+			status, err := ChangeStatus(jirix, state.Project, branch.ChangeID)
+			if err != nil {
+				fmt.Fprintf(jirix.Stderr(), "WARNING: failed to get the status of CLs from %s: %v\n", host, err)
+				failed[host] = true
+				continue
+			}
+			state.Branches[i].ChangeStatus = status
+		}
+	}
+}
+
+// setGerritChanges sets the gerrit changes of the changelists of the given
+// update that have a Change-Id, querying the gerrit hosts of their projects,
+// which are keyed by project name.  The changes are queried once per host.
+// Failures to query a host are reported as warnings and leave the changes
+// unknown.
+func setGerritChanges(jirix *jiri.X, update Update, gerritHosts map[string]string) {
+	changeIDs, seen := map[string][]string{}, map[string]bool{}
+	for name, host := range gerritHosts {
+		for _, cl := range update[name] {
+			if host == "" || cl.ChangeID == "" || seen[host+" "+cl.ChangeID] {
+				continue
+			}
+			seen[host+" "+cl.ChangeID] = true
+			changeIDs[host] = append(changeIDs[host], cl.ChangeID)
+		}
+	}
+	var hosts []string
+	for host := range changeIDs {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	changes := map[string]map[string]gerrit.Change{}
+	for _, host := range hosts {
+		u, err := url.Parse(host)
+		var list gerrit.CLList
+		if err == nil {
+			list, err = jirix.Gerrit(u).Changes(changeIDs[host])
+		}
+		if err != nil {
+			fmt.Fprintf(jirix.Stderr(), "WARNING: failed to get CLs from %s: %v\n", host, err)
+			continue
+		}
+		changes[host] = map[string]gerrit.Change{}
+		for _, change := range list {
+			// Prefer a merged change to its cherry-picks on other branches.
+			if other, ok := changes[host][change.Change_id]; ok && other.Status == gerrit.ChangeStatusMerged {
+				continue
+			}
+			changes[host][change.Change_id] = change
+		}
+	}
+	for name, host := range gerritHosts {
+		for i, cl := range update[name] {
+			change, ok := changes[host][cl.ChangeID]
+			if cl.ChangeID == "" || !ok {
+				continue
+			}
+			update[name][i].Change = change.Number
+			update[name][i].Owner = change.Owner.Email
+			update[name][i].Topic = change.Topic
+			update[name][i].Status = change.Status
+		}
+	}
+}
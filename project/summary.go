@@ -0,0 +1,246 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+)
+
+// updateSummary records the outcome of the operations executed by an update
+// of the local projects and tools, for the summary printed at its end.
+type updateSummary struct {
+	start time.Time
+	// counts maps operation kinds to the number of operations of that kind
+	// that were executed successfully.  Delete operations that did not delete
+	// the project, because garbage collection was not requested, are counted
+	// as "obsolete".
+	counts map[string]int
+	// changes describes the projects whose revision changed.
+	changes []string
+	// failed describes the operation that failed, if any, and notRun is the
+	// number of operations that were not run because of the failure.
+	failed string
+	notRun int
+	// tools holds the names of the tools that were installed, and toolsErr
+	// the error that prevented them from being built or installed, if any.
+	tools    []string
+	toolsErr error
+	// skippedTools holds the names of the tools that were not built because
+	// they are not built on the host, and failedTools those of the tools
+	// that failed to build.
+	skippedTools, failedTools []string
+	// rebases records the outcome of rebasing the current branches of the
+	// updated projects, if requested.
+	rebases []rebaseResult
+	// inProgress describes the projects that were left unchanged because a
+	// git operation is in progress in them.
+	inProgress []string
+	// missingBranches describes the projects that were not updated because
+	// their remote branch does not exist.
+	missingBranches []string
+	// hooks records the outcome of the runhooks that were run, in order.
+	hooks []hookResult
+	// postUpdates records the outcome of the post-update commands that were
+	// run, in order.
+	postUpdates []postUpdateResult
+}
+
+// hookResult records the outcome of running the runhook of a project.
+type hookResult struct {
+	project, kind string
+	duration      time.Duration
+	// output holds the combined stdout and stderr of the runhook.
+	output string
+	err    error
+	// skipped is the reason the runhook was not run, if it was skipped.
+	skipped string
+}
+
+// rebaseResult records the outcome of rebasing the current branch of a
+// project onto its updated master branch.  An empty problem means that the
+// branch was rebased cleanly.
+type rebaseResult struct {
+	project, branch, problem string
+}
+
+func newUpdateSummary() *updateSummary {
+	return &updateSummary{
+		start:  time.Now(),
+		counts: map[string]int{},
+	}
+}
+
+// shortRevision returns an abbreviated form of the given revision.
+func shortRevision(revision string) string {
+	if revision == "" {
+		return "-"
+	}
+	if len(revision) > 7 {
+		return revision[:7]
+	}
+	return revision
+}
+
+// revision returns the revision checked out in the given project, or an
+// empty string if it cannot be determined.
+func revision(jirix *jiri.X, project Project) string {
+	revision, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path)).CurrentRevision()
+	if err != nil {
+		return ""
+	}
+	return revision
+}
+
+// addOp records the successful execution of the given operation, which
+// changed the revision of its project from oldRevision to the revision that
+// is now checked out.  Updates that did not change the revision are counted
+// as unchanged.
+func (u *updateSummary) addOp(jirix *jiri.X, op operation, oldRevision string, gc bool) {
+	kind := op.Kind()
+	switch kind {
+	case "delete":
+		if !gc {
+			kind = "obsolete"
+		}
+	case "create", "move", "update":
+		project := op.Project()
+		newRevision := revision(jirix, project)
+		if oldRevision != newRevision {
+			u.changes = append(u.changes, fmt.Sprintf("%s: %s -> %s", project.Name, shortRevision(oldRevision), shortRevision(newRevision)))
+		} else if kind == "update" {
+			kind = "null"
+		}
+	}
+	u.counts[kind]++
+}
+
+// addRebase records the outcome of rebasing the current branch of a project,
+// if any.
+func (u *updateSummary) addRebase(result *rebaseResult) {
+	if result != nil {
+		u.rebases = append(u.rebases, *result)
+	}
+}
+
+// addInProgress records that the given project was left unchanged because
+// the given git operation is in progress in it.
+func (u *updateSummary) addInProgress(project, operation string) {
+	u.inProgress = append(u.inProgress, fmt.Sprintf("%s: %s in progress", project, operation))
+}
+
+// addMissingBranch records that the given project was not updated because
+// its remote branch does not exist.
+func (u *updateSummary) addMissingBranch(project Project) {
+	u.missingBranches = append(u.missingBranches, fmt.Sprintf("%s: %s", project.Name, project.RemoteBranch))
+}
+
+// String returns the summary in a human-readable form.
+func (u *updateSummary) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Update summary:\n")
+	fmt.Fprintf(&buf, "  projects: %d created, %d updated, %d moved, %d deleted, %d unchanged\n",
+		u.counts["create"], u.counts["update"], u.counts["move"], u.counts["delete"], u.counts["null"])
+	if n := u.counts["obsolete"]; n > 0 {
+		fmt.Fprintf(&buf, "  obsolete projects not deleted (use -gc to delete them): %d\n", n)
+	}
+	if u.failed != "" {
+		fmt.Fprintf(&buf, "  failed: %s (%d operations not run)\n", u.failed, u.notRun)
+	}
+	if len(u.changes) > 0 {
+		sort.Strings(u.changes)
+		fmt.Fprintf(&buf, "  revision changes:\n")
+		for _, change := range u.changes {
+			fmt.Fprintf(&buf, "    %s\n", change)
+		}
+	}
+	var rebased, notRebased []string
+	for _, result := range u.rebases {
+		if result.problem == "" {
+			rebased = append(rebased, fmt.Sprintf("%s: %s", result.project, result.branch))
+		} else {
+			notRebased = append(notRebased, fmt.Sprintf("%s: %s (%s)", result.project, result.branch, result.problem))
+		}
+	}
+	if len(rebased) > 0 {
+		sort.Strings(rebased)
+		fmt.Fprintf(&buf, "  rebased branches:\n")
+		for _, line := range rebased {
+			fmt.Fprintf(&buf, "    %s\n", line)
+		}
+	}
+	if len(notRebased) > 0 {
+		sort.Strings(notRebased)
+		fmt.Fprintf(&buf, "  branches that need to be rebased manually:\n")
+		for _, line := range notRebased {
+			fmt.Fprintf(&buf, "    %s\n", line)
+		}
+	}
+	if len(u.inProgress) > 0 {
+		sort.Strings(u.inProgress)
+		fmt.Fprintf(&buf, "  projects left unchanged (finish or abort the operation, then update again):\n")
+		for _, line := range u.inProgress {
+			fmt.Fprintf(&buf, "    %s\n", line)
+		}
+	}
+	if len(u.missingBranches) > 0 {
+		sort.Strings(u.missingBranches)
+		fmt.Fprintf(&buf, "  projects not updated, their remote branch is missing (update the manifest):\n")
+		for _, line := range u.missingBranches {
+			fmt.Fprintf(&buf, "    %s\n", line)
+		}
+	}
+	if len(u.hooks) > 0 {
+		fmt.Fprintf(&buf, "  hooks:\n")
+		for _, hook := range u.hooks {
+			status := "ok"
+			switch {
+			case hook.err != nil:
+				status = fmt.Sprintf("failed: %v", hook.err)
+			case hook.skipped != "":
+				status = "skipped: " + hook.skipped
+			}
+			fmt.Fprintf(&buf, "    %s (%s): %s, %v\n", hook.project, hook.kind, status, hook.duration.Round(100*time.Millisecond))
+		}
+	}
+	switch {
+	case u.failed != "" || len(u.missingBranches) > 0:
+		fmt.Fprintf(&buf, "  tools: not built\n")
+	case u.toolsErr != nil && len(u.failedTools) == 0:
+		fmt.Fprintf(&buf, "  tools: failed: %v\n", u.toolsErr)
+	case len(u.tools) > 0:
+		fmt.Fprintf(&buf, "  tools: %d installed: %s\n", len(u.tools), strings.Join(u.tools, ", "))
+	default:
+		fmt.Fprintf(&buf, "  tools: none installed\n")
+	}
+	if u.failed == "" && len(u.skippedTools) > 0 {
+		fmt.Fprintf(&buf, "  tools skipped, not built on %s/%s: %s\n", runtime.GOOS, runtime.GOARCH, strings.Join(u.skippedTools, ", "))
+	}
+	if u.failed == "" && len(u.failedTools) > 0 {
+		fmt.Fprintf(&buf, "  tools failed to build: %s\n", strings.Join(u.failedTools, ", "))
+	}
+	if len(u.postUpdates) > 0 {
+		fmt.Fprintf(&buf, "  post-update commands:\n")
+		for _, result := range u.postUpdates {
+			status := "ok"
+			switch {
+			case result.err != nil && result.failOk:
+				status = fmt.Sprintf("failed (ignored): %v", result.err)
+			case result.err != nil:
+				status = fmt.Sprintf("failed: %v", result.err)
+			}
+			fmt.Fprintf(&buf, "    %s: %s, %v\n", result.command, status, result.duration.Round(100*time.Millisecond))
+		}
+	}
+	fmt.Fprintf(&buf, "  elapsed: %v\n", time.Since(u.start).Round(100*time.Millisecond))
+	return buf.String()
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+)
+
+// loadSnapshotTag returns the tag recorded in the given snapshot, and the
+// projects of the snapshot.
+func loadSnapshotTag(jirix *jiri.X, snapshot string) (string, Projects, error) {
+	m, err := ManifestFromFile(jirix, snapshot)
+	if err != nil {
+		return "", nil, err
+	}
+	if m.Tag == "" {
+		return "", nil, fmt.Errorf("snapshot %s does not record a tag", snapshot)
+	}
+	projects, _, err := LoadSnapshotFile(jirix, snapshot)
+	if err != nil {
+		return "", nil, err
+	}
+	return m.Tag, projects, nil
+}
+
+// TagSnapshotProjects creates an annotated tag, named by the tag recorded in
+// the given snapshot, at the recorded revision of each project of the
+// snapshot.  Projects where the tag already points at that revision are
+// skipped.  If the tag points at another revision in any project, no tag is
+// created and an error listing these projects is returned, unless force is
+// true, in which case the tags are moved.
+func TagSnapshotProjects(jirix *jiri.X, snapshot string, force bool) error {
+	tag, projects, err := loadSnapshotTag(jirix, snapshot)
+	if err != nil {
+		return err
+	}
+	var create []Project
+	var conflicts []string
+	for _, key := range sortedKeys(projects) {
+		project := projects[key]
+		git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+		revision, err := git.TagRevision(tag)
+		if err != nil {
+			return err
+		}
+		switch revision {
+		case "":
+		case project.Revision:
+			continue
+		default:
+			conflicts = append(conflicts, fmt.Sprintf("  %s: %s instead of %s", project.Name, shortRevision(revision), shortRevision(project.Revision)))
+			if !force {
+				continue
+			}
+		}
+		create = append(create, project)
+	}
+	if len(conflicts) > 0 && !force {
+		return fmt.Errorf("tag %q already points at other revisions in the following projects (use -force-tag to move it):\n%s", tag, strings.Join(conflicts, "\n"))
+	}
+	message := fmt.Sprintf("Snapshot %s", tag)
+	for _, project := range create {
+		git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+		if err := git.CreateTag(tag, project.Revision, gitutil.MessageOpt(message), gitutil.ForceOpt(force)); err != nil {
+			return fmt.Errorf("failed to tag project %q: %v", project.Name, err)
+		}
+	}
+	return nil
+}
+
+// PushSnapshotTags pushes the tag recorded in the given snapshot to the
+// origin remote of each project of the snapshot, printing the outcome for
+// each project.  Projects whose remote rejects the tag do not prevent the
+// tag from being pushed to the others, but cause an error to be returned.
+func PushSnapshotTags(jirix *jiri.X, snapshot string, force bool) error {
+	tag, projects, err := loadSnapshotTag(jirix, snapshot)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, key := range sortedKeys(projects) {
+		project := projects[key]
+		git := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(project.Path))
+		if err := git.Push("origin", "refs/tags/"+tag, gitutil.ForceOpt(force), gitutil.VerifyOpt(false)); err != nil {
+			fmt.Fprintf(jirix.Stderr(), "%s: failed to push tag %q: %v\n", project.Name, tag, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s: pushed tag %q\n", project.Name, tag)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to push tag %q to %d of %d projects", tag, failed, len(projects))
+	}
+	return nil
+}
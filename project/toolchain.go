@@ -0,0 +1,197 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/profiles"
+	"v.io/x/lib/envvar"
+)
+
+// MinGoVersion is the oldest Go version that jiri tools can be built with.
+// Tools may require a newer version with the goversion attribute.
+const MinGoVersion = "1.5"
+
+// goProfile is the name of the profile that installs a Go toolchain.
+const goProfile = "go"
+
+// BuildToolsOpt is an option for BuildTools.
+type BuildToolsOpt interface {
+	buildToolsOpt()
+}
+
+// GoRootOpt causes BuildTools, UpdateUniverse and CheckoutSnapshot to build
+// the tools with the Go toolchain installed in the given directory.  By
+// default, the toolchain installed by the "go" profile for the native target
+// is used if there is one, and the "go" binary in PATH otherwise.
+type GoRootOpt string
+
+func (GoRootOpt) buildToolsOpt() {}
+func (GoRootOpt) updateOpt()     {}
+
+// goToolchainEnv returns the environment variables that select the Go
+// toolchain that tools are built with.  The toolchain is the one in goRoot,
+// if set, or the one installed by the "go" profile for the native target.
+// If neither is available, the environment is empty and the "go" binary in
+// PATH is used.
+func goToolchainEnv(jirix *jiri.X, goRoot string) (map[string]string, error) {
+	if goRoot == "" {
+		var err error
+		if goRoot, err = profileGoRoot(jirix); err != nil {
+			return nil, err
+		}
+	}
+	if goRoot == "" {
+		return map[string]string{}, nil
+	}
+	goRoot, err := filepath.Abs(goRoot)
+	if err != nil {
+		return nil, err
+	}
+	path := jirix.Env()["PATH"]
+	if path == "" {
+		path = os.Getenv("PATH")
+	}
+	return map[string]string{
+		"GOROOT": goRoot,
+		"PATH":   envvar.PrependUniqueToken(path, string(os.PathListSeparator), filepath.Join(goRoot, "bin")),
+	}, nil
+}
+
+// profileGoRoot returns the GOROOT of the Go toolchain installed by the "go"
+// profile for the native target, or "" if there is no such profile.
+func profileGoRoot(jirix *jiri.X) (string, error) {
+	pdb := profiles.NewDB()
+	if err := pdb.Read(jirix, jirix.ProfilesDBDir()); err != nil {
+		return "", err
+	}
+	native := profiles.NativeTarget()
+	for _, profile := range pdb.Profiles() {
+		if _, name := profiles.SplitProfileName(profile.Name()); name != goProfile {
+			continue
+		}
+		target := profiles.FindTarget(profile.Targets(), &native)
+		if target == nil {
+			continue
+		}
+		for _, kv := range target.Env.Vars {
+			if strings.HasPrefix(kv, "GOROOT=") {
+				return strings.TrimPrefix(kv, "GOROOT="), nil
+			}
+		}
+		if target.InstallationDir != "" {
+			return filepath.Join(jirix.Root, target.InstallationDir), nil
+		}
+	}
+	return "", nil
+}
+
+// checkGoVersion returns an error if the Go toolchain selected by the given
+// environment is older than MinGoVersion, or than the goversion of any of the
+// given tools that has a package and is built on the host.  Development
+// versions of Go are assumed to be recent enough.
+func checkGoVersion(jirix *jiri.X, env map[string]string, tools Tools) error {
+	required, requiredBy := MinGoVersion, "jiri"
+	build := false
+	names := []string{}
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tool := tools[name]
+		if tool.Package == "" || !tool.builtOn(runtime.GOOS, runtime.GOARCH) {
+			continue
+		}
+		build = true
+		if tool.GoVersion != "" && compareGoVersions(tool.GoVersion, required) > 0 {
+			required, requiredBy = tool.GoVersion, fmt.Sprintf("tool %q", tool.Name)
+		}
+	}
+	if !build {
+		return nil
+	}
+	var stdout, stderr bytes.Buffer
+	if err := jirix.NewSeq().Env(env).Capture(&stdout, &stderr).Last("go", "version"); err != nil {
+		return fmt.Errorf("cannot determine the Go version that tools are built with: %v\n%s", err, stderr.String())
+	}
+	// The output has the form "go version go1.6.2 linux/amd64", or
+	// "go version devel +0123456 ..." for development versions.
+	fields := strings.Fields(stdout.String())
+	if len(fields) < 3 {
+		return fmt.Errorf("unexpected output of \"go version\": %q", stdout.String())
+	}
+	if fields[2] == "devel" {
+		return nil
+	}
+	version := strings.TrimPrefix(fields[2], "go")
+	if compareGoVersions(version, required) < 0 {
+		return fmt.Errorf("%s requires Go %s or newer to be built, but the go binary is Go %s; install a newer Go, or select one with -go-root or the %q profile", requiredBy, required, version, goProfile)
+	}
+	return nil
+}
+
+// parseGoVersion returns the numbers of the given Go version, e.g. [1 6 2] for
+// "1.6.2" or "go1.6.2".  Suffixes of pre-release versions, as in "1.7rc1",
+// are ignored.
+func parseGoVersion(version string) ([]int, error) {
+	var numbers []int
+	for _, part := range strings.Split(strings.TrimPrefix(version, "go"), ".") {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid Go version %q", version)
+		}
+		numbers = append(numbers, n)
+		if end < len(part) {
+			break
+		}
+	}
+	return numbers, nil
+}
+
+// compareGoVersions returns -1, 0 or 1 if the Go version v1 is older than,
+// the same as, or newer than v2.  Invalid versions are older than any valid
+// version.
+func compareGoVersions(v1, v2 string) int {
+	n1, err1 := parseGoVersion(v1)
+	n2, err2 := parseGoVersion(v2)
+	switch {
+	case err1 != nil && err2 != nil:
+		return 0
+	case err1 != nil:
+		return -1
+	case err2 != nil:
+		return 1
+	}
+	for i := 0; i < len(n1) || i < len(n2); i++ {
+		var a, b int
+		if i < len(n1) {
+			a = n1[i]
+		}
+		if i < len(n2) {
+			b = n2[i]
+		}
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"v.io/jiri"
+	"v.io/jiri/gitutil"
+)
+
+// VerifySignature is the value of the verify attribute of the projects and
+// imports whose revisions must be signed by one of the trusted keys listed in
+// the files in $JIRI_ROOT/.jiri_root/keys.
+const VerifySignature = "signature"
+
+// trustedKeys returns the set of fingerprints listed in the files in the keys
+// directory, one per line, with spaces removed and letters in upper case.
+// Lines starting with "#" are comments.
+func trustedKeys(jirix *jiri.X) (map[string]bool, error) {
+	infos, err := ioutil.ReadDir(jirix.KeysDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	keys := map[string]bool{}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(jirix.KeysDir(), info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			keys[normalizeFingerprint(line)] = true
+		}
+	}
+	return keys, nil
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(fingerprint), ""))
+}
+
+// checkSignature checks that the given revision of the git repository of the
+// project in the given directory, or the current directory if dir is empty, is
+// signed by one of the trusted keys, if the project requires signed revisions
+// and noVerify is false.
+func checkSignature(jirix *jiri.X, project Project, dir, revision string, noVerify bool) error {
+	if project.Verify != VerifySignature || noVerify {
+		return nil
+	}
+	keys, err := trustedKeys(jirix)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("project %q requires signed revisions, but no trusted keys are listed in %s", project.Name, jirix.KeysDir())
+	}
+	fingerprints, err := gitutil.New(jirix.NewSeq(), gitutil.RootDirOpt(dir)).VerifySignature(revision)
+	if err != nil {
+		return fmt.Errorf("revision %q of project %q is not signed by a trusted key: %v", revision, project.Name, err)
+	}
+	for _, fingerprint := range fingerprints {
+		if keys[normalizeFingerprint(fingerprint)] {
+			return nil
+		}
+	}
+	return fmt.Errorf("revision %q of project %q is signed by %s, which is not a trusted key listed in %s", revision, project.Name, strings.Join(fingerprints, ", "), jirix.KeysDir())
+}
+
+// warnNoVerify warns that the signatures of the given projects that require
+// signed revisions are not verified.
+func warnNoVerify(jirix *jiri.X, projects ...Project) {
+	for _, project := range projects {
+		if project.Verify == VerifySignature {
+			fmt.Fprintf(jirix.Stderr(), "WARNING: the signatures of the revisions of project %q are NOT verified, because verification is disabled\n", project.Name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"v.io/jiri"
+)
+
+// xunitTestSuites is the root element of an xUnit report.
+type xunitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []xunitTestSuite `xml:"testsuite"`
+}
+
+type xunitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []xunitTestCase `xml:"testcase"`
+}
+
+type xunitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *xunitFailure `xml:"failure,omitempty"`
+	Skipped   *xunitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type xunitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type xunitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeHookXUnitReport writes the outcome of the given runhooks to the given
+// file as an xUnit report, with one test case per runhook, named after its
+// project.
+func writeHookXUnitReport(jirix *jiri.X, file string, hooks []hookResult) error {
+	suite := xunitTestSuite{Name: "jiri-hooks", Tests: len(hooks)}
+	var total float64
+	for _, hook := range hooks {
+		total += hook.duration.Seconds()
+		testCase := xunitTestCase{
+			ClassName: "hooks",
+			Name:      hook.project,
+			Time:      fmt.Sprintf("%.3f", hook.duration.Seconds()),
+			SystemOut: hook.output,
+		}
+		if hook.err != nil {
+			suite.Failures++
+			testCase.Failure = &xunitFailure{Message: hook.err.Error(), Text: hook.output}
+		}
+		if hook.skipped != "" {
+			suite.Skipped++
+			testCase.Skipped = &xunitSkipped{Message: hook.skipped}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total)
+	data, err := xml.MarshalIndent(xunitTestSuites{Suites: []xunitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("xml.MarshalIndent() failed: %v", err)
+	}
+	data = append([]byte(xml.Header), data...)
+	data = append(data, '\n')
+	return safeWriteFile(jirix, file, data)
+}
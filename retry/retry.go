@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"time"
 
+	"v.io/jiri/errkind"
 	"v.io/jiri/tool"
 )
 
@@ -57,5 +58,7 @@ func Function(ctx *tool.Context, fn func() error, opts ...RetryOpt) error {
 			time.Sleep(interval)
 		}
 	}
-	return fmt.Errorf("Failed %d times in a row. Last error:\n%v", attempts, err)
+	// Keep the kind of the last error, e.g. so that network failures are
+	// reported as such.
+	return errkind.Errorf(errkind.Of(err), "Failed %d times in a row. Last error:\n%v", attempts, err)
 }
@@ -13,7 +13,7 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
 	"v.io/x/lib/envvar"
@@ -37,6 +37,9 @@ type opts struct {
 type executor struct {
 	indent int
 	opts   opts
+	// recorder, if set, records the commands and file system changes
+	// of the executor instead of performing them.
+	recorder *Recorder
 }
 
 func newExecutor(env map[string]string, stdin io.Reader, stdout, stderr io.Writer, color, verbose bool) *executor {
@@ -78,6 +81,10 @@ func (e *executor) start(timeout time.Duration, opts opts, path string, args ...
 // function runs the given function and logs its outcome using
 // the given options.
 func (e *executor) function(opts opts, fn func() error, format string, args ...interface{}) error {
+	if e.recorder != nil {
+		e.recorder.PushStep(format, args...)
+		defer e.recorder.PopStep()
+	}
 	e.increaseIndent()
 	defer e.decreaseIndent()
 	e.printf(e.verboseStdout(opts), format, args...)
@@ -134,6 +141,17 @@ func (e *executor) call(fn func() error, format string, args ...interface{}) err
 	return e.function(e.opts, fn, format, args...)
 }
 
+// change executes the given Go standard library function that changes the
+// file system, encapsulated as a closure, unless the executor records its
+// actions, in which case the change is recorded instead.
+func (e *executor) change(fn func() error, format string, args ...interface{}) error {
+	if e.recorder != nil {
+		e.recorder.Record(FileAction, "%s", fmt.Sprintf(format, args...))
+		return nil
+	}
+	return e.call(fn, format, args...)
+}
+
 // execute executes the binary pointed to by the given path using the given
 // arguments and options. If the wait flag is set, the function waits for the
 // completion of the binary and the timeout value can optionally specify for
@@ -161,17 +179,20 @@ func (e *executor) execute(wait bool, timeout time.Duration, opts opts, path str
 	command.Stdout = opts.stdout
 	command.Stderr = opts.stderr
 	command.Env = envvar.MapToSlice(opts.env)
-	if out := e.verboseStdout(opts); out != ioutil.Discard {
-		args := []string{}
-		for _, arg := range command.Args {
-			// Quote any arguments that contain '"', ''', '|', or ' '.
-			if strings.IndexAny(arg, "\"' |") != -1 {
-				args = append(args, strconv.Quote(arg))
-			} else {
-				args = append(args, arg)
-			}
+	if e.recorder != nil {
+		line := commandLine(command)
+		if opts.dir != "" {
+			line = fmt.Sprintf("(cd %q && %s)", opts.dir, line)
 		}
-		e.printf(out, strings.Replace(strings.Join(args, " "), "%", "%%", -1))
+		e.recorder.Record(CommandAction, "%s", line)
+		if !wait {
+			e.recorder.Indeterminate("%s was not started", command.Args[0])
+			return command, fmt.Errorf("cannot start %q while recording", path)
+		}
+		return command, nil
+	}
+	if out := e.verboseStdout(opts); out != ioutil.Discard {
+		e.printf(out, strings.Replace(commandLine(command), "%", "%%", -1))
 	}
 
 	var err error
@@ -181,7 +202,7 @@ func (e *executor) execute(wait bool, timeout time.Duration, opts opts, path str
 		e.printf(e.verboseStdout(opts), okOrFailed(err))
 
 	case timeout == 0:
-		err = command.Run()
+		err = runCommand(command)
 		e.printf(e.verboseStdout(opts), okOrFailed(err))
 	default:
 		err = e.timedCommand(timeout, opts, command)
@@ -190,24 +211,97 @@ func (e *executor) execute(wait bool, timeout time.Duration, opts opts, path str
 	return command, err
 }
 
+// commandLine returns the command line of the given command, as printed in
+// verbose mode.
+func commandLine(command *exec.Cmd) string {
+	args := []string{}
+	for _, arg := range command.Args {
+		// Quote any arguments that contain '"', ''', '|', or ' '.
+		if strings.IndexAny(arg, "\"' |") != -1 {
+			args = append(args, strconv.Quote(arg))
+		} else {
+			args = append(args, arg)
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// running holds the commands that are being run, so that they can be
+// interrupted.
+var running = struct {
+	sync.Mutex
+	commands map[*exec.Cmd]struct{}
+}{commands: map[*exec.Cmd]struct{}{}}
+
+// runCommand runs the given command and waits for it to finish, recording it
+// as running in the meantime.
+func runCommand(command *exec.Cmd) error {
+	if err := command.Start(); err != nil {
+		return err
+	}
+	running.Lock()
+	running.commands[command] = struct{}{}
+	running.Unlock()
+	defer func() {
+		running.Lock()
+		delete(running.commands, command)
+		running.Unlock()
+	}()
+	return command.Wait()
+}
+
+// Interrupt sends the given signal to the commands that are being run by
+// sequences, and waits up to a few seconds for them to exit.  It is meant to
+// be used by signal handlers, to cancel in-flight commands before cleaning up
+// after them.  Commands run with a timeout handle signals themselves.
+func Interrupt(sig os.Signal) {
+	running.Lock()
+	for command := range running.commands {
+		command.Process.Signal(sig)
+	}
+	running.Unlock()
+	for i := 0; i < 50; i++ {
+		running.Lock()
+		n := len(running.commands)
+		running.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 // timedCommand executes the given command, terminating it forcefully
-// if it is still running after the given timeout elapses.
+// if it is still running after the given timeout elapses.  The command
+// is run in a process group of its own, so that the processes it starts
+// are terminated with it; it is therefore stopped if it tries to read
+// from the terminal, e.g. to prompt for credentials.
 func (e *executor) timedCommand(timeout time.Duration, opts opts, command *exec.Cmd) error {
 	// Make the process of this command a new process group leader
 	// to facilitate clean up of processes that time out.
-	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	setProcessGroup(command)
 	// Kill this process group explicitly when receiving SIGTERM
-	// or SIGINT signals.
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT)
-	go func() {
-		<-sigchan
-		e.terminateProcessGroup(opts, command)
+	// or SIGINT signals, as it no longer receives the signals sent
+	// to the foreground process group by the terminal.  Stop
+	// listening once the command is done, so that the signals do
+	// not reach process groups that no longer exist.
+	sigchan, stop := make(chan os.Signal, 1), make(chan struct{})
+	signal.Notify(sigchan, terminationSignals...)
+	defer func() {
+		signal.Stop(sigchan)
+		close(stop)
 	}()
 	if err := command.Start(); err != nil {
 		e.printf(e.verboseStdout(opts), "FAILED: %v", err)
 		return err
 	}
+	go func() {
+		select {
+		case <-sigchan:
+			e.terminateProcessGroup(opts, command)
+		case <-stop:
+		}
+	}()
 	done := make(chan error, 1)
 	go func() {
 		done <- command.Wait()
@@ -226,31 +320,6 @@ func (e *executor) timedCommand(timeout time.Duration, opts opts, command *exec.
 	}
 }
 
-// terminateProcessGroup sends SIGQUIT followed by SIGKILL to the
-// process group (the negative value of the process's pid).
-func (e *executor) terminateProcessGroup(opts opts, command *exec.Cmd) {
-	pid := -command.Process.Pid
-	// Use SIGQUIT in order to get a stack dump of potentially hanging
-	// commands.
-	if err := syscall.Kill(pid, syscall.SIGQUIT); err != nil {
-		e.printf(e.stderrFromOpts(opts), "Kill(%v, %v) failed: %v", pid, syscall.SIGQUIT, err)
-	}
-	e.printf(e.stderrFromOpts(opts), "Waiting for command to exit: %q", command.Args)
-	// Give the process some time to shut down cleanly.
-	for i := 0; i < 50; i++ {
-		if err := syscall.Kill(pid, 0); err != nil {
-			return
-		}
-		time.Sleep(200 * time.Millisecond)
-	}
-	// If it still exists, send SIGKILL to it.
-	if err := syscall.Kill(pid, 0); err == nil {
-		if err := syscall.Kill(-command.Process.Pid, syscall.SIGKILL); err != nil {
-			e.printf(e.stderrFromOpts(opts), "Kill(%v, %v) failed: %v", pid, syscall.SIGKILL, err)
-		}
-	}
-}
-
 func (e *executor) decreaseIndent() {
 	e.indent--
 }
@@ -0,0 +1,20 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runutil
+
+import "io"
+
+// InternalNewTerminalProgress returns a new Progress that reports to the
+// given writer as if it were a terminal of the given width.  The status line
+// is not redrawn every second, so that the output is deterministic.
+func InternalNewTerminalProgress(out io.Writer, total, width int) *Progress {
+	return &Progress{
+		out:      out,
+		terminal: true,
+		width:    width,
+		total:    total,
+		done:     make(chan struct{}),
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package runutil
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// terminationSignals are the signals upon which the process groups of
+// running commands are terminated.
+var terminationSignals = []os.Signal{syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT}
+
+// setProcessGroup makes the process of the given command the leader of a new
+// process group.
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateProcessGroup sends SIGQUIT followed by SIGKILL to the
+// process group (the negative value of the process's pid).
+func (e *executor) terminateProcessGroup(opts opts, command *exec.Cmd) {
+	pid := -command.Process.Pid
+	// Use SIGQUIT in order to get a stack dump of potentially hanging
+	// commands.
+	if err := syscall.Kill(pid, syscall.SIGQUIT); err != nil {
+		e.printf(e.stderrFromOpts(opts), "Kill(%v, %v) failed: %v", pid, syscall.SIGQUIT, err)
+	}
+	e.printf(e.stderrFromOpts(opts), "Waiting for command to exit: %q", command.Args)
+	// Give the process some time to shut down cleanly.
+	for i := 0; i < 50; i++ {
+		if err := syscall.Kill(pid, 0); err != nil {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	// If it still exists, send SIGKILL to it.
+	if err := syscall.Kill(pid, 0); err == nil {
+		if err := syscall.Kill(-command.Process.Pid, syscall.SIGKILL); err != nil {
+			e.printf(e.stderrFromOpts(opts), "Kill(%v, %v) failed: %v", pid, syscall.SIGKILL, err)
+		}
+	}
+}
+
+// isCrossDevice returns whether the given error of os.Rename means that the
+// source and destination are located on different mount points.
+func isCrossDevice(err error) bool {
+	linkErr, ok := err.(*os.LinkError)
+	if !ok {
+		return false
+	}
+	errno, ok := linkErr.Err.(syscall.Errno)
+	return ok && errno == syscall.EXDEV
+}
+
+// moveCommand returns the command that moves src to dst across mount points.
+func moveCommand(src, dst string) *exec.Cmd {
+	return exec.Command("mv", src, dst)
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runutil
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// errorNotSameDevice is the Windows error returned by os.Rename when the
+// source and destination are located on different volumes.
+const errorNotSameDevice = syscall.Errno(17)
+
+// terminationSignals are the signals upon which the process groups of
+// running commands are terminated.  Windows only delivers os.Interrupt.
+var terminationSignals = []os.Signal{os.Interrupt}
+
+// setProcessGroup makes the process of the given command the root of a new
+// process group.
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminateProcessGroup kills the process of the given command and all of
+// its descendants.  Windows has no equivalent of SIGQUIT, so the processes
+// are killed right away.
+func (e *executor) terminateProcessGroup(opts opts, command *exec.Cmd) {
+	pid := strconv.Itoa(command.Process.Pid)
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", pid).Run(); err != nil {
+		e.printf(e.stderrFromOpts(opts), "taskkill /T /F /PID %v failed: %v", pid, err)
+		if err := command.Process.Kill(); err != nil {
+			e.printf(e.stderrFromOpts(opts), "Kill(%v) failed: %v", pid, err)
+		}
+	}
+}
+
+// isCrossDevice returns whether the given error of os.Rename means that the
+// source and destination are located on different volumes.
+func isCrossDevice(err error) bool {
+	linkErr, ok := err.(*os.LinkError)
+	if !ok {
+		return false
+	}
+	errno, ok := linkErr.Err.(syscall.Errno)
+	return ok && errno == errorNotSameDevice
+}
+
+// moveCommand returns the command that moves src to dst across volumes.
+func moveCommand(src, dst string) *exec.Cmd {
+	return exec.Command("cmd", "/c", "move", "/y", src, dst)
+}
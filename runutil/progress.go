@@ -0,0 +1,215 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Progress reports the progress of an operation made of a known number of
+// steps, such as the update of the projects of a jiri root, so that users can
+// tell that a long operation is not hung.
+//
+// On a terminal, the progress is a single status line, e.g. "[12/80] cloning
+// release.go.x.ref (34s)", that is redrawn in place every second.  Output
+// written through the writers returned by Writer clears the status line
+// first, so that it never interleaves with the status line.  Elsewhere, a
+// timestamped line is printed when each step starts.
+//
+// The methods of a nil *Progress do nothing, so that callers need not check
+// whether progress is reported.
+type Progress struct {
+	mu       sync.Mutex
+	out      io.Writer
+	terminal bool
+	width    int
+	total    int
+	step     int
+	status   string
+	start    time.Time
+	// drawn is true if the status line is displayed, and partial is true
+	// if the last output written through a Writer did not end a line, in
+	// which case the status line is not drawn until it does.
+	drawn   bool
+	partial bool
+	stopped bool
+	done    chan struct{}
+}
+
+// NewProgress returns a new Progress for the given number of steps that
+// reports to the given writer.
+func NewProgress(out io.Writer, total int) *Progress {
+	p := &Progress{
+		out:      out,
+		terminal: isTerminal(out),
+		width:    terminalWidth(),
+		total:    total,
+		done:     make(chan struct{}),
+	}
+	if p.terminal {
+		go p.tick()
+	}
+	return p
+}
+
+// Terminal returns whether the progress is reported as a status line on a
+// terminal.
+func (p *Progress) Terminal() bool {
+	return p != nil && p.terminal
+}
+
+// Step starts the next step, with the status given by format and args, e.g.
+// "cloning <project>".
+func (p *Progress) Step(format string, args ...interface{}) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	p.step++
+	p.status = fmt.Sprintf(format, args...)
+	p.start = time.Now()
+	if !p.terminal {
+		fmt.Fprintf(p.out, "[%s] [%d/%d] %s\n", p.start.Format("15:04:05.00"), p.step, p.total, p.status)
+		return
+	}
+	p.draw()
+}
+
+// Status changes the status of the current step, e.g. when the clone of a
+// project finishes and its checkout starts.  It is only reported on a
+// terminal.
+func (p *Progress) Status(format string, args ...interface{}) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped || !p.terminal {
+		return
+	}
+	p.status = fmt.Sprintf(format, args...)
+	p.draw()
+}
+
+// Writer returns a writer that writes to w, clearing the status line before
+// each write and drawing it again after output that ends a line.
+func (p *Progress) Writer(w io.Writer) io.Writer {
+	if !p.Terminal() {
+		return w
+	}
+	return progressWriter{p, w}
+}
+
+// Stop stops reporting the progress and clears the status line, restoring
+// the line the status line was drawn over.  It can be called more than once,
+// e.g. both when the operation finishes and when jiri is interrupted.
+func (p *Progress) Stop() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	close(p.done)
+	p.clear()
+}
+
+// tick redraws the status line every second, to update the time the current
+// step has taken, until the progress is stopped.
+func (p *Progress) tick() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			if !p.stopped {
+				p.draw()
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// draw draws the status line over the current line, unless it holds partial
+// output or no step started yet.  It must be called with p.mu held.
+func (p *Progress) draw() {
+	if p.partial || p.step == 0 {
+		return
+	}
+	line := fmt.Sprintf("[%d/%d] %s (%ds)", p.step, p.total, p.status, int(time.Since(p.start).Seconds()))
+	// A line that wraps cannot be redrawn in place.
+	if len(line) >= p.width {
+		line = line[:p.width-1]
+	}
+	fmt.Fprintf(p.out, "\r%s\x1b[K", line)
+	p.drawn = true
+}
+
+// clear erases the status line, if it is displayed.  It must be called with
+// p.mu held.
+func (p *Progress) clear() {
+	if p.drawn {
+		fmt.Fprint(p.out, "\r\x1b[K")
+		p.drawn = false
+	}
+}
+
+// progressWriter is a writer that clears the status line of a Progress
+// before writing to the underlying writer.
+type progressWriter struct {
+	p *Progress
+	w io.Writer
+}
+
+func (pw progressWriter) Write(data []byte) (int, error) {
+	pw.p.mu.Lock()
+	defer pw.p.mu.Unlock()
+	pw.p.clear()
+	n, err := pw.w.Write(data)
+	if len(data) > 0 {
+		pw.p.partial = !bytes.HasSuffix(data, []byte("\n"))
+	}
+	if !pw.p.stopped {
+		pw.p.draw()
+	}
+	return n, err
+}
+
+// isTerminal returns whether the given writer is a terminal.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the width of the terminal given by $COLUMNS, or 80.
+func terminalWidth() int {
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 1 {
+		return width
+	}
+	return 80
+}
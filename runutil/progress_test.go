@@ -0,0 +1,87 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runutil_test
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"v.io/jiri/runutil"
+)
+
+// TestProgressLines checks that a progress that does not report to a terminal
+// prints a timestamped line for each step.
+func TestProgressLines(t *testing.T) {
+	var out bytes.Buffer
+	p := runutil.NewProgress(&out, 2)
+	if p.Terminal() {
+		t.Fatalf("progress reports to a terminal")
+	}
+	if got, want := p.Writer(&out), &out; got != want {
+		t.Fatalf("unexpected writer: got %v, want %v", got, want)
+	}
+	p.Step("cloning %s", "a")
+	p.Status("checking out %s", "a")
+	p.Step("updating %s", "b")
+	p.Stop()
+	p.Stop()
+	p.Step("deleting %s", "c")
+	re := regexp.MustCompile(`\[(\d\d:\d\d:\d\d.\d\d)\]`)
+	got := re.ReplaceAllString(out.String(), "[hh:mm:ss.xx]")
+	want := `[hh:mm:ss.xx] [1/2] cloning a
+[hh:mm:ss.xx] [2/2] updating b
+`
+	if got != want {
+		t.Errorf("unexpected output:\ngot\n%v\nwant\n%v", got, want)
+	}
+}
+
+// TestProgressTerminal checks that a progress that reports to a terminal
+// draws a status line that output written through its writers clears.
+func TestProgressTerminal(t *testing.T) {
+	var out bytes.Buffer
+	p := runutil.InternalNewTerminalProgress(&out, 2, 24)
+	if !p.Terminal() {
+		t.Fatalf("progress does not report to a terminal")
+	}
+	w := p.Writer(&out)
+	p.Step("cloning %s", "a")
+	p.Status("checking out %s", "a")
+	fmt.Fprint(w, "hello ")
+	p.Step("updating %s", "b")
+	fmt.Fprint(w, "world\n")
+	p.Stop()
+	fmt.Fprint(w, "done\n")
+	// The status line is truncated to fit the terminal, and it is not drawn
+	// over the partial line.
+	want := "\r[1/2] cloning a (0s)\x1b[K" +
+		"\r[1/2] checking out a (0\x1b[K" +
+		"\r\x1b[Khello " +
+		"world\n" +
+		"\r[2/2] updating b (0s)\x1b[K" +
+		"\r\x1b[K" +
+		"done\n"
+	if got := out.String(); got != want {
+		t.Errorf("unexpected output:\ngot\n%q\nwant\n%q", got, want)
+	}
+}
+
+// TestProgressNil checks that the methods of a nil progress do nothing.
+func TestProgressNil(t *testing.T) {
+	var p *runutil.Progress
+	var out bytes.Buffer
+	if p.Terminal() {
+		t.Fatalf("nil progress reports to a terminal")
+	}
+	p.Step("cloning %s", "a")
+	p.Status("checking out %s", "a")
+	fmt.Fprint(p.Writer(&out), "hello\n")
+	p.Stop()
+	if got, want := out.String(), "hello\n"; got != want {
+		t.Errorf("unexpected output: got %q, want %q", got, want)
+	}
+}
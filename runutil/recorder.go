@@ -0,0 +1,135 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runutil
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ActionKind is the kind of an action recorded by a Recorder.
+type ActionKind string
+
+const (
+	// CommandAction is a command that would be run.
+	CommandAction ActionKind = "run"
+	// FileAction is a change that would be made to the file system.
+	FileAction ActionKind = "file"
+	// DownloadAction is a URL that would be fetched, and its destination.
+	DownloadAction ActionKind = "download"
+	// EnvAction is an environment variable that would be set.
+	EnvAction ActionKind = "env"
+	// IndeterminateAction marks the point after which the recorded
+	// actions may not be those that would be performed, e.g. because the
+	// caller acts on the output of a command that was not run.
+	IndeterminateAction ActionKind = "indeterminate"
+)
+
+// Action is an action recorded by a Recorder.
+type Action struct {
+	// Step is the step the action belongs to, i.e. the descriptions of
+	// the enclosing Sequence.Call invocations, separated by ": ".
+	Step        string
+	Kind        ActionKind
+	Description string
+}
+
+// Recorder records the actions of the sequences it is attached to via
+// Sequence.Record, instead of letting them perform those actions, so that
+// the plan of an operation, such as the installation of a profile, can be
+// reviewed before it is carried out.
+//
+// Commands run by Run, Last and Start, and the file system changes made by
+// methods such as MkdirAll, WriteFile or Rename are recorded, and succeed
+// without doing anything.  Methods that only read the file system, such as
+// ReadFile or Stat, are performed as usual.  Functions invoked by Call are
+// invoked, and their descriptions name the step of the actions they record.
+// Commands whose output is captured produce no output, so the plan is marked
+// as indeterminate at that point, and commands started by Start fail.
+type Recorder struct {
+	mu            sync.Mutex
+	steps         []string
+	actions       []Action
+	indeterminate bool
+}
+
+// NewRecorder returns a new, empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// PushStep starts a new step, nested in the current one, that the actions
+// recorded until the matching PopStep belong to.
+func (r *Recorder) PushStep(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.steps = append(r.steps, fmt.Sprintf(format, args...))
+}
+
+// PopStep ends the current step.
+func (r *Recorder) PopStep() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.steps) > 0 {
+		r.steps = r.steps[:len(r.steps)-1]
+	}
+}
+
+// Record records an action of the given kind in the current step.
+func (r *Recorder) Record(kind ActionKind, format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if kind == IndeterminateAction {
+		r.indeterminate = true
+	}
+	r.actions = append(r.actions, Action{
+		Step:        strings.Join(r.steps, ": "),
+		Kind:        kind,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// Indeterminate marks the plan as indeterminate from this point on, for the
+// given reason.
+func (r *Recorder) Indeterminate(format string, args ...interface{}) {
+	r.Record(IndeterminateAction, format, args...)
+}
+
+// IsIndeterminate returns whether the plan was marked as indeterminate.
+func (r *Recorder) IsIndeterminate() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.indeterminate
+}
+
+// Actions returns the recorded actions, in the order they were recorded.
+func (r *Recorder) Actions() []Action {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Action(nil), r.actions...)
+}
+
+// WritePlan writes the recorded actions to the given writer, one per line,
+// under a heading for each step.
+func (r *Recorder) WritePlan(w io.Writer) {
+	step := ""
+	for _, action := range r.Actions() {
+		if action.Step != step {
+			step = action.Step
+			fmt.Fprintf(w, "%s:\n", step)
+		}
+		indent := ""
+		if step != "" {
+			indent = "  "
+		}
+		if action.Kind == IndeterminateAction {
+			fmt.Fprintf(w, "%s?? the plan is indeterminate from here: %s\n", indent, action.Description)
+			continue
+		}
+		fmt.Fprintf(w, "%s%-8s %s\n", indent, action.Kind, action.Description)
+	}
+}
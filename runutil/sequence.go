@@ -118,6 +118,20 @@ func NewSequence(env map[string]string, stdin io.Reader, stdout, stderr io.Write
 	return s
 }
 
+// Record arranges for all further calls to the methods of the sequence to be
+// recorded by the given Recorder, instead of running commands and changing
+// the file system; see Recorder for details.
+func (s Sequence) Record(r *Recorder) Sequence {
+	s.r.recorder = r
+	return s
+}
+
+// Recorder returns the Recorder of the sequence, or nil if its actions are
+// performed rather than recorded.
+func (s Sequence) Recorder() *Recorder {
+	return s.r.recorder
+}
+
 // RunOpts returns the value of verbose that was used to
 // create this sequence.
 func (s Sequence) RunOpts() (verbose bool) {
@@ -501,11 +515,26 @@ func fmtStringArgs(args ...string) string {
 	return out.String()
 }
 
+// recordCapture marks the plan of the recorder of the sequence, if any, as
+// indeterminate when the output of the next command is captured, since the
+// caller may act on the output, which a recorded command does not produce.
+// Output that is discarded or passed through to a file is not acted on.
+func (s Sequence) recordCapture(path string) {
+	if s.r.recorder == nil || s.stdout == nil || s.stdout == ioutil.Discard {
+		return
+	}
+	if _, ok := s.stdout.(*os.File); ok {
+		return
+	}
+	s.r.recorder.Indeterminate("the output of %s is not known", filepath.Base(path))
+}
+
 // Run runs the given command as a subprocess.
 func (s Sequence) Run(path string, args ...string) Sequence {
 	if s.err != nil {
 		return s
 	}
+	s.recordCapture(path)
 	defer s.initAndDefer(nil)()
 	s.setError(s.r.run(s.timeout, s.getOpts(), path, args...), fmt.Sprintf("Run(%q%s)", path, fmtStringArgs(args...)))
 	return s
@@ -519,6 +548,7 @@ func (s Sequence) Last(path string, args ...string) error {
 		return s.Done()
 	}
 	defer s.Done()
+	s.recordCapture(path)
 	defer s.initAndDefer(nil)()
 	s.setError(s.r.run(s.timeout, s.getOpts(), path, args...), fmt.Sprintf("Last(%q%s)", path, fmtStringArgs(args...)))
 	return s.Error()
@@ -651,7 +681,7 @@ func (s Sequence) Pushd(dir string) Sequence {
 		return s
 	}
 	s.dirs = append(s.dirs, cwd)
-	err = s.r.call(func() error {
+	err = s.r.change(func() error {
 		return os.Chdir(dir)
 	}, fmt.Sprintf("pushd %q", dir))
 	s.setError(err, "Pushd("+dir+")")
@@ -671,7 +701,7 @@ func (s Sequence) Popd() Sequence {
 	}
 	last := s.dirs[len(s.dirs)-1]
 	s.dirs = s.dirs[:len(s.dirs)-1]
-	err := s.r.call(func() error {
+	err := s.r.change(func() error {
 		return os.Chdir(last)
 	}, fmt.Sprintf("popd %q", last))
 	s.setError(err, "Popd() -> "+last)
@@ -684,7 +714,7 @@ func (s Sequence) Chdir(dir string) Sequence {
 	if s.err != nil {
 		return s
 	}
-	err := s.r.call(func() error {
+	err := s.r.change(func() error {
 		return os.Chdir(dir)
 	}, fmt.Sprintf("cd %q", dir))
 	s.setError(err, "Chdir("+dir+")")
@@ -698,7 +728,7 @@ func (s Sequence) Chmod(dir string, mode os.FileMode) Sequence {
 	if s.err != nil {
 		return s
 	}
-	err := s.r.call(func() error { return os.Chmod(dir, mode) }, fmt.Sprintf("chmod %v %q", mode, dir))
+	err := s.r.change(func() error { return os.Chmod(dir, mode) }, fmt.Sprintf("chmod %v %q", mode, dir))
 	s.setError(err, fmt.Sprintf("Chmod(%s, %s)", dir, mode))
 	return s
 
@@ -710,7 +740,7 @@ func (s Sequence) MkdirAll(dir string, mode os.FileMode) Sequence {
 	if s.err != nil {
 		return s
 	}
-	err := s.r.call(func() error { return os.MkdirAll(dir, mode) }, fmt.Sprintf("mkdir -p %q", dir))
+	err := s.r.change(func() error { return os.MkdirAll(dir, mode) }, fmt.Sprintf("mkdir -p %q", dir))
 	s.setError(err, fmt.Sprintf("MkdirAll(%s, %s)", dir, mode))
 	return s
 }
@@ -721,7 +751,7 @@ func (s Sequence) RemoveAll(dir string) Sequence {
 	if s.err != nil {
 		return s
 	}
-	err := s.r.call(func() error { return os.RemoveAll(dir) }, fmt.Sprintf("rm -rf %q", dir))
+	err := s.r.change(func() error { return os.RemoveAll(dir) }, fmt.Sprintf("rm -rf %q", dir))
 	s.setError(err, fmt.Sprintf("RemoveAll(%s)", dir))
 	return s
 }
@@ -732,7 +762,7 @@ func (s Sequence) Remove(file string) Sequence {
 	if s.err != nil {
 		return s
 	}
-	err := s.r.call(func() error { return os.Remove(file) }, fmt.Sprintf("rm %q", file))
+	err := s.r.change(func() error { return os.Remove(file) }, fmt.Sprintf("rm %q", file))
 	s.setError(err, fmt.Sprintf("Remove(%s)", file))
 	return s
 }
@@ -743,22 +773,16 @@ func (s Sequence) Rename(src, dst string) Sequence {
 	if s.err != nil {
 		return s
 	}
-	err := s.r.call(func() error {
+	err := s.r.change(func() error {
 		if err := os.Rename(src, dst); err != nil {
 			// Check if the rename operation failed
 			// because the source and destination are
 			// located on different mount points.
-			linkErr, ok := err.(*os.LinkError)
-			if !ok {
-				return err
-			}
-			errno, ok := linkErr.Err.(syscall.Errno)
-			if !ok || errno != syscall.EXDEV {
+			if !isCrossDevice(err) {
 				return err
 			}
 			// Fall back to a non-atomic rename.
-			cmd := exec.Command("mv", src, dst)
-			return cmd.Run()
+			return moveCommand(src, dst).Run()
 		}
 		return nil
 	}, fmt.Sprintf("mv %q %q", src, dst))
@@ -773,7 +797,7 @@ func (s Sequence) Symlink(src, dst string) Sequence {
 	if s.err != nil {
 		return s
 	}
-	err := s.r.call(func() error { return os.Symlink(src, dst) }, fmt.Sprintf("ln -s %q %q", src, dst))
+	err := s.r.change(func() error { return os.Symlink(src, dst) }, fmt.Sprintf("ln -s %q %q", src, dst))
 	s.setError(err, fmt.Sprintf("Symlink(%s, %s)", src, dst))
 	return s
 }
@@ -799,10 +823,15 @@ func (s Sequence) OpenFile(name string, flag int, perm os.FileMode) (f *os.File,
 	if s.err != nil {
 		return nil, s.Done()
 	}
-	s.r.call(func() error {
-		f, err = os.OpenFile(name, flag, perm)
-		return err
-	}, fmt.Sprintf("open file %q", name))
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 && s.r.recorder != nil {
+		s.r.recorder.Record(FileAction, "write %q", name)
+		f, err = openDevNull()
+	} else {
+		s.r.call(func() error {
+			f, err = os.OpenFile(name, flag, perm)
+			return err
+		}, fmt.Sprintf("open file %q", name))
+	}
 	s.setError(err, fmt.Sprintf("OpenFile(%s, 0x%x, %s)", name, flag, perm))
 	err = s.Done()
 	return
@@ -814,15 +843,26 @@ func (s Sequence) Create(name string) (f *os.File, err error) {
 	if s.err != nil {
 		return nil, s.Done()
 	}
-	s.r.call(func() error {
-		f, err = os.Create(name)
-		return err
-	}, fmt.Sprintf("create %q", name))
+	if s.r.recorder != nil {
+		s.r.recorder.Record(FileAction, "create %q", name)
+		f, err = openDevNull()
+	} else {
+		s.r.call(func() error {
+			f, err = os.Create(name)
+			return err
+		}, fmt.Sprintf("create %q", name))
+	}
 	s.setError(err, fmt.Sprintf("Create(%s)", name))
 	err = s.Done()
 	return
 }
 
+// openDevNull opens os.DevNull for writing, in place of the files that
+// recording sequences would create or write.
+func openDevNull() (*os.File, error) {
+	return os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+}
+
 // ReadDir is a wrapper around ioutil.ReadDir that handles options
 // such as "verbose". ReadDir is a terminating function.
 func (s Sequence) ReadDir(dirname string) (fi []os.FileInfo, err error) {
@@ -860,7 +900,7 @@ func (s Sequence) WriteFile(filename string, data []byte, perm os.FileMode) Sequ
 	if s.err != nil {
 		return s
 	}
-	err := s.r.call(func() error {
+	err := s.r.change(func() error {
 		return ioutil.WriteFile(filename, data, perm)
 	}, fmt.Sprintf("write %q", filename))
 	s.setError(err, fmt.Sprintf("WriteFile(%s, %.10s,  %s)", filename, data, perm))
@@ -937,7 +977,7 @@ func (s Sequence) TempDir(dir, prefix string) (tmpDir string, err error) {
 		dir = os.Getenv("TMPDIR")
 	}
 	tmpDir = filepath.Join(dir, prefix+"XXXXXX")
-	s.r.call(func() error {
+	s.r.change(func() error {
 		tmpDir, err = ioutil.TempDir(dir, prefix)
 		return err
 	}, fmt.Sprintf("mkdir -p %q", tmpDir))
@@ -955,10 +995,15 @@ func (s Sequence) TempFile(dir, prefix string) (f *os.File, err error) {
 	if dir == "" {
 		dir = os.Getenv("TMPDIR")
 	}
-	s.r.call(func() error {
-		f, err = ioutil.TempFile(dir, prefix)
-		return err
-	}, fmt.Sprintf("tempFile %q %q", dir, prefix))
+	if s.r.recorder != nil {
+		s.r.recorder.Record(FileAction, "tempFile %q %q", dir, prefix)
+		f, err = openDevNull()
+	} else {
+		s.r.call(func() error {
+			f, err = ioutil.TempFile(dir, prefix)
+			return err
+		}, fmt.Sprintf("tempFile %q %q", dir, prefix))
+	}
 	s.setError(err, fmt.Sprintf("TempFile(%s,%s)", dir, prefix))
 	err = s.Done()
 	return
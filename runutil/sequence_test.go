@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"syscall"
@@ -655,3 +656,63 @@ func TestStartWithOutput(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+func TestSequenceRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rec := runutil.NewRecorder()
+	s := runutil.NewSequence(nil, os.Stdin, os.Stdout, os.Stderr, false, false).Record(rec)
+	newDir, file := filepath.Join(dir, "new"), filepath.Join(dir, "file")
+	install := func() error {
+		return s.MkdirAll(newDir, 0755).
+			WriteFile(file, []byte("hello"), 0644).
+			Dir(newDir).Run("sh", "-c", "exit 1").
+			Done()
+	}
+	if err := s.Call(install, "install").Done(); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing was changed or run, and reads are still performed.
+	if _, err := os.Stat(newDir); !os.IsNotExist(err) {
+		t.Errorf("%s: got %v, want it not to exist", newDir, err)
+	}
+	if _, err := s.ReadDir(dir); err != nil {
+		t.Error(err)
+	}
+	if rec.IsIndeterminate() {
+		t.Errorf("got an indeterminate plan, want a determinate one")
+	}
+	// The path of the command is resolved using $PATH.
+	got := rec.Actions()
+	if len(got) == 3 {
+		got[2].Description = regexp.MustCompile(`&& \S*sh `).ReplaceAllString(got[2].Description, "&& sh ")
+	}
+	want := []runutil.Action{
+		{"install", runutil.FileAction, fmt.Sprintf("mkdir -p %q", newDir)},
+		{"install", runutil.FileAction, fmt.Sprintf("write %q", file)},
+		{"install", runutil.CommandAction, fmt.Sprintf(`(cd %q && sh -c "exit 1")`, newDir)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Capturing the output of a command makes the plan indeterminate.
+	var out bytes.Buffer
+	if err := s.Capture(&out, nil).Last("echo", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), ""; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !rec.IsIndeterminate() {
+		t.Errorf("got a determinate plan, want an indeterminate one")
+	}
+	// Started commands fail, rather than returning a handle that can't
+	// be waited for.
+	if _, err := s.Start("sleep", "1"); err == nil {
+		t.Errorf("Start succeeded, want it to fail")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"v.io/x/lib/timing"
+)
+
+// Timer categories label the major phases of jiri commands in the timer tree,
+// so that the phases can be compared across runs regardless of the names of
+// the individual intervals.
+const (
+	TimerLoadManifest   = "load manifest"
+	TimerScanFS         = "scan fs"
+	TimerUpdateProjects = "update projects"
+	TimerBuildTools     = "build tools"
+	TimerInstallTools   = "install tools"
+)
+
+// timerCategories maps timers to the categories of their intervals, indexed
+// like timing.Timer.Intervals.  It is global rather than part of X, since the
+// timer outlives the X of a command, and is shared by an X and its clones.
+var timerCategories = struct {
+	mu         sync.Mutex
+	categories map[*timing.Timer]map[int]string
+}{categories: map[*timing.Timer]map[int]string{}}
+
+// TimerPushCategory is like TimerPush, but also labels the new interval with
+// the given category.  Intervals nested in the new interval inherit the
+// category, unless they have their own.
+func (x *X) TimerPushCategory(category, name string) {
+	timer := x.Timer()
+	if timer == nil {
+		return
+	}
+	timerCategories.mu.Lock()
+	defer timerCategories.mu.Unlock()
+	categories := timerCategories.categories[timer]
+	if categories == nil {
+		categories = map[int]string{}
+		timerCategories.categories[timer] = categories
+	}
+	categories[len(timer.Intervals)] = category
+	timer.Push(name)
+}
+
+// TimerNode is a node of the tree of intervals of a timer.
+type TimerNode struct {
+	Name     string        `json:"name"`
+	Category string        `json:"category,omitempty"`
+	Depth    int           `json:"depth"`
+	Start    time.Duration `json:"startNs"`
+	Duration time.Duration `json:"durationNs"`
+	Children []*TimerNode  `json:"children,omitempty"`
+}
+
+// TimerTree returns the tree of intervals of the given timer.  The start of
+// each interval is relative to the start of the timer.  Intervals that are
+// still open end now.
+func TimerTree(timer *timing.Timer) *TimerNode {
+	timerCategories.mu.Lock()
+	categories := timerCategories.categories[timer]
+	timerCategories.mu.Unlock()
+	now := timer.Now()
+	// The intervals are in depth-first order, so the parent of each interval
+	// is the last interval seen at the depth above it.
+	var root *TimerNode
+	var parents []*TimerNode
+	for i, interval := range timer.Intervals {
+		end := interval.End
+		if end == timing.InvalidDuration {
+			end = now
+		}
+		node := &TimerNode{
+			Name:     interval.Name,
+			Category: categories[i],
+			Depth:    interval.Depth,
+			Start:    interval.Start,
+			Duration: end - interval.Start,
+		}
+		parents = append(parents[:interval.Depth], node)
+		if interval.Depth == 0 {
+			root = node
+			continue
+		}
+		parent := parents[interval.Depth-1]
+		if node.Category == "" {
+			node.Category = parent.Category
+		}
+		parent.Children = append(parent.Children, node)
+	}
+	return root
+}
+
+// WriteTimerJSON writes the tree of intervals of the given timer to w, as
+// JSON.
+func WriteTimerJSON(w io.Writer, timer *timing.Timer) error {
+	data, err := json.MarshalIndent(TimerTree(timer), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
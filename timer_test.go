@@ -0,0 +1,70 @@
+// Copyright 2016 The Vanadium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"v.io/jiri/tool"
+	"v.io/x/lib/timing"
+)
+
+// TestTimerTree checks that TimerTree returns the nesting of the intervals of
+// a timer, and that intervals inherit the category of their parent.
+func TestTimerTree(t *testing.T) {
+	timer := timing.NewTimer("jiri")
+	x := &X{Context: tool.NewContext(tool.ContextOpts{Timer: timer})}
+	x.TimerPush("update universe")
+	x.TimerPushCategory(TimerLoadManifest, "load manifest")
+	x.TimerPush("load .jiri_manifest")
+	x.TimerPop()
+	x.TimerPop()
+	x.TimerPushCategory(TimerUpdateProjects, "update projects")
+	x.TimerPop()
+	x.TimerPop()
+	timer.Finish()
+
+	type node struct {
+		name, category string
+		depth          int
+	}
+	var got []node
+	var walk func(n *TimerNode)
+	walk = func(n *TimerNode) {
+		got = append(got, node{n.Name, n.Category, n.Depth})
+		if n.Duration < 0 {
+			t.Errorf("%s: got negative duration %v", n.Name, n.Duration)
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(TimerTree(timer))
+	want := []node{
+		{"jiri", "", 0},
+		{"update universe", "", 1},
+		{"load manifest", TimerLoadManifest, 2},
+		{"load .jiri_manifest", TimerLoadManifest, 3},
+		{"update projects", TimerUpdateProjects, 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTimerJSON(&buf, timer); err != nil {
+		t.Fatal(err)
+	}
+	var root TimerNode
+	if err := json.Unmarshal(buf.Bytes(), &root); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if !reflect.DeepEqual(&root, TimerTree(timer)) {
+		t.Errorf("got %+v, want %+v", root, TimerTree(timer))
+	}
+}
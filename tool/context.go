@@ -34,6 +34,9 @@ type ContextOpts struct {
 	Stderr   io.Writer
 	Verbose  *bool
 	Timer    *timing.Timer
+	// Recorder, if set, records the commands and file system changes of
+	// the sequences of the context instead of performing them.
+	Recorder *runutil.Recorder
 }
 
 // newContextOpts is the ContextOpts factory.
@@ -76,6 +79,9 @@ func initOpts(defaultOpts, opts *ContextOpts) {
 	if opts.Timer == nil {
 		opts.Timer = defaultOpts.Timer
 	}
+	if opts.Recorder == nil {
+		opts.Recorder = defaultOpts.Recorder
+	}
 }
 
 // NewContext is the Context factory.
@@ -138,7 +144,11 @@ func (ctx Context) Manifest() string {
 // NewSeq returns a new instance of Sequence initialized using the options
 // stored in the context.
 func (ctx Context) NewSeq() runutil.Sequence {
-	return runutil.NewSequence(ctx.opts.Env, ctx.opts.Stdin, ctx.opts.Stdout, ctx.opts.Stderr, *ctx.opts.Color, *ctx.opts.Verbose)
+	s := runutil.NewSequence(ctx.opts.Env, ctx.opts.Stdin, ctx.opts.Stdout, ctx.opts.Stderr, *ctx.opts.Color, *ctx.opts.Verbose)
+	if ctx.opts.Recorder != nil {
+		s = s.Record(ctx.opts.Recorder)
+	}
+	return s
 }
 
 // Stdin returns the standard input of the context.
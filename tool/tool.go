@@ -20,7 +20,8 @@ var (
 	VerboseFlag bool
 
 	// Flags for working with projects.
-	ManifestFlag string
+	ManifestFlag        string
+	AllowNestedRootFlag bool
 )
 
 // InitializeRunFlags initializes flags for running commands.
@@ -29,7 +30,8 @@ func InitializeRunFlags(flags *flag.FlagSet) {
 	flags.BoolVar(&VerboseFlag, "v", false, "Print verbose output.")
 }
 
-// InitializeRunFlags initializes flags for working with projects.
+// InitializeProjectFlags initializes flags for working with projects.
 func InitializeProjectFlags(flags *flag.FlagSet) {
-	flags.StringVar(&ManifestFlag, "manifest", "", "Name of the project manifest.")
+	flags.StringVar(&ManifestFlag, "manifest", "", "Manifest file to load instead of $JIRI_ROOT/.jiri_manifest, relative to $JIRI_ROOT.")
+	flags.BoolVar(&AllowNestedRootFlag, "allow-nested-root", false, "Allow $JIRI_ROOT to be nested inside another jiri root or a project.")
 }
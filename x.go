@@ -10,8 +10,10 @@ package jiri
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"v.io/jiri/tool"
 	"v.io/x/lib/cmdline"
@@ -41,18 +43,51 @@ const (
 // including the manifest and related operations.
 type X struct {
 	*tool.Context
-	Root  string
-	Usage func(format string, args ...interface{}) error
+	Root     string
+	Usage    func(format string, args ...interface{}) error
+	cleanups *cleanups
+	// cleanupsOnce guards the creation of cleanups, since an X may be
+	// created without it, e.g. by other packages, and used concurrently.
+	cleanupsOnce sync.Once
 }
 
 // NewX returns a new execution environment, given a cmdline env.
-// It also prepends $JIRI_ROOT/.jiri_root/bin to the PATH.
+// It also prepends $JIRI_ROOT/.jiri_root/bin to the PATH.  If $JIRI_ROOT is
+// not set, the root is found by walking up from the current directory, as
+// described for FindRoot, and $JIRI_ROOT is set to it in the environment of
+// the commands run by the returned X.
 func NewX(env *cmdline.Env) (*X, error) {
 	ctx := tool.NewContextFromEnv(env)
-	root, err := findJiriRoot(ctx.Timer())
+	root, source, err := findJiriRoot(ctx.Timer(), ctx.Stderr())
 	if err != nil {
 		return nil, err
 	}
+	if source != rootFromEnv {
+		ctx.Env()[RootEnv] = root
+	}
+	return newX(env, ctx, root)
+}
+
+// NewXWithRoot is like NewX, but uses the given root directory, which need not
+// exist yet, rather than $JIRI_ROOT, which need not be set.  It is used by
+// commands that create a new root.  $JIRI_ROOT is set to the root in the
+// environment of the commands run by the returned X.
+func NewXWithRoot(env *cmdline.Env, root string) (*X, error) {
+	ctx := tool.NewContextFromEnv(env)
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Env()[RootEnv] = root
+	return newX(env, ctx, root)
+}
+
+func newX(env *cmdline.Env, ctx *tool.Context, root string) (*X, error) {
+	if !tool.AllowNestedRootFlag {
+		if err := checkNestedRoot(root); err != nil {
+			return nil, err
+		}
+	}
 	x := &X{
 		Context: ctx,
 		Root:    root,
@@ -76,24 +111,83 @@ func NewX(env *cmdline.Env) (*X, error) {
 	return x, nil
 }
 
-func findJiriRoot(timer *timing.Timer) (string, error) {
+// checkNestedRoot returns an error if the given root is nested inside another
+// jiri root or inside a project, whose root would scan and update the projects
+// of the nested root as its own.
+func checkNestedRoot(root string) error {
+	for dir := filepath.Dir(root); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, RootMetaDir)); err == nil {
+			return fmt.Errorf("jiri root %v is nested inside the jiri root %v, which would treat its projects as its own; move it elsewhere, or use -allow-nested-root if this is intended", root, dir)
+		}
+		if _, err := os.Stat(filepath.Join(dir, ProjectMetaDir)); err == nil {
+			return fmt.Errorf("jiri root %v is nested inside the project %v, whose jiri root would treat its projects as its own; move it elsewhere, or use -allow-nested-root if this is intended", root, dir)
+		}
+		if filepath.Dir(dir) == dir {
+			return nil
+		}
+	}
+}
+
+// Descriptions of how findJiriRoot determined the root directory.
+const (
+	rootFromEnv = "from $" + RootEnv
+	rootFromDir = "from the " + RootMetaDir + " directory above the current directory"
+)
+
+// findJiriRoot returns the root directory of the jiri environment, and a
+// description of how it was determined.  If $JIRI_ROOT is set, it is used,
+// and a warning is printed to the given writer, if any, if the current
+// directory is in a different root.  Otherwise, the root is the closest
+// directory containing a .jiri_root directory, walking up from the current
+// directory.
+func findJiriRoot(timer *timing.Timer, warnings io.Writer) (string, string, error) {
 	if timer != nil {
 		timer.Push("find JIRI_ROOT")
 		defer timer.Pop()
 	}
+	// The current directory is used to find the root if $JIRI_ROOT is not
+	// set, and to check that it agrees with $JIRI_ROOT otherwise.
+	wd, wdErr := os.Getwd()
 	if root := os.Getenv(RootEnv); root != "" {
 		// Always use JIRI_ROOT if it's set.
 		result, err := filepath.EvalSymlinks(root)
 		if err != nil {
-			return "", fmt.Errorf("%v EvalSymlinks(%v) failed: %v", RootEnv, root, err)
+			return "", "", fmt.Errorf("%v EvalSymlinks(%v) failed: %v", RootEnv, root, err)
 		}
 		if !filepath.IsAbs(result) {
-			return "", fmt.Errorf("%v isn't an absolute path: %v", RootEnv, result)
+			return "", "", fmt.Errorf("%v isn't an absolute path: %v", RootEnv, result)
+		}
+		result = filepath.Clean(result)
+		if dir := rootAbove(wd); warnings != nil && wdErr == nil && dir != "" && dir != result {
+			fmt.Fprintf(warnings, "WARNING: using the jiri root %v from $%v, although the current directory is in the jiri root %v\n", result, RootEnv, dir)
+		}
+		return result, rootFromEnv, nil
+	}
+	if wdErr != nil {
+		return "", "", fmt.Errorf("%v is not set, and Getwd() failed: %v", RootEnv, wdErr)
+	}
+	if dir := rootAbove(wd); dir != "" {
+		return dir, rootFromDir, nil
+	}
+	return "", "", fmt.Errorf("%v is not set, and no %v directory was found in %v or above it", RootEnv, RootMetaDir, wd)
+}
+
+// rootAbove returns the closest directory containing a .jiri_root directory,
+// walking up from the given directory, with its symlinks evaluated, or an
+// empty string if there is none.
+func rootAbove(dir string) string {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return ""
+	}
+	for ; ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(filepath.Join(dir, RootMetaDir)); err == nil && info.IsDir() {
+			return dir
+		}
+		if filepath.Dir(dir) == dir {
+			return ""
 		}
-		return filepath.Clean(result), nil
 	}
-	// TODO(toddw): Try to find the root by walking up the filesystem.
-	return "", fmt.Errorf("%v is not set", RootEnv)
 }
 
 // FindRoot returns the root directory of the jiri environment.  All state
@@ -101,7 +195,8 @@ func findJiriRoot(timer *timing.Timer) (string, error) {
 //
 // If the RootEnv environment variable is non-empty, we always attempt to use
 // it.  It must point to an absolute path, after symlinks are evaluated.
-// TODO(toddw): Walk up the filesystem too.
+// Otherwise, the root is the closest directory containing a .jiri_root
+// directory, walking up from the current directory.
 //
 // Returns an empty string if the root directory cannot be determined, or if any
 // errors are encountered.
@@ -110,16 +205,24 @@ func findJiriRoot(timer *timing.Timer) (string, error) {
 // execution environment, and handle errors.  An example of a valid usage is to
 // initialize default flag values in an init func before main.
 func FindRoot() string {
-	root, _ := findJiriRoot(nil)
+	root, _, _ := findJiriRoot(nil, nil)
 	return root
 }
 
+// FindRootSource is like FindRoot, but also returns a description of how the
+// root directory was determined, e.g. "from $JIRI_ROOT", or the reason why it
+// could not be determined.
+func FindRootSource() (string, string, error) {
+	return findJiriRoot(nil, nil)
+}
+
 // Clone returns a clone of the environment.
 func (x *X) Clone(opts tool.ContextOpts) *X {
 	return &X{
-		Context: x.Context.Clone(opts),
-		Root:    x.Root,
-		Usage:   x.Usage,
+		Context:  x.Context.Clone(opts),
+		Root:     x.Root,
+		Usage:    x.Usage,
+		cleanups: x.registry(),
 	}
 }
 
@@ -138,8 +241,16 @@ func (x *X) RootMetaDir() string {
 	return filepath.Join(x.Root, RootMetaDir)
 }
 
-// JiriManifestFile returns the path to the .jiri_manifest file.
+// JiriManifestFile returns the path to the .jiri_manifest file, or to the
+// manifest file given by the -manifest flag, which is relative to the root
+// unless it is absolute.
 func (x *X) JiriManifestFile() string {
+	if manifest := x.Manifest(); manifest != "" {
+		if filepath.IsAbs(manifest) {
+			return manifest
+		}
+		return filepath.Join(x.Root, manifest)
+	}
 	return filepath.Join(x.Root, JiriManifestFile)
 }
 
@@ -148,16 +259,72 @@ func (x *X) BinDir() string {
 	return filepath.Join(x.RootMetaDir(), "bin")
 }
 
+// PreviousBinDir returns the path to the directory holding the tools that
+// were installed in the bin directory before the latest installation.
+func (x *X) PreviousBinDir() string {
+	return x.BinDir() + ".previous"
+}
+
 // ScriptsDir returns the path to the scripts directory.
 func (x *X) ScriptsDir() string {
 	return filepath.Join(x.RootMetaDir(), "scripts")
 }
 
+// ScanIgnoreFile returns the path to the file listing directories that are
+// not scanned for local projects.
+func (x *X) ScanIgnoreFile() string {
+	return filepath.Join(x.RootMetaDir(), "scan-ignore")
+}
+
+// GroupsFile returns the path to the file listing the enabled project groups.
+func (x *X) GroupsFile() string {
+	return filepath.Join(x.RootMetaDir(), "groups")
+}
+
+// ManifestLockFile returns the path to the file recording the revisions of
+// the manifest projects that the remote imports were loaded at by the latest
+// update.
+func (x *X) ManifestLockFile() string {
+	return filepath.Join(x.RootMetaDir(), "manifest.lock")
+}
+
 // UpdateHistoryDir returns the path to the update history directory.
 func (x *X) UpdateHistoryDir() string {
 	return filepath.Join(x.RootMetaDir(), "update_history")
 }
 
+// RemoteCacheDir returns the path to the directory that the responses of
+// googlesource hosts are cached in by default.
+func (x *X) RemoteCacheDir() string {
+	return filepath.Join(x.RootMetaDir(), "cache", "googlesource")
+}
+
+// KeysDir returns the path to the directory of the files listing the
+// fingerprints of the keys trusted to sign the revisions of projects that
+// require signed revisions.
+func (x *X) KeysDir() string {
+	return filepath.Join(x.RootMetaDir(), "keys")
+}
+
+// GenerationFile returns the path to the file whose contents change whenever
+// the local projects are updated, so that caches of their states, such as
+// that of "jiri server", can be invalidated.
+func (x *X) GenerationFile() string {
+	return filepath.Join(x.RootMetaDir(), "generation")
+}
+
+// ServerSocket returns the path to the unix domain socket that "jiri server"
+// listens on.
+func (x *X) ServerSocket() string {
+	return filepath.Join(x.RootMetaDir(), "server.sock")
+}
+
+// TestConfigFile returns the path to the file configuring "jiri test", e.g.
+// the packages and tests it excludes.
+func (x *X) TestConfigFile() string {
+	return filepath.Join(x.RootMetaDir(), "test_config.json")
+}
+
 // ProfilesDBDir returns the path to the profiles data base directory.
 func (x *X) ProfilesDBDir() string {
 	return filepath.Join(x.RootMetaDir(), "profile_db")
@@ -187,6 +354,25 @@ func RunnerFunc(run func(*X, []string) error) cmdline.Runner {
 	return runner(run)
 }
 
+// RootRunnerFunc is like RunnerFunc, but the root directory of the X passed to
+// run is returned by root, given the command-line arguments, rather than read
+// from $JIRI_ROOT, which need not be set.  It is used by commands that create
+// a new root, such as "jiri bootstrap".
+func RootRunnerFunc(root func(*cmdline.Env, []string) (string, error), run func(*X, []string) error) cmdline.Runner {
+	return cmdline.RunnerFunc(func(env *cmdline.Env, args []string) error {
+		dir, err := root(env, args)
+		if err != nil {
+			return err
+		}
+		x, err := NewXWithRoot(env, dir)
+		if err != nil {
+			return err
+		}
+		defer x.handleSignals().finish()
+		return run(x, args)
+	})
+}
+
 type runner func(*X, []string) error
 
 func (r runner) Run(env *cmdline.Env, args []string) error {
@@ -194,5 +380,6 @@ func (r runner) Run(env *cmdline.Env, args []string) error {
 	if err != nil {
 		return err
 	}
+	defer x.handleSignals().finish()
 	return r(x, args)
 }
@@ -5,8 +5,11 @@
 package jiri
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"v.io/jiri/tool"
@@ -50,3 +53,123 @@ func TestFindRootEnvSymlink(t *testing.T) {
 		t.Fatalf("unexpected output: got %v, want %v", got, want)
 	}
 }
+
+// TestCheckNestedRoot checks that jiri roots nested inside another jiri root
+// or inside a project are rejected, naming the enclosing directory.
+func TestCheckNestedRoot(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	for _, dir := range []string{
+		filepath.Join("outer", RootMetaDir),
+		filepath.Join("outer", "src", "inner"),
+		filepath.Join("project", ProjectMetaDir),
+		filepath.Join("project", "inner"),
+		filepath.Join("alone", "root"),
+	} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+	}
+	tests := []struct {
+		root, want string
+	}{
+		{"outer/src/inner", "nested inside the jiri root " + filepath.Join(tmpDir, "outer")},
+		{"project/inner", "nested inside the project " + filepath.Join(tmpDir, "project")},
+		{"alone/root", ""},
+		{"outer", ""},
+	}
+	for _, test := range tests {
+		err := checkNestedRoot(filepath.Join(tmpDir, filepath.FromSlash(test.root)))
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%v: got error %v, want none", test.root, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%v: got error %v, want it to contain %q", test.root, err, test.want)
+		}
+	}
+}
+
+// TestFindRootWalk checks that findJiriRoot finds the root by walking up from
+// the current directory if $JIRI_ROOT is not set, and that it prefers
+// $JIRI_ROOT with a warning if the current directory is in another root.
+func TestFindRootWalk(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	if err != nil {
+		t.Fatalf("EvalSymlinks(%v) failed: %v", tmpDir, err)
+	}
+	for _, dir := range []string{
+		filepath.Join("root", RootMetaDir),
+		filepath.Join("root", "project", ProjectMetaDir),
+		filepath.Join("root", "project", "src", "nested"),
+		filepath.Join("other", RootMetaDir),
+		"outside",
+	} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd() failed: %v", err)
+	}
+	defer os.Chdir(wd)
+	oldRoot := os.Getenv(RootEnv)
+	defer os.Setenv(RootEnv, oldRoot)
+
+	tests := []struct {
+		env, dir         string
+		root, source     string
+		warning, failure string
+	}{
+		// Discovery from a directory nested in a project of the root.
+		{"", "root/project/src/nested", "root", rootFromDir, "", ""},
+		{"", "root", "root", rootFromDir, "", ""},
+		// Discovery from outside any root fails.
+		{"", "outside", "", "", "", "no " + RootMetaDir + " directory was found"},
+		// $JIRI_ROOT is preferred to the root of the current directory.
+		{"other", "outside", "other", rootFromEnv, "", ""},
+		{"other", "other", "other", rootFromEnv, "", ""},
+		{"other", "root/project", "other", rootFromEnv, "although the current directory is in the jiri root " + filepath.Join(tmpDir, "root"), ""},
+	}
+	for _, test := range tests {
+		env := ""
+		if test.env != "" {
+			env = filepath.Join(tmpDir, test.env)
+		}
+		if err := os.Setenv(RootEnv, env); err != nil {
+			t.Fatalf("Setenv() failed: %v", err)
+		}
+		if err := os.Chdir(filepath.Join(tmpDir, filepath.FromSlash(test.dir))); err != nil {
+			t.Fatalf("Chdir() failed: %v", err)
+		}
+		var warnings bytes.Buffer
+		root, source, err := findJiriRoot(nil, &warnings)
+		if test.failure != "" {
+			if err == nil || !strings.Contains(err.Error(), test.failure) {
+				t.Errorf("%v: got error %v, want it to contain %q", test.dir, err, test.failure)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: findJiriRoot() failed: %v", test.dir, err)
+			continue
+		}
+		if got, want := root, filepath.Join(tmpDir, test.root); got != want {
+			t.Errorf("%v: got root %v, want %v", test.dir, got, want)
+		}
+		if got, want := source, test.source; got != want {
+			t.Errorf("%v: got source %q, want %q", test.dir, got, want)
+		}
+		if got := warnings.String(); (test.warning == "") != (got == "") || !strings.Contains(got, test.warning) {
+			t.Errorf("%v: got warning %q, want %q", test.dir, got, test.warning)
+		}
+	}
+}